@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// aclPrefix is where per-bucket ACL catalogs live in the system bucket.
+const aclPrefix = "acls/"
+
+// Object visibilities. Objects without an ACL keep the unrestricted access
+// they had before ACLs existed.
+const (
+	aclPrivate      = "private"
+	aclTenantShared = "tenant-shared"
+	aclPublicRead   = "public-read"
+)
+
+// aclMu serializes read-modify-write updates of ACL catalogs.
+var aclMu sync.Mutex
+
+type ObjectACL struct {
+	Visibility string    `json:"visibility" enum:"private,tenant-shared,public-read" doc:"Who may read the object: only its owner, any tenant, or anyone"`
+	Owner      string    `json:"owner,omitempty" doc:"Tenant that owns the object"`
+	UpdatedAt  time.Time `json:"updated_at" doc:"Last modification time"`
+}
+
+type ObjectACLRequest struct {
+	Visibility string `json:"visibility" enum:"private,tenant-shared,public-read" doc:"Who may read the object: only its owner, any tenant, or anyone"`
+	Owner      string `json:"owner,omitempty" doc:"Owning tenant; only admins may set it, tenants always own what they share"`
+}
+
+type ObjectACLResponse struct {
+	Bucket string    `json:"bucket" doc:"MinIO bucket name"`
+	Name   string    `json:"name" doc:"Object name"`
+	ACL    ObjectACL `json:"acl" doc:"The object's ACL"`
+}
+
+// aclCaller identifies who is asking for an object.
+type aclCaller struct {
+	tenant string
+	admin  bool
+}
+
+// objectCaller resolves the Authorization header to the admin, a storage
+// tenant or an anonymous caller.
+func objectCaller(authorization string) aclCaller {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if ok && config.Auth.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(config.Auth.AdminToken)) == 1 {
+		return aclCaller{admin: true}
+	}
+	if t, ok := storageTenant(authorization); ok {
+		return aclCaller{tenant: t.Name}
+	}
+	return aclCaller{}
+}
+
+// allows reports whether c may read an object with this ACL.
+func (a ObjectACL) allows(c aclCaller) bool {
+	switch {
+	case c.admin, a.Visibility == aclPublicRead:
+		return true
+	case a.Visibility == aclTenantShared:
+		return c.tenant != ""
+	default:
+		return c.tenant != "" && c.tenant == a.Owner
+	}
+}
+
+// checkACL returns an error unless c may read the object.
+func checkACL(acl ObjectACL, c aclCaller, bucket, name string) error {
+	if acl.allows(c) {
+		return nil
+	}
+	if !c.admin && c.tenant == "" {
+		return huma.Error401Unauthorized(fmt.Sprintf("Object %s in bucket %s requires a tenant token", name, bucket))
+	}
+	return huma.Error403Forbidden(fmt.Sprintf("Tenant %s may not read object %s in bucket %s", c.tenant, name, bucket))
+}
+
+// checkBucketAccess refuses the buckets holding the service's own state:
+// the system bucket, with the ACL catalogs, policies and sources, and the
+// transcripts bucket. Nobody reaches them through the files API, admins
+// included, since rewriting a catalog would bypass every ACL.
+func checkBucketAccess(bucket string) error {
+	if bucket == config.MinIO.SystemBucket || bucket == transcriptBucket() {
+		return huma.Error403Forbidden(fmt.Sprintf("Bucket %s holds internal state and cannot be accessed", bucket))
+	}
+	return nil
+}
+
+// checkObjectACL loads the ACL of an object and returns an error unless c
+// may read it. Objects without an ACL are readable by anyone.
+func checkObjectACL(ctx context.Context, bucket, name string, c aclCaller) error {
+	acls, err := loadACLs(ctx, bucket)
+	if err != nil {
+		return huma.Error500InternalServerError("Failed to load ACLs", err)
+	}
+	if acl, ok := acls[name]; ok {
+		return checkACL(acl, c, bucket, name)
+	}
+	return nil
+}
+
+func aclKey(bucket string) string {
+	return aclPrefix + bucket + ".json"
+}
+
+// loadACLs reads a bucket's ACL catalog, keyed by object name. Buckets
+// without a catalog have no ACLs.
+func loadACLs(ctx context.Context, bucket string) (map[string]ObjectACL, error) {
+	acls := map[string]ObjectACL{}
+	if err := getJSON(ctx, config.MinIO.SystemBucket, aclKey(bucket), &acls); err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	return acls, nil
+}
+
+// updateACLs applies fn to a bucket's ACL catalog and saves it if fn
+// reports a change.
+func updateACLs(ctx context.Context, bucket string, fn func(map[string]ObjectACL) bool) error {
+	aclMu.Lock()
+	defer aclMu.Unlock()
+
+	acls, err := loadACLs(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	if !fn(acls) {
+		return nil
+	}
+	if err := ensureBucket(ctx, config.MinIO.SystemBucket); err != nil {
+		return err
+	}
+	return putJSON(ctx, config.MinIO.SystemBucket, aclKey(bucket), acls)
+}
+
+// moveACL carries the ACL of src over to dst, or of every object under src
+// when src is a folder prefix, so moved objects keep their visibility.
+func moveACL(ctx context.Context, bucket, src, dst string) {
+	err := updateACLs(ctx, bucket, func(acls map[string]ObjectACL) bool {
+		changed := false
+		for key, acl := range acls {
+			var target string
+			switch {
+			case key == src:
+				target = dst
+			case strings.HasSuffix(src, "/") && strings.HasPrefix(key, src):
+				target = dst + strings.TrimPrefix(key, src)
+			default:
+				continue
+			}
+			delete(acls, key)
+			acls[target] = acl
+			changed = true
+		}
+		return changed
+	})
+	if err != nil {
+		log.Printf("Failed to move ACL of %s/%s to %s: %v", bucket, src, dst, err)
+	}
+}
+
+func registerACLEndpoints(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "download-file",
+		Method:      http.MethodGet,
+		Path:        "/files/{bucket}/{name}",
+		Summary:     "Download a file",
+		Description: "Stream an object's content, subject to the object's ACL. A single byte range can be requested with Range, so media players can seek",
+	}, func(ctx context.Context, input *struct {
+		Bucket        string `path:"bucket" doc:"MinIO bucket name"`
+		Name          string `path:"name" doc:"Object name (URL-encoded if it contains slashes)"`
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token; not needed for public objects"`
+		Range         string `header:"Range" doc:"Single byte range to return, e.g. bytes=0-1023; other ranges return the whole object"`
+		IfRange       string `header:"If-Range" doc:"ETag the range applies to; the whole object is returned if it changed"`
+	}) (*huma.StreamResponse, error) {
+		client, err := services.MinIOReader()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkBucketAccess(input.Bucket); err != nil {
+			return nil, err
+		}
+		name, err := decodeObjectKey(input.Name)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkObjectACL(ctx, input.Bucket, name, objectCaller(input.Authorization)); err != nil {
+			return nil, err
+		}
+
+		var obj io.ReadCloser
+		var info minio.ObjectInfo
+		var span *byteRange
+		if input.Range != "" {
+			// The range is resolved against the object's size, so ranged
+			// downloads take a HEAD request first
+			stat, err := client.StatObject(ctx, input.Bucket, name, minio.StatObjectOptions{})
+			if err != nil {
+				if isNotFound(err) {
+					return nil, errObjectNotFound(input.Bucket, name)
+				}
+				return nil, huma.Error500InternalServerError("Failed to stat object", err)
+			}
+			if input.IfRange == "" || input.IfRange == `"`+stat.ETag+`"` {
+				span, err = parseByteRange(input.Range, stat.Size)
+				if err != nil {
+					return &huma.StreamResponse{
+						Body: func(hctx huma.Context) {
+							hctx.SetHeader("Content-Range", fmt.Sprintf("bytes */%d", stat.Size))
+							writeHumaError(hctx, huma.NewError(http.StatusRequestedRangeNotSatisfiable, fmt.Sprintf("Range %s is outside the object's %d bytes", input.Range, stat.Size)))
+						},
+					}, nil
+				}
+			}
+			if span != nil {
+				if obj, err = openObjectRange(ctx, client, input.Bucket, name, stat, span.start, span.end); err != nil {
+					if isNotFound(err) {
+						return nil, errObjectNotFound(input.Bucket, name)
+					}
+					return nil, huma.Error500InternalServerError("Failed to get object", err)
+				}
+				info = stat
+			}
+		}
+
+		// Whole objects go through the disk cache, if it is enabled
+		if obj == nil {
+			if obj, info, err = openObject(ctx, client, input.Bucket, name, ""); err != nil {
+				if isNotFound(err) {
+					return nil, errObjectNotFound(input.Bucket, name)
+				}
+				return nil, huma.Error500InternalServerError("Failed to get object", err)
+			}
+		}
+
+		return &huma.StreamResponse{
+			Body: func(hctx huma.Context) {
+				defer obj.Close()
+				contentType := info.ContentType
+				if contentType == "" {
+					contentType = "application/octet-stream"
+				}
+				hctx.SetHeader("Content-Type", contentType)
+				hctx.SetHeader("Accept-Ranges", "bytes")
+				hctx.SetHeader("ETag", `"`+info.ETag+`"`)
+				if span != nil {
+					hctx.SetHeader("Content-Range", span.contentRange())
+					hctx.SetHeader("Content-Length", strconv.FormatInt(span.length(), 10))
+					hctx.SetStatus(http.StatusPartialContent)
+				} else {
+					hctx.SetHeader("Content-Length", strconv.FormatInt(info.Size, 10))
+				}
+				// Stream straight from the cache file or the MinIO response
+				// without buffering the object
+				if _, err := io.Copy(hctx.BodyWriter(), obj); err != nil {
+					log.Printf("Failed to stream %s/%s: %v", input.Bucket, name, err)
+				}
+			},
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-file-acl",
+		Method:      http.MethodGet,
+		Path:        "/files/{bucket}/{name}/acl",
+		Summary:     "Get a file's ACL",
+		Description: "Return who may read an object",
+	}, func(ctx context.Context, input *struct {
+		Bucket        string `path:"bucket" doc:"MinIO bucket name"`
+		Name          string `path:"name" doc:"Object name (URL-encoded if it contains slashes)"`
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token"`
+	}) (*struct {
+		Body ObjectACLResponse
+	}, error) {
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+		if err := checkBucketAccess(input.Bucket); err != nil {
+			return nil, err
+		}
+
+		name, err := decodeObjectKey(input.Name)
+		if err != nil {
+			return nil, err
+		}
+		acls, err := loadACLs(ctx, input.Bucket)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to load ACLs", err)
+		}
+		acl, ok := acls[name]
+		if !ok {
+			return nil, huma.Error404NotFound(fmt.Sprintf("Object %s in bucket %s has no ACL", name, input.Bucket))
+		}
+		if err := checkACL(acl, objectCaller(input.Authorization), input.Bucket, name); err != nil {
+			return nil, err
+		}
+
+		return &struct {
+			Body ObjectACLResponse
+		}{
+			Body: ObjectACLResponse{Bucket: input.Bucket, Name: name, ACL: acl},
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "set-file-acl",
+		Method:      http.MethodPut,
+		Path:        "/files/{bucket}/{name}/acl",
+		Summary:     "Set a file's ACL",
+		Description: "Make an object private to its owning tenant, shared with all tenants, or publicly readable. Tenants take ownership of objects they set an ACL on; only the owner or an admin may change an existing ACL",
+	}, func(ctx context.Context, input *struct {
+		Bucket        string `path:"bucket" doc:"MinIO bucket name"`
+		Name          string `path:"name" doc:"Object name (URL-encoded if it contains slashes)"`
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token"`
+		Body          ObjectACLRequest
+	}) (*struct {
+		Body ObjectACLResponse
+	}, error) {
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := decodeObjectKey(input.Name)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkBucketAccess(input.Bucket); err != nil {
+			return nil, err
+		}
+		caller := objectCaller(input.Authorization)
+		if !caller.admin && caller.tenant == "" {
+			return nil, huma.Error401Unauthorized("Invalid or missing tenant token")
+		}
+		owner := caller.tenant
+		if input.Body.Owner != "" {
+			if !caller.admin && input.Body.Owner != caller.tenant {
+				return nil, huma.Error403Forbidden("Only admins may assign objects to another tenant")
+			}
+			owner = input.Body.Owner
+		}
+		if owner == "" && input.Body.Visibility == aclPrivate {
+			return nil, huma.Error422UnprocessableEntity("Private objects need an owner")
+		}
+
+		if _, err := client.StatObject(ctx, input.Bucket, name, minio.StatObjectOptions{}); err != nil {
+			if isNotFound(err) {
+				return nil, errObjectNotFound(input.Bucket, name)
+			}
+			return nil, huma.Error500InternalServerError("Failed to stat object", err)
+		}
+
+		var forbidden error
+		acl := ObjectACL{Visibility: input.Body.Visibility, Owner: owner, UpdatedAt: time.Now().UTC()}
+		err = updateACLs(ctx, input.Bucket, func(acls map[string]ObjectACL) bool {
+			if current, ok := acls[name]; ok && !caller.admin && current.Owner != "" && current.Owner != caller.tenant {
+				forbidden = huma.Error403Forbidden(fmt.Sprintf("Object %s in bucket %s is owned by another tenant", name, input.Bucket))
+				return false
+			}
+			acls[name] = acl
+			return true
+		})
+		if forbidden != nil {
+			return nil, forbidden
+		}
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to save ACL", err)
+		}
+
+		return &struct {
+			Body ObjectACLResponse
+		}{
+			Body: ObjectACLResponse{Bucket: input.Bucket, Name: name, ACL: acl},
+		}, nil
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestObjectACLAllows(t *testing.T) {
+	admin := aclCaller{admin: true}
+	acme := aclCaller{tenant: "acme"}
+	globex := aclCaller{tenant: "globex"}
+	anonymous := aclCaller{}
+
+	cases := []struct {
+		acl    ObjectACL
+		caller aclCaller
+		allow  bool
+	}{
+		{ObjectACL{Visibility: aclPrivate, Owner: "acme"}, acme, true},
+		{ObjectACL{Visibility: aclPrivate, Owner: "acme"}, globex, false},
+		{ObjectACL{Visibility: aclPrivate, Owner: "acme"}, anonymous, false},
+		{ObjectACL{Visibility: aclPrivate, Owner: "acme"}, admin, true},
+		{ObjectACL{Visibility: aclTenantShared, Owner: "acme"}, globex, true},
+		{ObjectACL{Visibility: aclTenantShared, Owner: "acme"}, anonymous, false},
+		{ObjectACL{Visibility: aclPublicRead, Owner: "acme"}, anonymous, true},
+	}
+	for _, c := range cases {
+		if got := c.acl.allows(c.caller); got != c.allow {
+			t.Errorf("%+v.allows(%+v) = %v, want %v", c.acl, c.caller, got, c.allow)
+		}
+	}
+}
+
+func TestObjectCaller(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "admin-token"
+	config.Storage.Tenants = []StorageTenantConfig{{Name: "acme", Token: "acme-token", Bucket: "tenants"}}
+
+	if c := objectCaller("Bearer admin-token"); !c.admin {
+		t.Errorf("Expected the admin token to identify the admin, got %+v", c)
+	}
+	if c := objectCaller("Bearer acme-token"); c.tenant != "acme" || c.admin {
+		t.Errorf("Expected the tenant token to identify acme, got %+v", c)
+	}
+	if c := objectCaller("Bearer wrong"); c != (aclCaller{}) {
+		t.Errorf("Expected an unknown token to be anonymous, got %+v", c)
+	}
+}
+
+func TestACLEndpointsWithoutClient(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerACLEndpoints(api)
+
+	requests := []struct {
+		method string
+		path   string
+		body   []byte
+	}{
+		{"GET", "/files/test-bucket/test.txt", nil},
+		{"GET", "/files/test-bucket/test.txt/acl", nil},
+		{"PUT", "/files/test-bucket/test.txt/acl", []byte(`{"visibility": "private"}`)},
+	}
+
+	for _, r := range requests {
+		req := httptest.NewRequest(r.method, r.path, bytes.NewBuffer(r.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s %s: expected status code 503, got %d", r.method, r.path, w.Code)
+		}
+	}
+}
+
+func TestParseByteRange(t *testing.T) {
+	cases := []struct {
+		header string
+		want   *byteRange
+		err    bool
+	}{
+		{"bytes=0-99", &byteRange{0, 99, 1000}, false},
+		{"bytes=500-", &byteRange{500, 999, 1000}, false},
+		{"bytes=-100", &byteRange{900, 999, 1000}, false},
+		{"bytes=-5000", &byteRange{0, 999, 1000}, false},
+		{"bytes=990-2000", &byteRange{990, 999, 1000}, false},
+		{"bytes=1000-", nil, true},
+		{"bytes=-0", nil, true},
+		{"bytes=5-1", nil, false},
+		{"bytes=0-1,5-9", nil, false},
+		{"items=0-1", nil, false},
+		{"bytes=abc", nil, false},
+	}
+	for _, c := range cases {
+		got, err := parseByteRange(c.header, 1000)
+		if (err != nil) != c.err || (got == nil) != (c.want == nil) || got != nil && *got != *c.want {
+			t.Errorf("parseByteRange(%q) = %+v, %v; want %+v, error %v", c.header, got, err, c.want, c.err)
+		}
+	}
+}
+
+func TestDownloadRange(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	objects := map[string]string{"media/clip.mp4": "0123456789"}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerACLEndpoints(api)
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/files/media/clip.mp4", nil)
+		req.Header = header
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get(http.Header{"Range": {"bytes=2-5"}})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "2345" || w.Header().Get("Content-Range") != "bytes 2-5/10" || w.Header().Get("Content-Length") != "4" {
+		t.Errorf("Expected bytes 2-5, got %d %v: %q", w.Code, w.Header(), w.Body.String())
+	}
+	if w := get(http.Header{"Range": {"bytes=-3"}}); w.Code != http.StatusPartialContent || w.Body.String() != "789" {
+		t.Errorf("Expected the last 3 bytes, got %d: %q", w.Code, w.Body.String())
+	}
+	w = get(http.Header{"Range": {"bytes=20-"}})
+	if w.Code != http.StatusRequestedRangeNotSatisfiable || w.Header().Get("Content-Range") != "bytes */10" {
+		t.Errorf("Expected an unsatisfiable range, got %d %v", w.Code, w.Header())
+	}
+	w = get(http.Header{"Range": {"bytes=2-5"}, "If-Range": {`"stale"`}})
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+		t.Errorf("Expected the whole object for a stale If-Range, got %d: %q", w.Code, w.Body.String())
+	}
+	w = get(http.Header{})
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" || w.Header().Get("Accept-Ranges") != "bytes" {
+		t.Errorf("Expected the whole object, got %d %v", w.Code, w.Header())
+	}
+}
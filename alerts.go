@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Alert is a notification raised by one of the monitors.
+type Alert struct {
+	Metric   string    `json:"metric"`
+	Current  float64   `json:"current"`
+	Baseline float64   `json:"baseline"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+}
+
+// AlertsConfig configures where alerts are delivered.
+type AlertsConfig struct {
+	WebhookURL   string   `mapstructure:"webhook_url" doc:"URL alerts are POSTed to as JSON"`
+	SMTPAddr     string   `mapstructure:"smtp_addr" doc:"SMTP server (host:port) for alert emails"`
+	SMTPUsername string   `mapstructure:"smtp_username" doc:"SMTP username"`
+	SMTPPassword string   `mapstructure:"smtp_password" doc:"SMTP password"`
+	EmailFrom    string   `mapstructure:"email_from" doc:"Sender address of alert emails"`
+	EmailTo      []string `mapstructure:"email_to" doc:"Recipients of alert emails"`
+}
+
+func (c AlertsConfig) Validate() error {
+	if len(c.EmailTo) > 0 && (c.SMTPAddr == "" || c.EmailFrom == "") {
+		return errors.New("alerts.email_to requires alerts.smtp_addr and alerts.email_from")
+	}
+	return nil
+}
+
+var alertHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// alertsEnabled reports whether any alert channel is configured.
+func alertsEnabled() bool {
+	return config.Alerts.WebhookURL != "" || (config.Alerts.SMTPAddr != "" && len(config.Alerts.EmailTo) > 0)
+}
+
+// sendAlert delivers an alert to the configured webhook and email
+// recipients. Delivery failures are logged.
+func sendAlert(ctx context.Context, a Alert) {
+	if config.Alerts.WebhookURL != "" {
+		body, _ := json.Marshal(a)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.Alerts.WebhookURL, bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+			var resp *http.Response
+			resp, err = alertHTTPClient.Do(req)
+			if err == nil {
+				resp.Body.Close()
+				if resp.StatusCode >= 300 {
+					err = fmt.Errorf("webhook returned %s", resp.Status)
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to send alert webhook: %v", err)
+		}
+	}
+
+	if config.Alerts.SMTPAddr != "" && len(config.Alerts.EmailTo) > 0 {
+		if err := sendEmail(config.Alerts.EmailTo, "[alert] "+a.Metric, a.Message); err != nil {
+			log.Printf("Failed to send alert email: %v", err)
+		}
+	}
+}
+
+// emailEnabled reports whether an SMTP server and sender are configured.
+func emailEnabled() bool {
+	return config.Alerts.SMTPAddr != "" && config.Alerts.EmailFrom != ""
+}
+
+// sendEmail sends a plain text email through the alerts SMTP server.
+func sendEmail(to []string, subject, body string) error {
+	var auth smtp.Auth
+	if config.Alerts.SMTPUsername != "" {
+		host := strings.Split(config.Alerts.SMTPAddr, ":")[0]
+		auth = smtp.PlainAuth("", config.Alerts.SMTPUsername, config.Alerts.SMTPPassword, host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+		config.Alerts.EmailFrom, strings.Join(to, ", "), subject, body)
+	return smtp.SendMail(config.Alerts.SMTPAddr, auth, config.Alerts.EmailFrom, to, []byte(msg))
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// MonitoringConfig configures the usage monitors.
+type MonitoringConfig struct {
+	Anomaly          AnomalyConfig `mapstructure:"anomaly" doc:"Usage anomaly detection"`
+	SLOs             []SLOConfig   `mapstructure:"slos" doc:"Service level objectives"`
+	SLOBurnRateAlert float64       `mapstructure:"slo_burn_rate_alert" doc:"Burn rate the 5m and 1h windows must both exceed to alert"`
+}
+
+// AnomalyConfig configures the usage anomaly monitor.
+type AnomalyConfig struct {
+	WindowMinutes   int     `mapstructure:"window_minutes" doc:"Minutes of usage compared against the baseline"`
+	BaselineMinutes int     `mapstructure:"baseline_minutes" doc:"Minutes of usage preceding the window that form the baseline"`
+	CooldownMinutes int     `mapstructure:"cooldown_minutes" doc:"Minimum minutes between alerts for the same metric"`
+	SpikeFactor     float64 `mapstructure:"spike_factor" doc:"How many times the baseline counts as a spike"`
+	ErrorRate       float64 `mapstructure:"error_rate" doc:"Minimum error rate that can alert"`
+	MinRequests     int64   `mapstructure:"min_requests" doc:"Minimum requests in the window before error rates are judged"`
+	MinTokens       int64   `mapstructure:"min_tokens" doc:"Minimum tokens in the window before token spikes alert"`
+	MinUploadBytes  int64   `mapstructure:"min_upload_bytes" doc:"Minimum uploaded bytes in the window before upload spikes alert"`
+}
+
+func (c MonitoringConfig) Validate() error {
+	errs := []error{c.Anomaly.Validate()}
+	for _, s := range c.SLOs {
+		errs = append(errs, s.validate())
+	}
+	if len(c.SLOs) > 0 && c.SLOBurnRateAlert <= 0 {
+		errs = append(errs, errors.New("monitoring.slo_burn_rate_alert must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+func (c AnomalyConfig) Validate() error {
+	if c.WindowMinutes <= 0 || c.BaselineMinutes <= 0 {
+		return errors.New("monitoring.anomaly.window_minutes and baseline_minutes must be positive")
+	}
+	if c.SpikeFactor <= 1 {
+		return errors.New("monitoring.anomaly.spike_factor must be greater than 1")
+	}
+	return nil
+}
+
+// anomalyThresholds controls when a window counts as anomalous.
+type anomalyThresholds struct {
+	SpikeFactor    float64
+	ErrorRate      float64
+	MinRequests    int64
+	MinTokens      int64
+	MinUploadBytes int64
+}
+
+func anomalyThresholdsFromConfig() anomalyThresholds {
+	return anomalyThresholds{
+		SpikeFactor:    config.Monitoring.Anomaly.SpikeFactor,
+		ErrorRate:      config.Monitoring.Anomaly.ErrorRate,
+		MinRequests:    config.Monitoring.Anomaly.MinRequests,
+		MinTokens:      config.Monitoring.Anomaly.MinTokens,
+		MinUploadBytes: config.Monitoring.Anomaly.MinUploadBytes,
+	}
+}
+
+// detectAnomalies compares the current window with the baseline, which
+// spans windows times as long, and returns every metric that spiked.
+func detectAnomalies(current, baseline usageBucket, windows float64, th anomalyThresholds, now time.Time) []Alert {
+	var found []Alert
+	spike := func(metric, unit string, cur, base, min int64) {
+		avg := float64(base) / windows
+		if cur >= min && float64(cur) > th.SpikeFactor*avg {
+			found = append(found, Alert{
+				Metric:   metric,
+				Current:  float64(cur),
+				Baseline: avg,
+				Message:  fmt.Sprintf("%s spiked to %d %s (baseline %.0f)", metric, cur, unit, avg),
+				Time:     now,
+			})
+		}
+	}
+	spike("token_spend", "tokens", current.Tokens, baseline.Tokens, th.MinTokens)
+	spike("upload_volume", "bytes", current.UploadBytes, baseline.UploadBytes, th.MinUploadBytes)
+
+	if current.Requests >= th.MinRequests && current.Requests > 0 {
+		rate := float64(current.Errors) / float64(current.Requests)
+		baseRate := 0.0
+		if baseline.Requests > 0 {
+			baseRate = float64(baseline.Errors) / float64(baseline.Requests)
+		}
+		if rate >= th.ErrorRate && rate > th.SpikeFactor*baseRate {
+			found = append(found, Alert{
+				Metric:   "error_rate",
+				Current:  rate,
+				Baseline: baseRate,
+				Message:  fmt.Sprintf("error_rate spiked to %.1f%% (baseline %.1f%%)", rate*100, baseRate*100),
+				Time:     now,
+			})
+		}
+	}
+	return found
+}
+
+// startAnomalyMonitor checks the last window of usage against the rolling
+// baseline once a minute and alerts on spikes. Each metric alerts at most
+// once per cooldown period.
+func startAnomalyMonitor(ctx context.Context) {
+	window := time.Duration(config.Monitoring.Anomaly.WindowMinutes) * time.Minute
+	baseline := time.Duration(config.Monitoring.Anomaly.BaselineMinutes) * time.Minute
+	cooldown := time.Duration(config.Monitoring.Anomaly.CooldownMinutes) * time.Minute
+	th := anomalyThresholdsFromConfig()
+
+	var mu sync.Mutex
+	lastAlert := map[string]time.Time{}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				// Only look at complete minutes
+				end := now.Truncate(time.Minute)
+				current := usage.sum(end.Add(-window), end)
+				base := usage.sum(end.Add(-window-baseline), end.Add(-window))
+
+				for _, a := range detectAnomalies(current, base, float64(baseline)/float64(window), th, now) {
+					mu.Lock()
+					recent := now.Sub(lastAlert[a.Metric]) < cooldown
+					if !recent {
+						lastAlert[a.Metric] = now
+					}
+					mu.Unlock()
+					if recent {
+						continue
+					}
+					log.Printf("Usage anomaly: %s", a.Message)
+					sendAlert(ctx, a)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUsageRecorderSum(t *testing.T) {
+	r := &usageRecorder{}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	r.recordRequest(start, http.StatusOK, 20*time.Millisecond)
+	r.recordRequest(start.Add(30*time.Second), http.StatusInternalServerError, 2*time.Second)
+	r.recordTokens(start.Add(time.Minute), 500)
+	r.recordUpload(start.Add(2*time.Minute), 1024)
+
+	total := r.sum(start, start.Add(2*time.Minute))
+	if total.Requests != 2 || total.Errors != 1 || total.Tokens != 500 || total.UploadBytes != 0 {
+		t.Errorf("Unexpected totals: %+v", total)
+	}
+	if total.withinLatency(time.Second) != 1 || total.withinLatency(5*time.Second) != 2 {
+		t.Errorf("Unexpected latency histogram: %v", total.Latency)
+	}
+	if len(r.buckets) != 3 {
+		t.Errorf("Expected one bucket per minute, got %d", len(r.buckets))
+	}
+
+	// Buckets older than the retention window are dropped
+	r.recordRequest(start.Add(usageRetention+time.Minute), http.StatusOK, time.Millisecond)
+	if len(r.buckets) != 2 {
+		t.Errorf("Expected expired buckets to be dropped, got %d buckets", len(r.buckets))
+	}
+}
+
+func TestDetectAnomalies(t *testing.T) {
+	th := anomalyThresholds{SpikeFactor: 3, ErrorRate: 0.05, MinRequests: 20, MinTokens: 1000, MinUploadBytes: 1 << 20}
+	now := time.Now()
+
+	// Baseline covers 12 windows with 1000 tokens per window on average
+	baseline := usageBucket{Requests: 1200, Errors: 12, Tokens: 12000, UploadBytes: 12 << 20}
+
+	normal := usageBucket{Requests: 100, Errors: 1, Tokens: 1500, UploadBytes: 1 << 20}
+	if found := detectAnomalies(normal, baseline, 12, th, now); len(found) != 0 {
+		t.Errorf("Expected no anomalies for normal traffic, got %+v", found)
+	}
+
+	spiky := usageBucket{Requests: 100, Errors: 20, Tokens: 5000, UploadBytes: 8 << 20}
+	found := detectAnomalies(spiky, baseline, 12, th, now)
+	metrics := map[string]bool{}
+	for _, a := range found {
+		metrics[a.Metric] = true
+	}
+	for _, m := range []string{"token_spend", "upload_volume", "error_rate"} {
+		if !metrics[m] {
+			t.Errorf("Expected %s anomaly, got %+v", m, found)
+		}
+	}
+
+	// Too little traffic to judge
+	quiet := usageBucket{Requests: 5, Errors: 5, Tokens: 900}
+	if found := detectAnomalies(quiet, usageBucket{}, 12, th, now); len(found) != 0 {
+		t.Errorf("Expected minimums to suppress alerts, got %+v", found)
+	}
+}
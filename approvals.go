@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// ApprovalsConfig names the admins of the two-person approval workflow.
+type ApprovalsConfig struct {
+	Admins        map[string]string `mapstructure:"admins" doc:"Admin tokens by admin name; with two or more, dangerous actions need a second admin's approval"`
+	WindowMinutes int               `mapstructure:"window_minutes" doc:"Minutes an approval request can be approved and then used in"`
+}
+
+func (c ApprovalsConfig) Validate() error {
+	if len(c.Admins) == 1 {
+		return errors.New("approvals.admins needs at least two admins")
+	}
+	tokens := map[string]bool{}
+	for name, token := range c.Admins {
+		if len(token) < 16 {
+			return fmt.Errorf("approvals.admins: the token of %q must be at least 16 characters", name)
+		}
+		if tokens[token] {
+			return errors.New("approvals.admins: every admin needs their own token")
+		}
+		tokens[token] = true
+	}
+	if c.WindowMinutes <= 0 {
+		return errors.New("approvals.window_minutes must be positive")
+	}
+	return nil
+}
+
+// approvalsRequired reports whether dangerous actions need approval.
+func approvalsRequired() bool {
+	return len(config.Approvals.Admins) >= 2
+}
+
+// Actions that need approval, named after their operation IDs.
+const (
+	approvalDeleteSubjectData = "delete-subject-data"
+	approvalRotateSecrets     = "rotate-secrets"
+	approvalPurgeTrash        = "purge-trash"
+	approvalRunRetention      = "run-retention"
+)
+
+// approvalTargetAll is the target of actions that apply to every bucket.
+const approvalTargetAll = "all"
+
+// Approval statuses.
+const (
+	approvalPending  = "pending"
+	approvalApproved = "approved"
+	approvalUsed     = "used"
+	approvalExpired  = "expired"
+)
+
+// Approval is a request of one admin to run a dangerous action, which a
+// second admin has to approve before it can be run.
+type Approval struct {
+	ID          string     `json:"id" doc:"Approval ID, sent as X-Approval-ID to run the action"`
+	Action      string     `json:"action" enum:"delete-subject-data,rotate-secrets,purge-trash,run-retention" doc:"Operation the approval is for"`
+	Target      string     `json:"target" doc:"What the action applies to: the data subject ID, the services whose credentials are replaced, such as openai,minio, or all for the purges"`
+	Reason      string     `json:"reason,omitempty" doc:"Why the action is needed"`
+	Status      string     `json:"status" enum:"pending,approved,used,expired" doc:"Where the approval stands"`
+	RequestedBy string     `json:"requested_by" doc:"Admin who requested the action"`
+	RequestedAt time.Time  `json:"requested_at" doc:"When the action was requested"`
+	ApprovedBy  string     `json:"approved_by,omitempty" doc:"Admin who approved the action"`
+	ApprovedAt  *time.Time `json:"approved_at,omitempty" doc:"When the action was approved"`
+	ExpiresAt   time.Time  `json:"expires_at" doc:"When the approval lapses unless it has been used"`
+}
+
+type ApprovalRequest struct {
+	Action string `json:"action" enum:"delete-subject-data,rotate-secrets,purge-trash,run-retention" doc:"Operation to run"`
+	Target string `json:"target" doc:"The data subject ID for delete-subject-data; openai, minio or openai,minio for rotate-secrets; all for purge-trash and run-retention"`
+	Reason string `json:"reason,omitempty" maxLength:"1000" doc:"Why the action is needed, for the approving admin"`
+}
+
+type ApprovalListResponse struct {
+	Approvals []Approval `json:"approvals" doc:"Approvals that are pending, approved or recently ended, newest first"`
+}
+
+// approvals are kept in memory: a restart drops them and they have to be
+// requested again.
+var approvals struct {
+	sync.Mutex
+	byID map[string]*Approval
+}
+
+// approvalRetention is how long an ended approval is still listed.
+const approvalRetention = 24 * time.Hour
+
+// adminName returns the name of the approvals admin a token belongs to.
+func adminName(authorization string) (string, bool) {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	for name, t := range config.Approvals.Admins {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// requireNamedAdmin authenticates one of the approvals admins.
+func requireNamedAdmin(authorization string) (string, error) {
+	if !approvalsRequired() {
+		return "", huma.Error403Forbidden("Approvals are disabled; configure approvals.admins")
+	}
+	name, ok := adminName(authorization)
+	if !ok {
+		return "", huma.Error401Unauthorized("Approvals need the token of an admin in approvals.admins")
+	}
+	return name, nil
+}
+
+// status returns the approval's status at now.
+func (a *Approval) status(now time.Time) string {
+	if a.Status != approvalUsed && !now.Before(a.ExpiresAt) {
+		return approvalExpired
+	}
+	return a.Status
+}
+
+// errApprovalRequired refuses an action that lacks a usable approval.
+func errApprovalRequired(msg string) error {
+	return codedError(http.StatusForbidden, "ERR_APPROVAL_REQUIRED", msg)
+}
+
+// consumeApproval lets a dangerous action run once approvals are required:
+// approvalID has to name an approved, unexpired approval of the action on
+// the target. The approval is used up.
+func consumeApproval(ctx context.Context, approvalID, action, target string) error {
+	if !approvalsRequired() {
+		return nil
+	}
+	if approvalID == "" {
+		return errApprovalRequired(fmt.Sprintf("%s needs a second admin's approval; request one with POST /admin/approvals and send its ID as X-Approval-ID", action))
+	}
+	approvals.Lock()
+	defer approvals.Unlock()
+	a, ok := approvals.byID[approvalID]
+	if !ok || a.Action != action || a.Target != target {
+		return errApprovalRequired(fmt.Sprintf("Approval %s is not for %s on %s", approvalID, action, target))
+	}
+	if status := a.status(time.Now()); status != approvalApproved {
+		return errApprovalRequired(fmt.Sprintf("Approval %s is %s", approvalID, status))
+	}
+	a.Status = approvalUsed
+	audit(ctx, AuditEvent{Action: "approval.used", Operation: action, Detail: a.ID + " " + target})
+	return nil
+}
+
+// validApprovalTarget checks that the target names what the action applies
+// to the way the action's handler does.
+func validApprovalTarget(action, target string) bool {
+	switch action {
+	case approvalDeleteSubjectData:
+		return subjectIDPattern.MatchString(target)
+	case approvalRotateSecrets:
+		return target == "openai" || target == "minio" || target == "openai,minio"
+	case approvalPurgeTrash, approvalRunRetention:
+		return target == approvalTargetAll
+	}
+	return false
+}
+
+// pruneApprovals drops approvals that ended more than approvalRetention ago.
+// The caller holds the lock.
+func pruneApprovals(now time.Time) {
+	for id, a := range approvals.byID {
+		if now.Sub(a.ExpiresAt) > approvalRetention {
+			delete(approvals.byID, id)
+		}
+	}
+}
+
+func registerApprovalEndpoints(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID:   "request-approval",
+		Method:        http.MethodPost,
+		Path:          "/admin/approvals",
+		Summary:       "Request approval for a dangerous action",
+		Description:   "Ask a second admin to approve deleting a data subject's data, rotating credentials, purging the trash or running the retention purge. Once approved, the action is run by sending the approval's ID as X-Approval-ID",
+		DefaultStatus: http.StatusCreated,
+	}, func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer token of an admin in approvals.admins"`
+		Body          ApprovalRequest
+	}) (*struct {
+		Body Approval
+	}, error) {
+		name, err := requireNamedAdmin(input.Authorization)
+		if err != nil {
+			return nil, err
+		}
+		if !validApprovalTarget(input.Body.Action, input.Body.Target) {
+			return nil, huma.Error422UnprocessableEntity(fmt.Sprintf("Invalid target %q for %s", input.Body.Target, input.Body.Action))
+		}
+
+		now := time.Now().UTC()
+		a := &Approval{
+			ID:          randomHex(8),
+			Action:      input.Body.Action,
+			Target:      input.Body.Target,
+			Reason:      input.Body.Reason,
+			Status:      approvalPending,
+			RequestedBy: name,
+			RequestedAt: now,
+			ExpiresAt:   now.Add(time.Duration(config.Approvals.WindowMinutes) * time.Minute),
+		}
+		approvals.Lock()
+		pruneApprovals(now)
+		if approvals.byID == nil {
+			approvals.byID = map[string]*Approval{}
+		}
+		approvals.byID[a.ID] = a
+		out := *a
+		approvals.Unlock()
+		audit(ctx, AuditEvent{Action: "approval.requested", Operation: a.Action, Detail: a.ID + " " + a.Target + " by " + name})
+		return &struct {
+			Body Approval
+		}{Body: out}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "list-approvals",
+		Method:      http.MethodGet,
+		Path:        "/admin/approvals",
+		Summary:     "List approvals",
+		Description: "List pending and approved actions, and those that ended in the last day",
+	}, func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer token of an admin in approvals.admins"`
+	}) (*struct {
+		Body ApprovalListResponse
+	}, error) {
+		if _, err := requireNamedAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		out := ApprovalListResponse{Approvals: []Approval{}}
+		approvals.Lock()
+		pruneApprovals(now)
+		for _, a := range approvals.byID {
+			item := *a
+			item.Status = a.status(now)
+			out.Approvals = append(out.Approvals, item)
+		}
+		approvals.Unlock()
+		sort.Slice(out.Approvals, func(i, j int) bool {
+			return out.Approvals[i].RequestedAt.After(out.Approvals[j].RequestedAt)
+		})
+		return &struct {
+			Body ApprovalListResponse
+		}{Body: out}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "approve-action",
+		Method:      http.MethodPost,
+		Path:        "/admin/approvals/{id}/approve",
+		Summary:     "Approve a dangerous action",
+		Description: "Approve another admin's pending request. The requesting admin cannot approve their own",
+	}, func(ctx context.Context, input *struct {
+		ID            string `path:"id" doc:"Approval ID"`
+		Authorization string `header:"Authorization" doc:"Bearer token of an admin in approvals.admins"`
+	}) (*struct {
+		Body Approval
+	}, error) {
+		name, err := requireNamedAdmin(input.Authorization)
+		if err != nil {
+			return nil, err
+		}
+		approvals.Lock()
+		defer approvals.Unlock()
+		a, ok := approvals.byID[input.ID]
+		if !ok {
+			return nil, huma.Error404NotFound("Approval not found")
+		}
+		if a.RequestedBy == name {
+			return nil, huma.Error403Forbidden("A second admin has to approve the request")
+		}
+		now := time.Now().UTC()
+		if status := a.status(now); status != approvalPending {
+			return nil, huma.Error409Conflict(fmt.Sprintf("Approval %s is %s", a.ID, status))
+		}
+		a.Status, a.ApprovedBy, a.ApprovedAt = approvalApproved, name, &now
+		audit(ctx, AuditEvent{Action: "approval.approved", Operation: a.Action, Detail: a.ID + " " + a.Target + " by " + name})
+		return &struct {
+			Body Approval
+		}{Body: *a}, nil
+	})
+}
+
+// rotatedServices names the services a rotation replaces credentials of,
+// as approval targets do.
+func (r SecretRotationRequest) rotatedServices() string {
+	var names []string
+	if r.OpenAIKey != "" {
+		names = append(names, "openai")
+	}
+	if r.MinIOKey != "" || r.MinIOSecret != "" {
+		names = append(names, "minio")
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestApprovals(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "shared-admin-token"
+	config.Compliance.ReportSigningKey = strings.Repeat("k", 32)
+	services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerApprovalEndpoints(api)
+	registerSubjectDataEndpoint(api)
+	registerTrashEndpoints(api)
+	registerRetentionEndpoint(api)
+	call := func(method, path, token, approval, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		if approval != "" {
+			req.Header.Set("X-Approval-ID", approval)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	erase := func(subject, approval string) *httptest.ResponseRecorder {
+		return call(http.MethodDelete, "/users/"+subject+"/data", "shared-admin-token", approval, "")
+	}
+
+	// Without named admins nothing needs approval
+	if w := erase("acme", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the deletion to go ahead without approvals, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call(http.MethodPost, "/admin/approvals", "shared-admin-token", "", `{"action": "delete-subject-data", "target": "acme"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected approvals to be disabled, got %d", w.Code)
+	}
+
+	// Decoding the configuration keeps map entries, so reset the admins
+	config.Approvals.Admins = map[string]string{"alice": "alice-token-0123456789", "bob": "bob-token-0123456789"}
+	defer func() { config.Approvals.Admins = nil }()
+	approvals.byID = nil
+	if w := erase("acme", ""); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "ERR_APPROVAL_REQUIRED") {
+		t.Errorf("Expected the deletion to need approval, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call(http.MethodDelete, "/users/acme/data?dry_run=true", "alice-token-0123456789", "", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a named admin's dry run to need no approval, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call(http.MethodPost, "/admin/approvals", "shared-admin-token", "", `{"action": "delete-subject-data", "target": "acme"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the shared admin token not to request approvals, got %d", w.Code)
+	}
+	if w := call(http.MethodPost, "/admin/approvals", "alice-token-0123456789", "", `{"action": "rotate-secrets", "target": "acme"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected an invalid target to be rejected, got %d", w.Code)
+	}
+
+	w := call(http.MethodPost, "/admin/approvals", "alice-token-0123456789", "", `{"action": "delete-subject-data", "target": "acme", "reason": "Contract ended"}`)
+	var a Approval
+	json.Unmarshal(w.Body.Bytes(), &a)
+	if w.Code != http.StatusCreated || a.Status != approvalPending || a.RequestedBy != "alice" {
+		t.Fatalf("Expected a pending approval, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := erase("acme", a.ID); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "pending") {
+		t.Errorf("Expected a pending approval not to be usable, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call(http.MethodPost, "/admin/approvals/"+a.ID+"/approve", "alice-token-0123456789", "", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the requester not to approve, got %d", w.Code)
+	}
+	w = call(http.MethodPost, "/admin/approvals/"+a.ID+"/approve", "bob-token-0123456789", "", "")
+	json.Unmarshal(w.Body.Bytes(), &a)
+	if w.Code != http.StatusOK || a.Status != approvalApproved || a.ApprovedBy != "bob" {
+		t.Fatalf("Expected bob to approve, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call(http.MethodPost, "/admin/approvals/"+a.ID+"/approve", "bob-token-0123456789", "", ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected a second approval to conflict, got %d", w.Code)
+	}
+
+	if w := erase("globex", a.ID); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the approval not to cover another subject, got %d", w.Code)
+	}
+	// The approval lets the deletion through once; it then fails for lack of MinIO
+	if w := erase("acme", a.ID); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the approved deletion to run, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := erase("acme", a.ID); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "used") {
+		t.Errorf("Expected a used approval to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = call(http.MethodPost, "/admin/approvals", "bob-token-0123456789", "", `{"action": "rotate-secrets", "target": "openai"}`)
+	var late Approval
+	json.Unmarshal(w.Body.Bytes(), &late)
+	approvals.byID[late.ID].ExpiresAt = time.Now().Add(-time.Second)
+	if w := call(http.MethodPost, "/admin/approvals/"+late.ID+"/approve", "alice-token-0123456789", "", ""); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "expired") {
+		t.Errorf("Expected an expired request not to be approved, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = call(http.MethodGet, "/admin/approvals", "alice-token-0123456789", "", "")
+	var list ApprovalListResponse
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list.Approvals) != 2 || list.Approvals[0].Status != approvalExpired || list.Approvals[1].Status != approvalUsed {
+		t.Errorf("Expected the expired and used approvals, newest first, got %s", w.Body.String())
+	}
+
+	// The purges need approval too, except for dry runs
+	for _, path := range []string{"/admin/trash/purge", "/admin/retention/run"} {
+		if w := call(http.MethodPost, path, "shared-admin-token", "", ""); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "ERR_APPROVAL_REQUIRED") {
+			t.Errorf("POST %s: expected the purge to need approval, got %d: %s", path, w.Code, w.Body.String())
+		}
+		if w := call(http.MethodPost, path+"?dry_run=true", "shared-admin-token", "", ""); w.Code == http.StatusForbidden {
+			t.Errorf("POST %s: expected a dry run to need no approval, got %s", path, w.Body.String())
+		}
+	}
+	if w := call(http.MethodPost, "/admin/approvals", "alice-token-0123456789", "", `{"action": "purge-trash", "target": "docs"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected a purge to apply to all buckets only, got %d", w.Code)
+	}
+	w = call(http.MethodPost, "/admin/approvals", "alice-token-0123456789", "", `{"action": "purge-trash", "target": "all"}`)
+	var purge Approval
+	json.Unmarshal(w.Body.Bytes(), &purge)
+	call(http.MethodPost, "/admin/approvals/"+purge.ID+"/approve", "bob-token-0123456789", "", "")
+	if w := call(http.MethodPost, "/admin/retention/run", "shared-admin-token", purge.ID, ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a trash purge approval not to run the retention purge, got %d", w.Code)
+	}
+	// The approved purge runs; it then fails for lack of MinIO
+	if w := call(http.MethodPost, "/admin/trash/purge", "shared-admin-token", purge.ID, ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the approved purge to run, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRotatedServices(t *testing.T) {
+	for req, want := range map[SecretRotationRequest]string{
+		{OpenAIKey: "sk"}:                                  "openai",
+		{MinIOKey: "k", MinIOSecret: "s"}:                  "minio",
+		{OpenAIKey: "sk", MinIOKey: "k", MinIOSecret: "s"}: "openai,minio",
+	} {
+		if got := req.rotatedServices(); got != want {
+			t.Errorf("Expected %s for %+v, got %s", want, req, got)
+		}
+	}
+}
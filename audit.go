@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// AuditEvent records a security-relevant decision.
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Tenant    string    `json:"tenant,omitempty"`
+	Operation string    `json:"operation,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// audit writes ev to the log as one "Audit:" line of JSON so that log
+// shippers can route audit events separately, and to the event log when
+// it is enabled.
+func audit(ctx context.Context, ev AuditEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	if t := traceFromContext(ctx); t != nil && ev.RequestID == "" {
+		ev.RequestID = t.RequestID
+	}
+	data, _ := json.Marshal(ev)
+	log.Printf("Audit: %s", data)
+	eventLog.record(ev)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// CacheConfig configures the response cache for GET endpoints.
+type CacheConfig struct {
+	Backend       string             `mapstructure:"backend" enum:"memory,redis" doc:"Where cached responses are kept; empty disables caching"`
+	MaxEntries    int                `mapstructure:"max_entries" doc:"Most responses the memory backend keeps"`
+	MaxBytes      int64              `mapstructure:"max_bytes" doc:"Most bytes of responses the memory backend keeps"`
+	MaxEntryBytes int64              `mapstructure:"max_entry_bytes" doc:"Larger responses are not cached"`
+	RedisURL      string             `mapstructure:"redis_url" doc:"Redis URL for the redis backend, e.g. redis://localhost:6379/0"`
+	RedisPrefix   string             `mapstructure:"redis_prefix" doc:"Prefix of the Redis keys"`
+	Routes        []CacheRouteConfig `mapstructure:"routes" doc:"GET routes to cache and for how long"`
+}
+
+type CacheRouteConfig struct {
+	Path       string `mapstructure:"path" doc:"Route pattern as in the API docs, e.g. /providers/{name}/models; a trailing * matches any rest"`
+	TTLSeconds int    `mapstructure:"ttl_seconds" doc:"Seconds a response is served from the cache"`
+}
+
+func (c CacheConfig) Validate() error {
+	switch c.Backend {
+	case "", "memory":
+	case "redis":
+		if c.RedisURL == "" {
+			return errors.New("cache.redis_url is required for the redis backend")
+		}
+		if _, err := redis.ParseURL(c.RedisURL); err != nil {
+			return fmt.Errorf("cache.redis_url: %w", err)
+		}
+	default:
+		return fmt.Errorf("cache.backend: unknown backend %q", c.Backend)
+	}
+	if c.Backend != "" && (c.MaxEntries <= 0 || c.MaxBytes <= 0 || c.MaxEntryBytes <= 0) {
+		return errors.New("cache.max_entries, cache.max_bytes and cache.max_entry_bytes must be positive")
+	}
+	for _, r := range c.Routes {
+		if !strings.HasPrefix(r.Path, "/") || r.TTLSeconds <= 0 {
+			return fmt.Errorf("cache.routes: %q needs an absolute path and a positive ttl_seconds", r.Path)
+		}
+	}
+	return nil
+}
+
+// cachedResponse is a stored response.
+type cachedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	Stored time.Time   `json:"stored"`
+}
+
+type cacheBackend interface {
+	get(ctx context.Context, key string) (*cachedResponse, error)
+	set(ctx context.Context, key string, resp *cachedResponse, ttl time.Duration) error
+}
+
+// memoryCache keeps responses in an LRU store bounded by count and size.
+type memoryCache struct {
+	store *lruStore[*cachedResponse]
+}
+
+func newMemoryCache(limits StoreLimits) *memoryCache {
+	return &memoryCache{store: newLRUStore("cache", limits, (*cachedResponse).size)}
+}
+
+// size approximates the memory a response holds.
+func (r *cachedResponse) size() int64 {
+	n := int64(len(r.Body))
+	for k, vs := range r.Header {
+		n += int64(len(k))
+		for _, v := range vs {
+			n += int64(len(v))
+		}
+	}
+	return n
+}
+
+func (c *memoryCache) get(_ context.Context, key string) (*cachedResponse, error) {
+	resp, _ := c.store.get(key)
+	return resp, nil
+}
+
+func (c *memoryCache) set(_ context.Context, key string, resp *cachedResponse, ttl time.Duration) error {
+	if ttl <= 0 {
+		c.store.delete(key)
+		return nil
+	}
+	c.store.set(key, resp, ttl)
+	return nil
+}
+
+// redisCache shares cached responses between instances.
+type redisCache struct {
+	client *redis.Client
+	prefix string
+}
+
+func (c *redisCache) get(ctx context.Context, key string) (*cachedResponse, error) {
+	b, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var resp cachedResponse
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *redisCache) set(ctx context.Context, key string, resp *cachedResponse, ttl time.Duration) error {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return c.client.Set(ctx, c.prefix+key, b, ttl).Err()
+}
+
+// responseCache caches the responses of the configured GET routes.
+type responseCache struct {
+	backend       cacheBackend
+	routes        []CacheRouteConfig
+	maxEntryBytes int64
+}
+
+// uncachedHeaders belong to one exchange and are never replayed.
+var uncachedHeaders = []string{requestIDHeader, "Date", "Set-Cookie"}
+
+func newResponseCache(c CacheConfig) (*responseCache, error) {
+	rc := &responseCache{routes: c.Routes, maxEntryBytes: c.MaxEntryBytes}
+	switch c.Backend {
+	case "memory":
+		rc.backend = newMemoryCache(StoreLimits{MaxEntries: c.MaxEntries, MaxBytes: c.MaxBytes})
+	case "redis":
+		opts, err := redis.ParseURL(c.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		rc.backend = &redisCache{client: redis.NewClient(opts), prefix: c.RedisPrefix}
+	default:
+		return nil, nil
+	}
+	return rc, nil
+}
+
+// matchRoute reports whether path matches pattern, where {param} matches
+// one segment and a trailing * matches the rest.
+func matchRoute(pattern, path string) bool {
+	ps := strings.Split(strings.Trim(pattern, "/"), "/")
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	for i, p := range ps {
+		if p == "*" && i == len(ps)-1 {
+			return true
+		}
+		if i >= len(segs) {
+			return false
+		}
+		if !(strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}")) && p != segs[i] {
+			return false
+		}
+	}
+	return len(ps) == len(segs)
+}
+
+// ttl returns how long responses for path are cached, or 0.
+func (c *responseCache) ttl(path string) time.Duration {
+	for _, r := range c.routes {
+		if matchRoute(r.Path, path) {
+			return time.Duration(r.TTLSeconds) * time.Second
+		}
+	}
+	return 0
+}
+
+func cacheKey(r *http.Request) string {
+	return r.URL.Path + "?" + r.URL.Query().Encode() + "|" + r.Header.Get("Accept")
+}
+
+func hasDirective(cacheControl, directive string) bool {
+	for _, d := range strings.Split(cacheControl, ",") {
+		if strings.EqualFold(strings.TrimSpace(d), directive) {
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatches reports whether an If-None-Match header matches etag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCached replays a cached response, or answers a matching
+// conditional request with 304.
+func writeCached(w http.ResponseWriter, r *http.Request, resp *cachedResponse, ttl time.Duration) {
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	age := time.Since(resp.Stored)
+	w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int((ttl-age).Seconds())))
+	w.Header().Set("X-Cache", "HIT")
+	if etagMatches(r.Header.Get("If-None-Match"), resp.Header.Get("ETag")) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
+
+// middleware serves cached responses of the configured routes. Requests
+// with Cache-Control: no-cache are revalidated, no-store bypasses the
+// cache entirely. Responses marked no-store or private are not stored.
+func (c *responseCache) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ttl := c.ttl(r.URL.Path)
+		reqCC := r.Header.Get("Cache-Control")
+		if r.Method != http.MethodGet || ttl == 0 || hasDirective(reqCC, "no-store") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := cacheKey(r)
+		if !hasDirective(reqCC, "no-cache") {
+			resp, err := c.backend.get(r.Context(), key)
+			if err != nil {
+				log.Printf("Failed to read response cache: %v", err)
+			}
+			if resp != nil && time.Since(resp.Stored) < ttl {
+				writeCached(w, r, resp, ttl)
+				return
+			}
+		}
+
+		// Buffer the response so an ETag can be set before it is sent
+		buf := &bufferedWriter{header: http.Header{}, status: http.StatusOK}
+		next.ServeHTTP(buf, r)
+
+		respCC := buf.header.Get("Cache-Control")
+		cacheable := buf.status == http.StatusOK && int64(buf.body.Len()) <= c.maxEntryBytes &&
+			!hasDirective(respCC, "no-store") && !hasDirective(respCC, "private")
+		if cacheable {
+			if buf.header.Get("ETag") == "" {
+				sum := sha256.Sum256(buf.body.Bytes())
+				buf.header.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+			}
+			if respCC == "" {
+				buf.header.Set("Cache-Control", fmt.Sprintf("max-age=%d", int(ttl.Seconds())))
+			}
+			stored := &cachedResponse{Status: buf.status, Header: buf.header.Clone(), Body: buf.body.Bytes(), Stored: time.Now()}
+			for _, h := range uncachedHeaders {
+				stored.Header.Del(h)
+			}
+			if err := c.backend.set(r.Context(), key, stored, ttl); err != nil {
+				log.Printf("Failed to write response cache: %v", err)
+			}
+			buf.header.Set("X-Cache", "MISS")
+		}
+
+		for k, v := range buf.header {
+			w.Header()[k] = v
+		}
+		if cacheable && etagMatches(r.Header.Get("If-None-Match"), buf.header.Get("ETag")) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.WriteHeader(buf.status)
+		w.Write(buf.body.Bytes())
+	})
+}
+
+// bufferedWriter collects a response in memory.
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) Header() http.Header         { return w.header }
+func (w *bufferedWriter) WriteHeader(status int)      { w.status = status }
+func (w *bufferedWriter) Write(b []byte) (int, error) { return w.body.Write(b) }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMatchRoute(t *testing.T) {
+	cases := []struct {
+		pattern, path string
+		match         bool
+	}{
+		{"/providers/{name}/models", "/providers/openai/models", true},
+		{"/providers/{name}/models", "/providers/openai", false},
+		{"/providers/{name}/models", "/providers/openai/models/extra", false},
+		{"/files/{bucket}/*", "/files/docs/reports/q1.pdf/preview", true},
+		{"/files/{bucket}/*", "/collections", false},
+		{"/errors", "/errors", true},
+	}
+	for _, c := range cases {
+		if got := matchRoute(c.pattern, c.path); got != c.match {
+			t.Errorf("matchRoute(%q, %q) = %v, want %v", c.pattern, c.path, got, c.match)
+		}
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+	c := newMemoryCache(StoreLimits{MaxEntries: 2, MaxBytes: 1 << 20})
+	c.set(ctx, "a", &cachedResponse{Status: 200}, time.Minute)
+	c.set(ctx, "b", &cachedResponse{Status: 200}, time.Minute)
+	c.get(ctx, "a")
+	c.set(ctx, "c", &cachedResponse{Status: 200}, time.Minute)
+
+	if resp, _ := c.get(ctx, "b"); resp != nil {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	if resp, _ := c.get(ctx, "a"); resp == nil {
+		t.Error("Expected a recently used entry to be kept")
+	}
+
+	c.set(ctx, "d", &cachedResponse{Status: 200}, -time.Second)
+	if resp, _ := c.get(ctx, "d"); resp != nil {
+		t.Error("Expected an expired entry to be dropped")
+	}
+}
+
+func TestResponseCacheMiddleware(t *testing.T) {
+	cache, err := newResponseCache(CacheConfig{
+		Backend:       "memory",
+		MaxEntries:    10,
+		MaxBytes:      1 << 20,
+		MaxEntryBytes: 1 << 10,
+		Routes:        []CacheRouteConfig{{Path: "/providers/{name}/models", TTLSeconds: 60}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	handler := cache.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("private") != "" {
+			w.Header().Set("Cache-Control", "private")
+		}
+		w.Write([]byte(`{"models": []}`))
+	}))
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	first := get("/providers/openai/models", nil)
+	etag := first.Header().Get("ETag")
+	if first.Header().Get("X-Cache") != "MISS" || etag == "" || first.Header().Get("Cache-Control") != "max-age=60" {
+		t.Errorf("Unexpected first response headers: %v", first.Header())
+	}
+
+	second := get("/providers/openai/models", nil)
+	if second.Header().Get("X-Cache") != "HIT" || second.Body.String() != `{"models": []}` || calls != 1 {
+		t.Errorf("Expected a cache hit, got %v %q after %d calls", second.Header(), second.Body.String(), calls)
+	}
+
+	notModified := get("/providers/openai/models", http.Header{"If-None-Match": {etag}})
+	if notModified.Code != http.StatusNotModified || notModified.Body.Len() != 0 {
+		t.Errorf("Expected 304 for a matching ETag, got %d", notModified.Code)
+	}
+
+	get("/providers/openai/models", http.Header{"Cache-Control": {"no-cache"}})
+	if calls != 2 {
+		t.Errorf("Expected no-cache to revalidate, got %d calls", calls)
+	}
+
+	get("/providers/openai/models?private=1", nil)
+	get("/providers/openai/models?private=1", nil)
+	if calls != 4 {
+		t.Errorf("Expected private responses not to be cached, got %d calls", calls)
+	}
+
+	get("/errors", nil)
+	if w := get("/errors", nil); w.Header().Get("X-Cache") != "" || calls != 6 {
+		t.Errorf("Expected unconfigured routes not to be cached, got %d calls", calls)
+	}
+}
+
+func TestCacheConfigValidate(t *testing.T) {
+	invalid := []CacheConfig{
+		{Backend: "disk"},
+		{Backend: "redis", MaxEntries: 1, MaxEntryBytes: 1},
+		{Backend: "memory", MaxEntries: 0, MaxEntryBytes: 1},
+		{Backend: "memory", MaxEntries: 1, MaxEntryBytes: 1, Routes: []CacheRouteConfig{{Path: "/errors"}}},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", c)
+		}
+	}
+}
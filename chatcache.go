@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ChatCacheConfig configures the cache of chat completions.
+type ChatCacheConfig struct {
+	Backend     string `mapstructure:"backend" enum:"memory,redis" doc:"Where chat completions are cached; empty disables the cache"`
+	TTLSeconds  int    `mapstructure:"ttl_seconds" doc:"Seconds a completion is reused for an identical request"`
+	MaxEntries  int    `mapstructure:"max_entries" doc:"Most completions the memory backend keeps"`
+	MaxBytes    int64  `mapstructure:"max_bytes" doc:"Most bytes of completions the memory backend keeps"`
+	RedisURL    string `mapstructure:"redis_url" doc:"Redis URL for the redis backend, e.g. redis://localhost:6379/0"`
+	RedisPrefix string `mapstructure:"redis_prefix" doc:"Prefix of the Redis keys"`
+}
+
+func (c ChatCacheConfig) Validate() error {
+	switch c.Backend {
+	case "":
+		return nil
+	case "memory":
+		if c.MaxEntries <= 0 || c.MaxBytes <= 0 {
+			return errors.New("chat_cache.max_entries and chat_cache.max_bytes must be positive")
+		}
+	case "redis":
+		if c.RedisURL == "" {
+			return errors.New("chat_cache.redis_url is required for the redis backend")
+		}
+		if _, err := redis.ParseURL(c.RedisURL); err != nil {
+			return fmt.Errorf("chat_cache.redis_url: %w", err)
+		}
+	default:
+		return fmt.Errorf("chat_cache.backend: unknown backend %q", c.Backend)
+	}
+	if c.TTLSeconds <= 0 {
+		return errors.New("chat_cache.ttl_seconds must be positive")
+	}
+	return nil
+}
+
+// Cache outcomes reported in chat responses.
+const (
+	chatCacheHit  = "hit"
+	chatCacheMiss = "miss"
+)
+
+// chatCompletionCache keeps completions by the request they answer, in the
+// backends of the response cache.
+type chatCompletionCache struct {
+	backend cacheBackend
+	ttl     time.Duration
+}
+
+// chatCache is the chat completion cache, nil when disabled.
+var chatCache atomic.Pointer[chatCompletionCache]
+
+func newChatCompletionCache(c ChatCacheConfig) (*chatCompletionCache, error) {
+	cc := &chatCompletionCache{ttl: time.Duration(c.TTLSeconds) * time.Second}
+	switch c.Backend {
+	case "memory":
+		cc.backend = &memoryCache{store: newLRUStore("chat_cache", StoreLimits{MaxEntries: c.MaxEntries, MaxBytes: c.MaxBytes}, (*cachedResponse).size)}
+	case "redis":
+		opts, err := redis.ParseURL(c.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		cc.backend = &redisCache{client: redis.NewClient(opts), prefix: c.RedisPrefix}
+	default:
+		return nil, nil
+	}
+	return cc, nil
+}
+
+// chatCacheKey identifies a completion by the provider and everything sent
+// to it: model, messages, sampling parameters and functions.
+func chatCacheKey(req ChatRequest, cr openai.ChatCompletionRequest) string {
+	cr.Functions = req.functionDefinitions()
+	data, _ := json.Marshal(cr)
+	sum := sha256.Sum256(append([]byte(config.ChatProvider.Name+"\n"), data...))
+	return hex.EncodeToString(sum[:])
+}
+
+// get returns the cached completion for key. Cache failures are logged and
+// count as misses.
+func (c *chatCompletionCache) get(ctx context.Context, key string) (openai.ChatCompletionResponse, bool) {
+	var resp openai.ChatCompletionResponse
+	stored, err := c.backend.get(ctx, key)
+	if err != nil {
+		log.Printf("Failed to read chat cache: %v", err)
+	}
+	if stored == nil || time.Since(stored.Stored) >= c.ttl {
+		return resp, false
+	}
+	if err := json.Unmarshal(stored.Body, &resp); err != nil {
+		return resp, false
+	}
+	return resp, true
+}
+
+func (c *chatCompletionCache) set(ctx context.Context, key string, resp openai.ChatCompletionResponse) {
+	body, err := json.Marshal(resp)
+	if err == nil {
+		err = c.backend.set(ctx, key, &cachedResponse{Status: http.StatusOK, Body: body, Stored: time.Now()}, c.ttl)
+	}
+	if err != nil {
+		log.Printf("Failed to write chat cache: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestChatCache(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.ChatCache.Backend = "memory"
+	cache, err := newChatCompletionCache(config.ChatCache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chatCache.Store(cache)
+	defer chatCache.Store(nil)
+	echo := &echoProvider{}
+	ctx := context.Background()
+
+	first, err := completeChat(ctx, echo, "chat", ChatRequest{Message: "Hi"}, "gpt-4o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := completeChat(ctx, echo, "chat", ChatRequest{Message: "Hi"}, "gpt-4o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Cache != chatCacheMiss || second.Cache != chatCacheHit || second.Reply != "echo: Hi" || echo.requests != 1 {
+		t.Errorf("Expected the repeated prompt to be answered from the cache, got %+v then %+v after %d requests", first, second, echo.requests)
+	}
+
+	// Any difference in the request is a different entry
+	temperature := float32(0.2)
+	for _, req := range []ChatRequest{
+		{Message: "Hello"},
+		{Message: "Hi", Temperature: &temperature},
+		{Message: "Hi", Functions: []ChatFunction{{Name: "lookup"}}},
+	} {
+		if resp, _ := completeChat(ctx, echo, "chat", req, "gpt-4o"); resp.Cache != chatCacheMiss {
+			t.Errorf("Expected a miss for %+v, got %q", req, resp.Cache)
+		}
+	}
+	if resp, _ := completeChat(ctx, echo, "chat", ChatRequest{Message: "Hi"}, "gpt-4"); resp.Cache != chatCacheMiss || echo.requests != 5 {
+		t.Errorf("Expected another model to miss, got %q after %d requests", resp.Cache, echo.requests)
+	}
+
+	// Replies using server-side tools are not cached
+	for range 2 {
+		if resp, _ := completeChat(ctx, echo, "chat", ChatRequest{Message: "Hi", Tools: []string{"current_time"}}, "gpt-4o"); resp.Cache != "" {
+			t.Errorf("Expected no cache with tools, got %q", resp.Cache)
+		}
+	}
+	if echo.requests != 7 {
+		t.Errorf("Expected both tool requests to reach the provider, got %d requests", echo.requests)
+	}
+
+	if err := (ChatCacheConfig{Backend: "redis", TTLSeconds: 60}).Validate(); err == nil {
+		t.Error("Expected the redis backend to need a URL")
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// maxAttachmentBytes caps the size of a file attached to a chat message.
+const maxAttachmentBytes = 256 << 10
+
+// chatSession is a conversation saved between CLI runs.
+type chatSession struct {
+	Messages []ChatMessage `json:"messages"`
+}
+
+func loadChatSession(path string) (*chatSession, error) {
+	s := &chatSession{Messages: []ChatMessage{}}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("session %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *chatSession) save(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// withAttachments appends the contents of files to a message as fenced
+// blocks labeled with their names.
+func withAttachments(message string, files []string) (string, error) {
+	var b strings.Builder
+	b.WriteString(message)
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return "", err
+		}
+		if len(data) > maxAttachmentBytes {
+			return "", fmt.Errorf("attachment %s is larger than %d bytes", f, maxAttachmentBytes)
+		}
+		fmt.Fprintf(&b, "\n\nAttached file %s:\n```\n%s\n```", filepath.Base(f), strings.TrimRight(string(data), "\n"))
+	}
+	return b.String(), nil
+}
+
+// chatBackend streams a reply to a chat request, calling onToken for each
+// piece as it arrives.
+type chatBackend interface {
+	stream(ctx context.Context, req ChatRequest, onToken func(string)) error
+}
+
+// remoteChat talks to a running instance through POST /chat/stream.
+type remoteChat struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+func (c *remoteChat) stream(ctx context.Context, req ChatRequest, onToken func(string)) error {
+	body, _ := json.Marshal(req)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.baseURL, "/")+"/chat/stream", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	var event string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event = name
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		switch event {
+		case "token":
+			var t ChatStreamToken
+			if err := json.Unmarshal([]byte(data), &t); err != nil {
+				return err
+			}
+			onToken(t.Content)
+		case "error":
+			var e ChatStreamError
+			json.Unmarshal([]byte(data), &e)
+			return errors.New(e.Message)
+		case "done":
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("stream ended before the reply was complete")
+}
+
+// directChat talks to OpenAI with the local configuration.
+type directChat struct {
+	client *openai.Client
+}
+
+func (c *directChat) stream(ctx context.Context, req ChatRequest, onToken func(string)) error {
+	messages, err := chatCompletionMessages(ctx, req)
+	if err != nil {
+		return err
+	}
+	stream, err := c.client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
+		Model:    openai.GPT3Dot5Turbo,
+		Messages: messages,
+	})
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(resp.Choices) > 0 {
+			onToken(resp.Choices[0].Delta.Content)
+		}
+	}
+}
+
+// chatTurn sends one message with the session's history, prints the reply
+// as it streams and records both in the session.
+func chatTurn(ctx context.Context, backend chatBackend, session *chatSession, message string, out io.Writer) error {
+	var reply strings.Builder
+	err := backend.stream(ctx, ChatRequest{Message: message, History: session.Messages}, func(token string) {
+		reply.WriteString(token)
+		io.WriteString(out, token)
+	})
+	fmt.Fprintln(out)
+	if err != nil {
+		return err
+	}
+	session.Messages = append(session.Messages,
+		ChatMessage{Role: openai.ChatMessageRoleUser, Content: message},
+		ChatMessage{Role: openai.ChatMessageRoleAssistant, Content: reply.String()},
+	)
+	return nil
+}
+
+// runChatCommand implements the chat subcommand. With -m it sends one
+// message and exits; otherwise it reads messages from in until EOF or
+// /exit. With -output json each reply is printed as a {"reply": ...}
+// object once complete instead of streaming.
+func runChatCommand(args []string, in io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("chat", flag.ContinueOnError)
+	fs.SetOutput(out)
+	serverURL := fs.String("url", envOr("APP_URL", "http://localhost:8080"), "URL of a running instance (env APP_URL)")
+	token := fs.String("token", os.Getenv("APP_TOKEN"), "Bearer token sent to the API (env APP_TOKEN)")
+	direct := fs.Bool("direct", false, "Talk to OpenAI directly using the local configuration instead of a running instance")
+	sessionPath := fs.String("session", "", "File the conversation is loaded from and saved to")
+	message := fs.String("m", "", "Send this message and exit instead of starting an interactive chat")
+	format := outputFlag(fs, outputTable)
+	var attachments []string
+	fs.Func("attach", "Attach a text file to the first message (repeatable)", func(f string) error {
+		attachments = append(attachments, f)
+		return nil
+	})
+	if err := parseFlags(fs, args, format); err != nil {
+		return err
+	}
+
+	var backend chatBackend = &remoteChat{baseURL: *serverURL, token: *token, client: &http.Client{}}
+	if *direct {
+		initConfig()
+		initClients()
+		client, err := services.OpenAI()
+		if err != nil {
+			return errors.New("openai.key is not configured")
+		}
+		backend = &directChat{client: client}
+	}
+
+	session, err := loadChatSession(*sessionPath)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	send := func(text string) error {
+		if len(attachments) > 0 {
+			if text, err = withAttachments(text, attachments); err != nil {
+				return err
+			}
+			attachments = nil
+		}
+		if *format == outputJSON {
+			if err := chatTurn(ctx, backend, session, text, io.Discard); err != nil {
+				return err
+			}
+			if err := writeJSON(out, map[string]string{"reply": session.Messages[len(session.Messages)-1].Content}); err != nil {
+				return err
+			}
+		} else if err := chatTurn(ctx, backend, session, text, out); err != nil {
+			return err
+		}
+		return session.save(*sessionPath)
+	}
+	prompt := "> "
+	if *format == outputJSON {
+		prompt = ""
+	}
+
+	if *message != "" {
+		return send(*message)
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64<<10), maxAttachmentBytes)
+	for fmt.Fprint(out, prompt); scanner.Scan(); fmt.Fprint(out, prompt) {
+		switch text := strings.TrimSpace(scanner.Text()); text {
+		case "":
+		case "/exit", "/quit":
+			return nil
+		case "/reset":
+			session.Messages = []ChatMessage{}
+			if err := session.save(*sessionPath); err != nil {
+				return err
+			}
+			if *format == outputTable {
+				fmt.Fprintln(out, "Conversation cleared")
+			}
+		default:
+			if err := send(text); err != nil && *format == outputJSON {
+				writeJSON(out, map[string]string{"error": err.Error()})
+			} else if err != nil {
+				fmt.Fprintf(out, "Error: %v\n", err)
+			}
+		}
+	}
+	return scanner.Err()
+}
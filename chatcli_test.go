@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+func TestChatCommand(t *testing.T) {
+	viper.Reset()
+	initConfig()
+
+	var received []openai.ChatCompletionMessage
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		received = req.Messages
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range []string{"Hel", "lo"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+	services.SetOpenAI(newTestOpenAIClient(upstream.URL))
+	defer services.SetOpenAI(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerChatStreamEndpoint(api)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	dir := t.TempDir()
+	session := filepath.Join(dir, "session.json")
+	notes := filepath.Join(dir, "notes.txt")
+	os.WriteFile(notes, []byte("ship on friday\n"), 0o600)
+
+	var out bytes.Buffer
+	err := runChatCommand([]string{"-url", server.URL, "-session", session, "-attach", notes, "-m", "Summarize"}, nil, &out)
+	if err != nil {
+		t.Fatalf("Chat failed: %v", err)
+	}
+	if out.String() != "Hello\n" {
+		t.Errorf("Expected the streamed reply, got %q", out.String())
+	}
+	if len(received) != 1 || !strings.Contains(received[0].Content, "Attached file notes.txt:\n```\nship on friday\n```") {
+		t.Errorf("Expected the attachment in the message, got %+v", received)
+	}
+
+	out.Reset()
+	err = runChatCommand([]string{"-url", server.URL, "-session", session}, strings.NewReader("And then?\n/exit\nignored\n"), &out)
+	if err != nil {
+		t.Fatalf("Interactive chat failed: %v", err)
+	}
+	if len(received) != 3 || received[1].Role != openai.ChatMessageRoleAssistant || received[2].Content != "And then?" {
+		t.Errorf("Expected the saved conversation to be sent as history, got %+v", received)
+	}
+
+	saved, err := loadChatSession(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Messages) != 4 || saved.Messages[3].Content != "Hello" {
+		t.Errorf("Expected both turns to be saved, got %+v", saved.Messages)
+	}
+}
+
+func TestRemoteChatError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, errOpenAINotConfigured())
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := runChatCommand([]string{"-url", server.URL, "-m", "Hi"}, nil, &out)
+	if err == nil || !strings.Contains(err.Error(), "503") || exitCode(err) != exitUnavailable {
+		t.Errorf("Expected the server's error to be reported as unavailable, got %v", err)
+	}
+}
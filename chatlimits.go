@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// Chat modes limited separately. Streams hold a connection and a
+// concurrency slot for the whole reply, so they get limits of their own.
+const (
+	chatModeNonStreaming = "non_streaming"
+	chatModeStreaming    = "streaming"
+)
+
+// ChatLimitsConfig limits streaming and non-streaming chat separately, so
+// one mode cannot exhaust the instance for the other.
+type ChatLimitsConfig struct {
+	NonStreaming ChatModeLimits `mapstructure:"non_streaming" doc:"Limits of POST /chat and POST /conversations/{id}/messages"`
+	Streaming    ChatModeLimits `mapstructure:"streaming" doc:"Limits of POST /chat/stream"`
+}
+
+// ChatModeLimits limits one chat mode on this instance.
+type ChatModeLimits struct {
+	RequestsPerMinute int `mapstructure:"requests_per_minute" doc:"Sustained request rate; 0 means unlimited"`
+	MaxConcurrent     int `mapstructure:"max_concurrent" doc:"Most requests in progress at once; 0 means unlimited"`
+	TimeoutSeconds    int `mapstructure:"timeout_seconds" doc:"Seconds a request may take, for streams until the last token; 0 means no limit"`
+}
+
+func (c ChatLimitsConfig) Validate() error {
+	for mode, l := range map[string]ChatModeLimits{chatModeNonStreaming: c.NonStreaming, chatModeStreaming: c.Streaming} {
+		if l.RequestsPerMinute < 0 || l.MaxConcurrent < 0 || l.TimeoutSeconds < 0 {
+			return fmt.Errorf("chat_limits.%s: limits must not be negative", mode)
+		}
+	}
+	return nil
+}
+
+func (c ChatLimitsConfig) mode(mode string) ChatModeLimits {
+	if mode == chatModeStreaming {
+		return c.Streaming
+	}
+	return c.NonStreaming
+}
+
+// chatModeState tracks one mode's request rate and requests in progress.
+type chatModeState struct {
+	allowance float64
+	lastCheck time.Time
+	active    int
+}
+
+// chatGovernor enforces the chat limits of this instance.
+type chatGovernor struct {
+	mu    sync.Mutex
+	modes map[string]*chatModeState
+}
+
+var chatLimits = &chatGovernor{modes: map[string]*chatModeState{}}
+
+// admit takes one request of mode from its rate limit and a concurrency
+// slot. The returned release frees the slot and must be called once the
+// request is done.
+func (g *chatGovernor) admit(mode string, now time.Time) (release func(), err error) {
+	l := config.ChatLimits.mode(mode)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := g.modes[mode]
+	if s == nil {
+		s = &chatModeState{lastCheck: now, allowance: math.Inf(1)}
+		g.modes[mode] = s
+	}
+	if l.MaxConcurrent > 0 && s.active >= l.MaxConcurrent {
+		return nil, huma.Error503ServiceUnavailable(fmt.Sprintf("Too many %s chats in progress; retry later", modeName(mode)))
+	}
+	if l.RequestsPerMinute > 0 {
+		// Token bucket holding up to one minute of requests
+		limit := float64(l.RequestsPerMinute)
+		s.allowance = math.Min(limit, s.allowance+now.Sub(s.lastCheck).Minutes()*limit)
+		s.lastCheck = now
+		if s.allowance < 1 {
+			return nil, huma.Error429TooManyRequests(fmt.Sprintf("More than %d %s chats per minute; retry later", l.RequestsPerMinute, modeName(mode)))
+		}
+		s.allowance--
+	}
+	s.active++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			s.active--
+		})
+	}, nil
+}
+
+func modeName(mode string) string {
+	if mode == chatModeStreaming {
+		return "streaming"
+	}
+	return "non-streaming"
+}
+
+// withChatTimeout bounds ctx by the timeout of mode.
+func withChatTimeout(ctx context.Context, mode string) (context.Context, context.CancelFunc) {
+	if s := config.ChatLimits.mode(mode).TimeoutSeconds; s > 0 {
+		return context.WithTimeout(ctx, time.Duration(s)*time.Second)
+	}
+	return context.WithCancel(ctx)
+}
+
+func errChatTimeout(mode string) error {
+	return huma.Error504GatewayTimeout(fmt.Sprintf("The %s chat took longer than %d seconds", modeName(mode), config.ChatLimits.mode(mode).TimeoutSeconds))
+}
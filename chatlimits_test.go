@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func resetChatLimits(t *testing.T) {
+	reset := func() { chatLimits = &chatGovernor{modes: map[string]*chatModeState{}} }
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestChatGovernor(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	resetChatLimits(t)
+	config.ChatLimits.Streaming = ChatModeLimits{MaxConcurrent: 1}
+	config.ChatLimits.NonStreaming = ChatModeLimits{RequestsPerMinute: 2}
+	now := time.Now()
+
+	release, err := chatLimits.admit(chatModeStreaming, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chatLimits.admit(chatModeStreaming, now); err == nil || !strings.Contains(err.Error(), "streaming chats in progress") {
+		t.Errorf("Expected a second stream to be refused, got %v", err)
+	}
+	// Streams in progress don't hold up non-streaming chat
+	for range 2 {
+		r, err := chatLimits.admit(chatModeNonStreaming, now)
+		if err != nil {
+			t.Fatalf("Expected non-streaming chat to be admitted, got %v", err)
+		}
+		r()
+	}
+	if _, err := chatLimits.admit(chatModeNonStreaming, now); err == nil || err.(huma.StatusError).GetStatus() != http.StatusTooManyRequests {
+		t.Errorf("Expected the third chat in a minute to be rate limited, got %v", err)
+	}
+	if r, err := chatLimits.admit(chatModeNonStreaming, now.Add(30*time.Second)); err != nil {
+		t.Errorf("Expected the rate limit to refill, got %v", err)
+	} else {
+		r()
+	}
+
+	release()
+	release()
+	if r, err := chatLimits.admit(chatModeStreaming, now); err != nil {
+		t.Errorf("Expected the released slot to be free, got %v", err)
+	} else {
+		r()
+	}
+	if n := chatLimits.modes[chatModeStreaming].active; n != 0 {
+		t.Errorf("Expected no streams in progress, got %d", n)
+	}
+
+	config.ChatLimits.Streaming.TimeoutSeconds = -1
+	if err := config.ChatLimits.Validate(); err == nil {
+		t.Error("Expected a negative timeout to be rejected")
+	}
+}
+
+func TestChatTimeouts(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	resetChatLimits(t)
+	services.SetOpenAI(newFakeOpenAIClient(2 * time.Second))
+	defer services.SetOpenAI(nil)
+	config.ChatLimits.NonStreaming.TimeoutSeconds = 1
+	config.ChatLimits.Streaming.TimeoutSeconds = 1
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerChatEndpoint(api)
+	registerChatStreamEndpoint(api)
+
+	for _, path := range []string{"/chat", "/chat/stream"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"message": "Hi"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusGatewayTimeout || !strings.Contains(w.Body.String(), "longer than 1 seconds") {
+			t.Errorf("Expected %s to time out, got %d: %s", path, w.Code, w.Body.String())
+		}
+	}
+	for mode, s := range chatLimits.modes {
+		if s.active != 0 {
+			t.Errorf("Expected the %s slot to be released, got %d", mode, s.active)
+		}
+	}
+}
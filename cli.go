@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+)
+
+// Exit codes of the subcommands. Scripts and CI pipelines depend on them,
+// so a code must never change meaning once released.
+const (
+	exitOK          = 0
+	exitFailure     = 1 // the command ran and failed
+	exitUsage       = 2 // invalid arguments or flags
+	exitUnavailable = 3 // the server could not be reached or is unavailable
+	exitDegraded    = 4 // the server answered but a dependency is degraded
+)
+
+// Output formats accepted by -output.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// exitError is an error that ends the command with a specific exit code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func usageError(format string, args ...any) error {
+	return &exitError{code: exitUsage, err: fmt.Errorf(format, args...)}
+}
+
+// exitCode maps the error a subcommand returned to its exit code.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return exitUnavailable
+	}
+	return exitFailure
+}
+
+// runCommand runs the subcommand name instead of the server and returns the
+// process exit code.
+func runCommand(name string, args []string, in io.Reader, out, errOut io.Writer) int {
+	var err error
+	switch name {
+	case "openapi":
+		// Print the OpenAPI spec for the SDK build
+		err = runOpenAPICommand(args, out)
+	case "config":
+		// Print the config JSON Schema or an example config.yaml
+		err = runConfigCommand(args, out)
+	case "chat":
+		// Chat with a running instance or directly with OpenAI
+		err = runChatCommand(args, in, out)
+	case "sync":
+		// Mirror a local directory to a bucket or back
+		err = runSyncCommand(args, out)
+	case "loadtest":
+		// Drive a running instance at a fixed rate and report latencies
+		err = runLoadTestCommand(args, out)
+	case "healthcheck":
+		// Check the health of a running instance
+		err = runHealthcheckCommand(args, out)
+	case "service":
+		// Install and control the Windows service
+		err = runServiceCommand(args, out)
+	case "verify-events":
+		// Check the event log's hash chain for tampering
+		err = runVerifyEventsCommand(args, out)
+	case "genkey", "encrypt":
+		// Manage encrypted config values
+		err = runConfigKeyCommand(name, args, in, out)
+	default:
+		err = usageError("unknown command %q", name)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		fmt.Fprintf(errOut, "Error: %v\n", err)
+	}
+	return exitCode(err)
+}
+
+// parseFlags parses a subcommand's flags, including -output when format is
+// not nil. Flag errors are usage errors; -help returns flag.ErrHelp.
+func parseFlags(fs *flag.FlagSet, args []string, format *string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &exitError{code: exitUsage, err: err}
+	}
+	if format != nil && *format != outputTable && *format != outputJSON {
+		return usageError("-output must be %s or %s, not %q", outputTable, outputJSON, *format)
+	}
+	return nil
+}
+
+// outputFlag registers -output on fs.
+func outputFlag(fs *flag.FlagSet, def string) *string {
+	return fs.String("output", def, "Output format: table or json")
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// newTable returns a writer that aligns tab-separated columns; callers must
+// Flush it.
+func newTable(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+}
+
+// envOr returns the environment variable key, or fallback when it is unset.
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// responseError turns an error response of the API into an error carrying
+// the problem's detail. Gateway and availability errors exit with
+// exitUnavailable.
+func responseError(resp *http.Response) error {
+	var problem struct {
+		Detail string `json:"detail"`
+	}
+	json.NewDecoder(resp.Body).Decode(&problem)
+	err := fmt.Errorf("server returned %s", resp.Status)
+	if problem.Detail != "" {
+		err = fmt.Errorf("server returned %s: %s", resp.Status, problem.Detail)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return &exitError{code: exitUnavailable, err: err}
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	_, connErr := http.Get("http://127.0.0.1:1/health")
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{nil, exitOK},
+		{errors.New("boom"), exitFailure},
+		{usageError("bad flag"), exitUsage},
+		{fmt.Errorf("listing: %w", connErr), exitUnavailable},
+		{&exitError{code: exitDegraded, err: errors.New("degraded")}, exitDegraded},
+	}
+	for _, c := range cases {
+		if got := exitCode(c.err); got != c.want {
+			t.Errorf("exitCode(%v) = %d, want %d", c.err, got, c.want)
+		}
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	var out, errOut bytes.Buffer
+	if code := runCommand("frobnicate", nil, nil, &out, &errOut); code != exitUsage || !strings.Contains(errOut.String(), "unknown command") {
+		t.Errorf("Expected an unknown command to exit %d, got %d: %q", exitUsage, code, errOut.String())
+	}
+	if code := runCommand("config", []string{"schema", "-output", "xml"}, nil, &out, &errOut); code != exitUsage {
+		t.Errorf("Expected an invalid -output to exit %d, got %d", exitUsage, code)
+	}
+
+	if code := runCommand("service", nil, nil, &out, &errOut); code != exitUsage {
+		t.Errorf("Expected service without an action to exit %d, got %d", exitUsage, code)
+	}
+	if code := runCommand("service", []string{"install"}, nil, &out, &errOut); runtime.GOOS != "windows" && code != exitFailure {
+		t.Errorf("Expected service install to fail outside Windows, got %d", code)
+	}
+
+	out.Reset()
+	if code := runCommand("config", []string{"schema", "-output", "table"}, nil, &out, &errOut); code != exitOK {
+		t.Fatalf("config schema failed with %d: %s", code, errOut.String())
+	}
+	if !strings.Contains(out.String(), "server.port") || !strings.Contains(out.String(), `"8080"`) {
+		t.Errorf("Expected a row per setting with its default, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	if code := runCommand("openapi", []string{"--output", "table"}, nil, &out, &errOut); code != exitOK {
+		t.Fatalf("openapi failed with %d: %s", code, errOut.String())
+	}
+	if !strings.Contains(out.String(), "GET     /health") {
+		t.Errorf("Expected a row per operation, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	if code := runCommand("genkey", []string{"-output", "json"}, nil, &out, &errOut); code != exitOK {
+		t.Fatalf("genkey failed with %d", code)
+	}
+	var key struct{ Key string }
+	if err := json.Unmarshal(out.Bytes(), &key); err != nil || len(key.Key) != 44 {
+		t.Errorf("Expected a JSON object with a base64 key, got %q", out.String())
+	}
+}
+
+func TestHealthcheckCommand(t *testing.T) {
+	health := `{"status": "healthy", "services": {"openai": true, "minio": false}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, health)
+	}))
+	defer server.Close()
+
+	var out, errOut bytes.Buffer
+	if code := runCommand("healthcheck", []string{"-url", server.URL}, nil, &out, &errOut); code != exitOK {
+		t.Errorf("Expected a healthy instance to exit 0, got %d: %s", code, errOut.String())
+	}
+	if !strings.Contains(out.String(), "minio   not configured") {
+		t.Errorf("Expected a table of services, got:\n%s", out.String())
+	}
+
+	if code := runCommand("healthcheck", []string{"-url", server.URL, "-require", "openai,minio"}, nil, &out, &errOut); code != exitDegraded {
+		t.Errorf("Expected a missing required service to exit %d, got %d", exitDegraded, code)
+	}
+
+	health = `{"status": "degraded", "services": {"openai": true, "minio": true}, "keys": [{"provider": "openai", "valid": false}]}`
+	out.Reset()
+	if code := runCommand("healthcheck", []string{"-url", server.URL, "-output", "json"}, nil, &out, &errOut); code != exitDegraded {
+		t.Errorf("Expected a degraded instance to exit %d, got %d", exitDegraded, code)
+	}
+	var report struct {
+		Status   string
+		Problems []string
+	}
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil || report.Status != "degraded" || len(report.Problems) != 2 {
+		t.Errorf("Expected a JSON report listing the problems, got %q", out.String())
+	}
+
+	server.Close()
+	if code := runCommand("healthcheck", []string{"-url", server.URL}, nil, &out, &errOut); code != exitUnavailable {
+		t.Errorf("Expected an unreachable instance to exit %d, got %d", exitUnavailable, code)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// collectionsPrefix is where collection manifests live in the system bucket.
+const collectionsPrefix = "collections/"
+
+// collectionsMu serializes read-modify-write updates of collection manifests.
+var collectionsMu sync.Mutex
+
+type CollectionDocument struct {
+	Bucket string `json:"bucket" minLength:"1" doc:"MinIO bucket name"`
+	Name   string `json:"name" minLength:"1" doc:"Object name"`
+}
+
+type Collection struct {
+	Name        string               `json:"name" doc:"Collection name"`
+	Description string               `json:"description,omitempty" doc:"What the collection contains"`
+	Documents   []CollectionDocument `json:"documents" doc:"Documents in the collection"`
+	CreatedAt   time.Time            `json:"created_at" doc:"Creation time"`
+	UpdatedAt   time.Time            `json:"updated_at" doc:"Last modification time"`
+}
+
+type CollectionCreateRequest struct {
+	Name        string `json:"name" pattern:"^[a-z0-9][a-z0-9_-]{0,62}$" doc:"Collection name (lowercase letters, digits, - and _)"`
+	Description string `json:"description,omitempty" maxLength:"1024" doc:"What the collection contains"`
+}
+
+type CollectionListResponse struct {
+	Collections []Collection `json:"collections" doc:"All collections"`
+}
+
+func collectionKey(name string) string {
+	return collectionsPrefix + name + ".json"
+}
+
+// loadCollection reads a collection manifest from the system bucket.
+func loadCollection(ctx context.Context, name string) (*Collection, error) {
+	var c Collection
+	if err := getJSON(ctx, config.MinIO.SystemBucket, collectionKey(name), &c); err != nil {
+		if isNotFound(err) {
+			return nil, huma.Error404NotFound(fmt.Sprintf("Collection %s not found", name))
+		}
+		return nil, huma.Error500InternalServerError("Failed to load collection", err)
+	}
+	return &c, nil
+}
+
+// updateCollection applies fn to a collection manifest and saves it.
+func updateCollection(ctx context.Context, name string, fn func(*Collection) error) (*Collection, error) {
+	collectionsMu.Lock()
+	defer collectionsMu.Unlock()
+
+	c, err := loadCollection(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(c); err != nil {
+		return nil, err
+	}
+	c.UpdatedAt = time.Now().UTC()
+	if err := putJSON(ctx, config.MinIO.SystemBucket, collectionKey(name), c); err != nil {
+		return nil, huma.Error500InternalServerError("Failed to save collection", err)
+	}
+	return c, nil
+}
+
+// hasDocument reports whether the collection already contains doc.
+func (c *Collection) hasDocument(doc CollectionDocument) bool {
+	for _, d := range c.Documents {
+		if d == doc {
+			return true
+		}
+	}
+	return false
+}
+
+func registerCollectionEndpoints(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID:   "create-collection",
+		Method:        http.MethodPost,
+		Path:          "/collections",
+		Summary:       "Create a collection",
+		Description:   "Create a named knowledge base collection that documents can be grouped into",
+		DefaultStatus: http.StatusCreated,
+	}, func(ctx context.Context, input *struct {
+		Body CollectionCreateRequest
+	}) (*struct {
+		Body Collection
+	}, error) {
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+
+		collectionsMu.Lock()
+		defer collectionsMu.Unlock()
+
+		if err := ensureBucket(ctx, config.MinIO.SystemBucket); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to create system bucket", err)
+		}
+		key := collectionKey(input.Body.Name)
+		if _, err := client.StatObject(ctx, config.MinIO.SystemBucket, key, minio.StatObjectOptions{}); err == nil {
+			return nil, huma.Error409Conflict(fmt.Sprintf("Collection %s already exists", input.Body.Name))
+		} else if !isNotFound(err) {
+			return nil, huma.Error500InternalServerError("Failed to check collection existence", err)
+		}
+
+		now := time.Now().UTC()
+		c := Collection{
+			Name:        input.Body.Name,
+			Description: input.Body.Description,
+			Documents:   []CollectionDocument{},
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		if err := putJSON(ctx, config.MinIO.SystemBucket, key, c); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to save collection", err)
+		}
+
+		return &struct {
+			Body Collection
+		}{
+			Body: c,
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "list-collections",
+		Method:      http.MethodGet,
+		Path:        "/collections",
+		Summary:     "List collections",
+		Description: "List all knowledge base collections and their documents",
+	}, func(ctx context.Context, input *struct{}) (*struct {
+		Body CollectionListResponse
+	}, error) {
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+
+		collections := []Collection{}
+		for obj := range client.ListObjects(ctx, config.MinIO.SystemBucket, minio.ListObjectsOptions{Prefix: collectionsPrefix}) {
+			if obj.Err != nil {
+				// No system bucket yet means no collections
+				if isNotFound(obj.Err) {
+					break
+				}
+				return nil, huma.Error500InternalServerError("Failed to list collections", obj.Err)
+			}
+			c, err := loadCollection(ctx, strings.TrimSuffix(strings.TrimPrefix(obj.Key, collectionsPrefix), ".json"))
+			if err != nil {
+				return nil, err
+			}
+			collections = append(collections, *c)
+		}
+		sort.Slice(collections, func(i, j int) bool { return collections[i].Name < collections[j].Name })
+
+		return &struct {
+			Body CollectionListResponse
+		}{
+			Body: CollectionListResponse{Collections: collections},
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-collection",
+		Method:      http.MethodGet,
+		Path:        "/collections/{collection}",
+		Summary:     "Get a collection",
+		Description: "Get a knowledge base collection and its documents",
+	}, func(ctx context.Context, input *struct {
+		Collection string `path:"collection" doc:"Collection name"`
+	}) (*struct {
+		Body Collection
+	}, error) {
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+
+		c, err := loadCollection(ctx, input.Collection)
+		if err != nil {
+			return nil, err
+		}
+
+		return &struct {
+			Body Collection
+		}{
+			Body: *c,
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID:   "delete-collection",
+		Method:        http.MethodDelete,
+		Path:          "/collections/{collection}",
+		Summary:       "Delete a collection",
+		Description:   "Delete a knowledge base collection. The documents themselves are left untouched",
+		DefaultStatus: http.StatusNoContent,
+	}, func(ctx context.Context, input *struct {
+		Collection string `path:"collection" doc:"Collection name"`
+	}) (*struct{}, error) {
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+
+		collectionsMu.Lock()
+		defer collectionsMu.Unlock()
+
+		if _, err := loadCollection(ctx, input.Collection); err != nil {
+			return nil, err
+		}
+		if err := client.RemoveObject(ctx, config.MinIO.SystemBucket, collectionKey(input.Collection), minio.RemoveObjectOptions{}); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to delete collection", err)
+		}
+		return nil, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "add-collection-document",
+		Method:      http.MethodPost,
+		Path:        "/collections/{collection}/documents",
+		Summary:     "Add a document to a collection",
+		Description: "Add an existing object to a knowledge base collection",
+	}, func(ctx context.Context, input *struct {
+		Collection    string `path:"collection" doc:"Collection name"`
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token; objects with an ACL are only added for callers it allows"`
+		Body          CollectionDocument
+	}) (*struct {
+		Body Collection
+	}, error) {
+		doc := input.Body
+		if err := checkBucketAccess(doc.Bucket); err != nil {
+			return nil, err
+		}
+
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkObjectACL(ctx, doc.Bucket, doc.Name, objectCaller(input.Authorization)); err != nil {
+			return nil, err
+		}
+		if _, err := client.StatObject(ctx, doc.Bucket, doc.Name, minio.StatObjectOptions{}); err != nil {
+			if isNotFound(err) {
+				return nil, errObjectNotFound(doc.Bucket, doc.Name)
+			}
+			return nil, huma.Error500InternalServerError("Failed to stat object", err)
+		}
+
+		c, err := updateCollection(ctx, input.Collection, func(c *Collection) error {
+			if !c.hasDocument(doc) {
+				c.Documents = append(c.Documents, doc)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return &struct {
+			Body Collection
+		}{
+			Body: *c,
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "remove-collection-document",
+		Method:      http.MethodDelete,
+		Path:        "/collections/{collection}/documents/{bucket}/{name}",
+		Summary:     "Remove a document from a collection",
+		Description: "Remove an object from a knowledge base collection. The object itself is left untouched",
+	}, func(ctx context.Context, input *struct {
+		Collection string `path:"collection" doc:"Collection name"`
+		Bucket     string `path:"bucket" doc:"MinIO bucket name"`
+		Name       string `path:"name" doc:"Object name (URL-encoded if it contains slashes)"`
+	}) (*struct {
+		Body Collection
+	}, error) {
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+
+		name, err := decodeObjectKey(input.Name)
+		if err != nil {
+			return nil, err
+		}
+		doc := CollectionDocument{Bucket: input.Bucket, Name: name}
+
+		c, err := updateCollection(ctx, input.Collection, func(c *Collection) error {
+			for i, d := range c.Documents {
+				if d == doc {
+					c.Documents = append(c.Documents[:i], c.Documents[i+1:]...)
+					return nil
+				}
+			}
+			return huma.Error404NotFound(fmt.Sprintf("Object %s in bucket %s is not part of collection %s", name, input.Bucket, input.Collection))
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return &struct {
+			Body Collection
+		}{
+			Body: *c,
+		}, nil
+	})
+}
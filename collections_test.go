@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestCollectionEndpointsWithoutClient(t *testing.T) {
+	// Initialize config for testing
+	viper.Reset()
+	initConfig()
+
+	// Ensure MinIO client is not initialized
+	services.SetMinIO(nil)
+
+	// Create test router and API
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+
+	// Register collection endpoints
+	registerCollectionEndpoints(api)
+
+	createBody, _ := json.Marshal(CollectionCreateRequest{Name: "handbook"})
+	addBody, _ := json.Marshal(CollectionDocument{Bucket: "docs", Name: "policy.md"})
+	requests := []struct {
+		method string
+		path   string
+		body   []byte
+	}{
+		{"POST", "/collections", createBody},
+		{"GET", "/collections", nil},
+		{"GET", "/collections/handbook", nil},
+		{"DELETE", "/collections/handbook", nil},
+		{"POST", "/collections/handbook/documents", addBody},
+		{"DELETE", "/collections/handbook/documents/docs/policy.md", nil},
+	}
+
+	for _, r := range requests {
+		req := httptest.NewRequest(r.method, r.path, bytes.NewBuffer(r.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		// Should return 503 Service Unavailable since MinIO client is not configured
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s %s: expected status code 503, got %d", r.method, r.path, w.Code)
+		}
+	}
+}
+
+func TestCollectionNameValidation(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerCollectionEndpoints(api)
+
+	jsonBody, _ := json.Marshal(CollectionCreateRequest{Name: "Not Valid!"})
+	req := httptest.NewRequest("POST", "/collections", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Schema validation runs before the handler
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status code 422, got %d", w.Code)
+	}
+}
+
+func TestAddCollectionDocumentAccess(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Storage.Tenants = []StorageTenantConfig{{Name: "acme", Token: "acme-token", Bucket: "tenants"}}
+	defer func() { config.Storage.Tenants = nil }()
+
+	objects := map[string]string{
+		"docs/policy.md":                   "Leave policy",
+		"docs/salaries.md":                 "Salaries",
+		"app-system/acls/docs.json":        `{"salaries.md": {"visibility": "private", "owner": "globex"}}`,
+		"app-system/collections/hr.json":   `{"name": "hr", "documents": []}`,
+		"app-system/policies/default.json": `{}`,
+	}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerCollectionEndpoints(api)
+
+	tests := []struct {
+		doc      CollectionDocument
+		token    string
+		expected int
+	}{
+		{CollectionDocument{Bucket: "app-system", Name: "policies/default.json"}, "acme-token", http.StatusForbidden},
+		{CollectionDocument{Bucket: "docs", Name: "salaries.md"}, "acme-token", http.StatusForbidden},
+		{CollectionDocument{Bucket: "docs", Name: "salaries.md"}, "", http.StatusUnauthorized},
+		{CollectionDocument{Bucket: "docs", Name: "missing.md"}, "acme-token", http.StatusNotFound},
+		{CollectionDocument{Bucket: "docs", Name: "policy.md"}, "acme-token", http.StatusOK},
+	}
+	for _, tt := range tests {
+		body, _ := json.Marshal(tt.doc)
+		req := httptest.NewRequest(http.MethodPost, "/collections/hr/documents", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.expected {
+			t.Errorf("%s/%s: expected status code %d, got %d: %s", tt.doc.Bucket, tt.doc.Name, tt.expected, w.Code, w.Body.String())
+		}
+	}
+
+	var c Collection
+	json.Unmarshal([]byte(objects["app-system/collections/hr.json"]), &c)
+	if len(c.Documents) != 1 || c.Documents[0].Name != "policy.md" {
+		t.Errorf("Expected only the readable document to be added, got %+v", c.Documents)
+	}
+}
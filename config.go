@@ -0,0 +1,583 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Config structure for our application. Each section belongs to one
+// subsystem and validates its own settings.
+type Config struct {
+	Server          ServerConfig          `mapstructure:"server" doc:"HTTP server"`
+	OpenAI          OpenAIConfig          `mapstructure:"openai" doc:"OpenAI client"`
+	MinIO           MinIOConfig           `mapstructure:"minio" doc:"MinIO storage"`
+	Auth            AuthConfig            `mapstructure:"auth" doc:"Credentials for admin, webhook and Vault access"`
+	Limits          LimitsConfig          `mapstructure:"limits" doc:"Size limits of documents the endpoints handle"`
+	Jobs            JobsConfig            `mapstructure:"jobs" doc:"Background jobs"`
+	Cache           CacheConfig           `mapstructure:"cache" doc:"Response cache for GET endpoints"`
+	Stores          StoresConfig          `mapstructure:"stores" doc:"Limits of in-memory stores"`
+	Proxy           ProxyConfig           `mapstructure:"proxy" doc:"OpenAI passthrough for internal teams"`
+	Storage         StorageConfig         `mapstructure:"storage" doc:"Temporary storage credentials for trusted clients"`
+	Sites           SitesConfig           `mapstructure:"sites" doc:"Static websites published from bucket prefixes"`
+	Gallery         GalleryConfig         `mapstructure:"gallery" doc:"Prompt templates, agents and example collections installed on first boot"`
+	Alerts          AlertsConfig          `mapstructure:"alerts" doc:"Alert channels"`
+	Monitoring      MonitoringConfig      `mapstructure:"monitoring" doc:"Usage anomaly detection and SLOs"`
+	PromptLog       PromptLogConfig       `mapstructure:"prompt_log" doc:"Redacted logging of prompts and replies"`
+	Compliance      ComplianceConfig      `mapstructure:"compliance" doc:"Data subject requests"`
+	Retention       RetentionConfig       `mapstructure:"retention" doc:"Retention periods of stored data"`
+	Takeout         TakeoutConfig         `mapstructure:"takeout" doc:"Data subject exports"`
+	Terms           TermsConfig           `mapstructure:"terms" doc:"Terms acceptance required for chat"`
+	OutputFilter    OutputFilterConfig    `mapstructure:"output_filter" doc:"Moderation and rules applied to generated content"`
+	Conversations   ConversationsConfig   `mapstructure:"conversations" doc:"Server-side chat sessions"`
+	Provenance      ProvenanceConfig      `mapstructure:"provenance" doc:"Marking of generated content"`
+	ChatLimits      ChatLimitsConfig      `mapstructure:"chat_limits" doc:"Rate, concurrency and time limits of streaming and non-streaming chat"`
+	Streams         StreamsConfig         `mapstructure:"streams" doc:"Heartbeats and idle timeouts of event streams"`
+	Embeddings      EmbeddingsConfig      `mapstructure:"embeddings" doc:"Limits of POST /embeddings"`
+	Images          ImagesConfig          `mapstructure:"images" doc:"Storage of images generated with POST /images"`
+	DiskCache       DiskCacheConfig       `mapstructure:"disk_cache" doc:"Local disk cache of objects read by previews, renders and text reads"`
+	ChatTools       ChatToolsConfig       `mapstructure:"chat_tools" doc:"Tool loop of POST /chat"`
+	HLS             HLSConfig             `mapstructure:"hls" doc:"Packaging of stored audio and video for HLS playback"`
+	InputModeration InputModerationConfig `mapstructure:"input_moderation" doc:"Moderation of chat messages before they are sent to the model"`
+	OpenAIRetry     OpenAIRetryConfig     `mapstructure:"openai_retry" doc:"Retries of chat completions that fail transiently"`
+	Approvals       ApprovalsConfig       `mapstructure:"approvals" doc:"Two-person approval of dangerous admin actions"`
+	EventLog        EventLogConfig        `mapstructure:"event_log" doc:"Hash-chained log of audit events in the system bucket"`
+	ChatProvider    ChatProviderConfig    `mapstructure:"chat_provider" doc:"Provider POST /chat is sent to"`
+	ChatCache       ChatCacheConfig       `mapstructure:"chat_cache" doc:"Cache of chat completions for identical requests"`
+	OPA             OPAConfig             `mapstructure:"opa" doc:"Authorization decisions by Open Policy Agent"`
+	RequestSigning  RequestSigningConfig  `mapstructure:"request_signing" doc:"HMAC-signed requests as an alternative to bearer tokens"`
+	Warmup          WarmupConfig          `mapstructure:"warmup" doc:"Warming up connections and caches before reporting ready"`
+	Vision          VisionConfig          `mapstructure:"vision" doc:"Chats with image input"`
+	RateLimits      RateLimitsConfig      `mapstructure:"rate_limits" doc:"Request rate limits of the classes operations declare"`
+	Shadow          ShadowConfig          `mapstructure:"shadow" doc:"Mirroring requests to a canary instance"`
+	Rollout         RolloutConfig         `mapstructure:"rollout" doc:"Rolling a new default chat model out to a share of chats"`
+	Costs           CostsConfig           `mapstructure:"costs" doc:"OpenAI prices and daily cost budgets"`
+	FileChat        FileChatConfig        `mapstructure:"file_chat" doc:"Retrieval of document chunks for POST /chat/with-files"`
+}
+
+type ServerConfig struct {
+	Port                   string           `mapstructure:"port" doc:"HTTP port to listen on when no listeners are configured"`
+	Listeners              []ListenerConfig `mapstructure:"listeners" doc:"Addresses to serve the API on, each with its own middleware and TLS settings"`
+	SDKDir                 string           `mapstructure:"sdk_dir" doc:"Directory the generated client SDKs are served from"`
+	ShutdownTimeoutSeconds int              `mapstructure:"shutdown_timeout_seconds" doc:"Seconds in-flight requests get to finish when the service stops"`
+}
+
+type OpenAIConfig struct {
+	Key                       string   `mapstructure:"key" doc:"OpenAI API key; chat features are disabled without it"`
+	StyleGuideBucket          string   `mapstructure:"style_guide_bucket" doc:"Bucket holding glossary and style guide documents"`
+	StyleGuideObjects         []string `mapstructure:"style_guide_objects" doc:"Style guide documents added to every generation request"`
+	SystemPrompt              string   `mapstructure:"system_prompt" doc:"System message sent before the conversation in every chat request"`
+	AllowSystemPromptOverride bool     `mapstructure:"allow_system_prompt_override" doc:"Let chat requests replace the system prompt with their own system_prompt"`
+	Fake                      bool     `mapstructure:"fake" doc:"Answer with canned replies instead of calling OpenAI, for load tests"`
+	FakeLatencyMS             int      `mapstructure:"fake_latency_ms" doc:"Milliseconds the fake provider waits before replying"`
+	AllowKeyHeader            bool     `mapstructure:"allow_key_header" doc:"Let requests call OpenAI with their own key sent in X-OpenAI-Key"`
+	AllowedModels             []string `mapstructure:"allowed_models" doc:"Models chat requests may use; any model when empty. Requests that name no model use the first"`
+}
+
+type MinIOConfig struct {
+	URL          string              `mapstructure:"url" doc:"MinIO endpoint (host:port)"`
+	UseSSL       bool                `mapstructure:"use_ssl" doc:"Connect to MinIO over HTTPS"`
+	Key          string              `mapstructure:"key" doc:"MinIO access key"`
+	Secret       string              `mapstructure:"secret" doc:"MinIO secret key"`
+	SystemBucket string              `mapstructure:"system_bucket" doc:"Bucket for internal state such as collections and sources"`
+	IngestBucket string              `mapstructure:"ingest_bucket" doc:"Bucket documents pushed to the ingest webhook are stored in"`
+	Failover     MinIOFailoverConfig `mapstructure:"failover" doc:"Replicated endpoints taking over when minio.url is down"`
+}
+
+type LimitsConfig struct {
+	MaxDiffBytes           int64 `mapstructure:"max_diff_bytes" doc:"Largest object that can be diffed"`
+	MaxEditBytes           int64 `mapstructure:"max_edit_bytes" doc:"Largest document sent to the model for editing"`
+	MaxRenderBytes         int64 `mapstructure:"max_render_bytes" doc:"Largest Markdown document that can be rendered"`
+	MaxPreviewBytes        int64 `mapstructure:"max_preview_bytes" doc:"How much of an image or PDF is read to build a preview"`
+	MaxPreviewPixels       int64 `mapstructure:"max_preview_pixels" doc:"Most pixels of an image that is decoded to build a preview"`
+	MaxIngestURLBytes      int64 `mapstructure:"max_ingest_url_bytes" doc:"Largest document fetched from an ingest webhook URL"`
+	MaxSourceDocumentBytes int64 `mapstructure:"max_source_document_bytes" doc:"Largest document pulled from a source"`
+	MaxSourceDocuments     int   `mapstructure:"max_source_documents" doc:"Most documents one source sync pulls"`
+	MaxUploadBytes         int64 `mapstructure:"max_upload_bytes" doc:"Largest file accepted by PUT /files/{bucket}/{name}"`
+	MaxAudioBytes          int64 `mapstructure:"max_audio_bytes" doc:"Largest audio file POST /transcribe sends to Whisper"`
+}
+
+type JobsConfig struct {
+	TrashRetentionDays int  `mapstructure:"trash_retention_days" doc:"Days trashed objects are kept before being purged; 0 keeps them forever"`
+	TrashDryRun        bool `mapstructure:"trash_dry_run" doc:"Only log what the hourly trash purge would delete"`
+	KeyProbeMinutes    int  `mapstructure:"key_probe_minutes" doc:"Minutes between OpenAI key health probes; 0 disables probing"`
+	LinkCheckMinutes   int  `mapstructure:"link_check_minutes" doc:"Minutes between link checks of published sites; 0 disables checking"`
+}
+
+// legacyConfigKeys maps the settings of the flat config layout to their
+// place in the nested one. Old keys keep working, from files and APP_
+// environment variables alike, but log a warning.
+var legacyConfigKeys = map[string]string{
+	"port":                     "server.port",
+	"sdk_dir":                  "server.sdk_dir",
+	"openai_key":               "openai.key",
+	"style_guide_bucket":       "openai.style_guide_bucket",
+	"style_guide_objects":      "openai.style_guide_objects",
+	"minio_url":                "minio.url",
+	"minio_key":                "minio.key",
+	"minio_secret":             "minio.secret",
+	"system_bucket":            "minio.system_bucket",
+	"ingest_bucket":            "minio.ingest_bucket",
+	"admin_token":              "auth.admin_token",
+	"ingest_tokens":            "auth.ingest_tokens",
+	"vault_addr":               "auth.vault.addr",
+	"vault_token":              "auth.vault.token",
+	"vault_secret_path":        "auth.vault.secret_path",
+	"vault_poll_minutes":       "auth.vault.poll_minutes",
+	"trash_retention_days":     "jobs.trash_retention_days",
+	"key_probe_minutes":        "jobs.key_probe_minutes",
+	"alert_webhook_url":        "alerts.webhook_url",
+	"alert_smtp_addr":          "alerts.smtp_addr",
+	"alert_smtp_username":      "alerts.smtp_username",
+	"alert_smtp_password":      "alerts.smtp_password",
+	"alert_email_from":         "alerts.email_from",
+	"alert_email_to":           "alerts.email_to",
+	"anomaly_window_minutes":   "monitoring.anomaly.window_minutes",
+	"anomaly_baseline_minutes": "monitoring.anomaly.baseline_minutes",
+	"anomaly_cooldown_minutes": "monitoring.anomaly.cooldown_minutes",
+	"anomaly_spike_factor":     "monitoring.anomaly.spike_factor",
+	"anomaly_error_rate":       "monitoring.anomaly.error_rate",
+	"anomaly_min_requests":     "monitoring.anomaly.min_requests",
+	"anomaly_min_tokens":       "monitoring.anomaly.min_tokens",
+	"anomaly_min_upload_bytes": "monitoring.anomaly.min_upload_bytes",
+	"slos":                     "monitoring.slos",
+	"slo_burn_rate_alert":      "monitoring.slo_burn_rate_alert",
+}
+
+// setConfigDefaults registers the default of every setting. Every key
+// needs one, otherwise viper ignores its environment variable.
+func setConfigDefaults(v *viper.Viper) {
+	v.SetDefault("server.port", "8080")
+	v.SetDefault("server.listeners", []ListenerConfig{})
+	v.SetDefault("server.sdk_dir", "sdk")
+	v.SetDefault("server.shutdown_timeout_seconds", 30)
+
+	v.SetDefault("openai.key", "")
+	v.SetDefault("openai.style_guide_bucket", "")
+	v.SetDefault("openai.style_guide_objects", []string{})
+	v.SetDefault("openai.system_prompt", "")
+	v.SetDefault("openai.allow_system_prompt_override", true)
+	v.SetDefault("openai.fake", false)
+	v.SetDefault("openai.fake_latency_ms", 0)
+	v.SetDefault("openai.allow_key_header", false)
+	v.SetDefault("openai.allowed_models", []string{})
+
+	v.SetDefault("minio.url", "localhost:9000")
+	v.SetDefault("minio.use_ssl", false)
+	v.SetDefault("minio.key", "")
+	v.SetDefault("minio.secret", "")
+	v.SetDefault("minio.system_bucket", "app-system")
+	v.SetDefault("minio.ingest_bucket", "ingest")
+	v.SetDefault("minio.failover.health_check_seconds", 10)
+
+	v.SetDefault("auth.admin_token", "")
+	v.SetDefault("auth.ingest_tokens", map[string]string{})
+	v.SetDefault("auth.vault.addr", "")
+	v.SetDefault("auth.vault.token", "")
+	v.SetDefault("auth.vault.secret_path", "")
+	v.SetDefault("auth.vault.poll_minutes", 5)
+
+	v.SetDefault("limits.max_diff_bytes", 2<<20)
+	v.SetDefault("limits.max_edit_bytes", 256<<10)
+	v.SetDefault("limits.max_render_bytes", 5<<20)
+	v.SetDefault("limits.max_preview_bytes", 20<<20)
+	v.SetDefault("limits.max_preview_pixels", 50_000_000)
+	v.SetDefault("limits.max_ingest_url_bytes", 20<<20)
+	v.SetDefault("limits.max_source_document_bytes", 20<<20)
+	v.SetDefault("limits.max_source_documents", 1000)
+	v.SetDefault("limits.max_upload_bytes", 64<<20)
+	v.SetDefault("limits.max_audio_bytes", 25<<20)
+
+	v.SetDefault("jobs.trash_retention_days", 30)
+	v.SetDefault("jobs.trash_dry_run", false)
+	v.SetDefault("jobs.key_probe_minutes", 15)
+	v.SetDefault("jobs.link_check_minutes", 360)
+
+	v.SetDefault("cache.backend", "")
+	v.SetDefault("cache.max_entries", 1000)
+	v.SetDefault("cache.max_bytes", 64<<20)
+	v.SetDefault("cache.max_entry_bytes", 1<<20)
+	v.SetDefault("cache.redis_url", "")
+	v.SetDefault("cache.redis_prefix", "test-renovate:cache:")
+	v.SetDefault("cache.routes", []CacheRouteConfig{})
+
+	v.SetDefault("stores.link_results.max_entries", 100)
+	v.SetDefault("stores.link_results.max_bytes", 4<<20)
+	v.SetDefault("stores.transcript_embeddings.max_entries", 10000)
+	v.SetDefault("stores.transcript_embeddings.max_bytes", 64<<20)
+	v.SetDefault("stores.document_embeddings.max_entries", 20000)
+	v.SetDefault("stores.document_embeddings.max_bytes", 128<<20)
+
+	v.SetDefault("proxy.enabled", false)
+	v.SetDefault("proxy.upstream_url", "https://api.openai.com")
+	v.SetDefault("proxy.teams", []ProxyTeamConfig{})
+
+	v.SetDefault("storage.tenants", []StorageTenantConfig{})
+	v.SetDefault("storage.max_credential_seconds", 43200)
+
+	v.SetDefault("sites.index_document", "index.html")
+	v.SetDefault("sites.error_document", "")
+	v.SetDefault("sites.sanitize_html", true)
+	v.SetDefault("sites.published", []SiteConfig{})
+
+	v.SetDefault("gallery.seed", true)
+	v.SetDefault("gallery.file", "")
+	v.SetDefault("gallery.bucket", "examples")
+
+	v.SetDefault("alerts.webhook_url", "")
+	v.SetDefault("alerts.smtp_addr", "")
+	v.SetDefault("alerts.smtp_username", "")
+	v.SetDefault("alerts.smtp_password", "")
+	v.SetDefault("alerts.email_from", "")
+	v.SetDefault("alerts.email_to", []string{})
+
+	v.SetDefault("monitoring.anomaly.window_minutes", 5)
+	v.SetDefault("monitoring.anomaly.baseline_minutes", 60)
+	v.SetDefault("monitoring.anomaly.cooldown_minutes", 30)
+	v.SetDefault("monitoring.anomaly.spike_factor", 3.0)
+	v.SetDefault("monitoring.anomaly.error_rate", 0.05)
+	v.SetDefault("monitoring.anomaly.min_requests", 20)
+	v.SetDefault("monitoring.anomaly.min_tokens", 10000)
+	v.SetDefault("monitoring.anomaly.min_upload_bytes", 100<<20)
+	v.SetDefault("monitoring.slos", []SLOConfig{})
+	v.SetDefault("monitoring.slo_burn_rate_alert", 14.4)
+
+	v.SetDefault("prompt_log.enabled", false)
+	v.SetDefault("prompt_log.redact", []RedactionRule{})
+
+	v.SetDefault("compliance.report_signing_key", "")
+
+	v.SetDefault("retention.interval_minutes", 60)
+	v.SetDefault("retention.dry_run", false)
+	v.SetDefault("retention.uploads.days", 0)
+	v.SetDefault("retention.uploads.buckets", []string{})
+	v.SetDefault("retention.audit_logs.days", 0)
+	v.SetDefault("retention.transcripts.days", 0)
+
+	v.SetDefault("takeout.link_hours", 24)
+	v.SetDefault("takeout.notify_url", "")
+
+	v.SetDefault("terms.required", false)
+
+	v.SetDefault("output_filter.enabled", false)
+	v.SetDefault("output_filter.moderation", false)
+	v.SetDefault("output_filter.moderation_action", "block")
+	v.SetDefault("output_filter.watermark", "\n\n[AI-generated content]")
+	v.SetDefault("output_filter.rules", []OutputRule{})
+
+	v.SetDefault("conversations.persistence", "memory")
+	v.SetDefault("conversations.max_history", 50)
+	v.SetDefault("conversations.memory.max_entries", 10000)
+	v.SetDefault("conversations.memory.max_bytes", 64<<20)
+	v.SetDefault("conversations.drafts.ttl_seconds", 7*24*60*60)
+	v.SetDefault("conversations.drafts.max_entries", 10000)
+	v.SetDefault("conversations.drafts.max_bytes", 16<<20)
+	v.SetDefault("conversations.transcripts.enabled", false)
+	v.SetDefault("conversations.transcripts.bucket", "")
+	v.SetDefault("conversations.transcripts.prefix", "transcripts/")
+	v.SetDefault("conversations.transcripts.search_max_transcripts", 1000)
+
+	v.SetDefault("provenance.watermark", "")
+
+	v.SetDefault("chat_limits.non_streaming.requests_per_minute", 0)
+	v.SetDefault("chat_limits.non_streaming.max_concurrent", 0)
+	v.SetDefault("chat_limits.non_streaming.timeout_seconds", 120)
+	v.SetDefault("chat_limits.streaming.requests_per_minute", 0)
+	v.SetDefault("chat_limits.streaming.max_concurrent", 0)
+	v.SetDefault("chat_limits.streaming.timeout_seconds", 600)
+
+	v.SetDefault("streams.heartbeat_seconds", 15)
+	v.SetDefault("streams.idle_timeout_seconds", 120)
+	v.SetDefault("streams.max_buffer_bytes", 64<<10)
+	v.SetDefault("streams.max_lag_seconds", 30)
+
+	v.SetDefault("embeddings.max_inputs", 2048)
+	v.SetDefault("embeddings.batch_size", 100)
+
+	v.SetDefault("images.bucket", "images")
+	v.SetDefault("images.link_minutes", 60)
+
+	v.SetDefault("disk_cache.dir", "")
+	v.SetDefault("disk_cache.max_entries", 10000)
+	v.SetDefault("disk_cache.max_bytes", 1<<30)
+	v.SetDefault("disk_cache.max_object_bytes", 64<<20)
+
+	v.SetDefault("chat_tools.max_rounds", 5)
+	v.SetDefault("chat_tools.max_result_bytes", 16<<10)
+
+	v.SetDefault("hls.bucket", "hls")
+	v.SetDefault("hls.segment_seconds", 6)
+	v.SetDefault("hls.max_media_bytes", 2<<30)
+	v.SetDefault("hls.timeout_seconds", 600)
+
+	v.SetDefault("input_moderation.enabled", false)
+
+	v.SetDefault("openai_retry.attempts", 3)
+	v.SetDefault("openai_retry.base_delay_ms", 500)
+	v.SetDefault("openai_retry.max_delay_ms", 8000)
+
+	v.SetDefault("approvals.admins", map[string]string{})
+	v.SetDefault("approvals.window_minutes", 60)
+
+	v.SetDefault("event_log.enabled", false)
+	v.SetDefault("event_log.flush_seconds", 5)
+
+	v.SetDefault("chat_provider.name", "openai")
+
+	v.SetDefault("chat_cache.backend", "")
+	v.SetDefault("chat_cache.ttl_seconds", 3600)
+	v.SetDefault("chat_cache.max_entries", 1000)
+	v.SetDefault("chat_cache.max_bytes", 16<<20)
+	v.SetDefault("chat_cache.redis_url", "")
+	v.SetDefault("chat_cache.redis_prefix", "test-renovate:chat:")
+
+	v.SetDefault("opa.url", "")
+	v.SetDefault("opa.decision", "test_renovate/authz/allow")
+	v.SetDefault("opa.timeout_ms", 500)
+	v.SetDefault("opa.fail_open", false)
+	v.SetDefault("opa.skip_operations", []string{"health", "livez", "readyz", "warmup"})
+
+	v.SetDefault("request_signing.clients", []SigningClientConfig{})
+	v.SetDefault("request_signing.max_skew_seconds", 300)
+	v.SetDefault("request_signing.max_body_bytes", 32<<20)
+	v.SetDefault("request_signing.nonces.max_entries", 100000)
+	v.SetDefault("request_signing.nonces.max_bytes", 6400000)
+
+	v.SetDefault("warmup.on_start", true)
+	v.SetDefault("warmup.timeout_seconds", 30)
+
+	v.SetDefault("vision.model", "gpt-4o")
+	v.SetDefault("vision.models", []string{"gpt-4o", "gpt-4o-mini", "gpt-4-turbo", "gpt-4.1", "gpt-4.1-mini"})
+	v.SetDefault("vision.max_image_bytes", 20<<20)
+
+	v.SetDefault("rate_limits.classes", map[string]RateClassConfig{})
+
+	v.SetDefault("shadow.url", "")
+	v.SetDefault("shadow.percent", 10)
+	v.SetDefault("shadow.routes", []string{"/chat", "/embeddings"})
+	v.SetDefault("shadow.timeout_ms", 60000)
+	v.SetDefault("shadow.max_body_bytes", 1<<20)
+	v.SetDefault("shadow.max_concurrent", 16)
+	v.SetDefault("shadow.forward_credentials", false)
+	v.SetDefault("shadow.diffs.max_entries", 1000)
+	v.SetDefault("shadow.diffs.max_bytes", 1<<20)
+	v.SetDefault("rollout.model", "")
+	v.SetDefault("rollout.percent", 10)
+	v.SetDefault("rollout.window", 200)
+	v.SetDefault("rollout.min_requests", 50)
+	v.SetDefault("rollout.max_error_rate_increase", 5)
+	v.SetDefault("rollout.max_latency_increase", 50)
+	v.SetDefault("costs.prices", defaultModelPrices)
+	v.SetDefault("costs.daily_budget", 0)
+	v.SetDefault("costs.caller_daily_budget", 0)
+	v.SetDefault("costs.caller_budgets", map[string]float64{})
+	v.SetDefault("costs.retention_days", 31)
+	v.SetDefault("costs.flush_seconds", 60)
+	v.SetDefault("file_chat.max_objects", 200)
+	v.SetDefault("file_chat.max_object_bytes", 1<<20)
+	v.SetDefault("file_chat.chunk_size", 2000)
+	v.SetDefault("file_chat.chunk_overlap", 200)
+	v.SetDefault("file_chat.max_chunks", 2000)
+	v.SetDefault("file_chat.top_k", 4)
+}
+
+// applyLegacyConfigKeys copies settings given under their flat names, in
+// the config file or as environment variables, to the nested keys. Keys
+// such as minio_url whose environment variable did not change need no
+// mapping for the environment.
+func applyLegacyConfigKeys(v *viper.Viper) {
+	for old, key := range legacyConfigKeys {
+		oldEnv := "APP_" + strings.ToUpper(old)
+		_, fromEnv := os.LookupEnv(oldEnv)
+		fromEnv = fromEnv && oldEnv != "APP_"+strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		if !fromEnv && !v.InConfig(old) {
+			continue
+		}
+		log.Printf("Config key %s is deprecated, use %s instead", old, key)
+		v.Set(key, v.Get(old))
+	}
+}
+
+func initConfig() {
+	// Initialize Viper for configuration management
+	viper.SetConfigName("config")
+	viper.SetConfigType("yaml")
+	viper.AddConfigPath(".")
+	viper.AddConfigPath("./config")
+
+	// Set defaults
+	setConfigDefaults(viper.GetViper())
+
+	// Enable environment variable binding
+	viper.SetEnvPrefix("APP")
+	viper.AutomaticEnv()
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+
+	// Try to read config file (optional)
+	if err := viper.ReadInConfig(); err != nil {
+		log.Printf("Config file not found, using defaults and environment variables: %v", err)
+	}
+	applyLegacyConfigKeys(viper.GetViper())
+
+	// Unmarshal config
+	if err := viper.Unmarshal(&config); err != nil {
+		log.Fatalf("Failed to unmarshal config: %v", err)
+	}
+	if err := decryptConfig(&config); err != nil {
+		log.Fatalf("Failed to decrypt config: %v", err)
+	}
+	if err := config.Validate(); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+
+	log.Printf("Configuration loaded: Port=%s, MinIO URL=%s", config.Server.Port, config.MinIO.URL)
+}
+
+// Validate checks every section and reports all problems at once.
+func (c *Config) Validate() error {
+	return errors.Join(
+		c.Server.Validate(),
+		c.OpenAI.Validate(),
+		c.MinIO.Validate(),
+		c.Auth.Validate(),
+		c.Limits.Validate(),
+		c.Jobs.Validate(),
+		c.Cache.Validate(),
+		c.Stores.Validate(),
+		c.Proxy.Validate(),
+		c.Storage.Validate(),
+		c.Sites.Validate(),
+		c.Gallery.Validate(),
+		c.Alerts.Validate(),
+		c.Monitoring.Validate(),
+		c.PromptLog.Validate(),
+		c.Compliance.Validate(),
+		c.Retention.Validate(),
+		c.Takeout.Validate(),
+		c.OutputFilter.Validate(),
+		c.Conversations.Validate(),
+		c.ChatLimits.Validate(),
+		c.Streams.Validate(),
+		c.Embeddings.Validate(),
+		c.Images.Validate(),
+		c.DiskCache.Validate(),
+		c.ChatTools.Validate(),
+		c.HLS.Validate(),
+		c.OpenAIRetry.Validate(),
+		c.Approvals.Validate(),
+		c.EventLog.Validate(),
+		c.ChatProvider.Validate(),
+		c.ChatCache.Validate(),
+		c.OPA.Validate(),
+		c.RequestSigning.Validate(),
+		c.Warmup.Validate(),
+		c.Vision.Validate(),
+		c.RateLimits.Validate(),
+		c.Shadow.Validate(),
+		c.Rollout.Validate(),
+		c.Costs.Validate(),
+		c.FileChat.Validate(),
+		c.validateSystemBucket(),
+	)
+}
+
+// validateSystemBucket keeps tenants and published sites out of the system
+// bucket, whose tenant policies and ACL catalogs they must not reach.
+func (c *Config) validateSystemBucket() error {
+	for _, t := range c.Storage.Tenants {
+		if t.Bucket == c.MinIO.SystemBucket {
+			return fmt.Errorf("storage.tenants: tenant %s must not use minio.system_bucket", t.Name)
+		}
+	}
+	for _, s := range c.Sites.Published {
+		if s.Bucket == c.MinIO.SystemBucket {
+			return fmt.Errorf("sites.published: site %s must not publish minio.system_bucket", s.Name)
+		}
+	}
+	return nil
+}
+
+func (c ServerConfig) Validate() error {
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("server.port: %q is not a valid port", c.Port)
+	}
+	if c.ShutdownTimeoutSeconds < 0 {
+		return errors.New("server.shutdown_timeout_seconds must not be negative")
+	}
+	names := map[string]bool{}
+	for _, l := range c.Listeners {
+		if err := l.Validate(); err != nil {
+			return err
+		}
+		if names[l.Name] {
+			return fmt.Errorf("server.listeners: duplicate name %q", l.Name)
+		}
+		names[l.Name] = true
+	}
+	return nil
+}
+
+func (c OpenAIConfig) Validate() error {
+	if len(c.StyleGuideObjects) > 0 && c.StyleGuideBucket == "" {
+		return errors.New("openai.style_guide_objects requires openai.style_guide_bucket")
+	}
+	if c.FakeLatencyMS < 0 {
+		return errors.New("openai.fake_latency_ms must not be negative")
+	}
+	seen := map[string]bool{}
+	for _, m := range c.AllowedModels {
+		if strings.TrimSpace(m) == "" || seen[m] {
+			return fmt.Errorf("openai.allowed_models: %q is blank or listed twice", m)
+		}
+		seen[m] = true
+	}
+	return nil
+}
+
+func (c MinIOConfig) Validate() error {
+	if (c.Key == "") != (c.Secret == "") {
+		return errors.New("minio.key and minio.secret must be set together")
+	}
+	if c.Key != "" && c.URL == "" {
+		return errors.New("minio.url is required")
+	}
+	return c.Failover.Validate()
+}
+
+func (c LimitsConfig) Validate() error {
+	for name, v := range map[string]int64{
+		"max_diff_bytes":            c.MaxDiffBytes,
+		"max_edit_bytes":            c.MaxEditBytes,
+		"max_render_bytes":          c.MaxRenderBytes,
+		"max_preview_bytes":         c.MaxPreviewBytes,
+		"max_preview_pixels":        c.MaxPreviewPixels,
+		"max_ingest_url_bytes":      c.MaxIngestURLBytes,
+		"max_source_document_bytes": c.MaxSourceDocumentBytes,
+		"max_source_documents":      int64(c.MaxSourceDocuments),
+		"max_upload_bytes":          c.MaxUploadBytes,
+		"max_audio_bytes":           c.MaxAudioBytes,
+	} {
+		if v <= 0 {
+			return fmt.Errorf("limits.%s must be positive", name)
+		}
+	}
+	return nil
+}
+
+func (c JobsConfig) Validate() error {
+	if c.TrashRetentionDays < 0 {
+		return errors.New("jobs.trash_retention_days must not be negative")
+	}
+	if c.KeyProbeMinutes < 0 {
+		return errors.New("jobs.key_probe_minutes must not be negative")
+	}
+	if c.LinkCheckMinutes < 0 {
+		return errors.New("jobs.link_check_minutes must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestLegacyConfigKeys(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(file, []byte("port: \"7070\"\nsystem_bucket: legacy-system\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("APP_TRASH_RETENTION_DAYS", "7")
+
+	v := viper.New()
+	setConfigDefaults(v)
+	v.SetEnvPrefix("APP")
+	v.AutomaticEnv()
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	applyLegacyConfigKeys(v)
+
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != "7070" {
+		t.Errorf("Expected port from legacy key, got %s", cfg.Server.Port)
+	}
+	if cfg.MinIO.SystemBucket != "legacy-system" {
+		t.Errorf("Expected system bucket from legacy key, got %s", cfg.MinIO.SystemBucket)
+	}
+	if cfg.Jobs.TrashRetentionDays != 7 {
+		t.Errorf("Expected trash retention from legacy env var, got %d", cfg.Jobs.TrashRetentionDays)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected legacy config to be valid, got %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Expected defaults to be valid, got %v", err)
+	}
+
+	cfg := config
+	cfg.Server.Port = "http"
+	cfg.MinIO.Secret = ""
+	cfg.Limits.MaxRenderBytes = 0
+	cfg.Auth.Vault.Addr = "https://vault.example.com"
+	cfg.Monitoring.SLOs = []SLOConfig{{Name: "api", Type: "latency", Objective: 0.99}}
+	cfg.Storage.Tenants = []StorageTenantConfig{{Name: "acme", Token: "acme-token", Bucket: cfg.MinIO.SystemBucket}}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected validation errors")
+	}
+	for _, want := range []string{"server.port", "minio.key", "limits.max_render_bytes", "auth.vault.addr", "slo api", "tenant acme must not use minio.system_bucket"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %s, got %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// encryptedPrefix marks config values encrypted with the config key.
+const encryptedPrefix = "enc:"
+
+// configKey loads the AES-256 key for encrypted config values from
+// APP_CONFIG_KEY or the file named by APP_CONFIG_KEY_FILE. Both hold the
+// key base64-encoded. The key is deliberately not a regular config value
+// so it never ends up next to the values it protects.
+func configKey() ([]byte, error) {
+	encoded := os.Getenv("APP_CONFIG_KEY")
+	if encoded == "" {
+		path := os.Getenv("APP_CONFIG_KEY_FILE")
+		if path == "" {
+			return nil, errors.New("config contains encrypted values but neither APP_CONFIG_KEY nor APP_CONFIG_KEY_FILE is set")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read config key: %w", err)
+		}
+		encoded = string(data)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("decode config key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("config key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+func newConfigCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptConfigValue returns plaintext encrypted with AES-256-GCM in the
+// enc:<base64 nonce+ciphertext> form accepted in config files.
+func encryptConfigValue(key []byte, plaintext string) (string, error) {
+	aead, err := newConfigCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptConfigValue(key []byte, value string) (string, error) {
+	aead, err := newConfigCipher(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("encrypted value could not be decrypted with the config key")
+	}
+	return string(plaintext), nil
+}
+
+// decryptConfig replaces every enc: string in cfg, including those inside
+// slices, maps and nested structs, with its plaintext. The key is only
+// loaded if an encrypted value is present.
+func decryptConfig(cfg *Config) error {
+	var key []byte
+	var walk func(path string, v reflect.Value) error
+	decrypt := func(path, s string) (string, error) {
+		if !strings.HasPrefix(s, encryptedPrefix) {
+			return s, nil
+		}
+		if key == nil {
+			var err error
+			if key, err = configKey(); err != nil {
+				return "", err
+			}
+		}
+		plaintext, err := decryptConfigValue(key, s)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+		return plaintext, nil
+	}
+
+	walk = func(path string, v reflect.Value) error {
+		switch v.Kind() {
+		case reflect.String:
+			s, err := decrypt(path, v.String())
+			if err != nil {
+				return err
+			}
+			v.SetString(s)
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				name := v.Type().Field(i).Tag.Get("mapstructure")
+				if err := walk(strings.TrimPrefix(path+"."+name, "."), v.Field(i)); err != nil {
+					return err
+				}
+			}
+		case reflect.Slice:
+			for i := 0; i < v.Len(); i++ {
+				if err := walk(fmt.Sprintf("%s[%d]", path, i), v.Index(i)); err != nil {
+					return err
+				}
+			}
+		case reflect.Map:
+			if v.Type().Elem().Kind() != reflect.String {
+				return nil
+			}
+			for _, k := range v.MapKeys() {
+				s, err := decrypt(fmt.Sprintf("%s.%v", path, k), v.MapIndex(k).String())
+				if err != nil {
+					return err
+				}
+				v.SetMapIndex(k, reflect.ValueOf(s).Convert(v.Type().Elem()))
+			}
+		}
+		return nil
+	}
+	return walk("", reflect.ValueOf(cfg).Elem())
+}
+
+// runConfigKeyCommand handles the genkey and encrypt commands. encrypt
+// reads the value to encrypt from in.
+func runConfigKeyCommand(command string, args []string, in io.Reader, out io.Writer) error {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(out)
+	format := outputFlag(flags, outputTable)
+	if err := parseFlags(flags, args, format); err != nil {
+		return err
+	}
+	emit := func(field, value string) error {
+		if *format == outputJSON {
+			return writeJSON(out, map[string]string{field: value})
+		}
+		_, err := fmt.Fprintln(out, value)
+		return err
+	}
+
+	switch command {
+	case "genkey":
+		key := make([]byte, 32)
+		rand.Read(key)
+		return emit("key", base64.StdEncoding.EncodeToString(key))
+	case "encrypt":
+		key, err := configKey()
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		value, err := encryptConfigValue(key, strings.TrimRight(string(data), "\r\n"))
+		if err != nil {
+			return err
+		}
+		return emit("value", value)
+	}
+	return usageError("unknown command %q", command)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestConfigValueRoundTrip(t *testing.T) {
+	key := make([]byte, 32)
+	value, err := encryptConfigValue(key, "sk-secret")
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if !strings.HasPrefix(value, encryptedPrefix) || strings.Contains(value, "sk-secret") {
+		t.Errorf("Unexpected encrypted value %q", value)
+	}
+
+	plaintext, err := decryptConfigValue(key, value)
+	if err != nil || plaintext != "sk-secret" {
+		t.Errorf("Expected sk-secret, got %q (%v)", plaintext, err)
+	}
+
+	otherKey := make([]byte, 32)
+	otherKey[0] = 1
+	if _, err := decryptConfigValue(otherKey, value); err == nil {
+		t.Error("Expected decryption with the wrong key to fail")
+	}
+}
+
+func TestEncryptedConfigValues(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	keyFile := filepath.Join(t.TempDir(), "config.key")
+	if err := os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("APP_CONFIG_KEY_FILE", keyFile)
+
+	openAIKey, _ := encryptConfigValue(key, "sk-secret")
+	token, _ := encryptConfigValue(key, "cms-token")
+	t.Setenv("APP_OPENAI_KEY", openAIKey)
+
+	viper.Reset()
+	viper.Set("auth.ingest_tokens", map[string]string{"cms": token})
+	initConfig()
+	if config.OpenAI.Key != "sk-secret" {
+		t.Errorf("Expected decrypted OpenAI key, got %q", config.OpenAI.Key)
+	}
+	if config.Auth.IngestTokens["cms"] != "cms-token" {
+		t.Errorf("Expected decrypted ingest token, got %q", config.Auth.IngestTokens["cms"])
+	}
+
+	// Without the key the encrypted value cannot be used
+	t.Setenv("APP_CONFIG_KEY_FILE", "")
+	cfg := Config{OpenAI: OpenAIConfig{Key: openAIKey}}
+	if err := decryptConfig(&cfg); err == nil {
+		t.Error("Expected an error without a config key")
+	}
+}
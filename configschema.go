@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// configSchema returns a JSON Schema for config.yaml derived from the
+// mapstructure, doc and enum tags of Config, with the registered defaults.
+func configSchema() map[string]any {
+	v := viper.New()
+	setConfigDefaults(v)
+
+	schema := structSchema(reflect.TypeOf(Config{}), "", v)
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = apiTitle + " configuration"
+	return schema
+}
+
+func structSchema(t reflect.Type, prefix string, v *viper.Viper) map[string]any {
+	props := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("mapstructure")
+		if name == "" {
+			continue
+		}
+		key := prefix + name
+		s := typeSchema(f.Type, key, v)
+		if doc := f.Tag.Get("doc"); doc != "" {
+			s["description"] = doc
+		}
+		if enum := f.Tag.Get("enum"); enum != "" {
+			s["enum"] = strings.Split(enum, ",")
+		}
+		if v != nil && f.Type.Kind() != reflect.Struct {
+			if def := v.Get(key); def != nil {
+				s["default"] = def
+			}
+		}
+		props[name] = s
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+}
+
+func typeSchema(t reflect.Type, key string, v *viper.Viper) map[string]any {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		// Items of a list have no defaults of their own
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem(), "", nil)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem(), "", nil)}
+	case reflect.Struct:
+		return structSchema(t, key+".", v)
+	}
+	return map[string]any{}
+}
+
+// writeConfigExample writes a config.yaml with every setting at its
+// default, each preceded by its description.
+func writeConfigExample(w io.Writer) error {
+	v := viper.New()
+	setConfigDefaults(v)
+
+	var sb strings.Builder
+	sb.WriteString("# Generated by `config example`. Every setting can also be set with an\n")
+	sb.WriteString("# APP_ environment variable, e.g. APP_SERVER_PORT.\n")
+	writeExampleStruct(&sb, reflect.TypeOf(Config{}), "", "", v)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func writeExampleStruct(sb *strings.Builder, t reflect.Type, indent, prefix string, v *viper.Viper) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("mapstructure")
+		if name == "" {
+			continue
+		}
+		sb.WriteString("\n")
+		if doc := f.Tag.Get("doc"); doc != "" {
+			fmt.Fprintf(sb, "%s# %s\n", indent, doc)
+		}
+		if enum := f.Tag.Get("enum"); enum != "" {
+			fmt.Fprintf(sb, "%s# One of: %s\n", indent, strings.ReplaceAll(enum, ",", ", "))
+		}
+
+		key := prefix + name
+		if f.Type.Kind() == reflect.Struct {
+			fmt.Fprintf(sb, "%s%s:\n", indent, name)
+			writeExampleStruct(sb, f.Type, indent+"  ", key+".", v)
+			continue
+		}
+		fmt.Fprintf(sb, "%s%s:%s\n", indent, name, yamlValue(v.Get(key), indent))
+	}
+}
+
+// yamlValue formats a default value for the example config, including the
+// separator after the key.
+func yamlValue(value any, indent string) string {
+	rv := reflect.ValueOf(value)
+	if !rv.IsValid() {
+		return ` ""`
+	}
+	switch rv.Kind() {
+	case reflect.Slice:
+		if rv.Len() == 0 {
+			return " []"
+		}
+		var sb strings.Builder
+		for i := 0; i < rv.Len(); i++ {
+			fmt.Fprintf(&sb, "\n%s  -%s", indent, yamlValue(rv.Index(i).Interface(), indent+"  "))
+		}
+		return sb.String()
+	case reflect.Map:
+		if rv.Len() == 0 {
+			return " {}"
+		}
+		keys := make([]string, 0, rv.Len())
+		for _, k := range rv.MapKeys() {
+			keys = append(keys, fmt.Sprint(k.Interface()))
+		}
+		sort.Strings(keys)
+		var sb strings.Builder
+		for _, k := range keys {
+			fmt.Fprintf(&sb, "\n%s  %s:%s", indent, k, yamlValue(rv.MapIndex(reflect.ValueOf(k)).Interface(), indent+"  "))
+		}
+		return sb.String()
+	case reflect.String:
+		return " " + strconv.Quote(rv.String())
+	case reflect.Float32, reflect.Float64:
+		return " " + strconv.FormatFloat(rv.Float(), 'f', -1, 64)
+	}
+	return fmt.Sprintf(" %v", value)
+}
+
+// schemaRows flattens a config schema into one row per setting: key,
+// type, default and description.
+func schemaRows(schema map[string]any, prefix string) [][4]string {
+	props, _ := schema["properties"].(map[string]any)
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rows [][4]string
+	for _, name := range names {
+		p := props[name].(map[string]any)
+		if p["type"] == "object" && p["properties"] != nil {
+			rows = append(rows, schemaRows(p, prefix+name+".")...)
+			continue
+		}
+		def := ""
+		if d, ok := p["default"]; ok {
+			data, _ := json.Marshal(d)
+			def = string(data)
+		}
+		desc, _ := p["description"].(string)
+		rows = append(rows, [4]string{prefix + name, fmt.Sprint(p["type"]), def, desc})
+	}
+	return rows
+}
+
+// runConfigCommand handles `config schema` and `config example`. The schema
+// is JSON or, with -output table, one row per setting; the example is YAML
+// or, with -output json, the defaults as JSON.
+func runConfigCommand(args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return usageError("usage: config schema|example [-output ...]")
+	}
+	flags := flag.NewFlagSet("config "+args[0], flag.ContinueOnError)
+	flags.SetOutput(w)
+
+	switch args[0] {
+	case "schema":
+		format := outputFlag(flags, outputJSON)
+		if err := parseFlags(flags, args[1:], format); err != nil {
+			return err
+		}
+		if *format == outputJSON {
+			return writeJSON(w, configSchema())
+		}
+		tw := newTable(w)
+		fmt.Fprintln(tw, "KEY\tTYPE\tDEFAULT\tDESCRIPTION")
+		for _, row := range schemaRows(configSchema(), "") {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", row[0], row[1], row[2], row[3])
+		}
+		return tw.Flush()
+	case "example":
+		format := flags.String("output", "yaml", "Output format: yaml or json")
+		if err := parseFlags(flags, args[1:], nil); err != nil {
+			return err
+		}
+		switch *format {
+		case "yaml":
+			return writeConfigExample(w)
+		case outputJSON:
+			v := viper.New()
+			setConfigDefaults(v)
+			return writeJSON(w, v.AllSettings())
+		}
+		return usageError("-output must be yaml or json, not %q", *format)
+	}
+	return usageError("unknown config command %q", args[0])
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestConfigFieldsAreDocumented(t *testing.T) {
+	var check func(t *testing.T, typ reflect.Type)
+	check = func(t *testing.T, typ reflect.Type) {
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if f.Tag.Get("mapstructure") == "" || f.Tag.Get("doc") == "" {
+				t.Errorf("%s.%s needs mapstructure and doc tags", typ.Name(), f.Name)
+			}
+			ft := f.Type
+			for ft.Kind() == reflect.Slice || ft.Kind() == reflect.Map {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				check(t, ft)
+			}
+		}
+	}
+	check(t, reflect.TypeOf(Config{}))
+}
+
+func TestConfigSchema(t *testing.T) {
+	props := configSchema()["properties"].(map[string]any)
+
+	server := props["server"].(map[string]any)["properties"].(map[string]any)
+	port := server["port"].(map[string]any)
+	if port["type"] != "string" || port["default"] != "8080" {
+		t.Errorf("Unexpected port schema: %v", port)
+	}
+	monitoring := props["monitoring"].(map[string]any)["properties"].(map[string]any)
+	slos := monitoring["slos"].(map[string]any)
+	item := slos["items"].(map[string]any)["properties"].(map[string]any)
+	if typ := item["type"].(map[string]any); len(typ["enum"].([]string)) != 2 {
+		t.Errorf("Expected SLO types to be enumerated, got %v", typ)
+	}
+}
+
+func TestConfigExampleLoads(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeConfigExample(&buf); err != nil {
+		t.Fatalf("Failed to write example: %v", err)
+	}
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(&buf); err != nil {
+		t.Fatalf("Example is not valid YAML: %v\n%s", err, buf.String())
+	}
+	var fromExample Config
+	if err := v.Unmarshal(&fromExample); err != nil {
+		t.Fatalf("Failed to unmarshal example: %v", err)
+	}
+
+	defaults := viper.New()
+	setConfigDefaults(defaults)
+	var fromDefaults Config
+	if err := defaults.Unmarshal(&fromDefaults); err != nil {
+		t.Fatalf("Failed to unmarshal defaults: %v", err)
+	}
+	// Compare printed forms since empty lists decode as nil from YAML
+	if fmt.Sprintf("%+v", fromExample) != fmt.Sprintf("%+v", fromDefaults) {
+		t.Errorf("Example config differs from the defaults:\n%+v\n%+v", fromExample, fromDefaults)
+	}
+}
@@ -0,0 +1,764 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+	"github.com/sashabaranov/go-openai"
+)
+
+// conversationsPrefix is where the minio persistence keeps conversations in
+// the system bucket.
+const conversationsPrefix = "conversations/"
+
+var conversationIDPattern = regexp.MustCompile(`^conv_[0-9a-f]{32}$`)
+
+// ConversationsConfig configures server-side chat sessions.
+type ConversationsConfig struct {
+	Persistence string            `mapstructure:"persistence" enum:"memory,minio" doc:"Where conversations are kept besides memory; memory loses them on restart"`
+	MaxHistory  int               `mapstructure:"max_history" doc:"Most earlier messages replayed to OpenAI with each new one"`
+	Memory      StoreLimits       `mapstructure:"memory" doc:"Conversations kept in memory; with minio persistence evicted ones are reloaded"`
+	Drafts      DraftsConfig      `mapstructure:"drafts" doc:"Unsent messages kept for clients to restore"`
+	Transcripts TranscriptsConfig `mapstructure:"transcripts" doc:"Transcripts of every exchange written to MinIO"`
+}
+
+// DraftsConfig bounds the drafts of unsent messages. Drafts are kept in
+// memory only.
+type DraftsConfig struct {
+	TTLSeconds int   `mapstructure:"ttl_seconds" doc:"Seconds a draft is kept after it was last saved"`
+	MaxEntries int   `mapstructure:"max_entries" doc:"Most drafts kept"`
+	MaxBytes   int64 `mapstructure:"max_bytes" doc:"Most bytes of drafts kept"`
+}
+
+func (c ConversationsConfig) Validate() error {
+	var errs []error
+	switch c.Persistence {
+	case "memory", "minio":
+	default:
+		errs = append(errs, fmt.Errorf("conversations.persistence: unknown persistence %q", c.Persistence))
+	}
+	if c.MaxHistory <= 0 {
+		errs = append(errs, errors.New("conversations.max_history must be positive"))
+	}
+	errs = append(errs, c.Memory.validate("conversations.memory"))
+	if c.Drafts.TTLSeconds <= 0 {
+		errs = append(errs, errors.New("conversations.drafts.ttl_seconds must be positive"))
+	}
+	errs = append(errs, StoreLimits{MaxEntries: c.Drafts.MaxEntries, MaxBytes: c.Drafts.MaxBytes}.validate("conversations.drafts"))
+	if c.Transcripts.SearchMaxTranscripts <= 0 {
+		errs = append(errs, errors.New("conversations.transcripts.search_max_transcripts must be positive"))
+	}
+	if c.Transcripts.Bucket == "" && c.Transcripts.Prefix == "" {
+		errs = append(errs, errors.New("conversations.transcripts.prefix must be set when transcripts are kept in minio.system_bucket"))
+	}
+	return errors.Join(errs...)
+}
+
+// Conversation is a chat session whose messages are replayed to OpenAI.
+type Conversation struct {
+	ID     string `json:"id" doc:"Conversation ID"`
+	Tenant string `json:"tenant,omitempty" doc:"Tenant that owns the conversation; empty if it was created without a tenant token"`
+	ConversationSettings
+	CreatedAt time.Time             `json:"created_at" doc:"When the conversation was created"`
+	UpdatedAt time.Time             `json:"updated_at" doc:"When the last message was added"`
+	Messages  []ConversationMessage `json:"messages" doc:"Messages so far, oldest first"`
+}
+
+// ConversationSettings are the model and sampling settings every message of
+// a conversation is sent with, so its replies stay consistent.
+type ConversationSettings struct {
+	Model       string   `json:"model,omitempty" doc:"Model for messages that name none; defaults to the tenant policy's default model or gpt-3.5-turbo"`
+	Temperature *float32 `json:"temperature,omitempty" minimum:"0" maximum:"2" doc:"Sampling temperature; defaults to OpenAI's default"`
+	TopP        *float32 `json:"top_p,omitempty" minimum:"0" maximum:"1" doc:"Nucleus sampling; defaults to OpenAI's default"`
+	MaxTokens   int      `json:"max_tokens,omitempty" minimum:"1" maximum:"128000" doc:"Most tokens a reply may have; defaults to the model's limit"`
+}
+
+// ConversationMessage is a message of a conversation. Replies keep the
+// settings they were generated with.
+type ConversationMessage struct {
+	ChatMessage
+	Settings *ConversationSettings `json:"settings,omitempty" doc:"Settings the reply was generated with"`
+}
+
+type ConversationCreateRequest struct {
+	ConversationSettings
+}
+
+type ConversationMessageRequest struct {
+	Message string `json:"message" minLength:"1" doc:"Message to send"`
+	Model   string `json:"model,omitempty" doc:"Model for this message; defaults to the conversation's model"`
+}
+
+type ConversationRegenerateRequest struct {
+	Model       string   `json:"model,omitempty" doc:"Model for the new reply; defaults to the conversation's model"`
+	Temperature *float32 `json:"temperature,omitempty" minimum:"0" maximum:"2" doc:"Sampling temperature of the new reply"`
+	TopP        *float32 `json:"top_p,omitempty" minimum:"0" maximum:"1" doc:"Nucleus sampling of the new reply"`
+	Mode        string   `json:"mode,omitempty" enum:"replace,append" default:"replace" doc:"replace drops the replies to the last message; append keeps them and adds the new one after them"`
+}
+
+type ConversationDraftRequest struct {
+	Message string `json:"message" minLength:"1" maxLength:"100000" doc:"Unsent message"`
+}
+
+// ConversationDraft is a message a caller has started to write in a
+// conversation but not sent.
+type ConversationDraft struct {
+	Message   string    `json:"message" doc:"Unsent message"`
+	UpdatedAt time.Time `json:"updated_at" doc:"When the draft was saved"`
+	ExpiresAt time.Time `json:"expires_at" doc:"When the draft is forgotten unless it is saved again"`
+}
+
+type ConversationMessageResponse struct {
+	ConversationID string               `json:"conversation_id" doc:"Conversation ID"`
+	Reply          string               `json:"reply" doc:"Response from OpenAI"`
+	Filter         *OutputFilterResult  `json:"filter,omitempty" doc:"Output filter decisions, if the filter is enabled"`
+	Messages       int                  `json:"messages" doc:"Number of messages in the conversation"`
+	Settings       ConversationSettings `json:"settings" doc:"Settings the reply was generated with"`
+}
+
+// size approximates the memory a conversation holds.
+func (c *Conversation) size() int64 {
+	n := int64(len(c.ID) + len(c.Tenant) + len(c.Model))
+	for _, m := range c.Messages {
+		n += int64(len(m.Role) + len(m.Content))
+	}
+	return n
+}
+
+// conversationPersistence stores conversations beyond the memory of one
+// instance.
+type conversationPersistence interface {
+	// load returns the conversation, or nil if it does not exist.
+	load(ctx context.Context, id string) (*Conversation, error)
+	save(ctx context.Context, c *Conversation) error
+	delete(ctx context.Context, id string) error
+}
+
+// minioConversations keeps conversations as JSON objects in the system
+// bucket.
+type minioConversations struct{}
+
+func conversationKey(id string) string {
+	return conversationsPrefix + id + ".json"
+}
+
+func (minioConversations) load(ctx context.Context, id string) (*Conversation, error) {
+	var c Conversation
+	if err := getJSON(ctx, config.MinIO.SystemBucket, conversationKey(id), &c); err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (minioConversations) save(ctx context.Context, c *Conversation) error {
+	if err := ensureBucket(ctx, config.MinIO.SystemBucket); err != nil {
+		return err
+	}
+	return putJSON(ctx, config.MinIO.SystemBucket, conversationKey(c.ID), c)
+}
+
+func (minioConversations) delete(ctx context.Context, id string) error {
+	client, err := services.MinIO()
+	if err != nil {
+		return err
+	}
+	err = client.RemoveObject(ctx, config.MinIO.SystemBucket, conversationKey(id), minio.RemoveObjectOptions{})
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// conversationStore keeps conversations in memory and, if configured,
+// writes them through to a persistence.
+type conversationStore struct {
+	mu          sync.Mutex
+	memory      *lruStore[*Conversation]
+	persistence conversationPersistence
+	// drafts are keyed by conversation and caller
+	drafts *lruStore[ConversationDraft]
+}
+
+func newConversationStore(c ConversationsConfig) *conversationStore {
+	s := &conversationStore{
+		memory: newLRUStore("conversations", c.Memory, (*Conversation).size),
+		drafts: newLRUStore("conversation_drafts", StoreLimits{MaxEntries: c.Drafts.MaxEntries, MaxBytes: c.Drafts.MaxBytes}, func(d ConversationDraft) int64 { return int64(len(d.Message)) }),
+	}
+	if c.Persistence == "minio" {
+		s.persistence = minioConversations{}
+	}
+	return s
+}
+
+// conversations is the store of this instance, created on first use.
+var conversations struct {
+	sync.Mutex
+	store *conversationStore
+}
+
+func conversationsStore() *conversationStore {
+	conversations.Lock()
+	defer conversations.Unlock()
+	if conversations.store == nil {
+		conversations.store = newConversationStore(config.Conversations)
+	}
+	return conversations.store
+}
+
+// get returns a copy of the conversation, or nil if it does not exist.
+func (s *conversationStore) get(ctx context.Context, id string) (*Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.load(ctx, id)
+	if c == nil || err != nil {
+		return nil, err
+	}
+	cp := *c
+	cp.Messages = append([]ConversationMessage(nil), c.Messages...)
+	return &cp, nil
+}
+
+// load must be called with s.mu held.
+func (s *conversationStore) load(ctx context.Context, id string) (*Conversation, error) {
+	if c, ok := s.memory.get(id); ok {
+		return c, nil
+	}
+	if s.persistence == nil {
+		return nil, nil
+	}
+	c, err := s.persistence.load(ctx, id)
+	if c != nil && err == nil {
+		s.memory.set(id, c, 0)
+	}
+	return c, err
+}
+
+func (s *conversationStore) create(ctx context.Context, c *Conversation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.persistence != nil {
+		if err := s.persistence.save(ctx, c); err != nil {
+			return err
+		}
+	}
+	s.memory.set(c.ID, c, 0)
+	return nil
+}
+
+// modify applies fn to a copy of a conversation and stores the result.
+func (s *conversationStore) modify(ctx context.Context, id string, fn func(*Conversation) error) (*Conversation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, err := s.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, errConversationNotFound(id)
+	}
+	updated := *c
+	updated.Messages = append([]ConversationMessage(nil), c.Messages...)
+	if err := fn(&updated); err != nil {
+		return nil, err
+	}
+	if s.persistence != nil {
+		if err := s.persistence.save(ctx, &updated); err != nil {
+			return nil, err
+		}
+	}
+	s.memory.set(id, &updated, 0)
+	return &updated, nil
+}
+
+// appendMessages adds messages to a conversation and returns its new
+// length. Messages appended concurrently are kept in the order they
+// arrive.
+func (s *conversationStore) appendMessages(ctx context.Context, id string, messages ...ConversationMessage) (int, error) {
+	c, err := s.modify(ctx, id, func(c *Conversation) error {
+		c.Messages = append(c.Messages, messages...)
+		c.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(c.Messages), nil
+}
+
+// replaceReplies replaces the messages of a conversation from index from on
+// with reply, or appends reply if from is its length. It fails with 409 if
+// the conversation no longer has length messages.
+func (s *conversationStore) replaceReplies(ctx context.Context, id string, length, from int, reply ConversationMessage) (int, error) {
+	c, err := s.modify(ctx, id, func(c *Conversation) error {
+		if len(c.Messages) != length {
+			return huma.Error409Conflict("The conversation changed while the reply was generated")
+		}
+		c.Messages = append(c.Messages[:from], reply)
+		c.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(c.Messages), nil
+}
+
+// updateSettings replaces the settings of a conversation. Messages already
+// in it keep theirs.
+func (s *conversationStore) updateSettings(ctx context.Context, id string, settings ConversationSettings) (*Conversation, error) {
+	return s.modify(ctx, id, func(c *Conversation) error {
+		c.ConversationSettings = settings
+		return nil
+	})
+}
+
+func (s *conversationStore) delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.persistence != nil {
+		if err := s.persistence.delete(ctx, id); err != nil {
+			return err
+		}
+	}
+	s.memory.delete(id)
+	return nil
+}
+
+// draftKey is the key of the draft of caller, as returned by
+// rateLimitCaller, in a conversation. Drafts of a deleted conversation are
+// unreachable and expire.
+func draftKey(id, caller string) string {
+	return id + "\x00" + caller
+}
+
+// saveDraft keeps a caller's draft for conversations.drafts.ttl_seconds.
+func (s *conversationStore) saveDraft(id, caller, message string) ConversationDraft {
+	ttl := time.Duration(config.Conversations.Drafts.TTLSeconds) * time.Second
+	now := time.Now().UTC()
+	d := ConversationDraft{Message: message, UpdatedAt: now, ExpiresAt: now.Add(ttl)}
+	s.drafts.set(draftKey(id, caller), d, ttl)
+	return d
+}
+
+// draft returns a caller's draft, if it has one that has not expired.
+func (s *conversationStore) draft(id, caller string) (ConversationDraft, bool) {
+	return s.drafts.get(draftKey(id, caller))
+}
+
+func (s *conversationStore) deleteDraft(id, caller string) {
+	s.drafts.delete(draftKey(id, caller))
+}
+
+func errConversationNotFound(id string) error {
+	return huma.Error404NotFound(fmt.Sprintf("Conversation %s not found", id))
+}
+
+// conversationChatRequest returns the request sending message after
+// history with settings s. It is not a method of ConversationSettings, as
+// huma cannot embed types with methods.
+func conversationChatRequest(s ConversationSettings, message string, history []ChatMessage) ChatRequest {
+	return ChatRequest{
+		Message:     message,
+		History:     history,
+		Model:       s.Model,
+		Temperature: s.Temperature,
+		TopP:        s.TopP,
+		MaxTokens:   s.MaxTokens,
+	}
+}
+
+// findConversation returns the conversation if the caller may use it.
+// Conversations created with a tenant token belong to that tenant; others
+// are open to anyone who knows the ID.
+func findConversation(ctx context.Context, id, authorization string) (*Conversation, error) {
+	if !conversationIDPattern.MatchString(id) {
+		return nil, errConversationNotFound(id)
+	}
+	c, err := conversationsStore().get(ctx, id)
+	if err != nil {
+		return nil, huma.Error500InternalServerError("Failed to load the conversation", err)
+	}
+	if c == nil {
+		return nil, errConversationNotFound(id)
+	}
+	caller := objectCaller(authorization)
+	if c.Tenant != "" && !caller.admin && caller.tenant != c.Tenant {
+		// Don't reveal other tenants' conversations
+		return nil, errConversationNotFound(id)
+	}
+	return c, nil
+}
+
+// replayHistory returns the last messages of a conversation that are sent
+// to OpenAI with a new one.
+func replayHistory(messages []ConversationMessage) []ChatMessage {
+	if n := config.Conversations.MaxHistory; len(messages) > n {
+		messages = messages[len(messages)-n:]
+	}
+	history := make([]ChatMessage, len(messages))
+	for i, m := range messages {
+		history[i] = m.ChatMessage
+	}
+	return history
+}
+
+func registerConversationEndpoints(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID:   "create-conversation",
+		Method:        http.MethodPost,
+		Path:          "/conversations",
+		Summary:       "Start a conversation",
+		Description:   "Create a chat session. Messages sent to it are answered with the earlier messages as context",
+		DefaultStatus: http.StatusCreated,
+	}, func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer tenant token; the conversation then belongs to the tenant"`
+		Body          *ConversationCreateRequest
+	}) (*struct {
+		Body Conversation
+	}, error) {
+		if err := requireTermsAccepted(ctx, input.Authorization); err != nil {
+			return nil, err
+		}
+		var req ConversationCreateRequest
+		if input.Body != nil {
+			req = *input.Body
+		}
+		if req.Model != "" {
+			if _, err := policyModel(ctx, req.Model); err != nil {
+				return nil, err
+			}
+		}
+		if config.Conversations.Persistence == "minio" {
+			if _, err := services.MinIO(); err != nil {
+				return nil, err
+			}
+		}
+
+		now := time.Now().UTC()
+		c := &Conversation{
+			ID:                   "conv_" + randomHex(16),
+			ConversationSettings: req.ConversationSettings,
+			CreatedAt:            now,
+			UpdatedAt:            now,
+			Messages:             []ConversationMessage{},
+		}
+		if t, ok := storageTenant(input.Authorization); ok {
+			c.Tenant = t.Name
+		}
+		if err := conversationsStore().create(ctx, c); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to save the conversation", err)
+		}
+		return &struct {
+			Body Conversation
+		}{Body: *c}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-conversation",
+		Method:      http.MethodGet,
+		Path:        "/conversations/{id}",
+		Summary:     "Get a conversation",
+		Description: "Return a conversation with all of its messages",
+	}, func(ctx context.Context, input *struct {
+		ID            string `path:"id" doc:"Conversation ID"`
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token"`
+	}) (*struct {
+		Body Conversation
+	}, error) {
+		c, err := findConversation(ctx, input.ID, input.Authorization)
+		if err != nil {
+			return nil, err
+		}
+		return &struct {
+			Body Conversation
+		}{Body: *c}, nil
+	})
+
+	huma.Register(api, withRoutePolicy(huma.Operation{
+		OperationID: "send-conversation-message",
+		Method:      http.MethodPost,
+		Path:        "/conversations/{id}/messages",
+		Summary:     "Send a message in a conversation",
+		Description: "Send a message to OpenAI together with the conversation's earlier messages, and add both the message and the reply to the conversation",
+	}, RoutePolicy{RateClass: "chat", Budgeted: true}), func(ctx context.Context, input *struct {
+		ID            string `path:"id" doc:"Conversation ID"`
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token"`
+		Body          ConversationMessageRequest
+	}) (*struct {
+		Body ConversationMessageResponse
+	}, error) {
+		provider, err := chatProvider(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := requireTermsAccepted(ctx, input.Authorization); err != nil {
+			return nil, err
+		}
+		c, err := findConversation(ctx, input.ID, input.Authorization)
+		if err != nil {
+			return nil, err
+		}
+		settings := c.ConversationSettings
+		if input.Body.Model != "" {
+			settings.Model = input.Body.Model
+		}
+		if settings.Model, err = policyModel(ctx, settings.Model); err != nil {
+			return nil, err
+		}
+
+		req := conversationChatRequest(settings, input.Body.Message, replayHistory(c.Messages))
+		resp, err := completeChat(ctx, provider, "conversation", req, settings.Model)
+		if err != nil {
+			return nil, err
+		}
+		n, err := conversationsStore().appendMessages(ctx, c.ID,
+			ConversationMessage{ChatMessage: ChatMessage{Role: openai.ChatMessageRoleUser, Content: input.Body.Message}},
+			ConversationMessage{ChatMessage: ChatMessage{Role: openai.ChatMessageRoleAssistant, Content: resp.Reply}, Settings: &settings},
+		)
+		if err != nil {
+			if errors.As(err, new(huma.StatusError)) {
+				return nil, err
+			}
+			return nil, huma.Error500InternalServerError("Failed to save the conversation", err)
+		}
+		caller := rateLimitCaller(input.Authorization)
+		// The draft was sent
+		conversationsStore().deleteDraft(c.ID, caller)
+		recordTranscript(ctx, c, TranscriptTurn{
+			Time:     time.Now().UTC(),
+			Caller:   caller,
+			Message:  input.Body.Message,
+			Reply:    resp.Reply,
+			Settings: settings,
+			Model:    resp.Model,
+			Usage:    resp.Usage,
+			Filter:   resp.Filter,
+		})
+		return &struct {
+			Body ConversationMessageResponse
+		}{Body: ConversationMessageResponse{ConversationID: c.ID, Reply: resp.Reply, Filter: resp.Filter, Messages: n, Settings: settings}}, nil
+	})
+
+	huma.Register(api, withRoutePolicy(huma.Operation{
+		OperationID: "regenerate-conversation-reply",
+		Method:      http.MethodPost,
+		Path:        "/conversations/{id}/regenerate",
+		Summary:     "Regenerate the last reply",
+		Description: "Send the conversation's last message to OpenAI again, optionally with another model or temperature, and replace the replies to it with the new one or add the new one after them",
+	}, RoutePolicy{RateClass: "chat", Budgeted: true}), func(ctx context.Context, input *struct {
+		ID            string `path:"id" doc:"Conversation ID"`
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token"`
+		Body          *ConversationRegenerateRequest
+	}) (*struct {
+		Body ConversationMessageResponse
+	}, error) {
+		provider, err := chatProvider(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := requireTermsAccepted(ctx, input.Authorization); err != nil {
+			return nil, err
+		}
+		c, err := findConversation(ctx, input.ID, input.Authorization)
+		if err != nil {
+			return nil, err
+		}
+		body := ConversationRegenerateRequest{Mode: "replace"}
+		if input.Body != nil {
+			body = *input.Body
+		}
+		turn := len(c.Messages) - 1
+		for turn >= 0 && c.Messages[turn].Role != openai.ChatMessageRoleUser {
+			turn--
+		}
+		if turn < 0 {
+			return nil, huma.Error409Conflict("The conversation has no message to reply to yet")
+		}
+		settings := c.ConversationSettings
+		if body.Model != "" {
+			settings.Model = body.Model
+		}
+		if body.Temperature != nil {
+			settings.Temperature = body.Temperature
+		}
+		if body.TopP != nil {
+			settings.TopP = body.TopP
+		}
+		if settings.Model, err = policyModel(ctx, settings.Model); err != nil {
+			return nil, err
+		}
+
+		req := conversationChatRequest(settings, c.Messages[turn].Content, replayHistory(c.Messages[:turn]))
+		// A cached reply would be the same one again
+		req.noCache = true
+		resp, err := completeChat(ctx, provider, "conversation", req, settings.Model)
+		if err != nil {
+			return nil, err
+		}
+		from := turn + 1
+		if body.Mode == "append" {
+			from = len(c.Messages)
+		}
+		n, err := conversationsStore().replaceReplies(ctx, c.ID, len(c.Messages), from,
+			ConversationMessage{ChatMessage: ChatMessage{Role: openai.ChatMessageRoleAssistant, Content: resp.Reply}, Settings: &settings})
+		if err != nil {
+			if errors.As(err, new(huma.StatusError)) {
+				return nil, err
+			}
+			return nil, huma.Error500InternalServerError("Failed to save the conversation", err)
+		}
+		recordTranscript(ctx, c, TranscriptTurn{
+			Time:        time.Now().UTC(),
+			Caller:      rateLimitCaller(input.Authorization),
+			Regenerated: true,
+			Message:     c.Messages[turn].Content,
+			Reply:       resp.Reply,
+			Settings:    settings,
+			Model:       resp.Model,
+			Usage:       resp.Usage,
+			Filter:      resp.Filter,
+		})
+		return &struct {
+			Body ConversationMessageResponse
+		}{Body: ConversationMessageResponse{ConversationID: c.ID, Reply: resp.Reply, Filter: resp.Filter, Messages: n, Settings: settings}}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "update-conversation-settings",
+		Method:      http.MethodPut,
+		Path:        "/conversations/{id}/settings",
+		Summary:     "Change a conversation's settings",
+		Description: "Replace the model and sampling settings the conversation's next messages are sent with. Earlier replies keep the settings they were generated with",
+	}, func(ctx context.Context, input *struct {
+		ID            string `path:"id" doc:"Conversation ID"`
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token"`
+		Body          ConversationSettings
+	}) (*struct {
+		Body ConversationSettings
+	}, error) {
+		c, err := findConversation(ctx, input.ID, input.Authorization)
+		if err != nil {
+			return nil, err
+		}
+		if input.Body.Model != "" {
+			if _, err := policyModel(ctx, input.Body.Model); err != nil {
+				return nil, err
+			}
+		}
+		updated, err := conversationsStore().updateSettings(ctx, c.ID, input.Body)
+		if err != nil {
+			if errors.As(err, new(huma.StatusError)) {
+				return nil, err
+			}
+			return nil, huma.Error500InternalServerError("Failed to save the conversation", err)
+		}
+		return &struct {
+			Body ConversationSettings
+		}{Body: updated.ConversationSettings}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "save-conversation-draft",
+		Method:      http.MethodPut,
+		Path:        "/conversations/{id}/draft",
+		Summary:     "Save an unsent message",
+		Description: "Keep the message the caller is writing in the conversation, so a client can restore it after a reload. Each caller has one draft per conversation, which expires after conversations.drafts.ttl_seconds",
+	}, func(ctx context.Context, input *struct {
+		ID            string `path:"id" doc:"Conversation ID"`
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token; the draft belongs to the caller"`
+		Body          ConversationDraftRequest
+	}) (*struct {
+		Body ConversationDraft
+	}, error) {
+		c, err := findConversation(ctx, input.ID, input.Authorization)
+		if err != nil {
+			return nil, err
+		}
+		d := conversationsStore().saveDraft(c.ID, rateLimitCaller(input.Authorization), input.Body.Message)
+		return &struct {
+			Body ConversationDraft
+		}{Body: d}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-conversation-draft",
+		Method:      http.MethodGet,
+		Path:        "/conversations/{id}/draft",
+		Summary:     "Get the unsent message",
+		Description: "Return the caller's draft in the conversation",
+	}, func(ctx context.Context, input *struct {
+		ID            string `path:"id" doc:"Conversation ID"`
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token"`
+	}) (*struct {
+		Body ConversationDraft
+	}, error) {
+		c, err := findConversation(ctx, input.ID, input.Authorization)
+		if err != nil {
+			return nil, err
+		}
+		d, ok := conversationsStore().draft(c.ID, rateLimitCaller(input.Authorization))
+		if !ok {
+			return nil, huma.Error404NotFound(fmt.Sprintf("No draft in conversation %s", c.ID))
+		}
+		return &struct {
+			Body ConversationDraft
+		}{Body: d}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID:   "delete-conversation-draft",
+		Method:        http.MethodDelete,
+		Path:          "/conversations/{id}/draft",
+		Summary:       "Discard the unsent message",
+		Description:   "Forget the caller's draft in the conversation",
+		DefaultStatus: http.StatusNoContent,
+	}, func(ctx context.Context, input *struct {
+		ID            string `path:"id" doc:"Conversation ID"`
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token"`
+	}) (*struct{}, error) {
+		c, err := findConversation(ctx, input.ID, input.Authorization)
+		if err != nil {
+			return nil, err
+		}
+		conversationsStore().deleteDraft(c.ID, rateLimitCaller(input.Authorization))
+		return nil, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID:   "delete-conversation",
+		Method:        http.MethodDelete,
+		Path:          "/conversations/{id}",
+		Summary:       "Delete a conversation",
+		Description:   "Forget a conversation and all of its messages, and delete its transcript",
+		DefaultStatus: http.StatusNoContent,
+	}, func(ctx context.Context, input *struct {
+		ID            string `path:"id" doc:"Conversation ID"`
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token"`
+	}) (*struct{}, error) {
+		c, err := findConversation(ctx, input.ID, input.Authorization)
+		if err != nil {
+			return nil, err
+		}
+		// The transcript goes first so that a failure leaves the
+		// conversation to delete again
+		if config.Conversations.Transcripts.Enabled {
+			if err := deleteTranscript(ctx, c.Tenant, c.ID); err != nil {
+				return nil, huma.Error500InternalServerError("Failed to delete the conversation's transcript", err)
+			}
+		}
+		if err := conversationsStore().delete(ctx, c.ID); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to delete the conversation", err)
+		}
+		return nil, nil
+	})
+}
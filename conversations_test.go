@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+// newConversationTestRouter serves the conversation endpoints with an
+// OpenAI server that answers "reply N" and records the messages it got.
+func newConversationTestRouter(t *testing.T) (http.Handler, *[][]openai.ChatCompletionMessage) {
+	viper.Reset()
+	initConfig()
+	config.Storage.Tenants = []StorageTenantConfig{
+		{Name: "acme", Token: "acme-token", Bucket: "tenants"},
+		{Name: "globex", Token: "globex-token", Bucket: "tenants"},
+	}
+	resetStore := func() {
+		conversations.Lock()
+		conversations.store = nil
+		conversations.Unlock()
+	}
+	resetStore()
+	t.Cleanup(resetStore)
+
+	var received [][]openai.ChatCompletionMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		received = append(received, req.Messages)
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: fmt.Sprintf("reply %d", len(received))},
+		}}})
+	}))
+	t.Cleanup(srv.Close)
+	cfg := openai.DefaultConfig("test")
+	cfg.BaseURL = srv.URL + "/v1"
+	services.SetOpenAI(openai.NewClientWithConfig(cfg))
+	t.Cleanup(func() { services.SetOpenAI(nil) })
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerConversationEndpoints(api)
+	return router, &received
+}
+
+func callConversations(router http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestConversations(t *testing.T) {
+	router, received := newConversationTestRouter(t)
+
+	w := callConversations(router, "POST", "/conversations", "acme-token", "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected the conversation to be created, got %d: %s", w.Code, w.Body.String())
+	}
+	var conv Conversation
+	json.Unmarshal(w.Body.Bytes(), &conv)
+	if !conversationIDPattern.MatchString(conv.ID) || conv.Tenant != "acme" {
+		t.Fatalf("Expected a tenant conversation, got %+v", conv)
+	}
+	path := "/conversations/" + conv.ID
+
+	for i, msg := range []string{"What is Go?", "Who made it?"} {
+		w := callConversations(router, "POST", path+"/messages", "acme-token", `{"message": "`+msg+`"}`)
+		var resp ConversationMessageResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusOK || resp.Reply != fmt.Sprintf("reply %d", i+1) || resp.Messages != 2*(i+1) {
+			t.Fatalf("Expected a reply, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	last := (*received)[1]
+	if len(last) != 3 || last[0].Content != "What is Go?" || last[1].Content != "reply 1" || last[2].Content != "Who made it?" {
+		t.Errorf("Expected the earlier messages to be replayed, got %+v", last)
+	}
+
+	w = callConversations(router, "GET", path, "acme-token", "")
+	json.Unmarshal(w.Body.Bytes(), &conv)
+	if w.Code != http.StatusOK || len(conv.Messages) != 4 || conv.Messages[3].Role != "assistant" {
+		t.Errorf("Expected all four messages, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, token := range []string{"globex-token", ""} {
+		if w := callConversations(router, "GET", path, token, ""); w.Code != http.StatusNotFound {
+			t.Errorf("Expected the conversation to be hidden from %q, got %d", token, w.Code)
+		}
+	}
+	if w := callConversations(router, "POST", path+"/messages", "globex-token", `{"message": "Hi"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected other tenants not to post, got %d", w.Code)
+	}
+
+	config.Conversations.MaxHistory = 2
+	callConversations(router, "POST", path+"/messages", "acme-token", `{"message": "And when?"}`)
+	if last := (*received)[len(*received)-1]; len(last) != 3 || last[0].Content != "Who made it?" {
+		t.Errorf("Expected only the last two messages to be replayed, got %+v", last)
+	}
+
+	if w := callConversations(router, "DELETE", path, "acme-token", ""); w.Code != http.StatusNoContent {
+		t.Errorf("Expected the conversation to be deleted, got %d", w.Code)
+	}
+	if w := callConversations(router, "GET", path, "acme-token", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a deleted conversation to be gone, got %d", w.Code)
+	}
+	if w := callConversations(router, "POST", "/conversations/conv_nope/messages", "", `{"message": "Hi"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown conversation to be rejected, got %d", w.Code)
+	}
+}
+
+func TestConversationRegenerate(t *testing.T) {
+	router, received := newConversationTestRouter(t)
+	w := callConversations(router, "POST", "/conversations", "acme-token", "")
+	var conv Conversation
+	json.Unmarshal(w.Body.Bytes(), &conv)
+	path := "/conversations/" + conv.ID
+
+	if w := callConversations(router, "POST", path+"/regenerate", "acme-token", ""); w.Code != http.StatusConflict {
+		t.Errorf("Expected nothing to regenerate in an empty conversation, got %d", w.Code)
+	}
+	callConversations(router, "POST", path+"/messages", "acme-token", `{"message": "What is Go?"}`)
+	callConversations(router, "POST", path+"/messages", "acme-token", `{"message": "Who made it?"}`)
+
+	w = callConversations(router, "POST", path+"/regenerate", "acme-token", `{"temperature": 1.5}`)
+	var resp ConversationMessageResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Reply != "reply 3" || resp.Messages != 4 {
+		t.Fatalf("Expected the reply to be replaced, got %d: %s", w.Code, w.Body.String())
+	}
+	if last := (*received)[2]; len(last) != 3 || last[2].Content != "Who made it?" {
+		t.Errorf("Expected the last message to be sent again without its reply, got %+v", last)
+	}
+
+	w = callConversations(router, "POST", path+"/regenerate", "acme-token", `{"mode": "append"}`)
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Reply != "reply 4" || resp.Messages != 5 {
+		t.Fatalf("Expected the reply to be appended, got %d: %s", w.Code, w.Body.String())
+	}
+	if last := (*received)[3]; len(last) != 3 {
+		t.Errorf("Expected the earlier replies to the message not to be sent, got %+v", last)
+	}
+	w = callConversations(router, "GET", path, "acme-token", "")
+	json.Unmarshal(w.Body.Bytes(), &conv)
+	var contents []string
+	for _, m := range conv.Messages {
+		contents = append(contents, m.Content)
+	}
+	if got := strings.Join(contents, "|"); got != "What is Go?|reply 1|Who made it?|reply 3|reply 4" {
+		t.Errorf("Unexpected messages %s", got)
+	}
+
+	if w := callConversations(router, "POST", path+"/regenerate", "globex-token", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected other tenants not to regenerate, got %d", w.Code)
+	}
+}
+
+func TestConversationSettings(t *testing.T) {
+	router, _ := newConversationTestRouter(t)
+	var sent openai.ChatCompletionRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = openai.ChatCompletionRequest{}
+		json.NewDecoder(r.Body).Decode(&sent)
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "Hello"}}}})
+	}))
+	defer srv.Close()
+	services.SetOpenAI(newTestOpenAIClient(srv.URL))
+
+	w := callConversations(router, "POST", "/conversations", "acme-token", `{"model": "gpt-4o", "temperature": 0.5, "max_tokens": 100}`)
+	var conv Conversation
+	json.Unmarshal(w.Body.Bytes(), &conv)
+	if w.Code != http.StatusCreated || conv.Temperature == nil || *conv.Temperature != 0.5 || conv.MaxTokens != 100 {
+		t.Fatalf("Expected the conversation to keep its settings, got %d: %s", w.Code, w.Body.String())
+	}
+	path := "/conversations/" + conv.ID
+	callConversations(router, "POST", path+"/messages", "acme-token", `{"message": "Hi"}`)
+	if sent.Model != "gpt-4o" || sent.Temperature != 0.5 || sent.MaxTokens != 100 {
+		t.Errorf("Expected the conversation's settings to be sent, got %+v", sent)
+	}
+
+	w = callConversations(router, "PUT", path+"/settings", "acme-token", `{"model": "gpt-4o-mini", "top_p": 0.9}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the settings to be updated, got %d: %s", w.Code, w.Body.String())
+	}
+	w = callConversations(router, "POST", path+"/messages", "acme-token", `{"message": "And now?"}`)
+	var resp ConversationMessageResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if sent.Model != "gpt-4o-mini" || sent.TopP != 0.9 || sent.Temperature != 0 || sent.MaxTokens != 0 || resp.Settings.Model != "gpt-4o-mini" {
+		t.Errorf("Expected the new settings to be used, got %+v and %+v", sent, resp.Settings)
+	}
+
+	// Each reply records what it was generated with
+	w = callConversations(router, "GET", path, "acme-token", "")
+	json.Unmarshal(w.Body.Bytes(), &conv)
+	if len(conv.Messages) != 4 || conv.Messages[0].Settings != nil || conv.Model != "gpt-4o-mini" {
+		t.Fatalf("Expected the conversation with its new settings, got %s", w.Body.String())
+	}
+	if s := conv.Messages[1].Settings; s == nil || s.Model != "gpt-4o" || s.Temperature == nil || *s.Temperature != 0.5 {
+		t.Errorf("Expected the first reply to keep its settings, got %+v", s)
+	}
+	if s := conv.Messages[3].Settings; s == nil || s.Model != "gpt-4o-mini" || s.TopP == nil || s.Temperature != nil {
+		t.Errorf("Expected the second reply to have the new settings, got %+v", s)
+	}
+
+	if w := callConversations(router, "PUT", path+"/settings", "acme-token", `{"temperature": 3}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected an invalid temperature to be rejected, got %d", w.Code)
+	}
+	if w := callConversations(router, "PUT", path+"/settings", "globex-token", `{}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected another tenant's conversation to be hidden, got %d", w.Code)
+	}
+}
+
+func TestConversationDrafts(t *testing.T) {
+	router, _ := newConversationTestRouter(t)
+	config.Auth.AdminToken = "admin-token"
+
+	// A conversation without a tenant is shared, but each caller has its
+	// own draft
+	w := callConversations(router, "POST", "/conversations", "", "")
+	var conv Conversation
+	json.Unmarshal(w.Body.Bytes(), &conv)
+	path := "/conversations/" + conv.ID + "/draft"
+	if w := callConversations(router, "GET", path, "acme-token", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected no draft yet, got %d", w.Code)
+	}
+	w = callConversations(router, "PUT", path, "acme-token", `{"message": "Dear"}`)
+	var draft ConversationDraft
+	json.Unmarshal(w.Body.Bytes(), &draft)
+	if w.Code != http.StatusOK || draft.Message != "Dear" || draft.ExpiresAt.Sub(draft.UpdatedAt) != 7*24*time.Hour {
+		t.Fatalf("Expected the draft to be saved for a week, got %d: %s", w.Code, w.Body.String())
+	}
+	callConversations(router, "PUT", path, "acme-token", `{"message": "Dear Sir"}`)
+	callConversations(router, "PUT", path, "admin-token", `{"message": "Hello"}`)
+	for token, want := range map[string]string{"acme-token": "Dear Sir", "admin-token": "Hello"} {
+		w := callConversations(router, "GET", path, token, "")
+		json.Unmarshal(w.Body.Bytes(), &draft)
+		if w.Code != http.StatusOK || draft.Message != want {
+			t.Errorf("Expected the draft %q for %s, got %d: %s", want, token, w.Code, w.Body.String())
+		}
+	}
+	if w := callConversations(router, "GET", path, "globex-token", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected no draft of another caller, got %d", w.Code)
+	}
+
+	// Sending a message clears the sender's draft
+	callConversations(router, "POST", "/conversations/"+conv.ID+"/messages", "acme-token", `{"message": "Dear Sir"}`)
+	if w := callConversations(router, "GET", path, "acme-token", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the sent draft to be cleared, got %d", w.Code)
+	}
+	if w := callConversations(router, "DELETE", path, "admin-token", ""); w.Code != http.StatusNoContent {
+		t.Errorf("Expected the draft to be discarded, got %d", w.Code)
+	}
+	if w := callConversations(router, "GET", path, "admin-token", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the discarded draft to be gone, got %d", w.Code)
+	}
+
+	if w := callConversations(router, "PUT", path, "acme-token", `{"message": ""}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected an empty draft to be rejected, got %d", w.Code)
+	}
+	w = callConversations(router, "POST", "/conversations", "acme-token", "")
+	json.Unmarshal(w.Body.Bytes(), &conv)
+	if w := callConversations(router, "PUT", "/conversations/"+conv.ID+"/draft", "globex-token", `{"message": "Hi"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected another tenant's conversation to be hidden, got %d", w.Code)
+	}
+}
+
+func TestConversationsMinIOPersistence(t *testing.T) {
+	router, _ := newConversationTestRouter(t)
+	config.Conversations.Persistence = "minio"
+
+	objects := map[string]string{}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	w := callConversations(router, "POST", "/conversations", "", `{"model": "gpt-4o-mini"}`)
+	var conv Conversation
+	json.Unmarshal(w.Body.Bytes(), &conv)
+	if w.Code != http.StatusCreated || conv.Model != "gpt-4o-mini" {
+		t.Fatalf("Expected the conversation to be created, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := callConversations(router, "POST", "/conversations/"+conv.ID+"/messages", "", `{"message": "Hi"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected a reply, got %d: %s", w.Code, w.Body.String())
+	}
+	key := "app-system/conversations/" + conv.ID + ".json"
+	if !strings.Contains(objects[key], "reply 1") {
+		t.Errorf("Expected the conversation to be persisted, got %v", objects)
+	}
+
+	// A fresh store, as after a restart, reloads the conversation
+	conversations.Lock()
+	conversations.store = nil
+	conversations.Unlock()
+	w = callConversations(router, "GET", "/conversations/"+conv.ID, "", "")
+	json.Unmarshal(w.Body.Bytes(), &conv)
+	if w.Code != http.StatusOK || len(conv.Messages) != 2 {
+		t.Errorf("Expected the conversation to be reloaded, got %d: %s", w.Code, w.Body.String())
+	}
+
+	callConversations(router, "DELETE", "/conversations/"+conv.ID, "", "")
+	if _, ok := objects[key]; ok {
+		t.Error("Expected the persisted conversation to be deleted")
+	}
+}
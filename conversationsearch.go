@@ -0,0 +1,238 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// maxEmbeddedTurnLength bounds the text of a turn that is embedded, well
+// within the input limit of the embedding model.
+const maxEmbeddedTurnLength = 8000
+
+type ConversationSearchHit struct {
+	ConversationID string    `json:"conversation_id" doc:"Conversation ID"`
+	Tenant         string    `json:"tenant,omitempty" doc:"Tenant that owns the conversation"`
+	Time           time.Time `json:"time" doc:"When the exchange completed"`
+	Message        string    `json:"message" doc:"Message of the exchange"`
+	Reply          string    `json:"reply" doc:"Reply of the exchange"`
+	Model          string    `json:"model,omitempty" doc:"Model that replied"`
+	Regenerated    bool      `json:"regenerated,omitempty" doc:"Whether the reply was regenerated"`
+	Score          float64   `json:"score" doc:"Relevance: the number of keyword matches, or the cosine similarity in semantic mode"`
+}
+
+type ConversationSearchResponse struct {
+	Results   []ConversationSearchHit `json:"results" doc:"Matching exchanges, most relevant first"`
+	Searched  int                     `json:"searched" doc:"Transcripts searched"`
+	Truncated bool                    `json:"truncated" doc:"Whether older transcripts or exchanges were left out; narrow the search to find them"`
+}
+
+// transcriptEmbeddings caches the vectors of transcript turns by the hash
+// of their text, so repeated semantic searches only embed new turns.
+var transcriptEmbeddings struct {
+	sync.Mutex
+	store *lruStore[[]float32]
+}
+
+func transcriptEmbeddingStore() *lruStore[[]float32] {
+	transcriptEmbeddings.Lock()
+	defer transcriptEmbeddings.Unlock()
+	if transcriptEmbeddings.store == nil {
+		transcriptEmbeddings.store = newLRUStore("transcript_embeddings", config.Stores.TranscriptEmbeddings, func(v []float32) int64 { return int64(4 * len(v)) })
+	}
+	return transcriptEmbeddings.store
+}
+
+// searchableTranscripts reads the transcripts of tenant, or all of them if
+// tenant is empty, most recently written first. A tenant's transcripts are
+// listed from its own folder. It reads at most
+// conversations.transcripts.search_max_transcripts objects and reports
+// whether there were more.
+func searchableTranscripts(ctx context.Context, tenant string) ([]Transcript, bool, error) {
+	client, err := services.MinIO()
+	if err != nil {
+		return nil, false, err
+	}
+	prefix := config.Conversations.Transcripts.Prefix
+	if tenant != "" {
+		prefix = transcriptDir(tenant)
+	}
+	var objects []minio.ObjectInfo
+	for obj := range client.ListObjects(ctx, transcriptBucket(), minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			// No bucket yet means no transcripts
+			if isNotFound(obj.Err) {
+				break
+			}
+			return nil, false, obj.Err
+		}
+		if strings.HasSuffix(obj.Key, ".json") {
+			objects = append(objects, obj)
+		}
+	}
+	slices.SortFunc(objects, func(a, b minio.ObjectInfo) int { return b.LastModified.Compare(a.LastModified) })
+
+	var transcripts []Transcript
+	truncated := false
+	if limit := config.Conversations.Transcripts.SearchMaxTranscripts; len(objects) > limit {
+		objects, truncated = objects[:limit], true
+	}
+	for _, obj := range objects {
+		var t Transcript
+		if err := getJSON(ctx, transcriptBucket(), obj.Key, &t); err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return nil, false, err
+		}
+		if tenant == "" || t.Tenant == tenant {
+			transcripts = append(transcripts, t)
+		}
+	}
+	return transcripts, truncated, nil
+}
+
+// keywordScore counts the occurrences of the words in text, or returns 0
+// unless all of them occur. words are lower case.
+func keywordScore(words []string, text string) float64 {
+	text = strings.ToLower(text)
+	var score float64
+	for _, w := range words {
+		n := strings.Count(text, w)
+		if n == 0 {
+			return 0
+		}
+		score += float64(n)
+	}
+	return score
+}
+
+// embedTurns scores hits by their similarity to query. Vectors of turns
+// embedded before are taken from the cache.
+func embedTurns(ctx context.Context, provider ChatProvider, query string, hits []ConversationSearchHit) error {
+	texts := []string{query}
+	for _, h := range hits {
+		text := h.Message + "\n\n" + h.Reply
+		if len(text) > maxEmbeddedTurnLength {
+			// Cut at the start of a rune so that no character is split
+			end := maxEmbeddedTurnLength
+			for end > 0 && !utf8.RuneStart(text[end]) {
+				end--
+			}
+			text = text[:end]
+		}
+		texts = append(texts, text)
+	}
+	vectors, err := embedCached(ctx, provider, transcriptEmbeddingStore(), texts)
+	if err != nil {
+		return err
+	}
+	for i := range hits {
+		hits[i].Score = cosineSimilarity(vectors[0], vectors[i+1])
+	}
+	return nil
+}
+
+func registerConversationSearchEndpoint(api huma.API) {
+	huma.Register(api, withRoutePolicy(huma.Operation{
+		OperationID: "search-conversations",
+		Method:      http.MethodGet,
+		Path:        "/conversations/search",
+		Summary:     "Search conversation transcripts",
+		Description: "Find exchanges in the transcripts of the caller's conversations by keywords or by meaning, optionally within a time range or for a model. Tenants search their own conversations; the admin searches all of them",
+	}, RoutePolicy{RateClass: "generation", Budgeted: true}), func(ctx context.Context, input *struct {
+		Authorization string    `header:"Authorization" doc:"Bearer tenant or admin token"`
+		Query         string    `query:"q" required:"true" minLength:"1" doc:"Keywords, all of which must occur in the message or reply; in semantic mode, a description of what to find"`
+		Mode          string    `query:"mode" enum:"keyword,semantic" default:"keyword" doc:"keyword matches the words; semantic ranks exchanges by the similarity of their embeddings to the query"`
+		From          time.Time `query:"from" doc:"Only exchanges at or after this time, e.g. 2026-09-01T00:00:00Z"`
+		To            time.Time `query:"to" doc:"Only exchanges before this time"`
+		Model         string    `query:"model" doc:"Only exchanges with this model, or with models it is a prefix of"`
+		Tenant        string    `query:"tenant" doc:"Only conversations of this tenant; admin only"`
+		Limit         int       `query:"limit" minimum:"1" maximum:"100" default:"20" doc:"Most results to return"`
+	}) (*struct {
+		Body ConversationSearchResponse
+	}, error) {
+		if !config.Conversations.Transcripts.Enabled {
+			return nil, huma.Error404NotFound("Transcripts are not enabled; set conversations.transcripts.enabled")
+		}
+		caller := objectCaller(input.Authorization)
+		tenant := caller.tenant
+		switch {
+		case caller.admin:
+			tenant = input.Tenant
+		case tenant == "":
+			return nil, huma.Error401Unauthorized("Searching conversations requires a tenant or admin token")
+		case input.Tenant != "" && input.Tenant != tenant:
+			return nil, huma.Error403Forbidden("Tenants can only search their own conversations")
+		}
+		if !input.From.IsZero() && !input.To.IsZero() && !input.From.Before(input.To) {
+			return nil, huma.Error422UnprocessableEntity("from must be before to")
+		}
+
+		transcripts, truncated, err := searchableTranscripts(ctx, tenant)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to read the transcripts", err)
+		}
+		words := strings.Fields(strings.ToLower(input.Query))
+		hits := []ConversationSearchHit{}
+		for _, t := range transcripts {
+			for _, turn := range t.Turns {
+				model := cmp.Or(turn.Model, turn.Settings.Model)
+				if !input.From.IsZero() && turn.Time.Before(input.From) ||
+					!input.To.IsZero() && !turn.Time.Before(input.To) ||
+					input.Model != "" && !strings.HasPrefix(model, input.Model) {
+					continue
+				}
+				hit := ConversationSearchHit{
+					ConversationID: t.ConversationID,
+					Tenant:         t.Tenant,
+					Time:           turn.Time,
+					Message:        turn.Message,
+					Reply:          turn.Reply,
+					Model:          model,
+					Regenerated:    turn.Regenerated,
+				}
+				if input.Mode == "semantic" {
+					hits = append(hits, hit)
+				} else if hit.Score = keywordScore(words, turn.Message+"\n"+turn.Reply); hit.Score > 0 {
+					hits = append(hits, hit)
+				}
+			}
+		}
+
+		if input.Mode == "semantic" && len(hits) > 0 {
+			// Only the newest exchanges are embedded
+			slices.SortFunc(hits, func(a, b ConversationSearchHit) int { return b.Time.Compare(a.Time) })
+			if n := config.Embeddings.MaxInputs - 1; len(hits) > n {
+				hits, truncated = hits[:n], true
+			}
+			provider, err := chatProvider(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if err := embedTurns(ctx, provider, input.Query, hits); err != nil {
+				return nil, openAIError(ctx, "Failed to embed the transcripts", err)
+			}
+		}
+		slices.SortStableFunc(hits, func(a, b ConversationSearchHit) int {
+			if a.Score != b.Score {
+				return cmp.Compare(b.Score, a.Score)
+			}
+			return b.Time.Compare(a.Time)
+		})
+		if len(hits) > input.Limit {
+			hits = hits[:input.Limit]
+		}
+		return &struct {
+			Body ConversationSearchResponse
+		}{Body: ConversationSearchResponse{Results: hits, Searched: len(transcripts), Truncated: truncated}}, nil
+	})
+}
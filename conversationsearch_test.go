@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+func TestConversationSearch(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "admin-token"
+	config.Storage.Tenants = []StorageTenantConfig{
+		{Name: "acme", Token: "acme-token", Bucket: "tenants"},
+		{Name: "globex", Token: "globex-token", Bucket: "tenants"},
+	}
+	defer func() { config.Storage.Tenants = nil }()
+	config.Conversations.Transcripts.Enabled = true
+	transcriptEmbeddings.store = nil
+
+	transcripts := []Transcript{
+		{ConversationID: "conv_1", Tenant: "acme", Turns: []TranscriptTurn{
+			{Time: time.Date(2026, 8, 3, 10, 0, 0, 0, time.UTC), Message: "How do I rotate the MinIO keys?", Reply: "Use POST /admin/credentials.", Model: "gpt-4o-2024-08-06"},
+			{Time: time.Date(2026, 9, 14, 10, 0, 0, 0, time.UTC), Message: "And the OpenAI key?", Reply: "Rotate it in the OpenAI dashboard, then update the keys.", Model: "gpt-4o-mini"},
+		}},
+		{ConversationID: "conv_2", Tenant: "globex", Turns: []TranscriptTurn{
+			{Time: time.Date(2026, 9, 20, 10, 0, 0, 0, time.UTC), Message: "Rotate keys?", Reply: "Yes, rotate the keys.", Model: "gpt-4o"},
+		}},
+		{ConversationID: "conv_3", Tenant: "acme", Turns: []TranscriptTurn{
+			{Time: time.Date(2026, 9, 21, 10, 0, 0, 0, time.UTC), Message: "Write a poem about cats", Reply: "Whiskers in the sun", Model: "gpt-4o"},
+		}},
+	}
+	objects := map[string]string{}
+	for _, tr := range transcripts {
+		data, _ := json.Marshal(tr)
+		objects["app-system/"+transcriptKey(tr.Tenant, tr.ConversationID)] = string(data)
+	}
+	// acme may no longer use the models of its old exchanges
+	objects["app-system/"+policyKeyName("acme")] = `{"models": ["gpt-4o"]}`
+	// Another tenant's transcripts are not read, so they do not count
+	// against the limit
+	config.Conversations.Transcripts.SearchMaxTranscripts = 2
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	// Texts about cats point one way, all others another
+	var embedded int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Input []string }
+		json.NewDecoder(r.Body).Decode(&req)
+		resp := openai.EmbeddingResponse{}
+		for i, text := range req.Input {
+			v := []float32{1, 0.1}
+			if strings.Contains(strings.ToLower(text), "cat") {
+				v = []float32{0.1, 1}
+			}
+			resp.Data = append(resp.Data, openai.Embedding{Index: i, Embedding: v})
+		}
+		embedded += len(req.Input)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+	services.SetOpenAI(newTestOpenAIClient(srv.URL))
+	defer services.SetOpenAI(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	api.UseMiddleware(tenantPolicyMiddleware)
+	registerConversationSearchEndpoint(api)
+	search := func(token string, query url.Values) (int, ConversationSearchResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/conversations/search?"+query.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp ConversationSearchResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	ids := func(resp ConversationSearchResponse) string {
+		var ids []string
+		for _, h := range resp.Results {
+			ids = append(ids, h.ConversationID+"@"+h.Time.Format("01-02"))
+		}
+		return strings.Join(ids, " ")
+	}
+
+	// Tenants only find their own exchanges, the most matches and then the
+	// newest first
+	code, resp := search("acme-token", url.Values{"q": {"rotate KEYS"}})
+	if code != http.StatusOK || ids(resp) != "conv_1@09-14 conv_1@08-03" || resp.Searched != 2 {
+		t.Errorf("Expected the tenant's exchanges about rotating keys, got %d: %s", code, ids(resp))
+	}
+	if resp.Truncated {
+		t.Error("Expected the tenant's search not to be truncated")
+	}
+	if _, resp := search("admin-token", url.Values{"q": {"rotate keys"}}); resp.Searched != 2 || !resp.Truncated {
+		t.Errorf("Expected the admin's search to stop after 2 transcripts, got %+v", resp)
+	}
+	config.Conversations.Transcripts.SearchMaxTranscripts = 1000
+	if _, resp := search("admin-token", url.Values{"q": {"rotate keys"}}); len(resp.Results) != 3 {
+		t.Errorf("Expected the admin to search every tenant, got %s", ids(resp))
+	}
+	for _, tc := range []struct {
+		query url.Values
+		want  string
+	}{
+		{url.Values{"q": {"rotate"}, "from": {"2026-09-01T00:00:00Z"}}, "conv_1@09-14"},
+		{url.Values{"q": {"rotate"}, "to": {"2026-09-01T00:00:00Z"}}, "conv_1@08-03"},
+		// The filter matches stored models, even those the policy no
+		// longer allows
+		{url.Values{"q": {"rotate"}, "model": {"gpt-4o-mini"}}, "conv_1@09-14"},
+		{url.Values{"q": {"rotate"}, "model": {"gpt-4o-2024"}}, "conv_1@08-03"},
+		{url.Values{"q": {"rotate"}, "limit": {"1"}}, "conv_1@09-14"},
+		{url.Values{"q": {"rotate dogs"}}, ""},
+	} {
+		if _, resp := search("acme-token", tc.query); ids(resp) != tc.want {
+			t.Errorf("Expected %s to find %q, got %q", tc.query.Encode(), tc.want, ids(resp))
+		}
+	}
+
+	// Semantic search ranks by meaning, and embeds each exchange once
+	code, resp = search("acme-token", url.Values{"q": {"that answer about my cat"}, "mode": {"semantic"}})
+	if code != http.StatusOK || len(resp.Results) != 3 || resp.Results[0].ConversationID != "conv_3" || resp.Results[0].Score < 0.99 {
+		t.Errorf("Expected the exchange about cats first, got %d: %+v", code, resp.Results)
+	}
+	search("acme-token", url.Values{"q": {"kittens"}, "mode": {"semantic"}})
+	if embedded != 5 {
+		t.Errorf("Expected the exchanges to be embedded once, got %d texts embedded", embedded)
+	}
+
+	for _, tc := range []struct {
+		token string
+		query url.Values
+		want  int
+	}{
+		{"", url.Values{"q": {"keys"}}, http.StatusUnauthorized},
+		{"acme-token", url.Values{"q": {"keys"}, "tenant": {"globex"}}, http.StatusForbidden},
+		{"acme-token", url.Values{"q": {"keys"}, "from": {"2026-10-01T00:00:00Z"}, "to": {"2026-09-01T00:00:00Z"}}, http.StatusUnprocessableEntity},
+	} {
+		if code, _ := search(tc.token, tc.query); code != tc.want {
+			t.Errorf("Expected %s with token %q to get %d, got %d", tc.query.Encode(), tc.token, tc.want, code)
+		}
+	}
+	config.Conversations.Transcripts.Enabled = false
+	if code, _ := search("acme-token", url.Values{"q": {"keys"}}); code != http.StatusNotFound {
+		t.Errorf("Expected search to need transcripts, got %d", code)
+	}
+}
+
+func TestEmbedTurnsTruncatesOnRunes(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	transcriptEmbeddings.store = nil
+	defer func() { transcriptEmbeddings.store = nil }()
+
+	var inputs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Input []string }
+		json.NewDecoder(r.Body).Decode(&req)
+		inputs = req.Input
+		resp := openai.EmbeddingResponse{}
+		for i := range req.Input {
+			resp.Data = append(resp.Data, openai.Embedding{Index: i, Embedding: []float32{1, 0}})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	// The limit falls into the middle of a three-byte rune
+	hits := []ConversationSearchHit{{Message: "a", Reply: strings.Repeat("€", maxEmbeddedTurnLength)}}
+	if err := embedTurns(context.Background(), newTestOpenAIClient(srv.URL), "query", hits); err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 2 || !utf8.ValidString(inputs[1]) || len(inputs[1]) > maxEmbeddedTurnLength || len(inputs[1]) < maxEmbeddedTurnLength-3 {
+		t.Errorf("Expected the turn to be cut at a rune boundary, got %d bytes", len(inputs[1]))
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+	"github.com/sashabaranov/go-openai"
+)
+
+// CostsConfig prices OpenAI usage and caps what callers may spend on it.
+type CostsConfig struct {
+	Prices            []ModelPriceConfig `mapstructure:"prices" doc:"USD prices of the models"`
+	DailyBudget       float64            `mapstructure:"daily_budget" doc:"USD all callers together may spend per UTC day; 0 means unlimited"`
+	CallerDailyBudget float64            `mapstructure:"caller_daily_budget" doc:"USD each caller may spend per UTC day unless set in caller_budgets; 0 means unlimited"`
+	CallerBudgets     map[string]float64 `mapstructure:"caller_budgets" doc:"USD callers may spend per UTC day by caller: admin, anonymous or tenant:<name>"`
+	RetentionDays     int                `mapstructure:"retention_days" doc:"UTC days of spend kept for GET /admin/costs"`
+	FlushSeconds      int                `mapstructure:"flush_seconds" doc:"Seconds between writes of the spend to the system bucket"`
+}
+
+type ModelPriceConfig struct {
+	Model                string  `mapstructure:"model" doc:"Model name or prefix, e.g. gpt-4o; the longest matching one applies"`
+	PromptPerMillion     float64 `mapstructure:"prompt_per_million" doc:"USD per million prompt tokens"`
+	CompletionPerMillion float64 `mapstructure:"completion_per_million" doc:"USD per million completion tokens"`
+}
+
+// defaultModelPrices are OpenAI's list prices of the models the service
+// uses, as costs.prices entries.
+var defaultModelPrices = []map[string]any{
+	{"model": "gpt-3.5-turbo", "prompt_per_million": 0.5, "completion_per_million": 1.5},
+	{"model": "gpt-4", "prompt_per_million": 30, "completion_per_million": 60},
+	{"model": "gpt-4-turbo", "prompt_per_million": 10, "completion_per_million": 30},
+	{"model": "gpt-4o", "prompt_per_million": 2.5, "completion_per_million": 10},
+	{"model": "gpt-4o-mini", "prompt_per_million": 0.15, "completion_per_million": 0.6},
+	{"model": "gpt-4.1", "prompt_per_million": 2, "completion_per_million": 8},
+	{"model": "gpt-4.1-mini", "prompt_per_million": 0.4, "completion_per_million": 1.6},
+	{"model": "text-embedding-ada-002", "prompt_per_million": 0.1, "completion_per_million": 0},
+	{"model": "text-embedding-3-small", "prompt_per_million": 0.02, "completion_per_million": 0},
+	{"model": "text-embedding-3-large", "prompt_per_million": 0.13, "completion_per_million": 0},
+}
+
+func (c CostsConfig) Validate() error {
+	for _, p := range c.Prices {
+		if p.Model == "" {
+			return errors.New("costs.prices: every price needs a model")
+		}
+		if p.PromptPerMillion < 0 || p.CompletionPerMillion < 0 {
+			return fmt.Errorf("costs.prices.%s: prices must not be negative", p.Model)
+		}
+	}
+	if c.DailyBudget < 0 || c.CallerDailyBudget < 0 {
+		return errors.New("costs: budgets must not be negative")
+	}
+	for caller, budget := range c.CallerBudgets {
+		if budget < 0 {
+			return fmt.Errorf("costs.caller_budgets.%s must not be negative", caller)
+		}
+	}
+	if c.RetentionDays < 1 || c.FlushSeconds < 1 {
+		return errors.New("costs.retention_days and costs.flush_seconds must be positive")
+	}
+	return nil
+}
+
+// callerBudget returns the daily budget of caller; 0 means unlimited.
+// Configuration keys are lower case.
+func (c CostsConfig) callerBudget(caller string) float64 {
+	if b, ok := c.CallerBudgets[strings.ToLower(caller)]; ok {
+		return b
+	}
+	return c.CallerDailyBudget
+}
+
+// estimateCost prices u at the rates of model, 0 if it has none.
+func estimateCost(model string, u openai.Usage) float64 {
+	var price *ModelPriceConfig
+	for i, p := range config.Costs.Prices {
+		if strings.HasPrefix(model, p.Model) && (price == nil || len(p.Model) > len(price.Model)) {
+			price = &config.Costs.Prices[i]
+		}
+	}
+	if price == nil {
+		return 0
+	}
+	return (float64(u.PromptTokens)*price.PromptPerMillion + float64(u.CompletionTokens)*price.CompletionPerMillion) / 1e6
+}
+
+// costsPrefix is where the spend of each day is stored in the system
+// bucket, as costs/<YYYY-MM-DD>.json.
+const costsPrefix = "costs/"
+
+func costsKey(day string) string {
+	return costsPrefix + day + ".json"
+}
+
+// callerSpend is what a caller spent on one day.
+type callerSpend struct {
+	Calls            int64   `json:"calls"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+func (s *callerSpend) add(o callerSpend) {
+	s.Calls += o.Calls
+	s.PromptTokens += o.PromptTokens
+	s.CompletionTokens += o.CompletionTokens
+	s.Cost += o.Cost
+}
+
+// addSpend adds the spend in from to the callers of to.
+func addSpend(to, from map[string]*callerSpend) {
+	for caller, s := range from {
+		if to[caller] == nil {
+			to[caller] = &callerSpend{}
+		}
+		to[caller].add(*s)
+	}
+}
+
+// costTracker adds up the spend of each caller by UTC day. The days are
+// stored in the system bucket, so spend survives restarts and replicas see
+// each other's: pending holds what was recorded since the last flush, and
+// days the stored spend plus pending.
+type costTracker struct {
+	mu      sync.Mutex
+	days    map[string]map[string]*callerSpend
+	pending map[string]map[string]*callerSpend
+}
+
+func newCostTracker() *costTracker {
+	return &costTracker{days: map[string]map[string]*callerSpend{}, pending: map[string]map[string]*callerSpend{}}
+}
+
+var costs = newCostTracker()
+
+type costCallerKey struct{}
+
+// costCaller returns who the OpenAI calls made for ctx are charged to.
+// Calls made outside of a budgeted request are charged to "system".
+func costCaller(ctx context.Context) string {
+	if caller, ok := ctx.Value(costCallerKey{}).(string); ok {
+		return caller
+	}
+	return "system"
+}
+
+// record charges an OpenAI call to caller and drops days past the
+// retention.
+func (t *costTracker) record(caller string, u openai.Usage, cost float64, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	day := now.UTC().Format(time.DateOnly)
+	callers := t.days[day]
+	if callers == nil {
+		callers = map[string]*callerSpend{}
+		t.days[day] = callers
+		oldest := now.UTC().AddDate(0, 0, 1-config.Costs.RetentionDays).Format(time.DateOnly)
+		for d := range t.days {
+			if d < oldest {
+				delete(t.days, d)
+			}
+		}
+	}
+	spend := map[string]*callerSpend{caller: {
+		Calls:            1,
+		PromptTokens:     int64(u.PromptTokens),
+		CompletionTokens: int64(u.CompletionTokens),
+		Cost:             cost,
+	}}
+	addSpend(callers, spend)
+	if t.pending[day] == nil {
+		t.pending[day] = map[string]*callerSpend{}
+	}
+	addSpend(t.pending[day], spend)
+}
+
+// flush adds the pending spend to the stored days. Spend that cannot be
+// stored stays pending for the next flush. Each stored day is read back,
+// so the totals include what other replicas stored.
+func (t *costTracker) flush(ctx context.Context) error {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = map[string]map[string]*callerSpend{}
+	t.mu.Unlock()
+
+	if len(pending) > 0 {
+		if err := ensureBucket(ctx, config.MinIO.SystemBucket); err != nil {
+			t.requeue(pending)
+			return err
+		}
+	}
+	var errs []error
+	for day, spend := range pending {
+		stored := map[string]*callerSpend{}
+		err := getJSON(ctx, config.MinIO.SystemBucket, costsKey(day), &stored)
+		if err == nil || isNotFound(err) {
+			addSpend(stored, spend)
+			err = putJSON(ctx, config.MinIO.SystemBucket, costsKey(day), stored)
+		}
+		if err != nil {
+			t.requeue(map[string]map[string]*callerSpend{day: spend})
+			errs = append(errs, fmt.Errorf("%s: %w", day, err))
+			continue
+		}
+		t.mu.Lock()
+		if _, ok := t.days[day]; ok {
+			// Spend recorded while the day was stored is still pending
+			addSpend(stored, t.pending[day])
+			t.days[day] = stored
+		}
+		t.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+func (t *costTracker) requeue(pending map[string]map[string]*callerSpend) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for day, spend := range pending {
+		if t.pending[day] == nil {
+			t.pending[day] = map[string]*callerSpend{}
+		}
+		addSpend(t.pending[day], spend)
+	}
+}
+
+// load reads the stored spend of the days within the retention and
+// deletes the objects of older days.
+func (t *costTracker) load(ctx context.Context, now time.Time) error {
+	client, err := services.MinIO()
+	if err != nil {
+		return err
+	}
+	oldest := now.UTC().AddDate(0, 0, 1-config.Costs.RetentionDays).Format(time.DateOnly)
+	for obj := range client.ListObjects(ctx, config.MinIO.SystemBucket, minio.ListObjectsOptions{Prefix: costsPrefix}) {
+		if obj.Err != nil {
+			if isNotFound(obj.Err) {
+				return nil
+			}
+			return obj.Err
+		}
+		day := strings.TrimSuffix(strings.TrimPrefix(obj.Key, costsPrefix), ".json")
+		if day < oldest {
+			if err := client.RemoveObject(ctx, config.MinIO.SystemBucket, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+				log.Printf("Failed to delete expired spend %s: %v", obj.Key, err)
+			}
+			continue
+		}
+		stored := map[string]*callerSpend{}
+		if err := getJSON(ctx, config.MinIO.SystemBucket, obj.Key, &stored); err != nil {
+			return err
+		}
+		t.mu.Lock()
+		addSpend(stored, t.pending[day])
+		t.days[day] = stored
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+// startCostRecorder loads the stored spend, then stores the spend every
+// interval until ctx is cancelled, and once more then.
+func startCostRecorder(ctx context.Context, interval time.Duration) {
+	if err := costs.load(ctx, time.Now()); err != nil {
+		log.Printf("Failed to load the stored spend: %v", err)
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+				if err := costs.flush(ctx); err != nil {
+					log.Printf("Failed to store the spend: %v", err)
+				}
+				cancel()
+				return
+			case <-ticker.C:
+				if err := costs.flush(ctx); err != nil {
+					log.Printf("Failed to store the spend: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// admit refuses caller once it or all callers together spent their budget
+// of the day.
+func (t *costTracker) admit(caller string, now time.Time) error {
+	c := config.Costs
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	callers := t.days[now.UTC().Format(time.DateOnly)]
+	if budget := c.callerBudget(caller); budget > 0 && callers[caller] != nil && callers[caller].Cost >= budget {
+		return codedError(http.StatusTooManyRequests, "ERR_BUDGET_EXCEEDED",
+			fmt.Sprintf("Caller %s spent its daily budget of $%.2f", caller, budget))
+	}
+	if c.DailyBudget > 0 {
+		var total float64
+		for _, s := range callers {
+			total += s.Cost
+		}
+		if total >= c.DailyBudget {
+			return codedError(http.StatusTooManyRequests, "ERR_BUDGET_EXCEEDED",
+				fmt.Sprintf("The daily budget of $%.2f is spent", c.DailyBudget))
+		}
+	}
+	return nil
+}
+
+type CallerCost struct {
+	Caller           string  `json:"caller" doc:"admin, anonymous, system or tenant:<name>"`
+	Calls            int64   `json:"calls" doc:"OpenAI calls"`
+	PromptTokens     int64   `json:"prompt_tokens" doc:"Prompt tokens"`
+	CompletionTokens int64   `json:"completion_tokens" doc:"Completion tokens"`
+	Cost             float64 `json:"cost" doc:"Estimated cost in USD"`
+	DailyBudget      float64 `json:"daily_budget" doc:"Daily budget in USD; 0 means unlimited"`
+}
+
+type CostsResponse struct {
+	Day         string       `json:"day" doc:"UTC day the spend covers"`
+	Cost        float64      `json:"cost" doc:"Estimated cost of all callers in USD"`
+	DailyBudget float64      `json:"daily_budget" doc:"Daily budget of all callers together in USD; 0 means unlimited"`
+	Callers     []CallerCost `json:"callers" doc:"Spend per caller, highest first"`
+}
+
+func registerCostsEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "get-costs",
+		Method:      http.MethodGet,
+		Path:        "/admin/costs",
+		Summary:     "Get OpenAI spend",
+		Description: "Report the estimated OpenAI cost of each caller on a UTC day, today by default, against their budgets",
+	}, func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer admin token"`
+		Day           string `query:"day" doc:"UTC day as YYYY-MM-DD; defaults to today"`
+	}) (*struct {
+		Body CostsResponse
+	}, error) {
+		if err := requireAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+		day := input.Day
+		if day == "" {
+			day = time.Now().UTC().Format(time.DateOnly)
+		} else if _, err := time.Parse(time.DateOnly, day); err != nil {
+			return nil, huma.Error422UnprocessableEntity("day must be a date as YYYY-MM-DD")
+		}
+
+		resp := CostsResponse{Day: day, DailyBudget: config.Costs.DailyBudget, Callers: []CallerCost{}}
+		costs.mu.Lock()
+		for caller, s := range costs.days[day] {
+			resp.Cost += s.Cost
+			resp.Callers = append(resp.Callers, CallerCost{
+				Caller:           caller,
+				Calls:            s.Calls,
+				PromptTokens:     s.PromptTokens,
+				CompletionTokens: s.CompletionTokens,
+				Cost:             s.Cost,
+				DailyBudget:      config.Costs.callerBudget(caller),
+			})
+		}
+		costs.mu.Unlock()
+		sort.Slice(resp.Callers, func(i, j int) bool {
+			if resp.Callers[i].Cost != resp.Callers[j].Cost {
+				return resp.Callers[i].Cost > resp.Callers[j].Cost
+			}
+			return resp.Callers[i].Caller < resp.Callers[j].Caller
+		})
+		return &struct {
+			Body CostsResponse
+		}{Body: resp}, nil
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+// usageProvider answers every chat with a fixed token usage.
+type usageProvider struct {
+	chatOnlyProvider
+	usage openai.Usage
+}
+
+func (p usageProvider) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return openai.ChatCompletionResponse{Model: req.Model, Usage: p.usage, Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "Hello"}}}}, nil
+}
+
+func (p usageProvider) Moderations(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error) {
+	return openai.ModerationResponse{Results: []openai.Result{{}}}, nil
+}
+
+func TestEstimateCost(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	u := openai.Usage{PromptTokens: 1_000_000, CompletionTokens: 1_000_000}
+	for model, want := range map[string]float64{
+		"gpt-4o":                 12.5,
+		"gpt-4o-mini-2024-07-18": 0.75,
+		"gpt-4-0613":             90,
+		"unknown-model":          0,
+	} {
+		if got := estimateCost(model, u); got != want {
+			t.Errorf("Expected %s to cost %v, got %v", model, want, got)
+		}
+	}
+}
+
+func TestCostBudgets(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "admin-token"
+	config.Storage.Tenants = []StorageTenantConfig{{Name: "acme", Token: "acme-token", Bucket: "tenants"}}
+	defer func() { config.Storage.Tenants = nil }()
+	config.Costs.CallerBudgets = map[string]float64{"tenant:acme": 1}
+	// Decoding the configuration keeps map entries, so reset the budgets
+	defer func() { config.Costs.CallerBudgets = nil }()
+	config.Costs.DailyBudget = 2
+	costs = newCostTracker()
+	config.ChatProvider.Name = "usage"
+	// 100k prompt and 50k completion tokens of gpt-4o cost $0.75
+	chatProviders["usage"] = func() (ChatProvider, error) {
+		return usageProvider{usage: openai.Usage{PromptTokens: 100_000, CompletionTokens: 50_000, TotalTokens: 150_000}}, nil
+	}
+	defer delete(chatProviders, "usage")
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	api.UseMiddleware(routePolicyMiddleware)
+	registerChatEndpoint(api)
+	registerCostsEndpoint(api)
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	chat := func(token string) int {
+		return call(http.MethodPost, "/chat", token, `{"message": "Hi", "model": "gpt-4o"}`).Code
+	}
+
+	// A caller may start requests until its spend reaches the budget
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := chat("acme-token"); code != want {
+			t.Errorf("Expected chat %d of the tenant to get %d, got %d", i+1, want, code)
+		}
+	}
+	// Then all callers together
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		if code := chat("admin-token"); code != want {
+			t.Errorf("Expected chat %d of the admin to get %d, got %d", i+1, want, code)
+		}
+	}
+
+	w := call(http.MethodGet, "/admin/costs", "admin-token", "")
+	var resp CostsResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Cost != 2.25 || resp.DailyBudget != 2 || len(resp.Callers) != 2 {
+		t.Fatalf("Expected the spend of both callers, got %d: %s", w.Code, w.Body.String())
+	}
+	if c := resp.Callers[0]; c.Caller != "tenant:acme" || c.Calls != 2 || c.PromptTokens != 200_000 || c.Cost != 1.5 || c.DailyBudget != 1 {
+		t.Errorf("Expected the tenant's spend first, got %+v", c)
+	}
+	if w := call(http.MethodGet, "/admin/costs?day=2020-01-01", "admin-token", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"callers":[]`) {
+		t.Errorf("Expected no spend on another day, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call(http.MethodGet, "/admin/costs?day=yesterday", "admin-token", ""); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected an invalid day to be rejected, got %d", w.Code)
+	}
+}
+
+func TestCostPersistence(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Costs.CallerBudgets = map[string]float64{"tenant:acme": 1}
+	defer func() { config.Costs.CallerBudgets = nil }()
+
+	now := time.Now()
+	today := now.UTC().Format(time.DateOnly)
+	expired := now.UTC().AddDate(0, 0, -config.Costs.RetentionDays).Format(time.DateOnly)
+	objects := map[string]string{
+		"app-system/" + costsKey(expired): `{"tenant:acme": {"calls": 1, "cost": 5}}`,
+	}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	first := newCostTracker()
+	first.record("tenant:acme", openai.Usage{PromptTokens: 100, CompletionTokens: 50}, 0.6, now)
+	if err := first.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Another replica stores its spend of the same day
+	second := newCostTracker()
+	second.record("tenant:acme", openai.Usage{PromptTokens: 10}, 0.3, now)
+	second.record("admin", openai.Usage{PromptTokens: 10}, 0.1, now)
+	if err := second.flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var stored map[string]callerSpend
+	json.Unmarshal([]byte(objects["app-system/"+costsKey(today)]), &stored)
+	if acme := stored["tenant:acme"]; acme.Calls != 2 || acme.PromptTokens != 110 || math.Abs(acme.Cost-0.9) > 1e-9 || stored["admin"].Calls != 1 {
+		t.Errorf("Expected the spend of both replicas to be stored, got %+v", stored)
+	}
+	if err := second.admit("tenant:acme", now); err != nil {
+		t.Errorf("Expected the tenant to be under budget, got %v", err)
+	}
+
+	// A restarted instance starts from the stored spend
+	restarted := newCostTracker()
+	if err := restarted.load(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	restarted.record("tenant:acme", openai.Usage{}, 0.2, now)
+	if err := restarted.admit("tenant:acme", now); err == nil {
+		t.Error("Expected the stored spend to count against the budget")
+	}
+	if _, ok := objects["app-system/"+costsKey(expired)]; ok {
+		t.Error("Expected the spend of days past the retention to be deleted")
+	}
+	if _, ok := restarted.days[expired]; ok {
+		t.Error("Expected days past the retention not to be loaded")
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+const (
+	// maxDiffEdits bounds the work done by the diff algorithm. Inputs that
+	// differ by more edits than this are reported as a full replacement.
+	maxDiffEdits = 2000
+)
+
+type DiffSource struct {
+	Bucket    string `json:"bucket" minLength:"1" doc:"MinIO bucket name"`
+	Name      string `json:"name" minLength:"1" doc:"Object name"`
+	VersionID string `json:"version_id,omitempty" doc:"Object version; defaults to the latest"`
+}
+
+type FileDiffRequest struct {
+	From    DiffSource `json:"from" doc:"Original object"`
+	To      DiffSource `json:"to" doc:"Changed object"`
+	Format  string     `json:"format,omitempty" enum:"unified,side-by-side" default:"unified" doc:"Diff output format"`
+	Context int        `json:"context,omitempty" minimum:"0" maximum:"100" default:"3" doc:"Unchanged lines of context around each change"`
+}
+
+type DiffRow struct {
+	Op        string `json:"op" enum:"equal,delete,insert,change" doc:"How the row changed"`
+	Left      string `json:"left,omitempty" doc:"Line from the original object"`
+	Right     string `json:"right,omitempty" doc:"Line from the changed object"`
+	LeftLine  int    `json:"left_line,omitempty" doc:"1-based line number in the original object"`
+	RightLine int    `json:"right_line,omitempty" doc:"1-based line number in the changed object"`
+}
+
+type FileDiffResponse struct {
+	Identical bool      `json:"identical" doc:"Whether the two objects have the same content"`
+	Additions int       `json:"additions" doc:"Number of added lines"`
+	Deletions int       `json:"deletions" doc:"Number of removed lines"`
+	Unified   string    `json:"unified,omitempty" doc:"Unified diff, for the unified format"`
+	Rows      []DiffRow `json:"rows,omitempty" doc:"Aligned rows, for the side-by-side format"`
+}
+
+// diffEdit is one line of an edit script. Op is ' ' for an unchanged line,
+// '-' for a line only in a and '+' for a line only in b.
+type diffEdit struct {
+	Op   byte
+	Text string
+}
+
+// splitLines splits text into lines without their terminators.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines computes a shortest edit script from a to b using Myers'
+// algorithm.
+func diffLines(a, b []string) []diffEdit {
+	n, m := len(a), len(b)
+	limit := n + m
+	if limit > maxDiffEdits {
+		limit = maxDiffEdits
+	}
+	offset := limit + 1
+	v := make([]int, 2*offset+1)
+
+	// trace[d] holds the furthest-reaching x for each diagonal k in
+	// [-d, d] before step d.
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackDiff(a, b, trace)
+			}
+		}
+	}
+
+	// Too many differences: report everything as replaced
+	edits := make([]diffEdit, 0, n+m)
+	for _, line := range a {
+		edits = append(edits, diffEdit{'-', line})
+	}
+	for _, line := range b {
+		edits = append(edits, diffEdit{'+', line})
+	}
+	return edits
+}
+
+func backtrackDiff(a, b []string, trace [][]int) []diffEdit {
+	var edits []diffEdit
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := 0
+		if d > 0 {
+			prevX = at(prevK)
+		}
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			edits = append(edits, diffEdit{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, diffEdit{'+', b[y-1]})
+			} else {
+				edits = append(edits, diffEdit{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// countChanges returns the number of added and removed lines in edits.
+func countChanges(edits []diffEdit) (additions, deletions int) {
+	for _, e := range edits {
+		switch e.Op {
+		case '+':
+			additions++
+		case '-':
+			deletions++
+		}
+	}
+	return additions, deletions
+}
+
+// unifiedDiff formats edits as a unified diff with the given number of
+// context lines around each change.
+func unifiedDiff(fromName, toName string, edits []diffEdit, context int) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", fromName, toName)
+
+	// aPos[i] and bPos[i] are the 0-based line numbers before edit i
+	aPos := make([]int, len(edits)+1)
+	bPos := make([]int, len(edits)+1)
+	for i, e := range edits {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if e.Op != '+' {
+			aPos[i+1]++
+		}
+		if e.Op != '-' {
+			bPos[i+1]++
+		}
+	}
+
+	for i := 0; i < len(edits); {
+		if edits[i].Op == ' ' {
+			i++
+			continue
+		}
+
+		// Grow the hunk while the next change is within two context windows
+		start := max(i-context, 0)
+		end := i
+		for j := i; j < len(edits); j++ {
+			if edits[j].Op != ' ' {
+				end = j
+			} else if j-end > 2*context {
+				break
+			}
+		}
+		end = min(end+context+1, len(edits))
+
+		aStart, aLen := aPos[start], aPos[end]-aPos[start]
+		bStart, bLen := bPos[start], bPos[end]-bPos[start]
+		if aLen > 0 {
+			aStart++
+		}
+		if bLen > 0 {
+			bStart++
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", aStart, aLen, bStart, bLen)
+		for _, e := range edits[start:end] {
+			sb.WriteByte(e.Op)
+			sb.WriteString(e.Text)
+			sb.WriteByte('\n')
+		}
+		i = end
+	}
+	return sb.String()
+}
+
+// sideBySideDiff aligns edits into rows, pairing runs of removed lines with
+// the added lines that replace them.
+func sideBySideDiff(edits []diffEdit) []DiffRow {
+	var rows []DiffRow
+	left, right := 0, 0
+	for i := 0; i < len(edits); {
+		if edits[i].Op == ' ' {
+			left++
+			right++
+			rows = append(rows, DiffRow{Op: "equal", Left: edits[i].Text, Right: edits[i].Text, LeftLine: left, RightLine: right})
+			i++
+			continue
+		}
+
+		var dels, adds []string
+		for ; i < len(edits) && edits[i].Op == '-'; i++ {
+			dels = append(dels, edits[i].Text)
+		}
+		for ; i < len(edits) && edits[i].Op == '+'; i++ {
+			adds = append(adds, edits[i].Text)
+		}
+		for j := 0; j < max(len(dels), len(adds)); j++ {
+			row := DiffRow{}
+			if j < len(dels) {
+				left++
+				row.Left, row.LeftLine = dels[j], left
+			}
+			if j < len(adds) {
+				right++
+				row.Right, row.RightLine = adds[j], right
+			}
+			switch {
+			case j < len(dels) && j < len(adds):
+				row.Op = "change"
+			case j < len(dels):
+				row.Op = "delete"
+			default:
+				row.Op = "insert"
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// readTextObject fetches an object (optionally a specific version) and
+// returns its content, rejecting objects that are too large or not UTF-8.
+func readTextObject(ctx context.Context, bucket, name, versionID string, limit int64) (string, error) {
+	client, err := services.MinIO()
+	if err != nil {
+		return "", err
+	}
+	obj, _, err := openObject(ctx, client, bucket, name, versionID)
+	if err != nil {
+		if isNotFound(err) {
+			return "", errObjectNotFound(bucket, name)
+		}
+		return "", huma.Error500InternalServerError("Failed to get object", err)
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(io.LimitReader(obj, limit+1))
+	if err != nil {
+		if isNotFound(err) {
+			return "", errObjectNotFound(bucket, name)
+		}
+		return "", huma.Error500InternalServerError("Failed to read object", err)
+	}
+	if int64(len(data)) > limit {
+		return "", huma.Error422UnprocessableEntity(fmt.Sprintf("Object %s is too large", name))
+	}
+	if !utf8.Valid(data) {
+		return "", huma.Error422UnprocessableEntity(fmt.Sprintf("Object %s is not a text file", name))
+	}
+	return string(data), nil
+}
+
+// diffSourceLabel names a diff input in unified diff headers.
+func diffSourceLabel(s DiffSource) string {
+	label := s.Bucket + "/" + s.Name
+	if s.VersionID != "" {
+		label += "@" + s.VersionID
+	}
+	return label
+}
+
+func registerFileDiffEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "diff-files",
+		Method:      http.MethodPost,
+		Path:        "/files/diff",
+		Summary:     "Diff two text files",
+		Description: "Compare two stored text objects, or two versions of the same object, and return a unified or side-by-side diff",
+	}, func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token; objects with an ACL are only read for callers it allows"`
+		Body          FileDiffRequest
+	}) (*struct {
+		Body FileDiffResponse
+	}, error) {
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+		caller := objectCaller(input.Authorization)
+		for _, s := range []DiffSource{input.Body.From, input.Body.To} {
+			if err := checkBucketAccess(s.Bucket); err != nil {
+				return nil, err
+			}
+			if err := checkObjectACL(ctx, s.Bucket, s.Name, caller); err != nil {
+				return nil, err
+			}
+		}
+
+		from, err := readTextObject(ctx, input.Body.From.Bucket, input.Body.From.Name, input.Body.From.VersionID, config.Limits.MaxDiffBytes)
+		if err != nil {
+			return nil, err
+		}
+		to, err := readTextObject(ctx, input.Body.To.Bucket, input.Body.To.Name, input.Body.To.VersionID, config.Limits.MaxDiffBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		edits := diffLines(splitLines(from), splitLines(to))
+		additions, deletions := countChanges(edits)
+		resp := FileDiffResponse{
+			Identical: from == to,
+			Additions: additions,
+			Deletions: deletions,
+		}
+		if input.Body.Format == "side-by-side" {
+			resp.Rows = sideBySideDiff(edits)
+		} else if additions+deletions > 0 {
+			resp.Unified = unifiedDiff(diffSourceLabel(input.Body.From), diffSourceLabel(input.Body.To), edits, input.Body.Context)
+		}
+
+		return &struct {
+			Body FileDiffResponse
+		}{
+			Body: resp,
+		}, nil
+	})
+}
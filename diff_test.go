@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+// applyEdits rebuilds both sides of an edit script.
+func applyEdits(edits []diffEdit) (a, b []string) {
+	for _, e := range edits {
+		if e.Op != '+' {
+			a = append(a, e.Text)
+		}
+		if e.Op != '-' {
+			b = append(b, e.Text)
+		}
+	}
+	return a, b
+}
+
+func TestDiffLines(t *testing.T) {
+	a := splitLines("a\nb\nc\nd\ne\n")
+	b := splitLines("a\nc\nd\nx\ne\nf\n")
+
+	edits := diffLines(a, b)
+	gotA, gotB := applyEdits(edits)
+	if strings.Join(gotA, "\n") != strings.Join(a, "\n") || strings.Join(gotB, "\n") != strings.Join(b, "\n") {
+		t.Fatalf("Edit script does not reproduce inputs: %+v", edits)
+	}
+
+	additions, deletions := countChanges(edits)
+	if additions != 2 || deletions != 1 {
+		t.Errorf("Expected 2 additions and 1 deletion, got %d and %d", additions, deletions)
+	}
+
+	if edits := diffLines(nil, nil); len(edits) != 0 {
+		t.Errorf("Expected no edits for empty inputs, got %+v", edits)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	edits := diffLines(splitLines("one\ntwo\nthree\n"), splitLines("one\n2\nthree\n"))
+
+	got := unifiedDiff("a.txt", "b.txt", edits, 1)
+	want := "--- a.txt\n+++ b.txt\n@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n"
+	if got != want {
+		t.Errorf("Unexpected unified diff:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSideBySideDiff(t *testing.T) {
+	edits := diffLines(splitLines("one\ntwo\n"), splitLines("one\n2\nthree\n"))
+
+	rows := sideBySideDiff(edits)
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows, got %+v", rows)
+	}
+	if rows[0].Op != "equal" || rows[1].Op != "change" || rows[2].Op != "insert" {
+		t.Errorf("Unexpected row ops: %+v", rows)
+	}
+	if rows[2].RightLine != 3 || rows[2].LeftLine != 0 {
+		t.Errorf("Unexpected line numbers for inserted row: %+v", rows[2])
+	}
+}
+
+func TestFileDiffEndpointWithoutClient(t *testing.T) {
+	// Initialize config for testing
+	viper.Reset()
+	initConfig()
+
+	// Ensure MinIO client is not initialized
+	services.SetMinIO(nil)
+
+	// Create test router and API
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+
+	// Register diff endpoint
+	registerFileDiffEndpoint(api)
+
+	// Create test request
+	requestBody := FileDiffRequest{
+		From: DiffSource{Bucket: "test-bucket", Name: "a.txt"},
+		To:   DiffSource{Bucket: "test-bucket", Name: "b.txt"},
+	}
+	jsonBody, _ := json.Marshal(requestBody)
+	req := httptest.NewRequest("POST", "/files/diff", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	// Execute request
+	router.ServeHTTP(w, req)
+
+	// Should return 503 Service Unavailable since MinIO client is not configured
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code 503, got %d", w.Code)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// cacheFileExt marks the files of the disk cache, so only they are cleared
+// from the directory at startup.
+const cacheFileExt = ".cached"
+
+// DiskCacheConfig keeps copies of read objects on local disk.
+type DiskCacheConfig struct {
+	Dir            string `mapstructure:"dir" doc:"Directory cached objects are kept in; empty disables the cache"`
+	MaxEntries     int    `mapstructure:"max_entries" doc:"Most objects the cache keeps"`
+	MaxBytes       int64  `mapstructure:"max_bytes" doc:"Most bytes the cache keeps on disk"`
+	MaxObjectBytes int64  `mapstructure:"max_object_bytes" doc:"Largest object the cache keeps"`
+}
+
+func (c DiskCacheConfig) Validate() error {
+	if c.Dir == "" {
+		return nil
+	}
+	if err := (StoreLimits{MaxEntries: c.MaxEntries, MaxBytes: c.MaxBytes}).validate("disk_cache"); err != nil {
+		return err
+	}
+	if c.MaxObjectBytes <= 0 || c.MaxObjectBytes > c.MaxBytes {
+		return errors.New("disk_cache.max_object_bytes must be positive and at most disk_cache.max_bytes")
+	}
+	return nil
+}
+
+type cachedFile struct {
+	path string
+	size int64
+}
+
+// objectCache is a read-through cache of objects on local disk. Entries are
+// keyed by version and ETag, so a changed object is never served from it;
+// the least recently used files are deleted to stay within the limits.
+type objectCache struct {
+	dir   string
+	files *lruStore[cachedFile]
+}
+
+func newObjectCache(c DiskCacheConfig) (*objectCache, error) {
+	if err := os.MkdirAll(c.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	// The index of an earlier run is gone, so are its files
+	stale, _ := filepath.Glob(filepath.Join(c.Dir, "*"+cacheFileExt))
+	for _, f := range stale {
+		os.Remove(f)
+	}
+	files := newLRUStore("disk_cache", StoreLimits{MaxEntries: c.MaxEntries, MaxBytes: c.MaxBytes}, func(f cachedFile) int64 { return f.size })
+	// Readers that still have a removed file open can finish reading it
+	files.onRemove = func(f cachedFile) { os.Remove(f.path) }
+	return &objectCache{dir: c.Dir, files: files}, nil
+}
+
+var diskCache struct {
+	sync.Mutex
+	cache *objectCache
+	err   error
+}
+
+// objectDiskCache returns the disk cache, or nil if it is disabled or its
+// directory cannot be used.
+func objectDiskCache() *objectCache {
+	if config.DiskCache.Dir == "" {
+		return nil
+	}
+	diskCache.Lock()
+	defer diskCache.Unlock()
+	if diskCache.cache == nil && diskCache.err == nil {
+		diskCache.cache, diskCache.err = newObjectCache(config.DiskCache)
+		if diskCache.err != nil {
+			log.Printf("Disk cache disabled: %v", diskCache.err)
+		}
+	}
+	return diskCache.cache
+}
+
+func objectCacheKey(bucket, name string, info minio.ObjectInfo) string {
+	return bucket + "/" + name + "?version=" + info.VersionID + "&etag=" + info.ETag
+}
+
+// openObject opens an object for reading. With the disk cache enabled, the
+// object is checked with a HEAD request and read from disk if the cache
+// holds its current content, and downloaded into the cache otherwise.
+func openObject(ctx context.Context, client *minio.Client, bucket, name, versionID string) (io.ReadCloser, minio.ObjectInfo, error) {
+	cache := objectDiskCache()
+	if cache == nil {
+		obj, err := client.GetObject(ctx, bucket, name, minio.GetObjectOptions{VersionID: versionID})
+		if err != nil {
+			return nil, minio.ObjectInfo{}, err
+		}
+		info, err := obj.Stat()
+		if err != nil {
+			obj.Close()
+			return nil, minio.ObjectInfo{}, err
+		}
+		return obj, info, nil
+	}
+
+	info, err := client.StatObject(ctx, bucket, name, minio.StatObjectOptions{VersionID: versionID})
+	if err != nil {
+		return nil, minio.ObjectInfo{}, err
+	}
+	key := objectCacheKey(bucket, name, info)
+	if f, ok := cache.files.get(key); ok {
+		if file, err := os.Open(f.path); err == nil {
+			return file, info, nil
+		}
+		cache.files.delete(key)
+	}
+
+	opts := minio.GetObjectOptions{VersionID: versionID}
+	// Fail instead of caching a version written since the HEAD request
+	opts.SetMatchETag(info.ETag)
+	obj, err := client.GetObject(ctx, bucket, name, opts)
+	if err != nil {
+		return nil, minio.ObjectInfo{}, err
+	}
+	if info.Size > config.DiskCache.MaxObjectBytes {
+		return obj, info, nil
+	}
+	defer obj.Close()
+	file, err := cache.fill(key, obj)
+	if err != nil {
+		return nil, minio.ObjectInfo{}, err
+	}
+	return file, info, nil
+}
+
+// openObjectRange opens bytes start to end, inclusive, of the object
+// version described by info. The range is read from the disk cache if it
+// holds that version; otherwise only the range is downloaded, and it is
+// not cached.
+func openObjectRange(ctx context.Context, client *minio.Client, bucket, name string, info minio.ObjectInfo, start, end int64) (io.ReadCloser, error) {
+	if cache := objectDiskCache(); cache != nil {
+		key := objectCacheKey(bucket, name, info)
+		if f, ok := cache.files.get(key); ok {
+			if file, err := os.Open(f.path); err == nil {
+				if _, err := file.Seek(start, io.SeekStart); err == nil {
+					return struct {
+						io.Reader
+						io.Closer
+					}{io.LimitReader(file, end-start+1), file}, nil
+				}
+				file.Close()
+			}
+			cache.files.delete(key)
+		}
+	}
+
+	opts := minio.GetObjectOptions{VersionID: info.VersionID}
+	opts.SetRange(start, end)
+	// Fail instead of mixing versions if the object was replaced
+	opts.SetMatchETag(info.ETag)
+	// Core sends the range with the GET; Object.Stat of the high-level
+	// client would fetch the whole object instead
+	obj, _, _, err := minio.Core{Client: client}.GetObject(ctx, bucket, name, opts)
+	return obj, err
+}
+
+// fill downloads r into a new cache file and returns it opened at the
+// start.
+func (c *objectCache) fill(key string, r io.Reader) (*os.File, error) {
+	file, err := os.CreateTemp(c.dir, "*"+cacheFileExt)
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(file, r)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	c.files.set(key, cachedFile{path: file.Name(), size: n}, 0)
+	return file, nil
+}
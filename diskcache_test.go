@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func resetDiskCache(t *testing.T) {
+	reset := func() {
+		diskCache.Lock()
+		diskCache.cache, diskCache.err = nil, nil
+		diskCache.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestDiskCache(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	resetDiskCache(t)
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "stale"+cacheFileExt), []byte("old"), 0o600)
+	os.WriteFile(filepath.Join(dir, "keep.txt"), []byte("not ours"), 0o600)
+	config.DiskCache = DiskCacheConfig{Dir: dir, MaxEntries: 2, MaxBytes: 1 << 20, MaxObjectBytes: 100}
+
+	objects := map[string]string{
+		"docs/a.md":   "# A\n",
+		"docs/b.md":   "# B\n",
+		"docs/c.md":   "# C\n",
+		"docs/big.md": strings.Repeat("x", 200),
+	}
+	var downloads atomic.Int32
+	fake := fakeS3(objects)
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only object downloads count, not the ACL lookups in the system bucket
+		if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/docs/") && !r.URL.Query().Has("location") {
+			downloads.Add(1)
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerFileRenderEndpoint(api)
+	registerFilePreviewEndpoint(api)
+	registerACLEndpoints(api)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	for range 3 {
+		if w := get("/files/docs/a.md/render"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "<h1>A</h1>") {
+			t.Fatalf("Expected the rendered document, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	if w := get("/files/docs/a.md/preview"); w.Code != http.StatusOK || w.Body.String() != "# A\n" {
+		t.Errorf("Expected the preview from the cache, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := get("/files/docs/a.md"); w.Code != http.StatusOK || w.Body.String() != "# A\n" {
+		t.Errorf("Expected the download from the cache, got %d: %s", w.Code, w.Body.String())
+	}
+	req := httptest.NewRequest(http.MethodGet, "/files/docs/a.md", nil)
+	req.Header.Set("Range", "bytes=2-2")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "A" {
+		t.Errorf("Expected the range from the cache, got %d: %q", w.Code, w.Body.String())
+	}
+	if n := downloads.Load(); n != 1 {
+		t.Errorf("Expected one download, got %d", n)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "stale"+cacheFileExt)); !os.IsNotExist(err) {
+		t.Error("Expected files of an earlier run to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "keep.txt")); err != nil {
+		t.Error("Expected other files to be left alone")
+	}
+
+	// A changed object is downloaded again
+	objects["docs/a.md"] = "# A2\n"
+	if w := get("/files/docs/a.md/render"); !strings.Contains(w.Body.String(), "<h1>A2</h1>") {
+		t.Errorf("Expected the new content, got %s", w.Body.String())
+	}
+	if n := downloads.Load(); n != 2 {
+		t.Errorf("Expected the changed object to be downloaded, got %d downloads", n)
+	}
+
+	// Objects above max_object_bytes are not cached
+	get("/files/docs/big.md/render")
+	get("/files/docs/big.md/render")
+	if n := downloads.Load(); n != 4 {
+		t.Errorf("Expected the large object to be downloaded each time, got %d downloads", n)
+	}
+
+	// Evicted entries take their files with them
+	get("/files/docs/b.md/render")
+	get("/files/docs/c.md/render")
+	cached, _ := filepath.Glob(filepath.Join(dir, "*"+cacheFileExt))
+	if len(cached) != 2 {
+		t.Errorf("Expected 2 cached files, got %v", cached)
+	}
+	if w := get("/files/docs/missing.md/render"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a missing object to be reported, got %d", w.Code)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+	"github.com/sashabaranov/go-openai"
+)
+
+const editSystemPrompt = "You are a careful document editor. Apply the user's instruction to the document they provide " +
+	"and reply with the complete edited document only, without commentary or code fences."
+
+type FileEditRequest struct {
+	Instruction string `json:"instruction" minLength:"1" doc:"What to change, e.g. \"fix grammar\" or \"convert to bullet points\""`
+	Model       string `json:"model,omitempty" doc:"OpenAI model to use; defaults to the tenant policy's default model or gpt-3.5-turbo"`
+}
+
+type FileEditResponse struct {
+	VersionID  string     `json:"version_id,omitempty" doc:"Version of the stored result, if the bucket is versioned"`
+	Additions  int        `json:"additions" doc:"Number of added lines"`
+	Deletions  int        `json:"deletions" doc:"Number of removed lines"`
+	Diff       string     `json:"diff" doc:"Unified diff between the original and edited document"`
+	Provenance Provenance `json:"provenance" doc:"Provenance stored with the edited document"`
+}
+
+// editPrompt is the user message asking to apply instruction to doc.
+func editPrompt(instruction, doc string) string {
+	return fmt.Sprintf("Instruction: %s\n\nDocument:\n%s", instruction, doc)
+}
+
+// editDocument asks the model to apply instruction to doc and returns the
+// edited text.
+func editDocument(ctx context.Context, provider ChatProvider, model, instruction, doc string) (string, error) {
+	if model == "" {
+		model = defaultModel
+	}
+
+	messages, err := withStyleGuide(ctx, []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: editSystemPrompt},
+		{Role: openai.ChatMessageRoleUser, Content: editPrompt(instruction, doc)},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := createChatCompletion(ctx, provider, openai.ChatCompletionRequest{
+		Model:    model,
+		Messages: messages,
+	})
+	if err != nil {
+		return "", err
+	}
+	recordTokenUsage(ctx, model, resp.Usage)
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("model returned no choices")
+	}
+
+	edited := resp.Choices[0].Message.Content
+	// Keep the original trailing newline convention
+	if strings.HasSuffix(doc, "\n") && !strings.HasSuffix(edited, "\n") {
+		edited += "\n"
+	}
+	return edited, nil
+}
+
+func registerFileEditEndpoint(api huma.API) {
+	huma.Register(api, withRoutePolicy(huma.Operation{
+		OperationID: "edit-file",
+		Method:      http.MethodPost,
+		Path:        "/files/{bucket}/{name}/edit",
+		Summary:     "Edit a file with OpenAI",
+		Description: "Apply a natural-language instruction to a stored text document with OpenAI, store the result as a new version of the object and return the diff",
+	}, RoutePolicy{
+		Timeout:   2 * time.Minute,
+		RateClass: "generation",
+		// Someone waits on the edit in an editor, so fail fast after one
+		// quick retry rather than backing off for long
+		Retry:    &RetryPolicy{Attempts: 2, BaseDelay: 500 * time.Millisecond, MaxDelay: time.Second},
+		Budgeted: true,
+	}), func(ctx context.Context, input *struct {
+		Bucket        string `path:"bucket" doc:"MinIO bucket name"`
+		Name          string `path:"name" doc:"Object name (URL-encoded if it contains slashes)"`
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token; objects with an ACL are only edited for callers it allows"`
+		Body          FileEditRequest
+	}) (*struct {
+		Body FileEditResponse
+	}, error) {
+		if err := checkBucketAccess(input.Bucket); err != nil {
+			return nil, err
+		}
+
+		provider, err := chatProvider(ctx)
+		if err != nil {
+			return nil, err
+		}
+		store, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+		model, err := policyModel(ctx, input.Body.Model)
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := decodeObjectKey(input.Name)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkObjectACL(ctx, input.Bucket, name, objectCaller(input.Authorization)); err != nil {
+			return nil, err
+		}
+
+		info, err := store.StatObject(ctx, input.Bucket, name, minio.StatObjectOptions{})
+		if err != nil {
+			if isNotFound(err) {
+				return nil, errObjectNotFound(input.Bucket, name)
+			}
+			return nil, huma.Error500InternalServerError("Failed to stat object", err)
+		}
+
+		original, err := readTextObject(ctx, input.Bucket, name, info.VersionID, config.Limits.MaxEditBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		edited, err := editDocument(ctx, provider, model, input.Body.Instruction, original)
+		logPrompt(ctx, "edit", input.Body, edited, err)
+		if err != nil {
+			return nil, openAIError(ctx, "Failed to get OpenAI response", err)
+		}
+		edited, filter, err := filterOutput(ctx, provider, "edit", edited)
+		if err != nil {
+			return nil, huma.Error502BadGateway("Output filter failed", err)
+		}
+		if filter != nil && filter.Action == outputBlock {
+			return nil, errOutputBlocked(filter)
+		}
+
+		// The model may have kept the watermark of an earlier edit
+		if w := config.Provenance.Watermark; !strings.Contains(edited, w) {
+			edited += w
+		}
+		provenance := Provenance{
+			Generator:     "edit-file",
+			Model:         model,
+			PromptSHA256:  promptSHA256(editPrompt(input.Body.Instruction, original)),
+			GeneratedAt:   time.Now().UTC().Truncate(time.Second),
+			SourceVersion: info.VersionID,
+		}
+
+		contentType := info.ContentType
+		if contentType == "" {
+			contentType = "text/plain"
+		}
+		opts := minio.PutObjectOptions{
+			ContentType:  contentType,
+			UserMetadata: provenance.metadata(),
+		}
+		// Only replace the version that was edited, not one written since
+		opts.SetMatchETag(info.ETag)
+		upload, err := store.PutObject(ctx, input.Bucket, name, strings.NewReader(edited), int64(len(edited)), opts)
+		if err != nil {
+			if minio.ToErrorResponse(err).StatusCode == http.StatusPreconditionFailed {
+				return nil, huma.Error412PreconditionFailed(fmt.Sprintf("Object %s in bucket %s changed while it was being edited; edit it again", name, input.Bucket))
+			}
+			return nil, huma.Error500InternalServerError("Failed to store edited file", err)
+		}
+
+		edits := diffLines(splitLines(original), splitLines(edited))
+		additions, deletions := countChanges(edits)
+		diff := ""
+		if additions+deletions > 0 {
+			diff = unifiedDiff(input.Bucket+"/"+name, input.Bucket+"/"+name, edits, 3)
+		}
+
+		return &struct {
+			Body FileEditResponse
+		}{
+			Body: FileEditResponse{
+				VersionID:  upload.VersionID,
+				Additions:  additions,
+				Deletions:  deletions,
+				Diff:       diff,
+				Provenance: provenance,
+			},
+		}, nil
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+func TestFileEditEndpointWithoutClient(t *testing.T) {
+	// Initialize config for testing
+	viper.Reset()
+	initConfig()
+
+	// Ensure clients are not initialized
+	services.SetOpenAI(nil)
+	services.SetMinIO(nil)
+
+	// Create test router and API
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+
+	// Register edit endpoint
+	registerFileEditEndpoint(api)
+
+	// Create test request
+	jsonBody, _ := json.Marshal(FileEditRequest{Instruction: "fix grammar"})
+	req := httptest.NewRequest("POST", "/files/test-bucket/draft.md/edit", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	// Execute request
+	router.ServeHTTP(w, req)
+
+	// Should return 503 Service Unavailable since OpenAI client is not configured
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code 503, got %d", w.Code)
+	}
+}
+
+func TestFileEdit(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Provenance.Watermark = "\n[Edited with AI]\n"
+
+	objects := map[string]string{"docs/draft.md": "teh draft\n"}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	// The model fixes the typo and keeps whatever follows the first line.
+	// concurrentWrite, if set, stands for another writer that stores the
+	// document while the model is working.
+	var concurrentWrite string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		doc := req.Messages[len(req.Messages)-1].Content
+		doc = doc[strings.Index(doc, "Document:\n")+len("Document:\n"):]
+		if concurrentWrite != "" {
+			objects["docs/draft.md"] = concurrentWrite
+		}
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{
+			{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: strings.Replace(doc, "teh", "the", 1)}},
+		}})
+	}))
+	defer srv.Close()
+	services.SetOpenAI(newTestOpenAIClient(srv.URL))
+	defer services.SetOpenAI(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerFileEditEndpoint(api)
+	edit := func() *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(FileEditRequest{Instruction: "fix typos"})
+		req := httptest.NewRequest(http.MethodPost, "/files/docs/draft.md/edit", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := edit()
+	var resp FileEditResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Additions == 0 || resp.Provenance.Generator != "edit-file" {
+		t.Fatalf("Expected the edit to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := objects["docs/draft.md"]; got != "the draft\n\n[Edited with AI]\n" {
+		t.Errorf("Expected the edited document with the watermark to be stored, got %q", got)
+	}
+
+	// The stored document already carries the watermark
+	if w := edit(); w.Code != http.StatusOK || strings.Count(objects["docs/draft.md"], "[Edited with AI]") != 1 {
+		t.Errorf("Expected the watermark to be added once, got %d: %q", w.Code, objects["docs/draft.md"])
+	}
+
+	concurrentWrite = "teh other draft\n"
+	w = edit()
+	if w.Code != http.StatusPreconditionFailed || !strings.Contains(w.Body.String(), "ERR_PRECONDITION_FAILED") {
+		t.Errorf("Expected 412 when the document changed during the edit, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := objects["docs/draft.md"]; got != concurrentWrite {
+		t.Errorf("Expected the concurrent write to be kept, got %q", got)
+	}
+}
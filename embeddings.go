@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/sashabaranov/go-openai"
+)
+
+// defaultEmbeddingModel is used when a request names no model.
+const defaultEmbeddingModel = "text-embedding-ada-002"
+
+// EmbeddingsConfig bounds embedding requests.
+type EmbeddingsConfig struct {
+	MaxInputs int `mapstructure:"max_inputs" doc:"Most texts one request may embed"`
+	BatchSize int `mapstructure:"batch_size" doc:"Texts sent to OpenAI per call; larger requests are split into batches"`
+}
+
+func (c EmbeddingsConfig) Validate() error {
+	if c.MaxInputs <= 0 || c.BatchSize <= 0 || c.BatchSize > 2048 {
+		return errors.New("embeddings.max_inputs must be positive and embeddings.batch_size between 1 and 2048")
+	}
+	return nil
+}
+
+// EmbeddingInput is one text or a list of texts.
+type EmbeddingInput []string
+
+func (in *EmbeddingInput) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*in = EmbeddingInput{text}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(in))
+}
+
+func (EmbeddingInput) Schema(r huma.Registry) *huma.Schema {
+	minItems := 1
+	return &huma.Schema{
+		Description: "Text to embed, or a list of texts",
+		OneOf: []*huma.Schema{
+			{Type: huma.TypeString},
+			{Type: huma.TypeArray, Items: &huma.Schema{Type: huma.TypeString}, MinItems: &minItems},
+		},
+	}
+}
+
+type EmbeddingsRequest struct {
+	Input EmbeddingInput `json:"input"`
+	Model string         `json:"model,omitempty" doc:"Embedding model; defaults to text-embedding-ada-002"`
+}
+
+type EmbeddingVector struct {
+	Index     int       `json:"index" doc:"Position of the text in the input"`
+	Embedding []float32 `json:"embedding" doc:"The text's vector"`
+}
+
+type EmbeddingsUsage struct {
+	PromptTokens int `json:"prompt_tokens" doc:"Tokens in the input"`
+	TotalTokens  int `json:"total_tokens" doc:"Tokens billed"`
+}
+
+type EmbeddingsResponse struct {
+	Model      string            `json:"model" doc:"Model that computed the vectors"`
+	Embeddings []EmbeddingVector `json:"embeddings" doc:"One vector per input text, in input order"`
+	Usage      EmbeddingsUsage   `json:"usage" doc:"Token usage over all batches"`
+}
+
+// parseEmbeddingModel resolves a model name. The OpenAI client only sends
+// the embedding models it knows.
+func parseEmbeddingModel(name string) (openai.EmbeddingModel, error) {
+	var m openai.EmbeddingModel
+	m.UnmarshalText([]byte(name))
+	if m == openai.Unknown {
+		return m, huma.Error422UnprocessableEntity(fmt.Sprintf("Unknown embedding model %s; use %s", name, defaultEmbeddingModel))
+	}
+	return m, nil
+}
+
+// createEmbeddings embeds texts in batches and returns the vectors in input
+// order.
+func createEmbeddings(ctx context.Context, provider ChatProvider, model openai.EmbeddingModel, texts []string) (EmbeddingsResponse, error) {
+	out := EmbeddingsResponse{Model: model.String(), Embeddings: make([]EmbeddingVector, 0, len(texts))}
+	for start := 0; start < len(texts); start += config.Embeddings.BatchSize {
+		batch := texts[start:min(start+config.Embeddings.BatchSize, len(texts))]
+		resp, err := provider.CreateEmbeddings(ctx, openai.EmbeddingRequest{Input: batch, Model: model})
+		if err != nil {
+			return EmbeddingsResponse{}, err
+		}
+		recordTokenUsage(ctx, model.String(), resp.Usage)
+		out.Usage.PromptTokens += resp.Usage.PromptTokens
+		out.Usage.TotalTokens += resp.Usage.TotalTokens
+		if len(resp.Data) != len(batch) {
+			return EmbeddingsResponse{}, fmt.Errorf("OpenAI returned %d embeddings for %d texts", len(resp.Data), len(batch))
+		}
+		// OpenAI doesn't promise to keep the order, but reports each index
+		slices.SortFunc(resp.Data, func(a, b openai.Embedding) int { return a.Index - b.Index })
+		for _, e := range resp.Data {
+			out.Embeddings = append(out.Embeddings, EmbeddingVector{Index: start + e.Index, Embedding: e.Embedding})
+		}
+	}
+	return out, nil
+}
+
+// embedCached embeds texts with the default embedding model and returns the
+// vectors in input order. Vectors of texts embedded before are taken from
+// store, and new ones are added to it.
+func embedCached(ctx context.Context, provider ChatProvider, store *lruStore[[]float32], texts []string) ([][]float32, error) {
+	model, err := parseEmbeddingModel(defaultEmbeddingModel)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(texts))
+	vectors := make([][]float32, len(texts))
+	var missing []int
+	var missingTexts []string
+	for i, text := range texts {
+		sum := sha256.Sum256([]byte(defaultEmbeddingModel + "\x00" + text))
+		keys[i] = hex.EncodeToString(sum[:])
+		if v, ok := store.get(keys[i]); ok {
+			vectors[i] = v
+			continue
+		}
+		missing = append(missing, i)
+		missingTexts = append(missingTexts, text)
+	}
+	if len(missing) == 0 {
+		return vectors, nil
+	}
+	resp, err := createEmbeddings(ctx, provider, model, missingTexts)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range missing {
+		vectors[i] = resp.Embeddings[j].Embedding
+		store.set(keys[i], vectors[i], 0)
+	}
+	return vectors, nil
+}
+
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range min(len(a), len(b)) {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+func registerEmbeddingsEndpoint(api huma.API) {
+	huma.Register(api, withRoutePolicy(huma.Operation{
+		OperationID: "create-embeddings",
+		Method:      http.MethodPost,
+		Path:        "/embeddings",
+		Summary:     "Embed texts",
+		Description: "Compute embedding vectors of one or more texts with OpenAI, to build search on top of the service. Long lists are sent to OpenAI in batches",
+	}, RoutePolicy{Timeout: time.Minute, RateClass: "generation", Budgeted: true}), func(ctx context.Context, input *struct {
+		Body EmbeddingsRequest
+	}) (*struct {
+		Body EmbeddingsResponse
+	}, error) {
+		provider, err := chatProvider(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(input.Body.Input); n > config.Embeddings.MaxInputs {
+			return nil, huma.Error422UnprocessableEntity(fmt.Sprintf("At most %d texts can be embedded at once, got %d", config.Embeddings.MaxInputs, n))
+		}
+		name := input.Body.Model
+		if name == "" {
+			name = defaultEmbeddingModel
+		}
+		model, err := parseEmbeddingModel(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := policyModel(ctx, name); err != nil {
+			return nil, err
+		}
+
+		resp, err := createEmbeddings(ctx, provider, model, input.Body.Input)
+		if err != nil {
+			return nil, openAIError(ctx, "Failed to create embeddings", err)
+		}
+		return &struct {
+			Body EmbeddingsResponse
+		}{Body: resp}, nil
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+func TestEmbeddings(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Embeddings.BatchSize = 2
+
+	var batches [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.EmbeddingRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		var texts []string
+		for _, in := range req.Input.([]any) {
+			texts = append(texts, in.(string))
+		}
+		batches = append(batches, texts)
+		resp := openai.EmbeddingResponse{Usage: openai.Usage{PromptTokens: len(texts), TotalTokens: len(texts)}}
+		// Answer in reverse to check the vectors are put back in order
+		for i := len(texts) - 1; i >= 0; i-- {
+			resp.Data = append(resp.Data, openai.Embedding{Index: i, Embedding: []float32{float32(len(texts[i]))}})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+	services.SetOpenAI(newTestOpenAIClient(server.URL))
+	defer services.SetOpenAI(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerEmbeddingsEndpoint(api)
+	call := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/embeddings", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := call(`{"input": "hello"}`)
+	var resp EmbeddingsResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Model != defaultEmbeddingModel || len(resp.Embeddings) != 1 || resp.Embeddings[0].Embedding[0] != 5 {
+		t.Fatalf("Expected one vector for a single text, got %d: %s", w.Code, w.Body.String())
+	}
+
+	batches = nil
+	w = call(`{"input": ["a", "bb", "ccc", "dddd", "eeeee"], "model": "text-similarity-ada-001"}`)
+	resp = EmbeddingsResponse{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Model != "text-similarity-ada-001" {
+		t.Fatalf("Expected the vectors, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(batches) != 3 || len(batches[2]) != 1 {
+		t.Errorf("Expected 3 batches of at most 2 texts, got %v", batches)
+	}
+	for i, e := range resp.Embeddings {
+		if e.Index != i || e.Embedding[0] != float32(i+1) {
+			t.Errorf("Expected vector %d in input order, got %+v", i, e)
+		}
+	}
+	if resp.Usage.TotalTokens != 5 {
+		t.Errorf("Expected the usage of all batches, got %+v", resp.Usage)
+	}
+
+	if w := call(`{"input": "hello", "model": "gpt-4"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected an unknown model to be rejected, got %d", w.Code)
+	}
+	config.Embeddings.MaxInputs = 2
+	if w := call(`{"input": ["a", "b", "c"]}`); w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "At most 2 texts") {
+		t.Errorf("Expected too many texts to be rejected, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call(`{"input": 42}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected a number to be rejected, got %d", w.Code)
+	}
+}
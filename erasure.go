@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// deletionReportsPrefix is where signed deletion reports are kept in the
+// system bucket.
+const deletionReportsPrefix = "compliance/deletions/"
+
+var subjectIDPattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,128}$`)
+
+// ComplianceConfig holds settings for data subject requests.
+type ComplianceConfig struct {
+	ReportSigningKey string `mapstructure:"report_signing_key" doc:"HMAC-SHA256 key that signs deletion reports; DELETE /users/{id}/data is disabled without it"`
+}
+
+func (c ComplianceConfig) Validate() error {
+	if c.ReportSigningKey != "" && len(c.ReportSigningKey) < 32 {
+		return fmt.Errorf("compliance.report_signing_key must be at least 32 characters")
+	}
+	return nil
+}
+
+type DeletedItem struct {
+	Class  string `json:"class" enum:"object,conversation,transcript" doc:"Kind of data"`
+	Bucket string `json:"bucket,omitempty" doc:"Bucket the data was stored in; empty for conversations only kept in memory"`
+	Name   string `json:"name" doc:"Object name, or the ID of a conversation only kept in memory"`
+}
+
+type RetainedItem struct {
+	Class  string `json:"class" enum:"object,conversation,transcript" doc:"Kind of data"`
+	Bucket string `json:"bucket" doc:"Bucket the data is stored in"`
+	Name   string `json:"name" doc:"Object name"`
+	Reason string `json:"reason" doc:"Why the data was kept, e.g. a legal hold"`
+}
+
+// DeletionReport records what a data subject deletion removed and kept.
+type DeletionReport struct {
+	ID          string         `json:"id" doc:"Report ID"`
+	Subject     string         `json:"subject" doc:"ID of the data subject"`
+	RequestedAt time.Time      `json:"requested_at" doc:"When the deletion was requested"`
+	CompletedAt time.Time      `json:"completed_at" doc:"When the deletion finished"`
+	Deleted     []DeletedItem  `json:"deleted" doc:"Data that was permanently deleted"`
+	Retained    []RetainedItem `json:"retained" doc:"Data that was kept, with the reason"`
+	DryRun      bool           `json:"dry_run,omitempty" doc:"Whether nothing was deleted and deleted lists what would have been"`
+}
+
+type SignedDeletionReport struct {
+	Report    DeletionReport `json:"report" doc:"The deletion report"`
+	Algorithm string         `json:"algorithm" enum:"hmac-sha256" doc:"Signature algorithm"`
+	Signature string         `json:"signature" doc:"Hex HMAC of the report's compact JSON, as stored, with compliance.report_signing_key"`
+	Object    string         `json:"object,omitempty" doc:"System bucket object the signed report is stored in; dry run reports are not stored"`
+}
+
+// signDeletionReport signs the report's JSON encoding.
+func signDeletionReport(report DeletionReport, key string) (string, error) {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// eraseSubjectObjects permanently deletes every object whose ACL names the
+// subject as owner, including trashed ones, and drops their ACLs. Objects
+// under a legal hold or that cannot be deleted are kept and reported. With
+// dryRun it only reports what would be deleted.
+func eraseSubjectObjects(ctx context.Context, client *minio.Client, subject string, dryRun bool) ([]DeletedItem, []RetainedItem, error) {
+	deleted, retained := []DeletedItem{}, []RetainedItem{}
+	var buckets []string
+	for obj := range client.ListObjects(ctx, config.MinIO.SystemBucket, minio.ListObjectsOptions{Prefix: aclPrefix}) {
+		if obj.Err != nil {
+			if isNotFound(obj.Err) {
+				break
+			}
+			return nil, nil, obj.Err
+		}
+		if bucket, ok := strings.CutSuffix(strings.TrimPrefix(obj.Key, aclPrefix), ".json"); ok {
+			buckets = append(buckets, bucket)
+		}
+	}
+	for _, bucket := range buckets {
+		acls, err := loadACLs(ctx, bucket)
+		if err != nil {
+			return nil, nil, err
+		}
+		var removed []string
+		for key, acl := range acls {
+			if acl.Owner != subject {
+				continue
+			}
+			hold, err := client.GetObjectLegalHold(ctx, bucket, key, minio.GetObjectLegalHoldOptions{})
+			if err == nil && hold != nil && *hold == minio.LegalHoldEnabled {
+				retained = append(retained, RetainedItem{Class: "object", Bucket: bucket, Name: key, Reason: "legal hold"})
+				continue
+			}
+			if dryRun {
+				deleted = append(deleted, DeletedItem{Class: "object", Bucket: bucket, Name: key})
+				continue
+			}
+			if err := client.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{}); err != nil && !isNotFound(err) {
+				retained = append(retained, RetainedItem{Class: "object", Bucket: bucket, Name: key, Reason: "deletion failed: " + err.Error()})
+				continue
+			}
+			deleted = append(deleted, DeletedItem{Class: "object", Bucket: bucket, Name: key})
+			removed = append(removed, key)
+		}
+		if len(removed) == 0 {
+			continue
+		}
+		err = updateACLs(ctx, bucket, func(acls map[string]ObjectACL) bool {
+			for _, key := range removed {
+				delete(acls, key)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return deleted, retained, nil
+}
+
+// sortErased orders a deletion report's items by class, bucket and name.
+func sortErased(deleted []DeletedItem, retained []RetainedItem) {
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].Class+"/"+deleted[i].Bucket+"/"+deleted[i].Name < deleted[j].Class+"/"+deleted[j].Bucket+"/"+deleted[j].Name
+	})
+	sort.Slice(retained, func(i, j int) bool {
+		return retained[i].Class+"/"+retained[i].Bucket+"/"+retained[i].Name < retained[j].Class+"/"+retained[j].Bucket+"/"+retained[j].Name
+	})
+}
+
+// eraseSubjectConversations deletes the conversations of the subject as a
+// tenant and every transcript of them, including transcripts whose
+// conversation is already gone. With dryRun it only reports what would be
+// deleted.
+func eraseSubjectConversations(ctx context.Context, client *minio.Client, subject string, dryRun bool) ([]DeletedItem, []RetainedItem, error) {
+	deleted, retained := []DeletedItem{}, []RetainedItem{}
+	store := conversationsStore()
+	ids := map[string]bool{}
+	store.memory.each(func(id string, c *Conversation) {
+		if c.Tenant == subject {
+			ids[id] = true
+		}
+	})
+	if store.persistence != nil {
+		for obj := range client.ListObjects(ctx, config.MinIO.SystemBucket, minio.ListObjectsOptions{Prefix: conversationsPrefix}) {
+			if obj.Err != nil {
+				if isNotFound(obj.Err) {
+					break
+				}
+				return nil, nil, obj.Err
+			}
+			var c Conversation
+			if err := getJSON(ctx, config.MinIO.SystemBucket, obj.Key, &c); err != nil {
+				if isNotFound(err) {
+					continue
+				}
+				return nil, nil, err
+			}
+			if c.Tenant == subject && c.ID != "" {
+				ids[c.ID] = true
+			}
+		}
+	}
+	for id := range ids {
+		item := DeletedItem{Class: "conversation", Name: id}
+		if store.persistence != nil {
+			item = DeletedItem{Class: "conversation", Bucket: config.MinIO.SystemBucket, Name: conversationKey(id)}
+		}
+		if !dryRun {
+			if err := store.delete(ctx, id); err != nil {
+				retained = append(retained, RetainedItem{Class: item.Class, Bucket: item.Bucket, Name: item.Name, Reason: "deletion failed: " + err.Error()})
+				continue
+			}
+		}
+		deleted = append(deleted, item)
+	}
+
+	transcripts, err := tenantTranscripts(ctx, client, subject)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, t := range transcripts {
+		item := DeletedItem{Class: "transcript", Bucket: transcriptBucket(), Name: transcriptKey(t.Tenant, t.ConversationID)}
+		if !dryRun {
+			if err := deleteTranscript(ctx, t.Tenant, t.ConversationID); err != nil {
+				retained = append(retained, RetainedItem{Class: item.Class, Bucket: item.Bucket, Name: item.Name, Reason: "deletion failed: " + err.Error()})
+				continue
+			}
+		}
+		deleted = append(deleted, item)
+	}
+	return deleted, retained, nil
+}
+
+// forgetEmbeddings empties the caches of transcript and document vectors.
+// They are keyed by the hash of the embedded text, so the subject's entries
+// cannot be told apart; other texts are embedded again when next searched.
+func forgetEmbeddings() {
+	for _, cache := range []*struct {
+		sync.Mutex
+		store *lruStore[[]float32]
+	}{&transcriptEmbeddings, &documentEmbeddings} {
+		cache.Lock()
+		if cache.store != nil {
+			cache.store.clear()
+		}
+		cache.Unlock()
+	}
+}
+
+func registerSubjectDataEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "delete-subject-data",
+		Method:      http.MethodDelete,
+		Path:        "/users/{id}/data",
+		Summary:     "Delete a data subject's data",
+		Description: "Permanently delete the objects a tenant or user owns, including trashed ones, except those under a legal hold, and the tenant's conversations and their transcripts. Returns a signed report that is also stored in the system bucket for compliance records, or with dry_run reports what would be deleted without deleting or storing anything",
+	}, func(ctx context.Context, input *struct {
+		ID            string `path:"id" doc:"Data subject ID, the owner recorded in object ACLs"`
+		Authorization string `header:"Authorization" doc:"Bearer admin token"`
+		DryRun        bool   `query:"dry_run" doc:"Only report what would be deleted"`
+		ApprovalID    string `header:"X-Approval-ID" doc:"Approved request for delete-subject-data on the subject; required when approvals.admins is set, except for dry runs"`
+	}) (*struct {
+		Body SignedDeletionReport
+	}, error) {
+		if err := requireAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+		if config.Compliance.ReportSigningKey == "" {
+			return nil, huma.Error503ServiceUnavailable("Deletion reports need compliance.report_signing_key")
+		}
+		if !subjectIDPattern.MatchString(input.ID) {
+			return nil, huma.Error422UnprocessableEntity("Invalid data subject ID")
+		}
+		if !input.DryRun {
+			if err := consumeApproval(ctx, input.ApprovalID, approvalDeleteSubjectData, input.ID); err != nil {
+				return nil, err
+			}
+		}
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+
+		report := DeletionReport{ID: randomHex(8), Subject: input.ID, RequestedAt: time.Now().UTC(), DryRun: input.DryRun}
+		report.Deleted, report.Retained, err = eraseSubjectObjects(ctx, client, input.ID, input.DryRun)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to delete the subject's data", err)
+		}
+		deleted, retained, err := eraseSubjectConversations(ctx, client, input.ID, input.DryRun)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to delete the subject's conversations", err)
+		}
+		report.Deleted, report.Retained = append(report.Deleted, deleted...), append(report.Retained, retained...)
+		sortErased(report.Deleted, report.Retained)
+		if !input.DryRun {
+			forgetEmbeddings()
+		}
+		report.CompletedAt = time.Now().UTC()
+		if !input.DryRun {
+			audit(ctx, AuditEvent{Action: "subject_data.deleted", Operation: "delete-subject-data", Detail: fmt.Sprintf("%s: %d deleted, %d retained", input.ID, len(report.Deleted), len(report.Retained))})
+		}
+
+		signature, err := signDeletionReport(report, config.Compliance.ReportSigningKey)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to sign the deletion report", err)
+		}
+		signed := SignedDeletionReport{Report: report, Algorithm: "hmac-sha256", Signature: signature}
+		if input.DryRun {
+			return &struct {
+				Body SignedDeletionReport
+			}{Body: signed}, nil
+		}
+		signed.Object = deletionReportsPrefix + input.ID + "/" + report.RequestedAt.Format("20060102T150405Z") + "-" + report.ID + ".json"
+		if err := ensureBucket(ctx, config.MinIO.SystemBucket); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to store the deletion report", err)
+		}
+		if err := putJSON(ctx, config.MinIO.SystemBucket, signed.Object, signed); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to store the deletion report", err)
+		}
+		return &struct {
+			Body SignedDeletionReport
+		}{Body: signed}, nil
+	})
+}
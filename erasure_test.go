@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestDeleteSubjectData(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "admin-token"
+
+	objects := map[string]string{
+		"tenants/acme/q1.txt":        "q1",
+		"tenants/.trash/acme/q0.txt": "q0",
+		"tenants/acme/audit.txt":     "held",
+		"tenants/globex/q1.txt":      "other",
+		"app-system/acls/tenants.json": `{
+			"acme/q1.txt": {"visibility": "private", "owner": "acme"},
+			".trash/acme/q0.txt": {"visibility": "private", "owner": "acme"},
+			"acme/audit.txt": {"visibility": "private", "owner": "acme"},
+			"globex/q1.txt": {"visibility": "private", "owner": "globex"}
+		}`,
+		// The transcript of a conversation that was deleted before
+		"app-system/transcripts/tenants/acme/conv_old.json":     `{"conversation_id": "conv_old", "tenant": "acme"}`,
+		"app-system/transcripts/tenants/globex/conv_other.json": `{"conversation_id": "conv_other", "tenant": "globex"}`,
+	}
+	fake := fakeS3(objects)
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["legal-hold"]; ok {
+			status := "OFF"
+			if r.URL.Path == "/tenants/acme/audit.txt" {
+				status = "ON"
+			}
+			w.Write([]byte(`<LegalHold><Status>` + status + `</Status></LegalHold>`))
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	conversations.store = nil
+	defer func() { conversations.store = nil }()
+	conversationsStore().create(context.Background(), &Conversation{ID: "conv_a", Tenant: "acme"})
+	conversationsStore().create(context.Background(), &Conversation{ID: "conv_b", Tenant: "globex"})
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerSubjectDataEndpoint(api)
+	erase := func(id, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, "/users/"+id+"/data", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := erase("acme", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the admin token, got %d", w.Code)
+	}
+	if w := erase("acme", "admin-token"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without a signing key, got %d", w.Code)
+	}
+	config.Compliance.ReportSigningKey = strings.Repeat("k", 32)
+
+	transcriptEmbeddings.store, documentEmbeddings.store = nil, nil
+	defer func() { transcriptEmbeddings.store, documentEmbeddings.store = nil, nil }()
+	transcriptEmbeddingStore().set("turn", []float32{1}, 0)
+	documentEmbeddingStore().set("chunk", []float32{1}, 0)
+
+	count := len(objects)
+	req := httptest.NewRequest(http.MethodDelete, "/users/acme/data?dry_run=true", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var dry SignedDeletionReport
+	json.Unmarshal(w.Body.Bytes(), &dry)
+	if w.Code != http.StatusOK || !dry.Report.DryRun || dry.Object != "" || len(dry.Report.Deleted) != 4 || len(dry.Report.Retained) != 1 {
+		t.Errorf("Expected the dry run to report the deletion, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(objects) != count || !strings.Contains(objects["app-system/acls/tenants.json"], `"acme/q1.txt"`) {
+		t.Errorf("Expected a dry run not to delete or store anything, got %v", objects)
+	}
+	if _, ok := transcriptEmbeddingStore().get("turn"); !ok {
+		t.Error("Expected a dry run to keep the cached embeddings")
+	}
+
+	w = erase("acme", "admin-token")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var signed SignedDeletionReport
+	if err := json.Unmarshal(w.Body.Bytes(), &signed); err != nil {
+		t.Fatal(err)
+	}
+	report := signed.Report
+	if len(report.Deleted) != 4 || report.Deleted[1].Name != ".trash/acme/q0.txt" || report.Deleted[2].Name != "acme/q1.txt" {
+		t.Errorf("Expected the owned and trashed objects to be deleted, got %+v", report.Deleted)
+	}
+	if c := report.Deleted[0]; c.Class != "conversation" || c.Name != "conv_a" || c.Bucket != "" {
+		t.Errorf("Expected the subject's conversation to be deleted, got %+v", c)
+	}
+	if c := report.Deleted[3]; c.Class != "transcript" || c.Bucket != "app-system" || c.Name != "transcripts/tenants/acme/conv_old.json" {
+		t.Errorf("Expected the subject's transcript to be deleted, got %+v", c)
+	}
+	if c, _ := conversationsStore().get(context.Background(), "conv_a"); c != nil {
+		t.Error("Expected the subject's conversation to be forgotten")
+	}
+	if c, _ := conversationsStore().get(context.Background(), "conv_b"); c == nil {
+		t.Error("Expected another tenant's conversation to be kept")
+	}
+	for _, store := range []*lruStore[[]float32]{transcriptEmbeddingStore(), documentEmbeddingStore()} {
+		if store.snapshot().Entries != 0 {
+			t.Errorf("Expected the %s store to be emptied", store.snapshot().Name)
+		}
+	}
+	if _, ok := objects["app-system/transcripts/tenants/acme/conv_old.json"]; ok {
+		t.Error("Expected the subject's transcript to be deleted")
+	}
+	if _, ok := objects["app-system/transcripts/tenants/globex/conv_other.json"]; !ok {
+		t.Error("Expected another tenant's transcript to be kept")
+	}
+	if len(report.Retained) != 1 || report.Retained[0].Name != "acme/audit.txt" || report.Retained[0].Reason != "legal hold" {
+		t.Errorf("Expected the object under legal hold to be retained, got %+v", report.Retained)
+	}
+	for _, key := range []string{"tenants/acme/q1.txt", "tenants/.trash/acme/q0.txt"} {
+		if _, ok := objects[key]; ok {
+			t.Errorf("Expected %s to be deleted", key)
+		}
+	}
+	if _, ok := objects["tenants/acme/audit.txt"]; !ok {
+		t.Error("Expected the held object to be kept")
+	}
+	if _, ok := objects["tenants/globex/q1.txt"]; !ok {
+		t.Error("Expected another tenant's object to be kept")
+	}
+	acls := objects["app-system/acls/tenants.json"]
+	if strings.Contains(acls, "acme/q1.txt") || !strings.Contains(acls, "acme/audit.txt") || !strings.Contains(acls, "globex/q1.txt") {
+		t.Errorf("Expected only the deleted objects' ACLs to be removed, got %s", acls)
+	}
+
+	if want, _ := signDeletionReport(report, config.Compliance.ReportSigningKey); signed.Signature != want {
+		t.Errorf("Expected the signature to verify, got %s", signed.Signature)
+	}
+	stored := objects["app-system/"+signed.Object]
+	if !strings.HasPrefix(signed.Object, deletionReportsPrefix+"acme/") || !strings.Contains(stored, signed.Signature) {
+		t.Errorf("Expected the signed report to be stored at %s, got %q", signed.Object, stored)
+	}
+
+	if w := erase("acme", "admin-token"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"deleted":[]`) {
+		t.Errorf("Expected a repeated deletion to find nothing left, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ErrorCode describes one of the stable codes carried by error responses.
+type ErrorCode struct {
+	Code        string `json:"code" doc:"Machine-readable error code"`
+	Status      int    `json:"status" doc:"HTTP status the code is usually returned with"`
+	Description string `json:"description" doc:"What the error means"`
+}
+
+type ErrorCatalogResponse struct {
+	Errors []ErrorCode `json:"errors" doc:"All error codes"`
+}
+
+// errorCatalog lists every error code. Codes are part of the API contract:
+// never rename or reuse one.
+var errorCatalog = []ErrorCode{
+	{"ERR_BAD_REQUEST", http.StatusBadRequest, "The request is malformed"},
+	{"ERR_UNAUTHORIZED", http.StatusUnauthorized, "Credentials are missing or invalid"},
+	{"ERR_FORBIDDEN", http.StatusForbidden, "The caller may not perform this operation"},
+	{"ERR_NOT_FOUND", http.StatusNotFound, "The requested resource does not exist"},
+	{"ERR_CONFLICT", http.StatusConflict, "The request conflicts with the current state of the resource"},
+	{"ERR_PRECONDITION_FAILED", http.StatusPreconditionFailed, "The resource changed since it was read; read it again and retry"},
+	{"ERR_PAYLOAD_TOO_LARGE", http.StatusRequestEntityTooLarge, "The request body is too large"},
+	{"ERR_UNSUPPORTED_MEDIA_TYPE", http.StatusUnsupportedMediaType, "The content type is not supported"},
+	{"ERR_VALIDATION", http.StatusUnprocessableEntity, "The request is well-formed but its content is invalid"},
+	{"ERR_RATE_LIMITED", http.StatusTooManyRequests, "Too many requests; retry later"},
+	{"ERR_INTERNAL", http.StatusInternalServerError, "An unexpected server error occurred"},
+	{"ERR_NOT_IMPLEMENTED", http.StatusNotImplemented, "The server lacks a component needed for this request"},
+	{"ERR_UPSTREAM", http.StatusBadGateway, "An upstream service returned an error"},
+	{"ERR_UNAVAILABLE", http.StatusServiceUnavailable, "The service is temporarily unavailable"},
+	{"ERR_TIMEOUT", http.StatusGatewayTimeout, "An upstream service timed out"},
+	{"ERR_OPENAI_NOT_CONFIGURED", http.StatusServiceUnavailable, "No OpenAI API key is configured"},
+	{"ERR_OPENAI_RATE_LIMIT", http.StatusTooManyRequests, "OpenAI rate limited the request; retry later"},
+	{"ERR_OPENAI_FAILED", http.StatusInternalServerError, "The OpenAI request failed"},
+	{"ERR_MINIO_NOT_CONFIGURED", http.StatusServiceUnavailable, "No MinIO credentials are configured"},
+	{"ERR_BUCKET_INVALID", http.StatusBadRequest, "The bucket name is not a valid S3 bucket name"},
+	{"ERR_BUCKET_NOT_FOUND", http.StatusNotFound, "The bucket does not exist"},
+	{"ERR_OBJECT_INVALID", http.StatusBadRequest, "The object name is not valid"},
+	{"ERR_OBJECT_NOT_FOUND", http.StatusNotFound, "The object does not exist"},
+	{"ERR_STORAGE_ACCESS_DENIED", http.StatusForbidden, "MinIO denied access with the configured credentials"},
+	{"ERR_BUDGET_EXCEEDED", http.StatusTooManyRequests, "The caller's token or cost budget is used up; retry after it resets"},
+	{"ERR_TERMS_NOT_ACCEPTED", http.StatusForbidden, "The caller must accept the current terms with POST /terms/accept first"},
+	{"ERR_POLICY_VIOLATION", http.StatusForbidden, "The caller's tenant policy does not allow the operation or model"},
+	{"ERR_MODEL_NOT_ALLOWED", http.StatusBadRequest, "The model is not in openai.allowed_models"},
+	{"ERR_OUTPUT_BLOCKED", http.StatusUnprocessableEntity, "The output filter blocked the generated content"},
+	{"ERR_INPUT_FLAGGED", http.StatusUnprocessableEntity, "Input moderation flagged the message"},
+	{"ERR_CHECKSUM_MISMATCH", http.StatusUnprocessableEntity, "The received content does not match the checksum sent with it"},
+	{"ERR_APPROVAL_REQUIRED", http.StatusForbidden, "The action needs an approved request from a second admin, sent as X-Approval-ID"},
+	{"ERR_POLICY_DENIED", http.StatusForbidden, "The authorization policy in Open Policy Agent denied the operation"},
+	{"ERR_SIGNATURE_INVALID", http.StatusUnauthorized, "The request's signature, timestamp or nonce is invalid"},
+	{"ERR_VERSIONING_DISABLED", http.StatusConflict, "The bucket is not versioned, so its past state is unknown"},
+}
+
+// statusCodes is the default code for each status.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "ERR_BAD_REQUEST",
+	http.StatusUnauthorized:          "ERR_UNAUTHORIZED",
+	http.StatusForbidden:             "ERR_FORBIDDEN",
+	http.StatusNotFound:              "ERR_NOT_FOUND",
+	http.StatusConflict:              "ERR_CONFLICT",
+	http.StatusPreconditionFailed:    "ERR_PRECONDITION_FAILED",
+	http.StatusRequestEntityTooLarge: "ERR_PAYLOAD_TOO_LARGE",
+	http.StatusUnsupportedMediaType:  "ERR_UNSUPPORTED_MEDIA_TYPE",
+	http.StatusUnprocessableEntity:   "ERR_VALIDATION",
+	http.StatusTooManyRequests:       "ERR_RATE_LIMITED",
+	http.StatusNotImplemented:        "ERR_NOT_IMPLEMENTED",
+	http.StatusBadGateway:            "ERR_UPSTREAM",
+	http.StatusServiceUnavailable:    "ERR_UNAVAILABLE",
+	http.StatusGatewayTimeout:        "ERR_TIMEOUT",
+}
+
+// minioCodes maps MinIO error codes to our own. Client mistakes are
+// reported as such even when the handler treated them as server errors.
+var minioCodes = map[string]struct {
+	Code   string
+	Status int
+}{
+	"InvalidBucketName":       {"ERR_BUCKET_INVALID", http.StatusBadRequest},
+	"NoSuchBucket":            {"ERR_BUCKET_NOT_FOUND", 0},
+	"XMinioInvalidObjectName": {"ERR_OBJECT_INVALID", http.StatusBadRequest},
+	"NoSuchKey":               {"ERR_OBJECT_NOT_FOUND", 0},
+	"AccessDenied":            {"ERR_STORAGE_ACCESS_DENIED", 0},
+}
+
+// APIError is the problem details body of every error response.
+type APIError struct {
+	huma.ErrorModel
+	Code string `json:"code" doc:"Stable machine-readable error code; see GET /errors"`
+}
+
+// newAPIError replaces huma.NewError so every error response carries a
+// code. The code is derived from the MinIO error among errs, if any, and
+// otherwise from the status.
+func newAPIError(status int, msg string, errs ...error) huma.StatusError {
+	details := make([]*huma.ErrorDetail, 0, len(errs))
+	code := ""
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if converted, ok := err.(huma.ErrorDetailer); ok {
+			details = append(details, converted.ErrorDetail())
+		} else {
+			details = append(details, &huma.ErrorDetail{Message: err.Error()})
+		}
+		if mapped, ok := minioCodes[minio.ToErrorResponse(err).Code]; ok && code == "" {
+			code = mapped.Code
+			if mapped.Status != 0 {
+				status = mapped.Status
+			}
+		}
+	}
+	if code == "" {
+		code = statusCodes[status]
+	}
+	if code == "" {
+		code = "ERR_INTERNAL"
+	}
+	return &APIError{
+		ErrorModel: huma.ErrorModel{
+			Status: status,
+			Title:  http.StatusText(status),
+			Detail: msg,
+			Errors: details,
+		},
+		Code: code,
+	}
+}
+
+func init() {
+	huma.NewError = newAPIError
+}
+
+// codedError returns an error response with a specific code.
+func codedError(status int, code, msg string, errs ...error) error {
+	err := huma.NewError(status, msg, errs...)
+	if e, ok := err.(*APIError); ok {
+		e.Code = code
+	}
+	return err
+}
+
+// writeError writes err as a problem details response from a plain HTTP
+// handler, outside of huma.
+func writeError(w http.ResponseWriter, err error) {
+	var se huma.StatusError
+	if !errors.As(err, &se) {
+		se = huma.NewError(http.StatusInternalServerError, err.Error())
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(se.GetStatus())
+	json.NewEncoder(w).Encode(se)
+}
+
+func errOpenAINotConfigured() error {
+	return codedError(http.StatusServiceUnavailable, "ERR_OPENAI_NOT_CONFIGURED", "OpenAI client not configured")
+}
+
+func errMinIONotConfigured() error {
+	return codedError(http.StatusServiceUnavailable, "ERR_MINIO_NOT_CONFIGURED", "MinIO client not configured")
+}
+
+func errBucketNotFound(bucket string) error {
+	return codedError(http.StatusNotFound, "ERR_BUCKET_NOT_FOUND", fmt.Sprintf("Bucket %s not found", bucket))
+}
+
+func errObjectNotFound(bucket, name string) error {
+	return codedError(http.StatusNotFound, "ERR_OBJECT_NOT_FOUND", fmt.Sprintf("Object %s not found in bucket %s", name, bucket))
+}
+
+// openAIStatusCode returns the HTTP status OpenAI answered err with, if any.
+func openAIStatusCode(err error) int {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.HTTPStatusCode
+	}
+	return 0
+}
+
+func registerErrorCatalogEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "list-error-codes",
+		Method:      http.MethodGet,
+		Path:        "/errors",
+		Summary:     "List error codes",
+		Description: "List the stable error codes returned in the code field of error responses",
+	}, func(ctx context.Context, input *struct{}) (*struct {
+		Body ErrorCatalogResponse
+	}, error) {
+		return &struct {
+			Body ErrorCatalogResponse
+		}{
+			Body: ErrorCatalogResponse{Errors: errorCatalog},
+		}, nil
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/minio/minio-go/v7"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+func TestNewAPIError(t *testing.T) {
+	tests := []struct {
+		status     int
+		errs       []error
+		wantStatus int
+		wantCode   string
+	}{
+		{http.StatusNotFound, nil, http.StatusNotFound, "ERR_NOT_FOUND"},
+		{http.StatusUnprocessableEntity, nil, http.StatusUnprocessableEntity, "ERR_VALIDATION"},
+		{http.StatusTeapot, nil, http.StatusTeapot, "ERR_INTERNAL"},
+		{http.StatusInternalServerError, []error{minio.ErrorResponse{Code: "NoSuchBucket"}}, http.StatusInternalServerError, "ERR_BUCKET_NOT_FOUND"},
+		{http.StatusInternalServerError, []error{minio.ErrorResponse{Code: "InvalidBucketName"}}, http.StatusBadRequest, "ERR_BUCKET_INVALID"},
+	}
+	for _, tt := range tests {
+		err := huma.NewError(tt.status, "failed", tt.errs...).(*APIError)
+		if err.Status != tt.wantStatus || err.Code != tt.wantCode {
+			t.Errorf("NewError(%d, %v) = %d %s, want %d %s", tt.status, tt.errs, err.Status, err.Code, tt.wantStatus, tt.wantCode)
+		}
+	}
+
+	err := openAIError(t.Context(), "Failed to get OpenAI response", &openai.APIError{HTTPStatusCode: http.StatusTooManyRequests})
+	if e := err.(*APIError); e.Status != http.StatusTooManyRequests || e.Code != "ERR_OPENAI_RATE_LIMIT" {
+		t.Errorf("Expected an OpenAI rate limit error, got %d %s", e.Status, e.Code)
+	}
+}
+
+func TestErrorCatalogIsComplete(t *testing.T) {
+	known := map[string]bool{}
+	for _, c := range errorCatalog {
+		if known[c.Code] {
+			t.Errorf("Duplicate error code %s", c.Code)
+		}
+		known[c.Code] = true
+	}
+	for _, code := range statusCodes {
+		if !known[code] {
+			t.Errorf("Status code %s is missing from the catalog", code)
+		}
+	}
+	for _, m := range minioCodes {
+		if !known[m.Code] {
+			t.Errorf("MinIO code %s is missing from the catalog", m.Code)
+		}
+	}
+}
+
+func TestErrorResponsesCarryCodes(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerErrorCatalogEndpoint(api)
+	registerCollectionEndpoints(api)
+
+	req := httptest.NewRequest(http.MethodGet, "/errors", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "ERR_OPENAI_RATE_LIMIT") {
+		t.Errorf("Expected the error catalog, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Handler errors
+	req = httptest.NewRequest(http.MethodGet, "/collections", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var problem APIError
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if problem.Code != "ERR_MINIO_NOT_CONFIGURED" {
+		t.Errorf("Expected ERR_MINIO_NOT_CONFIGURED, got %q", problem.Code)
+	}
+
+	// Validation errors raised by huma itself
+	req = httptest.NewRequest(http.MethodPost, "/collections", bytes.NewReader([]byte(`{"name": "Not Valid"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	problem = APIError{}
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if w.Code != http.StatusUnprocessableEntity || problem.Code != "ERR_VALIDATION" {
+		t.Errorf("Expected a 422 ERR_VALIDATION, got %d %q", w.Code, problem.Code)
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// eventLogPrefix is where the event log keeps one object per day in the
+// system bucket.
+const eventLogPrefix = "events/"
+
+// maxPendingEvents bounds the events held while the log cannot be written.
+const maxPendingEvents = 100000
+
+// EventLogConfig persists audit events to a tamper-evident log.
+type EventLogConfig struct {
+	Enabled      bool `mapstructure:"enabled" doc:"Append every audit event to a hash-chained log object per day in the system bucket"`
+	FlushSeconds int  `mapstructure:"flush_seconds" doc:"Seconds between writes of the day's log object"`
+}
+
+func (c EventLogConfig) Validate() error {
+	if c.Enabled && c.FlushSeconds <= 0 {
+		return errors.New("event_log.flush_seconds must be positive")
+	}
+	return nil
+}
+
+// EventLogEntry is one line of a day's log object. Hash is the SHA-256 of
+// the entry's JSON without the hash, and PrevHash the hash of the entry
+// before it, in the same or an earlier day, so that changing, removing or
+// reordering entries breaks the chain.
+type EventLogEntry struct {
+	Seq      int64      `json:"seq"`
+	PrevHash string     `json:"prev_hash"`
+	Event    AuditEvent `json:"event"`
+	Hash     string     `json:"hash,omitempty"`
+}
+
+func (e EventLogEntry) computeHash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func eventLogKey(day string) string {
+	return eventLogPrefix + day + ".jsonl"
+}
+
+// eventLogWriter collects audit events and appends them to the log object
+// of the day they are written on. Only one instance should write a log.
+type eventLogWriter struct {
+	mu      sync.Mutex
+	pending []AuditEvent
+
+	// Owned by the flushing goroutine
+	loaded  bool
+	day     string
+	content []byte
+	seq     int64
+	hash    string
+}
+
+var eventLog = &eventLogWriter{}
+
+// record queues an event for the next flush.
+func (w *eventLogWriter) record(ev AuditEvent) {
+	if !config.EventLog.Enabled {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) >= maxPendingEvents {
+		return
+	}
+	w.pending = append(w.pending, ev)
+}
+
+// requeue puts events that could not be written back before newer ones.
+func (w *eventLogWriter) requeue(events []AuditEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(events, w.pending...)
+	if len(w.pending) > maxPendingEvents {
+		w.pending = w.pending[:maxPendingEvents]
+	}
+}
+
+// load continues the chain from the newest log object up to day, and the
+// content of day's object if it exists.
+func (w *eventLogWriter) load(ctx context.Context, client *minio.Client, day string) error {
+	if err := ensureBucket(ctx, config.MinIO.SystemBucket); err != nil {
+		return err
+	}
+	var last string
+	for obj := range client.ListObjects(ctx, config.MinIO.SystemBucket, minio.ListObjectsOptions{Prefix: eventLogPrefix}) {
+		if obj.Err != nil {
+			if isNotFound(obj.Err) {
+				break
+			}
+			return obj.Err
+		}
+		if obj.Key <= eventLogKey(day) {
+			last = obj.Key
+		}
+	}
+	w.loaded, w.day, w.content, w.seq, w.hash = true, day, nil, 0, ""
+	if last == "" {
+		return nil
+	}
+	data, err := readEventLogObject(ctx, client, last)
+	if err != nil {
+		return err
+	}
+	lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	var tail EventLogEntry
+	if err := json.Unmarshal(lines[len(lines)-1], &tail); err != nil {
+		return fmt.Errorf("reading the end of %s: %w", last, err)
+	}
+	w.seq, w.hash = tail.Seq, tail.Hash
+	if last == eventLogKey(day) {
+		w.content = data
+	}
+	return nil
+}
+
+// flush appends the queued events to the log object of now's day. Events
+// that cannot be written stay queued for the next flush.
+func (w *eventLogWriter) flush(ctx context.Context, client *minio.Client, now time.Time) error {
+	w.mu.Lock()
+	events := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+	if len(events) == 0 {
+		return nil
+	}
+
+	day := now.UTC().Format(time.DateOnly)
+	if !w.loaded {
+		if err := w.load(ctx, client, day); err != nil {
+			w.requeue(events)
+			return err
+		}
+	}
+	content := w.content
+	if day != w.day {
+		content = nil
+	}
+	content = bytes.Clone(content)
+	seq, hash := w.seq, w.hash
+	for _, ev := range events {
+		seq++
+		entry := EventLogEntry{Seq: seq, PrevHash: hash, Event: ev}
+		entry.Hash = entry.computeHash()
+		hash = entry.Hash
+		line, _ := json.Marshal(entry)
+		content = append(append(content, line...), '\n')
+	}
+
+	key := eventLogKey(day)
+	if _, err := client.PutObject(ctx, config.MinIO.SystemBucket, key, bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{
+		ContentType: "application/x-ndjson",
+	}); err != nil {
+		w.requeue(events)
+		return err
+	}
+	w.day, w.content, w.seq, w.hash = day, content, seq, hash
+	// The head in the process log anchors the chain: entries cut off the end
+	// of the log leave no trace in the log itself
+	log.Printf("Event log: wrote %d events to %s, head %d %s", len(events), key, seq, hash)
+	return nil
+}
+
+// startEventLog flushes the queued events every interval until ctx is
+// cancelled, and once more then.
+func startEventLog(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		flush := func(ctx context.Context) {
+			client, err := services.MinIO()
+			if err == nil {
+				err = eventLog.flush(ctx, client, time.Now())
+			}
+			if err != nil {
+				log.Printf("Event log write failed: %v", err)
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+				flush(ctx)
+				cancel()
+				return
+			case <-ticker.C:
+				flush(ctx)
+			}
+		}
+	}()
+}
+
+func readEventLogObject(ctx context.Context, client *minio.Client, key string) ([]byte, error) {
+	obj, err := client.GetObject(ctx, config.MinIO.SystemBucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	return io.ReadAll(obj)
+}
+
+// EventLogProblem is a break in the chain found by verification.
+type EventLogProblem struct {
+	Object  string `json:"object"`
+	Line    int    `json:"line"`
+	Problem string `json:"problem"`
+}
+
+type EventLogDay struct {
+	Day     string `json:"day"`
+	Entries int    `json:"entries"`
+	OK      bool   `json:"ok"`
+}
+
+// EventLogVerification reports whether the log objects of a range of days
+// form an unbroken chain.
+type EventLogVerification struct {
+	Days     []EventLogDay     `json:"days"`
+	Entries  int               `json:"entries"`
+	Head     string            `json:"head,omitempty"`
+	Problems []EventLogProblem `json:"problems"`
+}
+
+// verifyEventLog checks every entry of the log objects from day from to day
+// to, both inclusive and either empty for no limit: that its hash matches
+// its content, and that it follows the entry before it. The first entry in
+// range is taken on trust unless it starts the log.
+func verifyEventLog(ctx context.Context, client *minio.Client, from, to string) (EventLogVerification, error) {
+	out := EventLogVerification{Days: []EventLogDay{}, Problems: []EventLogProblem{}}
+	var keys []string
+	for obj := range client.ListObjects(ctx, config.MinIO.SystemBucket, minio.ListObjectsOptions{Prefix: eventLogPrefix}) {
+		if obj.Err != nil {
+			if isNotFound(obj.Err) {
+				break
+			}
+			return out, obj.Err
+		}
+		if (from == "" || obj.Key >= eventLogKey(from)) && (to == "" || obj.Key <= eventLogKey(to)) {
+			keys = append(keys, obj.Key)
+		}
+	}
+
+	var prev *EventLogEntry
+	for _, key := range keys {
+		day := EventLogDay{Day: strings.TrimSuffix(strings.TrimPrefix(key, eventLogPrefix), ".jsonl"), OK: true}
+		problem := func(line int, format string, args ...any) {
+			day.OK = false
+			out.Problems = append(out.Problems, EventLogProblem{Object: key, Line: line, Problem: fmt.Sprintf(format, args...)})
+		}
+		data, err := readEventLogObject(ctx, client, key)
+		if err != nil {
+			return out, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(nil, 16<<20)
+		for line := 1; scanner.Scan(); line++ {
+			var entry EventLogEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				problem(line, "not a log entry: %v", err)
+				continue
+			}
+			day.Entries++
+			if entry.computeHash() != entry.Hash {
+				problem(line, "entry %d does not match its hash", entry.Seq)
+			}
+			switch {
+			case prev == nil && from == "" && (entry.Seq != 1 || entry.PrevHash != ""):
+				problem(line, "the log starts at entry %d instead of 1", entry.Seq)
+			case prev != nil && entry.Seq != prev.Seq+1:
+				problem(line, "entry %d follows entry %d", entry.Seq, prev.Seq)
+			case prev != nil && entry.PrevHash != prev.Hash:
+				problem(line, "entry %d does not chain to entry %d", entry.Seq, prev.Seq)
+			}
+			prev = &entry
+		}
+		if err := scanner.Err(); err != nil {
+			problem(0, "unreadable: %v", err)
+		}
+		out.Entries += day.Entries
+		out.Days = append(out.Days, day)
+	}
+	if prev != nil {
+		out.Head = prev.Hash
+	}
+	return out, nil
+}
+
+// runVerifyEventsCommand checks the event log in MinIO with the local
+// configuration, and fails if the chain is broken.
+func runVerifyEventsCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("verify-events", flag.ContinueOnError)
+	fs.SetOutput(out)
+	from := fs.String("from", "", "First day to verify, as YYYY-MM-DD; the log's start by default")
+	to := fs.String("to", "", "Last day to verify, as YYYY-MM-DD; the newest by default")
+	format := outputFlag(fs, outputTable)
+	if err := parseFlags(fs, args, format); err != nil {
+		return err
+	}
+	for _, day := range []string{*from, *to} {
+		if _, err := time.Parse(time.DateOnly, day); day != "" && err != nil {
+			return usageError("invalid day %q: use YYYY-MM-DD", day)
+		}
+	}
+
+	initConfig()
+	initClients()
+	client, err := services.MinIO()
+	if err != nil {
+		return &exitError{code: exitUnavailable, err: errors.New("minio is not configured")}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report, err := verifyEventLog(ctx, client, *from, *to)
+	if err != nil {
+		return &exitError{code: exitUnavailable, err: err}
+	}
+
+	if *format == outputJSON {
+		if err := writeJSON(out, report); err != nil {
+			return err
+		}
+	} else {
+		tw := newTable(out)
+		fmt.Fprintln(tw, "DAY\tENTRIES\tCHAIN")
+		for _, d := range report.Days {
+			status := "ok"
+			if !d.OK {
+				status = "BROKEN"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", d.Day, d.Entries, status)
+		}
+		tw.Flush()
+		for _, p := range report.Problems {
+			fmt.Fprintf(out, "%s:%d: %s\n", p.Object, p.Line, p.Problem)
+		}
+		if report.Head != "" {
+			fmt.Fprintf(out, "Head: %s\n", report.Head)
+		}
+	}
+	if len(report.Problems) > 0 {
+		return &exitError{code: exitFailure, err: fmt.Errorf("the event log has %d problems", len(report.Problems))}
+	}
+	return nil
+}
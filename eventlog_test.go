@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestEventLog(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.EventLog.Enabled = true
+
+	objects := map[string]string{}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+	ctx := context.Background()
+	day1 := time.Date(2026, 10, 16, 23, 0, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Hour)
+
+	w := &eventLogWriter{}
+	for _, action := range []string{"file.trashed", "file.restored", "policy.update"} {
+		w.record(AuditEvent{Time: day1, Action: action})
+	}
+	if err := w.flush(ctx, client, day1); err != nil {
+		t.Fatal(err)
+	}
+	w.record(AuditEvent{Time: day1, Action: "file.trashed"})
+	if err := w.flush(ctx, client, day1); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(objects["app-system/events/2026-10-16.jsonl"], "\n"); n != 4 {
+		t.Fatalf("Expected four entries on the first day, got %d", n)
+	}
+
+	// A restarted writer continues the chain in the next day's object
+	w = &eventLogWriter{}
+	w.record(AuditEvent{Time: day2, Action: "secrets.rotated"})
+	if err := w.flush(ctx, client, day2); err != nil {
+		t.Fatal(err)
+	}
+	report, err := verifyEventLog(ctx, client, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 || report.Entries != 5 || len(report.Days) != 2 || !strings.Contains(objects["app-system/events/2026-10-17.jsonl"], `"seq":5`) {
+		t.Fatalf("Expected an unbroken chain of five entries over two days, got %+v", report)
+	}
+
+	// Editing an entry breaks its hash, and removing one breaks the chain
+	original := objects["app-system/events/2026-10-16.jsonl"]
+	objects["app-system/events/2026-10-16.jsonl"] = strings.Replace(original, "file.restored", "file.uploaded", 1)
+	report, _ = verifyEventLog(ctx, client, "", "")
+	if len(report.Problems) != 1 || report.Problems[0].Line != 2 || !strings.Contains(report.Problems[0].Problem, "hash") || report.Days[0].OK || !report.Days[1].OK {
+		t.Errorf("Expected the edited entry to be found, got %+v", report)
+	}
+	lines := strings.SplitAfter(original, "\n")
+	objects["app-system/events/2026-10-16.jsonl"] = strings.Join(append(lines[:1:1], lines[2:]...), "")
+	report, _ = verifyEventLog(ctx, client, "", "")
+	if len(report.Problems) != 1 || !strings.Contains(report.Problems[0].Problem, "entry 3 follows entry 1") {
+		t.Errorf("Expected the removed entry to be found, got %+v", report)
+	}
+	if report, _ := verifyEventLog(ctx, client, "2026-10-17", ""); len(report.Problems) != 0 || report.Entries != 1 {
+		t.Errorf("Expected the second day alone to verify, got %+v", report)
+	}
+
+	// The command fails on a broken chain
+	viper.Set("minio.url", config.MinIO.URL)
+	var out bytes.Buffer
+	if code := runCommand("verify-events", []string{"-to", "2026-10-16"}, nil, &out, io.Discard); code != exitFailure || !strings.Contains(out.String(), "BROKEN") {
+		t.Errorf("Expected verify-events to report the broken day, got %d: %s", code, out.String())
+	}
+	objects["app-system/events/2026-10-16.jsonl"] = original
+	out.Reset()
+	if code := runCommand("verify-events", nil, nil, &out, io.Discard); code != exitOK || !strings.Contains(out.String(), "2026-10-17  1") {
+		t.Errorf("Expected verify-events to pass, got %d: %s", code, out.String())
+	}
+	if code := runCommand("verify-events", []string{"-from", "yesterday"}, nil, io.Discard, io.Discard); code != exitUsage {
+		t.Errorf("Expected an invalid day to be a usage error, got %d", code)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// MinIOFailoverConfig lists further endpoints serving the same data as
+// minio.url, e.g. the sites of a replicated MinIO deployment.
+type MinIOFailoverConfig struct {
+	Endpoints          []string `mapstructure:"endpoints" doc:"Further MinIO endpoints (host:port) replicating minio.url, taken over in order when it is down"`
+	HealthCheckSeconds int      `mapstructure:"health_check_seconds" doc:"Seconds between health checks of every endpoint"`
+	ReadFromReplica    bool     `mapstructure:"read_from_replica" doc:"Serve downloads, previews, renders and sites from a healthy endpoint other than the one taking writes"`
+}
+
+func (c MinIOFailoverConfig) Validate() error {
+	if len(c.Endpoints) > 0 && c.HealthCheckSeconds <= 0 {
+		return errors.New("minio.failover.health_check_seconds must be positive")
+	}
+	return nil
+}
+
+// MinIOEndpointStatus is the latest health check of one endpoint.
+type MinIOEndpointStatus struct {
+	URL       string    `json:"url" doc:"Endpoint (host:port)"`
+	Healthy   bool      `json:"healthy" doc:"Whether the endpoint answered its last check"`
+	Role      string    `json:"role,omitempty" enum:"primary,replica" doc:"primary takes the writes, replica serves reads"`
+	CheckedAt time.Time `json:"checked_at,omitempty" doc:"Time of the last check"`
+}
+
+type minioEndpoint struct {
+	url       string
+	client    *minio.Client
+	healthy   bool
+	checkedAt time.Time
+}
+
+// minioFailover switches the MinIO client of the service registry to the
+// first healthy endpoint, so storage operations continue while an endpoint
+// is down. The configured primary gets the writes back once it recovers.
+type minioFailover struct {
+	mu        sync.Mutex
+	endpoints []*minioEndpoint
+	active    int
+	replica   int // -1 without a replica to read from
+}
+
+// minioPool is the failover of the MinIO endpoints, or nil when only
+// minio.url is configured.
+var minioPool atomic.Pointer[minioFailover]
+
+// initMinIOFailover creates a client per endpoint and returns the one of
+// minio.url. Every endpoint counts as healthy until checked.
+func initMinIOFailover(creds *credentials.Credentials) (*minio.Client, error) {
+	f := &minioFailover{replica: -1}
+	for i, url := range append([]string{config.MinIO.URL}, config.MinIO.Failover.Endpoints...) {
+		client, err := newMinIOEndpointClient(url, creds, func() { f.markDown(i) })
+		if err != nil {
+			return nil, err
+		}
+		f.endpoints = append(f.endpoints, &minioEndpoint{url: url, client: client, healthy: true})
+	}
+	minioPool.Store(f)
+	f.mu.Lock()
+	f.elect()
+	f.mu.Unlock()
+	return f.endpoints[0].client, nil
+}
+
+// probeMinIO reports whether an endpoint is up. Any answer from the server,
+// even a refusal, counts as up; only server errors and failed connections
+// don't.
+func probeMinIO(ctx context.Context, client *minio.Client) bool {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err := client.BucketExists(ctx, config.MinIO.SystemBucket)
+	if err == nil {
+		return true
+	}
+	status := minio.ToErrorResponse(err).StatusCode
+	return status >= 400 && status < 500
+}
+
+// check probes every endpoint and re-elects the primary and replica.
+func (f *minioFailover) check(ctx context.Context, now time.Time) {
+	healthy := make([]bool, len(f.endpoints))
+	var wg sync.WaitGroup
+	for i, e := range f.endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			healthy[i] = probeMinIO(ctx, e.client)
+		}()
+	}
+	wg.Wait()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, e := range f.endpoints {
+		if e.healthy != healthy[i] {
+			log.Printf("MinIO endpoint %s is %s", e.url, map[bool]string{true: "up", false: "down"}[healthy[i]])
+		}
+		e.healthy, e.checkedAt = healthy[i], now
+	}
+	f.elect()
+}
+
+// markDown takes an endpoint whose connection failed out of service until
+// its next successful check, without waiting for the check.
+func (f *minioFailover) markDown(i int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.endpoints[i].healthy {
+		return
+	}
+	log.Printf("MinIO endpoint %s is down", f.endpoints[i].url)
+	f.endpoints[i].healthy = false
+	f.elect()
+}
+
+// elect makes the first healthy endpoint the primary and, if enabled, the
+// next healthy one the replica. With every endpoint down the primary is
+// kept, so requests report the outage instead of failing for lack of a
+// client. f.mu must be held.
+func (f *minioFailover) elect() {
+	active, replica := f.active, -1
+	for i, e := range f.endpoints {
+		if e.healthy {
+			active = i
+			break
+		}
+	}
+	if config.MinIO.Failover.ReadFromReplica {
+		for i, e := range f.endpoints {
+			if e.healthy && i != active {
+				replica = i
+				break
+			}
+		}
+	}
+
+	if active != f.active {
+		log.Printf("MinIO failover: %s takes over from %s", f.endpoints[active].url, f.endpoints[f.active].url)
+		f.active = active
+	}
+	f.replica = replica
+	if minioPool.Load() != f {
+		// Replaced by a re-initialization
+		return
+	}
+	services.SetMinIO(f.endpoints[active].client)
+	if replica >= 0 {
+		services.SetMinIOReader(f.endpoints[replica].client)
+	} else {
+		services.SetMinIOReader(nil)
+	}
+}
+
+// statuses reports every endpoint in configuration order.
+func (f *minioFailover) statuses() []MinIOEndpointStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]MinIOEndpointStatus, len(f.endpoints))
+	for i, e := range f.endpoints {
+		out[i] = MinIOEndpointStatus{URL: e.url, Healthy: e.healthy, CheckedAt: e.checkedAt}
+		switch i {
+		case f.active:
+			out[i].Role = "primary"
+		case f.replica:
+			out[i].Role = "replica"
+		}
+	}
+	return out
+}
+
+// start checks the endpoints now and then every interval.
+func (f *minioFailover) start(ctx context.Context, interval time.Duration) {
+	go func() {
+		f.check(ctx, time.Now())
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				f.check(ctx, now)
+			}
+		}
+	}()
+}
+
+// minioEndpointStatuses reports the failover endpoints, or nil without
+// failover.
+func minioEndpointStatuses() []MinIOEndpointStatus {
+	if f := minioPool.Load(); f != nil {
+		return f.statuses()
+	}
+	return nil
+}
+
+// failoverTransport reports failed connections to an endpoint, so the
+// failover moves on before the next health check.
+type failoverTransport struct {
+	base   http.RoundTripper
+	onDown func()
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil && req.Context().Err() == nil {
+		t.onDown()
+	}
+	return resp, err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/spf13/viper"
+)
+
+func TestMinIOFailover(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	defer func(n int) { minio.MaxRetry = n }(minio.MaxRetry)
+	minio.MaxRetry = 1
+
+	primary := httptest.NewServer(fakeS3(map[string]string{"app-system/state.json": `"primary"`}))
+	defer primary.Close()
+	secondary := httptest.NewServer(fakeS3(map[string]string{"app-system/state.json": `"secondary"`}))
+	defer secondary.Close()
+	config.MinIO.URL = strings.TrimPrefix(primary.URL, "http://")
+	config.MinIO.Failover.Endpoints = []string{strings.TrimPrefix(secondary.URL, "http://")}
+	config.MinIO.Failover.ReadFromReplica = true
+	defer func() {
+		config.MinIO.Failover = MinIOFailoverConfig{}
+		minioPool.Store(nil)
+		services.SetMinIO(nil)
+		services.SetMinIOReader(nil)
+	}()
+
+	client, err := initMinIOFailover(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	pool := minioPool.Load()
+	pool.check(ctx, time.Now())
+
+	if c, _ := services.MinIO(); c != client {
+		t.Error("Expected writes to go to minio.url")
+	}
+	if c, _ := services.MinIOReader(); c == client {
+		t.Error("Expected reads to go to the replica")
+	}
+	if s := pool.statuses(); s[0].Role != "primary" || s[1].Role != "replica" || !s[0].Healthy || !s[1].Healthy {
+		t.Errorf("Expected both endpoints up, got %+v", s)
+	}
+	if h := healthStatus(&HealthConfig{}); h.Status != "healthy" || len(h.MinIOEndpoints) != 2 {
+		t.Errorf("Expected a healthy report of both endpoints, got %+v", h)
+	}
+
+	// A failed connection moves storage over before the next check
+	primary.Close()
+	var state string
+	if err := getJSON(ctx, "app-system", "state.json", &state); err == nil {
+		t.Fatal("Expected the request to the closed endpoint to fail")
+	}
+	if err := getJSON(ctx, "app-system", "state.json", &state); err != nil || state != "secondary" {
+		t.Errorf("Expected the secondary to take over, got %q, %v", state, err)
+	}
+	if c, _ := services.MinIOReader(); c == client {
+		t.Error("Expected no reads from the closed endpoint")
+	}
+
+	pool.check(ctx, time.Now())
+	s := pool.statuses()
+	if s[0].Healthy || s[0].Role != "" || s[1].Role != "primary" || s[1].CheckedAt.IsZero() {
+		t.Errorf("Expected the secondary to be primary, got %+v", s)
+	}
+	if h := healthStatus(&HealthConfig{}); h.Status != "degraded" {
+		t.Errorf("Expected a down endpoint to degrade health, got %s", h.Status)
+	}
+}
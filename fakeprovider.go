@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// fakeReply is what the fake provider answers to every chat request.
+const fakeReply = "This is a canned reply from the fake provider."
+
+// fakeOpenAITransport answers OpenAI API requests in process, so load tests
+// measure the service rather than the provider and cost nothing.
+type fakeOpenAITransport struct {
+	latency time.Duration
+}
+
+func newFakeOpenAIClient(latency time.Duration) *openai.Client {
+	cfg := openai.DefaultConfig("fake")
+	cfg.HTTPClient = &http.Client{Transport: &fakeOpenAITransport{latency: latency}}
+	return openai.NewClientWithConfig(cfg)
+}
+
+func (t *fakeOpenAITransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	if t.latency > 0 {
+		timer := time.NewTimer(t.latency)
+		defer timer.Stop()
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+
+	switch {
+	case req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/chat/completions"):
+		var body struct {
+			Stream bool `json:"stream"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			return fakeResponse(req, http.StatusBadRequest, "application/json", fakeError(err.Error())), nil
+		}
+		if body.Stream {
+			return fakeResponse(req, http.StatusOK, "text/event-stream", fakeChatStream()), nil
+		}
+		data, _ := json.Marshal(openai.ChatCompletionResponse{
+			ID:      "chatcmpl-fake",
+			Object:  "chat.completion",
+			Created: time.Now().Unix(),
+			Model:   openai.GPT3Dot5Turbo,
+			Choices: []openai.ChatCompletionChoice{{
+				Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: fakeReply},
+				FinishReason: openai.FinishReasonStop,
+			}},
+			Usage: openai.Usage{PromptTokens: 10, CompletionTokens: 10, TotalTokens: 20},
+		})
+		return fakeResponse(req, http.StatusOK, "application/json", data), nil
+	case req.Method == http.MethodGet && strings.HasSuffix(req.URL.Path, "/models"):
+		data, _ := json.Marshal(openai.ModelsList{Models: []openai.Model{
+			{ID: openai.GPT3Dot5Turbo, Object: "model", OwnedBy: "fake"},
+		}})
+		return fakeResponse(req, http.StatusOK, "application/json", data), nil
+	case req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/moderations"):
+		data, _ := json.Marshal(openai.ModerationResponse{
+			ID:      "modr-fake",
+			Model:   "text-moderation-latest",
+			Results: []openai.Result{{}},
+		})
+		return fakeResponse(req, http.StatusOK, "application/json", data), nil
+	}
+	return fakeResponse(req, http.StatusNotFound, "application/json", fakeError("the fake provider does not implement "+req.URL.Path)), nil
+}
+
+// fakeChatStream streams fakeReply word by word, followed by a chunk with
+// the finish reason like OpenAI sends.
+func fakeChatStream() []byte {
+	var choices []openai.ChatCompletionStreamChoice
+	for _, word := range strings.SplitAfter(fakeReply, " ") {
+		choices = append(choices, openai.ChatCompletionStreamChoice{Delta: openai.ChatCompletionStreamChoiceDelta{Content: word}})
+	}
+	choices[0].Delta.Role = openai.ChatMessageRoleAssistant
+	choices = append(choices, openai.ChatCompletionStreamChoice{FinishReason: openai.FinishReasonStop})
+
+	var b bytes.Buffer
+	for _, choice := range choices {
+		data, _ := json.Marshal(openai.ChatCompletionStreamResponse{
+			ID:      "chatcmpl-fake",
+			Object:  "chat.completion.chunk",
+			Created: time.Now().Unix(),
+			Model:   openai.GPT3Dot5Turbo,
+			Choices: []openai.ChatCompletionStreamChoice{choice},
+		})
+		b.WriteString("data: ")
+		b.Write(data)
+		b.WriteString("\n\n")
+	}
+	b.WriteString("data: [DONE]\n\n")
+	return b.Bytes()
+}
+
+func fakeError(message string) []byte {
+	data, _ := json.Marshal(map[string]any{"error": map[string]string{"message": message, "type": "invalid_request_error"}})
+	return data
+}
+
+func fakeResponse(req *http.Request, status int, contentType string, body []byte) *http.Response {
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	// Generous rate limits keep the key probe from warning.
+	header.Set("x-ratelimit-limit-requests", "1000000")
+	header.Set("x-ratelimit-remaining-requests", "1000000")
+	header.Set("x-ratelimit-limit-tokens", "1000000000")
+	header.Set("x-ratelimit-remaining-tokens", "1000000000")
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func newFakeChatRouter(t testing.TB) http.Handler {
+	viper.Reset()
+	initConfig()
+	services.SetOpenAI(newFakeOpenAIClient(0))
+	t.Cleanup(func() { services.SetOpenAI(nil) })
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerChatEndpoint(api)
+	registerChatStreamEndpoint(api)
+	return router
+}
+
+func TestFakeProvider(t *testing.T) {
+	router := newFakeChatRouter(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"message": "Hi"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), fakeReply) {
+		t.Errorf("Expected the canned reply, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/chat/stream", strings.NewReader(`{"message": "Hi"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	// Words the client has not read yet are sent together
+	if w.Code != http.StatusOK || streamedReply(w.Body.String()) != fakeReply || !strings.Contains(w.Body.String(), "event: done") {
+		t.Errorf("Expected the canned reply streamed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	client, _ := services.OpenAI()
+	models, err := client.ListModels(t.Context())
+	if err != nil || len(models.Models) == 0 {
+		t.Errorf("Expected the fake model list, got %v, %v", models, err)
+	}
+}
+
+func BenchmarkChatEndpoint(b *testing.B) {
+	router := newFakeChatRouter(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"message": "Hi"}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func BenchmarkChatStreamEndpoint(b *testing.B) {
+	router := newFakeChatRouter(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		req := httptest.NewRequest(http.MethodPost, "/chat/stream", strings.NewReader(`{"message": "Hi"}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// FileChatConfig bounds the documents POST /chat/with-files retrieves from.
+type FileChatConfig struct {
+	MaxObjects     int   `mapstructure:"max_objects" doc:"Most objects under a prefix a request may chat over"`
+	MaxObjectBytes int64 `mapstructure:"max_object_bytes" doc:"Larger objects are skipped"`
+	ChunkSize      int   `mapstructure:"chunk_size" doc:"Bytes of text per chunk that is embedded and retrieved"`
+	ChunkOverlap   int   `mapstructure:"chunk_overlap" doc:"Bytes consecutive chunks share, so a passage cut by a chunk boundary is found whole in one of them"`
+	MaxChunks      int   `mapstructure:"max_chunks" doc:"Most chunks a request may embed"`
+	TopK           int   `mapstructure:"top_k" doc:"Chunks added to the prompt when a request sets no top_k"`
+}
+
+func (c FileChatConfig) Validate() error {
+	if c.MaxObjects <= 0 || c.MaxObjectBytes <= 0 || c.MaxChunks <= 0 {
+		return errors.New("file_chat.max_objects, max_object_bytes and max_chunks must be positive")
+	}
+	if c.ChunkSize <= 0 || c.ChunkOverlap < 0 || c.ChunkOverlap >= c.ChunkSize {
+		return errors.New("file_chat.chunk_size must be positive and file_chat.chunk_overlap between 0 and chunk_size")
+	}
+	if c.TopK < 1 || c.TopK > maxFileChatTopK {
+		return fmt.Errorf("file_chat.top_k must be between 1 and %d", maxFileChatTopK)
+	}
+	return nil
+}
+
+// maxFileChatTopK bounds the chunks added to a prompt.
+const maxFileChatTopK = 20
+
+type FileChatRequest struct {
+	Message     string        `json:"message" minLength:"1" doc:"Question about the documents"`
+	History     []ChatMessage `json:"history,omitempty" doc:"Earlier turns of the conversation, oldest first"`
+	Bucket      string        `json:"bucket,omitempty" doc:"Bucket of the documents; set either bucket or collection"`
+	Prefix      string        `json:"prefix,omitempty" doc:"Only objects in bucket whose names start with this, e.g. handbook/"`
+	Collection  string        `json:"collection,omitempty" pattern:"^[a-z0-9][a-z0-9_-]{0,62}$" doc:"Collection whose documents to chat over, in place of a bucket"`
+	TopK        int           `json:"top_k,omitempty" minimum:"1" maximum:"20" doc:"Most relevant chunks added to the prompt; defaults to file_chat.top_k"`
+	Model       string        `json:"model,omitempty" doc:"OpenAI model to use; defaults to the tenant policy's default model or gpt-3.5-turbo"`
+	Temperature *float32      `json:"temperature,omitempty" minimum:"0" maximum:"2" doc:"Sampling temperature; defaults to OpenAI's default"`
+	MaxTokens   int           `json:"max_tokens,omitempty" minimum:"1" maximum:"128000" doc:"Most tokens the reply may have; defaults to the model's limit"`
+}
+
+type FileChatSource struct {
+	Name  string  `json:"name" doc:"Object the chunk is from; bucket/name for the documents of a collection"`
+	Chunk int     `json:"chunk" doc:"Position of the chunk in the object, from 0"`
+	Score float64 `json:"score" doc:"Cosine similarity of the chunk to the message"`
+}
+
+type FileChatResponse struct {
+	Reply   string              `json:"reply" doc:"Response from OpenAI"`
+	Sources []FileChatSource    `json:"sources" doc:"Chunks added to the prompt, most relevant first"`
+	Skipped []string            `json:"skipped,omitempty" doc:"Objects that were left out: too large, not text, not readable by the caller, or, in a collection, no longer there"`
+	Filter  *OutputFilterResult `json:"filter,omitempty" doc:"Decisions of the output filter, when enabled"`
+	Model   string              `json:"model" doc:"Model that answered, as reported by OpenAI"`
+	Usage   ChatUsage           `json:"usage" doc:"Tokens used for the reply; the embeddings are billed separately"`
+	Cache   string              `json:"cache,omitempty" enum:"hit,miss" doc:"Whether the reply came from the chat cache, when enabled"`
+}
+
+// documentChunk is a piece of a stored text object.
+type documentChunk struct {
+	name  string
+	index int
+	text  string
+	score float64
+}
+
+// documentEmbeddings caches the vectors of document chunks by the hash of
+// their text, so unchanged documents are embedded once.
+var documentEmbeddings struct {
+	sync.Mutex
+	store *lruStore[[]float32]
+}
+
+func documentEmbeddingStore() *lruStore[[]float32] {
+	documentEmbeddings.Lock()
+	defer documentEmbeddings.Unlock()
+	if documentEmbeddings.store == nil {
+		documentEmbeddings.store = newLRUStore("document_embeddings", config.Stores.DocumentEmbeddings, func(v []float32) int64 { return int64(4 * len(v)) })
+	}
+	return documentEmbeddings.store
+}
+
+// chunkText splits text into chunks of at most size bytes that overlap by
+// about overlap bytes. Chunks end at whitespace where possible and never
+// inside a UTF-8 sequence.
+func chunkText(text string, size, overlap int) []string {
+	var chunks []string
+	for start := 0; start < len(text); {
+		end := min(start+size, len(text))
+		if end < len(text) {
+			// Prefer to cut at whitespace in the second half of the chunk
+			if i := strings.LastIndexFunc(text[start+size/2:end], unicode.IsSpace); i >= 0 {
+				end = start + size/2 + i + 1
+			}
+			for end > start+1 && !utf8.RuneStart(text[end]) {
+				end--
+			}
+		}
+		if chunk := strings.TrimSpace(text[start:end]); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		if end == len(text) {
+			break
+		}
+		next := max(end-overlap, start+1)
+		for next < end && !utf8.RuneStart(text[next]) {
+			next++
+		}
+		start = next
+	}
+	return chunks
+}
+
+// documentRef is an object to split into chunks, and the name its chunks
+// are reported under.
+type documentRef struct {
+	bucket string
+	key    string
+	name   string
+}
+
+// loadDocumentChunks reads the text objects under prefix that caller may
+// read and splits them into chunks. Trashed objects are not documents and
+// are passed over. It returns the names of the objects it left out.
+func loadDocumentChunks(ctx context.Context, bucket, prefix string, caller aclCaller) ([]documentChunk, []string, error) {
+	if err := checkBucketAccess(bucket); err != nil {
+		return nil, nil, err
+	}
+	client, err := services.MinIO()
+	if err != nil {
+		return nil, nil, err
+	}
+	acls, err := loadACLs(ctx, bucket)
+	if err != nil {
+		return nil, nil, huma.Error500InternalServerError("Failed to load the bucket's ACLs", err)
+	}
+	c := config.FileChat
+	var docs []documentRef
+	var skipped []string
+	for obj := range client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			if isNotFound(obj.Err) {
+				return nil, nil, huma.Error404NotFound(fmt.Sprintf("Bucket %s not found", bucket))
+			}
+			return nil, nil, huma.Error500InternalServerError("Failed to list objects", obj.Err)
+		}
+		if strings.HasSuffix(obj.Key, "/") || strings.HasPrefix(obj.Key, trashPrefix) {
+			continue
+		}
+		if acl, ok := acls[obj.Key]; ok {
+			if err := checkACL(acl, caller, bucket, obj.Key); err != nil {
+				skipped = append(skipped, obj.Key)
+				continue
+			}
+		}
+		if obj.Size > c.MaxObjectBytes {
+			skipped = append(skipped, obj.Key)
+			continue
+		}
+		if len(docs) == c.MaxObjects {
+			return nil, nil, huma.Error422UnprocessableEntity(fmt.Sprintf("More than %d objects under %s/%s; narrow the prefix", c.MaxObjects, bucket, prefix))
+		}
+		docs = append(docs, documentRef{bucket: bucket, key: obj.Key, name: obj.Key})
+	}
+
+	chunks, unreadable, err := chunkDocuments(ctx, docs, fmt.Sprintf("under %s/%s", bucket, prefix), "narrow the prefix")
+	return chunks, append(skipped, unreadable...), err
+}
+
+// loadCollectionChunks reads the text documents of a collection that
+// caller may read and splits them into chunks. A collection may span
+// buckets, so its documents are named bucket/name. Documents that were
+// deleted or trashed since they were added are left out with the others.
+func loadCollectionChunks(ctx context.Context, collection *Collection, caller aclCaller) ([]documentChunk, []string, error) {
+	client, err := services.MinIO()
+	if err != nil {
+		return nil, nil, err
+	}
+	c := config.FileChat
+	acls := map[string]map[string]ObjectACL{}
+	var docs []documentRef
+	var skipped []string
+	for _, d := range collection.Documents {
+		name := d.Bucket + "/" + d.Name
+		if checkBucketAccess(d.Bucket) != nil || strings.HasPrefix(d.Name, trashPrefix) {
+			skipped = append(skipped, name)
+			continue
+		}
+		bucketACLs, ok := acls[d.Bucket]
+		if !ok {
+			if bucketACLs, err = loadACLs(ctx, d.Bucket); err != nil {
+				return nil, nil, huma.Error500InternalServerError("Failed to load the bucket's ACLs", err)
+			}
+			acls[d.Bucket] = bucketACLs
+		}
+		if acl, ok := bucketACLs[d.Name]; ok {
+			if err := checkACL(acl, caller, d.Bucket, d.Name); err != nil {
+				skipped = append(skipped, name)
+				continue
+			}
+		}
+		info, err := client.StatObject(ctx, d.Bucket, d.Name, minio.StatObjectOptions{})
+		if err != nil {
+			if isNotFound(err) {
+				skipped = append(skipped, name)
+				continue
+			}
+			return nil, nil, huma.Error500InternalServerError("Failed to stat object", err)
+		}
+		if info.Size > c.MaxObjectBytes {
+			skipped = append(skipped, name)
+			continue
+		}
+		if len(docs) == c.MaxObjects {
+			return nil, nil, huma.Error422UnprocessableEntity(fmt.Sprintf("Collection %s has more than %d documents", collection.Name, c.MaxObjects))
+		}
+		docs = append(docs, documentRef{bucket: d.Bucket, key: d.Name, name: name})
+	}
+
+	chunks, unreadable, err := chunkDocuments(ctx, docs, "of collection "+collection.Name, "remove documents from it")
+	return chunks, append(skipped, unreadable...), err
+}
+
+// chunkDocuments reads docs and splits them into chunks. It returns the
+// names of the documents that are not text. scope and hint describe the
+// documents in the error for too many chunks.
+func chunkDocuments(ctx context.Context, docs []documentRef, scope, hint string) ([]documentChunk, []string, error) {
+	c := config.FileChat
+	var chunks []documentChunk
+	var skipped []string
+	for _, doc := range docs {
+		text, err := readTextObject(ctx, doc.bucket, doc.key, "", c.MaxObjectBytes)
+		if err != nil {
+			// Binary objects and objects that grew since they were listed
+			var se huma.StatusError
+			if errors.As(err, &se) && se.GetStatus() == http.StatusUnprocessableEntity {
+				skipped = append(skipped, doc.name)
+				continue
+			}
+			return nil, nil, err
+		}
+		for i, chunk := range chunkText(text, c.ChunkSize, c.ChunkOverlap) {
+			chunks = append(chunks, documentChunk{name: doc.name, index: i, text: chunk})
+		}
+		if len(chunks) > c.MaxChunks {
+			return nil, nil, huma.Error422UnprocessableEntity(fmt.Sprintf("The documents %s have more than %d chunks; %s", scope, c.MaxChunks, hint))
+		}
+	}
+	return chunks, skipped, nil
+}
+
+// documentsPrompt is the system message presenting the retrieved chunks to
+// the model.
+func documentsPrompt(chunks []documentChunk) string {
+	var b strings.Builder
+	b.WriteString("Answer the user's next message using the following excerpts of their documents. " +
+		"Name the documents you use. If the excerpts do not contain the answer, say so.\n")
+	for i, c := range chunks {
+		fmt.Fprintf(&b, "\n[%d] %s (part %d):\n%s\n", i+1, c.name, c.index+1, c.text)
+	}
+	return b.String()
+}
+
+func registerFileChatEndpoint(api huma.API) {
+	huma.Register(api, withRoutePolicy(huma.Operation{
+		OperationID: "chat-with-files",
+		Method:      http.MethodPost,
+		Path:        "/chat/with-files",
+		Summary:     "Chat about stored documents",
+		Description: "Split the text objects under a bucket prefix, or the documents of a collection, into chunks, embed them, and answer the message with the chunks most similar to it in the prompt",
+	}, RoutePolicy{Timeout: 2 * time.Minute, RateClass: "chat", Budgeted: true}), func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token; objects with an ACL are only read for callers it allows"`
+		Body          FileChatRequest
+	}) (*struct {
+		Body FileChatResponse
+	}, error) {
+		provider, err := chatProvider(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := requireTermsAccepted(ctx, input.Authorization); err != nil {
+			return nil, err
+		}
+		if (input.Body.Bucket == "") == (input.Body.Collection == "") {
+			return nil, huma.Error422UnprocessableEntity("Set either bucket or collection")
+		}
+		if input.Body.Collection != "" && input.Body.Prefix != "" {
+			return nil, huma.Error422UnprocessableEntity("prefix only applies to a bucket")
+		}
+		if input.Body.Bucket != "" {
+			if err := checkBucketAccess(input.Body.Bucket); err != nil {
+				return nil, err
+			}
+		}
+		model, err := policyModel(ctx, input.Body.Model)
+		if err != nil {
+			return nil, err
+		}
+
+		var chunks []documentChunk
+		var skipped []string
+		scope := fmt.Sprintf("under %s/%s", input.Body.Bucket, input.Body.Prefix)
+		if input.Body.Collection != "" {
+			collection, err := loadCollection(ctx, input.Body.Collection)
+			if err != nil {
+				return nil, err
+			}
+			chunks, skipped, err = loadCollectionChunks(ctx, collection, objectCaller(input.Authorization))
+			if err != nil {
+				return nil, err
+			}
+			scope = "in collection " + collection.Name
+		} else if chunks, skipped, err = loadDocumentChunks(ctx, input.Body.Bucket, input.Body.Prefix, objectCaller(input.Authorization)); err != nil {
+			return nil, err
+		}
+		if len(chunks) == 0 {
+			return nil, huma.Error422UnprocessableEntity("No readable text objects " + scope)
+		}
+		texts := []string{input.Body.Message}
+		for _, c := range chunks {
+			texts = append(texts, c.text)
+		}
+		vectors, err := embedCached(ctx, provider, documentEmbeddingStore(), texts)
+		if err != nil {
+			return nil, openAIError(ctx, "Failed to embed the documents", err)
+		}
+		for i := range chunks {
+			chunks[i].score = cosineSimilarity(vectors[0], vectors[i+1])
+		}
+		slices.SortStableFunc(chunks, func(a, b documentChunk) int { return cmp.Compare(b.score, a.score) })
+		chunks = chunks[:min(cmp.Or(input.Body.TopK, config.FileChat.TopK), len(chunks))]
+
+		req := ChatRequest{
+			Message:     input.Body.Message,
+			History:     input.Body.History,
+			Model:       model,
+			Temperature: input.Body.Temperature,
+			MaxTokens:   input.Body.MaxTokens,
+			documents:   documentsPrompt(chunks),
+		}
+		resp, err := completeChat(ctx, provider, "chat_with_files", req, model)
+		if err != nil {
+			return nil, err
+		}
+		sources := make([]FileChatSource, len(chunks))
+		for i, c := range chunks {
+			sources[i] = FileChatSource{Name: c.name, Chunk: c.index, Score: c.score}
+		}
+		return &struct {
+			Body FileChatResponse
+		}{Body: FileChatResponse{
+			Reply:   resp.Reply,
+			Sources: sources,
+			Skipped: skipped,
+			Filter:  resp.Filter,
+			Model:   resp.Model,
+			Usage:   resp.Usage,
+			Cache:   resp.Cache,
+		}}, nil
+	})
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+func TestChunkText(t *testing.T) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dög. ", 20)
+	chunks := chunkText(text, 100, 20)
+	if len(chunks) < 9 {
+		t.Fatalf("Expected the text to be split into chunks of 100 bytes, got %d chunks", len(chunks))
+	}
+	words := map[string]bool{}
+	for _, w := range strings.Fields(text) {
+		words[w] = true
+	}
+	for i, c := range chunks {
+		if len(c) > 100 || !utf8.ValidString(c) {
+			t.Errorf("Expected chunk %d to be valid text of at most 100 bytes, got %q", i, c)
+		}
+		// Chunks end at whitespace, so they hold whole words
+		for _, w := range strings.Fields(c)[1:] {
+			if !words[w] {
+				t.Errorf("Expected chunk %d to end at a word boundary, got %q", i, c)
+			}
+		}
+	}
+	// Consecutive chunks overlap
+	if tail := chunks[0][len(chunks[0])-10:]; !strings.Contains(chunks[1], tail) {
+		t.Errorf("Expected the second chunk to repeat the end of the first, got %q and %q", chunks[0], chunks[1])
+	}
+	if got := chunkText("short", 100, 20); len(got) != 1 || got[0] != "short" {
+		t.Errorf("Expected a short text to be one chunk, got %q", got)
+	}
+	if got := chunkText(" \n ", 100, 20); len(got) != 0 {
+		t.Errorf("Expected no chunks of blank text, got %q", got)
+	}
+}
+
+func TestChatWithFiles(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Storage.Tenants = []StorageTenantConfig{{Name: "acme", Token: "acme-token", Bucket: "tenants"}}
+	defer func() { config.Storage.Tenants = nil }()
+	documentEmbeddings.store = nil
+
+	objects := map[string]string{
+		"docs/handbook/leave.md":        "Vacation: every employee gets 30 days of vacation per year.",
+		"docs/handbook/it.md":           "Laptops are replaced every three years.",
+		"docs/handbook/logo.png":        "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+		"docs/handbook/salaries.md":     "Vacation pay is 120% of the salary.",
+		"docs/other.md":                 "Vacation is not covered here.",
+		"app-system/acls/docs.json":     `{"handbook/salaries.md": {"visibility": "private", "owner": "globex"}}`,
+		"docs/handbook/archive/old.md":  "Old vacation rules: 20 days.",
+		"docs/.trash/handbook/draft.md": "Draft vacation rules: 10 days.",
+		"app-system/collections/hr.json": `{"name": "hr", "documents": [{"bucket": "docs", "name": "handbook/leave.md"}, {"bucket": "docs", "name": "handbook/salaries.md"},
+			{"bucket": "docs", "name": "handbook/logo.png"}, {"bucket": "docs", "name": "gone.md"}]}`,
+	}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	// Texts about vacation point one way, all others another
+	var embedded int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Input []string }
+		json.NewDecoder(r.Body).Decode(&req)
+		resp := openai.EmbeddingResponse{}
+		for i, text := range req.Input {
+			v := []float32{1, 0.1}
+			if strings.Contains(strings.ToLower(text), "vacation") {
+				v = []float32{0.1, 1}
+			}
+			resp.Data = append(resp.Data, openai.Embedding{Index: i, Embedding: v})
+		}
+		embedded += len(req.Input)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+	provider := &recordingProvider{}
+	config.ChatProvider.Name = "recording"
+	chatProviders["recording"] = func() (ChatProvider, error) {
+		return withEmbeddings{provider, newTestOpenAIClient(srv.URL)}, nil
+	}
+	defer delete(chatProviders, "recording")
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerFileChatEndpoint(api)
+	chat := func(body string) (*httptest.ResponseRecorder, FileChatResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/chat/with-files", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer acme-token")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp FileChatResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+
+	w, resp := chat(`{"message": "How many vacation days do I get?", "bucket": "docs", "prefix": "handbook/", "top_k": 2}`)
+	if w.Code != http.StatusOK || resp.Reply != "A cat" || len(resp.Sources) != 2 {
+		t.Fatalf("Expected a reply from two chunks, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, s := range resp.Sources {
+		if s.Name != "handbook/leave.md" && s.Name != "handbook/archive/old.md" || s.Score < 0.99 {
+			t.Errorf("Expected the chunks about vacation, got %+v", resp.Sources)
+		}
+	}
+	if strings.Join(resp.Skipped, " ") != "handbook/logo.png handbook/salaries.md" && strings.Join(resp.Skipped, " ") != "handbook/salaries.md handbook/logo.png" {
+		t.Errorf("Expected the image and the other tenant's object to be skipped, got %v", resp.Skipped)
+	}
+	messages := provider.last.Messages
+	if n := len(messages); n < 2 || messages[n-2].Role != openai.ChatMessageRoleSystem || !strings.Contains(messages[n-2].Content, "30 days of vacation") ||
+		strings.Contains(messages[n-2].Content, "Laptops") || messages[n-1].Content != "How many vacation days do I get?" {
+		t.Errorf("Expected the chunks in front of the message, got %+v", messages)
+	}
+	// The chunks are embedded once
+	if embedded != 4 {
+		t.Errorf("Expected the message and 3 chunks to be embedded, got %d", embedded)
+	}
+	chat(`{"message": "And laptops?", "bucket": "docs", "prefix": "handbook/"}`)
+	if embedded != 5 {
+		t.Errorf("Expected only the new message to be embedded, got %d texts", embedded)
+	}
+
+	// Trashed documents are not read
+	w, resp = chat(`{"message": "Vacation?", "bucket": "docs", "top_k": 10}`)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), ".trash/") || strings.Contains(provider.last.Messages[len(provider.last.Messages)-2].Content, "Draft") {
+		t.Errorf("Expected the trash to be left out, got %d: %s", w.Code, w.Body.String())
+	}
+	// Internal state is never a document, even if a caller gets past the
+	// handler's check
+	if _, _, err := loadDocumentChunks(context.Background(), "app-system", "", aclCaller{admin: true}); err == nil || !strings.Contains(err.Error(), "internal state") {
+		t.Errorf("Expected the system bucket to be refused, got %v", err)
+	}
+
+	// The documents of a collection are read where the caller may
+	w, resp = chat(`{"message": "Vacation?", "collection": "hr", "top_k": 10}`)
+	if w.Code != http.StatusOK || len(resp.Sources) != 1 || resp.Sources[0].Name != "docs/handbook/leave.md" {
+		t.Fatalf("Expected the collection's readable document, got %d: %s", w.Code, w.Body.String())
+	}
+	if strings.Join(resp.Skipped, " ") != "docs/handbook/salaries.md docs/gone.md docs/handbook/logo.png" {
+		t.Errorf("Expected the other tenant's, the deleted and the binary document to be skipped, got %v", resp.Skipped)
+	}
+	for body, expected := range map[string]int{
+		`{"message": "Hi", "collection": "missing"}`:                   http.StatusNotFound,
+		`{"message": "Hi", "bucket": "docs", "collection": "hr"}`:      http.StatusUnprocessableEntity,
+		`{"message": "Hi", "collection": "hr", "prefix": "handbook/"}`: http.StatusUnprocessableEntity,
+		`{"message": "Hi"}`: http.StatusUnprocessableEntity,
+	} {
+		if w, _ := chat(body); w.Code != expected {
+			t.Errorf("Expected %d for %s, got %d", expected, body, w.Code)
+		}
+	}
+
+	config.FileChat.MaxObjects = 2
+	if w, _ := chat(`{"message": "Hi", "bucket": "docs", "prefix": "handbook/"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected too many objects to be refused, got %d", w.Code)
+	}
+	if w, _ := chat(`{"message": "Hi", "bucket": "docs", "prefix": "nothing/"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected a prefix without documents to be refused, got %d", w.Code)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// decodeObjectKey turns a {name} path parameter into an object key. Keys
+// containing slashes must be sent URL-encoded (e.g. reports%2F2024.txt)
+// since a path parameter only matches a single segment.
+func decodeObjectKey(raw string) (string, error) {
+	key, err := url.PathUnescape(raw)
+	if err != nil {
+		return "", huma.Error400BadRequest("Invalid object name", err)
+	}
+	if strings.TrimSpace(key) == "" {
+		return "", huma.Error400BadRequest("Object name must not be empty")
+	}
+	return key, nil
+}
+
+// isNotFound reports whether a MinIO error means the object or bucket does
+// not exist.
+func isNotFound(err error) bool {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket", "NoSuchVersion":
+		return true
+	}
+	return false
+}
+
+// moveBackupPrefix is where moveObject keeps, in the system bucket, an
+// object a move replaces until the move has succeeded.
+const moveBackupPrefix = "moves/"
+
+// moveObject copies src to dst inside a bucket and removes src. If src
+// cannot be removed the copy is rolled back, so callers either see the
+// object under its old name or its new one, never both. An object the move
+// replaced is put back. The object's ACL moves with it.
+func moveObject(ctx context.Context, bucket, src, dst string) error {
+	client, err := services.MinIO()
+	if err != nil {
+		return err
+	}
+	backup := ""
+	if _, err := client.StatObject(ctx, bucket, dst, minio.StatObjectOptions{}); err == nil {
+		if err := ensureBucket(ctx, config.MinIO.SystemBucket); err != nil {
+			return err
+		}
+		backup = moveBackupPrefix + randomHex(16)
+		if _, err := client.CopyObject(ctx,
+			minio.CopyDestOptions{Bucket: config.MinIO.SystemBucket, Object: backup},
+			minio.CopySrcOptions{Bucket: bucket, Object: dst},
+		); err != nil {
+			return err
+		}
+		defer func() {
+			if err := client.RemoveObject(context.WithoutCancel(ctx), config.MinIO.SystemBucket, backup, minio.RemoveObjectOptions{}); err != nil {
+				log.Printf("Failed to remove the backup of %s/%s: %v", bucket, dst, err)
+			}
+		}()
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	_, err = client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: bucket, Object: dst},
+		minio.CopySrcOptions{Bucket: bucket, Object: src},
+	)
+	if err != nil {
+		return err
+	}
+	if err := client.RemoveObject(ctx, bucket, src, minio.RemoveObjectOptions{}); err != nil {
+		var rbErr error
+		if backup != "" {
+			_, rbErr = client.CopyObject(ctx,
+				minio.CopyDestOptions{Bucket: bucket, Object: dst},
+				minio.CopySrcOptions{Bucket: config.MinIO.SystemBucket, Object: backup},
+			)
+		} else {
+			rbErr = client.RemoveObject(ctx, bucket, dst, minio.RemoveObjectOptions{})
+		}
+		if rbErr != nil {
+			log.Printf("Failed to roll back copy of %s/%s to %s: %v", bucket, src, dst, rbErr)
+		}
+		return err
+	}
+	moveACL(ctx, bucket, src, dst)
+	return nil
+}
+
+// ensureBucket creates bucket if it does not exist yet.
+func ensureBucket(ctx context.Context, bucket string) error {
+	client, err := services.MinIO()
+	if err != nil {
+		return err
+	}
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil || exists {
+		return err
+	}
+	return client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{})
+}
+
+// putJSON stores v as a JSON object.
+func putJSON(ctx context.Context, bucket, key string, v any) error {
+	client, err := services.MinIO()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = client.PutObject(ctx, bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	return err
+}
+
+// getJSON loads a JSON object into v. Missing objects are reported by
+// isNotFound.
+func getJSON(ctx context.Context, bucket, key string, v any) error {
+	client, err := services.MinIO()
+	if err != nil {
+		return err
+	}
+	obj, err := client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	return json.NewDecoder(obj).Decode(v)
+}
+
+// byteRange is a satisfiable range of an object's bytes, end inclusive.
+type byteRange struct {
+	start, end, size int64
+}
+
+func (r byteRange) length() int64 { return r.end - r.start + 1 }
+
+func (r byteRange) contentRange() string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, r.size)
+}
+
+// errRangeNotSatisfiable means no byte of the requested range exists.
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// parseByteRange resolves a Range header against an object of size bytes.
+// It returns nil for headers that are to be ignored, which serves the
+// whole object: malformed ones, other units and multiple ranges.
+func parseByteRange(header string, size int64) (*byteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, nil
+	}
+	r := byteRange{end: size - 1, size: size}
+	if first == "" {
+		// Suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return nil, nil
+		}
+		if n == 0 || size == 0 {
+			return nil, errRangeNotSatisfiable
+		}
+		r.start = max(size-n, 0)
+		return &r, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+	if last != "" {
+		end, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return nil, nil
+		}
+		r.end = min(end, size-1)
+	}
+	if start >= size {
+		return nil, errRangeNotSatisfiable
+	}
+	r.start = start
+	return &r, nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+type FolderCreateRequest struct {
+	Path string `json:"path" minLength:"1" doc:"Folder path, e.g. reports/2024"`
+}
+
+type FolderEntry struct {
+	Name         string      `json:"name" doc:"Entry name relative to the listed folder"`
+	Path         string      `json:"path" doc:"Full object key or folder prefix"`
+	IsFolder     bool        `json:"is_folder" doc:"Whether the entry is a subfolder"`
+	Size         int64       `json:"size,omitempty" doc:"Object size in bytes"`
+	LastModified time.Time   `json:"last_modified,omitempty" doc:"Object modification time"`
+	ETag         string      `json:"etag,omitempty" doc:"Object ETag; the MD5 of the content unless it contains a dash (multipart uploads)"`
+	Provenance   *Provenance `json:"provenance,omitempty" doc:"How the file was generated, if the service generated it and provenance was requested"`
+}
+
+type FolderListResponse struct {
+	Bucket  string        `json:"bucket" doc:"Bucket name"`
+	Path    string        `json:"path" doc:"Listed folder prefix"`
+	Entries []FolderEntry `json:"entries" doc:"Subfolders followed by files"`
+}
+
+type FolderMoveRequest struct {
+	From string `json:"from" minLength:"1" doc:"Folder to move"`
+	To   string `json:"to" minLength:"1" doc:"Destination folder"`
+}
+
+type FolderResponse struct {
+	Success bool   `json:"success" doc:"Operation success status"`
+	Message string `json:"message" doc:"Operation result message"`
+	Path    string `json:"path" doc:"Resulting folder prefix"`
+	Moved   int    `json:"moved,omitempty" doc:"Number of objects moved"`
+}
+
+// folderPrefix normalizes a user-supplied folder path into an object key
+// prefix: no leading slash and exactly one trailing slash. The bucket root
+// is the empty string.
+func folderPrefix(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return ""
+	}
+	return path + "/"
+}
+
+// moveFolder moves every object under from to the same relative key under
+// to. If an object cannot be moved, the objects already moved are moved
+// back so the folder is not left split between both prefixes; the returned
+// count is then the number of objects that could not be moved back.
+func moveFolder(ctx context.Context, bucket, from, to string) (int, error) {
+	client, err := services.MinIO()
+	if err != nil {
+		return 0, err
+	}
+	moved := []string{}
+	for obj := range client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: from, Recursive: true}) {
+		err = obj.Err
+		if err == nil {
+			err = moveObject(ctx, bucket, obj.Key, to+strings.TrimPrefix(obj.Key, from))
+		}
+		if err != nil {
+			return rollbackFolderMove(ctx, bucket, from, to, moved), err
+		}
+		moved = append(moved, obj.Key)
+	}
+	return len(moved), nil
+}
+
+// rollbackFolderMove moves the given keys from to back to from and returns
+// how many of them stayed under to.
+func rollbackFolderMove(ctx context.Context, bucket, from, to string, keys []string) int {
+	stranded := 0
+	for _, key := range keys {
+		if err := moveObject(ctx, bucket, to+strings.TrimPrefix(key, from), key); err != nil {
+			log.Printf("Failed to move %s/%s back while rolling back folder move: %v", bucket, key, err)
+			stranded++
+		}
+	}
+	return stranded
+}
+
+func registerFolderEndpoints(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "create-folder",
+		Method:      http.MethodPost,
+		Path:        "/folders/{bucket}",
+		Summary:     "Create a folder",
+		Description: "Create an empty folder by writing a zero-byte marker object for the prefix",
+	}, func(ctx context.Context, input *struct {
+		Bucket string `path:"bucket" doc:"MinIO bucket name"`
+		Body   FolderCreateRequest
+	}) (*struct {
+		Body FolderResponse
+	}, error) {
+		if err := checkBucketAccess(input.Bucket); err != nil {
+			return nil, err
+		}
+
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+
+		prefix := folderPrefix(input.Body.Path)
+		if prefix == "" {
+			return nil, huma.Error400BadRequest("Folder path must not be empty")
+		}
+
+		_, err = client.PutObject(ctx, input.Bucket, prefix, bytes.NewReader(nil), 0, minio.PutObjectOptions{})
+		if err != nil {
+			if isNotFound(err) {
+				return nil, errBucketNotFound(input.Bucket)
+			}
+			return nil, huma.Error500InternalServerError("Failed to create folder", err)
+		}
+
+		return &struct {
+			Body FolderResponse
+		}{
+			Body: FolderResponse{
+				Success: true,
+				Message: fmt.Sprintf("Folder %s created in bucket %s", prefix, input.Bucket),
+				Path:    prefix,
+			},
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "list-folder",
+		Method:      http.MethodGet,
+		Path:        "/folders/{bucket}",
+		Summary:     "List a folder",
+		Description: "List the direct subfolders and files under a folder, like a directory listing, or with recursive every file below it. Files the caller may not read under their ACL are left out",
+	}, func(ctx context.Context, input *struct {
+		Bucket        string `path:"bucket" doc:"MinIO bucket name"`
+		Path          string `query:"path" doc:"Folder to list; empty lists the bucket root"`
+		Recursive     bool   `query:"recursive" doc:"List every file below the folder instead of its direct entries"`
+		Provenance    bool   `query:"provenance" doc:"Include the provenance of generated files; looks up every file, so listings are slower"`
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token; anonymous callers only see public and unrestricted files"`
+	}) (*struct {
+		Body FolderListResponse
+	}, error) {
+		if err := checkBucketAccess(input.Bucket); err != nil {
+			return nil, err
+		}
+
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+
+		acls, err := loadACLs(ctx, input.Bucket)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to load ACLs", err)
+		}
+		caller := objectCaller(input.Authorization)
+
+		prefix := folderPrefix(input.Path)
+		folders := []FolderEntry{}
+		files := []FolderEntry{}
+		for obj := range client.ListObjects(ctx, input.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: input.Recursive}) {
+			if obj.Err != nil {
+				if isNotFound(obj.Err) {
+					return nil, errBucketNotFound(input.Bucket)
+				}
+				return nil, huma.Error500InternalServerError("Failed to list folder", obj.Err)
+			}
+			// Skip the folder's own marker and the trash
+			if obj.Key == prefix || strings.HasPrefix(obj.Key, trashPrefix) {
+				continue
+			}
+
+			name := strings.TrimPrefix(obj.Key, prefix)
+			if strings.HasSuffix(obj.Key, "/") {
+				folders = append(folders, FolderEntry{
+					Name:     strings.TrimSuffix(name, "/"),
+					Path:     obj.Key,
+					IsFolder: true,
+				})
+				continue
+			}
+			if acl, ok := acls[obj.Key]; ok && !acl.allows(caller) {
+				continue
+			}
+			entry := FolderEntry{
+				Name:         name,
+				Path:         obj.Key,
+				Size:         obj.Size,
+				LastModified: obj.LastModified,
+				ETag:         strings.Trim(obj.ETag, `"`),
+			}
+			if input.Provenance {
+				if entry.Provenance, err = statProvenance(ctx, client, input.Bucket, obj.Key); err != nil && !isNotFound(err) {
+					return nil, huma.Error500InternalServerError("Failed to read provenance", err)
+				}
+			}
+			files = append(files, entry)
+		}
+
+		return &struct {
+			Body FolderListResponse
+		}{
+			Body: FolderListResponse{
+				Bucket:  input.Bucket,
+				Path:    prefix,
+				Entries: append(folders, files...),
+			},
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "move-folder",
+		Method:      http.MethodPost,
+		Path:        "/folders/{bucket}/move",
+		Summary:     "Move a folder",
+		Description: "Move every object under one folder to another folder in the same bucket",
+	}, func(ctx context.Context, input *struct {
+		Bucket string `path:"bucket" doc:"MinIO bucket name"`
+		Body   FolderMoveRequest
+	}) (*struct {
+		Body FolderResponse
+	}, error) {
+		if err := checkBucketAccess(input.Bucket); err != nil {
+			return nil, err
+		}
+
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+
+		from := folderPrefix(input.Body.From)
+		to := folderPrefix(input.Body.To)
+		if from == "" || to == "" {
+			return nil, huma.Error400BadRequest("Source and destination folders must not be empty")
+		}
+		if strings.HasPrefix(to, from) || strings.HasPrefix(from, trashPrefix) || strings.HasPrefix(to, trashPrefix) {
+			return nil, huma.Error400BadRequest(fmt.Sprintf("Cannot move folder %s to %s", from, to))
+		}
+
+		// Refuse to merge into an existing folder
+		for obj := range client.ListObjects(ctx, input.Bucket, minio.ListObjectsOptions{Prefix: to, MaxKeys: 1}) {
+			if obj.Err != nil {
+				if isNotFound(obj.Err) {
+					return nil, errBucketNotFound(input.Bucket)
+				}
+				return nil, huma.Error500InternalServerError("Failed to check destination folder", obj.Err)
+			}
+			return nil, huma.Error409Conflict(fmt.Sprintf("Folder %s already exists in bucket %s", to, input.Bucket))
+		}
+
+		moved, err := moveFolder(ctx, input.Bucket, from, to)
+		if err != nil {
+			if moved > 0 {
+				return nil, huma.Error500InternalServerError(fmt.Sprintf("Failed to move folder; %d objects could not be moved back from %s", moved, to), err)
+			}
+			return nil, huma.Error500InternalServerError("Failed to move folder; no objects were moved", err)
+		}
+		if moved == 0 {
+			return nil, huma.Error404NotFound(fmt.Sprintf("Folder %s not found in bucket %s", from, input.Bucket))
+		}
+
+		return &struct {
+			Body FolderResponse
+		}{
+			Body: FolderResponse{
+				Success: true,
+				Message: fmt.Sprintf("Folder %s moved to %s in bucket %s", from, to, input.Bucket),
+				Path:    to,
+				Moved:   moved,
+			},
+		}, nil
+	})
+}
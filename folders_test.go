@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestFolderPrefix(t *testing.T) {
+	cases := map[string]string{
+		"":              "",
+		"/":             "",
+		"reports":       "reports/",
+		"/reports/2024": "reports/2024/",
+		"reports/2024/": "reports/2024/",
+	}
+	for in, want := range cases {
+		if got := folderPrefix(in); got != want {
+			t.Errorf("folderPrefix(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestFolderEndpointsWithoutClient(t *testing.T) {
+	// Initialize config for testing
+	viper.Reset()
+	initConfig()
+
+	// Ensure MinIO client is not initialized
+	services.SetMinIO(nil)
+
+	// Create test router and API
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+
+	// Register folder endpoints
+	registerFolderEndpoints(api)
+
+	createBody, _ := json.Marshal(FolderCreateRequest{Path: "reports"})
+	moveBody, _ := json.Marshal(FolderMoveRequest{From: "reports", To: "archive"})
+	requests := []struct {
+		method string
+		path   string
+		body   []byte
+	}{
+		{"POST", "/folders/test-bucket", createBody},
+		{"GET", "/folders/test-bucket?path=reports", nil},
+		{"POST", "/folders/test-bucket/move", moveBody},
+	}
+
+	for _, r := range requests {
+		req := httptest.NewRequest(r.method, r.path, bytes.NewBuffer(r.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		// Should return 503 Service Unavailable since MinIO client is not configured
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s %s: expected status code 503, got %d", r.method, r.path, w.Code)
+		}
+	}
+}
+
+func TestFolderEndpoints(t *testing.T) {
+	viper.Reset()
+	initConfig()
+
+	objects := map[string]string{
+		"docs/reports/":             "",
+		"docs/reports/public.txt":   "public",
+		"docs/reports/private.txt":  "private",
+		"docs/reports/2024/q1.txt":  "q1",
+		"docs/reports/2024/q2.txt":  "q2",
+		"docs/.trash/reports/x.txt": "trashed",
+		"app-system/acls/docs.json": `{
+			"reports/private.txt": {"visibility": "private", "owner": "acme"},
+			"reports/2024/q1.txt": {"visibility": "private", "owner": "acme"}
+		}`,
+	}
+	fake := fakeS3(objects)
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// q2.txt cannot be removed, so moving its folder fails halfway
+		if r.Method == http.MethodDelete && r.URL.Path == "/docs/reports/2024/q2.txt" {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`))
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	config.Storage.Tenants = []StorageTenantConfig{
+		{Name: "acme", Token: "acme-token", Bucket: "acme"},
+		{Name: "globex", Token: "globex-token", Bucket: "globex"},
+	}
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerFolderEndpoints(api)
+
+	list := func(query, token string) []string {
+		req := httptest.NewRequest(http.MethodGet, "/folders/docs?"+query, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /folders/docs?%s: expected 200, got %d: %s", query, w.Code, w.Body.String())
+		}
+		var resp FolderListResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		paths := []string{}
+		for _, e := range resp.Entries {
+			paths = append(paths, e.Path)
+		}
+		return paths
+	}
+
+	if got := strings.Join(list("path=reports", "acme-token"), ","); got != "reports/2024/,reports/private.txt,reports/public.txt" {
+		t.Errorf("Expected the owner to see the subfolder and both files, got %s", got)
+	}
+	if got := strings.Join(list("path=reports", "globex-token"), ","); got != "reports/2024/,reports/public.txt" {
+		t.Errorf("Expected another tenant not to see the private file, got %s", got)
+	}
+	if got := strings.Join(list("path=reports&recursive=true", ""), ","); got != "reports/2024/q2.txt,reports/public.txt" {
+		t.Errorf("Expected an anonymous recursive listing to leave out private files, got %s", got)
+	}
+
+	move := func(from, to string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(FolderMoveRequest{From: from, To: to})
+		req := httptest.NewRequest(http.MethodPost, "/folders/docs/move", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// q1.txt is moved before q2.txt fails, then moved back with its ACL
+	if w := move("reports/2024", "archive/2024"); w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "no objects were moved") {
+		t.Errorf("Expected the failed move to be rolled back, got %d: %s", w.Code, w.Body.String())
+	}
+	for key, want := range map[string]string{"docs/reports/2024/q1.txt": "q1", "docs/reports/2024/q2.txt": "q2"} {
+		if objects[key] != want {
+			t.Errorf("Expected %s to be restored, got %q", key, objects[key])
+		}
+	}
+	if _, ok := objects["docs/archive/2024/q1.txt"]; ok {
+		t.Error("Expected no objects to be left under the destination")
+	}
+	var acls map[string]ObjectACL
+	json.Unmarshal([]byte(objects["app-system/acls/docs.json"]), &acls)
+	if acls["reports/2024/q1.txt"].Owner != "acme" {
+		t.Errorf("Expected the ACL to move back with the object, got %+v", acls)
+	}
+
+	if w := move("reports", "reports/old"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 when moving a folder into itself, got %d", w.Code)
+	}
+	if w := move("missing", "elsewhere"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing folder, got %d", w.Code)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+	"github.com/spf13/viper"
+)
+
+// defaultGallery is seeded when gallery.file is not set.
+//
+//go:embed gallery.yaml
+var defaultGallery []byte
+
+const (
+	templatesPrefix = "templates/"
+	agentsPrefix    = "agents/"
+	// gallerySeedKey records which gallery items have been installed.
+	gallerySeedKey = "gallery/seeded.json"
+)
+
+// GalleryConfig configures the template gallery seeded on first boot.
+type GalleryConfig struct {
+	Seed   bool   `mapstructure:"seed" doc:"Install the gallery's templates, agents and collections at startup"`
+	File   string `mapstructure:"file" doc:"YAML or JSON gallery to seed; empty uses the built-in gallery"`
+	Bucket string `mapstructure:"bucket" doc:"Bucket the documents of example collections are uploaded to"`
+}
+
+func (c GalleryConfig) Validate() error {
+	if c.Seed && c.Bucket == "" {
+		return errors.New("gallery.bucket must not be empty")
+	}
+	return nil
+}
+
+type PromptTemplate struct {
+	Name        string `json:"name" mapstructure:"name" doc:"Template name"`
+	Description string `json:"description,omitempty" mapstructure:"description" doc:"What the template is for"`
+	System      string `json:"system,omitempty" mapstructure:"system" doc:"System prompt"`
+	Prompt      string `json:"prompt" mapstructure:"prompt" doc:"User prompt with {{variable}} placeholders"`
+}
+
+type Agent struct {
+	Name        string   `json:"name" mapstructure:"name" doc:"Agent name"`
+	Description string   `json:"description,omitempty" mapstructure:"description" doc:"What the agent does"`
+	Model       string   `json:"model,omitempty" mapstructure:"model" doc:"OpenAI model the agent uses"`
+	System      string   `json:"system" mapstructure:"system" doc:"System prompt that defines the agent"`
+	Collections []string `json:"collections,omitempty" mapstructure:"collections" doc:"Collections the agent draws on"`
+}
+
+type GalleryDocument struct {
+	Name    string `mapstructure:"name"`
+	Content string `mapstructure:"content"`
+}
+
+type GalleryCollection struct {
+	Name        string            `mapstructure:"name"`
+	Description string            `mapstructure:"description"`
+	Documents   []GalleryDocument `mapstructure:"documents"`
+}
+
+// Gallery is the layout of a gallery file.
+type Gallery struct {
+	Templates   []PromptTemplate    `mapstructure:"templates"`
+	Agents      []Agent             `mapstructure:"agents"`
+	Collections []GalleryCollection `mapstructure:"collections"`
+}
+
+// gallerySeed lists the gallery items installed so far. Items are only
+// installed once, so deleting a seeded item does not bring it back.
+type gallerySeed struct {
+	Items     []string  `json:"items"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// galleryNamePattern is the collection naming rule, which gallery templates
+// and agents share.
+var galleryNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// loadGallery reads the configured gallery, or the built-in one.
+func loadGallery(c GalleryConfig) (*Gallery, error) {
+	v := viper.New()
+	if c.File != "" {
+		v.SetConfigFile(c.File)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, err
+		}
+	} else {
+		v.SetConfigType("yaml")
+		if err := v.ReadConfig(bytes.NewReader(defaultGallery)); err != nil {
+			return nil, err
+		}
+	}
+	var g Gallery
+	if err := v.Unmarshal(&g); err != nil {
+		return nil, err
+	}
+	for _, t := range g.Templates {
+		if !galleryNamePattern.MatchString(t.Name) || t.Prompt == "" {
+			return nil, fmt.Errorf("template %q needs a valid name and a prompt", t.Name)
+		}
+	}
+	for _, a := range g.Agents {
+		if !galleryNamePattern.MatchString(a.Name) || a.System == "" {
+			return nil, fmt.Errorf("agent %q needs a valid name and a system prompt", a.Name)
+		}
+	}
+	for _, col := range g.Collections {
+		if !galleryNamePattern.MatchString(col.Name) {
+			return nil, fmt.Errorf("collection %q has an invalid name", col.Name)
+		}
+	}
+	return &g, nil
+}
+
+// putIfAbsent stores v unless the key already exists, so seeding never
+// overwrites what users created under the same name.
+func putIfAbsent(ctx context.Context, key string, v any) (bool, error) {
+	client, err := services.MinIO()
+	if err != nil {
+		return false, err
+	}
+	if _, err := client.StatObject(ctx, config.MinIO.SystemBucket, key, minio.StatObjectOptions{}); err == nil {
+		return false, nil
+	} else if !isNotFound(err) {
+		return false, err
+	}
+	return true, putJSON(ctx, config.MinIO.SystemBucket, key, v)
+}
+
+// seedGallery installs the gallery items that have not been installed yet
+// and returns how many it created.
+func seedGallery(ctx context.Context, g *Gallery) (int, error) {
+	if err := ensureBucket(ctx, config.MinIO.SystemBucket); err != nil {
+		return 0, err
+	}
+	var seed gallerySeed
+	if err := getJSON(ctx, config.MinIO.SystemBucket, gallerySeedKey, &seed); err != nil && !isNotFound(err) {
+		return 0, err
+	}
+	done := map[string]bool{}
+	for _, item := range seed.Items {
+		done[item] = true
+	}
+
+	created := 0
+	install := func(item string, fn func() (bool, error)) error {
+		if done[item] {
+			return nil
+		}
+		ok, err := fn()
+		if err != nil {
+			return fmt.Errorf("%s: %w", item, err)
+		}
+		if ok {
+			created++
+		}
+		done[item] = true
+		seed.Items = append(seed.Items, item)
+		return nil
+	}
+
+	for _, t := range g.Templates {
+		if err := install("template:"+t.Name, func() (bool, error) {
+			return putIfAbsent(ctx, templatesPrefix+t.Name+".json", t)
+		}); err != nil {
+			return created, err
+		}
+	}
+	for _, a := range g.Agents {
+		if err := install("agent:"+a.Name, func() (bool, error) {
+			return putIfAbsent(ctx, agentsPrefix+a.Name+".json", a)
+		}); err != nil {
+			return created, err
+		}
+	}
+	for _, col := range g.Collections {
+		if err := install("collection:"+col.Name, func() (bool, error) {
+			return seedCollection(ctx, col)
+		}); err != nil {
+			return created, err
+		}
+	}
+
+	seed.UpdatedAt = time.Now().UTC()
+	return created, putJSON(ctx, config.MinIO.SystemBucket, gallerySeedKey, seed)
+}
+
+// seedCollection uploads an example collection's documents and creates the
+// collection pointing at them. Documents are not uploaded over existing
+// objects.
+func seedCollection(ctx context.Context, col GalleryCollection) (bool, error) {
+	client, err := services.MinIO()
+	if err != nil {
+		return false, err
+	}
+	collectionsMu.Lock()
+	defer collectionsMu.Unlock()
+
+	if _, err := client.StatObject(ctx, config.MinIO.SystemBucket, collectionKey(col.Name), minio.StatObjectOptions{}); err == nil {
+		return false, nil
+	} else if !isNotFound(err) {
+		return false, err
+	}
+	if err := ensureBucket(ctx, config.Gallery.Bucket); err != nil {
+		return false, err
+	}
+
+	now := time.Now().UTC()
+	c := Collection{Name: col.Name, Description: col.Description, Documents: []CollectionDocument{}, CreatedAt: now, UpdatedAt: now}
+	for _, d := range col.Documents {
+		c.Documents = append(c.Documents, CollectionDocument{Bucket: config.Gallery.Bucket, Name: d.Name})
+		// An object of the same name is the user's and is kept
+		if _, err := client.StatObject(ctx, config.Gallery.Bucket, d.Name, minio.StatObjectOptions{}); err == nil {
+			continue
+		} else if !isNotFound(err) {
+			return false, err
+		}
+		_, err := client.PutObject(ctx, config.Gallery.Bucket, d.Name, strings.NewReader(d.Content), int64(len(d.Content)), minio.PutObjectOptions{
+			ContentType: "text/markdown; charset=utf-8",
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, putJSON(ctx, config.MinIO.SystemBucket, collectionKey(col.Name), c)
+}
+
+// listGalleryItems loads every JSON object under prefix in the system
+// bucket.
+func listGalleryItems[T any](ctx context.Context, prefix string) ([]T, error) {
+	client, err := services.MinIO()
+	if err != nil {
+		return nil, err
+	}
+	items := []T{}
+	for obj := range client.ListObjects(ctx, config.MinIO.SystemBucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			if isNotFound(obj.Err) {
+				return items, nil
+			}
+			return nil, obj.Err
+		}
+		var item T
+		if err := getJSON(ctx, config.MinIO.SystemBucket, obj.Key, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+type TemplateListResponse struct {
+	Templates []PromptTemplate `json:"templates" doc:"All prompt templates"`
+}
+
+type AgentListResponse struct {
+	Agents []Agent `json:"agents" doc:"All agents"`
+}
+
+func registerGalleryEndpoints(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "list-templates",
+		Method:      http.MethodGet,
+		Path:        "/templates",
+		Summary:     "List prompt templates",
+		Description: "List the prompt templates, installed from the template gallery or saved with PUT /templates/{name}",
+	}, func(ctx context.Context, input *struct{}) (*struct {
+		Body TemplateListResponse
+	}, error) {
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+		templates, err := listGalleryItems[PromptTemplate](ctx, templatesPrefix)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to list templates", err)
+		}
+		sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+		return &struct {
+			Body TemplateListResponse
+		}{Body: TemplateListResponse{Templates: templates}}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "list-agents",
+		Method:      http.MethodGet,
+		Path:        "/agents",
+		Summary:     "List agents",
+		Description: "List the agents installed from the template gallery",
+	}, func(ctx context.Context, input *struct{}) (*struct {
+		Body AgentListResponse
+	}, error) {
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+		agents, err := listGalleryItems[Agent](ctx, agentsPrefix)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to list agents", err)
+		}
+		sort.Slice(agents, func(i, j int) bool { return agents[i].Name < agents[j].Name })
+		return &struct {
+			Body AgentListResponse
+		}{Body: AgentListResponse{Agents: agents}}, nil
+	})
+}
+
+// seedDefaultGallery seeds the configured gallery at startup. Failures are
+// logged; the next start retries the items that were not installed.
+func seedDefaultGallery() {
+	g, err := loadGallery(config.Gallery)
+	if err != nil {
+		log.Printf("Failed to load template gallery: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	created, err := seedGallery(ctx, g)
+	if err != nil {
+		log.Printf("Failed to seed template gallery after %d items: %v", created, err)
+		return
+	}
+	if created > 0 {
+		log.Printf("Template gallery installed %d items", created)
+	}
+}
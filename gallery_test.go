@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestLoadGallery(t *testing.T) {
+	g, err := loadGallery(GalleryConfig{})
+	if err != nil {
+		t.Fatalf("Failed to load the built-in gallery: %v", err)
+	}
+	if len(g.Templates) == 0 || len(g.Agents) == 0 || len(g.Collections) == 0 {
+		t.Errorf("Expected the built-in gallery to have templates, agents and collections, got %+v", g)
+	}
+
+	file := filepath.Join(t.TempDir(), "gallery.yaml")
+	os.WriteFile(file, []byte("templates:\n  - name: Bad Name\n    prompt: hi\n"), 0o600)
+	if _, err := loadGallery(GalleryConfig{File: file}); err == nil {
+		t.Error("Expected a template with an invalid name to be rejected")
+	}
+}
+
+func TestSeedGallery(t *testing.T) {
+	viper.Reset()
+	initConfig()
+
+	objects := map[string]string{
+		"app-system/templates/summarize.json": `{"name": "summarize", "prompt": "mine"}`,
+		"examples/getting-started/faq.md":     "My FAQ",
+	}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	g := &Gallery{
+		Templates: []PromptTemplate{{Name: "summarize", Prompt: "Summarize {{document}}"}, {Name: "translate", Prompt: "Translate {{text}}"}},
+		Agents:    []Agent{{Name: "editor", System: "You edit text."}},
+		Collections: []GalleryCollection{{Name: "getting-started", Documents: []GalleryDocument{
+			{Name: "getting-started/welcome.md", Content: "# Welcome"},
+			{Name: "getting-started/faq.md", Content: "# FAQ"},
+		}}},
+	}
+	ctx := context.Background()
+
+	created, err := seedGallery(ctx, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 3 {
+		t.Errorf("Expected 3 items to be created, got %d", created)
+	}
+	if objects["app-system/templates/summarize.json"] != `{"name": "summarize", "prompt": "mine"}` {
+		t.Error("Expected an existing template not to be overwritten")
+	}
+	if objects["examples/getting-started/welcome.md"] != "# Welcome" || !strings.Contains(objects["app-system/collections/getting-started.json"], `"bucket":"examples"`) {
+		t.Errorf("Expected the example collection and its documents to be created, got %v", objects)
+	}
+	if objects["examples/getting-started/faq.md"] != "My FAQ" || !strings.Contains(objects["app-system/collections/getting-started.json"], "faq.md") {
+		t.Errorf("Expected an existing document to be kept and used, got %q", objects["examples/getting-started/faq.md"])
+	}
+
+	delete(objects, "app-system/templates/translate.json")
+	if created, err := seedGallery(ctx, g); err != nil || created != 0 {
+		t.Errorf("Expected seeding to be idempotent and not restore deleted items, got %d, %v", created, err)
+	}
+
+	g.Agents = append(g.Agents, Agent{Name: "researcher", System: "You research."})
+	if created, err := seedGallery(ctx, g); err != nil || created != 1 {
+		t.Errorf("Expected items added to the gallery later to be installed, got %d, %v", created, err)
+	}
+}
@@ -5,13 +5,22 @@ go 1.24.5
 require (
 	github.com/danielgtaylor/huma/v2 v2.10.0
 	github.com/go-chi/chi/v5 v5.0.12
-	github.com/minio/minio-go/v7 v7.0.45
-	github.com/sashabaranov/go-openai v1.16.0
+	github.com/minio/minio-go/v7 v7.0.49
+	github.com/redis/go-redis/v9 v9.7.3
+	github.com/sashabaranov/go-openai v1.17.9
 	github.com/spf13/viper v1.20.1
+	github.com/yuin/goldmark v1.8.6
+	github.com/yuin/goldmark-highlighting/v2 v2.0.0-20230729083705-37449abec8cc
+	golang.org/x/net v0.33.0
+	golang.org/x/sys v0.29.0
 )
 
 require (
+	github.com/alecthomas/chroma/v2 v2.2.0 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/danielgtaylor/casing v1.0.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/dlclark/regexp2 v1.7.0 // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/fsnotify/fsnotify v1.8.0 // indirect
 	github.com/fxamacker/cbor/v2 v2.6.0 // indirect
@@ -40,8 +49,6 @@ require (
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.9.0 // indirect
 	golang.org/x/crypto v0.32.0 // indirect
-	golang.org/x/net v0.33.0 // indirect
-	golang.org/x/sys v0.29.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
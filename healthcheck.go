@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// healthReport is the part of GET /health the healthcheck command reads.
+type healthReport struct {
+	Status   string          `json:"status"`
+	Services map[string]bool `json:"services"`
+	Keys     []KeyStatus     `json:"keys,omitempty"`
+}
+
+// degraded lists why the report does not meet the requirements: the
+// instance's own status, and required services that are not available.
+func (h healthReport) degraded(require []string) []string {
+	var problems []string
+	if h.Status != "healthy" {
+		problems = append(problems, "status is "+h.Status)
+	}
+	for _, k := range h.Keys {
+		if !k.Valid {
+			problems = append(problems, k.Provider+" key is invalid")
+		}
+	}
+	for _, s := range require {
+		if !h.Services[s] {
+			problems = append(problems, s+" is not available")
+		}
+	}
+	return problems
+}
+
+// runHealthcheckCommand queries GET /health of a running instance. It exits
+// with exitUnavailable when the instance cannot be reached and exitDegraded
+// when it reports a problem, so it can serve as a container or CI probe.
+func runHealthcheckCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	flags.SetOutput(out)
+	serverURL := flags.String("url", envOr("APP_URL", "http://localhost:8080"), "URL of a running instance (env APP_URL)")
+	timeout := flags.Duration("timeout", 5*time.Second, "How long to wait for the instance")
+	requireList := flags.String("require", "", "Comma-separated services that must be available, e.g. openai,minio")
+	format := outputFlag(flags, outputTable)
+	if err := parseFlags(flags, args, format); err != nil {
+		return err
+	}
+	var require []string
+	for _, s := range strings.Split(*requireList, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			require = append(require, s)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(*serverURL, "/")+"/health", nil)
+	if err != nil {
+		return usageError("invalid -url: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &exitError{code: exitUnavailable, err: responseError(resp)}
+	}
+	var report healthReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return &exitError{code: exitUnavailable, err: fmt.Errorf("invalid health response: %w", err)}
+	}
+
+	problems := report.degraded(require)
+	if *format == outputJSON {
+		if err := writeJSON(out, struct {
+			healthReport
+			Problems []string `json:"problems"`
+		}{report, append([]string{}, problems...)}); err != nil {
+			return err
+		}
+	} else {
+		tw := newTable(out)
+		fmt.Fprintf(tw, "STATUS\t%s\n", report.Status)
+		services := make([]string, 0, len(report.Services))
+		for s := range report.Services {
+			services = append(services, s)
+		}
+		sort.Strings(services)
+		for _, s := range services {
+			state := "available"
+			if !report.Services[s] {
+				state = "not configured"
+			}
+			fmt.Fprintf(tw, "%s\t%s\n", s, state)
+		}
+		for _, p := range problems {
+			fmt.Fprintf(tw, "PROBLEM\t%s\n", p)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(problems) > 0 {
+		return &exitError{code: exitDegraded, err: errors.New(strings.Join(problems, "; "))}
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+const hlsPlaylist = "index.m3u8"
+
+// hlsSegmentPattern matches the segment names ffmpeg is told to write.
+var hlsSegmentPattern = regexp.MustCompile(`^segment\d{5}\.ts$`)
+
+// hlsVersionPattern matches ETags, which name the package of each content
+// of an object.
+var hlsVersionPattern = regexp.MustCompile(`^[0-9a-f-]{1,64}$`)
+
+// HLSConfig sets how stored audio and video are packaged for playback in
+// browsers.
+type HLSConfig struct {
+	Bucket         string `mapstructure:"bucket" doc:"Bucket packaged playlists and segments are stored in; created on first use"`
+	SegmentSeconds int    `mapstructure:"segment_seconds" doc:"Target length of a segment in seconds"`
+	MaxMediaBytes  int64  `mapstructure:"max_media_bytes" doc:"Largest audio or video object that is packaged"`
+	TimeoutSeconds int    `mapstructure:"timeout_seconds" doc:"Most seconds ffmpeg may take to package one object"`
+}
+
+func (c HLSConfig) Validate() error {
+	if c.Bucket == "" {
+		return errors.New("hls.bucket is required")
+	}
+	if c.SegmentSeconds <= 0 || c.MaxMediaBytes <= 0 || c.TimeoutSeconds <= 0 {
+		return errors.New("hls.segment_seconds, hls.max_media_bytes and hls.timeout_seconds must be positive")
+	}
+	return nil
+}
+
+// mediaExtensions are recognized as audio or video even where the host's
+// MIME table doesn't know them.
+var mediaExtensions = []string{".aac", ".avi", ".flac", ".m4a", ".m4v", ".mkv", ".mov", ".mp3", ".mp4", ".mpeg", ".mpg", ".oga", ".ogg", ".opus", ".wav", ".webm"}
+
+// isMediaObject reports whether an object is audio or video, by its content
+// type or else its extension.
+func isMediaObject(contentType, name string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "" || mediaType == "application/octet-stream" || mediaType == "binary/octet-stream" {
+		ext := strings.ToLower(path.Ext(name))
+		if slices.Contains(mediaExtensions, ext) {
+			return true
+		}
+		mediaType, _, _ = mime.ParseMediaType(mime.TypeByExtension(ext))
+	}
+	return strings.HasPrefix(mediaType, "audio/") || strings.HasPrefix(mediaType, "video/")
+}
+
+// hlsPrefix is where the package of one content of an object is stored.
+func hlsPrefix(bucket, name, version string) string {
+	return bucket + "/" + name + "/" + version + "/"
+}
+
+type hlsJob struct {
+	done chan struct{}
+	err  error
+}
+
+// hlsJobs are the packagings running, so concurrent requests for an object
+// wait for one ffmpeg run instead of starting their own.
+var hlsJobs struct {
+	sync.Mutex
+	running map[string]*hlsJob
+}
+
+// ensureHLSPackage packages an object unless its package is stored already.
+// The playlist is stored last, so a stored playlist means a whole package.
+func ensureHLSPackage(ctx context.Context, bucket, name string, info minio.ObjectInfo) error {
+	store, err := services.MinIO()
+	if err != nil {
+		return err
+	}
+	prefix := hlsPrefix(bucket, name, info.ETag)
+	if _, err := store.StatObject(ctx, config.HLS.Bucket, prefix+hlsPlaylist, minio.StatObjectOptions{}); err == nil {
+		return nil
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	hlsJobs.Lock()
+	job, ok := hlsJobs.running[prefix]
+	if !ok {
+		if hlsJobs.running == nil {
+			hlsJobs.running = map[string]*hlsJob{}
+		}
+		job = &hlsJob{done: make(chan struct{})}
+		hlsJobs.running[prefix] = job
+		// Packaging outlives the request that started it, as others may
+		// be waiting for it
+		go func() {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Duration(config.HLS.TimeoutSeconds)*time.Second)
+			defer cancel()
+			job.err = packageHLS(ctx, bucket, name, info, prefix)
+			hlsJobs.Lock()
+			delete(hlsJobs.running, prefix)
+			hlsJobs.Unlock()
+			close(job.done)
+		}()
+	}
+	hlsJobs.Unlock()
+
+	select {
+	case <-job.done:
+		return job.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// packageHLS downloads an object, cuts it into segments with ffmpeg, which
+// must be installed on the host, and stores the segments and the playlist.
+func packageHLS(ctx context.Context, bucket, name string, info minio.ObjectInfo, prefix string) error {
+	bin, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return err
+	}
+	client, err := services.MinIOReader()
+	if err != nil {
+		return err
+	}
+	store, err := services.MinIO()
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "hls-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	src, err := os.Create(filepath.Join(dir, "source"+path.Ext(name)))
+	if err != nil {
+		return err
+	}
+	opts := minio.GetObjectOptions{}
+	// Package the content the request saw, not a newer one
+	opts.SetMatchETag(info.ETag)
+	obj, err := client.GetObject(ctx, bucket, name, opts)
+	if err == nil {
+		_, err = io.Copy(src, obj)
+		obj.Close()
+	}
+	src.Close()
+	if err != nil {
+		return err
+	}
+
+	// Browsers need H.264 and AAC, so recordings are transcoded; the
+	// optional maps let audio-only files through
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, bin, "-nostdin", "-loglevel", "error", "-i", src.Name(),
+		"-map", "0:v:0?", "-map", "0:a:0?", "-c:v", "libx264", "-preset", "veryfast", "-c:a", "aac",
+		"-f", "hls", "-hls_time", strconv.Itoa(config.HLS.SegmentSeconds), "-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(dir, "segment%05d.ts"), filepath.Join(dir, hlsPlaylist))
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &ffmpegError{err: err, output: strings.TrimSpace(stderr.String())}
+	}
+
+	segments, err := filepath.Glob(filepath.Join(dir, "segment*.ts"))
+	if err != nil {
+		return err
+	}
+	if err := ensureBucket(ctx, config.HLS.Bucket); err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if _, err := store.FPutObject(ctx, config.HLS.Bucket, prefix+filepath.Base(s), s, minio.PutObjectOptions{ContentType: "video/mp2t"}); err != nil {
+			return err
+		}
+	}
+	playlist, err := os.ReadFile(filepath.Join(dir, hlsPlaylist))
+	if err != nil {
+		return err
+	}
+	playlist = versionHLSPlaylist(playlist, info.ETag)
+	_, err = store.PutObject(ctx, config.HLS.Bucket, prefix+hlsPlaylist, bytes.NewReader(playlist), int64(len(playlist)), minio.PutObjectOptions{ContentType: "application/vnd.apple.mpegurl"})
+	return err
+}
+
+// versionHLSPlaylist adds the version to the segment URIs of a playlist, so
+// a player keeps getting the segments of the content it started with even
+// if the object is replaced.
+func versionHLSPlaylist(playlist []byte, version string) []byte {
+	lines := strings.Split(string(playlist), "\n")
+	for i, line := range lines {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines[i] = line + "?v=" + url.QueryEscape(version)
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// ffmpegError is a failed ffmpeg run, usually because the object is not
+// media ffmpeg can read.
+type ffmpegError struct {
+	err    error
+	output string
+}
+
+func (e *ffmpegError) Error() string {
+	return fmt.Sprintf("ffmpeg: %v: %s", e.err, e.output)
+}
+
+func registerHLSEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "get-file-hls",
+		Method:      http.MethodGet,
+		Path:        "/files/{bucket}/{name}/hls/{file}",
+		Summary:     "Stream stored media with HLS",
+		Description: "Serve a stored audio or video object as HTTP Live Streaming, subject to the object's ACL. " +
+			"The object is packaged with ffmpeg on the first request for index.m3u8, which can take a while for long recordings; later requests are served from the hls.bucket",
+	}, func(ctx context.Context, input *struct {
+		Bucket        string `path:"bucket" doc:"MinIO bucket name"`
+		Name          string `path:"name" doc:"Object name (URL-encoded if it contains slashes)"`
+		File          string `path:"file" doc:"index.m3u8, or a segment named in it"`
+		Version       string `query:"v" doc:"Content version of a segment, as set in the playlist"`
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token; needed for objects with a private ACL"`
+	}) (*huma.StreamResponse, error) {
+		client, err := services.MinIOReader()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+		name, err := decodeObjectKey(input.Name)
+		if err != nil {
+			return nil, err
+		}
+		if input.File != hlsPlaylist && !hlsSegmentPattern.MatchString(input.File) {
+			return nil, huma.Error404NotFound(fmt.Sprintf("No HLS file %s", input.File))
+		}
+		if err := checkBucketAccess(input.Bucket); err != nil {
+			return nil, err
+		}
+		if err := checkObjectACL(ctx, input.Bucket, name, objectCaller(input.Authorization)); err != nil {
+			return nil, err
+		}
+
+		version := input.Version
+		if input.File == hlsPlaylist || version == "" {
+			info, err := client.StatObject(ctx, input.Bucket, name, minio.StatObjectOptions{})
+			if err != nil {
+				if isNotFound(err) {
+					return nil, errObjectNotFound(input.Bucket, name)
+				}
+				return nil, huma.Error500InternalServerError("Failed to stat object", err)
+			}
+			if !isMediaObject(info.ContentType, name) {
+				return nil, huma.Error415UnsupportedMediaType(fmt.Sprintf("Object %s is not audio or video", name))
+			}
+			if info.Size > config.HLS.MaxMediaBytes {
+				return nil, huma.NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Object %s is larger than the %d bytes packaged for streaming", name, config.HLS.MaxMediaBytes))
+			}
+			if input.File == hlsPlaylist {
+				err = ensureHLSPackage(ctx, input.Bucket, name, info)
+				var ffErr *ffmpegError
+				switch {
+				case errors.Is(err, exec.ErrNotFound):
+					return nil, huma.Error501NotImplemented("HLS streaming requires ffmpeg to be installed on the server")
+				case errors.As(err, &ffErr):
+					log.Printf("Failed to package %s/%s: %v", input.Bucket, name, err)
+					return nil, huma.Error422UnprocessableEntity(fmt.Sprintf("Object %s cannot be packaged for streaming", name))
+				case err != nil:
+					return nil, huma.Error500InternalServerError("Failed to package the media", err)
+				}
+			}
+			version = info.ETag
+		}
+		if !hlsVersionPattern.MatchString(version) {
+			return nil, huma.Error404NotFound(fmt.Sprintf("No HLS file %s", input.File))
+		}
+
+		obj, stored, _, err := minio.Core{Client: client}.GetObject(ctx, config.HLS.Bucket, hlsPrefix(input.Bucket, name, version)+input.File, minio.GetObjectOptions{})
+		if err != nil {
+			if isNotFound(err) {
+				return nil, huma.Error404NotFound(fmt.Sprintf("No HLS file %s for this version of %s", input.File, name))
+			}
+			return nil, huma.Error500InternalServerError("Failed to get the HLS file", err)
+		}
+		return &huma.StreamResponse{
+			Body: func(hctx huma.Context) {
+				defer obj.Close()
+				hctx.SetHeader("Content-Length", strconv.FormatInt(stored.Size, 10))
+				if input.File == hlsPlaylist {
+					// The playlist follows the object, segments never change
+					hctx.SetHeader("Content-Type", "application/vnd.apple.mpegurl")
+					hctx.SetHeader("Cache-Control", "no-cache")
+				} else {
+					hctx.SetHeader("Content-Type", "video/mp2t")
+					hctx.SetHeader("Cache-Control", "private, max-age=31536000, immutable")
+				}
+				if _, err := io.Copy(hctx.BodyWriter(), obj); err != nil {
+					log.Printf("Failed to stream %s of %s/%s: %v", input.File, input.Bucket, name, err)
+				}
+			},
+		}, nil
+	})
+}
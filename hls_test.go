@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+// fakeFFmpeg is a shell script standing in for ffmpeg: it writes two
+// segments and a playlist, fails for input containing "broken" and counts
+// its runs in the file runs.
+const fakeFFmpeg = `#!/bin/sh
+echo run >> "$(dirname "$0")/runs"
+while [ $# -gt 1 ]; do
+	case "$1" in
+	-i) input="$2" ;;
+	-hls_segment_filename) pattern="$2" ;;
+	esac
+	shift
+done
+if grep -q broken "$input"; then
+	echo "Invalid data found when processing input" >&2
+	exit 1
+fi
+dir=$(dirname "$pattern")
+printf seg0 > "$dir/segment00000.ts"
+printf seg1 > "$dir/segment00001.ts"
+printf '#EXTM3U\n#EXTINF:6.0,\nsegment00000.ts\n#EXTINF:2.5,\nsegment00001.ts\n#EXT-X-ENDLIST\n' > "$1"
+`
+
+func TestHLSEndpoint(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ffmpeg is a shell script")
+	}
+	viper.Reset()
+	initConfig()
+
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte(fakeFFmpeg), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	objects := map[string]string{
+		"media/meeting.webm": "recording",
+		"media/broken.mp4":   "broken",
+		"media/notes.txt":    "text",
+	}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerHLSEndpoint(api)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	// Concurrent first requests share one packaging
+	var wg sync.WaitGroup
+	codes := make([]int, 3)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = get("/files/media/meeting.webm/hls/index.m3u8").Code
+		}()
+	}
+	wg.Wait()
+	w := get("/files/media/meeting.webm/hls/index.m3u8")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/vnd.apple.mpegurl" {
+		t.Fatalf("Expected a playlist, got %d (%v): %s", w.Code, codes, w.Body.String())
+	}
+	runs, _ := os.ReadFile(filepath.Join(bin, "runs"))
+	if n := strings.Count(string(runs), "run"); n != 1 {
+		t.Errorf("Expected one ffmpeg run, got %d", n)
+	}
+	var segment string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, "segment00001.ts?v=") {
+			segment = line
+		}
+	}
+	if segment == "" {
+		t.Fatalf("Expected versioned segment URIs, got %s", w.Body.String())
+	}
+
+	w = get("/files/media/meeting.webm/hls/" + segment)
+	if w.Code != http.StatusOK || w.Body.String() != "seg1" || w.Header().Get("Content-Type") != "video/mp2t" || !strings.Contains(w.Header().Get("Cache-Control"), "immutable") {
+		t.Errorf("Expected the segment, got %d %v: %q", w.Code, w.Header(), w.Body.String())
+	}
+	// Replacing the recording leaves the segments of the old version
+	objects["media/meeting.webm"] = "new recording"
+	if w := get("/files/media/meeting.webm/hls/" + segment); w.Code != http.StatusOK || w.Body.String() != "seg1" {
+		t.Errorf("Expected the old version's segment, got %d", w.Code)
+	}
+
+	for path, code := range map[string]int{
+		"/files/media/meeting.webm/hls/segment00009.ts?v=0123": http.StatusNotFound,
+		"/files/media/meeting.webm/hls/../../secret":           http.StatusNotFound,
+		"/files/media/meeting.webm/hls/source.webm":            http.StatusNotFound,
+		"/files/media/missing.mp4/hls/index.m3u8":              http.StatusNotFound,
+		"/files/media/notes.txt/hls/index.m3u8":                http.StatusUnsupportedMediaType,
+		"/files/media/broken.mp4/hls/index.m3u8":               http.StatusUnprocessableEntity,
+	} {
+		if w := get(path); w.Code != code {
+			t.Errorf("Expected %d for %s, got %d: %s", code, path, w.Code, w.Body.String())
+		}
+	}
+
+	config.HLS.MaxMediaBytes = 4
+	if w := get("/files/media/meeting.webm/hls/index.m3u8"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected a too large recording to be refused, got %d", w.Code)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+	"github.com/sashabaranov/go-openai"
+)
+
+// imageModel is the model the OpenAI client generates images with.
+const imageModel = "dall-e-2"
+
+// ImagesConfig sets where generated images are stored.
+type ImagesConfig struct {
+	Bucket      string `mapstructure:"bucket" doc:"Bucket generated images are stored in; created on first use"`
+	LinkMinutes int    `mapstructure:"link_minutes" doc:"Minutes the returned download link stays valid"`
+}
+
+func (c ImagesConfig) Validate() error {
+	if c.Bucket == "" {
+		return errors.New("images.bucket is required")
+	}
+	// S3 signs links for at most a week
+	if c.LinkMinutes <= 0 || c.LinkMinutes > 7*24*60 {
+		return errors.New("images.link_minutes must be between 1 and 10080")
+	}
+	return nil
+}
+
+type ImageGenerationRequest struct {
+	Prompt string `json:"prompt" minLength:"1" maxLength:"1000" doc:"Description of the image"`
+	Size   string `json:"size,omitempty" enum:"256x256,512x512,1024x1024" default:"1024x1024" doc:"Width and height in pixels"`
+}
+
+type ImageGenerationResponse struct {
+	Bucket     string     `json:"bucket" doc:"MinIO bucket the image is stored in"`
+	Key        string     `json:"key" doc:"Object name of the PNG"`
+	Size       int64      `json:"size" doc:"Stored size in bytes"`
+	URL        string     `json:"url" doc:"Presigned download link"`
+	ExpiresAt  time.Time  `json:"expires_at" doc:"When the download link expires"`
+	Provenance Provenance `json:"provenance" doc:"How the image was generated, also stored with the object"`
+}
+
+// generateImage asks OpenAI for one PNG.
+func generateImage(ctx context.Context, client *openai.Client, req ImageGenerationRequest) ([]byte, error) {
+	resp, err := client.CreateImage(ctx, openai.ImageRequest{
+		Prompt:         req.Prompt,
+		N:              1,
+		Size:           req.Size,
+		ResponseFormat: openai.CreateImageResponseFormatB64JSON,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) == 0 {
+		return nil, errors.New("OpenAI returned no image")
+	}
+	return base64.StdEncoding.DecodeString(resp.Data[0].B64JSON)
+}
+
+func registerImageEndpoint(api huma.API) {
+	huma.Register(api, withRoutePolicy(huma.Operation{
+		OperationID:   "generate-image",
+		Method:        http.MethodPost,
+		Path:          "/images",
+		Summary:       "Generate an image",
+		Description:   "Generate a PNG from a prompt with DALL-E, store it in the images bucket and return its key and a presigned download link",
+		DefaultStatus: http.StatusCreated,
+	}, RoutePolicy{Timeout: 2 * time.Minute, RateClass: "generation", Budgeted: true}), func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer tenant token; the image is then private to the tenant"`
+		Body          ImageGenerationRequest
+	}) (*struct {
+		Body ImageGenerationResponse
+	}, error) {
+		if err := requireTermsAccepted(ctx, input.Authorization); err != nil {
+			return nil, err
+		}
+		ai, err := openAIClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		store, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := policyModel(ctx, imageModel); err != nil {
+			return nil, err
+		}
+
+		png, err := generateImage(ctx, ai, input.Body)
+		logPrompt(ctx, "image", input.Body, "", err)
+		if err != nil {
+			return nil, openAIError(ctx, "Failed to generate the image", err)
+		}
+
+		now := time.Now().UTC()
+		bucket, key := config.Images.Bucket, now.Format("2006/01/02/")+randomHex(16)+".png"
+		provenance := Provenance{
+			Generator:    "generate-image",
+			Model:        imageModel,
+			PromptSHA256: promptSHA256(input.Body.Prompt),
+			GeneratedAt:  now.Truncate(time.Second),
+		}
+		if err := ensureBucket(ctx, bucket); err != nil {
+			return nil, huma.Error500InternalServerError(fmt.Sprintf("Failed to create bucket %s", bucket), err)
+		}
+		info, err := store.PutObject(ctx, bucket, key, bytes.NewReader(png), int64(len(png)), minio.PutObjectOptions{
+			ContentType:  "image/png",
+			UserMetadata: provenance.metadata(),
+		})
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to store the image", err)
+		}
+		usage.recordUpload(now, info.Size)
+
+		// Record the tenant as owner, so the image counts as its data for
+		// takeout and erasure
+		if t, ok := storageTenant(input.Authorization); ok {
+			err := updateACLs(ctx, bucket, func(acls map[string]ObjectACL) bool {
+				acls[key] = ObjectACL{Visibility: aclPrivate, Owner: t.Name, UpdatedAt: now}
+				return true
+			})
+			if err != nil {
+				return nil, huma.Error500InternalServerError("Failed to save the image's ACL", err)
+			}
+		}
+
+		expiry := time.Duration(config.Images.LinkMinutes) * time.Minute
+		link, err := store.PresignedGetObject(ctx, bucket, key, expiry, nil)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to sign the download link", err)
+		}
+		return &struct {
+			Body ImageGenerationResponse
+		}{Body: ImageGenerationResponse{
+			Bucket:     bucket,
+			Key:        key,
+			Size:       info.Size,
+			URL:        link.String(),
+			ExpiresAt:  now.Add(expiry),
+			Provenance: provenance,
+		}}, nil
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+func TestGenerateImage(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Storage.Tenants = []StorageTenantConfig{{Name: "acme", Token: "acme-token", Bucket: "tenants"}}
+
+	png := "\x89PNG\r\n\x1a\nfake image"
+	var sent openai.ImageRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/images/generations" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&sent)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(openai.ImageResponse{Data: []openai.ImageResponseDataInner{{B64JSON: base64.StdEncoding.EncodeToString([]byte(png))}}})
+	}))
+	defer server.Close()
+	services.SetOpenAI(newTestOpenAIClient(server.URL))
+	defer services.SetOpenAI(nil)
+
+	objects := map[string]string{}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerImageEndpoint(api)
+	registerProvenanceEndpoint(api)
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := call("POST", "/images", "acme-token", `{"prompt": "a gopher on a bike", "size": "256x256"}`)
+	var resp ImageGenerationResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected the image to be created, got %d: %s", w.Code, w.Body.String())
+	}
+	if sent.Prompt != "a gopher on a bike" || sent.Size != "256x256" || sent.ResponseFormat != openai.CreateImageResponseFormatB64JSON {
+		t.Errorf("Expected the prompt and size to be sent, got %+v", sent)
+	}
+	if resp.Bucket != "images" || !strings.HasSuffix(resp.Key, ".png") || objects["images/"+resp.Key] != png {
+		t.Errorf("Expected the PNG to be stored under the returned key, got %+v", resp)
+	}
+	if !strings.HasPrefix(resp.URL, s3.URL+"/images/"+resp.Key) || resp.ExpiresAt.IsZero() {
+		t.Errorf("Expected a download link, got %s", resp.URL)
+	}
+	if resp.Provenance.Model != imageModel || resp.Provenance.PromptSHA256 != promptSHA256("a gopher on a bike") {
+		t.Errorf("Expected the provenance, got %+v", resp.Provenance)
+	}
+
+	// The image belongs to the tenant
+	acls, err := loadACLs(t.Context(), "images")
+	if err != nil || acls[resp.Key].Owner != "acme" || acls[resp.Key].Visibility != aclPrivate {
+		t.Errorf("Expected the image to be private to acme, got %+v, %v", acls, err)
+	}
+	path := "/files/images/" + strings.ReplaceAll(resp.Key, "/", "%2F") + "/provenance"
+	if w := call("GET", path, "", ""); w.Code < 400 {
+		t.Errorf("Expected other callers to be refused, got %d", w.Code)
+	}
+	if w := call("GET", path, "acme-token", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"generator":"generate-image"`) {
+		t.Errorf("Expected the stored provenance, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := call("POST", "/images", "", `{"prompt": "x", "size": "640x480"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected an unsupported size to be rejected, got %d", w.Code)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+type IngestWebhookRequest struct {
+	Name        string `json:"name" minLength:"1" maxLength:"1024" doc:"Object name to store the document under, relative to the source's folder"`
+	Content     string `json:"content,omitempty" doc:"Document content; either content or url is required"`
+	URL         string `json:"url,omitempty" format:"uri" doc:"URL to fetch the document from; either content or url is required"`
+	ContentType string `json:"content_type,omitempty" doc:"Content type of the document; defaults to text/plain for content and the fetched type for url"`
+	Collection  string `json:"collection,omitempty" doc:"Collection to add the document to"`
+}
+
+type IngestWebhookResponse struct {
+	Source string `json:"source" doc:"Source the token belongs to"`
+	Bucket string `json:"bucket" doc:"Bucket the document was stored in"`
+	Name   string `json:"name" doc:"Object name of the stored document"`
+	Size   int64  `json:"size" doc:"Stored size in bytes"`
+}
+
+// ingestSourceForToken returns the webhook source a bearer token belongs to.
+func ingestSourceForToken(authorization string) (string, bool) {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	for source, want := range config.Auth.IngestTokens {
+		if want != "" && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 {
+			return source, true
+		}
+	}
+	return "", false
+}
+
+// ingestKey places a document under its source's folder, rejecting names
+// that would escape it.
+func ingestKey(source, name string) (string, error) {
+	clean := path.Clean("/" + name)
+	if clean == "/" {
+		return "", huma.Error422UnprocessableEntity("Document name must not be empty")
+	}
+	return source + clean, nil
+}
+
+func registerIngestWebhookEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID:   "ingest-webhook",
+		Method:        http.MethodPost,
+		Path:          "/ingest/webhook",
+		Summary:       "Ingest a document from a webhook",
+		Description:   "Store a document pushed by an external system (CMS, ticketing, ...) authenticated with a per-source bearer token. The document is sent inline or fetched from a URL",
+		DefaultStatus: http.StatusCreated,
+	}, func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer token of the ingesting source"`
+		Body          IngestWebhookRequest
+	}) (*struct {
+		Body IngestWebhookResponse
+	}, error) {
+		source, ok := ingestSourceForToken(input.Authorization)
+		if !ok {
+			return nil, huma.Error401Unauthorized("Invalid or missing ingest token")
+		}
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+
+		doc := input.Body
+		if (doc.Content == "") == (doc.URL == "") {
+			return nil, huma.Error422UnprocessableEntity("Exactly one of content or url is required")
+		}
+		key, err := ingestKey(source, doc.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		var body io.Reader = strings.NewReader(doc.Content)
+		size := int64(len(doc.Content))
+		contentType := doc.ContentType
+		if doc.URL != "" {
+			resp, err := httpGet(ctx, doc.URL)
+			if err != nil {
+				return nil, huma.Error502BadGateway("Failed to fetch document URL", err)
+			}
+			defer resp.Body.Close()
+			body, size = io.LimitReader(resp.Body, config.Limits.MaxIngestURLBytes), -1
+			if contentType == "" {
+				contentType = resp.Header.Get("Content-Type")
+			}
+		}
+		if contentType == "" {
+			contentType = "text/plain"
+		}
+
+		if err := ensureBucket(ctx, config.MinIO.IngestBucket); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to create ingest bucket", err)
+		}
+		info, err := client.PutObject(ctx, config.MinIO.IngestBucket, key, body, size, minio.PutObjectOptions{
+			ContentType:  contentType,
+			UserMetadata: map[string]string{"Source": source},
+		})
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to store document", err)
+		}
+		usage.recordUpload(time.Now(), info.Size)
+
+		if doc.Collection != "" {
+			stored := CollectionDocument{Bucket: config.MinIO.IngestBucket, Name: key}
+			_, err := updateCollection(ctx, doc.Collection, func(c *Collection) error {
+				if !c.hasDocument(stored) {
+					c.Documents = append(c.Documents, stored)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return &struct {
+			Body IngestWebhookResponse
+		}{
+			Body: IngestWebhookResponse{
+				Source: source,
+				Bucket: config.MinIO.IngestBucket,
+				Name:   key,
+				Size:   info.Size,
+			},
+		}, nil
+	})
+}
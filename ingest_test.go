@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestIngestKey(t *testing.T) {
+	key, err := ingestKey("cms", "../../etc/passwd")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if key != "cms/etc/passwd" {
+		t.Errorf("Expected name to stay inside the source folder, got %s", key)
+	}
+
+	if _, err := ingestKey("cms", "/"); err == nil {
+		t.Error("Expected error for empty name")
+	}
+}
+
+func TestIngestWebhookEndpoint(t *testing.T) {
+	// Initialize config for testing
+	viper.Reset()
+	initConfig()
+	config.Auth.IngestTokens = map[string]string{"cms": "cms-token"}
+
+	// Ensure MinIO client is not initialized
+	services.SetMinIO(nil)
+
+	// Create test router and API
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+
+	// Register ingest endpoint
+	registerIngestWebhookEndpoint(api)
+
+	jsonBody, _ := json.Marshal(IngestWebhookRequest{Name: "page.md", Content: "Hello"})
+	cases := []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong-token", http.StatusUnauthorized},
+		// Valid token, but MinIO client is not configured
+		{"cms-token", http.StatusServiceUnavailable},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/ingest/webhook", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != c.want {
+			t.Errorf("Token %q: expected status code %d, got %d", c.token, c.want, w.Code)
+		}
+	}
+}
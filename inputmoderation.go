@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/sashabaranov/go-openai"
+)
+
+// InputModerationConfig checks chat messages before they reach the model.
+type InputModerationConfig struct {
+	Enabled bool `mapstructure:"enabled" doc:"Check every chat message with the OpenAI moderation API and refuse flagged ones"`
+}
+
+// errInputFlagged refuses a message with the categories it was flagged for
+// as details.
+func errInputFlagged(categories []string) error {
+	details := make([]error, len(categories))
+	for i, c := range categories {
+		details[i] = &huma.ErrorDetail{Message: c, Location: "body.message", Value: c}
+	}
+	return codedError(http.StatusUnprocessableEntity, "ERR_INPUT_FLAGGED", "The message was flagged by moderation: "+strings.Join(categories, ", "), details...)
+}
+
+// moderateInput checks a chat message with the moderation API when input
+// moderation is enabled, and refuses it if flagged.
+func moderateInput(ctx context.Context, provider ChatProvider, operation, message string) error {
+	if !config.InputModeration.Enabled || message == "" {
+		return nil
+	}
+	resp, err := provider.Moderations(ctx, openai.ModerationRequest{Input: message})
+	if err != nil {
+		return openAIError(ctx, "Input moderation failed", err)
+	}
+	for _, r := range resp.Results {
+		if r.Flagged {
+			categories := flaggedCategories(r)
+			audit(ctx, AuditEvent{Action: "input_moderation.flagged", Operation: operation, Detail: strings.Join(categories, ",")})
+			return errInputFlagged(categories)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+func TestInputModeration(t *testing.T) {
+	var moderated []string
+	completions := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/moderations") {
+			var req openai.ModerationRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			moderated = append(moderated, req.Input)
+			flagged := strings.Contains(req.Input, "attack")
+			json.NewEncoder(w).Encode(openai.ModerationResponse{Results: []openai.Result{{
+				Flagged:    flagged,
+				Categories: openai.ResultCategories{Violence: flagged, ViolenceGraphic: flagged},
+			}}})
+			return
+		}
+		completions++
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "Sure"}}}})
+	}))
+	defer srv.Close()
+	services.SetOpenAI(newTestOpenAIClient(srv.URL))
+	defer services.SetOpenAI(nil)
+
+	viper.Reset()
+	initConfig()
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerChatEndpoint(api)
+	registerChatStreamEndpoint(api)
+	call := func(path, message string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(ChatRequest{Message: message})
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := call("/chat", "Plan an attack"); w.Code != http.StatusOK || len(moderated) != 0 {
+		t.Errorf("Expected no moderation while disabled, got %d and %v", w.Code, moderated)
+	}
+
+	config.InputModeration.Enabled = true
+	completions = 0
+	if w := call("/chat", "Plan a party"); w.Code != http.StatusOK || len(moderated) != 1 || completions != 1 {
+		t.Errorf("Expected a clean message to pass, got %d and %v", w.Code, moderated)
+	}
+	for _, path := range []string{"/chat", "/chat/stream"} {
+		w := call(path, "Plan an attack")
+		var problem APIError
+		json.Unmarshal(w.Body.Bytes(), &problem)
+		if w.Code != http.StatusUnprocessableEntity || problem.Code != "ERR_INPUT_FLAGGED" || len(problem.Errors) != 2 || problem.Errors[0].Value != "violence" || problem.Errors[1].Value != "violence/graphic" {
+			t.Errorf("Expected %s to refuse the message with its categories, got %d: %s", path, w.Code, w.Body.String())
+		}
+	}
+	if completions != 1 {
+		t.Errorf("Expected flagged messages not to reach the model, got %d completions", completions)
+	}
+}
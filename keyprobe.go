@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// keyQuotaWarning is the fraction of a rate limit below which a key is
+// reported as running low.
+const keyQuotaWarning = 0.1
+
+type KeyRateLimits struct {
+	LimitRequests     int    `json:"limit_requests" doc:"Requests allowed per window"`
+	RemainingRequests int    `json:"remaining_requests" doc:"Requests left in the current window"`
+	ResetRequests     string `json:"reset_requests,omitempty" doc:"Time until the request window resets"`
+	LimitTokens       int    `json:"limit_tokens" doc:"Tokens allowed per window"`
+	RemainingTokens   int    `json:"remaining_tokens" doc:"Tokens left in the current window"`
+	ResetTokens       string `json:"reset_tokens,omitempty" doc:"Time until the token window resets"`
+}
+
+type KeyStatus struct {
+	Provider   string         `json:"provider" doc:"Provider the key belongs to"`
+	Valid      bool           `json:"valid" doc:"Whether the provider accepted the key"`
+	CheckedAt  time.Time      `json:"checked_at" doc:"When the key was last probed"`
+	RateLimits *KeyRateLimits `json:"rate_limits,omitempty" doc:"Rate limits reported by the provider"`
+	Warnings   []string       `json:"warnings,omitempty" doc:"Problems with the key"`
+	Error      string         `json:"error,omitempty" doc:"Error returned by the probe"`
+}
+
+var keyHealth struct {
+	sync.Mutex
+	statuses map[string]KeyStatus
+}
+
+// probeOpenAIKey sends the cheapest possible completion and reads the
+// key's validity and rate limits from the response.
+func probeOpenAIKey(ctx context.Context, client *openai.Client, now time.Time) KeyStatus {
+	status := KeyStatus{Provider: "openai", CheckedAt: now}
+
+	resp, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:     openai.GPT3Dot5Turbo,
+		MaxTokens: 1,
+		Messages:  []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "ping"}},
+	})
+	if err != nil {
+		status.Error = err.Error()
+		var apiErr *openai.APIError
+		switch code := openAIStatusCode(err); {
+		case code == http.StatusUnauthorized:
+			status.Warnings = append(status.Warnings, "key is invalid or has been revoked")
+		case errors.As(err, &apiErr) && apiErr.Code == "insufficient_quota":
+			status.Valid = true
+			status.Warnings = append(status.Warnings, "account quota is exhausted")
+		case code == http.StatusTooManyRequests:
+			status.Valid = true
+			status.Warnings = append(status.Warnings, "key is being rate limited")
+		default:
+			// Network or server trouble says nothing about the key
+			status.Valid = true
+			status.Warnings = append(status.Warnings, "key could not be checked")
+		}
+		return status
+	}
+	recordTokenUsage(ctx, openai.GPT3Dot5Turbo, resp.Usage)
+
+	status.Valid = true
+	h := resp.GetRateLimitHeaders()
+	if h.LimitRequests > 0 || h.LimitTokens > 0 {
+		status.RateLimits = &KeyRateLimits{
+			LimitRequests:     h.LimitRequests,
+			RemainingRequests: h.RemainingRequests,
+			ResetRequests:     h.ResetRequests.String(),
+			LimitTokens:       h.LimitTokens,
+			RemainingTokens:   h.RemainingTokens,
+			ResetTokens:       h.ResetTokens.String(),
+		}
+		if h.LimitRequests > 0 && float64(h.RemainingRequests) < keyQuotaWarning*float64(h.LimitRequests) {
+			status.Warnings = append(status.Warnings, fmt.Sprintf("only %d of %d requests left in the current window", h.RemainingRequests, h.LimitRequests))
+		}
+		if h.LimitTokens > 0 && float64(h.RemainingTokens) < keyQuotaWarning*float64(h.LimitTokens) {
+			status.Warnings = append(status.Warnings, fmt.Sprintf("only %d of %d tokens left in the current window", h.RemainingTokens, h.LimitTokens))
+		}
+	}
+	return status
+}
+
+// keyStatuses returns the latest probe result for each provider, or nil
+// before the first probe.
+func keyStatuses() []KeyStatus {
+	keyHealth.Lock()
+	defer keyHealth.Unlock()
+	if len(keyHealth.statuses) == 0 {
+		return nil
+	}
+	statuses := make([]KeyStatus, 0, len(keyHealth.statuses))
+	for _, s := range keyHealth.statuses {
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+// recordKeyStatus stores a probe result and reports whether its warnings
+// differ from the previous result.
+func recordKeyStatus(s KeyStatus) bool {
+	keyHealth.Lock()
+	defer keyHealth.Unlock()
+	if keyHealth.statuses == nil {
+		keyHealth.statuses = map[string]KeyStatus{}
+	}
+	prev, seen := keyHealth.statuses[s.Provider]
+	keyHealth.statuses[s.Provider] = s
+	if !seen {
+		return len(s.Warnings) > 0
+	}
+	return fmt.Sprint(prev.Warnings) != fmt.Sprint(s.Warnings)
+}
+
+// startKeyProbe probes the configured keys now and then every interval,
+// alerting whenever a key's warnings change.
+func startKeyProbe(ctx context.Context, interval time.Duration) {
+	probe := func(now time.Time) {
+		client, err := services.OpenAI()
+		if err != nil {
+			return
+		}
+		status := probeOpenAIKey(ctx, client, now)
+		if !recordKeyStatus(status) || len(status.Warnings) == 0 {
+			return
+		}
+		a := Alert{
+			Metric:  "key_health:" + status.Provider,
+			Message: fmt.Sprintf("%s key: %v", status.Provider, status.Warnings),
+			Time:    now,
+		}
+		log.Printf("Key health: %s", a.Message)
+		if alertsEnabled() {
+			sendAlert(ctx, a)
+		}
+	}
+
+	go func() {
+		probe(time.Now())
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				probe(now)
+			}
+		}
+	}()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func newTestOpenAIClient(url string) *openai.Client {
+	cfg := openai.DefaultConfig("test-key")
+	cfg.BaseURL = url + "/v1"
+	return openai.NewClientWithConfig(cfg)
+}
+
+func TestProbeOpenAIKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("x-ratelimit-limit-requests", "100")
+		w.Header().Set("x-ratelimit-remaining-requests", "5")
+		w.Header().Set("x-ratelimit-limit-tokens", "40000")
+		w.Header().Set("x-ratelimit-remaining-tokens", "39000")
+		w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "pong"}}], "usage": {"total_tokens": 9}}`))
+	}))
+	defer server.Close()
+
+	status := probeOpenAIKey(t.Context(), newTestOpenAIClient(server.URL), time.Now())
+	if !status.Valid || status.RateLimits == nil || status.RateLimits.RemainingRequests != 5 {
+		t.Fatalf("Unexpected status: %+v", status)
+	}
+	if len(status.Warnings) != 1 {
+		t.Errorf("Expected a warning about remaining requests only, got %v", status.Warnings)
+	}
+}
+
+func TestProbeOpenAIKeyInvalid(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": {"message": "Incorrect API key provided", "type": "invalid_request_error", "code": "invalid_api_key"}}`))
+	}))
+	defer server.Close()
+
+	status := probeOpenAIKey(t.Context(), newTestOpenAIClient(server.URL), time.Now())
+	if status.Valid || len(status.Warnings) != 1 || status.Error == "" {
+		t.Errorf("Expected an invalid key, got %+v", status)
+	}
+}
+
+func TestRecordKeyStatus(t *testing.T) {
+	keyHealth.statuses = nil
+
+	if recordKeyStatus(KeyStatus{Provider: "openai", Valid: true}) {
+		t.Error("Expected no change for a healthy first probe")
+	}
+	if !recordKeyStatus(KeyStatus{Provider: "openai", Warnings: []string{"key is invalid or has been revoked"}}) {
+		t.Error("Expected a new warning to be reported")
+	}
+	if recordKeyStatus(KeyStatus{Provider: "openai", Warnings: []string{"key is invalid or has been revoked"}}) {
+		t.Error("Expected a repeated warning not to be reported again")
+	}
+	if len(keyStatuses()) != 1 {
+		t.Errorf("Expected one key status, got %v", keyStatuses())
+	}
+	keyHealth.statuses = nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// leakTimeout is how long goroutines get to exit once a test ends;
+// background jobs notice their context's cancellation asynchronously.
+const leakTimeout = 5 * time.Second
+
+// ignoredGoroutines are started once per process by the runtime and the
+// standard library and live until it exits.
+var ignoredGoroutines = []string{
+	"os/signal.signal_recv",
+	"os/signal.loop",
+	"runtime.ensureSigM",
+}
+
+// goroutineStacks returns the stack of every goroutine by goroutine ID.
+func goroutineStacks() map[string]string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	stacks := map[string]string{}
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		id, _, _ := strings.Cut(strings.TrimPrefix(g, "goroutine "), " ")
+		stacks[id] = g
+	}
+	return stacks
+}
+
+// leakedGoroutines returns the stacks of goroutines that are not in before.
+func leakedGoroutines(before map[string]string) []string {
+	var leaked []string
+	for id, stack := range goroutineStacks() {
+		if _, ok := before[id]; ok || strings.Contains(stack, "leakedGoroutines") {
+			continue
+		}
+		ignored := false
+		for _, fn := range ignoredGoroutines {
+			ignored = ignored || strings.Contains(stack, fn)
+		}
+		if !ignored {
+			leaked = append(leaked, stack)
+		}
+	}
+	sort.Strings(leaked)
+	return leaked
+}
+
+// leakKind describes what a leaked goroutine holds on to.
+func leakKind(stack string) string {
+	switch {
+	case strings.Contains(stack, "net/http.(*persistConn)"):
+		return "HTTP client connection"
+	case strings.Contains(stack, "net/http.(*conn).serve"):
+		return "HTTP server connection"
+	}
+	return "goroutine"
+}
+
+// openFDs counts the process's open file descriptors, or returns -1 where
+// /proc is not available.
+func openFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
+
+// checkLeaks fails t if goroutines, HTTP connections or file descriptors
+// opened during the test are still open when it ends. Call it first so
+// that it runs after the test's other cleanups.
+func checkLeaks(t testing.TB) {
+	t.Helper()
+	before := goroutineStacks()
+	fds := openFDs()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(leakTimeout)
+		for {
+			leaked, open := leakedGoroutines(before), openFDs()
+			if len(leaked) == 0 && open <= fds {
+				return
+			}
+			if time.Now().After(deadline) {
+				for _, stack := range leaked {
+					t.Errorf("Leaked %s:\n%s", leakKind(stack), stack)
+				}
+				if open > fds {
+					t.Errorf("Leaked %d file descriptors", open-fds)
+				}
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+}
+
+func TestLeakedGoroutines(t *testing.T) {
+	before := goroutineStacks()
+	stop := make(chan struct{})
+	go func() { <-stop }()
+	leaked := leakedGoroutines(before)
+	if len(leaked) != 1 || !strings.Contains(leaked[0], "TestLeakedGoroutines") || leakKind(leaked[0]) != "goroutine" {
+		t.Errorf("Expected the blocked goroutine to be reported, got %q", leaked)
+	}
+	close(stop)
+	for deadline := time.Now().Add(leakTimeout); len(leaked) > 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+		leaked = leakedGoroutines(before)
+	}
+	if len(leaked) > 0 {
+		t.Errorf("Expected no leak once the goroutine returned, got %q", leaked)
+	}
+}
+
+// TestServerLifecycleDoesNotLeak starts and stops the server with its
+// background jobs a few times and serves requests in between.
+func TestServerLifecycleDoesNotLeak(t *testing.T) {
+	checkLeaks(t)
+	viper.Reset()
+	t.Cleanup(func() {
+		viper.Reset()
+		initConfig()
+		services.SetOpenAI(nil)
+		services.SetMinIO(nil)
+		keyHealth.statuses = nil
+	})
+	socket := filepath.Join(t.TempDir(), "api.sock")
+	viper.Set("server.listeners", []map[string]any{{"name": "test", "network": "unix", "addr": socket}})
+	viper.Set("server.shutdown_timeout_seconds", 1)
+	viper.Set("openai.fake", true)
+	viper.Set("jobs.key_probe_minutes", 1)
+	viper.Set("alerts.webhook_url", "http://127.0.0.1:1/alerts")
+
+	transport := &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+	}}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+
+	for cycle := range 3 {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- runServer(ctx) }()
+
+		var resp *http.Response
+		var err error
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if resp, err = client.Get("http://app/health"); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			cancel()
+			t.Fatalf("Cycle %d: server did not start: %v", cycle, err)
+		}
+		resp.Body.Close()
+		resp, err = client.Post("http://app/chat/stream", "application/json", strings.NewReader(`{"message": "Hi"}`))
+		if err != nil {
+			t.Fatalf("Cycle %d: %v", cycle, err)
+		}
+		resp.Body.Close()
+
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Cycle %d: server stopped with %v", cycle, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Cycle %d: server did not stop", cycle)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// maxBrokenLinksInAlert caps how many links one alert lists.
+const maxBrokenLinksInAlert = 10
+
+type BrokenLink struct {
+	Site  string `json:"site" doc:"Published site"`
+	Page  string `json:"page" doc:"Page containing the link, relative to the site root"`
+	URL   string `json:"url" doc:"Link target as written in the page"`
+	Error string `json:"error" doc:"Why the link is broken"`
+}
+
+type LinkCheckResponse struct {
+	CheckedAt   time.Time    `json:"checked_at" doc:"When the last check finished; zero if no check has run"`
+	BrokenLinks []BrokenLink `json:"broken_links" doc:"Broken links found by the last check"`
+}
+
+var linkHealth struct {
+	sync.Mutex
+	checkedAt time.Time
+	// broken holds each site's broken links, created from
+	// stores.link_results on first use.
+	broken *lruStore[[]BrokenLink]
+}
+
+// brokenLinksSize approximates the memory a site's broken links hold.
+func brokenLinksSize(links []BrokenLink) int64 {
+	var n int64
+	for _, b := range links {
+		n += int64(len(b.Site) + len(b.Page) + len(b.URL) + len(b.Error))
+	}
+	return n
+}
+
+var linkCheckClient = &http.Client{Timeout: 10 * time.Second}
+
+// linkChecker checks the links of one site's pages, remembering the result
+// for each target so shared links are only fetched once.
+type linkChecker struct {
+	client  *minio.Client
+	site    SiteConfig
+	index   string
+	results map[string]string
+}
+
+// isHTMLKey reports whether an object is a page the link checker reads.
+func isHTMLKey(key string) bool {
+	ext := strings.ToLower(path.Ext(key))
+	return ext == ".html" || ext == ".htm"
+}
+
+// check returns why a link in page is broken, or "" if it works. Links
+// with a path are resolved against the site root, as on a custom domain.
+func (lc *linkChecker) check(ctx context.Context, page, link string) string {
+	ref, err := url.Parse(link)
+	if err != nil {
+		return "invalid URL"
+	}
+	base := &url.URL{Scheme: "site", Host: "site", Path: "/" + page}
+	target := base.ResolveReference(ref)
+
+	switch target.Scheme {
+	case "mailto", "tel":
+		return ""
+	case "http", "https":
+		if !lc.ownHost(target.Host) {
+			target.Fragment = ""
+			return lc.cached(target.String(), func() string { return checkExternalLink(ctx, target.String()) })
+		}
+	case "site":
+	default:
+		return ""
+	}
+
+	rel := strings.TrimPrefix(path.Clean("/"+target.Path), "/")
+	if rel == "" || strings.HasSuffix(target.Path, "/") {
+		rel = path.Join(rel, lc.index)
+	}
+	key := lc.site.root() + rel
+	return lc.cached("site:"+key, func() string {
+		_, err := lc.client.StatObject(ctx, lc.site.Bucket, key, minio.StatObjectOptions{})
+		if isNotFound(err) && path.Ext(rel) == "" {
+			_, err = lc.client.StatObject(ctx, lc.site.Bucket, key+"/"+lc.index, minio.StatObjectOptions{})
+		}
+		switch {
+		case isNotFound(err):
+			return "page not found"
+		case err != nil:
+			return err.Error()
+		}
+		return ""
+	})
+}
+
+func (lc *linkChecker) ownHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, h := range lc.site.Hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (lc *linkChecker) cached(target string, check func() string) string {
+	if result, ok := lc.results[target]; ok {
+		return result
+	}
+	result := check()
+	lc.results[target] = result
+	return result
+}
+
+// checkExternalLink fetches a URL, falling back to GET for servers that
+// don't support HEAD.
+func checkExternalLink(ctx context.Context, target string) string {
+	status := 0
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, target, nil)
+		if err != nil {
+			return "invalid URL"
+		}
+		resp, err := linkCheckClient.Do(req)
+		if err != nil {
+			return err.Error()
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		status = resp.StatusCode
+		if status != http.StatusMethodNotAllowed && status != http.StatusNotImplemented {
+			break
+		}
+	}
+	if status >= 400 {
+		return fmt.Sprintf("returned %d %s", status, http.StatusText(status))
+	}
+	return ""
+}
+
+// checkSiteLinks reads every HTML page of a site and returns its broken
+// links.
+func checkSiteLinks(ctx context.Context, site SiteConfig, index string) ([]BrokenLink, error) {
+	client, err := services.MinIO()
+	if err != nil {
+		return nil, err
+	}
+	lc := &linkChecker{client: client, site: site, index: index, results: map[string]string{}}
+	broken := []BrokenLink{}
+	root := site.root()
+	for obj := range client.ListObjects(ctx, site.Bucket, minio.ListObjectsOptions{Prefix: root, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if !isHTMLKey(obj.Key) || obj.Size > config.Limits.MaxRenderBytes {
+			continue
+		}
+		o, err := client.GetObject(ctx, site.Bucket, obj.Key, minio.GetObjectOptions{})
+		if err != nil {
+			return nil, err
+		}
+		src, err := io.ReadAll(io.LimitReader(o, config.Limits.MaxRenderBytes))
+		o.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		page := strings.TrimPrefix(obj.Key, root)
+		for _, link := range htmlLinks(sanitizeHTML(src)) {
+			if reason := lc.check(ctx, page, link); reason != "" {
+				broken = append(broken, BrokenLink{Site: site.Name, Page: page, URL: link, Error: reason})
+			}
+		}
+	}
+	return broken, nil
+}
+
+// recordBrokenLinks stores a site's broken links and returns the ones the
+// previous check did not report.
+func recordBrokenLinks(site string, broken []BrokenLink, now time.Time) []BrokenLink {
+	linkHealth.Lock()
+	defer linkHealth.Unlock()
+	if linkHealth.broken == nil {
+		linkHealth.broken = newLRUStore("link_results", config.Stores.LinkResults, brokenLinksSize)
+	}
+	previous, _ := linkHealth.broken.get(site)
+	known := map[BrokenLink]bool{}
+	for _, b := range previous {
+		known[b] = true
+	}
+	var fresh []BrokenLink
+	for _, b := range broken {
+		if !known[b] {
+			fresh = append(fresh, b)
+		}
+	}
+	linkHealth.broken.set(site, broken, 0)
+	linkHealth.checkedAt = now
+	return fresh
+}
+
+// startLinkChecker checks the links of every published site now and then
+// every interval, alerting when new broken links show up.
+func startLinkChecker(ctx context.Context, interval time.Duration) {
+	run := func() {
+		for _, site := range config.Sites.Published {
+			broken, err := checkSiteLinks(ctx, site, config.Sites.IndexDocument)
+			if err != nil {
+				log.Printf("Link check of site %s failed: %v", site.Name, err)
+				continue
+			}
+			fresh := recordBrokenLinks(site.Name, broken, time.Now())
+			if len(fresh) == 0 {
+				continue
+			}
+			lines := []string{}
+			for i, b := range fresh {
+				if i == maxBrokenLinksInAlert {
+					lines = append(lines, fmt.Sprintf("and %d more", len(fresh)-i))
+					break
+				}
+				lines = append(lines, fmt.Sprintf("%s -> %s (%s)", b.Page, b.URL, b.Error))
+			}
+			a := Alert{
+				Metric:  "broken_links:" + site.Name,
+				Current: float64(len(broken)),
+				Message: fmt.Sprintf("Site %s has %d new broken links: %s", site.Name, len(fresh), strings.Join(lines, "; ")),
+				Time:    time.Now(),
+			}
+			log.Printf("Link check: %s", a.Message)
+			if alertsEnabled() {
+				sendAlert(ctx, a)
+			}
+		}
+	}
+
+	go func() {
+		run()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				run()
+			}
+		}
+	}()
+}
+
+func registerLinkCheckEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "get-broken-links",
+		Method:      http.MethodGet,
+		Path:        "/admin/sites/links",
+		Summary:     "Get broken links",
+		Description: "Report the broken links the link checker found in published sites",
+	}, func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer admin token"`
+	}) (*struct {
+		Body LinkCheckResponse
+	}, error) {
+		if err := requireAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+
+		linkHealth.Lock()
+		resp := LinkCheckResponse{CheckedAt: linkHealth.checkedAt, BrokenLinks: []BrokenLink{}}
+		if linkHealth.broken != nil {
+			linkHealth.broken.each(func(_ string, broken []BrokenLink) {
+				resp.BrokenLinks = append(resp.BrokenLinks, broken...)
+			})
+		}
+		linkHealth.Unlock()
+		sort.SliceStable(resp.BrokenLinks, func(i, j int) bool { return resp.BrokenLinks[i].Site < resp.BrokenLinks[j].Site })
+
+		return &struct {
+			Body LinkCheckResponse
+		}{Body: resp}, nil
+	})
+}
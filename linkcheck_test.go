@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestCheckSiteLinks(t *testing.T) {
+	viper.Reset()
+	initConfig()
+
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/gone":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/no-head" && r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer external.Close()
+
+	s3 := httptest.NewServer(fakeS3(map[string]string{
+		"docs/reports/index.html": `<a href="q1/">Q1</a> <a href="q2/">Q2</a> <a href="#top">Top</a>
+			<a href="` + external.URL + `/ok">ok</a> <a href="` + external.URL + `/gone">gone</a>
+			<a href="` + external.URL + `/no-head">no head</a> <a href="mailto:team@example.com">mail</a>
+			<a href="javascript:void(0)">js</a> <a href="https://reports.example.com/q1">self</a>`,
+		"docs/reports/q1/index.html": `<img src="../chart.png"> <img src="missing.png"> <a href="/q1">root</a>`,
+		"docs/reports/chart.png":     "png",
+		"docs/reports/style.css":     "body {}",
+	}))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	site := SiteConfig{Name: "reports", Bucket: "docs", Prefix: "reports", Hosts: []string{"reports.example.com"}}
+	broken, err := checkSiteLinks(context.Background(), site, "index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	for _, b := range broken {
+		got[b.Page+" "+b.URL] = b.Error
+	}
+	want := map[string]string{
+		"index.html q2/":                       "page not found",
+		"index.html " + external.URL + "/gone": "returned 404 Not Found",
+		"q1/index.html missing.png":            "page not found",
+	}
+	if len(got) != len(want) {
+		t.Errorf("Expected %d broken links, got %v", len(want), got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: expected %q, got %q", k, v, got[k])
+		}
+	}
+}
+
+func TestRecordBrokenLinks(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	linkHealth.broken = nil
+	now := time.Now()
+	a := BrokenLink{Site: "reports", Page: "index.html", URL: "q2/", Error: "page not found"}
+	b := BrokenLink{Site: "reports", Page: "index.html", URL: "q3/", Error: "page not found"}
+
+	if fresh := recordBrokenLinks("reports", []BrokenLink{a}, now); len(fresh) != 1 {
+		t.Errorf("Expected the first broken link to be new, got %v", fresh)
+	}
+	if fresh := recordBrokenLinks("reports", []BrokenLink{a, b}, now); len(fresh) != 1 || fresh[0] != b {
+		t.Errorf("Expected only the second link to be new, got %v", fresh)
+	}
+	if fresh := recordBrokenLinks("reports", []BrokenLink{a, b}, now); len(fresh) != 0 {
+		t.Errorf("Expected no new broken links, got %v", fresh)
+	}
+}
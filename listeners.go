@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// ListenerConfig configures one address the API is served on: a TCP
+// address, a unix socket, or a socket passed by systemd. Listeners can
+// restrict the paths they serve, so public and admin traffic can be split
+// across ports.
+type ListenerConfig struct {
+	Name         string    `mapstructure:"name" doc:"Listener name used in logs"`
+	Network      string    `mapstructure:"network" enum:"tcp,tcp4,tcp6,unix,systemd" doc:"tcp listens on IPv4 and IPv6, tcp4 and tcp6 on one of them, unix on a socket file, systemd on a socket passed by socket activation; defaults to tcp"`
+	Addr         string    `mapstructure:"addr" doc:"Address to listen on, e.g. :8080 or [::1]:9090; the socket path for unix; the FileDescriptorName or index for systemd"`
+	SocketMode   string    `mapstructure:"socket_mode" doc:"Octal permissions of a unix socket file, e.g. 0660"`
+	Middleware   []string  `mapstructure:"middleware" enum:"tracing,usage,real_ip,recover,request_log" doc:"Middleware applied to requests, outermost first; defaults to tracing and usage"`
+	Paths        []string  `mapstructure:"paths" doc:"Only serve paths at or below one of these prefixes, matched by whole segments; empty serves every path"`
+	ExcludePaths []string  `mapstructure:"exclude_paths" doc:"Never serve paths at or below one of these prefixes, matched by whole segments"`
+	Protocols    []string  `mapstructure:"protocols" enum:"http1,http2,h2c" doc:"Protocols to serve; h2c is HTTP/2 without TLS with prior knowledge. Defaults to http1 and http2, where http2 needs TLS"`
+	TLS          TLSConfig `mapstructure:"tls" doc:"TLS settings; plain HTTP when no certificate is set"`
+}
+
+type TLSConfig struct {
+	CertFile     string `mapstructure:"cert_file" doc:"PEM certificate chain"`
+	KeyFile      string `mapstructure:"key_file" doc:"PEM private key"`
+	ClientCAFile string `mapstructure:"client_ca_file" doc:"PEM CA bundle client certificates must be signed by; enables mutual TLS"`
+	MinVersion   string `mapstructure:"min_version" enum:"1.2,1.3" doc:"Oldest TLS version accepted; defaults to 1.2"`
+}
+
+// defaultMiddleware is the chain of listeners that don't configure one.
+var defaultMiddleware = []string{"tracing", "usage"}
+
+// listenerMiddleware lists the middleware listeners can be configured with.
+var listenerMiddleware = map[string]func(http.Handler) http.Handler{
+	"tracing":     tracingMiddleware,
+	"usage":       usageMiddleware,
+	"real_ip":     middleware.RealIP,
+	"recover":     middleware.Recoverer,
+	"request_log": middleware.Logger,
+}
+
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func (l ListenerConfig) Validate() error {
+	if l.Name == "" {
+		return errors.New("server.listeners: name must not be empty")
+	}
+	switch l.Network {
+	case "", "tcp", "tcp4", "tcp6", "systemd":
+	case "unix":
+		if l.SocketMode != "" {
+			if _, err := strconv.ParseUint(l.SocketMode, 8, 32); err != nil {
+				return fmt.Errorf("server.listeners.%s: socket_mode %q is not an octal mode", l.Name, l.SocketMode)
+			}
+		}
+	default:
+		return fmt.Errorf("server.listeners.%s: unknown network %q", l.Name, l.Network)
+	}
+	if l.Addr == "" {
+		return fmt.Errorf("server.listeners.%s: addr must not be empty", l.Name)
+	}
+	if l.SocketMode != "" && l.Network != "unix" {
+		return fmt.Errorf("server.listeners.%s: socket_mode only applies to unix sockets", l.Name)
+	}
+	for _, p := range l.Protocols {
+		switch p {
+		case "http1", "http2":
+		case "h2c":
+			if l.TLS.CertFile != "" {
+				return fmt.Errorf("server.listeners.%s: h2c is only served without TLS", l.Name)
+			}
+		default:
+			return fmt.Errorf("server.listeners.%s: unknown protocol %q", l.Name, p)
+		}
+	}
+	for _, m := range l.Middleware {
+		if listenerMiddleware[m] == nil {
+			return fmt.Errorf("server.listeners.%s: unknown middleware %q", l.Name, m)
+		}
+	}
+	return l.TLS.validate(l.Name)
+}
+
+func (c TLSConfig) validate(listener string) error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("server.listeners.%s: tls.cert_file and tls.key_file must be set together", listener)
+	}
+	if c.CertFile == "" && (c.ClientCAFile != "" || c.MinVersion != "") {
+		return fmt.Errorf("server.listeners.%s: tls settings need tls.cert_file", listener)
+	}
+	if _, ok := tlsVersions[c.MinVersion]; !ok {
+		return fmt.Errorf("server.listeners.%s: unknown tls.min_version %q", listener, c.MinVersion)
+	}
+	return nil
+}
+
+// tlsConfig loads the certificates of c, or returns nil for plain HTTP.
+func (c TLSConfig) tlsConfig() (*tls.Config, error) {
+	if c.CertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tlsVersions[c.MinVersion],
+	}
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", c.ClientCAFile)
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// configuredListeners returns the configured listeners. Without any, the
+// API is served on every socket passed by systemd, or else on server.port.
+func configuredListeners() ([]ListenerConfig, error) {
+	if len(config.Server.Listeners) > 0 {
+		return config.Server.Listeners, nil
+	}
+	sockets, err := systemdSockets()
+	if err != nil {
+		return nil, err
+	}
+	if len(sockets) == 0 {
+		return []ListenerConfig{{Name: "default", Addr: ":" + config.Server.Port}}, nil
+	}
+	var listeners []ListenerConfig
+	for _, s := range sockets {
+		listeners = append(listeners, ListenerConfig{Name: "systemd-" + s.Name, Network: "systemd", Addr: s.Name})
+	}
+	return listeners, nil
+}
+
+// protocols returns the protocols listener l serves, or nil for the
+// server's default of HTTP/1 and HTTP/2 over TLS.
+func (l ListenerConfig) protocols() *http.Protocols {
+	if len(l.Protocols) == 0 {
+		return nil
+	}
+	p := new(http.Protocols)
+	p.SetHTTP1(slices.Contains(l.Protocols, "http1"))
+	p.SetHTTP2(slices.Contains(l.Protocols, "http2"))
+	p.SetUnencryptedHTTP2(slices.Contains(l.Protocols, "h2c"))
+	return p
+}
+
+// listen opens the socket of listener c.
+func listen(c ListenerConfig) (net.Listener, error) {
+	switch c.Network {
+	case "":
+		return net.Listen("tcp", c.Addr)
+	case "unix":
+		return listenUnix(c.Addr, c.SocketMode)
+	case "systemd":
+		return systemdListener(c.Addr)
+	default:
+		return net.Listen(c.Network, c.Addr)
+	}
+}
+
+// hasPathPrefix reports whether the cleaned path is one of prefixes or
+// lies below one. Prefixes match whole segments, so /v1/chat matches
+// /v1/chat/completions but not /v1/chatter, and dot segments cannot climb
+// out of a prefix.
+func hasPathPrefix(p string, prefixes []string) bool {
+	p = cleanPath(p)
+	return slices.ContainsFunc(prefixes, func(prefix string) bool {
+		prefix = strings.TrimSuffix(prefix, "/")
+		return p == prefix || strings.HasPrefix(p, prefix+"/")
+	})
+}
+
+// cleanPath resolves dot segments and repeated slashes in a URL path.
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// listenerHandler wraps handler in the path filter and middleware chain of
+// listener l.
+func listenerHandler(l ListenerConfig, handler http.Handler) http.Handler {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (len(l.Paths) > 0 && !hasPathPrefix(r.URL.Path, l.Paths)) || hasPathPrefix(r.URL.Path, l.ExcludePaths) {
+			http.NotFound(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+
+	chain := l.Middleware
+	if len(chain) == 0 {
+		chain = defaultMiddleware
+	}
+	var wrapped http.Handler = h
+	for i := len(chain) - 1; i >= 0; i-- {
+		wrapped = listenerMiddleware[chain[i]](wrapped)
+	}
+	return wrapped
+}
+
+// apiListener is a bound listener and the server that will serve it.
+type apiListener struct {
+	name string
+	ln   net.Listener
+	srv  *http.Server
+}
+
+// openListeners binds every listener before any of them serves, so a bad
+// address or certificate fails startup as a whole.
+func openListeners(configs []ListenerConfig, handler http.Handler) ([]*apiListener, error) {
+	var opened []*apiListener
+	closeAll := func() {
+		for _, l := range opened {
+			l.ln.Close()
+		}
+	}
+	for _, c := range configs {
+		tlsConfig, err := c.TLS.tlsConfig()
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("listener %s: %w", c.Name, err)
+		}
+		ln, err := listen(c)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("listener %s: %w", c.Name, err)
+		}
+		opened = append(opened, &apiListener{
+			name: c.Name,
+			ln:   ln,
+			srv: &http.Server{
+				Handler:   listenerHandler(c, handler),
+				TLSConfig: tlsConfig,
+				Protocols: c.protocols(),
+			},
+		})
+	}
+	return opened, nil
+}
+
+func (l *apiListener) serve() error {
+	scheme := "http"
+	if l.srv.TLSConfig != nil {
+		scheme = "https"
+	}
+	log.Printf("Listener %s serving %s on %s", l.name, scheme, l.ln.Addr())
+	if l.srv.TLSConfig != nil {
+		return l.srv.ServeTLS(l.ln, "", "")
+	}
+	return l.srv.Serve(l.ln)
+}
+
+// serveListeners serves every listener until the first one stops or ctx is
+// done. Once ctx is done the listeners stop accepting connections and
+// in-flight requests get up to grace to finish before they are cut off.
+func serveListeners(ctx context.Context, listeners []*apiListener, grace time.Duration) error {
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			errs <- fmt.Errorf("listener %s: %w", l.name, l.serve())
+		}()
+	}
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down; waiting up to %s for in-flight requests", grace)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.srv.Shutdown(shutdownCtx); err != nil {
+				log.Printf("Listener %s: %v; closing the remaining connections", l.name, err)
+				l.srv.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListenerValidate(t *testing.T) {
+	valid := ListenerConfig{Name: "public", Addr: ":8080", Middleware: []string{"recover", "tracing"}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected %+v to be valid, got %v", valid, err)
+	}
+
+	invalid := []ListenerConfig{
+		{Addr: ":8080"},
+		{Name: "public"},
+		{Name: "public", Addr: ":8080", Network: "udp"},
+		{Name: "public", Addr: ":8080", Middleware: []string{"gzip"}},
+		{Name: "public", Addr: ":8080", TLS: TLSConfig{CertFile: "cert.pem"}},
+		{Name: "public", Addr: ":8080", TLS: TLSConfig{ClientCAFile: "ca.pem"}},
+		{Name: "public", Addr: ":8080", TLS: TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", MinVersion: "1.0"}},
+	}
+	for _, l := range invalid {
+		if err := l.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", l)
+		}
+	}
+
+	dup := ServerConfig{Port: "8080", Listeners: []ListenerConfig{valid, valid}}
+	if err := dup.Validate(); err == nil {
+		t.Error("Expected duplicate listener names to be rejected")
+	}
+}
+
+func TestListenerHandler(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	public := listenerHandler(ListenerConfig{Name: "public", ExcludePaths: []string{"/admin/"}}, ok)
+	admin := listenerHandler(ListenerConfig{Name: "admin", Paths: []string{"/admin/", "/health"}, Middleware: []string{"recover"}}, ok)
+
+	cases := []struct {
+		handler http.Handler
+		path    string
+		status  int
+	}{
+		{public, "/chat", http.StatusNoContent},
+		{public, "/admin/secrets/rotate", http.StatusNotFound},
+		{public, "/chat/../admin/secrets/rotate", http.StatusNotFound},
+		{public, "//admin/secrets/rotate", http.StatusNotFound},
+		{admin, "/admin/secrets/rotate", http.StatusNoContent},
+		{admin, "/health", http.StatusNoContent},
+		{admin, "/healthz", http.StatusNotFound},
+		{admin, "/admin/../chat", http.StatusNotFound},
+		{admin, "/chat", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		c.handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.path, nil))
+		if w.Code != c.status {
+			t.Errorf("Expected %d for %s, got %d", c.status, c.path, w.Code)
+		}
+	}
+
+	// The default chain includes tracing, the admin chain does not
+	w := httptest.NewRecorder()
+	public.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/chat", nil))
+	if w.Header().Get(requestIDHeader) == "" {
+		t.Error("Expected the default middleware to set a request ID")
+	}
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if w.Header().Get(requestIDHeader) != "" {
+		t.Error("Expected no request ID without the tracing middleware")
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1.
+func writeTestCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestServeListeners(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})
+
+	listeners, err := openListeners([]ListenerConfig{
+		{Name: "plain", Network: "tcp4", Addr: "127.0.0.1:0"},
+		{Name: "secure", Addr: "127.0.0.1:0", TLS: TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3"}},
+	}, handler)
+	if err != nil {
+		t.Fatalf("Failed to open listeners: %v", err)
+	}
+	defer func() {
+		for _, l := range listeners {
+			l.srv.Close()
+		}
+	}()
+	go serveListeners(context.Background(), listeners, 0)
+
+	resp, err := http.Get("http://" + listeners[0].ln.Addr().String() + "/plain")
+	if err != nil {
+		t.Fatalf("Plain request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 from the plain listener, got %d", resp.StatusCode)
+	}
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err = client.Get("https://" + listeners[1].ln.Addr().String() + "/secure")
+	if err != nil {
+		t.Fatalf("TLS request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.TLS == nil || resp.TLS.Version != tls.VersionTLS13 {
+		t.Errorf("Expected a TLS 1.3 connection, got %+v", resp.TLS)
+	}
+
+	// A listener that cannot bind fails startup and releases the others
+	if _, err := openListeners([]ListenerConfig{
+		{Name: "ok", Addr: "127.0.0.1:0"},
+		{Name: "taken", Addr: listeners[0].ln.Addr().String()},
+	}, handler); err == nil {
+		t.Error("Expected an error for an address in use")
+	}
+}
+
+func TestServeListenersShutdown(t *testing.T) {
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+	listeners, err := openListeners([]ListenerConfig{{Name: "plain", Addr: "127.0.0.1:0"}}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- serveListeners(ctx, listeners, 5*time.Second) }()
+
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + listeners[0].ln.Addr().String() + "/")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		body <- string(data)
+	}()
+	<-started
+	cancel()
+
+	if got := <-body; got != "done" {
+		t.Errorf("Expected the in-flight request to finish, got %q", got)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("Expected a graceful stop, got %v", err)
+	}
+	if _, err := http.Get("http://" + listeners[0].ln.Addr().String() + "/"); err == nil {
+		t.Error("Expected the listener to be closed after shutdown")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loadReport summarizes a load test. Latencies are in milliseconds.
+type loadReport struct {
+	Target   string         `json:"target"`
+	Requests int            `json:"requests"`
+	Errors   int            `json:"errors"`
+	Dropped  int            `json:"dropped"`
+	RPS      float64        `json:"rps"`
+	Statuses map[string]int `json:"statuses"`
+	MinMS    float64        `json:"min_ms"`
+	P50MS    float64        `json:"p50_ms"`
+	P90MS    float64        `json:"p90_ms"`
+	P99MS    float64        `json:"p99_ms"`
+	MaxMS    float64        `json:"max_ms"`
+}
+
+// loadTarget is the request a load test sends over and over.
+type loadTarget struct {
+	method      string
+	url         string
+	body        []byte
+	contentType string
+}
+
+func (t loadTarget) send(ctx context.Context, client *http.Client) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, t.method, t.url, bytes.NewReader(t.body))
+	if err != nil {
+		return "", err
+	}
+	if len(t.body) > 0 {
+		req.Header.Set("Content-Type", t.contentType)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	status := strconv.Itoa(resp.StatusCode)
+	if resp.StatusCode >= 400 {
+		return status, errors.New(resp.Status)
+	}
+	return status, nil
+}
+
+// percentile returns the nearest-rank percentile p of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p/100+0.999999) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// runLoadTest sends requests to target at rps for duration, starting each on
+// schedule whether or not earlier ones finished so that a slow server shows
+// up as latency rather than a lower rate. Requests that would exceed
+// concurrency are dropped and counted.
+func runLoadTest(ctx context.Context, target loadTarget, client *http.Client, rps int, duration time.Duration, concurrency int) loadReport {
+	report := loadReport{Target: target.method + " " + target.url, Statuses: map[string]int{}}
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		latencies []time.Duration
+	)
+	slots := make(chan struct{}, concurrency)
+	ticker := time.NewTicker(time.Second / time.Duration(rps))
+	defer ticker.Stop()
+	start := time.Now()
+	deadline := time.NewTimer(duration)
+	defer deadline.Stop()
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-deadline.C:
+			break loop
+		case <-ticker.C:
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			report.Dropped++
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			began := time.Now()
+			status, err := target.send(ctx, client)
+			took := time.Since(began)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Requests++
+			if status == "" {
+				status = "error"
+			}
+			report.Statuses[status]++
+			if err != nil {
+				report.Errors++
+				return
+			}
+			latencies = append(latencies, took)
+		}()
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	report.RPS = float64(report.Requests) / elapsed.Seconds()
+	slices.Sort(latencies)
+	if len(latencies) > 0 {
+		report.MinMS = milliseconds(latencies[0])
+		report.MaxMS = milliseconds(latencies[len(latencies)-1])
+	}
+	report.P50MS = milliseconds(percentile(latencies, 50))
+	report.P90MS = milliseconds(percentile(latencies, 90))
+	report.P99MS = milliseconds(percentile(latencies, 99))
+	return report
+}
+
+// runLoadTestCommand drives a running instance at a fixed request rate and
+// reports latency percentiles of the successful requests. It exits with
+// exitFailure when the p99 latency or the error rate exceed the given
+// limits, so it can guard releases against performance regressions.
+func runLoadTestCommand(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	flags.SetOutput(out)
+	serverURL := flags.String("url", envOr("APP_URL", "http://localhost:8080"), "URL of a running instance (env APP_URL)")
+	method := flags.String("method", http.MethodGet, "HTTP method of the requests")
+	path := flags.String("path", "/health", "Path the requests are sent to")
+	body := flags.String("body", "", "Request body, or @file to read it from a file")
+	contentType := flags.String("content-type", "application/json", "Content type of the request body")
+	rps := flags.Int("rps", 50, "Requests started per second")
+	duration := flags.Duration("duration", 10*time.Second, "How long to send requests")
+	concurrency := flags.Int("c", 100, "Most requests in flight; requests beyond it are dropped")
+	timeout := flags.Duration("timeout", 10*time.Second, "Timeout of each request")
+	maxP99 := flags.Duration("max-p99", 0, "Fail when the p99 latency exceeds this; 0 disables the check")
+	maxErrors := flags.Float64("max-error-rate", 0.01, "Fail when more than this fraction of requests fail")
+	format := outputFlag(flags, outputTable)
+	if err := parseFlags(flags, args, format); err != nil {
+		return err
+	}
+	if *rps <= 0 || *concurrency <= 0 || *duration <= 0 {
+		return usageError("-rps, -c and -duration must be positive")
+	}
+
+	target := loadTarget{
+		method:      strings.ToUpper(*method),
+		url:         strings.TrimSuffix(*serverURL, "/") + "/" + strings.TrimPrefix(*path, "/"),
+		body:        []byte(*body),
+		contentType: *contentType,
+	}
+	if file, ok := strings.CutPrefix(*body, "@"); ok {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return usageError("-body: %v", err)
+		}
+		target.body = data
+	}
+	if _, err := http.NewRequest(target.method, target.url, nil); err != nil {
+		return usageError("invalid -url: %v", err)
+	}
+
+	ctx, stop := context.WithTimeout(context.Background(), *duration+*timeout)
+	defer stop()
+	client := &http.Client{
+		Timeout:   *timeout,
+		Transport: &http.Transport{MaxIdleConnsPerHost: *concurrency},
+	}
+	report := runLoadTest(ctx, target, client, *rps, *duration, *concurrency)
+
+	if *format == outputJSON {
+		if err := writeJSON(out, report); err != nil {
+			return err
+		}
+	} else {
+		tw := newTable(out)
+		fmt.Fprintf(tw, "TARGET\t%s\n", report.Target)
+		fmt.Fprintf(tw, "REQUESTS\t%d (%.1f/s)\n", report.Requests, report.RPS)
+		fmt.Fprintf(tw, "ERRORS\t%d\n", report.Errors)
+		fmt.Fprintf(tw, "DROPPED\t%d\n", report.Dropped)
+		statuses := make([]string, 0, len(report.Statuses))
+		for s := range report.Statuses {
+			statuses = append(statuses, s)
+		}
+		sort.Strings(statuses)
+		for _, s := range statuses {
+			fmt.Fprintf(tw, "STATUS %s\t%d\n", s, report.Statuses[s])
+		}
+		fmt.Fprintf(tw, "LATENCY\tmin %.1fms  p50 %.1fms  p90 %.1fms  p99 %.1fms  max %.1fms\n",
+			report.MinMS, report.P50MS, report.P90MS, report.P99MS, report.MaxMS)
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if report.Requests == 0 {
+		return &exitError{code: exitUnavailable, err: errors.New("no request was sent")}
+	}
+	if report.Errors == report.Requests && report.Statuses["error"] == report.Requests {
+		return &exitError{code: exitUnavailable, err: fmt.Errorf("%s could not be reached", *serverURL)}
+	}
+	var problems []string
+	if rate := float64(report.Errors) / float64(report.Requests); rate > *maxErrors {
+		problems = append(problems, fmt.Sprintf("error rate %.1f%% exceeds %.1f%%", rate*100, *maxErrors*100))
+	}
+	if *maxP99 > 0 && report.P99MS > milliseconds(*maxP99) {
+		problems = append(problems, fmt.Sprintf("p99 latency %.1fms exceeds %s", report.P99MS, *maxP99))
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	for p, want := range map[float64]time.Duration{50: 50 * time.Millisecond, 90: 90 * time.Millisecond, 99: 99 * time.Millisecond, 100: 100 * time.Millisecond} {
+		if got := percentile(sorted, p); got != want {
+			t.Errorf("p%v = %v, want %v", p, got, want)
+		}
+	}
+	if got := percentile(nil, 99); got != 0 {
+		t.Errorf("Expected 0 without samples, got %v", got)
+	}
+}
+
+func TestLoadTestCommand(t *testing.T) {
+	var failing bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/chat" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(`{"reply": "ok"}`))
+	}))
+	defer server.Close()
+	args := []string{"-url", server.URL, "-method", "post", "-path", "chat", "-body", `{"message": "hi"}`, "-rps", "200", "-duration", "250ms", "-output", "json"}
+
+	var out, errOut bytes.Buffer
+	if code := runCommand("loadtest", args, nil, &out, &errOut); code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, errOut.String())
+	}
+	var report loadReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests == 0 || report.Errors != 0 || report.Statuses["200"] != report.Requests || report.P99MS < report.P50MS {
+		t.Errorf("Unexpected report %+v", report)
+	}
+
+	failing = true
+	out.Reset()
+	errOut.Reset()
+	if code := runCommand("loadtest", args, nil, &out, &errOut); code != exitFailure || !strings.Contains(errOut.String(), "error rate") {
+		t.Errorf("Expected exit code %d for failing requests, got %d: %s", exitFailure, code, errOut.String())
+	}
+
+	server.Close()
+	if code := runCommand("loadtest", args, nil, &out, &errOut); code != exitUnavailable {
+		t.Errorf("Expected exit code %d without a server, got %d", exitUnavailable, code)
+	}
+	if code := runCommand("loadtest", []string{"-rps", "0"}, nil, &out, &errOut); code != exitUsage {
+		t.Errorf("Expected exit code %d for -rps 0, got %d", exitUsage, code)
+	}
+}
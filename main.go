@@ -1,37 +1,109 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/danielgtaylor/huma/v2"
 	"github.com/danielgtaylor/huma/v2/adapters/humachi"
 	"github.com/go-chi/chi/v5"
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/sashabaranov/go-openai"
-	"github.com/spf13/viper"
 )
 
-// Config structure for our application
-type Config struct {
-	Port        string `mapstructure:"port"`
-	OpenAIKey   string `mapstructure:"openai_key"`
-	MinIOURL    string `mapstructure:"minio_url"`
-	MinIOKey    string `mapstructure:"minio_key"`
-	MinIOSecret string `mapstructure:"minio_secret"`
-}
+const (
+	apiTitle   = "Test Renovate API"
+	apiVersion = "1.0.0"
+)
 
 // API Input/Output structures
 type ChatRequest struct {
-	Message string `json:"message" doc:"Message to send to OpenAI"`
+	Message      string        `json:"message,omitempty" doc:"Message to send to OpenAI; empty with a template, or to continue after a function result"`
+	History      []ChatMessage `json:"history,omitempty" doc:"Earlier turns of the conversation, oldest first"`
+	Model        string        `json:"model,omitempty" doc:"OpenAI model to use; defaults to the tenant policy's default model or gpt-3.5-turbo"`
+	SystemPrompt string        `json:"system_prompt,omitempty" maxLength:"32768" doc:"System message for this request instead of the configured openai.system_prompt"`
+
+	Temperature *float32 `json:"temperature,omitempty" minimum:"0" maximum:"2" doc:"Sampling temperature; higher is more random. Defaults to OpenAI's default"`
+	TopP        *float32 `json:"top_p,omitempty" minimum:"0" maximum:"1" doc:"Nucleus sampling: only tokens within this probability mass are considered. Defaults to OpenAI's default"`
+	MaxTokens   int      `json:"max_tokens,omitempty" minimum:"1" maximum:"128000" doc:"Most tokens the reply may have; defaults to the model's limit"`
+	N           int      `json:"n,omitempty" minimum:"1" maximum:"10" doc:"Number of replies to generate; more than 1 is not supported by /chat/stream"`
+
+	Tools     []string       `json:"tools,omitempty" enum:"current_time,read_file" doc:"Server-side tools the model may call; the service runs them and sends the results back. Not supported by /chat/stream"`
+	Functions []ChatFunction `json:"functions,omitempty" maxItems:"64" doc:"Functions the client runs; when the model calls one, the reply carries the call as function_call. Not supported by /chat/stream"`
+
+	Template  string            `json:"template,omitempty" doc:"Prompt template from GET /templates to send instead of message, which is left empty"`
+	Variables map[string]string `json:"variables,omitempty" doc:"Values of the template's {{variable}} placeholders"`
+
+	Images []ChatImage `json:"images,omitempty" maxItems:"10" doc:"Images sent with the message to a vision model"`
+
+	// imageParts are the images resolved by attachImages
+	imageParts []openai.ChatMessagePart
+	// noCache asks for a new reply even if one is cached
+	noCache bool
+	// replySchema is the JSON Schema a structured reply must match
+	replySchema []byte
+	// documents are excerpts the message is answered from
+	documents string
+}
+
+// completionRequest builds the OpenAI request for req with the sampling
+// parameters it sets.
+func (r ChatRequest) completionRequest(model string, messages []openai.ChatCompletionMessage) openai.ChatCompletionRequest {
+	cr := openai.ChatCompletionRequest{
+		Model:     model,
+		Messages:  messages,
+		MaxTokens: r.MaxTokens,
+		N:         r.N,
+	}
+	// The client leaves out zero values, which would turn an explicit 0
+	// into OpenAI's default of 1
+	if t := r.Temperature; t != nil {
+		cr.Temperature = max(*t, math.SmallestNonzeroFloat32)
+	}
+	if p := r.TopP; p != nil {
+		cr.TopP = max(*p, math.SmallestNonzeroFloat32)
+	}
+	if len(r.replySchema) > 0 {
+		cr.ResponseFormat = &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}
+	}
+	return cr
+}
+
+type ChatMessage struct {
+	Role         string            `json:"role" enum:"user,assistant,function" doc:"Who sent the message; function for the result of a client function"`
+	Content      string            `json:"content" doc:"Message text, or the function's result"`
+	Name         string            `json:"name,omitempty" doc:"Function whose result this is"`
+	FunctionCall *ChatFunctionCall `json:"function_call,omitempty" doc:"Function call of an assistant message"`
 }
 
 type ChatResponse struct {
-	Reply string `json:"reply" doc:"Response from OpenAI"`
+	Reply   string              `json:"reply" doc:"Response from OpenAI"`
+	Replies []string            `json:"replies,omitempty" doc:"Every reply, when more than one was requested with n"`
+	Filter  *OutputFilterResult `json:"filter,omitempty" doc:"Decisions of the output filter about the first reply, when enabled"`
+
+	FunctionCall *ChatFunctionCall    `json:"function_call,omitempty" doc:"Client function the model calls; run it and send its result as a function message in history"`
+	ToolCalls    []ChatToolInvocation `json:"tool_calls,omitempty" doc:"Server-side tools run for this reply, in order"`
+
+	Model string    `json:"model" doc:"Model that answered, as reported by OpenAI"`
+	Usage ChatUsage `json:"usage" doc:"Tokens used for the reply, over all tool rounds; none when it came from the cache"`
+	Cache string    `json:"cache,omitempty" enum:"hit,miss" doc:"Whether the reply came from the chat cache, when enabled"`
+}
+
+type ChatUsage struct {
+	PromptTokens     int `json:"prompt_tokens" doc:"Tokens in the prompts"`
+	CompletionTokens int `json:"completion_tokens" doc:"Tokens in the completions"`
+	TotalTokens      int `json:"total_tokens" doc:"Tokens billed"`
 }
 
 type FileUploadRequest struct {
@@ -46,131 +118,462 @@ type FileUploadResponse struct {
 }
 
 var (
-	config       Config
-	openaiClient *openai.Client
-	minioClient  *minio.Client
+	config Config
 )
 
-func initConfig() {
-	// Initialize Viper for configuration management
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("./config")
-
-	// Set defaults
-	viper.SetDefault("port", "8080")
-	viper.SetDefault("minio_url", "localhost:9000")
-
-	// Enable environment variable binding
-	viper.SetEnvPrefix("APP")
-	viper.AutomaticEnv()
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-
-	// Try to read config file (optional)
-	if err := viper.ReadInConfig(); err != nil {
-		log.Printf("Config file not found, using defaults and environment variables: %v", err)
-	}
-
-	// Unmarshal config
-	if err := viper.Unmarshal(&config); err != nil {
-		log.Fatalf("Failed to unmarshal config: %v", err)
-	}
-
-	log.Printf("Configuration loaded: Port=%s, MinIO URL=%s", config.Port, config.MinIOURL)
-}
-
 func initClients() {
+	secrets.set(config.OpenAI.Key, config.MinIO.Key, config.MinIO.Secret)
+
 	// Initialize OpenAI client
-	if config.OpenAIKey != "" {
-		openaiClient = openai.NewClient(config.OpenAIKey)
+	switch {
+	case config.OpenAI.Fake:
+		services.SetOpenAI(newFakeOpenAIClient(time.Duration(config.OpenAI.FakeLatencyMS) * time.Millisecond))
+		log.Println("OpenAI client replaced by the fake provider")
+	case config.OpenAI.Key != "":
+		openaiConfig := openai.DefaultConfig(config.OpenAI.Key)
+		openaiConfig.HTTPClient = &http.Client{
+			Transport: &openAIKeyTransport{base: newOpenAITransport()},
+		}
+		services.SetOpenAI(openai.NewClientWithConfig(openaiConfig))
 		log.Println("OpenAI client initialized")
-	} else {
+	default:
+		services.SetOpenAI(nil)
 		log.Println("OpenAI API key not provided, chat functionality will be disabled")
 	}
 
 	// Initialize MinIO client
-	if config.MinIOKey != "" && config.MinIOSecret != "" {
+	minioPool.Store(nil)
+	services.SetMinIOReader(nil)
+	if config.MinIO.Key != "" && config.MinIO.Secret != "" {
+		var client *minio.Client
 		var err error
-		minioClient, err = minio.New(config.MinIOURL, &minio.Options{
-			Creds:  credentials.NewStaticV4(config.MinIOKey, config.MinIOSecret, ""),
-			Secure: false, // Set to true for HTTPS
-		})
+		if len(config.MinIO.Failover.Endpoints) > 0 {
+			client, err = initMinIOFailover(minioCreds)
+		} else {
+			client, err = newMinIOClient(minioCreds)
+		}
 		if err != nil {
 			log.Printf("Failed to initialize MinIO client: %v", err)
 		} else {
 			log.Println("MinIO client initialized")
 		}
+		services.SetMinIO(client)
 	} else {
+		services.SetMinIO(nil)
 		log.Println("MinIO credentials not provided, file upload functionality will be disabled")
 	}
 }
 
+// registerEndpoints registers every API operation.
+func registerEndpoints(api huma.API) {
+	registerChatEndpoint(api)
+	registerChatStreamEndpoint(api)
+	registerFileUploadEndpoint(api)
+	registerHealthEndpoint(api)
+	registerTrashEndpoints(api)
+	registerFileRenameEndpoint(api)
+	registerFolderEndpoints(api)
+	registerACLEndpoints(api)
+	registerFilePutEndpoint(api)
+	registerFilePreviewEndpoint(api)
+	registerFileRenderEndpoint(api)
+	registerFileDiffEndpoint(api)
+	registerFileEditEndpoint(api)
+	registerCollectionEndpoints(api)
+	registerSourceEndpoints(api)
+	registerIngestWebhookEndpoint(api)
+	registerSLOEndpoint(api)
+	registerErrorCatalogEndpoint(api)
+	registerSDKEndpoints(api)
+	registerProviderEndpoints(api)
+	registerSecretRotationEndpoint(api)
+	registerProxyUsageEndpoint(api)
+	registerStorageCredentialsEndpoint(api)
+	registerLinkCheckEndpoint(api)
+	registerGalleryEndpoints(api)
+	registerMetricsEndpoint(api)
+	registerSubjectDataEndpoint(api)
+	registerRetentionEndpoint(api)
+	registerTakeoutEndpoints(api)
+	registerTermsEndpoints(api)
+	registerTenantPolicyEndpoints(api)
+	registerConversationEndpoints(api)
+	registerConversationSearchEndpoint(api)
+	registerProvenanceEndpoint(api)
+	registerEmbeddingsEndpoint(api)
+	registerImageEndpoint(api)
+	registerTranscribeEndpoint(api)
+	registerHLSEndpoint(api)
+	registerApprovalEndpoints(api)
+	registerBucketSnapshotEndpoint(api)
+	registerTemplateEndpoints(api)
+	registerWarmupEndpoints(api)
+	registerShadowEndpoint(api)
+	registerRolloutEndpoints(api)
+	registerCostsEndpoint(api)
+	registerStructuredChatEndpoint(api)
+	registerFileChatEndpoint(api)
+}
+
 func main() {
+	// Commands that run instead of the server
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
+	// Stop gracefully on Ctrl+C, SIGTERM and, on Windows, when the console
+	// is closed or the user logs off
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := runServer(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runServer starts the background jobs and serves the API until ctx is
+// done, then waits for in-flight requests to finish.
+func runServer(ctx context.Context) error {
 	// Initialize configuration with Viper
 	initConfig()
 
 	// Initialize external clients
 	initClients()
 
-	// Create Chi router
+	// Install the template gallery on first boot
+	available := services.Status()
+	if available.MinIO && config.Gallery.Seed {
+		seedDefaultGallery()
+	}
+
+	// Create Chi router; middleware is added per listener
 	router := chi.NewMux()
 
+	// Authenticate HMAC-signed requests as their client
+	if verifier := newRequestVerifier(config.RequestSigning); verifier != nil {
+		router.Use(verifier.middleware)
+	}
+
+	// Mirror a share of requests to the canary and compare its responses
+	if shadow := newShadower(config.Shadow); shadow != nil {
+		activeShadower.Lock()
+		activeShadower.s = shadow
+		activeShadower.Unlock()
+		router.Use(shadow.middleware)
+	}
+
+	// Serve published sites on their custom domains
+	sites := newSiteServer(config.Sites)
+	if sites != nil {
+		router.Use(sites.hostMiddleware)
+	}
+
+	// Serve repeated GETs of the configured routes from the cache
+	cache, err := newResponseCache(config.Cache)
+	if err != nil {
+		return fmt.Errorf("failed to initialize response cache: %w", err)
+	}
+	if cache != nil {
+		router.Use(cache.middleware)
+	}
+
+	// Answer repeated identical chat prompts from the cache
+	chats, err := newChatCompletionCache(config.ChatCache)
+	if err != nil {
+		return fmt.Errorf("failed to initialize chat cache: %w", err)
+	}
+	chatCache.Store(chats)
+
 	// Create Huma API
-	api := humachi.New(router, huma.DefaultConfig("Test Renovate API", "1.0.0"))
+	api := humachi.New(router, huma.DefaultConfig(apiTitle, apiVersion))
+	api.UseMiddleware(tenantPolicyMiddleware)
+	api.UseMiddleware(opaMiddleware)
+	api.UseMiddleware(routePolicyMiddleware)
+	api.UseMiddleware(openAIKeyMiddleware)
+	registerEndpoints(api)
+
+	// Forward internal teams' OpenAI calls through the governed proxy
+	if config.Proxy.Enabled {
+		upstream, _ := url.Parse(config.Proxy.UpstreamURL)
+		router.Handle(openAIProxyPrefix+"/*", newOpenAIProxy(upstream))
+	}
 
-	// Register API endpoints
-	registerChatEndpoint(api)
-	registerFileUploadEndpoint(api)
-	registerHealthEndpoint(api)
+	// Report the progress of PUT /files uploads over WebSockets
+	router.Handle(uploadEventsPath, uploadEventsHandler())
+
+	// Serve published sites by name
+	if sites != nil {
+		router.Handle(sitesPrefix+"/{site}", sites)
+		router.Handle(sitesPrefix+"/{site}/*", sites)
+	}
+
+	// Move storage to a healthy MinIO endpoint when one goes down
+	if pool := minioPool.Load(); pool != nil {
+		pool.start(ctx, time.Duration(config.MinIO.Failover.HealthCheckSeconds)*time.Second)
+	}
+
+	// Permanently remove trashed objects once their retention expires
+	if available.MinIO && trashRetention() > 0 {
+		startTrashPurger(ctx, time.Hour)
+	}
+
+	// Purge uploads and compliance records past their retention period
+	if available.MinIO && config.Retention.enabled() && config.Retention.IntervalMinutes > 0 {
+		startRetentionPurger(ctx, time.Duration(config.Retention.IntervalMinutes)*time.Minute)
+	}
+
+	// Alert on token, error and upload spikes and on fast-burning SLOs
+	if alertsEnabled() {
+		startAnomalyMonitor(ctx)
+		if len(config.Monitoring.SLOs) > 0 {
+			startSLOMonitor(ctx)
+		}
+	}
+
+	// Flag broken links in published sites
+	if available.MinIO && len(config.Sites.Published) > 0 && config.Jobs.LinkCheckMinutes > 0 {
+		startLinkChecker(ctx, time.Duration(config.Jobs.LinkCheckMinutes)*time.Minute)
+	}
+
+	// Check the OpenAI key's validity and remaining rate limits
+	if available.OpenAI && config.Jobs.KeyProbeMinutes > 0 {
+		startKeyProbe(ctx, time.Duration(config.Jobs.KeyProbeMinutes)*time.Minute)
+	}
+
+	// Pick up credentials rotated in Vault
+	if config.Auth.Vault.Addr != "" && config.Auth.Vault.SecretPath != "" && config.Auth.Vault.PollMinutes > 0 {
+		startVaultRotation(ctx, time.Duration(config.Auth.Vault.PollMinutes)*time.Minute)
+	}
+
+	// Keep the spend of each day in the system bucket
+	if available.MinIO {
+		startCostRecorder(ctx, time.Duration(config.Costs.FlushSeconds)*time.Second)
+	}
+
+	// Append audit events to the tamper-evident event log
+	if available.MinIO && config.EventLog.Enabled {
+		startEventLog(ctx, time.Duration(config.EventLog.FlushSeconds)*time.Second)
+	}
+
+	// Pull external sources whose sync interval has elapsed
+	if available.MinIO {
+		startSourceScheduler(ctx, time.Minute)
+	}
+
+	// Open connections and fill caches; GET /readyz fails until done
+	if config.Warmup.OnStart {
+		go runWarmup(ctx)
+	}
+
+	// Start serving on every listener
+	listenerConfigs, err := configuredListeners()
+	if err != nil {
+		return fmt.Errorf("failed to read systemd sockets: %w", err)
+	}
+	listeners, err := openListeners(listenerConfigs, router)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	return serveListeners(ctx, listeners, time.Duration(config.Server.ShutdownTimeoutSeconds)*time.Second)
+}
 
-	// Start server
-	addr := fmt.Sprintf(":%s", config.Port)
-	log.Printf("Starting server on %s", addr)
-	log.Fatal(http.ListenAndServe(addr, router))
+// chatCompletionMessages turns a chat request into the messages sent to
+// OpenAI: the style guide, the earlier turns and the new message. An empty
+// message after a function result lets the model continue from the result.
+func chatCompletionMessages(ctx context.Context, req ChatRequest) ([]openai.ChatCompletionMessage, error) {
+	messages := make([]openai.ChatCompletionMessage, 0, len(req.History)+1)
+	for _, m := range req.History {
+		msg := openai.ChatCompletionMessage{Role: m.Role, Content: m.Content, Name: m.Name}
+		if m.FunctionCall != nil {
+			msg.FunctionCall = &openai.FunctionCall{Name: m.FunctionCall.Name, Arguments: m.FunctionCall.Arguments}
+		}
+		messages = append(messages, msg)
+	}
+	continues := req.Message == "" && len(req.History) > 0 && req.History[len(req.History)-1].Role == openai.ChatMessageRoleFunction
+	if !continues {
+		if req.documents != "" {
+			messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: req.documents})
+		}
+		messages = append(messages, userMessage(req))
+	}
+	messages, err := withStyleGuide(ctx, messages)
+	if err != nil {
+		return nil, err
+	}
+	messages = withSystemPrompt(req, messages)
+	// JSON mode needs the messages to ask for JSON
+	if len(req.replySchema) > 0 {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: "Reply with a single JSON value that matches this JSON Schema, and nothing else:\n" + string(req.replySchema),
+		})
+	}
+	return messages, nil
+}
+
+// withSystemPrompt puts the request's system prompt, or else the
+// configured one, in front of messages.
+func withSystemPrompt(req ChatRequest, messages []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	prompt := config.OpenAI.SystemPrompt
+	if req.SystemPrompt != "" {
+		prompt = req.SystemPrompt
+	}
+	if prompt == "" {
+		return messages
+	}
+	return append([]openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleSystem, Content: prompt}}, messages...)
+}
+
+// checkSystemPrompt refuses a request's system prompt when overrides are
+// disabled.
+func checkSystemPrompt(req ChatRequest) error {
+	if req.SystemPrompt != "" && !config.OpenAI.AllowSystemPromptOverride {
+		return huma.Error422UnprocessableEntity("This deployment does not allow overriding the system prompt")
+	}
+	return nil
+}
+
+// completeChat sends req to OpenAI under the non-streaming chat limits and
+// returns the filtered reply. The exchange is logged as operation.
+func completeChat(ctx context.Context, provider ChatProvider, operation string, req ChatRequest, model string) (ChatResponse, error) {
+	if err := checkAllowedModel(model); err != nil {
+		return ChatResponse{}, err
+	}
+	release, err := chatLimits.admit(chatModeNonStreaming, time.Now())
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	defer release()
+	ctx, cancel := withChatTimeout(ctx, chatModeNonStreaming)
+	defer cancel()
+
+	if err := moderateInput(ctx, provider, operation, req.Message); err != nil {
+		return ChatResponse{}, err
+	}
+	messages, err := chatCompletionMessages(ctx, req)
+	if err != nil {
+		return ChatResponse{}, huma.Error500InternalServerError("Failed to load style guide", err)
+	}
+
+	cr := req.completionRequest(model, messages)
+	// Server-side tools can answer differently each time, so their replies
+	// are not cached
+	cache, cacheKey, cacheStatus := chatCache.Load(), "", ""
+	if cache != nil && len(req.Tools) == 0 && !req.noCache {
+		cacheKey, cacheStatus = chatCacheKey(req, cr), chatCacheMiss
+	}
+	var (
+		resp  openai.ChatCompletionResponse
+		trace []ChatToolInvocation
+		hit   bool
+	)
+	if cacheKey != "" {
+		resp, hit = cache.get(ctx, cacheKey)
+	}
+	if hit {
+		cacheStatus = chatCacheHit
+	} else {
+		start := time.Now()
+		resp, trace, err = runChatTools(ctx, provider, req, cr)
+		observeRollout(ctx, time.Since(start), err)
+		if err != nil {
+			logPrompt(ctx, operation, req, "", err)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return ChatResponse{}, errChatTimeout(chatModeNonStreaming)
+			}
+			return ChatResponse{}, openAIError(ctx, "Failed to get OpenAI response", err)
+		}
+		if cacheKey != "" {
+			cache.set(ctx, cacheKey, resp)
+		}
+	}
+
+	out := ChatResponse{
+		ToolCalls: trace,
+		Model:     cmp.Or(resp.Model, model),
+		Cache:     cacheStatus,
+	}
+	// A cached reply costs nothing
+	if !hit {
+		out.Usage = ChatUsage{PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens, TotalTokens: resp.Usage.TotalTokens}
+	}
+	replies := []string{"No response"}
+	if len(resp.Choices) > 0 {
+		replies = make([]string, len(resp.Choices))
+		for i, c := range resp.Choices {
+			replies[i] = c.Message.Content
+		}
+		if call := resp.Choices[0].Message.FunctionCall; call != nil {
+			out.FunctionCall = &ChatFunctionCall{Name: call.Name, Arguments: call.Arguments}
+		}
+	}
+	logPrompt(ctx, operation, req, strings.Join(replies, "\n\n"), nil)
+
+	for i, reply := range replies {
+		reply, filter, err := filterOutput(ctx, provider, operation, reply)
+		if err != nil {
+			return ChatResponse{}, huma.Error502BadGateway("Output filter failed", err)
+		}
+		if filter != nil && filter.Action == outputBlock {
+			return ChatResponse{}, errOutputBlocked(filter)
+		}
+		if i == 0 {
+			out.Reply, out.Filter = reply, filter
+		}
+		if len(replies) > 1 {
+			out.Replies = append(out.Replies, reply)
+		}
+	}
+	return out, nil
 }
 
 func registerChatEndpoint(api huma.API) {
-	huma.Register(api, huma.Operation{
+	huma.Register(api, withRoutePolicy(huma.Operation{
 		OperationID: "chat",
 		Method:      http.MethodPost,
 		Path:        "/chat",
 		Summary:     "Send a message to OpenAI",
 		Description: "Send a message to OpenAI and get a response using the configured API key",
-	}, func(ctx context.Context, input *struct {
-		Body ChatRequest
+	}, RoutePolicy{RateClass: "chat", Budgeted: true}), func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer tenant token; required when terms.required is set"`
+		Body          ChatRequest
 	}) (*struct {
 		Body ChatResponse
 	}, error) {
-		if openaiClient == nil {
-			return nil, huma.Error400BadRequest("OpenAI client not configured")
+		provider, err := chatProvider(ctx)
+		if err != nil {
+			return nil, err
 		}
-
-		resp, err := openaiClient.CreateChatCompletion(
-			ctx,
-			openai.ChatCompletionRequest{
-				Model: openai.GPT3Dot5Turbo,
-				Messages: []openai.ChatCompletionMessage{
-					{
-						Role:    openai.ChatMessageRoleUser,
-						Content: input.Body.Message,
-					},
-				},
-			},
-		)
+		if err := requireTermsAccepted(ctx, input.Authorization); err != nil {
+			return nil, err
+		}
+		ctx, requested := rolloutChatModel(ctx, chatModel(input.Body))
+		model, err := policyModel(ctx, requested)
 		if err != nil {
-			return nil, huma.Error500InternalServerError("Failed to get OpenAI response", err)
+			return nil, err
 		}
-
-		reply := "No response"
-		if len(resp.Choices) > 0 {
-			reply = resp.Choices[0].Message.Content
+		if err := checkSystemPrompt(input.Body); err != nil {
+			return nil, err
+		}
+		if err := applyTemplate(ctx, &input.Body); err != nil {
+			return nil, err
+		}
+		if err := checkChatTools(input.Body); err != nil {
+			return nil, err
+		}
+		caller := objectCaller(input.Authorization)
+		if err := attachImages(ctx, &input.Body, model, caller); err != nil {
+			return nil, err
 		}
 
+		ctx = withToolCaller(ctx, caller)
+		resp, err := completeChat(ctx, provider, "chat", input.Body, model)
+		if err != nil {
+			return nil, err
+		}
 		return &struct {
 			Body ChatResponse
 		}{
-			Body: ChatResponse{Reply: reply},
+			Body: resp,
 		}, nil
 	})
 }
@@ -187,7 +590,11 @@ func registerFileUploadEndpoint(api huma.API) {
 	}) (*struct {
 		Body FileUploadResponse
 	}, error) {
-		if minioClient == nil {
+		if err := checkBucketAccess(input.Body.BucketName); err != nil {
+			return nil, err
+		}
+		client, err := services.MinIO()
+		if err != nil {
 			return &struct {
 				Body FileUploadResponse
 			}{
@@ -199,7 +606,7 @@ func registerFileUploadEndpoint(api huma.API) {
 		}
 
 		// Create bucket if it doesn't exist
-		exists, err := minioClient.BucketExists(ctx, input.Body.BucketName)
+		exists, err := client.BucketExists(ctx, input.Body.BucketName)
 		if err != nil {
 			return &struct {
 				Body FileUploadResponse
@@ -212,7 +619,7 @@ func registerFileUploadEndpoint(api huma.API) {
 		}
 
 		if !exists {
-			err = minioClient.MakeBucket(ctx, input.Body.BucketName, minio.MakeBucketOptions{})
+			err = client.MakeBucket(ctx, input.Body.BucketName, minio.MakeBucketOptions{})
 			if err != nil {
 				return &struct {
 					Body FileUploadResponse
@@ -227,7 +634,7 @@ func registerFileUploadEndpoint(api huma.API) {
 
 		// Upload file
 		reader := strings.NewReader(input.Body.Content)
-		_, err = minioClient.PutObject(ctx, input.Body.BucketName, input.Body.FileName, reader, int64(len(input.Body.Content)), minio.PutObjectOptions{
+		_, err = client.PutObject(ctx, input.Body.BucketName, input.Body.FileName, reader, int64(len(input.Body.Content)), minio.PutObjectOptions{
 			ContentType: "text/plain",
 		})
 		if err != nil {
@@ -240,6 +647,7 @@ func registerFileUploadEndpoint(api huma.API) {
 				},
 			}, nil
 		}
+		usage.recordUpload(time.Now(), int64(len(input.Body.Content)))
 
 		return &struct {
 			Body FileUploadResponse
@@ -252,7 +660,52 @@ func registerFileUploadEndpoint(api huma.API) {
 	})
 }
 
+type HealthServices struct {
+	OpenAI bool `json:"openai" doc:"Whether an OpenAI client is configured"`
+	MinIO  bool `json:"minio" doc:"Whether a MinIO client is configured"`
+}
+
+type HealthConfig struct {
+	Port     string `json:"port" doc:"HTTP port"`
+	MinIOURL string `json:"minio_url" doc:"MinIO endpoint"`
+}
+
+type HealthResponse struct {
+	Status         string                `json:"status" enum:"healthy,degraded" doc:"degraded when a provider key failed its last probe or a MinIO endpoint is down"`
+	Services       HealthServices        `json:"services" doc:"Configured clients"`
+	Config         *HealthConfig         `json:"config" doc:"Settings the instance runs with"`
+	Keys           []KeyStatus           `json:"keys,omitempty" doc:"Latest probe of each provider key"`
+	MinIOEndpoints []MinIOEndpointStatus `json:"minio_endpoints,omitempty" doc:"Latest check of each MinIO endpoint, when failover is configured"`
+}
+
+// healthStatus builds the health response around the static config part.
+// Monitoring scrapes it every few seconds, so it allocates nothing while no
+// key has been probed.
+func healthStatus(static *HealthConfig) HealthResponse {
+	h := HealthResponse{
+		Status:         "healthy",
+		Services:       services.Status(),
+		Config:         static,
+		Keys:           keyStatuses(),
+		MinIOEndpoints: minioEndpointStatuses(),
+	}
+	for _, k := range h.Keys {
+		if !k.Valid {
+			h.Status = "degraded"
+		}
+	}
+	for _, e := range h.MinIOEndpoints {
+		if !e.Healthy {
+			h.Status = "degraded"
+		}
+	}
+	return h
+}
+
 func registerHealthEndpoint(api huma.API) {
+	// The config does not change while the server runs
+	static := &HealthConfig{Port: config.Server.Port, MinIOURL: config.MinIO.URL}
+
 	huma.Register(api, huma.Operation{
 		OperationID: "health",
 		Method:      http.MethodGet,
@@ -260,24 +713,10 @@ func registerHealthEndpoint(api huma.API) {
 		Summary:     "Health check endpoint",
 		Description: "Check the health status of the application and its dependencies",
 	}, func(ctx context.Context, input *struct{}) (*struct {
-		Body map[string]interface{}
+		Body HealthResponse
 	}, error) {
-		status := map[string]interface{}{
-			"status": "healthy",
-			"services": map[string]bool{
-				"openai": openaiClient != nil,
-				"minio":  minioClient != nil,
-			},
-			"config": map[string]string{
-				"port":      config.Port,
-				"minio_url": config.MinIOURL,
-			},
-		}
-
 		return &struct {
-			Body map[string]interface{}
-		}{
-			Body: status,
-		}, nil
+			Body HealthResponse
+		}{Body: healthStatus(static)}, nil
 	})
 }
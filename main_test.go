@@ -3,54 +3,57 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/danielgtaylor/huma/v2"
 	"github.com/danielgtaylor/huma/v2/adapters/humachi"
 	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
 	"github.com/spf13/viper"
 )
 
 func TestInitConfig(t *testing.T) {
 	// Save original values
-	originalPort := viper.GetString("port")
-	originalMinIOURL := viper.GetString("minio_url")
+	originalPort := viper.GetString("server.port")
+	originalMinIOURL := viper.GetString("minio.url")
 
 	// Test default configuration
 	viper.Reset()
 	initConfig()
 
-	if config.Port != "8080" {
-		t.Errorf("Expected default port to be 8080, got %s", config.Port)
+	if config.Server.Port != "8080" {
+		t.Errorf("Expected default port to be 8080, got %s", config.Server.Port)
 	}
 
-	if config.MinIOURL != "localhost:9000" {
-		t.Errorf("Expected default MinIO URL to be localhost:9000, got %s", config.MinIOURL)
+	if config.MinIO.URL != "localhost:9000" {
+		t.Errorf("Expected default MinIO URL to be localhost:9000, got %s", config.MinIO.URL)
 	}
 
 	// Test environment variable override
-	os.Setenv("APP_PORT", "9090")
+	os.Setenv("APP_SERVER_PORT", "9090")
 	os.Setenv("APP_MINIO_URL", "test.example.com:9000")
 
 	viper.Reset()
 	initConfig()
 
-	if config.Port != "9090" {
-		t.Errorf("Expected port from env var to be 9090, got %s", config.Port)
+	if config.Server.Port != "9090" {
+		t.Errorf("Expected port from env var to be 9090, got %s", config.Server.Port)
 	}
 
-	if config.MinIOURL != "test.example.com:9000" {
-		t.Errorf("Expected MinIO URL from env var to be test.example.com:9000, got %s", config.MinIOURL)
+	if config.MinIO.URL != "test.example.com:9000" {
+		t.Errorf("Expected MinIO URL from env var to be test.example.com:9000, got %s", config.MinIO.URL)
 	}
 
 	// Cleanup
-	os.Unsetenv("APP_PORT")
+	os.Unsetenv("APP_SERVER_PORT")
 	os.Unsetenv("APP_MINIO_URL")
-	viper.Set("port", originalPort)
-	viper.Set("minio_url", originalMinIOURL)
+	viper.Set("server.port", originalPort)
+	viper.Set("minio.url", originalMinIOURL)
 }
 
 func TestHealthEndpoint(t *testing.T) {
@@ -117,7 +120,7 @@ func TestChatEndpointWithoutClient(t *testing.T) {
 	initConfig()
 
 	// Ensure OpenAI client is not initialized
-	openaiClient = nil
+	services.SetOpenAI(nil)
 
 	// Create test router and API
 	router := chi.NewMux()
@@ -136,9 +139,9 @@ func TestChatEndpointWithoutClient(t *testing.T) {
 	// Execute request
 	router.ServeHTTP(w, req)
 
-	// Should return 400 Bad Request since OpenAI client is not configured
-	if w.Code != http.StatusBadRequest {
-		t.Errorf("Expected status code 400, got %d", w.Code)
+	// Should return 503 Service Unavailable since OpenAI client is not configured
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code 503, got %d", w.Code)
 	}
 }
 
@@ -148,7 +151,7 @@ func TestFileUploadEndpointWithoutClient(t *testing.T) {
 	initConfig()
 
 	// Ensure MinIO client is not initialized
-	minioClient = nil
+	services.SetMinIO(nil)
 
 	// Create test router and API
 	router := chi.NewMux()
@@ -194,23 +197,25 @@ func TestFileUploadEndpointWithoutClient(t *testing.T) {
 
 func TestConfigStructValidation(t *testing.T) {
 	testConfig := Config{
-		Port:        "8080",
-		OpenAIKey:   "test-key",
-		MinIOURL:    "localhost:9000",
-		MinIOKey:    "test-access-key",
-		MinIOSecret: "test-secret-key",
+		Server: ServerConfig{Port: "8080"},
+		OpenAI: OpenAIConfig{Key: "test-key"},
+		MinIO: MinIOConfig{
+			URL:    "localhost:9000",
+			Key:    "test-access-key",
+			Secret: "test-secret-key",
+		},
 	}
 
-	if testConfig.Port != "8080" {
-		t.Errorf("Expected port to be 8080, got %s", testConfig.Port)
+	if testConfig.Server.Port != "8080" {
+		t.Errorf("Expected port to be 8080, got %s", testConfig.Server.Port)
 	}
 
-	if testConfig.OpenAIKey != "test-key" {
-		t.Errorf("Expected OpenAI key to be test-key, got %s", testConfig.OpenAIKey)
+	if testConfig.OpenAI.Key != "test-key" {
+		t.Errorf("Expected OpenAI key to be test-key, got %s", testConfig.OpenAI.Key)
 	}
 
-	if testConfig.MinIOURL != "localhost:9000" {
-		t.Errorf("Expected MinIO URL to be localhost:9000, got %s", testConfig.MinIOURL)
+	if testConfig.MinIO.URL != "localhost:9000" {
+		t.Errorf("Expected MinIO URL to be localhost:9000, got %s", testConfig.MinIO.URL)
 	}
 }
 
@@ -249,3 +254,147 @@ func TestChatRequestResponseStructs(t *testing.T) {
 		t.Errorf("Expected message to be 'Upload successful', got %s", uploadResp.Message)
 	}
 }
+
+func TestChatSamplingParameters(t *testing.T) {
+	viper.Reset()
+	initConfig()
+
+	var sent map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = nil
+		json.NewDecoder(r.Body).Decode(&sent)
+		n, _ := sent["n"].(float64)
+		resp := openai.ChatCompletionResponse{}
+		for i := range max(int(n), 1) {
+			resp.Choices = append(resp.Choices, openai.ChatCompletionChoice{Index: i, Message: openai.ChatCompletionMessage{Content: fmt.Sprintf("reply %d", i)}})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+	cfg := openai.DefaultConfig("test")
+	cfg.BaseURL = srv.URL + "/v1"
+	services.SetOpenAI(openai.NewClientWithConfig(cfg))
+	defer services.SetOpenAI(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerChatEndpoint(api)
+	registerChatStreamEndpoint(api)
+	call := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := call("/chat", `{"message": "Hi", "temperature": 0, "top_p": 0.5, "max_tokens": 100, "n": 2}`)
+	var resp ChatResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Reply != "reply 0" || len(resp.Replies) != 2 || resp.Replies[1] != "reply 1" {
+		t.Errorf("Expected two replies, got %d: %s", w.Code, w.Body.String())
+	}
+	if resp.Model != "gpt-3.5-turbo" || resp.Usage.TotalTokens != 0 {
+		t.Errorf("Expected the requested model when OpenAI reports none, got %s %+v", resp.Model, resp.Usage)
+	}
+	if temp, ok := sent["temperature"].(float64); !ok || temp > 1e-6 {
+		t.Errorf("Expected a temperature of 0 to be sent, got %v", sent["temperature"])
+	}
+	if sent["top_p"] != 0.5 || sent["max_tokens"] != 100.0 || sent["n"] != 2.0 {
+		t.Errorf("Expected the sampling parameters to be sent, got %v", sent)
+	}
+
+	call("/chat", `{"message": "Hi"}`)
+	for _, key := range []string{"temperature", "top_p", "max_tokens", "n"} {
+		if _, ok := sent[key]; ok {
+			t.Errorf("Expected %s to be left to OpenAI, got %v", key, sent)
+		}
+	}
+
+	for _, body := range []string{
+		`{"message": "Hi", "temperature": 2.5}`,
+		`{"message": "Hi", "top_p": 1.5}`,
+		`{"message": "Hi", "max_tokens": 0}`,
+		`{"message": "Hi", "n": 11}`,
+	} {
+		if w := call("/chat", body); w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected %s to be rejected, got %d", body, w.Code)
+		}
+	}
+	if w := call("/chat/stream", `{"message": "Hi", "n": 2}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected streaming several replies to be rejected, got %d", w.Code)
+	}
+}
+
+func TestChatSystemPrompt(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.OpenAI.SystemPrompt = "Answer in French."
+
+	var sent openai.ChatCompletionRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&sent)
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "Bonjour"}}}})
+	}))
+	defer srv.Close()
+	cfg := openai.DefaultConfig("test")
+	cfg.BaseURL = srv.URL + "/v1"
+	services.SetOpenAI(openai.NewClientWithConfig(cfg))
+	defer services.SetOpenAI(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerChatEndpoint(api)
+	call := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	call(`{"message": "Hi", "history": [{"role": "user", "content": "Hello"}, {"role": "assistant", "content": "Salut"}]}`)
+	if len(sent.Messages) != 4 || sent.Messages[0].Role != openai.ChatMessageRoleSystem || sent.Messages[0].Content != "Answer in French." || sent.Messages[3].Content != "Hi" {
+		t.Errorf("Expected the configured system prompt first, got %+v", sent.Messages)
+	}
+
+	call(`{"message": "Hi", "system_prompt": "Answer in German."}`)
+	if len(sent.Messages) != 2 || sent.Messages[0].Content != "Answer in German." {
+		t.Errorf("Expected the request's system prompt to replace the configured one, got %+v", sent.Messages)
+	}
+
+	config.OpenAI.AllowSystemPromptOverride = false
+	if w := call(`{"message": "Hi", "system_prompt": "Answer in German."}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected the override to be refused, got %d", w.Code)
+	}
+
+	config.OpenAI.SystemPrompt = ""
+	call(`{"message": "Hi"}`)
+	if len(sent.Messages) != 1 || sent.Messages[0].Role != openai.ChatMessageRoleUser {
+		t.Errorf("Expected no system message, got %+v", sent.Messages)
+	}
+}
+
+func TestHealthStatusDoesNotAllocate(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	keyHealth.statuses = nil
+	static := &HealthConfig{Port: "8080", MinIOURL: "localhost:9000"}
+	allocs := testing.AllocsPerRun(100, func() {
+		healthStatus(static)
+	})
+	if allocs != 0 {
+		t.Errorf("Expected healthStatus not to allocate, got %v allocations", allocs)
+	}
+}
+
+func BenchmarkHealthEndpoint(b *testing.B) {
+	viper.Reset()
+	initConfig()
+	router := chi.NewMux()
+	registerHealthEndpoint(humachi.New(router, huma.DefaultConfig("Test API", "1.0.0")))
+	b.ReportAllocs()
+	for b.Loop() {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	}
+}
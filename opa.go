@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// OPAConfig delegates authorization decisions to Open Policy Agent.
+type OPAConfig struct {
+	URL            string   `mapstructure:"url" doc:"Base URL of the OPA server, e.g. http://localhost:8181; empty disables OPA"`
+	Decision       string   `mapstructure:"decision" doc:"Path of the rule that decides, under /v1/data"`
+	TimeoutMS      int      `mapstructure:"timeout_ms" doc:"Longest wait for a decision"`
+	FailOpen       bool     `mapstructure:"fail_open" doc:"Allow requests when OPA cannot be reached, instead of refusing them"`
+	SkipOperations []string `mapstructure:"skip_operations" doc:"Operations that are never sent to OPA"`
+}
+
+func (c OPAConfig) Validate() error {
+	if c.URL == "" {
+		return nil
+	}
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("opa.url: %q is not an http or https URL", c.URL)
+	}
+	if strings.Trim(c.Decision, "/") == "" {
+		return errors.New("opa.decision is required")
+	}
+	if c.TimeoutMS <= 0 {
+		return errors.New("opa.timeout_ms must be positive")
+	}
+	return nil
+}
+
+// OPAInput is the input document of a decision: the operation and the
+// resource it acts on, and who calls it.
+type OPAInput struct {
+	Operation string              `json:"operation"`
+	Method    string              `json:"method"`
+	Path      string              `json:"path"`
+	Params    map[string]string   `json:"params"`
+	Query     map[string][]string `json:"query"`
+	Caller    OPACaller           `json:"caller"`
+}
+
+type OPACaller struct {
+	Kind   string `json:"kind"`
+	Tenant string `json:"tenant,omitempty"`
+	Admin  string `json:"admin,omitempty"`
+}
+
+// opaDecision is a rule's result: either a boolean or an object with allow
+// and an optional reason.
+type opaDecision struct {
+	Allow  bool
+	Reason string
+}
+
+func (d *opaDecision) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &d.Allow); err == nil {
+		return nil
+	}
+	var obj struct {
+		Allow  bool   `json:"allow"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return fmt.Errorf("decision is neither a boolean nor an object with allow: %s", data)
+	}
+	d.Allow, d.Reason = obj.Allow, obj.Reason
+	return nil
+}
+
+var opaClient = &http.Client{}
+
+// opaCaller describes the caller of a request for policies.
+func opaCaller(authorization string) OPACaller {
+	if name, ok := adminName(authorization); ok {
+		return OPACaller{Kind: "admin", Admin: name}
+	}
+	caller := objectCaller(authorization)
+	switch {
+	case caller.admin:
+		return OPACaller{Kind: "admin"}
+	case caller.tenant != "":
+		return OPACaller{Kind: "tenant", Tenant: caller.tenant}
+	}
+	return OPACaller{Kind: "anonymous"}
+}
+
+// queryOPA asks OPA for the decision on input. An undefined decision, from
+// a policy without a matching rule, denies.
+func queryOPA(ctx context.Context, input OPAInput) (opaDecision, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(config.OPA.TimeoutMS)*time.Millisecond)
+	defer cancel()
+	body, err := json.Marshal(map[string]any{"input": input})
+	if err != nil {
+		return opaDecision{}, err
+	}
+	endpoint := strings.TrimSuffix(config.OPA.URL, "/") + "/v1/data/" + strings.Trim(config.OPA.Decision, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return opaDecision{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := opaClient.Do(req)
+	if err != nil {
+		return opaDecision{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return opaDecision{}, fmt.Errorf("OPA returned %s", resp.Status)
+	}
+	var out struct {
+		Result *opaDecision `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return opaDecision{}, err
+	}
+	if out.Result == nil {
+		return opaDecision{Reason: "the decision " + config.OPA.Decision + " is undefined"}, nil
+	}
+	return *out.Result, nil
+}
+
+// opaMiddleware refuses operations OPA does not allow.
+func opaMiddleware(ctx huma.Context, next func(huma.Context)) {
+	op := ctx.Operation()
+	if config.OPA.URL == "" || slices.Contains(config.OPA.SkipOperations, op.OperationID) {
+		next(ctx)
+		return
+	}
+	u := ctx.URL()
+	input := OPAInput{
+		Operation: op.OperationID,
+		Method:    ctx.Method(),
+		Path:      u.Path,
+		Params:    map[string]string{},
+		Query:     u.Query(),
+		Caller:    opaCaller(ctx.Header("Authorization")),
+	}
+	for _, p := range op.Parameters {
+		if p.In == "path" {
+			input.Params[p.Name] = ctx.Param(p.Name)
+		}
+	}
+
+	decision, err := queryOPA(ctx.Context(), input)
+	if err != nil {
+		if config.OPA.FailOpen {
+			log.Printf("OPA decision failed, allowing %s: %v", op.OperationID, err)
+			next(ctx)
+			return
+		}
+		writeHumaError(ctx, huma.Error503ServiceUnavailable("Authorization policy is unavailable", err))
+		return
+	}
+	if !decision.Allow {
+		msg := fmt.Sprintf("Policy does not allow %s", op.OperationID)
+		if decision.Reason != "" {
+			msg += ": " + decision.Reason
+		}
+		audit(ctx.Context(), AuditEvent{Action: "opa.denied", Tenant: input.Caller.Tenant, Operation: op.OperationID, Detail: decision.Reason})
+		writeHumaError(ctx, codedError(http.StatusForbidden, "ERR_POLICY_DENIED", msg))
+		return
+	}
+	next(ctx)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestOPAMiddleware(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "admin-token"
+	config.Storage.Tenants = []StorageTenantConfig{{Name: "acme", Token: "acme-token", Bucket: "tenants"}}
+
+	// The policy lets admins do anything, tenants read their own bucket, and
+	// has no rule for anonymous callers
+	var inputs []OPAInput
+	opa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/data/test_renovate/authz/allow" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body struct{ Input OPAInput }
+		json.NewDecoder(r.Body).Decode(&body)
+		inputs = append(inputs, body.Input)
+		switch in := body.Input; {
+		case in.Caller.Kind == "admin":
+			fmt.Fprint(w, `{"result": true}`)
+		case in.Caller.Kind == "tenant" && in.Params["bucket"] == "tenants":
+			fmt.Fprint(w, `{"result": {"allow": true}}`)
+		case in.Caller.Kind == "tenant":
+			fmt.Fprint(w, `{"result": {"allow": false, "reason": "tenants only use their own bucket"}}`)
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	defer opa.Close()
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	api.UseMiddleware(opaMiddleware)
+	huma.Register(api, huma.Operation{OperationID: "read-bucket", Method: http.MethodGet, Path: "/buckets/{bucket}"}, func(ctx context.Context, input *struct {
+		Bucket string `path:"bucket"`
+	}) (*struct{}, error) {
+		return nil, nil
+	})
+	huma.Register(api, huma.Operation{OperationID: "health", Method: http.MethodGet, Path: "/health"}, func(ctx context.Context, input *struct{}) (*struct{}, error) {
+		return nil, nil
+	})
+	call := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Disabled, nothing is asked
+	if w := call("/buckets/other", ""); w.Code != http.StatusNoContent || len(inputs) != 0 {
+		t.Errorf("Expected requests to pass without OPA, got %d", w.Code)
+	}
+
+	config.OPA.URL = opa.URL
+	defer func() { config.OPA.URL = "" }()
+	if w := call("/buckets/tenants?limit=5", "acme-token"); w.Code != http.StatusNoContent {
+		t.Errorf("Expected the tenant's own bucket to be allowed, got %d: %s", w.Code, w.Body.String())
+	}
+	if in := inputs[0]; in.Operation != "read-bucket" || in.Method != http.MethodGet || in.Params["bucket"] != "tenants" || in.Query["limit"][0] != "5" || in.Caller != (OPACaller{Kind: "tenant", Tenant: "acme"}) {
+		t.Errorf("Unexpected input: %+v", in)
+	}
+	if w := call("/buckets/other", "acme-token"); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "ERR_POLICY_DENIED") || !strings.Contains(w.Body.String(), "own bucket") {
+		t.Errorf("Expected another bucket to be denied with the reason, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call("/buckets/other", "admin-token"); w.Code != http.StatusNoContent {
+		t.Errorf("Expected the admin to be allowed, got %d", w.Code)
+	}
+	if w := call("/buckets/other", ""); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "undefined") {
+		t.Errorf("Expected an undefined decision to deny, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call("/health", ""); w.Code != http.StatusNoContent || len(inputs) != 4 {
+		t.Errorf("Expected health checks to skip OPA, got %d after %d decisions", w.Code, len(inputs))
+	}
+
+	// Unreachable, requests fail closed unless configured otherwise
+	config.OPA.Decision = "missing"
+	if w := call("/buckets/tenants", "acme-token"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without a decision, got %d", w.Code)
+	}
+	config.OPA.FailOpen = true
+	if w := call("/buckets/tenants", "acme-token"); w.Code != http.StatusNoContent {
+		t.Errorf("Expected fail_open to allow, got %d", w.Code)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/sashabaranov/go-openai"
+)
+
+// openAIKeyHeader carries the OpenAI key a request is billed to instead of
+// the configured one, when openai.allow_key_header is set.
+const openAIKeyHeader = "X-OpenAI-Key"
+
+// maxOpenAIKeyLength bounds the keys accepted in openAIKeyHeader.
+const maxOpenAIKeyLength = 256
+
+type requestOpenAIClientKey struct{}
+
+// openAIKeyMiddleware gives requests sending their own OpenAI key a client
+// signing with it. The key is never logged or stored.
+func openAIKeyMiddleware(ctx huma.Context, next func(huma.Context)) {
+	key := ctx.Header(openAIKeyHeader)
+	if key == "" || config.OpenAI.Fake {
+		next(ctx)
+		return
+	}
+	if !config.OpenAI.AllowKeyHeader {
+		writeHumaError(ctx, huma.Error403Forbidden(openAIKeyHeader+" is not accepted by this service"))
+		return
+	}
+	if len(key) > maxOpenAIKeyLength || strings.ContainsFunc(key, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) {
+		writeHumaError(ctx, huma.Error400BadRequest(openAIKeyHeader+" is not a valid API key"))
+		return
+	}
+	next(huma.WithContext(ctx, context.WithValue(ctx.Context(), requestOpenAIClientKey{}, newKeyedOpenAIClient(key))))
+}
+
+// newKeyedOpenAIClient creates a client signing with key. It shares the
+// connection pool of the configured client, so creating one per request is
+// cheap.
+func newKeyedOpenAIClient(key string) *openai.Client {
+	cfg := openai.DefaultConfig(key)
+	cfg.HTTPClient = &http.Client{Transport: newOpenAITransport()}
+	return openai.NewClientWithConfig(cfg)
+}
+
+// openAIClient returns the client the request in ctx calls OpenAI with: one
+// signing with the request's own key, or the configured client.
+func openAIClient(ctx context.Context) (*openai.Client, error) {
+	if c, ok := ctx.Value(requestOpenAIClientKey{}).(*openai.Client); ok {
+		return c, nil
+	}
+	return services.OpenAI()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+// openAIRecorder answers OpenAI calls and keeps the key of the last one.
+type openAIRecorder struct{ authorization string }
+
+func (o *openAIRecorder) RoundTrip(r *http.Request) (*http.Response, error) {
+	o.authorization = r.Header.Get("Authorization")
+	w := httptest.NewRecorder()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "Hello"}}}})
+	return w.Result(), nil
+}
+
+func TestOpenAIKeyHeader(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	services.SetOpenAI(nil)
+	upstream := &openAIRecorder{}
+	defer func(t http.RoundTripper) { http.DefaultTransport = t }(http.DefaultTransport)
+	http.DefaultTransport = upstream
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	api.UseMiddleware(openAIKeyMiddleware)
+	registerChatEndpoint(api)
+	chat := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"message": "Hi"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(openAIKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := chat("sk-team"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the header to be rejected unless allowed, got %d", w.Code)
+	}
+
+	config.OpenAI.AllowKeyHeader = true
+	if w := chat("sk-team"); w.Code != http.StatusOK {
+		t.Fatalf("Expected the chat to succeed with the request's key, got %d: %s", w.Code, w.Body.String())
+	}
+	if upstream.authorization != "Bearer sk-team" {
+		t.Errorf("Expected OpenAI to be called with the request's key, got %q", upstream.authorization)
+	}
+	if w := chat("sk-team\r\nX-Injected: 1"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a malformed key to be rejected, got %d", w.Code)
+	}
+	if w := chat(""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected requests without a key to need the configured one, got %d", w.Code)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// OpenAIRetryConfig retries chat completions that fail transiently.
+type OpenAIRetryConfig struct {
+	Attempts    int `mapstructure:"attempts" doc:"Most times a chat completion is sent; 1 disables retries"`
+	BaseDelayMS int `mapstructure:"base_delay_ms" doc:"Backoff before the first retry, doubling for every further one"`
+	MaxDelayMS  int `mapstructure:"max_delay_ms" doc:"Longest backoff between two attempts"`
+}
+
+func (c OpenAIRetryConfig) Validate() error {
+	if c.Attempts < 1 {
+		return errors.New("openai_retry.attempts must be at least 1")
+	}
+	if c.BaseDelayMS < 0 || c.MaxDelayMS < c.BaseDelayMS {
+		return errors.New("openai_retry.base_delay_ms must not be negative or above openai_retry.max_delay_ms")
+	}
+	return nil
+}
+
+// isTransientOpenAIError reports whether a failed call may succeed when
+// repeated: rate limits, server errors, timeouts and dropped connections.
+// Errors of the request itself, such as a bad model or key, are not.
+func isTransientOpenAIError(err error) bool {
+	switch openAIStatusCode(err) {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func (c OpenAIRetryConfig) policy() RetryPolicy {
+	return RetryPolicy{
+		Attempts:  c.Attempts,
+		BaseDelay: time.Duration(c.BaseDelayMS) * time.Millisecond,
+		MaxDelay:  time.Duration(c.MaxDelayMS) * time.Millisecond,
+	}
+}
+
+// retryDelay returns the jittered backoff before retry n under
+// openai_retry.
+func retryDelay(n int) time.Duration {
+	return config.OpenAIRetry.policy().delay(n)
+}
+
+// createChatCompletion sends a chat completion, retrying transient failures
+// with jittered exponential backoff as the operation's retry policy, or
+// openai_retry, allows. It gives up early when ctx ends, returning the last
+// failure.
+func createChatCompletion(ctx context.Context, provider ChatProvider, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	policy := retryPolicyFor(ctx)
+	for attempt := 1; ; attempt++ {
+		resp, err := provider.CreateChatCompletion(ctx, req)
+		if err == nil || attempt >= policy.Attempts || !isTransientOpenAIError(err) || ctx.Err() != nil {
+			return resp, err
+		}
+		delay := policy.delay(attempt)
+		log.Printf("OpenAI chat completion failed, retrying in %s (attempt %d of %d): %v", delay, attempt, policy.Attempts, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, err
+		}
+	}
+}
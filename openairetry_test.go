@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+func TestCreateChatCompletionRetries(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.OpenAIRetry.BaseDelayMS = 1
+	config.OpenAIRetry.MaxDelayMS = 5
+
+	var calls int
+	failures, status := 0, http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= failures {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"error": {"message": "try again", "type": "server_error"}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "Hi"}}}})
+	}))
+	defer srv.Close()
+	client := newTestOpenAIClient(srv.URL)
+	send := func(n int, code int) error {
+		calls, failures, status = 0, n, code
+		_, err := createChatCompletion(context.Background(), client, openai.ChatCompletionRequest{Model: "gpt-3.5-turbo"})
+		return err
+	}
+
+	if err := send(2, http.StatusServiceUnavailable); err != nil || calls != 3 {
+		t.Errorf("Expected two retries to succeed, got %d calls: %v", calls, err)
+	}
+	if err := send(3, http.StatusTooManyRequests); openAIStatusCode(err) != http.StatusTooManyRequests || calls != 3 {
+		t.Errorf("Expected the last failure after every attempt, got %d calls: %v", calls, err)
+	}
+	if err := send(1, http.StatusBadRequest); err == nil || calls != 1 {
+		t.Errorf("Expected a bad request not to be retried, got %d calls", calls)
+	}
+	config.OpenAIRetry.Attempts = 1
+	if err := send(1, http.StatusBadGateway); err == nil || calls != 1 {
+		t.Errorf("Expected no retries with one attempt, got %d calls", calls)
+	}
+
+	config.OpenAIRetry.Attempts = 3
+	config.OpenAIRetry.BaseDelayMS, config.OpenAIRetry.MaxDelayMS = 10000, 10000
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	calls, failures, status = 0, 3, http.StatusInternalServerError
+	start := time.Now()
+	if _, err := createChatCompletion(ctx, client, openai.ChatCompletionRequest{Model: "gpt-3.5-turbo"}); err == nil || calls != 1 || time.Since(start) > 5*time.Second {
+		t.Errorf("Expected the backoff to end with the context, got %d calls: %v", calls, err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	for n, ceiling := range map[int]time.Duration{1: 500 * time.Millisecond, 3: 2 * time.Second, 10: 8 * time.Second, 100: 8 * time.Second} {
+		for range 20 {
+			if d := retryDelay(n); d <= 0 || d > ceiling {
+				t.Errorf("Expected retry %d to wait up to %s, got %s", n, ceiling, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Output filter actions, weakest first.
+const (
+	outputAllow     = "allow"
+	outputWatermark = "watermark"
+	outputRedact    = "redact"
+	outputBlock     = "block"
+)
+
+var outputActions = []string{outputAllow, outputWatermark, outputRedact, outputBlock}
+
+// OutputFilterConfig filters model replies before they are returned or
+// stored.
+type OutputFilterConfig struct {
+	Enabled          bool         `mapstructure:"enabled" doc:"Filter chat replies and edited documents before returning or storing them"`
+	Moderation       bool         `mapstructure:"moderation" doc:"Check every reply with the OpenAI moderation API"`
+	ModerationAction string       `mapstructure:"moderation_action" doc:"What to do with replies moderation flags: block or watermark"`
+	Watermark        string       `mapstructure:"watermark" doc:"Text appended to replies a watermark rule matched"`
+	Rules            []OutputRule `mapstructure:"rules" doc:"Pattern and keyword rules applied in order"`
+}
+
+// OutputRule matches a regular expression or keywords in a reply.
+type OutputRule struct {
+	Name        string   `mapstructure:"name" doc:"Rule name recorded in filter decisions"`
+	Pattern     string   `mapstructure:"pattern" doc:"Regular expression to look for"`
+	Keywords    []string `mapstructure:"keywords" doc:"Words to look for, case-insensitively"`
+	Action      string   `mapstructure:"action" doc:"block, redact or watermark"`
+	Replacement string   `mapstructure:"replacement" doc:"Text that replaces redacted matches; defaults to [REDACTED]"`
+}
+
+func (c OutputFilterConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	var errs []error
+	if c.Moderation && c.ModerationAction != outputBlock && c.ModerationAction != outputWatermark {
+		errs = append(errs, fmt.Errorf("output_filter.moderation_action must be block or watermark, not %q", c.ModerationAction))
+	}
+	for i, r := range c.Rules {
+		if r.Name == "" {
+			errs = append(errs, fmt.Errorf("output_filter.rules %d needs a name", i))
+			continue
+		}
+		if r.Pattern == "" && len(r.Keywords) == 0 {
+			errs = append(errs, fmt.Errorf("output_filter.rules %s needs a pattern or keywords", r.Name))
+		}
+		if _, err := compileOutputRule(r); err != nil {
+			errs = append(errs, fmt.Errorf("output_filter.rules %s: %w", r.Name, err))
+		}
+		if !slices.Contains(outputActions[1:], r.Action) {
+			errs = append(errs, fmt.Errorf("output_filter.rules %s: action must be block, redact or watermark, not %q", r.Name, r.Action))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OutputDecision is what one rule decided about a reply.
+type OutputDecision struct {
+	Rule       string   `json:"rule" doc:"Rule name, or moderation"`
+	Action     string   `json:"action" enum:"block,redact,watermark" doc:"Action the rule took"`
+	Matches    int      `json:"matches,omitempty" doc:"Number of matches"`
+	Categories []string `json:"categories,omitempty" doc:"Moderation categories the reply was flagged for"`
+}
+
+// OutputFilterResult records the filter's decisions about one reply.
+type OutputFilterResult struct {
+	Action    string           `json:"action" enum:"allow,watermark,redact,block" doc:"Strongest action taken"`
+	Decisions []OutputDecision `json:"decisions" doc:"Rules that matched, in the order they ran"`
+}
+
+type compiledOutputRule struct {
+	OutputRule
+	re *regexp.Regexp
+}
+
+// compileOutputRule joins a rule's pattern and keywords into one
+// expression.
+func compileOutputRule(r OutputRule) (*compiledOutputRule, error) {
+	var alternatives []string
+	if r.Pattern != "" {
+		alternatives = append(alternatives, "(?:"+r.Pattern+")")
+	}
+	if len(r.Keywords) > 0 {
+		words := make([]string, len(r.Keywords))
+		for i, k := range r.Keywords {
+			words[i] = regexp.QuoteMeta(k)
+		}
+		alternatives = append(alternatives, `(?i:\b(?:`+strings.Join(words, "|")+`)\b)`)
+	}
+	re, err := regexp.Compile(strings.Join(alternatives, "|"))
+	if err != nil {
+		return nil, err
+	}
+	if r.Replacement == "" {
+		r.Replacement = defaultRedaction
+	}
+	return &compiledOutputRule{OutputRule: r, re: re}, nil
+}
+
+// outputRules caches the compiled configured rules.
+var outputRules struct {
+	sync.Mutex
+	rules    []OutputRule
+	compiled []*compiledOutputRule
+}
+
+func currentOutputRules() ([]*compiledOutputRule, error) {
+	outputRules.Lock()
+	defer outputRules.Unlock()
+	rules := config.OutputFilter.Rules
+	if outputRules.compiled == nil || !slices.EqualFunc(rules, outputRules.rules, func(a, b OutputRule) bool {
+		return a.Name == b.Name && a.Pattern == b.Pattern && a.Action == b.Action && a.Replacement == b.Replacement && slices.Equal(a.Keywords, b.Keywords)
+	}) {
+		compiled := make([]*compiledOutputRule, 0, len(rules))
+		for _, r := range rules {
+			c, err := compileOutputRule(r)
+			if err != nil {
+				return nil, err
+			}
+			compiled = append(compiled, c)
+		}
+		outputRules.rules = slices.Clone(rules)
+		outputRules.compiled = compiled
+	}
+	return outputRules.compiled, nil
+}
+
+// flaggedCategories lists the moderation categories set in r.
+func flaggedCategories(r openai.Result) []string {
+	data, _ := json.Marshal(r.Categories)
+	var categories map[string]bool
+	json.Unmarshal(data, &categories)
+	var flagged []string
+	for name, set := range categories {
+		if set {
+			flagged = append(flagged, name)
+		}
+	}
+	sort.Strings(flagged)
+	return flagged
+}
+
+// errOutputBlocked reports a blocked reply with the decisions as details.
+func errOutputBlocked(result *OutputFilterResult) error {
+	details := make([]error, len(result.Decisions))
+	for i, d := range result.Decisions {
+		details[i] = &huma.ErrorDetail{Message: fmt.Sprintf("%s: %s", d.Rule, d.Action), Location: "reply"}
+	}
+	return codedError(http.StatusUnprocessableEntity, "ERR_OUTPUT_BLOCKED", "The generated reply was blocked by the output filter", details...)
+}
+
+// filterOutput applies the output filter to a model reply. It returns the
+// text to use and the decisions made, or nil decisions when the filter is
+// disabled. A blocked reply comes back empty with the block decision.
+func filterOutput(ctx context.Context, provider ChatProvider, operation, text string) (string, *OutputFilterResult, error) {
+	if !config.OutputFilter.Enabled {
+		return text, nil, nil
+	}
+	result := &OutputFilterResult{Action: outputAllow, Decisions: []OutputDecision{}}
+	decide := func(d OutputDecision) {
+		result.Decisions = append(result.Decisions, d)
+		if slices.Index(outputActions, d.Action) > slices.Index(outputActions, result.Action) {
+			result.Action = d.Action
+		}
+	}
+	defer func() {
+		if result.Action != outputAllow {
+			data, _ := json.Marshal(result.Decisions)
+			audit(ctx, AuditEvent{Action: "output_filter." + result.Action, Operation: operation, Detail: string(data)})
+		}
+	}()
+
+	if config.OutputFilter.Moderation && text != "" {
+		resp, err := provider.Moderations(ctx, openai.ModerationRequest{Input: text})
+		if err != nil {
+			return "", nil, fmt.Errorf("moderation failed: %w", err)
+		}
+		for _, r := range resp.Results {
+			if r.Flagged {
+				decide(OutputDecision{Rule: "moderation", Action: config.OutputFilter.ModerationAction, Categories: flaggedCategories(r)})
+				break
+			}
+		}
+		if result.Action == outputBlock {
+			return "", result, nil
+		}
+	}
+
+	rules, err := currentOutputRules()
+	if err != nil {
+		return "", nil, err
+	}
+	for _, r := range rules {
+		matches := len(r.re.FindAllStringIndex(text, -1))
+		if matches == 0 {
+			continue
+		}
+		decide(OutputDecision{Rule: r.Name, Action: r.Action, Matches: matches})
+		switch r.Action {
+		case outputBlock:
+			return "", result, nil
+		case outputRedact:
+			text = r.re.ReplaceAllLiteralString(text, r.Replacement)
+		}
+	}
+	if slices.ContainsFunc(result.Decisions, func(d OutputDecision) bool { return d.Action == outputWatermark }) {
+		text += config.OutputFilter.Watermark
+	}
+	return text, result, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+func TestOutputFilterChat(t *testing.T) {
+	router := newFakeChatRouter(t)
+	config.OutputFilter.Enabled = true
+	config.OutputFilter.Rules = []OutputRule{
+		{Name: "canned", Keywords: []string{"CANNED"}, Action: outputRedact, Replacement: "[x]"},
+		{Name: "provider", Pattern: `provider\.$`, Action: outputWatermark},
+	}
+	if err := config.OutputFilter.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	call := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"message": "Hi"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+
+	w := call("/chat")
+	var resp ChatResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Expected a filtered reply, got %d: %s", w.Code, w.Body.String())
+	}
+	want := "This is a [x] reply from the fake provider." + config.OutputFilter.Watermark
+	if resp.Reply != want {
+		t.Errorf("Expected %q, got %q", want, resp.Reply)
+	}
+	if resp.Filter == nil || resp.Filter.Action != outputRedact || len(resp.Filter.Decisions) != 2 {
+		t.Errorf("Expected both decisions recorded, got %+v", resp.Filter)
+	}
+	if !strings.Contains(logs.String(), `"action":"output_filter.redact"`) {
+		t.Errorf("Expected the decision to be audited, got %q", logs.String())
+	}
+
+	w = call("/chat/stream")
+	if w.Code != http.StatusOK || strings.Count(w.Body.String(), "event: token") != 1 || !strings.Contains(w.Body.String(), "[x] reply") || !strings.Contains(w.Body.String(), `"filter":{"action":"redact"`) {
+		t.Errorf("Expected the filtered reply streamed as one token, got %d: %s", w.Code, w.Body.String())
+	}
+
+	config.OutputFilter.Rules = append(config.OutputFilter.Rules, OutputRule{Name: "fake", Keywords: []string{"fake"}, Action: outputBlock})
+	if w := call("/chat"); w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "ERR_OUTPUT_BLOCKED") {
+		t.Errorf("Expected the reply to be blocked, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call("/chat/stream"); strings.Contains(w.Body.String(), "event: token") || !strings.Contains(w.Body.String(), "blocked by the output filter") {
+		t.Errorf("Expected the streamed reply to be blocked, got %s", w.Body.String())
+	}
+	if !strings.Contains(logs.String(), `"action":"output_filter.block"`) {
+		t.Errorf("Expected the block to be audited, got %q", logs.String())
+	}
+}
+
+func TestOutputFilterModeration(t *testing.T) {
+	var flagged bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(openai.ModerationResponse{Results: []openai.Result{{
+			Flagged:    flagged,
+			Categories: openai.ResultCategories{Violence: flagged},
+		}}})
+	}))
+	defer srv.Close()
+	cfg := openai.DefaultConfig("test")
+	cfg.BaseURL = srv.URL + "/v1"
+	client := openai.NewClientWithConfig(cfg)
+
+	viper.Reset()
+	initConfig()
+	config.OutputFilter.Enabled = true
+	config.OutputFilter.Moderation = true
+	config.OutputFilter.ModerationAction = outputBlock
+
+	text, result, err := filterOutput(t.Context(), client, "chat", "Hello")
+	if err != nil || text != "Hello" || result.Action != outputAllow {
+		t.Errorf("Expected an unflagged reply to pass, got %q, %+v, %v", text, result, err)
+	}
+
+	flagged = true
+	text, result, err = filterOutput(t.Context(), client, "chat", "Hello")
+	if err != nil || text != "" || result.Action != outputBlock || len(result.Decisions) != 1 || result.Decisions[0].Categories[0] != "violence" {
+		t.Errorf("Expected a flagged reply to be blocked, got %q, %+v, %v", text, result, err)
+	}
+
+	config.OutputFilter.ModerationAction = outputWatermark
+	text, result, err = filterOutput(t.Context(), client, "chat", "Hello")
+	if err != nil || text != "Hello"+config.OutputFilter.Watermark || result.Action != outputWatermark {
+		t.Errorf("Expected a flagged reply to be watermarked, got %q, %+v, %v", text, result, err)
+	}
+}
+
+func TestOutputFilterConfigValidate(t *testing.T) {
+	c := OutputFilterConfig{Enabled: true, Rules: []OutputRule{
+		{Name: "bad", Pattern: "(", Action: outputRedact},
+		{Name: "none", Action: outputBlock},
+		{Name: "allow", Keywords: []string{"x"}, Action: outputAllow},
+	}}
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("Expected invalid rules to be rejected")
+	}
+	for _, want := range []string{"bad", "needs a pattern or keywords", "action must be"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in %v", want, err)
+		}
+	}
+}
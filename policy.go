@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+	"github.com/sashabaranov/go-openai"
+)
+
+// policiesPrefix is where tenant policies are kept in the system bucket.
+const policiesPrefix = "policies/"
+
+// policyCacheTTL is how long a tenant's policy is cached. Changes made
+// through another instance take effect within it.
+const policyCacheTTL = 30 * time.Second
+
+// defaultModel is used when neither the request nor the tenant's policy
+// names a model.
+const defaultModel = openai.GPT3Dot5Turbo
+
+// TenantPolicy restricts what a storage tenant may do. Empty lists allow
+// everything.
+type TenantPolicy struct {
+	Models       []string  `json:"models,omitempty" doc:"Models the tenant may use, e.g. gpt-4o-mini; empty allows all"`
+	DefaultModel string    `json:"default_model,omitempty" doc:"Model used when a request names none; defaults to gpt-3.5-turbo"`
+	Operations   []string  `json:"operations,omitempty" doc:"Operation IDs the tenant may call, e.g. chat or get-file; empty allows all"`
+	UpdatedAt    time.Time `json:"updated_at" readOnly:"true" doc:"Last modification time"`
+}
+
+type TenantPolicyResponse struct {
+	Tenant string       `json:"tenant" doc:"Tenant name"`
+	Policy TenantPolicy `json:"policy" doc:"The tenant's policy"`
+}
+
+type policyKey struct{}
+
+// tenantPolicy is the policy in force for one request.
+type tenantPolicy struct {
+	tenant string
+	policy *TenantPolicy
+}
+
+// policyCache holds recently loaded policies by tenant; nil entries record
+// tenants without a policy.
+var policyCache struct {
+	sync.Mutex
+	entries map[string]cachedPolicy
+}
+
+type cachedPolicy struct {
+	policy   *TenantPolicy
+	loadedAt time.Time
+}
+
+func policyKeyName(tenant string) string {
+	return policiesPrefix + tenant + ".json"
+}
+
+// loadTenantPolicy returns the tenant's policy, or nil if it has none.
+func loadTenantPolicy(ctx context.Context, tenant string) (*TenantPolicy, error) {
+	policyCache.Lock()
+	cached, ok := policyCache.entries[tenant]
+	policyCache.Unlock()
+	if ok && time.Since(cached.loadedAt) < policyCacheTTL {
+		return cached.policy, nil
+	}
+
+	var p *TenantPolicy
+	var stored TenantPolicy
+	switch err := getJSON(ctx, config.MinIO.SystemBucket, policyKeyName(tenant), &stored); {
+	case isNotFound(err):
+	case err != nil:
+		return nil, err
+	default:
+		p = &stored
+	}
+	cachePolicy(tenant, p)
+	return p, nil
+}
+
+func cachePolicy(tenant string, p *TenantPolicy) {
+	policyCache.Lock()
+	defer policyCache.Unlock()
+	if policyCache.entries == nil {
+		policyCache.entries = map[string]cachedPolicy{}
+	}
+	policyCache.entries[tenant] = cachedPolicy{policy: p, loadedAt: time.Now()}
+}
+
+func errPolicyViolation(msg string) error {
+	return codedError(http.StatusForbidden, "ERR_POLICY_VIOLATION", msg)
+}
+
+// policyModel returns the model a request should use under the caller's
+// policy, or an error if openai.allowed_models or the policy does not
+// allow it.
+func policyModel(ctx context.Context, requested string) (string, error) {
+	tp, _ := ctx.Value(policyKey{}).(*tenantPolicy)
+	model := requested
+	if model == "" && tp != nil && tp.policy.DefaultModel != "" {
+		model = tp.policy.DefaultModel
+	}
+	if model == "" {
+		model = defaultModel
+		if allowed := config.OpenAI.AllowedModels; len(allowed) > 0 {
+			model = allowed[0]
+		}
+	}
+	if err := checkAllowedModel(model); err != nil {
+		return "", err
+	}
+	if tp == nil || len(tp.policy.Models) == 0 || slices.Contains(tp.policy.Models, model) {
+		return model, nil
+	}
+	audit(ctx, AuditEvent{Action: "policy.violation", Tenant: tp.tenant, Detail: "model " + model + " is not allowed"})
+	return "", errPolicyViolation(fmt.Sprintf("Tenant %s may not use model %s", tp.tenant, model))
+}
+
+// checkAllowedModel refuses models outside openai.allowed_models.
+func checkAllowedModel(model string) error {
+	allowed := config.OpenAI.AllowedModels
+	if len(allowed) == 0 || slices.Contains(allowed, model) {
+		return nil
+	}
+	return codedError(http.StatusBadRequest, "ERR_MODEL_NOT_ALLOWED", fmt.Sprintf("Model %s is not allowed; use one of %s", model, strings.Join(allowed, ", ")))
+}
+
+// writeHumaError writes err as a problem details response from a huma
+// middleware.
+func writeHumaError(ctx huma.Context, err error) {
+	se, ok := err.(huma.StatusError)
+	if !ok {
+		se = huma.NewError(http.StatusInternalServerError, err.Error())
+	}
+	ctx.SetHeader("Content-Type", "application/problem+json")
+	ctx.SetStatus(se.GetStatus())
+	json.NewEncoder(ctx.BodyWriter()).Encode(se)
+}
+
+// tenantPolicyMiddleware evaluates the policy of the tenant a request's
+// token belongs to. Operations the policy does not allow are refused and
+// audited; allowed requests carry the policy for policyModel. Requests
+// without a tenant token are not affected.
+func tenantPolicyMiddleware(ctx huma.Context, next func(huma.Context)) {
+	tenant, ok := storageTenant(ctx.Header("Authorization"))
+	if !ok {
+		next(ctx)
+		return
+	}
+	if _, err := services.MinIO(); err != nil {
+		// Policies live in MinIO, so there can't be any
+		next(ctx)
+		return
+	}
+	policy, err := loadTenantPolicy(ctx.Context(), tenant.Name)
+	if err != nil {
+		writeHumaError(ctx, huma.Error500InternalServerError("Failed to read the tenant policy", err))
+		return
+	}
+	if policy == nil {
+		next(ctx)
+		return
+	}
+	op := ctx.Operation().OperationID
+	if len(policy.Operations) > 0 && !slices.Contains(policy.Operations, op) {
+		audit(ctx.Context(), AuditEvent{Action: "policy.violation", Tenant: tenant.Name, Operation: op, Detail: "operation is not allowed"})
+		writeHumaError(ctx, errPolicyViolation(fmt.Sprintf("Tenant %s may not call %s", tenant.Name, op)))
+		return
+	}
+	next(huma.WithValue(ctx, policyKey{}, &tenantPolicy{tenant: tenant.Name, policy: policy}))
+}
+
+// operationIDs lists the operations registered on api.
+func operationIDs(api huma.API) []string {
+	var ids []string
+	for _, item := range api.OpenAPI().Paths {
+		for _, op := range []*huma.Operation{item.Get, item.Put, item.Post, item.Delete, item.Patch, item.Head, item.Options} {
+			if op != nil {
+				ids = append(ids, op.OperationID)
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func registerTenantPolicyEndpoints(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "put-tenant-policy",
+		Method:      http.MethodPut,
+		Path:        "/admin/tenants/{tenant}/policy",
+		Summary:     "Set a tenant's policy",
+		Description: "Restrict the models and operations a storage tenant may use. The policy is evaluated on every request made with the tenant's token",
+	}, func(ctx context.Context, input *struct {
+		Tenant        string `path:"tenant" doc:"Storage tenant name"`
+		Authorization string `header:"Authorization" doc:"Bearer admin token"`
+		Body          TenantPolicy
+	}) (*struct {
+		Body TenantPolicyResponse
+	}, error) {
+		if err := requireAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+		if !slices.ContainsFunc(config.Storage.Tenants, func(t StorageTenantConfig) bool { return t.Name == input.Tenant }) {
+			return nil, huma.Error404NotFound(fmt.Sprintf("Tenant %s is not configured", input.Tenant))
+		}
+		known := operationIDs(api)
+		for _, op := range input.Body.Operations {
+			if _, found := slices.BinarySearch(known, op); !found {
+				return nil, huma.Error422UnprocessableEntity(fmt.Sprintf("Unknown operation %s", op))
+			}
+		}
+		if m := input.Body.DefaultModel; m != "" && len(input.Body.Models) > 0 && !slices.Contains(input.Body.Models, m) {
+			return nil, huma.Error422UnprocessableEntity(fmt.Sprintf("Default model %s is not among the allowed models", m))
+		}
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+
+		p := input.Body
+		p.UpdatedAt = time.Now().UTC()
+		if err := ensureBucket(ctx, config.MinIO.SystemBucket); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to save the policy", err)
+		}
+		if err := putJSON(ctx, config.MinIO.SystemBucket, policyKeyName(input.Tenant), p); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to save the policy", err)
+		}
+		cachePolicy(input.Tenant, &p)
+		audit(ctx, AuditEvent{Action: "policy.update", Tenant: input.Tenant})
+		return &struct {
+			Body TenantPolicyResponse
+		}{Body: TenantPolicyResponse{Tenant: input.Tenant, Policy: p}}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-tenant-policy",
+		Method:      http.MethodGet,
+		Path:        "/admin/tenants/{tenant}/policy",
+		Summary:     "Get a tenant's policy",
+		Description: "Return the policy a storage tenant's requests are evaluated against",
+	}, func(ctx context.Context, input *struct {
+		Tenant        string `path:"tenant" doc:"Storage tenant name"`
+		Authorization string `header:"Authorization" doc:"Bearer admin token"`
+	}) (*struct {
+		Body TenantPolicyResponse
+	}, error) {
+		if err := requireAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+		var p TenantPolicy
+		if err := getJSON(ctx, config.MinIO.SystemBucket, policyKeyName(input.Tenant), &p); err != nil {
+			if isNotFound(err) {
+				return nil, huma.Error404NotFound(fmt.Sprintf("Tenant %s has no policy", input.Tenant))
+			}
+			return nil, huma.Error500InternalServerError("Failed to read the policy", err)
+		}
+		return &struct {
+			Body TenantPolicyResponse
+		}{Body: TenantPolicyResponse{Tenant: input.Tenant, Policy: p}}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID:   "delete-tenant-policy",
+		Method:        http.MethodDelete,
+		Path:          "/admin/tenants/{tenant}/policy",
+		Summary:       "Remove a tenant's policy",
+		Description:   "Lift all restrictions of a storage tenant",
+		DefaultStatus: http.StatusNoContent,
+	}, func(ctx context.Context, input *struct {
+		Tenant        string `path:"tenant" doc:"Storage tenant name"`
+		Authorization string `header:"Authorization" doc:"Bearer admin token"`
+	}) (*struct{}, error) {
+		if err := requireAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+		if err := client.RemoveObject(ctx, config.MinIO.SystemBucket, policyKeyName(input.Tenant), minio.RemoveObjectOptions{}); err != nil && !isNotFound(err) {
+			return nil, huma.Error500InternalServerError("Failed to remove the policy", err)
+		}
+		cachePolicy(input.Tenant, nil)
+		audit(ctx, AuditEvent{Action: "policy.delete", Tenant: input.Tenant})
+		return nil, nil
+	})
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+func TestTenantPolicy(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "admin-token"
+	config.Storage.Tenants = []StorageTenantConfig{{Name: "acme", Token: "acme-token", Bucket: "tenants"}}
+	resetPolicies := func() {
+		policyCache.Lock()
+		policyCache.entries = nil
+		policyCache.Unlock()
+	}
+	resetPolicies()
+	t.Cleanup(resetPolicies)
+
+	objects := map[string]string{}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+	services.SetOpenAI(newFakeOpenAIClient(0))
+	defer services.SetOpenAI(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	api.UseMiddleware(tenantPolicyMiddleware)
+	registerChatEndpoint(api)
+	registerChatStreamEndpoint(api)
+	registerTenantPolicyEndpoints(api)
+	registerFilePutEndpoint(api)
+	registerTrashEndpoints(api)
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := call("PUT", "/admin/tenants/acme/policy", "admin-token", `{"operations": ["no-such-op"]}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected an unknown operation to be rejected, got %d", w.Code)
+	}
+	if w := call("PUT", "/admin/tenants/nobody/policy", "admin-token", `{}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown tenant to be rejected, got %d", w.Code)
+	}
+	w := call("PUT", "/admin/tenants/acme/policy", "admin-token", `{"models": ["gpt-4o-mini"], "default_model": "gpt-4o-mini", "operations": ["chat"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the policy to be saved, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(objects["app-system/policies/acme.json"], "gpt-4o-mini") {
+		t.Errorf("Expected the policy to be stored, got %v", objects)
+	}
+
+	var logs bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&logs)
+
+	if w := call("POST", "/chat", "acme-token", `{"message": "Hi"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected chat with the default model to be allowed, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call("POST", "/chat", "acme-token", `{"message": "Hi", "model": "gpt-4"}`); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "ERR_POLICY_VIOLATION") {
+		t.Errorf("Expected a model outside the policy to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call("POST", "/chat/stream", "acme-token", `{"message": "Hi"}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected an operation outside the policy to be refused, got %d", w.Code)
+	}
+	if w := call("POST", "/chat/stream", "", `{"message": "Hi", "model": "gpt-4"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected requests without a tenant token to be unaffected, got %d", w.Code)
+	}
+	audits := logs.String()
+	if !strings.Contains(audits, `"action":"policy.violation","tenant":"acme","detail":"model gpt-4 is not allowed"`) ||
+		!strings.Contains(audits, `"operation":"chat-stream"`) {
+		t.Errorf("Expected both violations to be audited, got %s", audits)
+	}
+
+	// Tenants can change their policy neither through the admin endpoints
+	// nor by writing the stored object
+	stored := objects["app-system/policies/acme.json"]
+	for _, tc := range []struct{ method, path, body string }{
+		{"PUT", "/admin/tenants/acme/policy", `{}`},
+		{"DELETE", "/admin/tenants/acme/policy", ""},
+		{"PUT", "/files/app-system/policies%2Facme.json", `{}`},
+		{"DELETE", "/files/app-system/policies%2Facme.json", ""},
+	} {
+		if w := call(tc.method, tc.path, "acme-token", tc.body); w.Code != http.StatusUnauthorized && w.Code != http.StatusForbidden {
+			t.Errorf("Expected %s %s to be refused to the tenant, got %d", tc.method, tc.path, w.Code)
+		}
+	}
+	if objects["app-system/policies/acme.json"] != stored {
+		t.Errorf("Expected the policy to be unchanged, got %s", objects["app-system/policies/acme.json"])
+	}
+
+	if w := call("GET", "/admin/tenants/acme/policy", "admin-token", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"operations":["chat"]`) {
+		t.Errorf("Expected the policy, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call("DELETE", "/admin/tenants/acme/policy", "admin-token", ""); w.Code != http.StatusNoContent {
+		t.Errorf("Expected the policy to be removed, got %d", w.Code)
+	}
+	if w := call("POST", "/chat/stream", "acme-token", `{"message": "Hi"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected the tenant to be unrestricted without a policy, got %d", w.Code)
+	}
+}
+
+func TestAllowedModels(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	var model string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		model = req.Model
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}]}`)
+	}))
+	defer upstream.Close()
+	services.SetOpenAI(newTestOpenAIClient(upstream.URL))
+	defer services.SetOpenAI(nil)
+	config.OpenAI.AllowedModels = []string{"gpt-4o-mini", "gpt-4o"}
+	defer func() { config.OpenAI.AllowedModels = nil }()
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerChatEndpoint(api)
+	registerChatStreamEndpoint(api)
+	registerStructuredChatEndpoint(api)
+	registerEmbeddingsEndpoint(api)
+	call := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := call("/chat", `{"message": "Hi", "model": "gpt-4o"}`); w.Code != http.StatusOK || model != "gpt-4o" {
+		t.Errorf("Expected an allowed model to be used, got %d with %s", w.Code, model)
+	}
+	if w := call("/chat", `{"message": "Hi"}`); w.Code != http.StatusOK || model != "gpt-4o-mini" {
+		t.Errorf("Expected the first allowed model by default, got %d with %s", w.Code, model)
+	}
+	for path, body := range map[string]string{
+		"/chat":            `{"message": "Hi", "model": "gpt-4"}`,
+		"/chat/stream":     `{"message": "Hi", "model": "gpt-4"}`,
+		"/chat/structured": `{"message": "Hi", "model": "gpt-4", "schema": {"type": "object"}}`,
+		"/embeddings":      `{"input": ["Hi"]}`,
+	} {
+		w := call(path, body)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "ERR_MODEL_NOT_ALLOWED") || !strings.Contains(w.Body.String(), "gpt-4o-mini, gpt-4o") {
+			t.Errorf("Expected %s to refuse a model that is not allowed, got %d: %s", path, w.Code, w.Body.String())
+		}
+	}
+
+	for _, models := range [][]string{{"gpt-4o", " "}, {"gpt-4o", "gpt-4o"}} {
+		if err := (OpenAIConfig{AllowedModels: models}).Validate(); err == nil {
+			t.Errorf("Expected %q to be invalid", models)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // registers the GIF decoder for image.Decode
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+const (
+	// maxPreviewLineBytes caps the length of a single text line.
+	maxPreviewLineBytes = 64 << 10
+)
+
+// textExtensions are previewed as text even when stored without a content
+// type; the standard mime table does not know most of them.
+var textExtensions = map[string]bool{
+	".txt": true, ".csv": true, ".tsv": true, ".md": true,
+	".log": true, ".yaml": true, ".yml": true, ".json": true,
+}
+
+type previewKind int
+
+const (
+	previewUnsupported previewKind = iota
+	previewText
+	previewImage
+	previewPDF
+)
+
+// detectPreviewKind picks a preview strategy from the stored content type,
+// falling back to the object's extension when the type is generic.
+func detectPreviewKind(contentType, name string) previewKind {
+	ct, _, _ := mime.ParseMediaType(contentType)
+	if ct == "" || ct == "application/octet-stream" || ct == "binary/octet-stream" {
+		ext := strings.ToLower(path.Ext(name))
+		if textExtensions[ext] {
+			return previewText
+		}
+		ct, _, _ = mime.ParseMediaType(mime.TypeByExtension(ext))
+	}
+
+	switch {
+	case strings.HasPrefix(ct, "text/"), ct == "application/json", ct == "application/xml", ct == "application/x-yaml":
+		return previewText
+	case ct == "image/png", ct == "image/jpeg", ct == "image/gif":
+		return previewImage
+	case ct == "application/pdf":
+		return previewPDF
+	}
+	return previewUnsupported
+}
+
+// readLines returns at most n lines from r.
+func readLines(r io.Reader, n int) ([]byte, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxPreviewLineBytes)
+
+	var buf bytes.Buffer
+	for i := 0; i < n && scanner.Scan(); i++ {
+		buf.Write(scanner.Bytes())
+		buf.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil && err != bufio.ErrTooLong {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// resizeImage scales src down so it is at most width pixels wide, keeping
+// the aspect ratio. Images that are already small enough are returned as-is.
+func resizeImage(src image.Image, width int) image.Image {
+	b := src.Bounds()
+	if b.Dx() <= width {
+		return src
+	}
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		sy := b.Min.Y + y*b.Dy()/height
+		for x := 0; x < width; x++ {
+			sx := b.Min.X + x*b.Dx()/width
+			dst.Set(x, y, src.At(sx, sy))
+		}
+	}
+	return dst
+}
+
+// previewImageBytes decodes an image and re-encodes a downscaled copy in
+// the same format (GIFs become PNGs). The dimensions are read from the
+// header first, so that images with more than limits.max_preview_pixels
+// are refused before they are decoded.
+func previewImageBytes(r io.Reader, width int) ([]byte, string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, config.Limits.MaxPreviewBytes))
+	if err != nil {
+		return nil, "", err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if pixels := int64(cfg.Width) * int64(cfg.Height); pixels > config.Limits.MaxPreviewPixels {
+		return nil, "", huma.Error422UnprocessableEntity(fmt.Sprintf("Image is %dx%d pixels; previews are limited to %d pixels", cfg.Width, cfg.Height, config.Limits.MaxPreviewPixels))
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	thumb := resizeImage(img, width)
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80})
+		return buf.Bytes(), "image/jpeg", err
+	}
+	err = png.Encode(&buf, thumb)
+	return buf.Bytes(), "image/png", err
+}
+
+// previewPDFBytes renders the first page of a PDF to PNG using poppler's
+// pdftoppm, which must be installed on the host.
+func previewPDFBytes(ctx context.Context, r io.Reader, width int) ([]byte, error) {
+	bin, err := exec.LookPath("pdftoppm")
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp("", "preview-*.pdf")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, io.LimitReader(r, config.Limits.MaxPreviewBytes))
+	tmp.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, bin, "-png", "-f", "1", "-l", "1", "-singlefile", "-scale-to", strconv.Itoa(width), tmp.Name())
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func registerFilePreviewEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "preview-file",
+		Method:      http.MethodGet,
+		Path:        "/files/{bucket}/{name}/preview",
+		Summary:     "Preview a file",
+		Description: "Return a lightweight preview of an object: the first lines of text files, a downscaled copy of images, or the first page of a PDF rendered as PNG",
+	}, func(ctx context.Context, input *struct {
+		Bucket        string `path:"bucket" doc:"MinIO bucket name"`
+		Name          string `path:"name" doc:"Object name (URL-encoded if it contains slashes)"`
+		Lines         int    `query:"lines" default:"20" minimum:"1" maximum:"500" doc:"Number of lines to return for text files"`
+		Width         int    `query:"width" default:"320" minimum:"16" maximum:"2048" doc:"Maximum width in pixels for image and PDF previews"`
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token; not needed for public objects"`
+	}) (*struct {
+		ContentType string `header:"Content-Type"`
+		Body        []byte
+	}, error) {
+		if err := checkBucketAccess(input.Bucket); err != nil {
+			return nil, err
+		}
+
+		client, err := services.MinIOReader()
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := decodeObjectKey(input.Name)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkObjectACL(ctx, input.Bucket, name, objectCaller(input.Authorization)); err != nil {
+			return nil, err
+		}
+
+		obj, info, err := openObject(ctx, client, input.Bucket, name, "")
+		if err != nil {
+			if isNotFound(err) {
+				return nil, errObjectNotFound(input.Bucket, name)
+			}
+			return nil, huma.Error500InternalServerError("Failed to get object", err)
+		}
+		defer obj.Close()
+
+		var body []byte
+		contentType := "text/plain; charset=utf-8"
+		switch detectPreviewKind(info.ContentType, name) {
+		case previewText:
+			body, err = readLines(obj, input.Lines)
+		case previewImage:
+			body, contentType, err = previewImageBytes(obj, input.Width)
+		case previewPDF:
+			contentType = "image/png"
+			body, err = previewPDFBytes(ctx, obj, input.Width)
+			if errors.Is(err, exec.ErrNotFound) {
+				return nil, huma.Error501NotImplemented("PDF previews require pdftoppm to be installed on the server")
+			}
+		default:
+			return nil, huma.Error415UnsupportedMediaType(fmt.Sprintf("No preview available for content type %q", info.ContentType))
+		}
+		if errors.As(err, new(huma.StatusError)) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to build preview", err)
+		}
+
+		return &struct {
+			ContentType string `header:"Content-Type"`
+			Body        []byte
+		}{
+			ContentType: contentType,
+			Body:        body,
+		}, nil
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestDetectPreviewKind(t *testing.T) {
+	cases := []struct {
+		contentType string
+		name        string
+		want        previewKind
+	}{
+		{"text/plain", "notes", previewText},
+		{"text/csv; charset=utf-8", "data", previewText},
+		{"application/octet-stream", "data.csv", previewText},
+		{"", "README.md", previewText},
+		{"image/png", "logo", previewImage},
+		{"", "photo.jpg", previewImage},
+		{"application/pdf", "report", previewPDF},
+		{"application/zip", "archive.zip", previewUnsupported},
+	}
+	for _, c := range cases {
+		if got := detectPreviewKind(c.contentType, c.name); got != c.want {
+			t.Errorf("detectPreviewKind(%q, %q) = %v, want %v", c.contentType, c.name, got, c.want)
+		}
+	}
+}
+
+func TestReadLines(t *testing.T) {
+	out, err := readLines(strings.NewReader("a\nb\nc\nd\n"), 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(out) != "a\nb\n" {
+		t.Errorf("Expected first two lines, got %q", out)
+	}
+}
+
+func TestResizeImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 800, 400))
+
+	thumb := resizeImage(src, 200)
+	if b := thumb.Bounds(); b.Dx() != 200 || b.Dy() != 100 {
+		t.Errorf("Expected 200x100 thumbnail, got %dx%d", b.Dx(), b.Dy())
+	}
+
+	if small := resizeImage(src, 1000); small != image.Image(src) {
+		t.Error("Expected images narrower than the target width to be returned unchanged")
+	}
+}
+
+func TestPreviewImagePixelLimit(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	var src bytes.Buffer
+	png.Encode(&src, image.NewGray(image.Rect(0, 0, 400, 300)))
+
+	if _, contentType, err := previewImageBytes(bytes.NewReader(src.Bytes()), 100); err != nil || contentType != "image/png" {
+		t.Fatalf("Expected a PNG preview, got %q, %v", contentType, err)
+	}
+	config.Limits.MaxPreviewPixels = 400*300 - 1
+	_, _, err := previewImageBytes(bytes.NewReader(src.Bytes()), 100)
+	var se huma.StatusError
+	if !errors.As(err, &se) || se.GetStatus() != http.StatusUnprocessableEntity {
+		t.Errorf("Expected an image over the pixel limit to be refused with 422, got %v", err)
+	}
+}
+
+func TestFilePreviewEndpointWithoutClient(t *testing.T) {
+	// Initialize config for testing
+	viper.Reset()
+	initConfig()
+
+	// Ensure MinIO client is not initialized
+	services.SetMinIO(nil)
+
+	// Create test router and API
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+
+	// Register preview endpoint
+	registerFilePreviewEndpoint(api)
+
+	req := httptest.NewRequest("GET", "/files/test-bucket/test.txt/preview", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Should return 503 Service Unavailable since MinIO client is not configured
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code 503, got %d", w.Code)
+	}
+}
+
+func TestFilePreviewACL(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	resetDiskCache(t)
+	config.Storage.Tenants = []StorageTenantConfig{
+		{Name: "acme", Token: "acme-token", Bucket: "docs"},
+		{Name: "globex", Token: "globex-token", Bucket: "docs"},
+	}
+	defer func() { config.Storage.Tenants = nil }()
+	objects := map[string]string{
+		"docs/plan.txt":             "Secret plan\n",
+		"docs/open.txt":             "Hello\n",
+		"app-system/acls/docs.json": `{"plan.txt": {"visibility": "private", "owner": "acme"}}`,
+	}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerFilePreviewEndpoint(api)
+	preview := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, tc := range []struct {
+		path, token string
+		want        int
+	}{
+		{"/files/docs/plan.txt/preview", "acme-token", http.StatusOK},
+		{"/files/docs/plan.txt/preview", "globex-token", http.StatusForbidden},
+		{"/files/docs/plan.txt/preview", "", http.StatusUnauthorized},
+		{"/files/docs/open.txt/preview", "", http.StatusOK},
+		{"/files/app-system/acls%2Fdocs.json/preview", "acme-token", http.StatusForbidden},
+	} {
+		w := preview(tc.path, tc.token)
+		if w.Code != tc.want {
+			t.Errorf("Expected %s with token %q to return %d, got %d: %s", tc.path, tc.token, tc.want, w.Code, w.Body.String())
+		}
+		if tc.want != http.StatusOK && strings.Contains(w.Body.String(), "Secret plan") {
+			t.Errorf("Expected %s not to leak the content", tc.path)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"sync"
+)
+
+// defaultRedaction replaces redacted text when a rule sets no replacement.
+const defaultRedaction = "[REDACTED]"
+
+// PromptLogConfig controls logging of the prompts sent to OpenAI and the
+// replies received. Redaction rules run before anything is written, so
+// deployments in regulated environments can log safely.
+type PromptLogConfig struct {
+	Enabled bool            `mapstructure:"enabled" doc:"Log chat and edit prompts and their replies after redaction"`
+	Redact  []RedactionRule `mapstructure:"redact" doc:"Redaction rules applied to logged prompts and replies, in order"`
+}
+
+// RedactionRule hides matches of Pattern, or whole values of Fields. With
+// both set the pattern is only applied inside those fields.
+type RedactionRule struct {
+	Name        string   `mapstructure:"name" doc:"Rule name used in config errors"`
+	Pattern     string   `mapstructure:"pattern" doc:"Regular expression whose matches are replaced"`
+	Fields      []string `mapstructure:"fields" doc:"JSON fields of the logged record the rule applies to, e.g. message or content; without a pattern their whole value is replaced"`
+	Replacement string   `mapstructure:"replacement" doc:"Text that replaces a match or field; defaults to [REDACTED]"`
+}
+
+func (c PromptLogConfig) Validate() error {
+	var errs []error
+	for i, r := range c.Redact {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprint(i)
+		}
+		if r.Pattern == "" && len(r.Fields) == 0 {
+			errs = append(errs, fmt.Errorf("prompt_log.redact %s needs a pattern or fields", name))
+		}
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			errs = append(errs, fmt.Errorf("prompt_log.redact %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type compiledRedaction struct {
+	pattern     *regexp.Regexp
+	fields      []string
+	replacement string
+}
+
+// redactor applies redaction rules to a JSON document decoded into any.
+type redactor struct {
+	global []*compiledRedaction // patterns applied everywhere
+	fields []*compiledRedaction // rules scoped to fields
+}
+
+func newRedactor(rules []RedactionRule) (*redactor, error) {
+	r := &redactor{}
+	for _, rule := range rules {
+		c := &compiledRedaction{fields: rule.Fields, replacement: rule.Replacement}
+		if c.replacement == "" {
+			c.replacement = defaultRedaction
+		}
+		if rule.Pattern != "" {
+			p, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, err
+			}
+			c.pattern = p
+		}
+		switch {
+		case len(c.fields) > 0:
+			r.fields = append(r.fields, c)
+		case c.pattern != nil:
+			r.global = append(r.global, c)
+		}
+	}
+	return r, nil
+}
+
+// redact returns v with the rules applied. Maps and slices are changed in
+// place.
+func (r *redactor) redact(v any) any {
+	return r.value(v, r.global)
+}
+
+func (r *redactor) value(v any, active []*compiledRedaction) any {
+	switch v := v.(type) {
+	case string:
+		for _, rule := range active {
+			v = rule.pattern.ReplaceAllString(v, rule.replacement)
+		}
+		return v
+	case []any:
+		for i := range v {
+			v[i] = r.value(v[i], active)
+		}
+		return v
+	case map[string]any:
+		for k, x := range v {
+			scoped := active
+			replaced := false
+			for _, rule := range r.fields {
+				if !slices.Contains(rule.fields, k) {
+					continue
+				}
+				if rule.pattern == nil {
+					v[k] = rule.replacement
+					replaced = true
+					break
+				}
+				scoped = append(slices.Clip(scoped), rule)
+			}
+			if !replaced {
+				v[k] = r.value(x, scoped)
+			}
+		}
+		return v
+	}
+	return v
+}
+
+// promptRedactor caches the redactor built from the configured rules.
+var promptRedactor struct {
+	sync.Mutex
+	rules    []RedactionRule
+	redactor *redactor
+}
+
+func currentRedactor() (*redactor, error) {
+	promptRedactor.Lock()
+	defer promptRedactor.Unlock()
+	rules := config.PromptLog.Redact
+	if promptRedactor.redactor == nil || !slices.EqualFunc(rules, promptRedactor.rules, func(a, b RedactionRule) bool {
+		return a.Name == b.Name && a.Pattern == b.Pattern && a.Replacement == b.Replacement && slices.Equal(a.Fields, b.Fields)
+	}) {
+		r, err := newRedactor(rules)
+		if err != nil {
+			return nil, err
+		}
+		promptRedactor.rules = slices.Clone(rules)
+		promptRedactor.redactor = r
+	}
+	return promptRedactor.redactor, nil
+}
+
+// promptRecord is what the prompt log writes for one model call.
+type promptRecord struct {
+	Operation string `json:"operation"`
+	RequestID string `json:"request_id,omitempty"`
+	Request   any    `json:"request"`
+	Reply     string `json:"reply,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// redactedPrompt returns rec as JSON with the configured rules applied.
+func redactedPrompt(rec promptRecord) ([]byte, error) {
+	r, err := currentRedactor()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(r.redact(doc))
+}
+
+// logPrompt writes a redacted record of a model call when the prompt log is
+// enabled. Nothing is written if redaction fails.
+func logPrompt(ctx context.Context, operation string, request any, reply string, callErr error) {
+	if !config.PromptLog.Enabled {
+		return
+	}
+	rec := promptRecord{Operation: operation, Request: request, Reply: reply}
+	if t := traceFromContext(ctx); t != nil {
+		rec.RequestID = t.RequestID
+	}
+	if callErr != nil {
+		rec.Error = callErr.Error()
+	}
+	data, err := redactedPrompt(rec)
+	if err != nil {
+		log.Printf("Prompt log: %s not logged: %v", operation, err)
+		return
+	}
+	log.Printf("Prompt log: %s", data)
+}
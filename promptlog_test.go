@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestRedactor(t *testing.T) {
+	r, err := newRedactor([]RedactionRule{
+		{Name: "email", Pattern: `[\w.]+@[\w.]+`, Replacement: "[EMAIL]"},
+		{Name: "history", Fields: []string{"history"}},
+		{Name: "digits", Pattern: `\d+`, Fields: []string{"reply"}, Replacement: "#"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := map[string]any{
+		"request": map[string]any{
+			"message": "Mail ann@example.com about order 1234",
+			"history": []any{map[string]any{"role": "user", "content": "secret"}},
+		},
+		"reply": "Order 1234 ships to ann@example.com",
+	}
+	got := r.redact(doc).(map[string]any)
+	req := got["request"].(map[string]any)
+	if req["message"] != "Mail [EMAIL] about order 1234" {
+		t.Errorf("Expected the pattern to apply to every field, got %q", req["message"])
+	}
+	if req["history"] != defaultRedaction {
+		t.Errorf("Expected the field to be replaced, got %v", req["history"])
+	}
+	if got["reply"] != "Order # ships to [EMAIL]" {
+		t.Errorf("Expected the scoped pattern to apply to reply only, got %q", got["reply"])
+	}
+}
+
+func TestPromptLogConfigValidate(t *testing.T) {
+	valid := PromptLogConfig{Redact: []RedactionRule{{Pattern: `\d{4}`}, {Fields: []string{"message"}}}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Expected valid rules, got %v", err)
+	}
+	for _, rule := range []RedactionRule{{Name: "empty"}, {Name: "bad", Pattern: "("}} {
+		if err := (PromptLogConfig{Redact: []RedactionRule{rule}}).Validate(); err == nil || !strings.Contains(err.Error(), rule.Name) {
+			t.Errorf("Expected rule %s to be rejected, got %v", rule.Name, err)
+		}
+	}
+}
+
+func TestLogPrompt(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	defer func() { viper.Reset(); initConfig() }()
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	logPrompt(context.Background(), "chat", ChatRequest{Message: "card 4111 1111 1111 1111"}, "ok", nil)
+	if buf.Len() != 0 {
+		t.Fatalf("Expected nothing to be logged while disabled, got %s", buf.String())
+	}
+
+	config.PromptLog = PromptLogConfig{Enabled: true, Redact: []RedactionRule{{Pattern: `\d{4}( \d{4}){3}`, Replacement: "[CARD]"}}}
+	ctx := withTrace(context.Background(), &traceInfo{RequestID: "req-1"})
+	logPrompt(ctx, "chat", ChatRequest{Message: "card 4111 1111 1111 1111"}, "Charged 4111 1111 1111 1111", errors.New("late"))
+	out := buf.String()
+	for _, want := range []string{`"operation":"chat"`, `"request_id":"req-1"`, `"message":"card [CARD]"`, `"reply":"Charged [CARD]"`, `"error":"late"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %s in the prompt log, got %s", want, out)
+		}
+	}
+	if strings.Contains(out, "4111") {
+		t.Errorf("Expected the card number to be redacted, got %s", out)
+	}
+}
+
+func TestChatEndpointLogsRedactedPrompt(t *testing.T) {
+	router := newFakeChatRouter(t)
+	config.PromptLog = PromptLogConfig{Enabled: true, Redact: []RedactionRule{{Fields: []string{"message"}}}}
+	defer func() { config.PromptLog = PromptLogConfig{} }()
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"message": "my password is hunter2"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", w.Code)
+	}
+	if !strings.Contains(buf.String(), `"message":"[REDACTED]"`) || strings.Contains(buf.String(), "hunter2") || !strings.Contains(buf.String(), fakeReply) {
+		t.Errorf("Expected the redacted prompt and the reply to be logged, got %s", buf.String())
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// User metadata keys provenance is stored under. S3 sends them as
+// X-Amz-Meta-* headers, and copies keep them, so provenance survives
+// renames, moves and the trash.
+const (
+	provenanceGenerator     = "Provenance-Generator"
+	provenanceModel         = "Provenance-Model"
+	provenancePromptSHA256  = "Provenance-Prompt-Sha256"
+	provenanceGeneratedAt   = "Provenance-Generated-At"
+	provenanceSourceVersion = "Provenance-Source-Version"
+)
+
+// ProvenanceConfig marks content the service generates.
+type ProvenanceConfig struct {
+	Watermark string `mapstructure:"watermark" doc:"Text appended to every generated document before it is stored; empty adds none"`
+}
+
+// Provenance records how a stored object was generated.
+type Provenance struct {
+	Generator     string    `json:"generator" doc:"Operation that generated the content, e.g. edit-file"`
+	Model         string    `json:"model" doc:"Model that generated the content"`
+	PromptSHA256  string    `json:"prompt_sha256" doc:"SHA-256 of the prompt sent to the model"`
+	GeneratedAt   time.Time `json:"generated_at" doc:"When the content was generated"`
+	SourceVersion string    `json:"source_version,omitempty" doc:"Version of the object the content was generated from"`
+}
+
+type ProvenanceResponse struct {
+	Bucket     string      `json:"bucket" doc:"MinIO bucket name"`
+	Name       string      `json:"name" doc:"Object name"`
+	VersionID  string      `json:"version_id,omitempty" doc:"Object version"`
+	Provenance *Provenance `json:"provenance" doc:"How the object was generated; null if it was not generated by the service"`
+}
+
+func promptSHA256(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+// metadata returns p as S3 user metadata.
+func (p Provenance) metadata() map[string]string {
+	m := map[string]string{
+		provenanceGenerator:    p.Generator,
+		provenanceModel:        p.Model,
+		provenancePromptSHA256: p.PromptSHA256,
+		provenanceGeneratedAt:  p.GeneratedAt.UTC().Format(time.RFC3339),
+	}
+	if p.SourceVersion != "" {
+		m[provenanceSourceVersion] = p.SourceVersion
+	}
+	return m
+}
+
+// objectProvenance reads provenance from an object's user metadata, or
+// returns nil if the object has none.
+func objectProvenance(info minio.ObjectInfo) *Provenance {
+	generator := info.UserMetadata[provenanceGenerator]
+	if generator == "" {
+		return nil
+	}
+	p := &Provenance{
+		Generator:     generator,
+		Model:         info.UserMetadata[provenanceModel],
+		PromptSHA256:  info.UserMetadata[provenancePromptSHA256],
+		SourceVersion: info.UserMetadata[provenanceSourceVersion],
+	}
+	p.GeneratedAt, _ = time.Parse(time.RFC3339, info.UserMetadata[provenanceGeneratedAt])
+	return p
+}
+
+// statProvenance looks up the provenance of one object.
+func statProvenance(ctx context.Context, client *minio.Client, bucket, name string) (*Provenance, error) {
+	info, err := client.StatObject(ctx, bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return objectProvenance(info), nil
+}
+
+func registerProvenanceEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "get-file-provenance",
+		Method:      http.MethodGet,
+		Path:        "/files/{bucket}/{name}/provenance",
+		Summary:     "Get a file's provenance",
+		Description: "Return the model, prompt hash and time an object was generated with, if the service generated it",
+	}, func(ctx context.Context, input *struct {
+		Bucket        string `path:"bucket" doc:"MinIO bucket name"`
+		Name          string `path:"name" doc:"Object name (URL-encoded if it contains slashes)"`
+		Authorization string `header:"Authorization" doc:"Bearer tenant or admin token; not needed for public objects"`
+	}) (*struct {
+		Body ProvenanceResponse
+	}, error) {
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := decodeObjectKey(input.Name)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkBucketAccess(input.Bucket); err != nil {
+			return nil, err
+		}
+		if err := checkObjectACL(ctx, input.Bucket, name, objectCaller(input.Authorization)); err != nil {
+			return nil, err
+		}
+
+		info, err := client.StatObject(ctx, input.Bucket, name, minio.StatObjectOptions{})
+		if err != nil {
+			if isNotFound(err) {
+				return nil, errObjectNotFound(input.Bucket, name)
+			}
+			return nil, huma.Error500InternalServerError(fmt.Sprintf("Failed to stat object %s", name), err)
+		}
+		return &struct {
+			Body ProvenanceResponse
+		}{Body: ProvenanceResponse{
+			Bucket:     input.Bucket,
+			Name:       name,
+			VersionID:  info.VersionID,
+			Provenance: objectProvenance(info),
+		}}, nil
+	})
+}
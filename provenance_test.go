@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestEditProvenance(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Provenance.Watermark = "\n-- generated"
+
+	objects := map[string]string{
+		"docs/notes/draft.md": "teh draft\n",
+		"docs/notes/plain.md": "written by hand\n",
+	}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+	services.SetOpenAI(newFakeOpenAIClient(0))
+	defer services.SetOpenAI(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerFileEditEndpoint(api)
+	registerProvenanceEndpoint(api)
+	registerFolderEndpoints(api)
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := call("POST", "/files/docs/notes%2Fdraft.md/edit", `{"instruction": "fix typos"}`)
+	var edit FileEditResponse
+	json.Unmarshal(w.Body.Bytes(), &edit)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the edit to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	want := Provenance{
+		Generator:    "edit-file",
+		Model:        defaultModel,
+		PromptSHA256: promptSHA256(editPrompt("fix typos", "teh draft\n")),
+	}
+	if p := edit.Provenance; p.Generator != want.Generator || p.Model != want.Model || p.PromptSHA256 != want.PromptSHA256 || p.GeneratedAt.IsZero() {
+		t.Errorf("Expected %+v, got %+v", want, p)
+	}
+	if !strings.HasSuffix(objects["docs/notes/draft.md"], "\n-- generated") {
+		t.Errorf("Expected the stored document to be watermarked, got %q", objects["docs/notes/draft.md"])
+	}
+
+	w = call("GET", "/files/docs/notes%2Fdraft.md/provenance", "")
+	var resp ProvenanceResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Provenance == nil || *resp.Provenance != edit.Provenance {
+		t.Errorf("Expected the stored provenance, got %d: %s", w.Code, w.Body.String())
+	}
+	w = call("GET", "/files/docs/notes%2Fplain.md/provenance", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"provenance":null`) {
+		t.Errorf("Expected no provenance for a hand-written file, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call("GET", "/files/docs/missing.md/provenance", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a missing file to be reported, got %d", w.Code)
+	}
+
+	w = call("GET", "/folders/docs?path=notes&provenance=true", "")
+	var list FolderListResponse
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if w.Code != http.StatusOK || len(list.Entries) != 2 || list.Entries[0].Provenance == nil || list.Entries[1].Provenance != nil {
+		t.Errorf("Expected the listing to carry provenance, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call("GET", "/folders/docs?path=notes", ""); strings.Contains(w.Body.String(), "provenance") {
+		t.Errorf("Expected provenance only on request, got %s", w.Body.String())
+	}
+}
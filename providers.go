@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ChatProvider generates the chat completions, streamed replies,
+// embeddings and moderation checks of the chat, edit and search endpoints.
+// Requests and responses use the OpenAI types; a provider with another API
+// translates them.
+type ChatProvider interface {
+	CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (*openai.ChatCompletionStream, error)
+	CreateEmbeddings(ctx context.Context, req openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error)
+	Moderations(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error)
+}
+
+// ChatProviderConfig selects the provider chats are sent to.
+type ChatProviderConfig struct {
+	Name string `mapstructure:"name" doc:"Chat provider: openai"`
+}
+
+func (c ChatProviderConfig) Validate() error {
+	if _, ok := chatProviders[c.Name]; !ok {
+		return fmt.Errorf("chat_provider.name: unknown provider %q", c.Name)
+	}
+	return nil
+}
+
+// chatProviders returns each provider's client, or a 503 problem when it is
+// not configured.
+var chatProviders = map[string]func() (ChatProvider, error){
+	"openai": func() (ChatProvider, error) {
+		client, err := services.OpenAI()
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	},
+}
+
+// chatProvider returns the configured chat provider, signing with the
+// request's own key when it sent one to OpenAI.
+func chatProvider(ctx context.Context) (ChatProvider, error) {
+	if config.ChatProvider.Name == "openai" {
+		if c, ok := ctx.Value(requestOpenAIClientKey{}).(*openai.Client); ok {
+			return c, nil
+		}
+	}
+	return chatProviders[config.ChatProvider.Name]()
+}
+
+// modelListTTL is how long a provider's model list is reused before it is
+// fetched again.
+const modelListTTL = 10 * time.Minute
+
+type modelDeprecation struct {
+	ShutdownDate string
+	Replacement  string
+}
+
+// openAIDeprecations lists models OpenAI has announced as deprecated. The
+// models endpoint does not report this itself.
+var openAIDeprecations = map[string]modelDeprecation{
+	"ada":                       {"2024-01-04", "babbage-002"},
+	"babbage":                   {"2024-01-04", "babbage-002"},
+	"curie":                     {"2024-01-04", "davinci-002"},
+	"davinci":                   {"2024-01-04", "davinci-002"},
+	"text-ada-001":              {"2024-01-04", "gpt-3.5-turbo-instruct"},
+	"text-babbage-001":          {"2024-01-04", "gpt-3.5-turbo-instruct"},
+	"text-curie-001":            {"2024-01-04", "gpt-3.5-turbo-instruct"},
+	"text-davinci-002":          {"2024-01-04", "gpt-3.5-turbo-instruct"},
+	"text-davinci-003":          {"2024-01-04", "gpt-3.5-turbo-instruct"},
+	"gpt-3.5-turbo-0301":        {"", "gpt-3.5-turbo"},
+	"gpt-3.5-turbo-0613":        {"2024-09-13", "gpt-3.5-turbo"},
+	"gpt-3.5-turbo-16k-0613":    {"2024-09-13", "gpt-3.5-turbo"},
+	"gpt-4-0314":                {"", "gpt-4"},
+	"gpt-4-32k-0314":            {"", "gpt-4o"},
+	"gpt-4-vision-preview":      {"2024-12-06", "gpt-4o"},
+	"gpt-4-1106-vision-preview": {"2024-12-06", "gpt-4o"},
+	"gpt-4.5-preview":           {"2025-07-14", "gpt-4.1"},
+}
+
+type ProviderModel struct {
+	ID           string    `json:"id" doc:"Model ID"`
+	OwnedBy      string    `json:"owned_by,omitempty" doc:"Organization that owns the model"`
+	CreatedAt    time.Time `json:"created_at,omitempty" doc:"When the model was created"`
+	Deprecated   bool      `json:"deprecated" doc:"Whether the provider has deprecated the model"`
+	ShutdownDate string    `json:"shutdown_date,omitempty" doc:"When a deprecated model stops working, if announced"`
+	Replacement  string    `json:"replacement,omitempty" doc:"Recommended replacement for a deprecated model"`
+}
+
+type ProviderModelsResponse struct {
+	Provider  string          `json:"provider" doc:"Provider name"`
+	Models    []ProviderModel `json:"models" doc:"Models on this page, sorted by ID"`
+	NextAfter string          `json:"next_after,omitempty" doc:"Pass as after to fetch the next page; empty on the last page"`
+	Total     int             `json:"total" doc:"Number of models matching the filters"`
+	FetchedAt time.Time       `json:"fetched_at" doc:"When the list was fetched from the provider"`
+}
+
+var modelListCache struct {
+	sync.Mutex
+	models    []ProviderModel
+	fetchedAt time.Time
+}
+
+// openAIModels returns the models client's key can access, cached for
+// modelListTTL.
+func openAIModels(ctx context.Context, client *openai.Client) ([]ProviderModel, time.Time, error) {
+	modelListCache.Lock()
+	defer modelListCache.Unlock()
+	if !modelListCache.fetchedAt.IsZero() && time.Since(modelListCache.fetchedAt) < modelListTTL {
+		return modelListCache.models, modelListCache.fetchedAt, nil
+	}
+
+	list, err := client.ListModels(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	models := make([]ProviderModel, 0, len(list.Models))
+	for _, m := range list.Models {
+		models = append(models, providerModel(m))
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+
+	modelListCache.models = models
+	modelListCache.fetchedAt = time.Now()
+	return models, modelListCache.fetchedAt, nil
+}
+
+func providerModel(m openai.Model) ProviderModel {
+	pm := ProviderModel{ID: m.ID, OwnedBy: m.OwnedBy}
+	if m.CreatedAt > 0 {
+		pm.CreatedAt = time.Unix(m.CreatedAt, 0).UTC()
+	}
+	if d, ok := openAIDeprecations[m.ID]; ok {
+		pm.Deprecated = true
+		pm.ShutdownDate = d.ShutdownDate
+		pm.Replacement = d.Replacement
+	}
+	return pm
+}
+
+// pageModels returns up to limit models sorted after the given ID, and the
+// cursor for the next page.
+func pageModels(models []ProviderModel, after string, limit int, includeDeprecated bool) (page []ProviderModel, next string, total int) {
+	page = []ProviderModel{}
+	for _, m := range models {
+		if m.Deprecated && !includeDeprecated {
+			continue
+		}
+		total++
+		if m.ID <= after {
+			continue
+		}
+		if len(page) == limit {
+			next = page[len(page)-1].ID
+			continue
+		}
+		page = append(page, m)
+	}
+	return page, next, total
+}
+
+func registerProviderEndpoints(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "list-provider-models",
+		Method:      http.MethodGet,
+		Path:        "/providers/{name}/models",
+		Summary:     "List provider models",
+		Description: "List the models the configured key can access, with deprecation status. The list is cached for 10 minutes",
+	}, func(ctx context.Context, input *struct {
+		Name              string `path:"name" enum:"openai" doc:"Provider name"`
+		After             string `query:"after" doc:"Return models after this ID"`
+		Limit             int    `query:"limit" minimum:"1" maximum:"200" default:"50" doc:"Maximum number of models to return"`
+		IncludeDeprecated bool   `query:"include_deprecated" default:"true" doc:"Include deprecated models"`
+	}) (*struct {
+		Body ProviderModelsResponse
+	}, error) {
+		client, err := services.OpenAI()
+		if err != nil {
+			return nil, err
+		}
+
+		models, fetchedAt, err := openAIModels(ctx, client)
+		if err != nil {
+			return nil, openAIError(ctx, "Failed to list OpenAI models", err)
+		}
+		page, next, total := pageModels(models, input.After, input.Limit, input.IncludeDeprecated)
+
+		return &struct {
+			Body ProviderModelsResponse
+		}{
+			Body: ProviderModelsResponse{
+				Provider:  input.Name,
+				Models:    page,
+				NextAfter: next,
+				Total:     total,
+				FetchedAt: fetchedAt,
+			},
+		}, nil
+	})
+}
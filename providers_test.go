@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+// chatOnlyProvider gives test providers that only answer chats the rest of
+// ChatProvider.
+type chatOnlyProvider struct{}
+
+func (chatOnlyProvider) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (*openai.ChatCompletionStream, error) {
+	return nil, errors.New("streaming is not supported")
+}
+
+func (chatOnlyProvider) CreateEmbeddings(ctx context.Context, req openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error) {
+	return openai.EmbeddingResponse{}, errors.New("embeddings are not supported")
+}
+
+// withEmbeddings answers chats with a test provider and embeddings with an
+// OpenAI client.
+type withEmbeddings struct {
+	ChatProvider
+	client *openai.Client
+}
+
+func (p withEmbeddings) CreateEmbeddings(ctx context.Context, req openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error) {
+	return p.client.CreateEmbeddings(ctx, req)
+}
+
+func TestProviderModel(t *testing.T) {
+	m := providerModel(openai.Model{ID: "text-davinci-003", OwnedBy: "openai-internal", CreatedAt: 1669599635})
+	if !m.Deprecated || m.Replacement != "gpt-3.5-turbo-instruct" || m.CreatedAt.Year() != 2022 {
+		t.Errorf("Unexpected model: %+v", m)
+	}
+	if m := providerModel(openai.Model{ID: "gpt-4o"}); m.Deprecated || !m.CreatedAt.IsZero() {
+		t.Errorf("Expected a current model, got %+v", m)
+	}
+}
+
+func TestPageModels(t *testing.T) {
+	models := []ProviderModel{{ID: "a"}, {ID: "b", Deprecated: true}, {ID: "c"}, {ID: "d"}}
+
+	page, next, total := pageModels(models, "", 2, true)
+	if len(page) != 2 || page[1].ID != "b" || next != "b" || total != 4 {
+		t.Errorf("Unexpected first page: %v %q %d", page, next, total)
+	}
+	page, next, _ = pageModels(models, next, 2, true)
+	if len(page) != 2 || page[0].ID != "c" || next != "" {
+		t.Errorf("Unexpected last page: %v %q", page, next)
+	}
+
+	page, next, total = pageModels(models, "", 10, false)
+	if len(page) != 3 || next != "" || total != 3 {
+		t.Errorf("Expected deprecated models to be filtered, got %v %q %d", page, next, total)
+	}
+}
+
+func TestProviderModelsEndpointNoClient(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	services.SetOpenAI(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerProviderEndpoints(api)
+
+	req := httptest.NewRequest(http.MethodGet, "/providers/openai/models", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/providers/unknown/models", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 for an unknown provider, got %d", w.Code)
+	}
+}
+
+// echoProvider answers with the last message, standing in for a provider
+// other than OpenAI.
+type echoProvider struct {
+	chatOnlyProvider
+	requests int
+}
+
+func (p *echoProvider) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	p.requests++
+	last := req.Messages[len(req.Messages)-1].Content
+	return openai.ChatCompletionResponse{Model: "echo-1", Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "echo: " + last}}}}, nil
+}
+
+func (p *echoProvider) Moderations(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error) {
+	return openai.ModerationResponse{Results: []openai.Result{{}}}, nil
+}
+
+func TestChatProviderSelection(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	services.SetOpenAI(nil)
+	echo := &echoProvider{}
+	chatProviders["echo"] = func() (ChatProvider, error) { return echo, nil }
+	defer delete(chatProviders, "echo")
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerChatEndpoint(api)
+	chat := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"message": "Hi"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := chat(); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without an OpenAI client, got %d", w.Code)
+	}
+	config.ChatProvider.Name = "echo"
+	if w := chat(); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"reply":"echo: Hi"`) || !strings.Contains(w.Body.String(), `"model":"echo-1"`) || echo.requests != 1 {
+		t.Errorf("Expected the echo provider to answer, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := (ChatProviderConfig{Name: "azure"}).Validate(); err == nil {
+		t.Error("Expected an unknown provider to be rejected")
+	}
+}
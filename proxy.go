@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// openAIProxyPrefix is where the OpenAI passthrough is mounted.
+const openAIProxyPrefix = "/proxy/openai"
+
+// ProxyConfig configures the governed OpenAI passthrough for internal teams.
+type ProxyConfig struct {
+	Enabled     bool              `mapstructure:"enabled" doc:"Serve /proxy/openai/*"`
+	UpstreamURL string            `mapstructure:"upstream_url" doc:"OpenAI API base URL requests are forwarded to"`
+	Teams       []ProxyTeamConfig `mapstructure:"teams" doc:"Teams allowed to use the proxy"`
+}
+
+type ProxyTeamConfig struct {
+	Name              string   `mapstructure:"name" doc:"Team name used in logs and usage reports"`
+	Token             string   `mapstructure:"token" doc:"Bearer token the team authenticates with"`
+	RequestsPerMinute int      `mapstructure:"requests_per_minute" doc:"Sustained request rate; 0 means unlimited"`
+	DailyTokenBudget  int64    `mapstructure:"daily_token_budget" doc:"OpenAI tokens the team may use per UTC day; 0 means unlimited"`
+	AllowedPaths      []string `mapstructure:"allowed_paths" doc:"OpenAI API paths the team may call, with the paths below them, e.g. /v1/chat/completions; empty allows all"`
+}
+
+func (c ProxyConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if u, err := url.Parse(c.UpstreamURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("proxy.upstream_url: %q is not an absolute URL", c.UpstreamURL)
+	}
+	names := map[string]bool{}
+	for _, t := range c.Teams {
+		if t.Name == "" || t.Token == "" {
+			return errors.New("proxy.teams: every team needs a name and a token")
+		}
+		if names[t.Name] {
+			return fmt.Errorf("proxy.teams: duplicate team %q", t.Name)
+		}
+		names[t.Name] = true
+		if t.RequestsPerMinute < 0 || t.DailyTokenBudget < 0 {
+			return fmt.Errorf("proxy.teams.%s: limits must not be negative", t.Name)
+		}
+	}
+	return nil
+}
+
+// proxyTeamState tracks a team's request rate and token spend.
+type proxyTeamState struct {
+	allowance float64
+	lastCheck time.Time
+	day       string
+	tokens    int64
+	requests  int64
+}
+
+// proxyGovernor enforces rate limits and budgets per team.
+type proxyGovernor struct {
+	mu    sync.Mutex
+	teams map[string]*proxyTeamState
+}
+
+var proxyUsage = &proxyGovernor{teams: map[string]*proxyTeamState{}}
+
+func (g *proxyGovernor) state(team string, now time.Time) *proxyTeamState {
+	s := g.teams[team]
+	if s == nil {
+		s = &proxyTeamState{lastCheck: now, allowance: math.Inf(1)}
+		g.teams[team] = s
+	}
+	if day := now.UTC().Format(time.DateOnly); s.day != day {
+		s.day, s.tokens, s.requests = day, 0, 0
+	}
+	return s
+}
+
+// admit checks the team's budget and takes one request from its rate limit.
+func (g *proxyGovernor) admit(t ProxyTeamConfig, now time.Time) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := g.state(t.Name, now)
+	if t.DailyTokenBudget > 0 && s.tokens >= t.DailyTokenBudget {
+		return codedError(http.StatusTooManyRequests, "ERR_BUDGET_EXCEEDED",
+			fmt.Sprintf("Team %s used its daily budget of %d tokens", t.Name, t.DailyTokenBudget))
+	}
+	if t.RequestsPerMinute > 0 {
+		// Token bucket holding up to one minute of requests
+		limit := float64(t.RequestsPerMinute)
+		s.allowance = math.Min(limit, s.allowance+now.Sub(s.lastCheck).Minutes()*limit)
+		s.lastCheck = now
+		if s.allowance < 1 {
+			return huma.Error429TooManyRequests(fmt.Sprintf("Team %s exceeded %d requests per minute", t.Name, t.RequestsPerMinute))
+		}
+		s.allowance--
+	}
+	s.requests++
+	return nil
+}
+
+func (g *proxyGovernor) recordTokens(team string, tokens int64, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.state(team, now).tokens += tokens
+}
+
+// proxyTeam returns the team whose token is in the Authorization header.
+func proxyTeam(authorization string) (ProxyTeamConfig, bool) {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return ProxyTeamConfig{}, false
+	}
+	for _, t := range config.Proxy.Teams {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+			return t, true
+		}
+	}
+	return ProxyTeamConfig{}, false
+}
+
+// proxyExchange carries what the proxy learns about one request.
+type proxyExchange struct {
+	team   string
+	tokens int64
+}
+
+type proxyExchangeKey struct{}
+
+// charge records tokens used by the exchange for its team.
+func (ex *proxyExchange) charge(tokens int64) {
+	if tokens <= 0 {
+		return
+	}
+	ex.tokens = tokens
+	now := time.Now()
+	proxyUsage.recordTokens(ex.team, tokens, now)
+	usage.recordTokens(now, int(tokens))
+}
+
+// proxyUsagePayload is the part of a response, or of one streamed event,
+// that reports usage. Chat completions report it at the top, the
+// responses API in the response of its completed event.
+type proxyUsagePayload struct {
+	Usage *struct {
+		TotalTokens int64 `json:"total_tokens"`
+	} `json:"usage"`
+	Response *proxyUsagePayload `json:"response"`
+}
+
+func (p proxyUsagePayload) totalTokens() int64 {
+	switch {
+	case p.Usage != nil:
+		return p.Usage.TotalTokens
+	case p.Response != nil:
+		return p.Response.totalTokens()
+	}
+	return 0
+}
+
+// recordProxyUsage reads the token usage of a JSON response and charges it
+// to the team. The usage of event streams is charged once they end.
+func recordProxyUsage(resp *http.Response) error {
+	ex, _ := resp.Request.Context().Value(proxyExchangeKey{}).(*proxyExchange)
+	if ex == nil {
+		return nil
+	}
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mt == "text/event-stream" {
+		resp.Body = &streamUsageReader{body: resp.Body, ex: ex}
+		return nil
+	}
+	if mt != "application/json" {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	var payload proxyUsagePayload
+	if json.Unmarshal(body, &payload) == nil {
+		ex.charge(payload.totalTokens())
+	}
+	return nil
+}
+
+// maxStreamLine bounds the event data a streamUsageReader holds; longer
+// lines carry content, not usage, and are skipped.
+const maxStreamLine = 64 << 10
+
+// streamUsageReader passes an event stream through unchanged while
+// picking up the usage reported in its data lines, and charges it when
+// the stream is closed.
+type streamUsageReader struct {
+	body    io.ReadCloser
+	ex      *proxyExchange
+	line    []byte
+	skip    bool // the current line is too long to hold
+	tokens  int64
+	charged bool
+}
+
+func (r *streamUsageReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.scan(p[:n])
+	return n, err
+}
+
+func (r *streamUsageReader) scan(b []byte) {
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			r.hold(b)
+			return
+		}
+		r.hold(b[:i])
+		if data, ok := bytes.CutPrefix(bytes.TrimSpace(r.line), []byte("data:")); ok && !r.skip {
+			var payload proxyUsagePayload
+			if json.Unmarshal(bytes.TrimSpace(data), &payload) == nil {
+				if tokens := payload.totalTokens(); tokens > 0 {
+					r.tokens = tokens
+				}
+			}
+		}
+		r.line, r.skip = r.line[:0], false
+		b = b[i+1:]
+	}
+}
+
+func (r *streamUsageReader) hold(b []byte) {
+	if r.skip || len(r.line)+len(b) > maxStreamLine {
+		r.line, r.skip = r.line[:0], true
+		return
+	}
+	r.line = append(r.line, b...)
+}
+
+func (r *streamUsageReader) Close() error {
+	if !r.charged {
+		r.charged = true
+		r.ex.charge(r.tokens)
+	}
+	return r.body.Close()
+}
+
+// newOpenAIProxy returns the handler for /proxy/openai/*. Requests are
+// authenticated with a team token, checked against the team's limits and
+// forwarded with the service's own OpenAI key.
+func newOpenAIProxy(upstream *url.URL) http.Handler {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(upstream)
+			// The path the team's allowed paths were checked against
+			r.Out.URL.Path = strings.TrimSuffix(upstream.Path, "/") + cleanPath(strings.TrimPrefix(r.In.URL.Path, openAIProxyPrefix))
+			r.Out.URL.RawPath = ""
+			r.Out.Host = upstream.Host
+			// The upstream key is added by the transport; let the
+			// transport negotiate compression so usage can be read
+			r.Out.Header.Del("Authorization")
+			r.Out.Header.Del("Accept-Encoding")
+		},
+		Transport:      &openAIKeyTransport{base: newOpenAITransport()},
+		FlushInterval:  -1,
+		ModifyResponse: recordProxyUsage,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			writeError(w, huma.Error502BadGateway("OpenAI request failed", err))
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := services.OpenAI(); err != nil {
+			writeError(w, err)
+			return
+		}
+		team, ok := proxyTeam(r.Header.Get("Authorization"))
+		if !ok {
+			writeError(w, huma.Error401Unauthorized("Invalid or missing proxy token"))
+			return
+		}
+		path := cleanPath(strings.TrimPrefix(r.URL.Path, openAIProxyPrefix))
+		if len(team.AllowedPaths) > 0 && !hasPathPrefix(path, team.AllowedPaths) {
+			writeError(w, huma.Error403Forbidden(fmt.Sprintf("Team %s may not call %s", team.Name, path)))
+			return
+		}
+		model, err := prepareProxyRequest(r, path)
+		if err != nil {
+			writeError(w, huma.Error400BadRequest("Failed to read the request body", err))
+			return
+		}
+		if model != "" {
+			if err := checkAllowedModel(model); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		if err := proxyUsage.admit(team, time.Now()); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		start := time.Now()
+		ex := &proxyExchange{team: team.Name}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		ctx := context.WithValue(r.Context(), proxyExchangeKey{}, ex)
+		proxy.ServeHTTP(rec, r.WithContext(ctx))
+
+		requestID := "-"
+		if t := traceFromContext(ctx); t != nil {
+			if id := t.lastUpstream("openai"); id != "" {
+				requestID = id
+			}
+		}
+		log.Printf("Proxy %s %s %s: %d in %s, %d tokens, OpenAI request ID %s",
+			team.Name, r.Method, path, rec.status, time.Since(start).Round(time.Millisecond), ex.tokens, requestID)
+	})
+}
+
+// prepareProxyRequest returns the model named by a JSON request body, and
+// puts the body back for the upstream request. Streamed completions are
+// asked to report their usage, which OpenAI otherwise leaves out of
+// streams. Uploads, which are multipart forms, are passed on unread.
+func prepareProxyRequest(r *http.Request, path string) (string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if r.Body == nil || r.Body == http.NoBody || strings.HasPrefix(mediaType, "multipart/") {
+		return "", nil
+	}
+	b, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	var req struct {
+		Model         string                     `json:"model"`
+		Stream        bool                       `json:"stream"`
+		StreamOptions map[string]json.RawMessage `json:"stream_options"`
+	}
+	// A body that is not JSON is left for OpenAI to refuse
+	if json.Unmarshal(b, &req) == nil && req.Stream && (strings.HasSuffix(path, "/chat/completions") || strings.HasSuffix(path, "/v1/completions")) {
+		var body map[string]json.RawMessage
+		json.Unmarshal(b, &body)
+		if req.StreamOptions == nil {
+			req.StreamOptions = map[string]json.RawMessage{}
+		}
+		req.StreamOptions["include_usage"] = json.RawMessage("true")
+		body["stream_options"], _ = json.Marshal(req.StreamOptions)
+		b, _ = json.Marshal(body)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(b))
+	r.ContentLength = int64(len(b))
+	return req.Model, nil
+}
+
+type ProxyTeamUsage struct {
+	Team             string `json:"team" doc:"Team name"`
+	Requests         int64  `json:"requests" doc:"Requests today (UTC)"`
+	Tokens           int64  `json:"tokens" doc:"OpenAI tokens used today (UTC)"`
+	DailyTokenBudget int64  `json:"daily_token_budget" doc:"Daily token budget; 0 means unlimited"`
+}
+
+type ProxyUsageResponse struct {
+	Day   string           `json:"day" doc:"UTC day the usage covers"`
+	Teams []ProxyTeamUsage `json:"teams" doc:"Usage per configured team"`
+}
+
+func registerProxyUsageEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "get-proxy-usage",
+		Method:      http.MethodGet,
+		Path:        "/admin/proxy/usage",
+		Summary:     "Get OpenAI proxy usage",
+		Description: "Report each team's requests and token spend through the OpenAI proxy today",
+	}, func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer admin token"`
+	}) (*struct {
+		Body ProxyUsageResponse
+	}, error) {
+		if err := requireAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		resp := ProxyUsageResponse{Day: now.UTC().Format(time.DateOnly), Teams: []ProxyTeamUsage{}}
+		proxyUsage.mu.Lock()
+		for _, t := range config.Proxy.Teams {
+			s := proxyUsage.state(t.Name, now)
+			resp.Teams = append(resp.Teams, ProxyTeamUsage{
+				Team:             t.Name,
+				Requests:         s.requests,
+				Tokens:           s.tokens,
+				DailyTokenBudget: t.DailyTokenBudget,
+			})
+		}
+		proxyUsage.mu.Unlock()
+		sort.Slice(resp.Teams, func(i, j int) bool { return resp.Teams[i].Team < resp.Teams[j].Team })
+
+		return &struct {
+			Body ProxyUsageResponse
+		}{Body: resp}, nil
+	})
+}
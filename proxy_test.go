@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestProxyGovernor(t *testing.T) {
+	g := &proxyGovernor{teams: map[string]*proxyTeamState{}}
+	now := time.Date(2026, 5, 1, 23, 59, 0, 0, time.UTC)
+
+	limited := ProxyTeamConfig{Name: "search", RequestsPerMinute: 2}
+	for i := 0; i < 2; i++ {
+		if err := g.admit(limited, now); err != nil {
+			t.Fatalf("Request %d: unexpected error: %v", i, err)
+		}
+	}
+	if err := g.admit(limited, now); err == nil {
+		t.Error("Expected the third request in a minute to be limited")
+	}
+	if err := g.admit(limited, now.Add(30*time.Second)); err != nil {
+		t.Errorf("Expected the rate limit to refill, got %v", err)
+	}
+
+	budgeted := ProxyTeamConfig{Name: "support", DailyTokenBudget: 100}
+	g.recordTokens("support", 100, now)
+	if err := g.admit(budgeted, now); err == nil || err.(*APIError).Code != "ERR_BUDGET_EXCEEDED" {
+		t.Errorf("Expected the budget to be exceeded, got %v", err)
+	}
+	if err := g.admit(budgeted, now.Add(time.Minute)); err != nil {
+		t.Errorf("Expected the budget to reset the next day, got %v", err)
+	}
+}
+
+func TestOpenAIProxy(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "admin-token"
+	config.Proxy.Teams = []ProxyTeamConfig{
+		{Name: "search", Token: "search-token", DailyTokenBudget: 50, AllowedPaths: []string{"/v1/chat/"}},
+		{Name: "assist", Token: "assist-token", DailyTokenBudget: 20},
+	}
+	proxyUsage = &proxyGovernor{teams: map[string]*proxyTeamState{}}
+	secrets.set("sk-service", "", "")
+	defer secrets.set("", "", "")
+
+	var gotAuth, gotPath string
+	var gotBody map[string]any
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth, gotPath = r.Header.Get("Authorization"), r.URL.Path
+		gotBody = nil
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		if gotBody["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: {\"choices\": [{\"delta\": {\"content\": \"Hi\"}}], \"usage\": null}\n\n"))
+			w.Write([]byte("data: {\"choices\": [], \"usage\": {\"total_tokens\": 25}}\n\ndata: [DONE]\n\n"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices": [], "usage": {"total_tokens": 30}}`))
+	}))
+	defer upstream.Close()
+	services.SetOpenAI(newTestOpenAIClient(upstream.URL))
+	defer services.SetOpenAI(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerProxyUsageEndpoint(api)
+	upstreamURL, _ := url.Parse(upstream.URL)
+	router.Handle(openAIProxyPrefix+"/*", newOpenAIProxy(upstreamURL))
+
+	call := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"model": "gpt-4o-mini"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := call("/proxy/openai/v1/chat/completions", "wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unknown token, got %d", w.Code)
+	}
+	for _, path := range []string{"/proxy/openai/v1/files", "/proxy/openai/v1/chat/../files", "/proxy/openai/v1/chatter"} {
+		if w := call(path, "search-token"); w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for %s outside the allow list, got %d", path, w.Code)
+		}
+	}
+	config.OpenAI.AllowedModels = []string{"gpt-4o"}
+	if w := call("/proxy/openai/v1/chat/completions", "search-token"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "ERR_MODEL_NOT_ALLOWED") {
+		t.Errorf("Expected a model outside openai.allowed_models to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+	config.OpenAI.AllowedModels = nil
+
+	w := call("/proxy/openai/v1/chat/completions", "search-token")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if gotAuth != "Bearer sk-service" || gotPath != "/v1/chat/completions" {
+		t.Errorf("Expected the service key on /v1/chat/completions, got %q on %q", gotAuth, gotPath)
+	}
+	if !strings.Contains(w.Body.String(), `"total_tokens": 30`) {
+		t.Errorf("Expected the upstream body to be passed through, got %s", w.Body.String())
+	}
+
+	call("/proxy/openai/v1/chat/completions", "search-token")
+	w = call("/proxy/openai/v1/chat/completions", "search-token")
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "ERR_BUDGET_EXCEEDED") {
+		t.Errorf("Expected the budget to be exceeded after 60 tokens, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/proxy/usage", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var report ProxyUsageResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to decode usage: %v", err)
+	}
+	if len(report.Teams) != 2 || report.Teams[1].Requests != 2 || report.Teams[1].Tokens != 60 {
+		t.Errorf("Unexpected usage report: %+v", report)
+	}
+
+	// Streams are asked for their usage, which is charged when they end
+	stream := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/proxy/openai/v1/chat/completions", strings.NewReader(`{"model": "gpt-4o-mini", "stream": true}`))
+		req.Header.Set("Authorization", "Bearer assist-token")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	w = stream()
+	if options, _ := gotBody["stream_options"].(map[string]any); options["include_usage"] != true {
+		t.Errorf("Expected the stream to be asked for usage, got %v", gotBody)
+	}
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "data: [DONE]") {
+		t.Errorf("Expected the stream to be passed through, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := stream(); w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "ERR_BUDGET_EXCEEDED") {
+		t.Errorf("Expected the streamed tokens to use up the budget, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+type FileRenameRequest struct {
+	NewName   string `json:"new_name" minLength:"1" doc:"New object name"`
+	Overwrite bool   `json:"overwrite,omitempty" doc:"Replace an existing object with the new name"`
+}
+
+type FileRenameResponse struct {
+	Success bool   `json:"success" doc:"Rename success status"`
+	Message string `json:"message" doc:"Rename result message"`
+	Name    string `json:"name" doc:"New object name"`
+}
+
+func registerFileRenameEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "rename-file",
+		Method:      http.MethodPut,
+		Path:        "/files/{bucket}/{name}/rename",
+		Summary:     "Rename a file",
+		Description: "Rename an object within its bucket. The object is copied to the new name and the original removed; if either step fails the original is left in place",
+	}, func(ctx context.Context, input *struct {
+		Bucket string `path:"bucket" doc:"MinIO bucket name"`
+		Name   string `path:"name" doc:"Object name (URL-encoded if it contains slashes)"`
+		Body   FileRenameRequest
+	}) (*struct {
+		Body FileRenameResponse
+	}, error) {
+		if err := checkBucketAccess(input.Bucket); err != nil {
+			return nil, err
+		}
+
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := decodeObjectKey(input.Name)
+		if err != nil {
+			return nil, err
+		}
+		newName := input.Body.NewName
+		if strings.HasPrefix(name, trashPrefix) || strings.HasPrefix(newName, trashPrefix) {
+			return nil, huma.Error400BadRequest("Use the trash endpoints to move objects in or out of the trash")
+		}
+		if newName == name {
+			return nil, huma.Error400BadRequest("New name must differ from the current name")
+		}
+
+		if !input.Body.Overwrite {
+			if _, err := client.StatObject(ctx, input.Bucket, newName, minio.StatObjectOptions{}); err == nil {
+				return nil, huma.Error409Conflict(fmt.Sprintf("Object %s already exists in bucket %s", newName, input.Bucket))
+			} else if !isNotFound(err) {
+				return nil, huma.Error500InternalServerError("Failed to check object existence", err)
+			}
+		}
+
+		if err := moveObject(ctx, input.Bucket, name, newName); err != nil {
+			if isNotFound(err) {
+				return nil, errObjectNotFound(input.Bucket, name)
+			}
+			return nil, huma.Error500InternalServerError("Failed to rename object", err)
+		}
+
+		return &struct {
+			Body FileRenameResponse
+		}{
+			Body: FileRenameResponse{
+				Success: true,
+				Message: fmt.Sprintf("File %s renamed to %s in bucket %s", name, newName, input.Bucket),
+				Name:    newName,
+			},
+		}, nil
+	})
+}
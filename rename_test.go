@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestFileRenameEndpointWithoutClient(t *testing.T) {
+	// Initialize config for testing
+	viper.Reset()
+	initConfig()
+
+	// Ensure MinIO client is not initialized
+	services.SetMinIO(nil)
+
+	// Create test router and API
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+
+	// Register rename endpoint
+	registerFileRenameEndpoint(api)
+
+	// Create test request
+	jsonBody, _ := json.Marshal(FileRenameRequest{NewName: "renamed.txt"})
+	req := httptest.NewRequest("PUT", "/files/test-bucket/test.txt/rename", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	// Execute request
+	router.ServeHTTP(w, req)
+
+	// Should return 503 Service Unavailable since MinIO client is not configured
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code 503, got %d", w.Code)
+	}
+}
+
+func TestFileRename(t *testing.T) {
+	viper.Reset()
+	initConfig()
+
+	objects := map[string]string{
+		"docs/draft.txt":  "draft",
+		"docs/taken.txt":  "taken",
+		"docs/locked.txt": "locked",
+		"app-system/acls/docs.json": `{
+			"draft.txt": {"visibility": "private", "owner": "acme"},
+			"locked.txt": {"visibility": "private", "owner": "acme"}
+		}`,
+	}
+	fake := fakeS3(objects)
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The source of this rename cannot be deleted
+		if r.Method == http.MethodDelete && r.URL.Path == "/docs/locked.txt" {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`))
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerFileRenameEndpoint(api)
+	rename := func(name string, body FileRenameRequest) *httptest.ResponseRecorder {
+		jsonBody, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPut, "/files/docs/"+name+"/rename", bytes.NewBuffer(jsonBody))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := rename("draft.txt", FileRenameRequest{NewName: "final.txt"}); w.Code != http.StatusOK {
+		t.Fatalf("Expected the rename to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, ok := objects["docs/draft.txt"]; ok || objects["docs/final.txt"] != "draft" {
+		t.Errorf("Expected the object to move to its new name, got %v", objects)
+	}
+	var acls map[string]ObjectACL
+	json.Unmarshal([]byte(objects["app-system/acls/docs.json"]), &acls)
+	if _, ok := acls["draft.txt"]; ok || acls["final.txt"].Owner != "acme" {
+		t.Errorf("Expected the ACL to move with the object, got %+v", acls)
+	}
+
+	if w := rename("final.txt", FileRenameRequest{NewName: "taken.txt"}); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for an existing name, got %d", w.Code)
+	}
+	if objects["docs/final.txt"] != "draft" || objects["docs/taken.txt"] != "taken" {
+		t.Errorf("Expected a refused rename to change nothing, got %v", objects)
+	}
+	if w := rename("final.txt", FileRenameRequest{NewName: "taken.txt", Overwrite: true}); w.Code != http.StatusOK || objects["docs/taken.txt"] != "draft" {
+		t.Errorf("Expected overwrite to replace the existing object, got %d: %v", w.Code, objects)
+	}
+
+	// The object a failed move would replace is put back
+	if w := rename("locked.txt", FileRenameRequest{NewName: "taken.txt", Overwrite: true}); w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 when the original cannot be removed, got %d", w.Code)
+	}
+	if objects["docs/taken.txt"] != "draft" || objects["docs/locked.txt"] != "locked" {
+		t.Errorf("Expected the replaced object to be restored, got %v", objects)
+	}
+	for key := range objects {
+		if strings.HasPrefix(key, "app-system/"+moveBackupPrefix) {
+			t.Errorf("Expected the backup to be removed, found %s", key)
+		}
+	}
+
+	if w := rename("locked.txt", FileRenameRequest{NewName: "moved.txt"}); w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 when the original cannot be removed, got %d", w.Code)
+	}
+	if _, ok := objects["docs/moved.txt"]; ok || objects["docs/locked.txt"] != "locked" {
+		t.Errorf("Expected the copy to be rolled back and the original kept, got %v", objects)
+	}
+	acls = nil
+	json.Unmarshal([]byte(objects["app-system/acls/docs.json"]), &acls)
+	if _, ok := acls["moved.txt"]; ok || acls["locked.txt"].Owner != "acme" {
+		t.Errorf("Expected the ACL to stay with the original, got %+v", acls)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	src := "# Title\n\n<script>alert(1)</script>\n\n[click](javascript:alert(1))\n\n```go\nfunc main() {}\n```\n"
+
+	out, err := renderMarkdown([]byte(src))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	html := string(out)
+
+	if !strings.Contains(html, "<h1>Title</h1>") {
+		t.Errorf("Expected heading to be rendered, got %s", html)
+	}
+	if strings.Contains(html, "<script>") {
+		t.Error("Expected raw HTML to be stripped")
+	}
+	if strings.Contains(html, "javascript:") {
+		t.Error("Expected javascript: links to be stripped")
+	}
+	if !strings.Contains(html, "<pre") || !strings.Contains(html, "style=") {
+		t.Errorf("Expected highlighted code block, got %s", html)
+	}
+}
+
+func TestFileRenderEndpointWithoutClient(t *testing.T) {
+	// Initialize config for testing
+	viper.Reset()
+	initConfig()
+
+	// Ensure MinIO client is not initialized
+	services.SetMinIO(nil)
+
+	// Create test router and API
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+
+	// Register render endpoint
+	registerFileRenderEndpoint(api)
+
+	req := httptest.NewRequest("GET", "/files/test-bucket/README.md/render", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Should return 503 Service Unavailable since MinIO client is not configured
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code 503, got %d", w.Code)
+	}
+}
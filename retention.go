@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// Data classes with a retention policy.
+const (
+	retentionUploads     = "uploads"
+	retentionAuditLogs   = "audit_logs"
+	retentionTranscripts = "transcripts"
+)
+
+// RetentionConfig sets how long each class of stored data is kept. A
+// scheduled job purges data older than its class's retention.
+type RetentionConfig struct {
+	IntervalMinutes int                       `mapstructure:"interval_minutes" doc:"Minutes between retention purges; 0 disables the scheduled purge"`
+	DryRun          bool                      `mapstructure:"dry_run" doc:"Only log what the scheduled purge would delete"`
+	Uploads         UploadRetentionConfig     `mapstructure:"uploads" doc:"Retention of uploaded files"`
+	AuditLogs       AuditRetentionConfig      `mapstructure:"audit_logs" doc:"Retention of compliance records such as deletion reports"`
+	Transcripts     TranscriptRetentionConfig `mapstructure:"transcripts" doc:"Retention of conversation transcripts"`
+}
+
+type UploadRetentionConfig struct {
+	Days    int      `mapstructure:"days" doc:"Days uploaded files are kept after their last modification; 0 keeps them forever"`
+	Buckets []string `mapstructure:"buckets" doc:"Buckets holding uploaded files the policy applies to"`
+}
+
+type AuditRetentionConfig struct {
+	Days int `mapstructure:"days" doc:"Days deletion reports are kept; 0 keeps them forever"`
+}
+
+type TranscriptRetentionConfig struct {
+	Days int `mapstructure:"days" doc:"Days transcripts are kept after their last exchange; 0 keeps them forever"`
+}
+
+func (c RetentionConfig) Validate() error {
+	var errs []error
+	if c.IntervalMinutes < 0 {
+		errs = append(errs, errors.New("retention.interval_minutes must not be negative"))
+	}
+	if c.Uploads.Days < 0 {
+		errs = append(errs, errors.New("retention.uploads.days must not be negative"))
+	}
+	if c.Uploads.Days > 0 && len(c.Uploads.Buckets) == 0 {
+		errs = append(errs, errors.New("retention.uploads.buckets must list the buckets to purge"))
+	}
+	if c.AuditLogs.Days < 0 {
+		errs = append(errs, errors.New("retention.audit_logs.days must not be negative"))
+	}
+	if c.Transcripts.Days < 0 {
+		errs = append(errs, errors.New("retention.transcripts.days must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// enabled reports whether any data class has a retention period.
+func (c RetentionConfig) enabled() bool {
+	return c.Uploads.Days > 0 || c.AuditLogs.Days > 0 || c.Transcripts.Days > 0
+}
+
+type PurgedItem struct {
+	Bucket       string    `json:"bucket" doc:"Bucket the data was stored in"`
+	Name         string    `json:"name" doc:"Object name"`
+	LastModified time.Time `json:"last_modified" doc:"When the object was last written"`
+}
+
+type RetentionClassReport struct {
+	Class         string         `json:"class" enum:"uploads,audit_logs,transcripts" doc:"Data class"`
+	RetentionDays int            `json:"retention_days" doc:"Days the class is kept"`
+	Cutoff        time.Time      `json:"cutoff" doc:"Data last modified before this time is purged"`
+	Purged        []PurgedItem   `json:"purged" doc:"Data that was deleted, or would be in a dry run"`
+	Retained      []RetainedItem `json:"retained" doc:"Expired data that could not be deleted, with the reason"`
+}
+
+// RetentionReport describes one purge run.
+type RetentionReport struct {
+	DryRun  bool                   `json:"dry_run" doc:"Whether nothing was deleted"`
+	RanAt   time.Time              `json:"ran_at" doc:"When the purge ran"`
+	Classes []RetentionClassReport `json:"classes" doc:"Results per data class with a retention period"`
+}
+
+// purged returns how many objects the run deleted or would delete.
+func (r RetentionReport) purged() int {
+	n := 0
+	for _, c := range r.Classes {
+		n += len(c.Purged)
+	}
+	return n
+}
+
+// purgeExpired deletes the objects under prefix in bucket that were last
+// modified before cutoff, skipping trashed objects, which have their own
+// retention. Objects that cannot be deleted, e.g. because of a legal hold,
+// are reported as retained.
+func purgeExpired(ctx context.Context, client *minio.Client, bucket, prefix string, cutoff time.Time, dryRun bool, report *RetentionClassReport) error {
+	var removed []string
+	for obj := range client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			if isNotFound(obj.Err) {
+				break
+			}
+			return obj.Err
+		}
+		if strings.HasPrefix(obj.Key, trashPrefix) || !obj.LastModified.Before(cutoff) {
+			continue
+		}
+		if !dryRun {
+			if err := client.RemoveObject(ctx, bucket, obj.Key, minio.RemoveObjectOptions{}); err != nil && !isNotFound(err) {
+				report.Retained = append(report.Retained, RetainedItem{Class: "object", Bucket: bucket, Name: obj.Key, Reason: "deletion failed: " + err.Error()})
+				continue
+			}
+			removed = append(removed, obj.Key)
+		}
+		report.Purged = append(report.Purged, PurgedItem{Bucket: bucket, Name: obj.Key, LastModified: obj.LastModified})
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	return updateACLs(ctx, bucket, func(acls map[string]ObjectACL) bool {
+		changed := false
+		for _, key := range removed {
+			if _, ok := acls[key]; ok {
+				delete(acls, key)
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+// runRetention purges every data class whose retention has expired as of
+// now. With dryRun it only reports what would be purged.
+func runRetention(ctx context.Context, now time.Time, dryRun bool) (RetentionReport, error) {
+	report := RetentionReport{DryRun: dryRun, RanAt: now.UTC(), Classes: []RetentionClassReport{}}
+	client, err := services.MinIO()
+	if err != nil {
+		return report, err
+	}
+	policy := config.Retention
+
+	if days := policy.Uploads.Days; days > 0 {
+		class := RetentionClassReport{Class: retentionUploads, RetentionDays: days, Cutoff: report.RanAt.AddDate(0, 0, -days), Purged: []PurgedItem{}, Retained: []RetainedItem{}}
+		for _, bucket := range policy.Uploads.Buckets {
+			// Internal state is never an upload
+			if bucket == config.MinIO.SystemBucket {
+				continue
+			}
+			if err := purgeExpired(ctx, client, bucket, "", class.Cutoff, dryRun, &class); err != nil {
+				return report, fmt.Errorf("%s in bucket %s: %w", retentionUploads, bucket, err)
+			}
+		}
+		report.Classes = append(report.Classes, class)
+	}
+	if days := policy.AuditLogs.Days; days > 0 {
+		class := RetentionClassReport{Class: retentionAuditLogs, RetentionDays: days, Cutoff: report.RanAt.AddDate(0, 0, -days), Purged: []PurgedItem{}, Retained: []RetainedItem{}}
+		if err := purgeExpired(ctx, client, config.MinIO.SystemBucket, deletionReportsPrefix, class.Cutoff, dryRun, &class); err != nil {
+			return report, fmt.Errorf("%s: %w", retentionAuditLogs, err)
+		}
+		report.Classes = append(report.Classes, class)
+	}
+	if days := policy.Transcripts.Days; days > 0 {
+		class := RetentionClassReport{Class: retentionTranscripts, RetentionDays: days, Cutoff: report.RanAt.AddDate(0, 0, -days), Purged: []PurgedItem{}, Retained: []RetainedItem{}}
+		if err := purgeExpired(ctx, client, transcriptBucket(), config.Conversations.Transcripts.Prefix, class.Cutoff, dryRun, &class); err != nil {
+			return report, fmt.Errorf("%s: %w", retentionTranscripts, err)
+		}
+		report.Classes = append(report.Classes, class)
+	}
+	return report, nil
+}
+
+// startRetentionPurger runs runRetention periodically until ctx is
+// cancelled. Dry runs log each object that would be deleted.
+func startRetentionPurger(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				report, err := runRetention(ctx, now, config.Retention.DryRun)
+				if err != nil {
+					log.Printf("Retention purge failed: %v", err)
+					continue
+				}
+				for _, class := range report.Classes {
+					if report.DryRun {
+						for _, item := range class.Purged {
+							log.Printf("Retention dry run: would delete %s %s/%s", class.Class, item.Bucket, item.Name)
+						}
+					}
+					for _, item := range class.Retained {
+						log.Printf("Retention purge kept %s %s/%s: %s", class.Class, item.Bucket, item.Name, item.Reason)
+					}
+				}
+				if n := report.purged(); n > 0 && !report.DryRun {
+					log.Printf("Retention purge removed %d objects", n)
+				}
+			}
+		}
+	}()
+}
+
+func registerRetentionEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "run-retention",
+		Method:      http.MethodPost,
+		Path:        "/admin/retention/run",
+		Summary:     "Run the retention purge",
+		Description: "Purge data whose retention period has expired now, or with dry_run report what would be purged without deleting anything",
+	}, func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer admin token"`
+		DryRun        bool   `query:"dry_run" doc:"Only report what would be purged"`
+		ApprovalID    string `header:"X-Approval-ID" doc:"Approved request for run-retention on all; required when approvals.admins is set, except for dry runs"`
+	}) (*struct {
+		Body RetentionReport
+	}, error) {
+		if err := requireAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+		if !input.DryRun {
+			if err := consumeApproval(ctx, input.ApprovalID, approvalRunRetention, approvalTargetAll); err != nil {
+				return nil, err
+			}
+		}
+		report, err := runRetention(ctx, time.Now(), input.DryRun)
+		if err != nil {
+			var status huma.StatusError
+			if errors.As(err, &status) {
+				return nil, err
+			}
+			return nil, huma.Error500InternalServerError("Retention purge failed", err)
+		}
+		return &struct {
+			Body RetentionReport
+		}{Body: report}, nil
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestRetentionConfigValidate(t *testing.T) {
+	invalid := []RetentionConfig{
+		{IntervalMinutes: -1},
+		{Uploads: UploadRetentionConfig{Days: -1}},
+		{Uploads: UploadRetentionConfig{Days: 30}},
+		{AuditLogs: AuditRetentionConfig{Days: -1}},
+		{Transcripts: TranscriptRetentionConfig{Days: -1}},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", c)
+		}
+	}
+	valid := RetentionConfig{IntervalMinutes: 60, Uploads: UploadRetentionConfig{Days: 30, Buckets: []string{"uploads"}}}
+	if err := valid.Validate(); err != nil || !valid.enabled() {
+		t.Errorf("Expected %+v to be valid and enabled, got %v", valid, err)
+	}
+}
+
+func TestRunRetention(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "admin-token"
+	config.Retention.Uploads = UploadRetentionConfig{Days: 30, Buckets: []string{"uploads", "app-system"}}
+	config.Retention.AuditLogs.Days = 365
+
+	// fakeS3 lists every object as last modified on 2026-01-02
+	objects := map[string]string{
+		"uploads/report.pdf":            "old",
+		"uploads/.trash/draft.txt":      "trashed",
+		"other/report.pdf":              "not covered",
+		"app-system/collections/a.json": "{}",
+		"app-system/compliance/deletions/acme/20260102T150405Z-1.json": "{}",
+		"app-system/acls/uploads.json":                                 `{"report.pdf": {"visibility": "private", "owner": "acme"}}`,
+		"app-system/transcripts/conv_1.json":                           `{"conversation_id": "conv_1"}`,
+	}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	ctx := context.Background()
+	report, err := runRetention(ctx, time.Date(2026, 1, 20, 0, 0, 0, 0, time.UTC), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.purged() != 0 || len(report.Classes) != 2 {
+		t.Errorf("Expected nothing to be purged before the retention expired, got %+v", report)
+	}
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerRetentionEndpoint(api)
+	run := func(query string) RetentionReport {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/admin/retention/run"+query, nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var report RetentionReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+
+	// The endpoint purges as of now, when a year may not have passed yet
+	config.Retention.AuditLogs.Days = 0
+	before := len(objects)
+	dry := run("?dry_run=true")
+	if !dry.DryRun || len(dry.Classes) != 1 || len(dry.Classes[0].Purged) != 1 || dry.Classes[0].Purged[0].Name != "report.pdf" {
+		t.Errorf("Expected the dry run to report the expired upload only, got %+v", dry)
+	}
+	if len(objects) != before {
+		t.Errorf("Expected a dry run not to delete anything, got %v", objects)
+	}
+
+	purged := run("")
+	if purged.DryRun || purged.purged() != 1 {
+		t.Errorf("Expected the expired upload to be purged, got %+v", purged)
+	}
+	if _, ok := objects["uploads/report.pdf"]; ok {
+		t.Error("Expected the expired upload to be deleted")
+	}
+	for _, key := range []string{"uploads/.trash/draft.txt", "other/report.pdf", "app-system/collections/a.json", "app-system/transcripts/conv_1.json"} {
+		if _, ok := objects[key]; !ok {
+			t.Errorf("Expected %s to be kept", key)
+		}
+	}
+	if strings.Contains(objects["app-system/acls/uploads.json"], "report.pdf") {
+		t.Error("Expected the purged upload's ACL to be removed")
+	}
+
+	config.Retention.AuditLogs.Days = 365
+	config.Retention.Transcripts.Days = 30
+	report, err = runRetention(ctx, time.Date(2027, 2, 1, 0, 0, 0, 0, time.UTC), false)
+	if err != nil || report.purged() != 2 || report.Classes[1].Purged[0].Name != "compliance/deletions/acme/20260102T150405Z-1.json" {
+		t.Errorf("Expected the expired deletion report to be purged, got %+v, %v", report, err)
+	}
+	if len(report.Classes) != 3 || report.Classes[2].Class != retentionTranscripts || len(report.Classes[2].Purged) != 1 {
+		t.Errorf("Expected the expired transcript to be purged, got %+v", report)
+	}
+	if _, ok := objects["app-system/transcripts/conv_1.json"]; ok {
+		t.Error("Expected the expired transcript to be deleted")
+	}
+	if _, ok := objects["app-system/collections/a.json"]; !ok {
+		t.Error("Expected the transcripts class to leave the rest of the system bucket alone")
+	}
+}
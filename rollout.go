@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// RolloutConfig rolls a new default chat model out to a share of the chats,
+// and rolls it back when it does worse than the current default.
+type RolloutConfig struct {
+	Model                string  `mapstructure:"model" doc:"Model rolled out as the default of chats that name none; empty disables the rollout"`
+	Percent              float64 `mapstructure:"percent" doc:"Percentage of those chats sent to the model"`
+	Window               int     `mapstructure:"window" doc:"Recent chats of each model the error rates and latencies are compared over"`
+	MinRequests          int     `mapstructure:"min_requests" doc:"Chats each model needs in the window before they are compared"`
+	MaxErrorRateIncrease float64 `mapstructure:"max_error_rate_increase" doc:"Percentage points the model's error rate may exceed the default's before it is rolled back"`
+	MaxLatencyIncrease   float64 `mapstructure:"max_latency_increase" doc:"Percent the model's p95 latency may exceed the default's before it is rolled back; 0 disables the check"`
+}
+
+func (c RolloutConfig) Validate() error {
+	if c.Percent < 0 || c.Percent > 100 {
+		return errors.New("rollout.percent must be between 0 and 100")
+	}
+	if c.Window < 1 {
+		return errors.New("rollout.window must be positive")
+	}
+	if c.MinRequests < 1 || c.MinRequests > c.Window {
+		return errors.New("rollout.min_requests must be between 1 and rollout.window")
+	}
+	if c.MaxErrorRateIncrease < 0 || c.MaxLatencyIncrease < 0 {
+		return errors.New("rollout thresholds must not be negative")
+	}
+	return nil
+}
+
+// The arms of a rollout: chats sent to the rolled out model, and the others.
+const (
+	rolloutCandidate = "candidate"
+	rolloutBase      = "base"
+)
+
+type rolloutArmKey struct{}
+
+// rolloutOutcome is how one chat went. A zero latency leaves the chat out
+// of the latency comparison.
+type rolloutOutcome struct {
+	failed  bool
+	latency time.Duration
+}
+
+// rolloutArm keeps the outcomes of an arm's recent chats in a ring.
+type rolloutArm struct {
+	recent   []rolloutOutcome
+	next     int
+	requests int64
+	errors   int64
+}
+
+func (a *rolloutArm) add(o rolloutOutcome, window int) {
+	a.requests++
+	if o.failed {
+		a.errors++
+	}
+	if len(a.recent) < window {
+		a.recent = append(a.recent, o)
+		return
+	}
+	a.recent[a.next] = o
+	a.next = (a.next + 1) % len(a.recent)
+}
+
+type RolloutArmStats struct {
+	Requests       int64   `json:"requests" doc:"Chats since the rollout started"`
+	Errors         int64   `json:"errors" doc:"Failed chats since the rollout started"`
+	Window         int     `json:"window" doc:"Recent chats the rates are computed over"`
+	ErrorRate      float64 `json:"error_rate" doc:"Percentage of the recent chats that failed"`
+	LatencySamples int     `json:"latency_samples" doc:"Recent successful chats with a measured latency"`
+	P95LatencyMS   int64   `json:"p95_latency_ms" doc:"95th percentile latency of those chats in milliseconds"`
+}
+
+func (a *rolloutArm) stats() RolloutArmStats {
+	s := RolloutArmStats{Requests: a.requests, Errors: a.errors, Window: len(a.recent)}
+	var failed int
+	var latencies []time.Duration
+	for _, o := range a.recent {
+		switch {
+		case o.failed:
+			failed++
+		case o.latency > 0:
+			latencies = append(latencies, o.latency)
+		}
+	}
+	if s.Window > 0 {
+		s.ErrorRate = float64(failed) * 100 / float64(s.Window)
+	}
+	if s.LatencySamples = len(latencies); s.LatencySamples > 0 {
+		slices.Sort(latencies)
+		s.P95LatencyMS = latencies[(len(latencies)*95-1)/100].Milliseconds()
+	}
+	return s
+}
+
+type RolloutRollback struct {
+	At     time.Time `json:"at" doc:"When the model was rolled back"`
+	Reason string    `json:"reason" doc:"The regression that rolled it back"`
+}
+
+// modelRollout is the state of the configured rollout on this instance.
+// It starts over when rollout.model changes.
+type modelRollout struct {
+	mu       sync.Mutex
+	model    string
+	arms     map[string]*rolloutArm
+	rollback *RolloutRollback
+}
+
+var rollout = &modelRollout{}
+
+// syncLocked starts over if the rolled out model has changed. Callers hold
+// mu.
+func (r *modelRollout) syncLocked() {
+	if r.arms == nil || r.model != config.Rollout.Model {
+		r.resetLocked()
+	}
+}
+
+// resetLocked forgets the outcomes and any rollback. Callers hold mu.
+func (r *modelRollout) resetLocked() {
+	r.model = config.Rollout.Model
+	r.arms = map[string]*rolloutArm{rolloutCandidate: {}, rolloutBase: {}}
+	r.rollback = nil
+}
+
+// regressionLocked compares the arms and describes how the rolled out
+// model does worse than allowed, if it does. Callers hold mu.
+func (r *modelRollout) regressionLocked(c RolloutConfig) (reason string, current, baseline float64) {
+	cand, base := r.arms[rolloutCandidate].stats(), r.arms[rolloutBase].stats()
+	if cand.Window < c.MinRequests || base.Window < c.MinRequests {
+		return "", 0, 0
+	}
+	if cand.ErrorRate-base.ErrorRate > c.MaxErrorRateIncrease {
+		return fmt.Sprintf("error rate %.1f%% against %.1f%%", cand.ErrorRate, base.ErrorRate), cand.ErrorRate, base.ErrorRate
+	}
+	if c.MaxLatencyIncrease > 0 && cand.LatencySamples >= c.MinRequests && base.LatencySamples >= c.MinRequests &&
+		float64(cand.P95LatencyMS) > float64(base.P95LatencyMS)*(1+c.MaxLatencyIncrease/100) {
+		return fmt.Sprintf("p95 latency %dms against %dms", cand.P95LatencyMS, base.P95LatencyMS), float64(cand.P95LatencyMS), float64(base.P95LatencyMS)
+	}
+	return "", 0, 0
+}
+
+// rolloutChatModel picks the model of a chat: requested if it names one,
+// otherwise the rolled out model for rollout.percent of the chats. Chats
+// whose tenant policy sets a default model or does not allow the rolled out
+// one are left out. The returned context records the arm the chat is in.
+func rolloutChatModel(ctx context.Context, requested string) (context.Context, string) {
+	c := config.Rollout
+	if requested != "" || c.Model == "" || c.Percent <= 0 {
+		return ctx, requested
+	}
+	if tp, _ := ctx.Value(policyKey{}).(*tenantPolicy); tp != nil && (tp.policy.DefaultModel != "" || len(tp.policy.Models) > 0 && !slices.Contains(tp.policy.Models, c.Model)) {
+		return ctx, requested
+	}
+	rollout.mu.Lock()
+	rollout.syncLocked()
+	rolledBack := rollout.rollback != nil
+	rollout.mu.Unlock()
+	if rolledBack {
+		return ctx, requested
+	}
+	if rand.Float64()*100 < c.Percent {
+		return context.WithValue(ctx, rolloutArmKey{}, rolloutCandidate), c.Model
+	}
+	return context.WithValue(ctx, rolloutArmKey{}, rolloutBase), requested
+}
+
+// observeRollout records how a chat of the rollout went, and rolls the
+// model back when it regressed. Chats the caller canceled are not counted.
+func observeRollout(ctx context.Context, latency time.Duration, err error) {
+	arm, ok := ctx.Value(rolloutArmKey{}).(string)
+	if !ok || errors.Is(err, context.Canceled) {
+		return
+	}
+	c := config.Rollout
+	rollout.mu.Lock()
+	// The chat started before a reconfiguration or rollback
+	if rollout.arms == nil || rollout.model != c.Model || rollout.rollback != nil {
+		rollout.mu.Unlock()
+		return
+	}
+	if err != nil {
+		latency = 0
+	}
+	rollout.arms[arm].add(rolloutOutcome{failed: err != nil, latency: latency}, c.Window)
+	reason, current, baseline := rollout.regressionLocked(c)
+	now := time.Now()
+	if reason != "" {
+		rollout.rollback = &RolloutRollback{At: now, Reason: reason}
+	}
+	rollout.mu.Unlock()
+	if reason == "" {
+		return
+	}
+
+	log.Printf("Rollout of %s rolled back: %s", c.Model, reason)
+	audit(ctx, AuditEvent{Action: "rollout.rolled_back", Detail: c.Model + ": " + reason})
+	if alertsEnabled() {
+		go sendAlert(context.WithoutCancel(ctx), Alert{
+			Metric:   "rollout:" + c.Model,
+			Current:  current,
+			Baseline: baseline,
+			Message:  fmt.Sprintf("Rollout of %s rolled back: %s", c.Model, reason),
+			Time:     now,
+		})
+	}
+}
+
+type RolloutStatus struct {
+	Model     string           `json:"model" doc:"Model being rolled out"`
+	BaseModel string           `json:"base_model" doc:"Default model of the other chats"`
+	Percent   float64          `json:"percent" doc:"Percentage of the chats sent to the model"`
+	State     string           `json:"state" enum:"disabled,active,rolled_back" doc:"Whether chats are being sent to the model"`
+	Rollback  *RolloutRollback `json:"rollback,omitempty" doc:"Why the model was rolled back"`
+	Candidate RolloutArmStats  `json:"candidate" doc:"Chats sent to the model"`
+	Base      RolloutArmStats  `json:"base" doc:"Chats sent to the default model"`
+}
+
+func rolloutStatus() RolloutStatus {
+	rollout.mu.Lock()
+	defer rollout.mu.Unlock()
+	rollout.syncLocked()
+	s := RolloutStatus{
+		Model:     rollout.model,
+		BaseModel: defaultModel,
+		Percent:   config.Rollout.Percent,
+		State:     "active",
+		Rollback:  rollout.rollback,
+		Candidate: rollout.arms[rolloutCandidate].stats(),
+		Base:      rollout.arms[rolloutBase].stats(),
+	}
+	switch {
+	case s.Rollback != nil:
+		s.State = "rolled_back"
+	case s.Model == "" || s.Percent <= 0:
+		s.State = "disabled"
+	}
+	return s
+}
+
+func registerRolloutEndpoints(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "get-rollout",
+		Method:      http.MethodGet,
+		Path:        "/admin/rollout",
+		Summary:     "Show the model rollout",
+		Description: "Return how the model being rolled out does against the default model on this instance, and whether it was rolled back",
+	}, func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer admin token"`
+	}) (*struct {
+		Body RolloutStatus
+	}, error) {
+		if err := requireAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+		return &struct {
+			Body RolloutStatus
+		}{Body: rolloutStatus()}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "reset-rollout",
+		Method:      http.MethodPost,
+		Path:        "/admin/rollout/reset",
+		Summary:     "Restart the model rollout",
+		Description: "Forget the outcomes of the rollout and resume it after a rollback",
+	}, func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer admin token"`
+	}) (*struct {
+		Body RolloutStatus
+	}, error) {
+		if err := requireAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+		rollout.mu.Lock()
+		rollout.resetLocked()
+		rollout.mu.Unlock()
+		audit(ctx, AuditEvent{Action: "rollout.reset", Operation: "reset-rollout", Detail: config.Rollout.Model})
+		return &struct {
+			Body RolloutStatus
+		}{Body: rolloutStatus()}, nil
+	})
+}
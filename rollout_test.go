@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+// failingModelProvider fails the chats sent to one model.
+type failingModelProvider struct {
+	chatOnlyProvider
+	model string
+}
+
+func (p failingModelProvider) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	if req.Model == p.model {
+		return openai.ChatCompletionResponse{}, errors.New("model unavailable")
+	}
+	return openai.ChatCompletionResponse{Model: req.Model, Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "Hello"}}}}, nil
+}
+
+func (p failingModelProvider) Moderations(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error) {
+	return openai.ModerationResponse{Results: []openai.Result{{}}}, nil
+}
+
+func TestModelRollout(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "admin-token"
+	config.ChatProvider.Name = "failing"
+	chatProviders["failing"] = func() (ChatProvider, error) { return failingModelProvider{model: "gpt-4o"}, nil }
+	defer delete(chatProviders, "failing")
+	config.Rollout = RolloutConfig{Model: "gpt-4o", Percent: 50, Window: 20, MinRequests: 5, MaxErrorRateIncrease: 10}
+	rollout = &modelRollout{}
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerChatEndpoint(api)
+	registerRolloutEndpoints(api)
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer admin-token")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	status := func() RolloutStatus {
+		var s RolloutStatus
+		json.Unmarshal(call(http.MethodGet, "/admin/rollout", "").Body.Bytes(), &s)
+		return s
+	}
+
+	for range 200 {
+		if status().State == "rolled_back" {
+			break
+		}
+		call(http.MethodPost, "/chat", `{"message": "Hi"}`)
+		// Chats naming a model are not part of the rollout
+		call(http.MethodPost, "/chat", `{"message": "Hi", "model": "gpt-4o"}`)
+	}
+	s := status()
+	if s.State != "rolled_back" || s.Rollback == nil || !strings.Contains(s.Rollback.Reason, "error rate") {
+		t.Fatalf("Expected the failing model to be rolled back, got %+v", s)
+	}
+	if s.Candidate.Errors != s.Candidate.Requests || s.Base.Errors != 0 || s.Candidate.Requests+s.Base.Requests >= 200 {
+		t.Errorf("Expected only the chats left to the rollout to be counted, got %+v", s)
+	}
+	for range 10 {
+		if w := call(http.MethodPost, "/chat", `{"message": "Hi"}`); w.Code != http.StatusOK {
+			t.Fatalf("Expected chats to use the default model after the rollback, got %d", w.Code)
+		}
+	}
+
+	if s := call(http.MethodPost, "/admin/rollout/reset", ""); s.Code != http.StatusOK || status().State != "active" || status().Candidate.Requests != 0 {
+		t.Errorf("Expected the reset to resume the rollout, got %d: %s", s.Code, s.Body.String())
+	}
+
+	// Latency regressions roll back too
+	config.Rollout.MaxLatencyIncrease = 50
+	for range 5 {
+		observeRollout(context.WithValue(context.Background(), rolloutArmKey{}, rolloutBase), 100*time.Millisecond, nil)
+		observeRollout(context.WithValue(context.Background(), rolloutArmKey{}, rolloutCandidate), 200*time.Millisecond, nil)
+	}
+	if s := status(); s.State != "rolled_back" || !strings.Contains(s.Rollback.Reason, "p95 latency 200ms against 100ms") {
+		t.Errorf("Expected the slower model to be rolled back, got %+v", s)
+	}
+}
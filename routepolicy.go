@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// routePolicyKey is the operation metadata key of its RoutePolicy.
+const routePolicyKey = "route-policy"
+
+// RoutePolicy is how an operation is run, declared with the operation by
+// withRoutePolicy and applied by routePolicyMiddleware.
+type RoutePolicy struct {
+	// Timeout bounds the request's context; 0 leaves it unbounded.
+	// Streaming operations must not set it, as it would cut their stream.
+	Timeout time.Duration
+	// Retry replaces openai_retry for the calls the operation makes to
+	// OpenAI.
+	Retry *RetryPolicy
+	// RateClass is the rate_limits.classes entry requests count against.
+	RateClass string
+	// Budgeted refuses requests once their caller spent its cost budget,
+	// and charges the OpenAI calls they make to it.
+	Budgeted bool
+}
+
+// RetryPolicy retries calls that fail transiently with jittered
+// exponential backoff.
+type RetryPolicy struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// delay returns the jittered backoff before retry n, counting from 1: a
+// random duration up to the base delay doubled n-1 times, capped at the max
+// delay.
+func (p RetryPolicy) delay(n int) time.Duration {
+	ceiling := p.MaxDelay
+	if d := p.BaseDelay << min(n-1, 30); d > 0 && d < ceiling {
+		ceiling = d
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling) + 1
+}
+
+// withRoutePolicy declares how op is run.
+func withRoutePolicy(op huma.Operation, p RoutePolicy) huma.Operation {
+	if op.Metadata == nil {
+		op.Metadata = map[string]any{}
+	}
+	op.Metadata[routePolicyKey] = p
+	return op
+}
+
+type retryPolicyKey struct{}
+
+// retryPolicyFor returns the retry policy of the operation ctx belongs to,
+// or openai_retry.
+func retryPolicyFor(ctx context.Context) RetryPolicy {
+	if p, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy); ok {
+		return p
+	}
+	return config.OpenAIRetry.policy()
+}
+
+// RateLimitsConfig limits the request rate of the classes operations
+// declare.
+type RateLimitsConfig struct {
+	Classes map[string]RateClassConfig `mapstructure:"classes" doc:"Limits by rate class: chat and generation"`
+}
+
+type RateClassConfig struct {
+	RequestsPerMinute int `mapstructure:"requests_per_minute" doc:"Sustained requests per minute of each caller; 0 means unlimited"`
+	Burst             int `mapstructure:"burst" doc:"Requests a caller may make at once after being idle; defaults to requests_per_minute"`
+}
+
+func (c RateLimitsConfig) Validate() error {
+	for name, class := range c.Classes {
+		if class.RequestsPerMinute < 0 || class.Burst < 0 {
+			return fmt.Errorf("rate_limits.classes.%s: limits must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// rateBucket is the token bucket of one caller in one class.
+type rateBucket struct {
+	allowance float64
+	lastCheck time.Time
+}
+
+// rateLimiter enforces the rate classes on this instance. Callers are
+// tenants, the admin and anonymous callers, who share one bucket.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+var rateLimits = &rateLimiter{buckets: map[string]*rateBucket{}}
+
+// take counts one request of caller against class.
+func (l *rateLimiter) take(class, caller string, now time.Time) error {
+	c := config.RateLimits.Classes[class]
+	if c.RequestsPerMinute <= 0 {
+		return nil
+	}
+	burst := float64(c.Burst)
+	if burst <= 0 {
+		burst = float64(c.RequestsPerMinute)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := class + "/" + caller
+	b := l.buckets[key]
+	if b == nil {
+		b = &rateBucket{allowance: burst, lastCheck: now}
+		l.buckets[key] = b
+	}
+	b.allowance = math.Min(burst, b.allowance+now.Sub(b.lastCheck).Minutes()*float64(c.RequestsPerMinute))
+	b.lastCheck = now
+	if b.allowance < 1 {
+		return huma.Error429TooManyRequests(fmt.Sprintf("More than %d %s requests per minute; retry later", c.RequestsPerMinute, class))
+	}
+	b.allowance--
+	return nil
+}
+
+// rateLimitCaller names who a request counts against.
+func rateLimitCaller(authorization string) string {
+	switch c := objectCaller(authorization); {
+	case c.admin:
+		return "admin"
+	case c.tenant != "":
+		return "tenant:" + c.tenant
+	}
+	return "anonymous"
+}
+
+// routePolicyMiddleware applies the policy an operation declares: its rate
+// class and cost budget, then its retry policy and timeout for the handler.
+func routePolicyMiddleware(ctx huma.Context, next func(huma.Context)) {
+	p, ok := ctx.Operation().Metadata[routePolicyKey].(RoutePolicy)
+	if !ok {
+		next(ctx)
+		return
+	}
+	caller := rateLimitCaller(ctx.Header("Authorization"))
+	if p.RateClass != "" {
+		if err := rateLimits.take(p.RateClass, caller, time.Now()); err != nil {
+			writeHumaError(ctx, err)
+			return
+		}
+	}
+
+	c := ctx.Context()
+	if p.Budgeted {
+		// Requests made with the caller's own OpenAI key are billed to it
+		if ctx.Header(openAIKeyHeader) == "" || !config.OpenAI.AllowKeyHeader {
+			if err := costs.admit(caller, time.Now()); err != nil {
+				writeHumaError(ctx, err)
+				return
+			}
+		}
+		c = context.WithValue(c, costCallerKey{}, caller)
+	}
+	if p.Retry != nil {
+		c = context.WithValue(c, retryPolicyKey{}, *p.Retry)
+	}
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		c, cancel = context.WithTimeout(c, p.Timeout)
+		defer cancel()
+	}
+	next(huma.WithContext(ctx, c))
+}
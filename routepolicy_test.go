@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestRoutePolicyMiddleware(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "admin-token"
+	config.Storage.Tenants = []StorageTenantConfig{{Name: "acme", Token: "acme-token", Bucket: "tenants"}}
+	defer func() { config.Storage.Tenants = nil }()
+	config.RateLimits.Classes = map[string]RateClassConfig{"generation": {RequestsPerMinute: 2}}
+	// Decoding the configuration keeps map entries, so reset the classes
+	defer func() { config.RateLimits.Classes = nil }()
+	rateLimits = &rateLimiter{buckets: map[string]*rateBucket{}}
+
+	var (
+		deadline time.Duration
+		retry    RetryPolicy
+	)
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	api.UseMiddleware(routePolicyMiddleware)
+	huma.Register(api, withRoutePolicy(huma.Operation{OperationID: "generate", Method: http.MethodPost, Path: "/generate"}, RoutePolicy{
+		Timeout:   time.Minute,
+		RateClass: "generation",
+		Retry:     &RetryPolicy{Attempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Second},
+	}), func(ctx context.Context, input *struct{}) (*struct{}, error) {
+		d, _ := ctx.Deadline()
+		deadline, retry = time.Until(d), retryPolicyFor(ctx)
+		return nil, nil
+	})
+	huma.Register(api, huma.Operation{OperationID: "plain", Method: http.MethodGet, Path: "/plain"}, func(ctx context.Context, input *struct{}) (*struct{}, error) {
+		_, bounded := ctx.Deadline()
+		if bounded || retryPolicyFor(ctx) != config.OpenAIRetry.policy() {
+			t.Error("Expected an operation without a policy to run unchanged")
+		}
+		return nil, nil
+	})
+	call := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := call(http.MethodPost, "/generate", "acme-token"); code != http.StatusNoContent {
+		t.Fatalf("Expected the request to pass, got %d", code)
+	}
+	if deadline <= 50*time.Second || deadline > time.Minute || retry.Attempts != 5 {
+		t.Errorf("Expected the declared timeout and retries, got %s and %+v", deadline, retry)
+	}
+
+	// Each caller has its own bucket of the class
+	call(http.MethodPost, "/generate", "acme-token")
+	if code := call(http.MethodPost, "/generate", "acme-token"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the third request in a minute to be limited, got %d", code)
+	}
+	if code := call(http.MethodPost, "/generate", "admin-token"); code != http.StatusNoContent {
+		t.Errorf("Expected another caller not to be limited, got %d", code)
+	}
+	call(http.MethodGet, "/plain", "acme-token")
+
+	// Classes without limits are unlimited
+	config.RateLimits.Classes = nil
+	if code := call(http.MethodPost, "/generate", "acme-token"); code != http.StatusNoContent {
+		t.Errorf("Expected an unconfigured class to be unlimited, got %d", code)
+	}
+}
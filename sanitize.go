@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// allowedTags are kept by sanitizeHTML, with the attributes each may carry
+// on top of globalAttrs. Other tags are dropped but their text is kept.
+var allowedTags = map[string][]string{
+	"html": {"lang"}, "head": nil, "body": nil, "title": nil,
+	"meta": {"charset", "name", "content"}, "link": {"rel", "href", "media"}, "style": {"media"},
+	"a": {"href", "name", "target"}, "img": {"src", "alt", "width", "height"},
+	"abbr": nil, "article": nil, "aside": nil, "b": nil, "blockquote": {"cite"}, "br": nil,
+	"caption": nil, "code": nil, "col": {"span"}, "colgroup": {"span"}, "dd": nil, "del": nil,
+	"details": {"open"}, "div": nil, "dl": nil, "dt": nil, "em": nil, "figcaption": nil,
+	"figure": nil, "footer": nil, "h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil,
+	"h6": nil, "header": nil, "hr": nil, "i": nil, "ins": nil, "kbd": nil, "li": {"value"},
+	"main": nil, "mark": nil, "nav": nil, "ol": {"start", "type"}, "p": nil, "pre": nil,
+	"q": {"cite"}, "s": nil, "section": nil, "small": nil, "span": nil, "strong": nil,
+	"sub": nil, "summary": nil, "sup": nil, "table": nil, "tbody": nil, "td": {"colspan", "rowspan"},
+	"tfoot": nil, "th": {"colspan", "rowspan", "scope"}, "thead": nil, "time": {"datetime"},
+	"tr": nil, "u": nil, "ul": nil,
+}
+
+// globalAttrs may appear on any allowed tag.
+var globalAttrs = []string{"id", "class", "title", "lang", "dir", "style", "align"}
+
+// droppedTags are removed together with everything inside them.
+var droppedTags = map[string]bool{
+	"script": true, "iframe": true, "object": true, "embed": true, "svg": true, "math": true,
+	"template": true, "noscript": true, "textarea": true, "select": true, "frameset": true,
+}
+
+// urlAttrs hold URLs and are checked against safeURLSchemes.
+var urlAttrs = map[string]bool{"href": true, "src": true, "cite": true}
+
+var safeURLSchemes = map[string]bool{"": true, "http": true, "https": true, "mailto": true, "tel": true}
+
+// safeURL reports whether a link is relative or uses a harmless scheme.
+func safeURL(raw string) bool {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	return err == nil && safeURLSchemes[strings.ToLower(u.Scheme)]
+}
+
+// safeCSS rejects the few CSS constructs that can run script or load
+// content in older browsers.
+func safeCSS(css string) bool {
+	css = strings.ToLower(css)
+	return !strings.Contains(css, "expression(") && !strings.Contains(css, "javascript:") &&
+		!strings.Contains(css, "@import") && !strings.Contains(css, "behavior:")
+}
+
+// sanitizeHTML keeps a safe subset of HTML: formatting, tables, images and
+// links. Scripts, frames, plugins, forms, event handlers, meta refreshes
+// and javascript: URLs are removed.
+func sanitizeHTML(src []byte) []byte {
+	var out bytes.Buffer
+	z := html.NewTokenizer(bytes.NewReader(src))
+	skip, skipDepth := "", 0
+	inStyle := false
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return out.Bytes()
+		}
+		tok := z.Token()
+
+		if skip != "" {
+			switch {
+			case tt == html.StartTagToken && tok.Data == skip:
+				skipDepth++
+			case tt == html.EndTagToken && tok.Data == skip:
+				if skipDepth--; skipDepth == 0 {
+					skip = ""
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case html.DoctypeToken:
+			out.WriteString("<!DOCTYPE html>")
+		case html.TextToken:
+			if inStyle {
+				// Style contents are raw text and must not be escaped
+				if safeCSS(tok.Data) {
+					out.WriteString(tok.Data)
+				}
+			} else {
+				out.WriteString(html.EscapeString(tok.Data))
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedTags[tok.Data] {
+				if tt == html.StartTagToken {
+					skip, skipDepth = tok.Data, 1
+				}
+				continue
+			}
+			attrs, ok := allowedTags[tok.Data]
+			if !ok {
+				continue
+			}
+			if tok.Data == "link" && !isStylesheet(tok.Attr) {
+				continue
+			}
+			out.WriteString("<" + tok.Data)
+			blank := false
+			for _, a := range tok.Attr {
+				if !allowedAttr(a.Key, attrs) || (urlAttrs[a.Key] && !safeURL(a.Val)) ||
+					(a.Key == "style" && !safeCSS(a.Val)) {
+					continue
+				}
+				if a.Key == "target" && a.Val == "_blank" {
+					blank = true
+				}
+				out.WriteString(" " + a.Key + `="` + html.EscapeString(a.Val) + `"`)
+			}
+			if blank {
+				// Keep opened pages from reaching back through window.opener
+				out.WriteString(` rel="noopener noreferrer"`)
+			}
+			out.WriteString(">")
+			inStyle = tok.Data == "style" && tt == html.StartTagToken
+		case html.EndTagToken:
+			if _, ok := allowedTags[tok.Data]; ok {
+				out.WriteString("</" + tok.Data + ">")
+			}
+			inStyle = false
+		}
+	}
+}
+
+func allowedAttr(key string, tagAttrs []string) bool {
+	for _, a := range tagAttrs {
+		if a == key {
+			return true
+		}
+	}
+	for _, a := range globalAttrs {
+		if a == key {
+			return true
+		}
+	}
+	return false
+}
+
+func isStylesheet(attrs []html.Attribute) bool {
+	for _, a := range attrs {
+		if a.Key == "rel" && strings.EqualFold(strings.TrimSpace(a.Val), "stylesheet") {
+			return true
+		}
+	}
+	return false
+}
+
+// htmlLinks returns the targets of a document's links, images and
+// stylesheets in document order, without duplicates.
+func htmlLinks(src []byte) []string {
+	var links []string
+	seen := map[string]bool{}
+	z := html.NewTokenizer(bytes.NewReader(src))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return links
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		tok := z.Token()
+		for _, a := range tok.Attr {
+			if (a.Key == "href" && (tok.Data == "a" || tok.Data == "link")) || (a.Key == "src" && tok.Data == "img") {
+				if v := strings.TrimSpace(a.Val); v != "" && !seen[v] {
+					seen[v] = true
+					links = append(links, v)
+				}
+			}
+		}
+	}
+}
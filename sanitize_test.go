@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	cases := map[string]string{
+		`<p onclick="steal()">Hi <b>there</b></p>`:                    `<p>Hi <b>there</b></p>`,
+		`<script>alert(1)</script><p>after</p>`:                       `<p>after</p>`,
+		`<a href="javascript:alert(1)">x</a>`:                         `<a>x</a>`,
+		`<a href=" JaVaScRiPt:alert(1)">x</a>`:                        `<a>x</a>`,
+		`<a href="/q1/" target="_blank">Q1</a>`:                       `<a href="/q1/" target="_blank" rel="noopener noreferrer">Q1</a>`,
+		`<img src="data:image/png;base64,AAAA" alt="x">`:              `<img alt="x">`,
+		`<iframe src="https://evil.example"><p>x</p></iframe>ok`:      `ok`,
+		`<svg><svg><script>x</script></svg></svg>ok`:                  `ok`,
+		`<form action="/steal"><input name="pw">Name</form>`:          `Name`,
+		`<meta http-equiv="refresh" content="0;url=https://evil">`:    `<meta content="0;url=https://evil">`,
+		`<style>p > b { color: red }</style>`:                         `<style>p > b { color: red }</style>`,
+		`<style>p { background: url("javascript:x") }</style>`:        `<style></style>`,
+		`<p style="width: expression(alert(1))">x</p>`:                `<p>x</p>`,
+		`<link rel="stylesheet" href="style.css"><link rel="import">`: `<link rel="stylesheet" href="style.css">`,
+		`<p>1 &lt; 2 &amp; <!-- hidden --> 3</p>`:                     `<p>1 &lt; 2 &amp;  3</p>`,
+	}
+	for in, want := range cases {
+		if got := string(sanitizeHTML([]byte(in))); got != want {
+			t.Errorf("sanitizeHTML(%q)\n got %q\nwant %q", in, got, want)
+		}
+	}
+
+	doc := string(sanitizeHTML([]byte("<!doctype html><html><head><title>R</title></head><body><h1>R</h1></body></html>")))
+	if !strings.HasPrefix(doc, "<!DOCTYPE html><html><head><title>R</title>") {
+		t.Errorf("Expected a full document to keep its structure, got %s", doc)
+	}
+}
+
+func TestHTMLLinks(t *testing.T) {
+	src := `<a href="q1/">Q1</a> <img src="chart.png"> <link rel="stylesheet" href="style.css"> <a href="q1/">again</a> <a name="top">top</a>`
+	want := []string{"q1/", "chart.png", "style.css"}
+	if got := htmlLinks([]byte(src)); !reflect.DeepEqual(got, want) {
+		t.Errorf("htmlLinks = %v, want %v", got, want)
+	}
+}
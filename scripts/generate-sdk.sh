@@ -0,0 +1,35 @@
+#!/bin/sh
+# Generates the OpenAPI spec and Go/TypeScript clients for the current API
+# version into sdk/<version>/, where GET /sdk serves them from.
+#
+# Requires Go and Docker. Usage: scripts/generate-sdk.sh [output-dir]
+set -eu
+
+out="${1:-sdk}"
+generator="openapitools/openapi-generator-cli:v7.8.0"
+
+version="$(go run . openapi 2>/dev/null | sed -n 's/^    "version": "\(.*\)",\{0,1\}$/\1/p' | head -n 1)"
+if [ -z "$version" ]; then
+	echo "could not determine API version" >&2
+	exit 1
+fi
+
+dir="$out/$version"
+work="$(mktemp -d)"
+trap 'rm -rf "$work"' EXIT
+
+mkdir -p "$dir"
+go run . openapi > "$dir/openapi.json"
+cp "$dir/openapi.json" "$work/openapi.json"
+
+docker run --rm -u "$(id -u):$(id -g)" -v "$work:/work" "$generator" generate \
+	-i /work/openapi.json -g go -o /work/go \
+	--additional-properties=packageName=client,packageVersion="$version",isGoSubmodule=true
+docker run --rm -u "$(id -u):$(id -g)" -v "$work:/work" "$generator" generate \
+	-i /work/openapi.json -g typescript-fetch -o /work/typescript \
+	--additional-properties=npmName=test-renovate-client,npmVersion="$version"
+
+tar -czf "$dir/go-client.tar.gz" -C "$work" go
+tar -czf "$dir/typescript-client.tar.gz" -C "$work" typescript
+
+echo "SDK $version written to $dir"
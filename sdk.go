@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+)
+
+// sdkNamePattern restricts SDK versions and file names to a single safe
+// path segment.
+var sdkNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+type SDKFile struct {
+	Name string `json:"name" doc:"File name"`
+	Size int64  `json:"size" doc:"File size in bytes"`
+	URL  string `json:"url" doc:"Download path"`
+}
+
+type SDKVersion struct {
+	Version string    `json:"version" doc:"API version the artifacts were generated from"`
+	Files   []SDKFile `json:"files" doc:"Spec and generated clients"`
+}
+
+type SDKListResponse struct {
+	Current  string       `json:"current" doc:"Version of the running API"`
+	Versions []SDKVersion `json:"versions" doc:"Published SDK artifacts, newest version first"`
+}
+
+// writeOpenAPISpec writes the spec of every registered operation as JSON.
+// The SDK build generates clients from it.
+func writeOpenAPISpec(w io.Writer) error {
+	api := humachi.New(chi.NewMux(), huma.DefaultConfig(apiTitle, apiVersion))
+	registerEndpoints(api)
+
+	data, err := json.MarshalIndent(api.OpenAPI(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// runOpenAPICommand prints the spec, or with -output table one line per
+// operation.
+func runOpenAPICommand(args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("openapi", flag.ContinueOnError)
+	flags.SetOutput(w)
+	format := outputFlag(flags, outputJSON)
+	if err := parseFlags(flags, args, format); err != nil {
+		return err
+	}
+	if *format == outputJSON {
+		return writeOpenAPISpec(w)
+	}
+
+	api := humachi.New(chi.NewMux(), huma.DefaultConfig(apiTitle, apiVersion))
+	registerEndpoints(api)
+	var ops []*huma.Operation
+	for _, item := range api.OpenAPI().Paths {
+		for _, op := range []*huma.Operation{item.Get, item.Put, item.Post, item.Delete, item.Options, item.Head, item.Patch, item.Trace} {
+			if op != nil {
+				ops = append(ops, op)
+			}
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].Path != ops[j].Path {
+			return ops[i].Path < ops[j].Path
+		}
+		return ops[i].Method < ops[j].Method
+	})
+	tw := newTable(w)
+	fmt.Fprintln(tw, "METHOD\tPATH\tOPERATION\tSUMMARY")
+	for _, op := range ops {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", op.Method, op.Path, op.OperationID, op.Summary)
+	}
+	return tw.Flush()
+}
+
+// listSDKs returns the artifacts under dir, one subdirectory per version.
+func listSDKs(dir string) ([]SDKVersion, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []SDKVersion{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []SDKVersion{}
+	for _, e := range entries {
+		if !e.IsDir() || !sdkNamePattern.MatchString(e.Name()) {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		v := SDKVersion{Version: e.Name(), Files: []SDKFile{}}
+		for _, f := range files {
+			info, err := f.Info()
+			if err != nil || !info.Mode().IsRegular() || !sdkNamePattern.MatchString(f.Name()) {
+				continue
+			}
+			v.Files = append(v.Files, SDKFile{
+				Name: f.Name(),
+				Size: info.Size(),
+				URL:  fmt.Sprintf("/sdk/%s/%s", e.Name(), f.Name()),
+			})
+		}
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version > versions[j].Version })
+	return versions, nil
+}
+
+func registerSDKEndpoints(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "list-sdks",
+		Method:      http.MethodGet,
+		Path:        "/sdk",
+		Summary:     "List client SDKs",
+		Description: "List the OpenAPI spec and generated Go and TypeScript clients published for each API version",
+	}, func(ctx context.Context, input *struct{}) (*struct {
+		Body SDKListResponse
+	}, error) {
+		versions, err := listSDKs(config.Server.SDKDir)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to list SDKs", err)
+		}
+
+		return &struct {
+			Body SDKListResponse
+		}{
+			Body: SDKListResponse{Current: apiVersion, Versions: versions},
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "download-sdk",
+		Method:      http.MethodGet,
+		Path:        "/sdk/{version}/{file}",
+		Summary:     "Download a client SDK",
+		Description: "Download a published OpenAPI spec or generated client archive",
+	}, func(ctx context.Context, input *struct {
+		Version string `path:"version" doc:"API version"`
+		File    string `path:"file" doc:"File name, e.g. openapi.json or go-client.tar.gz"`
+	}) (*struct {
+		ContentType string `header:"Content-Type"`
+		Body        []byte
+	}, error) {
+		if !sdkNamePattern.MatchString(input.Version) || !sdkNamePattern.MatchString(input.File) {
+			return nil, huma.Error404NotFound(fmt.Sprintf("SDK file %s/%s not found", input.Version, input.File))
+		}
+
+		data, err := os.ReadFile(filepath.Join(config.Server.SDKDir, input.Version, input.File))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, huma.Error404NotFound(fmt.Sprintf("SDK file %s/%s not found", input.Version, input.File))
+			}
+			return nil, huma.Error500InternalServerError("Failed to read SDK file", err)
+		}
+
+		contentType := mime.TypeByExtension(filepath.Ext(input.File))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		return &struct {
+			ContentType string `header:"Content-Type"`
+			Body        []byte
+		}{
+			ContentType: contentType,
+			Body:        data,
+		}, nil
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestWriteOpenAPISpec(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeOpenAPISpec(&buf); err != nil {
+		t.Fatalf("Failed to write spec: %v", err)
+	}
+
+	var spec struct {
+		Info struct {
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &spec); err != nil {
+		t.Fatalf("Spec is not valid JSON: %v", err)
+	}
+	if spec.Info.Version != apiVersion {
+		t.Errorf("Expected version %s, got %s", apiVersion, spec.Info.Version)
+	}
+	for _, path := range []string{"/chat", "/upload", "/sdk"} {
+		if _, ok := spec.Paths[path]; !ok {
+			t.Errorf("Expected %s in the spec", path)
+		}
+	}
+}
+
+func TestSDKEndpoints(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Server.SDKDir = t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(config.Server.SDKDir, "1.0.0"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(config.Server.SDKDir, "1.0.0", "openapi.json"), []byte(`{"openapi": "3.1.0"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerSDKEndpoints(api)
+
+	req := httptest.NewRequest(http.MethodGet, "/sdk", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var list SDKListResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(list.Versions) != 1 || len(list.Versions[0].Files) != 1 || list.Versions[0].Files[0].URL != "/sdk/1.0.0/openapi.json" {
+		t.Errorf("Unexpected SDK list: %+v", list)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/sdk/1.0.0/openapi.json", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != `{"openapi": "3.1.0"}` {
+		t.Errorf("Expected the spec, got %d: %s", w.Code, w.Body.String())
+	}
+
+	for _, path := range []string{"/sdk/1.0.0/missing.json", "/sdk/..%2F..%2Fetc/passwd", "/sdk/1.0.0/..%2F1.0.0"} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for %s, got %d", path, w.Code)
+		}
+	}
+}
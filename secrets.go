@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/sashabaranov/go-openai"
+)
+
+// secretStore holds the credentials the OpenAI and MinIO clients sign
+// requests with. Clients read them on every request, so rotating a secret
+// takes effect without rebuilding the clients.
+type secretStore struct {
+	mu          sync.RWMutex
+	openAIKey   string
+	minioKey    string
+	minioSecret string
+}
+
+var secrets = &secretStore{}
+
+// AuthConfig holds the tokens clients authenticate with and the Vault
+// settings credentials are rotated from.
+type AuthConfig struct {
+	AdminToken   string            `mapstructure:"admin_token" doc:"Bearer token for the admin endpoints; empty disables them"`
+	IngestTokens map[string]string `mapstructure:"ingest_tokens" doc:"Ingest webhook tokens by source name"`
+	Vault        VaultConfig       `mapstructure:"vault" doc:"Vault secret polled for rotated credentials"`
+}
+
+type VaultConfig struct {
+	Addr        string `mapstructure:"addr" doc:"Vault address to poll for rotated credentials"`
+	Token       string `mapstructure:"token" doc:"Vault token"`
+	SecretPath  string `mapstructure:"secret_path" doc:"Path of the Vault KV secret holding the credentials"`
+	PollMinutes int    `mapstructure:"poll_minutes" doc:"Minutes between Vault polls"`
+}
+
+func (c AuthConfig) Validate() error {
+	for source, token := range c.IngestTokens {
+		if token == "" {
+			return fmt.Errorf("auth.ingest_tokens.%s must not be empty", source)
+		}
+	}
+	return c.Vault.Validate()
+}
+
+func (c VaultConfig) Validate() error {
+	if (c.Addr == "") != (c.SecretPath == "") {
+		return errors.New("auth.vault.addr and auth.vault.secret_path must be set together")
+	}
+	if c.PollMinutes < 0 {
+		return errors.New("auth.vault.poll_minutes must not be negative")
+	}
+	return nil
+}
+
+// minioCreds is the credential source of client.
+var minioCreds = credentials.New(secretsProvider{})
+
+// rotateMu serializes rotations so concurrent verifications cannot
+// interleave their swaps.
+var rotateMu sync.Mutex
+
+func (s *secretStore) set(openAIKey, minioKey, minioSecret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.openAIKey, s.minioKey, s.minioSecret = openAIKey, minioKey, minioSecret
+}
+
+func (s *secretStore) get() (openAIKey, minioKey, minioSecret string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.openAIKey, s.minioKey, s.minioSecret
+}
+
+// secretsProvider serves the MinIO credentials from the secret store.
+type secretsProvider struct{}
+
+func (secretsProvider) Retrieve() (credentials.Value, error) {
+	_, key, secret := secrets.get()
+	return credentials.Value{AccessKeyID: key, SecretAccessKey: secret, SignerType: credentials.SignatureV4}, nil
+}
+
+// IsExpired is always false; rotation expires minioCreds explicitly.
+func (secretsProvider) IsExpired() bool {
+	return false
+}
+
+// openAIKeyTransport signs OpenAI requests with the current key.
+type openAIKeyTransport struct {
+	base http.RoundTripper
+}
+
+func (t *openAIKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, _, _ := secrets.get()
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+key)
+	return t.base.RoundTrip(req)
+}
+
+func newOpenAITransport() http.RoundTripper {
+	return &tracingTransport{base: http.DefaultTransport, service: "openai", idHeader: "X-Request-Id"}
+}
+
+func newMinIOClient(creds *credentials.Credentials) (*minio.Client, error) {
+	return newMinIOEndpointClient(config.MinIO.URL, creds, nil)
+}
+
+// minioEndpointURL returns the URL of a MinIO endpoint given as host:port,
+// with the scheme set by minio.use_ssl.
+func minioEndpointURL(endpoint string) string {
+	if config.MinIO.UseSSL {
+		return "https://" + endpoint
+	}
+	return "http://" + endpoint
+}
+
+// newMinIOEndpointClient creates a client of one MinIO endpoint. onDown, if
+// set, is called when a connection to the endpoint fails.
+func newMinIOEndpointClient(endpoint string, creds *credentials.Credentials, onDown func()) (*minio.Client, error) {
+	secure := config.MinIO.UseSSL
+	transport, err := minio.DefaultTransport(secure)
+	if err != nil {
+		return nil, err
+	}
+	var rt http.RoundTripper = &tracingTransport{base: transport, service: "minio", idHeader: "X-Amz-Request-Id"}
+	if onDown != nil {
+		rt = &failoverTransport{base: rt, onDown: onDown}
+	}
+	return minio.New(endpoint, &minio.Options{
+		Creds:     creds,
+		Secure:    secure,
+		Transport: rt,
+	})
+}
+
+type SecretRotationRequest struct {
+	OpenAIKey   string `json:"openai_key,omitempty" doc:"New OpenAI API key"`
+	MinIOKey    string `json:"minio_key,omitempty" doc:"New MinIO access key; requires minio_secret"`
+	MinIOSecret string `json:"minio_secret,omitempty" doc:"New MinIO secret key; requires minio_key"`
+}
+
+type SecretRotationResponse struct {
+	Rotated []string `json:"rotated" doc:"Services whose credentials were replaced"`
+}
+
+// rotateSecrets verifies the new credentials with throwaway clients and
+// swaps them in only if every probe succeeds. Services that were not
+// configured at startup cannot be enabled by rotation.
+func rotateSecrets(ctx context.Context, req SecretRotationRequest) ([]string, error) {
+	rotateMu.Lock()
+	defer rotateMu.Unlock()
+
+	openAIKey, minioKey, minioSecret := secrets.get()
+	rotated := []string{}
+
+	if req.OpenAIKey != "" && req.OpenAIKey != openAIKey {
+		if _, err := services.OpenAI(); err != nil {
+			return nil, err
+		}
+		cfg := openai.DefaultConfig(req.OpenAIKey)
+		cfg.HTTPClient = &http.Client{Transport: newOpenAITransport()}
+		if _, err := openai.NewClientWithConfig(cfg).ListModels(ctx); err != nil {
+			return nil, huma.Error422UnprocessableEntity("New OpenAI key failed verification", err)
+		}
+		openAIKey = req.OpenAIKey
+		rotated = append(rotated, "openai")
+	}
+
+	if (req.MinIOKey != "") != (req.MinIOSecret != "") {
+		return nil, huma.Error422UnprocessableEntity("minio_key and minio_secret must be rotated together")
+	}
+	if req.MinIOKey != "" && (req.MinIOKey != minioKey || req.MinIOSecret != minioSecret) {
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+		probe, err := newMinIOClient(credentials.NewStaticV4(req.MinIOKey, req.MinIOSecret, ""))
+		if err == nil {
+			_, err = probe.ListBuckets(ctx)
+		}
+		if err != nil {
+			return nil, huma.Error422UnprocessableEntity("New MinIO credentials failed verification", err)
+		}
+		minioKey, minioSecret = req.MinIOKey, req.MinIOSecret
+		rotated = append(rotated, "minio")
+	}
+
+	if len(rotated) == 0 {
+		return rotated, nil
+	}
+	secrets.set(openAIKey, minioKey, minioSecret)
+	minioCreds.Expire()
+
+	// The model list depends on the key
+	modelListCache.Lock()
+	modelListCache.fetchedAt = time.Time{}
+	modelListCache.Unlock()
+
+	log.Printf("Rotated credentials for %s", strings.Join(rotated, ", "))
+	return rotated, nil
+}
+
+// fetchVaultSecrets reads the credentials from a Vault KV secret. Both KV
+// version 1 and version 2 response layouts are accepted.
+func fetchVaultSecrets(ctx context.Context) (SecretRotationRequest, error) {
+	var out SecretRotationRequest
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(config.Auth.Vault.Addr, "/")+"/v1/"+strings.TrimPrefix(config.Auth.Vault.SecretPath, "/"), nil)
+	if err != nil {
+		return out, err
+	}
+	req.Header.Set("X-Vault-Token", config.Auth.Vault.Token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return out, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return out, fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return out, err
+	}
+	data := body.Data
+	if nested, ok := body.Data["data"]; ok {
+		if err := json.Unmarshal(nested, &data); err != nil {
+			return out, err
+		}
+	}
+	for key, dst := range map[string]*string{"openai_key": &out.OpenAIKey, "minio_key": &out.MinIOKey, "minio_secret": &out.MinIOSecret} {
+		if raw, ok := data[key]; ok {
+			if err := json.Unmarshal(raw, dst); err != nil {
+				return out, fmt.Errorf("vault secret field %s: %w", key, err)
+			}
+		}
+	}
+	return out, nil
+}
+
+// startVaultRotation polls Vault and rotates credentials whenever the
+// secret changes.
+func startVaultRotation(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				req, err := fetchVaultSecrets(ctx)
+				if err == nil {
+					_, err = rotateSecrets(ctx, req)
+				}
+				if err != nil {
+					log.Printf("Vault secret rotation failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// requireAdmin checks an admin bearer token.
+func requireAdmin(authorization string) error {
+	if config.Auth.AdminToken == "" && len(config.Approvals.Admins) == 0 {
+		return huma.Error403Forbidden("Admin endpoints are disabled")
+	}
+	if _, ok := adminName(authorization); ok {
+		return nil
+	}
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || config.Auth.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(config.Auth.AdminToken)) != 1 {
+		return huma.Error401Unauthorized("Invalid or missing admin token")
+	}
+	return nil
+}
+
+func registerSecretRotationEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "rotate-secrets",
+		Method:      http.MethodPost,
+		Path:        "/admin/secrets/rotate",
+		Summary:     "Rotate credentials",
+		Description: "Replace the OpenAI key and/or MinIO credentials at runtime. New credentials are verified before they are used",
+	}, func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer admin token"`
+		ApprovalID    string `header:"X-Approval-ID" doc:"Approved request for rotate-secrets on the rotated services; required when approvals.admins is set"`
+		Body          SecretRotationRequest
+	}) (*struct {
+		Body SecretRotationResponse
+	}, error) {
+		if err := requireAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+		if err := consumeApproval(ctx, input.ApprovalID, approvalRotateSecrets, input.Body.rotatedServices()); err != nil {
+			return nil, err
+		}
+
+		rotated, err := rotateSecrets(ctx, input.Body)
+		if err != nil {
+			return nil, err
+		}
+		if len(rotated) > 0 {
+			audit(ctx, AuditEvent{Action: "secrets.rotated", Operation: "rotate-secrets", Detail: strings.Join(rotated, ",")})
+		}
+
+		return &struct {
+			Body SecretRotationResponse
+		}{
+			Body: SecretRotationResponse{Rotated: rotated},
+		}, nil
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+func TestRotatedSecretsAreUsed(t *testing.T) {
+	defer secrets.set("", "", "")
+
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": []}`))
+	}))
+	defer server.Close()
+
+	secrets.set("old-key", "old-access", "old-secret")
+	cfg := openai.DefaultConfig("old-key")
+	cfg.BaseURL = server.URL + "/v1"
+	cfg.HTTPClient = &http.Client{Transport: &openAIKeyTransport{base: http.DefaultTransport}}
+	client := openai.NewClientWithConfig(cfg)
+
+	secrets.set("new-key", "new-access", "new-secret")
+	minioCreds.Expire()
+	if _, err := client.ListModels(t.Context()); err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if auth != "Bearer new-key" {
+		t.Errorf("Expected the rotated OpenAI key to be used, got %q", auth)
+	}
+
+	creds, err := minioCreds.Get()
+	if err != nil || creds.AccessKeyID != "new-access" || creds.SecretAccessKey != "new-secret" {
+		t.Errorf("Expected the rotated MinIO credentials, got %+v (%v)", creds, err)
+	}
+}
+
+func TestFetchVaultSecrets(t *testing.T) {
+	viper.Reset()
+	initConfig()
+
+	for name, body := range map[string]string{
+		"kv1": `{"data": {"openai_key": "sk-vault", "minio_key": "access", "minio_secret": "secret"}}`,
+		"kv2": `{"data": {"data": {"openai_key": "sk-vault", "minio_key": "access", "minio_secret": "secret"}, "metadata": {"version": 3}}}`,
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Vault-Token") != "vault-token" || r.URL.Path != "/v1/secret/data/app" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(body))
+		}))
+		config.Auth.Vault.Addr = server.URL
+		config.Auth.Vault.Token = "vault-token"
+		config.Auth.Vault.SecretPath = "secret/data/app"
+
+		got, err := fetchVaultSecrets(t.Context())
+		server.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		if got.OpenAIKey != "sk-vault" || got.MinIOKey != "access" || got.MinIOSecret != "secret" {
+			t.Errorf("%s: unexpected secrets: %+v", name, got)
+		}
+	}
+}
+
+func TestSecretRotationEndpoint(t *testing.T) {
+	viper.Reset()
+	initConfig()
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerSecretRotationEndpoint(api)
+
+	rotate := func(token, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/admin/secrets/rotate", bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := rotate("anything", `{}`); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 without an admin token configured, got %d", code)
+	}
+
+	config.Auth.AdminToken = "admin-token"
+	if code := rotate("wrong", `{}`); code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a wrong token, got %d", code)
+	}
+	if code := rotate("admin-token", `{"minio_key": "only-the-key"}`); code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 for a key without a secret, got %d", code)
+	}
+	if code := rotate("admin-token", `{}`); code != http.StatusOK {
+		t.Errorf("Expected status 200 for a no-op rotation, got %d", code)
+	}
+}
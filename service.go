@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// defaultServiceName is the name the Windows service is installed under
+// unless -name is given.
+const defaultServiceName = "test-app"
+
+// runServiceCommand manages the Windows service: install registers this
+// binary with the service manager, uninstall removes it, start and stop
+// control it, and run is what the service manager executes.
+func runServiceCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return usageError("usage: service install|uninstall|start|stop|run [flags]")
+	}
+	action := args[0]
+	flags := flag.NewFlagSet("service "+action, flag.ContinueOnError)
+	flags.SetOutput(out)
+	name := flags.String("name", defaultServiceName, "Service name")
+	wd, _ := os.Getwd()
+	dir := flags.String("dir", wd, "Directory config.yaml is read from; the service runs in it")
+	format := outputFlag(flags, outputTable)
+	if err := parseFlags(flags, args[1:], format); err != nil {
+		return err
+	}
+
+	var err error
+	switch action {
+	case "install":
+		var abs string
+		if abs, err = filepath.Abs(*dir); err == nil {
+			err = installService(*name, abs)
+		}
+	case "uninstall":
+		err = removeService(*name)
+	case "start":
+		err = startService(*name)
+	case "stop":
+		err = stopService(*name)
+	case "run":
+		// Run under the service manager; nothing to report on success
+		if err := os.Chdir(*dir); err != nil {
+			return err
+		}
+		return runService(*name)
+	default:
+		return usageError("unknown service command %q", action)
+	}
+	if err != nil {
+		return err
+	}
+
+	done := map[string]string{"install": "installed", "uninstall": "uninstalled", "start": "started", "stop": "stopped"}[action]
+	if *format == outputJSON {
+		return writeJSON(out, map[string]string{"service": *name, "state": done})
+	}
+	_, err = fmt.Fprintf(out, "Service %s %s\n", *name, done)
+	return err
+}
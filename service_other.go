@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// errServiceUnsupported is returned by the service commands outside
+// Windows, where the systemd units do the same job.
+var errServiceUnsupported = errors.New("Windows services are not supported on this platform; use the systemd units instead")
+
+func installService(name, dir string) error { return errServiceUnsupported }
+func removeService(name string) error       { return errServiceUnsupported }
+func startService(name string) error        { return errServiceUnsupported }
+func stopService(name string) error         { return errServiceUnsupported }
+func runService(name string) error          { return errServiceUnsupported }
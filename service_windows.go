@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// windowsService runs the server under the service manager and turns its
+// stop and shutdown requests into a graceful stop.
+type windowsService struct{}
+
+func (windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- runServer(ctx) }()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case err := <-done:
+			// The server stopped on its own, e.g. a listener failed
+			log.Printf("Server stopped: %v", err)
+			return false, 1
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				grace := time.Duration(config.Server.ShutdownTimeoutSeconds) * time.Second
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32((grace + 5*time.Second).Milliseconds())}
+				cancel()
+				if err := <-done; err != nil {
+					log.Printf("Server stopped: %v", err)
+					return false, 1
+				}
+				return false, 0
+			}
+		}
+	}
+}
+
+// winEventLogWriter sends log output to the Windows event log, since a service
+// has no console.
+type winEventLogWriter struct {
+	l *eventlog.Log
+}
+
+func (w winEventLogWriter) Write(p []byte) (int, error) {
+	return len(p), w.l.Info(1, strings.TrimRight(string(p), "\n"))
+}
+
+func runService(name string) error {
+	if inService, err := svc.IsWindowsService(); err != nil || !inService {
+		return usageError("service run is started by the service manager; use service start")
+	}
+	if l, err := eventlog.Open(name); err == nil {
+		defer l.Close()
+		log.SetFlags(0)
+		log.SetOutput(winEventLogWriter{l})
+	}
+	return svc.Run(name, windowsService{})
+}
+
+func installService(name, dir string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", name)
+	}
+
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: name,
+		Description: apiTitle,
+		StartType:   mgr.StartAutomatic,
+	}, "service", "run", "-name", name, "-dir", dir)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	// Restart after a crash, backing off on repeated failures
+	s.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+	}, uint32((24 * time.Hour).Seconds()))
+	if err := eventlog.InstallAsEventCreate(name, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("registering the event log source: %w", err)
+	}
+	return nil
+}
+
+func removeService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	eventlog.Remove(name)
+	return nil
+}
+
+func startService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	defer s.Close()
+	return s.Start()
+}
+
+// stopService asks the service to stop and waits until it has.
+func stopService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	defer s.Close()
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(2 * time.Minute)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s did not stop within 2 minutes", name)
+		}
+		time.Sleep(300 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ServiceRegistry holds the clients of the external services. Clients are
+// swapped atomically, so a request racing a re-initialization sees either
+// the old or the new client. Callers take a client once and use it for the
+// whole operation instead of reading it again.
+type ServiceRegistry struct {
+	openai atomic.Pointer[openai.Client]
+	minio  atomic.Pointer[minio.Client]
+	// minioReader serves reads from a replica, if set
+	minioReader atomic.Pointer[minio.Client]
+}
+
+// services are the clients used by the endpoints and background jobs.
+var services ServiceRegistry
+
+// OpenAI returns the OpenAI client, or a 503 problem when none is
+// configured.
+func (r *ServiceRegistry) OpenAI() (*openai.Client, error) {
+	if c := r.openai.Load(); c != nil {
+		return c, nil
+	}
+	return nil, errOpenAINotConfigured()
+}
+
+// MinIO returns the MinIO client, or a 503 problem when none is configured.
+func (r *ServiceRegistry) MinIO() (*minio.Client, error) {
+	if c := r.minio.Load(); c != nil {
+		return c, nil
+	}
+	return nil, errMinIONotConfigured()
+}
+
+// MinIOReader returns the client reads that tolerate replication lag
+// should use: a replica's when reading from replicas is enabled, else the
+// MinIO client.
+func (r *ServiceRegistry) MinIOReader() (*minio.Client, error) {
+	c, err := r.MinIO()
+	if err != nil {
+		return nil, err
+	}
+	if rc := r.minioReader.Load(); rc != nil {
+		return rc, nil
+	}
+	return c, nil
+}
+
+// SetOpenAI replaces the OpenAI client; nil disables the service.
+func (r *ServiceRegistry) SetOpenAI(c *openai.Client) { r.openai.Store(c) }
+
+// SetMinIO replaces the MinIO client; nil disables the service.
+func (r *ServiceRegistry) SetMinIO(c *minio.Client) { r.minio.Store(c) }
+
+// SetMinIOReader replaces the replica client; nil reads from the MinIO
+// client.
+func (r *ServiceRegistry) SetMinIOReader(c *minio.Client) { r.minioReader.Store(c) }
+
+// Status reports which services are available.
+func (r *ServiceRegistry) Status() HealthServices {
+	return HealthServices{OpenAI: r.openai.Load() != nil, MinIO: r.minio.Load() != nil}
+}
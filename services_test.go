@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestServiceRegistry(t *testing.T) {
+	var r ServiceRegistry
+	if _, err := r.OpenAI(); err == nil {
+		t.Error("Expected an error without an OpenAI client")
+	}
+	if _, err := r.MinIO(); err == nil {
+		t.Error("Expected an error without a MinIO client")
+	}
+	if s := r.Status(); s.OpenAI || s.MinIO {
+		t.Errorf("Expected no service to be available, got %+v", s)
+	}
+
+	r.SetOpenAI(newFakeOpenAIClient(0))
+	if c, err := r.OpenAI(); c == nil || err != nil {
+		t.Errorf("Expected the OpenAI client, got %v, %v", c, err)
+	}
+	if s := r.Status(); !s.OpenAI || s.MinIO {
+		t.Errorf("Expected only OpenAI to be available, got %+v", s)
+	}
+	r.SetOpenAI(nil)
+	if s := r.Status(); s.OpenAI {
+		t.Errorf("Expected OpenAI to be disabled again, got %+v", s)
+	}
+}
+
+// TestServiceReinitializationRace serves chat requests while the OpenAI
+// client is replaced and removed; run with -race.
+func TestServiceReinitializationRace(t *testing.T) {
+	defer services.SetOpenAI(nil)
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerChatEndpoint(api)
+	registerHealthEndpoint(api)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range 200 {
+			if i%2 == 0 {
+				services.SetOpenAI(newFakeOpenAIClient(0))
+			} else {
+				services.SetOpenAI(nil)
+			}
+		}
+	}()
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(`{"message": "Hi"}`))
+				req.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				if w.Code != http.StatusOK && w.Code != http.StatusServiceUnavailable {
+					t.Errorf("Expected 200 or 503 while the client is replaced, got %d: %s", w.Code, w.Body.String())
+				}
+				router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// shadowHeader marks requests mirrored to the canary, which does not
+// mirror them again.
+const shadowHeader = "X-Shadow-Request"
+
+// maxShadowDiffPaths caps the differing fields recorded per request.
+const maxShadowDiffPaths = 20
+
+// shadowCredentialHeaders are removed from mirrored requests unless
+// shadow.forward_credentials is set. Authorization also carries the bearer
+// token that signed requests are given.
+var shadowCredentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", openAIKeyHeader}
+
+// shadowSignatureHeaders are always removed: the signature was checked
+// here, and the identity it grants is in Authorization.
+var shadowSignatureHeaders = []string{signatureKeyHeader, signatureTimestampHeader, signatureNonceHeader, signatureHeader}
+
+// ShadowConfig mirrors a share of requests to a canary instance, such as
+// one running a new model or provider configuration, and records how its
+// responses differ.
+type ShadowConfig struct {
+	URL                string      `mapstructure:"url" doc:"Base URL of the canary instance; empty disables shadowing"`
+	Percent            float64     `mapstructure:"percent" doc:"Share of matching requests mirrored, from 0 to 100"`
+	Routes             []string    `mapstructure:"routes" doc:"Routes to mirror, as in cache.routes; streaming responses are never mirrored"`
+	TimeoutMS          int         `mapstructure:"timeout_ms" doc:"Longest wait for the canary's response"`
+	MaxBodyBytes       int64       `mapstructure:"max_body_bytes" doc:"Requests and responses larger than this are not mirrored"`
+	MaxConcurrent      int         `mapstructure:"max_concurrent" doc:"Most mirrored requests in flight; more are skipped"`
+	Diffs              StoreLimits `mapstructure:"diffs" doc:"Comparisons kept for GET /admin/shadow"`
+	ForwardCredentials bool        `mapstructure:"forward_credentials" doc:"Send the caller's bearer token to the canary; otherwise mirrored requests carry no credentials"`
+}
+
+func (c ShadowConfig) Validate() error {
+	if c.URL == "" {
+		return nil
+	}
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("shadow.url: %q is not an http or https URL", c.URL)
+	}
+	if c.Percent < 0 || c.Percent > 100 {
+		return errors.New("shadow.percent must be between 0 and 100")
+	}
+	if c.TimeoutMS <= 0 || c.MaxBodyBytes <= 0 || c.MaxConcurrent <= 0 {
+		return errors.New("shadow.timeout_ms, shadow.max_body_bytes and shadow.max_concurrent must be positive")
+	}
+	for _, r := range c.Routes {
+		if !strings.HasPrefix(r, "/") {
+			return fmt.Errorf("shadow.routes: %q is not an absolute path", r)
+		}
+	}
+	return c.Diffs.validate("shadow.diffs")
+}
+
+// ShadowComparison is how the canary's response to one mirrored request
+// compared to the one the caller got.
+type ShadowComparison struct {
+	RequestID     string    `json:"request_id,omitempty" doc:"X-Request-ID of the original request"`
+	Method        string    `json:"method" doc:"HTTP method"`
+	Path          string    `json:"path" doc:"Path of the request"`
+	At            time.Time `json:"at" doc:"When the request was mirrored"`
+	Status        int       `json:"status" doc:"Status of the response the caller got"`
+	CanaryStatus  int       `json:"canary_status,omitempty" doc:"Status of the canary's response; empty if it failed"`
+	Match         bool      `json:"match" doc:"Whether the status and body were the same"`
+	DiffPaths     []string  `json:"diff_paths,omitempty" doc:"JSON fields that differ, e.g. reply or usage.total_tokens; body when a body is not JSON"`
+	LatencyMS     int64     `json:"latency_ms" doc:"How long the original request took"`
+	CanaryLatency int64     `json:"canary_latency_ms,omitempty" doc:"How long the canary took"`
+	Error         string    `json:"error,omitempty" doc:"Why the canary could not be asked"`
+}
+
+type ShadowStats struct {
+	Mirrored int64 `json:"mirrored" doc:"Requests mirrored since the start"`
+	Matched  int64 `json:"matched" doc:"Mirrored requests the canary answered the same"`
+	Differed int64 `json:"differed" doc:"Mirrored requests the canary answered differently"`
+	Failed   int64 `json:"failed" doc:"Mirrored requests the canary did not answer"`
+	Skipped  int64 `json:"skipped" doc:"Requests picked for mirroring but skipped because too many were in flight or a body was too large"`
+}
+
+type ShadowResponse struct {
+	URL         string             `json:"url" doc:"The canary's base URL"`
+	Percent     float64            `json:"percent" doc:"Share of matching requests mirrored"`
+	Stats       ShadowStats        `json:"stats" doc:"Counts since the start"`
+	Comparisons []ShadowComparison `json:"comparisons" doc:"Recent comparisons, newest first"`
+}
+
+// shadower mirrors requests to the canary.
+type shadower struct {
+	base    *url.URL
+	config  ShadowConfig
+	client  *http.Client
+	slots   chan struct{}
+	results *lruStore[ShadowComparison]
+
+	mu    sync.Mutex
+	stats ShadowStats
+}
+
+// activeShadower is the shadower of this instance, if shadowing is
+// enabled.
+var activeShadower struct {
+	sync.Mutex
+	s *shadower
+}
+
+func newShadower(c ShadowConfig) *shadower {
+	if c.URL == "" {
+		return nil
+	}
+	base, _ := url.Parse(strings.TrimSuffix(c.URL, "/"))
+	return &shadower{
+		base:   base,
+		config: c,
+		client: &http.Client{Timeout: time.Duration(c.TimeoutMS) * time.Millisecond},
+		slots:  make(chan struct{}, c.MaxConcurrent),
+		results: newLRUStore("shadow_diffs", c.Diffs, func(sc ShadowComparison) int64 {
+			n := int64(len(sc.RequestID)+len(sc.Path)+len(sc.Error)) + 64
+			for _, p := range sc.DiffPaths {
+				n += int64(len(p))
+			}
+			return n
+		}),
+	}
+}
+
+// picks decides whether to mirror r.
+func (s *shadower) picks(r *http.Request) bool {
+	if r.Header.Get(shadowHeader) != "" || r.Header.Get("Upgrade") != "" || strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return false
+	}
+	// Mirroring a request made with the caller's own key would bill it twice
+	if r.Header.Get(openAIKeyHeader) != "" {
+		return false
+	}
+	if !slices.ContainsFunc(s.config.Routes, func(route string) bool { return matchRoute(route, r.URL.Path) }) {
+		return false
+	}
+	return rand.Float64()*100 < s.config.Percent
+}
+
+func (s *shadower) count(fn func(*ShadowStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.stats)
+}
+
+// middleware serves requests as usual and mirrors the picked ones to the
+// canary once they are answered, so callers don't wait for it.
+func (s *shadower) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.picks(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, s.config.MaxBodyBytes+1))
+		if err != nil {
+			writeError(w, codedError(http.StatusBadRequest, "ERR_BAD_REQUEST", "Failed to read the request body", err))
+			return
+		}
+		if int64(len(body)) > s.config.MaxBodyBytes {
+			// Too large to keep a copy of; pass the whole body on
+			s.count(func(st *ShadowStats) { st.Skipped++ })
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+			next.ServeHTTP(w, r)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		tee := &teeWriter{ResponseWriter: w, status: http.StatusOK, limit: s.config.MaxBodyBytes}
+		start := time.Now()
+		next.ServeHTTP(tee, r)
+		latency := time.Since(start)
+
+		if tee.overflow || strings.HasPrefix(tee.Header().Get("Content-Type"), "text/event-stream") {
+			s.count(func(st *ShadowStats) { st.Skipped++ })
+			return
+		}
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			s.count(func(st *ShadowStats) { st.Skipped++ })
+			return
+		}
+		mirror := r.Clone(context.WithoutCancel(r.Context()))
+		go func() {
+			defer func() { <-s.slots }()
+			s.compare(mirror, body, tee.status, tee.body.Bytes(), latency)
+		}()
+	})
+}
+
+// compare sends the request to the canary and records how its response
+// differs from the original one.
+func (s *shadower) compare(r *http.Request, body []byte, status int, original []byte, latency time.Duration) {
+	sc := ShadowComparison{
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		At:        time.Now().UTC(),
+		Status:    status,
+		LatencyMS: latency.Milliseconds(),
+	}
+	if t := traceFromContext(r.Context()); t != nil {
+		sc.RequestID = t.RequestID
+	}
+	s.count(func(st *ShadowStats) { st.Mirrored++ })
+	defer func() {
+		key := sc.RequestID
+		if key == "" {
+			key = randomHex(8)
+		}
+		s.results.set(key, sc, 0)
+	}()
+
+	target := *s.base
+	target.Path += r.URL.Path
+	target.RawQuery = r.URL.RawQuery
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		sc.Error = err.Error()
+		s.count(func(st *ShadowStats) { st.Failed++ })
+		return
+	}
+	req.Header = r.Header.Clone()
+	for _, h := range shadowSignatureHeaders {
+		req.Header.Del(h)
+	}
+	if !s.config.ForwardCredentials {
+		for _, h := range shadowCredentialHeaders {
+			req.Header.Del(h)
+		}
+	}
+	req.Header.Set(shadowHeader, "1")
+	if sc.RequestID != "" {
+		req.Header.Set(requestIDHeader, sc.RequestID)
+	}
+	req.Header.Del("Accept-Encoding")
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		sc.Error = err.Error()
+		s.count(func(st *ShadowStats) { st.Failed++ })
+		log.Printf("Shadow request %s %s failed: %v", r.Method, r.URL.Path, err)
+		return
+	}
+	defer resp.Body.Close()
+	canary, err := io.ReadAll(io.LimitReader(resp.Body, s.config.MaxBodyBytes+1))
+	sc.CanaryLatency = time.Since(start).Milliseconds()
+	if err != nil {
+		sc.Error = err.Error()
+		s.count(func(st *ShadowStats) { st.Failed++ })
+		return
+	}
+	sc.CanaryStatus = resp.StatusCode
+
+	sc.DiffPaths = diffResponses(original, canary)
+	if status != resp.StatusCode {
+		sc.DiffPaths = append([]string{"status"}, sc.DiffPaths...)
+	}
+	sc.Match = len(sc.DiffPaths) == 0
+	s.count(func(st *ShadowStats) {
+		if sc.Match {
+			st.Matched++
+		} else {
+			st.Differed++
+		}
+	})
+}
+
+// diffResponses lists the JSON fields two bodies differ in, or body when
+// either is not JSON and they differ.
+func diffResponses(a, b []byte) []string {
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		if bytes.Equal(a, b) {
+			return nil
+		}
+		return []string{"body"}
+	}
+	var paths []string
+	diffJSON("", va, vb, &paths)
+	return paths
+}
+
+func diffJSON(path string, a, b any, paths *[]string) {
+	if len(*paths) >= maxShadowDiffPaths {
+		return
+	}
+	name := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+	switch a := a.(type) {
+	case map[string]any:
+		if b, ok := b.(map[string]any); ok {
+			keys := make([]string, 0, len(a)+len(b))
+			for k := range a {
+				keys = append(keys, k)
+			}
+			for k := range b {
+				if _, ok := a[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				diffJSON(name(k), a[k], b[k], paths)
+			}
+			return
+		}
+	case []any:
+		if b, ok := b.([]any); ok && len(a) == len(b) {
+			for i := range a {
+				diffJSON(name(fmt.Sprint(i)), a[i], b[i], paths)
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		if path == "" {
+			path = "body"
+		}
+		*paths = append(*paths, path)
+	}
+}
+
+// teeWriter passes a response through and keeps a copy of up to limit
+// bytes of it.
+type teeWriter struct {
+	http.ResponseWriter
+	status   int
+	limit    int64
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *teeWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *teeWriter) Write(b []byte) (int, error) {
+	if !w.overflow {
+		if int64(w.body.Len()+len(b)) > w.limit {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *teeWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func registerShadowEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "get-shadow-comparisons",
+		Method:      http.MethodGet,
+		Path:        "/admin/shadow",
+		Summary:     "Compare the canary's responses",
+		Description: "Return how the canary instance answered the requests mirrored to it compared to this instance, newest first",
+	}, func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer admin token"`
+		Differences   bool   `query:"differences" doc:"Only list comparisons that did not match"`
+	}) (*struct {
+		Body ShadowResponse
+	}, error) {
+		if err := requireAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+		activeShadower.Lock()
+		s := activeShadower.s
+		activeShadower.Unlock()
+		if s == nil {
+			return nil, huma.Error404NotFound("Shadowing is not enabled; set shadow.url")
+		}
+
+		resp := ShadowResponse{URL: s.config.URL, Percent: s.config.Percent, Comparisons: []ShadowComparison{}}
+		s.mu.Lock()
+		resp.Stats = s.stats
+		s.mu.Unlock()
+		s.results.each(func(_ string, sc ShadowComparison) {
+			if !input.Differences || !sc.Match {
+				resp.Comparisons = append(resp.Comparisons, sc)
+			}
+		})
+		// The store orders by use; list by time
+		sort.SliceStable(resp.Comparisons, func(i, j int) bool { return resp.Comparisons[i].At.After(resp.Comparisons[j].At) })
+		return &struct {
+			Body ShadowResponse
+		}{Body: resp}, nil
+	})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestDiffResponses(t *testing.T) {
+	a := `{"reply": "Hi", "model": "gpt-4o", "usage": {"prompt_tokens": 5, "total_tokens": 7}, "replies": ["a", "b"]}`
+	b := `{"reply": "Hello", "model": "gpt-4o", "usage": {"prompt_tokens": 5, "total_tokens": 9}, "replies": ["a", "c"], "cache": "miss"}`
+	if got := diffResponses([]byte(a), []byte(b)); !slices.Equal(got, []string{"cache", "replies.1", "reply", "usage.total_tokens"}) {
+		t.Errorf("Unexpected differences %v", got)
+	}
+	if got := diffResponses([]byte(a), []byte(a)); len(got) != 0 {
+		t.Errorf("Expected equal bodies to match, got %v", got)
+	}
+	if got := diffResponses([]byte("text"), []byte(`{}`)); !slices.Equal(got, []string{"body"}) {
+		t.Errorf("Expected a non-JSON body to differ as a whole, got %v", got)
+	}
+}
+
+func TestShadowMiddleware(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "admin-token"
+
+	var canaryBodies, canaryCredentials []string
+	canary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		canaryBodies = append(canaryBodies, r.Header.Get(shadowHeader)+" "+r.URL.RequestURI()+" "+string(body))
+		for _, h := range append(shadowCredentialHeaders, shadowSignatureHeaders...) {
+			if v := r.Header.Get(h); v != "" {
+				canaryCredentials = append(canaryCredentials, h+": "+v)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"reply": "Hello from the canary", "model": "gpt-4o"}`))
+	}))
+	defer canary.Close()
+	config.Shadow.URL = canary.URL
+	config.Shadow.Percent = 100
+	config.Shadow.Routes = []string{"/chat", "/chat/stream"}
+	// Decoding the configuration keeps slice entries, so reset the routes
+	defer func() { config.Shadow.URL, config.Shadow.Routes = "", nil }()
+	s := newShadower(config.Shadow)
+	activeShadower.s = s
+	defer func() { activeShadower.s = nil }()
+
+	router := chi.NewMux()
+	router.Use(s.middleware)
+	router.Post("/chat", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"message": "Hi"}` {
+			t.Errorf("Expected the handler to get the body, got %s", body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"reply": "Hi there", "model": "gpt-4o"}`))
+	})
+	router.Post("/chat/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: done\ndata: {}\n\n"))
+	})
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerShadowEndpoint(api)
+	call := func(method, path, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := call(http.MethodPost, "/chat?v=1", `{"message": "Hi"}`, "Authorization", "Bearer acme-token", "Cookie", "session=1", signatureNonceHeader, "nonce-0000000001"); w.Body.String() != `{"reply": "Hi there", "model": "gpt-4o"}` {
+		t.Errorf("Expected the caller to get this instance's response, got %s", w.Body.String())
+	}
+	call(http.MethodPost, "/chat", `{"message": "Hi"}`, shadowHeader, "1")
+	// Streams are told by the request's Accept header or the response
+	call(http.MethodPost, "/chat/stream", `{"message": "Hi"}`)
+	call(http.MethodPost, "/chat/stream", `{"message": "Hi"}`, "Accept", "text/event-stream")
+
+	var resp ShadowResponse
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		w := call(http.MethodGet, "/admin/shadow", "", "Authorization", "Bearer admin-token")
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if st := resp.Stats; st.Mirrored > 0 && st.Matched+st.Differed+st.Failed == st.Mirrored {
+			break
+		}
+	}
+	if resp.Stats != (ShadowStats{Mirrored: 1, Differed: 1, Skipped: 1}) {
+		t.Errorf("Expected one mirrored chat and the stream skipped, got %+v", resp.Stats)
+	}
+	if len(canaryBodies) != 1 || canaryBodies[0] != `1 /chat?v=1 {"message": "Hi"}` {
+		t.Errorf("Expected the canary to get the marked request, got %q", canaryBodies)
+	}
+	if len(canaryCredentials) != 0 {
+		t.Errorf("Expected the mirrored request to carry no credentials, got %q", canaryCredentials)
+	}
+	if len(resp.Comparisons) != 1 || resp.Comparisons[0].Match || !slices.Equal(resp.Comparisons[0].DiffPaths, []string{"reply"}) || resp.Comparisons[0].CanaryStatus != http.StatusOK {
+		t.Errorf("Expected the reply to differ, got %+v", resp.Comparisons)
+	}
+	if w := call(http.MethodGet, "/admin/shadow", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the comparisons to be admin only, got %d", w.Code)
+	}
+}
+
+func TestShadowForwardCredentials(t *testing.T) {
+	var got http.Header
+	canary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Write([]byte(`{}`))
+	}))
+	defer canary.Close()
+	s := newShadower(ShadowConfig{URL: canary.URL, TimeoutMS: 1000, MaxBodyBytes: 1 << 20, ForwardCredentials: true, Diffs: StoreLimits{MaxEntries: 10, MaxBytes: 1 << 10}})
+
+	req := httptest.NewRequest(http.MethodPost, "/chat", nil)
+	req.Header.Set("Authorization", "Bearer acme-token")
+	req.Header.Set(signatureKeyHeader, "billing")
+	req.Header.Set(signatureHeader, "abc")
+	s.compare(req, nil, http.StatusOK, []byte(`{}`), 0)
+
+	if got.Get("Authorization") != "Bearer acme-token" {
+		t.Errorf("Expected the bearer token to be forwarded, got %q", got.Get("Authorization"))
+	}
+	if got.Get(signatureKeyHeader) != "" || got.Get(signatureHeader) != "" {
+		t.Errorf("Expected the signature headers to be removed, got %v", got)
+	}
+}
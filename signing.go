@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers of a signed request.
+const (
+	signatureKeyHeader       = "X-Signature-Key"
+	signatureTimestampHeader = "X-Signature-Timestamp"
+	signatureNonceHeader     = "X-Signature-Nonce"
+	signatureHeader          = "X-Signature"
+)
+
+// RequestSigningConfig lets server-to-server callers authenticate by signing
+// requests with a shared secret instead of sending a bearer token.
+type RequestSigningConfig struct {
+	Clients      []SigningClientConfig `mapstructure:"clients" doc:"Callers that sign their requests"`
+	MaxSkewSecs  int                   `mapstructure:"max_skew_seconds" doc:"Most seconds a request's timestamp may differ from the server's clock"`
+	MaxBodyBytes int64                 `mapstructure:"max_body_bytes" doc:"Largest body of a signed request, which is read into memory to check the signature"`
+	Nonces       StoreLimits           `mapstructure:"nonces" doc:"Nonces remembered to refuse replays"`
+}
+
+type SigningClientConfig struct {
+	ID     string `mapstructure:"id" doc:"Key ID the caller sends as X-Signature-Key"`
+	Secret string `mapstructure:"secret" doc:"Shared secret of at least 32 characters"`
+	As     string `mapstructure:"as" doc:"Who signed requests act as: admin, or the name of a storage tenant"`
+}
+
+func (c RequestSigningConfig) Validate() error {
+	if len(c.Clients) == 0 {
+		return nil
+	}
+	if c.MaxSkewSecs <= 0 || c.MaxBodyBytes <= 0 {
+		return errors.New("request_signing.max_skew_seconds and request_signing.max_body_bytes must be positive")
+	}
+	if err := c.Nonces.validate("request_signing.nonces"); err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, client := range c.Clients {
+		if client.ID == "" || seen[client.ID] {
+			return fmt.Errorf("request_signing.clients: %q needs a unique id", client.ID)
+		}
+		seen[client.ID] = true
+		if len(client.Secret) < 32 {
+			return fmt.Errorf("request_signing.clients.%s: the secret must have at least 32 characters", client.ID)
+		}
+		if client.As == "" {
+			return fmt.Errorf("request_signing.clients.%s: as must be admin or a storage tenant", client.ID)
+		}
+	}
+	return nil
+}
+
+// signatureBase is the string a request's signature is the HMAC-SHA256 of:
+// the method, the path with its query, the timestamp, the nonce and the
+// hex SHA-256 of the body, each on a line of its own.
+func signatureBase(method, uri, timestamp, nonce string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(sum[:])
+}
+
+func signRequest(secret, base string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(base))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// requestVerifier checks signed requests and lets them through as the
+// client's identity.
+type requestVerifier struct {
+	clients []SigningClientConfig
+	maxSkew time.Duration
+	maxBody int64
+
+	// mu makes checking and recording a nonce one step
+	mu     sync.Mutex
+	nonces *lruStore[struct{}]
+}
+
+func newRequestVerifier(c RequestSigningConfig) *requestVerifier {
+	if len(c.Clients) == 0 {
+		return nil
+	}
+	return &requestVerifier{
+		clients: c.Clients,
+		maxSkew: time.Duration(c.MaxSkewSecs) * time.Second,
+		maxBody: c.MaxBodyBytes,
+		nonces:  newLRUStore("signature_nonces", c.Nonces, func(struct{}) int64 { return 64 }),
+	}
+}
+
+// bearerFor returns the Authorization header of the identity a client acts
+// as, resolved on every request so that rotated tokens apply.
+func bearerFor(as string) (string, bool) {
+	if as == "admin" {
+		return "Bearer " + config.Auth.AdminToken, config.Auth.AdminToken != ""
+	}
+	i := slices.IndexFunc(config.Storage.Tenants, func(t StorageTenantConfig) bool { return t.Name == as })
+	if i < 0 {
+		return "", false
+	}
+	return "Bearer " + config.Storage.Tenants[i].Token, true
+}
+
+func errBadSignature(msg string) error {
+	return codedError(http.StatusUnauthorized, "ERR_SIGNATURE_INVALID", msg)
+}
+
+// verify checks r's signature, timestamp and nonce, and returns the
+// identity of its client. The body is read and replaced.
+func (v *requestVerifier) verify(r *http.Request, now time.Time) (string, error) {
+	id := r.Header.Get(signatureKeyHeader)
+	i := slices.IndexFunc(v.clients, func(c SigningClientConfig) bool { return c.ID == id })
+	if i < 0 {
+		return "", errBadSignature("Unknown signing key")
+	}
+	client := v.clients[i]
+
+	timestamp, nonce := r.Header.Get(signatureTimestampHeader), r.Header.Get(signatureNonceHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", errBadSignature(signatureTimestampHeader + " must be the time in Unix seconds")
+	}
+	if skew := now.Sub(time.Unix(unix, 0)).Abs(); skew > v.maxSkew {
+		return "", errBadSignature(fmt.Sprintf("The request's timestamp is %s off the server's clock", skew.Round(time.Second)))
+	}
+	if len(nonce) < 16 || len(nonce) > 64 {
+		return "", errBadSignature(signatureNonceHeader + " must have 16 to 64 characters")
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, v.maxBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return "", codedError(http.StatusRequestEntityTooLarge, "ERR_PAYLOAD_TOO_LARGE", fmt.Sprintf("Signed requests may have at most %d bytes of body", v.maxBody))
+		}
+		return "", codedError(http.StatusBadRequest, "ERR_BAD_REQUEST", "Failed to read the request body", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	want := signRequest(client.Secret, signatureBase(r.Method, r.URL.RequestURI(), timestamp, nonce, body))
+	if !hmac.Equal([]byte(want), []byte(r.Header.Get(signatureHeader))) {
+		return "", errBadSignature("The signature does not match the request")
+	}
+
+	// Nonces are remembered as long as their timestamp is accepted, so a
+	// replay within the window is refused and one after it is too old.
+	// They are never evicted early: when the store is full of nonces still
+	// in the window, signed requests are refused until some expire.
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key := client.ID + "/" + nonce
+	if _, seen := v.nonces.get(key); seen {
+		return "", errBadSignature("The nonce has been used before")
+	}
+	if !v.nonces.add(key, struct{}{}, 2*v.maxSkew) {
+		return "", codedError(http.StatusServiceUnavailable, "ERR_UNAVAILABLE", "Too many signed requests within the replay window; retry later")
+	}
+	return client.As, nil
+}
+
+// middleware authenticates signed requests as their client's identity by
+// setting the Authorization header the endpoints check. Requests without
+// a signature pass unchanged.
+func (v *requestVerifier) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(signatureKeyHeader) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		as, err := v.verify(r, time.Now())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		bearer, ok := bearerFor(as)
+		if !ok {
+			writeError(w, errBadSignature(fmt.Sprintf("The signing key acts as %s, which is not configured", as)))
+			return
+		}
+		r.Header.Set("Authorization", bearer)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func TestRequestSigning(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "admin-token"
+	config.Storage.Tenants = []StorageTenantConfig{{Name: "acme", Token: "acme-token", Bucket: "tenants"}}
+	config.RequestSigning.Clients = []SigningClientConfig{
+		{ID: "billing", Secret: strings.Repeat("b", 32), As: "acme"},
+		{ID: "ops", Secret: strings.Repeat("o", 32), As: "admin"},
+		{ID: "gone", Secret: strings.Repeat("g", 32), As: "globex"},
+	}
+	// Decoding the configuration keeps slice entries, so reset the clients
+	defer func() { config.RequestSigning.Clients = nil }()
+	v := newRequestVerifier(config.RequestSigning)
+	handler := v.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(r.Header.Get("Authorization") + " " + string(body)))
+	}))
+
+	nonce := 0
+	signed := func(id, secret, method, uri, body string, at time.Time) *http.Request {
+		nonce++
+		req := httptest.NewRequest(method, uri, strings.NewReader(body))
+		ts, n := strconv.FormatInt(at.Unix(), 10), "nonce-"+strings.Repeat("0", 10)+strconv.Itoa(nonce)
+		req.Header.Set(signatureKeyHeader, id)
+		req.Header.Set(signatureTimestampHeader, ts)
+		req.Header.Set(signatureNonceHeader, n)
+		req.Header.Set(signatureHeader, signRequest(secret, signatureBase(method, uri, ts, n, []byte(body))))
+		return req
+	}
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	req := signed("billing", strings.Repeat("b", 32), http.MethodPost, "/chat?stream=false", `{"message": "Hi"}`, time.Now())
+	if w := serve(req); w.Code != http.StatusOK || w.Body.String() != `Bearer acme-token {"message": "Hi"}` {
+		t.Errorf("Expected the request to pass as acme with its body, got %d: %s", w.Code, w.Body.String())
+	}
+	// The same request again is a replay
+	replay := req.Clone(req.Context())
+	replay.Body = io.NopCloser(strings.NewReader(`{"message": "Hi"}`))
+	if w := serve(replay); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "nonce") {
+		t.Errorf("Expected the replay to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve(signed("ops", strings.Repeat("o", 32), http.MethodGet, "/admin/approvals", "", time.Now())); w.Body.String() != "Bearer admin-token " {
+		t.Errorf("Expected the request to pass as the admin, got %s", w.Body.String())
+	}
+
+	tampered := signed("billing", strings.Repeat("b", 32), http.MethodPost, "/chat", `{"message": "Hi"}`, time.Now())
+	tampered.Body = io.NopCloser(strings.NewReader(`{"message": "Bye"}`))
+	for name, req := range map[string]*http.Request{
+		"tampered body":  tampered,
+		"wrong secret":   signed("billing", strings.Repeat("x", 32), http.MethodGet, "/health", "", time.Now()),
+		"unknown key":    signed("nobody", strings.Repeat("b", 32), http.MethodGet, "/health", "", time.Now()),
+		"old timestamp":  signed("billing", strings.Repeat("b", 32), http.MethodGet, "/health", "", time.Now().Add(-10*time.Minute)),
+		"unknown tenant": signed("gone", strings.Repeat("g", 32), http.MethodGet, "/health", "", time.Now()),
+	} {
+		if w := serve(req); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "ERR_SIGNATURE_INVALID") {
+			t.Errorf("Expected the %s to be refused, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+
+	// Unsigned requests pass untouched
+	req = httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set("Authorization", "Bearer mine")
+	if w := serve(req); w.Body.String() != "Bearer mine " {
+		t.Errorf("Expected an unsigned request to keep its header, got %s", w.Body.String())
+	}
+
+	// A full nonce store refuses signed requests rather than forget nonces
+	// that could still be replayed
+	v.nonces = newLRUStore("signature_nonces", StoreLimits{MaxEntries: 1, MaxBytes: 64}, func(struct{}) int64 { return 64 })
+	if w := serve(signed("billing", strings.Repeat("b", 32), http.MethodGet, "/health", "", time.Now())); w.Code != http.StatusOK {
+		t.Errorf("Expected the first request to pass, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve(signed("billing", strings.Repeat("b", 32), http.MethodGet, "/health", "", time.Now())); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 with the nonce store full, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if err := (RequestSigningConfig{Clients: []SigningClientConfig{{ID: "a", Secret: "short", As: "admin"}}, MaxSkewSecs: 300, MaxBodyBytes: 1, Nonces: StoreLimits{MaxEntries: 1, MaxBytes: 1}}).Validate(); err == nil {
+		t.Error("Expected a short secret to be rejected")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/minio/minio-go/v7"
+)
+
+// sitesPrefix is where published sites are served by name.
+const sitesPrefix = "/sites"
+
+// SitesConfig configures static websites served straight from MinIO.
+type SitesConfig struct {
+	IndexDocument string       `mapstructure:"index_document" doc:"Object served for a folder, e.g. index.html"`
+	ErrorDocument string       `mapstructure:"error_document" doc:"Object inside the site served with status 404 for missing pages; empty returns a JSON error"`
+	SanitizeHTML  bool         `mapstructure:"sanitize_html" doc:"Strip scripts, frames, forms and event handlers from published HTML pages"`
+	Published     []SiteConfig `mapstructure:"published" doc:"Bucket prefixes published as public websites"`
+}
+
+type SiteConfig struct {
+	Name   string   `mapstructure:"name" doc:"Site name; the site is served under /sites/{name}/"`
+	Bucket string   `mapstructure:"bucket" doc:"Bucket holding the site"`
+	Prefix string   `mapstructure:"prefix" doc:"Folder in the bucket that is the site's root; empty publishes the whole bucket"`
+	Hosts  []string `mapstructure:"hosts" doc:"Custom domains that serve the site at their root"`
+}
+
+func (c SitesConfig) Validate() error {
+	if len(c.Published) > 0 && c.IndexDocument == "" {
+		return errors.New("sites.index_document must not be empty")
+	}
+	names := map[string]bool{}
+	hosts := map[string]bool{}
+	for _, s := range c.Published {
+		if s.Name == "" || s.Bucket == "" || strings.Contains(s.Name, "/") {
+			return errors.New("sites.published: every site needs a name without slashes and a bucket")
+		}
+		if names[s.Name] {
+			return fmt.Errorf("sites.published: duplicate site %q", s.Name)
+		}
+		names[s.Name] = true
+		for _, h := range s.Hosts {
+			h = strings.ToLower(h)
+			if hosts[h] {
+				return fmt.Errorf("sites.published.%s: host %q is used by another site", s.Name, h)
+			}
+			hosts[h] = true
+		}
+	}
+	return nil
+}
+
+// root returns the site's object prefix, empty or ending in a slash.
+func (s SiteConfig) root() string {
+	return folderPrefix(s.Prefix)
+}
+
+// siteServer serves published sites by name and by custom domain.
+type siteServer struct {
+	config SitesConfig
+	byName map[string]SiteConfig
+	byHost map[string]SiteConfig
+}
+
+// newSiteServer returns nil when no sites are published.
+func newSiteServer(c SitesConfig) *siteServer {
+	if len(c.Published) == 0 {
+		return nil
+	}
+	s := &siteServer{config: c, byName: map[string]SiteConfig{}, byHost: map[string]SiteConfig{}}
+	for _, site := range c.Published {
+		s.byName[site.Name] = site
+		for _, h := range site.Hosts {
+			s.byHost[strings.ToLower(h)] = site
+		}
+	}
+	return s
+}
+
+// requestHost returns the request's host name without port, lowercased.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// hostMiddleware serves requests for a site's custom domain from the site
+// and passes everything else on to the API.
+func (s *siteServer) hostMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if site, ok := s.byHost[requestHost(r)]; ok {
+			s.serve(w, r, site, r.URL.Path)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ServeHTTP serves /sites/{site}/*.
+func (s *siteServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	site, ok := s.byName[chi.URLParam(r, "site")]
+	if !ok {
+		writeError(w, huma.Error404NotFound(fmt.Sprintf("Site %s not found", chi.URLParam(r, "site"))))
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, sitesPrefix+"/"+site.Name)
+	if rest == "" {
+		// Relative links only resolve against the site root with a slash
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+	s.serve(w, r, site, rest)
+}
+
+// siteContentType returns the stored content type, or one derived from the
+// key's extension when the object was uploaded without a useful one.
+func siteContentType(stored, key string) string {
+	ct, _, _ := mime.ParseMediaType(stored)
+	if ct != "" && ct != "application/octet-stream" && ct != "binary/octet-stream" {
+		return stored
+	}
+	if byExt := mime.TypeByExtension(path.Ext(key)); byExt != "" {
+		return byExt
+	}
+	return "application/octet-stream"
+}
+
+// serve writes the object for urlPath, resolving folders to their index
+// document. HTML pages are sanitized unless disabled.
+func (s *siteServer) serve(w http.ResponseWriter, r *http.Request, site SiteConfig, urlPath string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, huma.Error405MethodNotAllowed("Sites are read-only"))
+		return
+	}
+	if _, err := services.MinIO(); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	rel := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if rel == "" || strings.HasSuffix(urlPath, "/") {
+		rel = path.Join(rel, s.config.IndexDocument)
+	}
+
+	key := site.root() + rel
+	obj, info, err := s.open(r, site, key)
+	if isNotFound(err) && path.Ext(rel) == "" {
+		// A folder requested without its trailing slash
+		if index, _, err := s.open(r, site, key+"/"+s.config.IndexDocument); err == nil {
+			index.Close()
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+	}
+	status := http.StatusOK
+	if isNotFound(err) && s.config.ErrorDocument != "" {
+		status = http.StatusNotFound
+		key = site.root() + s.config.ErrorDocument
+		obj, info, err = s.open(r, site, key)
+	}
+	if err != nil {
+		if isNotFound(err) {
+			writeError(w, huma.Error404NotFound(fmt.Sprintf("Page %s not found", urlPath)))
+			return
+		}
+		writeError(w, huma.Error502BadGateway("Failed to read site object", err))
+		return
+	}
+	defer obj.Close()
+
+	contentType := siteContentType(info.ContentType, key)
+	etag := `"` + info.ETag + `"`
+	var body io.ReadSeeker = obj
+	if mt, _, _ := mime.ParseMediaType(contentType); mt == "text/html" && s.config.SanitizeHTML {
+		src, err := io.ReadAll(io.LimitReader(obj, config.Limits.MaxRenderBytes+1))
+		if err != nil {
+			writeError(w, huma.Error502BadGateway("Failed to read site object", err))
+			return
+		}
+		if int64(len(src)) > config.Limits.MaxRenderBytes {
+			writeError(w, huma.Error422UnprocessableEntity(fmt.Sprintf("Page %s is too large to publish", urlPath)))
+			return
+		}
+		body = bytes.NewReader(sanitizeHTML(src))
+		etag = "W/" + etag
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			io.Copy(w, body)
+		}
+		return
+	}
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, key, info.LastModified, body)
+}
+
+// open fetches a site object. Objects whose ACL does not make them public
+// are treated as missing, so publishing a prefix never leaks private files.
+func (s *siteServer) open(r *http.Request, site SiteConfig, key string) (*minio.Object, minio.ObjectInfo, error) {
+	client, err := services.MinIOReader()
+	if err != nil {
+		return nil, minio.ObjectInfo{}, err
+	}
+	notFound := minio.ErrorResponse{Code: "NoSuchKey", Key: key}
+	acls, err := loadACLs(r.Context(), site.Bucket)
+	if err != nil {
+		return nil, minio.ObjectInfo{}, err
+	}
+	if acl, ok := acls[key]; ok && acl.Visibility != aclPublicRead {
+		return nil, minio.ObjectInfo{}, notFound
+	}
+
+	obj, err := client.GetObject(r.Context(), site.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, minio.ObjectInfo{}, err
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, minio.ObjectInfo{}, err
+	}
+	return obj, info, nil
+}
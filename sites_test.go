@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+// fakeS3 serves objects from a map keyed by bucket/key, enough for
+// GetObject, StatObject, PutObject, CopyObject and recursive ListObjects.
+// Every bucket exists.
+func fakeS3(objects map[string]string) http.Handler {
+	var mu sync.Mutex
+	meta := map[string]http.Header{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		query := r.URL.Query()
+		if r.Method == http.MethodPut {
+			if key := strings.TrimPrefix(r.URL.Path, "/"); strings.Contains(key, "/") {
+				if match := r.Header.Get("If-Match"); match != "" {
+					if current, ok := objects[key]; !ok || match != fmt.Sprintf(`"%x"`, md5.Sum([]byte(current))) {
+						w.Header().Set("Content-Type", "application/xml")
+						w.WriteHeader(http.StatusPreconditionFailed)
+						w.Write([]byte(`<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`))
+						return
+					}
+				}
+				body, _ := io.ReadAll(r.Body)
+				if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+					body = decodeAWSChunked(body)
+				}
+				if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+					source, _, _ = strings.Cut(source, "?versionId=")
+					source, _ = url.PathUnescape(strings.TrimPrefix(source, "/"))
+					current, ok := objects[source]
+					if !ok {
+						w.Header().Set("Content-Type", "application/xml")
+						w.WriteHeader(http.StatusNotFound)
+						w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>Not found</Message></Error>`))
+						return
+					}
+					objects[key] = current
+					meta[key] = meta[source]
+					fmt.Fprintf(w, `<CopyObjectResult><ETag>"%x"</ETag><LastModified>2026-01-02T15:04:05.000Z</LastModified></CopyObjectResult>`, md5.Sum([]byte(current)))
+					return
+				}
+				objects[key] = string(body)
+				w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(body)))
+				meta[key] = http.Header{}
+				for k, v := range r.Header {
+					if strings.HasPrefix(k, "X-Amz-Meta-") {
+						meta[key][k] = v
+					}
+				}
+			}
+			if w.Header().Get("ETag") == "" {
+				w.Header().Set("ETag", `"etag"`)
+			}
+			return
+		}
+		if r.Method == http.MethodDelete {
+			delete(objects, strings.TrimPrefix(r.URL.Path, "/"))
+			delete(meta, strings.TrimPrefix(r.URL.Path, "/"))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.Method == http.MethodHead && !strings.Contains(strings.Trim(r.URL.Path, "/"), "/") {
+			return
+		}
+		if _, ok := query["location"]; ok {
+			w.Write([]byte(`<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-east-1</LocationConstraint>`))
+			return
+		}
+		if query.Get("list-type") == "2" {
+			bucket := strings.Trim(r.URL.Path, "/")
+			prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+			keys := []string{}
+			folders := map[string]bool{}
+			for k := range objects {
+				key, ok := strings.CutPrefix(k, bucket+"/")
+				if !ok || !strings.HasPrefix(key, prefix) {
+					continue
+				}
+				if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+					folders[key[:len(prefix)+i+len(delimiter)]] = true
+					continue
+				}
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			fmt.Fprintf(w, `<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>%s</Name><KeyCount>%d</KeyCount><IsTruncated>false</IsTruncated>`, bucket, len(keys)+len(folders))
+			for _, k := range keys {
+				fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size><LastModified>2026-01-02T15:04:05.000Z</LastModified></Contents>`, k, len(objects[bucket+"/"+k]))
+			}
+			for k := range folders {
+				fmt.Fprintf(w, `<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>`, k)
+			}
+			w.Write([]byte(`</ListBucketResult>`))
+			return
+		}
+		body, ok := objects[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method != http.MethodHead {
+				w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>Not found</Message></Error>`))
+			}
+			return
+		}
+		for k, v := range meta[strings.TrimPrefix(r.URL.Path, "/")] {
+			w.Header()[k] = v
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum([]byte(body))))
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2026 15:04:05 GMT")
+		w.Header().Set("Content-Type", "binary/octet-stream")
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+	})
+}
+
+// decodeAWSChunked strips the chunk headers of a signed streaming upload.
+func decodeAWSChunked(body []byte) []byte {
+	var out []byte
+	for len(body) > 0 {
+		header, rest, _ := strings.Cut(string(body), "\r\n")
+		size, err := strconv.ParseInt(strings.Split(header, ";")[0], 16, 64)
+		if err != nil || size == 0 || int64(len(rest)) < size {
+			break
+		}
+		out = append(out, rest[:size]...)
+		body = []byte(strings.TrimPrefix(rest[size:], "\r\n"))
+	}
+	return out
+}
+
+func TestSitesConfigValidate(t *testing.T) {
+	invalid := []SitesConfig{
+		{Published: []SiteConfig{{Name: "reports", Bucket: "docs"}}},
+		{IndexDocument: "index.html", Published: []SiteConfig{{Name: "a/b", Bucket: "docs"}}},
+		{IndexDocument: "index.html", Published: []SiteConfig{{Name: "a", Bucket: "docs"}, {Name: "a", Bucket: "docs"}}},
+		{IndexDocument: "index.html", Published: []SiteConfig{
+			{Name: "a", Bucket: "docs", Hosts: []string{"reports.example.com"}},
+			{Name: "b", Bucket: "docs", Hosts: []string{"Reports.example.com"}},
+		}},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", c)
+		}
+	}
+}
+
+func TestSiteServer(t *testing.T) {
+	viper.Reset()
+	initConfig()
+
+	s3 := httptest.NewServer(fakeS3(map[string]string{
+		"docs/reports/index.html":      "<h1>Reports</h1>",
+		"docs/reports/q1/index.html":   "<h1>Q1</h1>",
+		"docs/reports/style.css":       "body {}",
+		"docs/reports/unsafe.html":     `<p onclick="x()">hi</p><script>x()</script>`,
+		"docs/reports/404.html":        "<h1>Missing</h1>",
+		"app-system/acls/docs.json":    `{"reports/secret.html": {"visibility": "private", "owner": "acme"}}`,
+		"docs/reports/secret.html":     "secret",
+		"docs/unpublished/index.html":  "hidden",
+		"docs/reports/data/latest.txt": "42",
+	}))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	sites := newSiteServer(SitesConfig{
+		IndexDocument: "index.html",
+		SanitizeHTML:  true,
+		Published:     []SiteConfig{{Name: "reports", Bucket: "docs", Prefix: "reports", Hosts: []string{"reports.example.com"}}},
+	})
+	router := chi.NewMux()
+	router.Use(sites.hostMiddleware)
+	router.Handle(sitesPrefix+"/{site}", sites)
+	router.Handle(sitesPrefix+"/{site}/*", sites)
+	router.Get("/health", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+
+	get := func(host, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	cases := []struct {
+		host, path  string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"api.example.com", "/sites/reports/", http.StatusOK, "text/html; charset=utf-8", "<h1>Reports</h1>"},
+		{"api.example.com", "/sites/reports/style.css", http.StatusOK, "text/css; charset=utf-8", "body {}"},
+		{"api.example.com", "/sites/reports/unsafe.html", http.StatusOK, "text/html; charset=utf-8", "<p>hi</p>"},
+		{"api.example.com", "/sites/reports/q1/", http.StatusOK, "text/html; charset=utf-8", "<h1>Q1</h1>"},
+		{"api.example.com", "/sites/reports/data/latest.txt", http.StatusOK, "text/plain; charset=utf-8", "42"},
+		{"api.example.com", "/sites/reports/secret.html", http.StatusNotFound, "", ""},
+		{"api.example.com", "/sites/reports/../unpublished/", http.StatusNotFound, "", ""},
+		{"api.example.com", "/sites/other/", http.StatusNotFound, "", ""},
+		{"reports.example.com:8080", "/q1/", http.StatusOK, "text/html; charset=utf-8", "<h1>Q1</h1>"},
+		{"api.example.com", "/health", http.StatusOK, "", "ok"},
+	}
+	for _, c := range cases {
+		w := get(c.host, c.path)
+		if w.Code != c.status {
+			t.Errorf("GET %s%s: expected %d, got %d: %s", c.host, c.path, c.status, w.Code, w.Body.String())
+			continue
+		}
+		if c.contentType != "" && w.Header().Get("Content-Type") != c.contentType {
+			t.Errorf("GET %s%s: expected content type %q, got %q", c.host, c.path, c.contentType, w.Header().Get("Content-Type"))
+		}
+		if c.body != "" && w.Body.String() != c.body {
+			t.Errorf("GET %s%s: expected body %q, got %q", c.host, c.path, c.body, w.Body.String())
+		}
+	}
+
+	if w := get("api.example.com", "/sites/reports/q1"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/sites/reports/q1/" {
+		t.Errorf("Expected a folder without slash to redirect, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+
+	sites.config.ErrorDocument = "404.html"
+	if w := get("reports.example.com", "/missing.html"); w.Code != http.StatusNotFound || w.Body.String() != "<h1>Missing</h1>" {
+		t.Errorf("Expected the error document with 404, got %d: %s", w.Code, w.Body.String())
+	}
+}
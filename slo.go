@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// sloWindow is the compliance window SLOs are evaluated over. It matches
+// how much usage history is kept in memory.
+const sloWindow = usageRetention
+
+// sloAlertCooldown limits burn-rate alerts to one per SLO per period.
+const sloAlertCooldown = time.Hour
+
+// sloBurnWindows are the windows burn rates are reported for.
+var sloBurnWindows = []struct {
+	Name     string
+	Duration time.Duration
+}{
+	{"5m", 5 * time.Minute},
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+}
+
+// SLOConfig defines a service level objective in the configuration file.
+type SLOConfig struct {
+	Name        string  `mapstructure:"name" doc:"SLO name"`
+	Type        string  `mapstructure:"type" enum:"availability,latency" doc:"availability counts non-5xx responses as good, latency counts requests within threshold_ms"`
+	Objective   float64 `mapstructure:"objective" doc:"Target fraction of good requests, e.g. 0.999"`
+	ThresholdMs int     `mapstructure:"threshold_ms" doc:"Latency threshold for latency SLOs"`
+}
+
+type SLOStatus struct {
+	Name                 string             `json:"name" doc:"SLO name"`
+	Type                 string             `json:"type" enum:"availability,latency" doc:"What the SLO measures"`
+	Objective            float64            `json:"objective" doc:"Target fraction of good requests"`
+	ThresholdMs          int                `json:"threshold_ms,omitempty" doc:"Latency threshold for latency SLOs"`
+	Window               string             `json:"window" doc:"Compliance window"`
+	Total                int64              `json:"total" doc:"Requests in the compliance window"`
+	Good                 int64              `json:"good" doc:"Good requests in the compliance window"`
+	SLI                  float64            `json:"sli" doc:"Fraction of good requests in the compliance window"`
+	ErrorBudgetRemaining float64            `json:"error_budget_remaining" doc:"Fraction of the error budget left; negative once exhausted"`
+	BurnRates            map[string]float64 `json:"burn_rates" doc:"Error budget burn rate per window; 1 spends the budget exactly over the compliance window"`
+	Alerting             bool               `json:"alerting" doc:"Whether the budget is burning faster than the alert threshold"`
+}
+
+type SLOResponse struct {
+	SLOs []SLOStatus `json:"slos" doc:"Status of each configured SLO"`
+}
+
+func (s SLOConfig) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("slo name must not be empty")
+	}
+	if s.Objective <= 0 || s.Objective >= 1 {
+		return fmt.Errorf("slo %s: objective must be between 0 and 1", s.Name)
+	}
+	switch s.Type {
+	case "availability":
+	case "latency":
+		if s.ThresholdMs <= 0 {
+			return fmt.Errorf("slo %s: latency SLOs need a positive threshold_ms", s.Name)
+		}
+	default:
+		return fmt.Errorf("slo %s: unknown type %q", s.Name, s.Type)
+	}
+	return nil
+}
+
+// good returns the number of requests in b that met the objective. Latency
+// thresholds are rounded down to the nearest histogram bound.
+func (s SLOConfig) good(b usageBucket) int64 {
+	if s.Type == "latency" {
+		return b.withinLatency(time.Duration(s.ThresholdMs) * time.Millisecond)
+	}
+	return b.Requests - b.Errors
+}
+
+// burnRate returns how fast the error budget is being spent in b, relative
+// to spending it evenly over the compliance window.
+func (s SLOConfig) burnRate(b usageBucket) float64 {
+	if b.Requests == 0 {
+		return 0
+	}
+	bad := float64(b.Requests-s.good(b)) / float64(b.Requests)
+	return bad / (1 - s.Objective)
+}
+
+// sloStatus evaluates s against the recorded usage up to now. A budget is
+// considered to burn too fast when both the 5m and 1h burn rates exceed
+// the configured threshold.
+func sloStatus(r *usageRecorder, s SLOConfig, now time.Time) SLOStatus {
+	total := r.sum(now.Add(-sloWindow), now.Add(time.Minute))
+	status := SLOStatus{
+		Name:                 s.Name,
+		Type:                 s.Type,
+		Objective:            s.Objective,
+		ThresholdMs:          s.ThresholdMs,
+		Window:               "24h",
+		Total:                total.Requests,
+		Good:                 s.good(total),
+		SLI:                  1,
+		ErrorBudgetRemaining: 1,
+		BurnRates:            map[string]float64{},
+	}
+	if total.Requests > 0 {
+		status.SLI = float64(status.Good) / float64(total.Requests)
+		status.ErrorBudgetRemaining = 1 - s.burnRate(total)
+	}
+	for _, w := range sloBurnWindows {
+		status.BurnRates[w.Name] = s.burnRate(r.sum(now.Add(-w.Duration), now.Add(time.Minute)))
+	}
+	status.Alerting = status.BurnRates["5m"] > config.Monitoring.SLOBurnRateAlert && status.BurnRates["1h"] > config.Monitoring.SLOBurnRateAlert
+	return status
+}
+
+// startSLOMonitor checks every SLO once a minute and alerts when an error
+// budget burns too fast.
+func startSLOMonitor(ctx context.Context) {
+	lastAlert := map[string]time.Time{}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				for _, s := range config.Monitoring.SLOs {
+					status := sloStatus(usage, s, now)
+					if !status.Alerting || now.Sub(lastAlert[s.Name]) < sloAlertCooldown {
+						continue
+					}
+					lastAlert[s.Name] = now
+					a := Alert{
+						Metric:   "slo_burn_rate:" + s.Name,
+						Current:  status.BurnRates["1h"],
+						Baseline: config.Monitoring.SLOBurnRateAlert,
+						Message: fmt.Sprintf("SLO %s is burning its error budget at %.1fx (5m) and %.1fx (1h); %.1f%% of the budget is left",
+							s.Name, status.BurnRates["5m"], status.BurnRates["1h"], status.ErrorBudgetRemaining*100),
+						Time: now,
+					}
+					log.Printf("SLO alert: %s", a.Message)
+					sendAlert(ctx, a)
+				}
+			}
+		}
+	}()
+}
+
+func registerSLOEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "get-slos",
+		Method:      http.MethodGet,
+		Path:        "/slo",
+		Summary:     "SLO status",
+		Description: "Report compliance, remaining error budget and burn rates for every configured SLO",
+	}, func(ctx context.Context, input *struct{}) (*struct {
+		Body SLOResponse
+	}, error) {
+		now := time.Now()
+		slos := []SLOStatus{}
+		for _, s := range config.Monitoring.SLOs {
+			slos = append(slos, sloStatus(usage, s, now))
+		}
+
+		return &struct {
+			Body SLOResponse
+		}{
+			Body: SLOResponse{SLOs: slos},
+		}, nil
+	})
+}
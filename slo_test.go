@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestSLOConfigValidate(t *testing.T) {
+	valid := []SLOConfig{
+		{Name: "api", Type: "availability", Objective: 0.999},
+		{Name: "fast", Type: "latency", Objective: 0.95, ThresholdMs: 500},
+	}
+	for _, s := range valid {
+		if err := s.validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", s, err)
+		}
+	}
+
+	invalid := []SLOConfig{
+		{Type: "availability", Objective: 0.999},
+		{Name: "api", Type: "availability", Objective: 1},
+		{Name: "fast", Type: "latency", Objective: 0.95},
+		{Name: "api", Type: "throughput", Objective: 0.9},
+	}
+	for _, s := range invalid {
+		if err := s.validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", s)
+		}
+	}
+}
+
+func TestSLOStatus(t *testing.T) {
+	viper.Reset()
+	initConfig()
+
+	r := &usageRecorder{}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Healthy traffic for the past day, then a burst of slow errors
+	for i := 0; i < 1000; i++ {
+		r.recordRequest(now.Add(-12*time.Hour), http.StatusOK, 10*time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		r.recordRequest(now, http.StatusInternalServerError, 3*time.Second)
+	}
+
+	availability := sloStatus(r, SLOConfig{Name: "api", Type: "availability", Objective: 0.99}, now)
+	if availability.Total != 1010 || availability.Good != 1000 {
+		t.Errorf("Unexpected totals: %+v", availability)
+	}
+	// 10 bad of 1010 uses 99% of a 1% budget
+	if got := availability.ErrorBudgetRemaining; got < 0.0099 || got > 0.01 {
+		t.Errorf("Expected about 1%% of the budget left, got %f", got)
+	}
+	// Every request in the last 5 minutes failed: 100x burn
+	if got := availability.BurnRates["5m"]; got < 99.9 || got > 100.1 {
+		t.Errorf("Expected a 100x burn rate, got %f", got)
+	}
+	if !availability.Alerting {
+		t.Error("Expected the availability SLO to be alerting")
+	}
+
+	latency := sloStatus(r, SLOConfig{Name: "fast", Type: "latency", Objective: 0.9, ThresholdMs: 1000}, now)
+	if latency.Good != 1000 {
+		t.Errorf("Expected slow requests to be counted as bad, got %+v", latency)
+	}
+
+	empty := sloStatus(&usageRecorder{}, SLOConfig{Name: "api", Type: "availability", Objective: 0.99}, now)
+	if empty.SLI != 1 || empty.ErrorBudgetRemaining != 1 || empty.Alerting {
+		t.Errorf("Expected a full budget without traffic, got %+v", empty)
+	}
+}
+
+func TestSLOEndpoint(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Monitoring.SLOs = []SLOConfig{{Name: "api", Type: "availability", Objective: 0.999}}
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerSLOEndpoint(api)
+
+	req := httptest.NewRequest(http.MethodGet, "/slo", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp SLOResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.SLOs) != 1 || resp.SLOs[0].Name != "api" || resp.SLOs[0].BurnRates["1h"] < 0 {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+}
@@ -0,0 +1,753 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	// sourcesPrefix is where source definitions live in the system bucket.
+	sourcesPrefix = "sources/"
+)
+
+var (
+	// sourcesMu serializes read-modify-write updates of source definitions.
+	sourcesMu sync.Mutex
+	// runningSyncs tracks which sources are currently syncing.
+	runningSyncs   = map[string]bool{}
+	runningSyncsMu sync.Mutex
+)
+
+// errSyncRunning is returned when a source is already being synced.
+var errSyncRunning = errors.New("source is already syncing")
+
+type S3SourceConfig struct {
+	Endpoint  string `json:"endpoint" doc:"S3 endpoint, e.g. s3.amazonaws.com or minio.example.com:9000"`
+	Bucket    string `json:"bucket" doc:"Bucket to pull from"`
+	Prefix    string `json:"prefix,omitempty" doc:"Only pull objects under this prefix"`
+	AccessKey string `json:"access_key,omitempty" doc:"Access key"`
+	SecretKey string `json:"secret_key,omitempty" doc:"Secret key (write-only, never returned); stored encrypted with the config key, so it requires APP_CONFIG_KEY or APP_CONFIG_KEY_FILE"`
+	Secure    bool   `json:"secure,omitempty" doc:"Use HTTPS"`
+}
+
+type SitemapSourceConfig struct {
+	URL string `json:"url" format:"uri" doc:"URL of the sitemap.xml"`
+}
+
+type GitSourceConfig struct {
+	URL    string `json:"url" doc:"Repository URL to clone"`
+	Branch string `json:"branch,omitempty" doc:"Branch to pull; defaults to the repository's default branch"`
+	Path   string `json:"path,omitempty" doc:"Only pull files under this directory"`
+}
+
+type SourceCreateRequest struct {
+	Name string `json:"name" pattern:"^[a-z0-9][a-z0-9_-]{0,62}$" doc:"Source name (lowercase letters, digits, - and _)"`
+	SourceRequest
+}
+
+type SourceRequest struct {
+	Type            string               `json:"type" enum:"s3,sitemap,git" doc:"Connector type"`
+	Bucket          string               `json:"bucket" minLength:"1" doc:"Bucket pulled documents are stored in"`
+	Prefix          string               `json:"prefix,omitempty" doc:"Key prefix for pulled documents"`
+	Collection      string               `json:"collection,omitempty" doc:"Collection pulled documents are added to"`
+	IntervalMinutes int                  `json:"interval_minutes" minimum:"5" default:"60" doc:"How often to sync"`
+	S3              *S3SourceConfig      `json:"s3,omitempty" doc:"Settings for the s3 connector"`
+	Sitemap         *SitemapSourceConfig `json:"sitemap,omitempty" doc:"Settings for the sitemap connector"`
+	Git             *GitSourceConfig     `json:"git,omitempty" doc:"Settings for the git connector"`
+}
+
+type Source struct {
+	Name string `json:"name" doc:"Source name"`
+	SourceRequest
+	CreatedAt      time.Time `json:"created_at" doc:"Creation time"`
+	LastSyncAt     time.Time `json:"last_sync_at,omitempty" doc:"When the last sync finished"`
+	LastSyncError  string    `json:"last_sync_error,omitempty" doc:"Error from the last sync, if it failed"`
+	LastSyncedDocs int       `json:"last_synced_docs" doc:"Documents added or updated by the last sync"`
+}
+
+type SourceListResponse struct {
+	Sources []Source `json:"sources" doc:"All sources"`
+}
+
+type SourceSyncResponse struct {
+	Synced int `json:"synced" doc:"Documents added or updated"`
+}
+
+// validate checks that the settings for the chosen connector are present.
+func (r *SourceRequest) validate() error {
+	if err := checkBucketAccess(r.Bucket); err != nil {
+		return err
+	}
+	switch r.Type {
+	case "s3":
+		if r.S3 == nil || r.S3.Endpoint == "" || r.S3.Bucket == "" {
+			return huma.Error422UnprocessableEntity("s3 sources require s3.endpoint and s3.bucket")
+		}
+		if r.S3.SecretKey != "" {
+			if _, err := configKey(); err != nil {
+				return huma.Error422UnprocessableEntity("Secret keys of s3 sources are stored encrypted; set APP_CONFIG_KEY or APP_CONFIG_KEY_FILE", err)
+			}
+		}
+	case "sitemap":
+		if r.Sitemap == nil || r.Sitemap.URL == "" {
+			return huma.Error422UnprocessableEntity("sitemap sources require sitemap.url")
+		}
+	case "git":
+		if r.Git == nil || r.Git.URL == "" {
+			return huma.Error422UnprocessableEntity("git sources require git.url")
+		}
+	}
+	return nil
+}
+
+// redacted returns a copy of s that is safe to return from the API.
+func (s Source) redacted() Source {
+	if s.S3 != nil {
+		s3 := *s.S3
+		s3.SecretKey = ""
+		s.S3 = &s3
+	}
+	return s
+}
+
+func sourceKey(name string) string {
+	return sourcesPrefix + name + ".json"
+}
+
+// loadSource reads a source and decrypts its S3 secret key. Keys stored
+// in plaintext before they were encrypted are used as they are, and are
+// encrypted when the source is saved next.
+func loadSource(ctx context.Context, name string) (*Source, error) {
+	var s Source
+	if err := getJSON(ctx, config.MinIO.SystemBucket, sourceKey(name), &s); err != nil {
+		if isNotFound(err) {
+			return nil, huma.Error404NotFound(fmt.Sprintf("Source %s not found", name))
+		}
+		return nil, huma.Error500InternalServerError("Failed to load source", err)
+	}
+	if s.S3 != nil && strings.HasPrefix(s.S3.SecretKey, encryptedPrefix) {
+		key, err := configKey()
+		if err == nil {
+			s.S3.SecretKey, err = decryptConfigValue(key, s.S3.SecretKey)
+		}
+		if err != nil {
+			return nil, huma.Error500InternalServerError(fmt.Sprintf("Failed to decrypt the secret key of source %s", name), err)
+		}
+	}
+	return &s, nil
+}
+
+// saveSource writes src with its S3 secret key encrypted with the config
+// key, so the system bucket never holds the key in plaintext.
+func saveSource(ctx context.Context, src *Source) error {
+	stored := *src
+	if src.S3 != nil && src.S3.SecretKey != "" {
+		key, err := configKey()
+		if err != nil {
+			return err
+		}
+		s3 := *src.S3
+		if s3.SecretKey, err = encryptConfigValue(key, s3.SecretKey); err != nil {
+			return err
+		}
+		stored.S3 = &s3
+	}
+	return putJSON(ctx, config.MinIO.SystemBucket, sourceKey(src.Name), stored)
+}
+
+func listSources(ctx context.Context) ([]Source, error) {
+	client, err := services.MinIO()
+	if err != nil {
+		return nil, err
+	}
+	sources := []Source{}
+	for obj := range client.ListObjects(ctx, config.MinIO.SystemBucket, minio.ListObjectsOptions{Prefix: sourcesPrefix}) {
+		if obj.Err != nil {
+			// No system bucket yet means no sources
+			if isNotFound(obj.Err) {
+				break
+			}
+			return nil, obj.Err
+		}
+		s, err := loadSource(ctx, strings.TrimSuffix(strings.TrimPrefix(obj.Key, sourcesPrefix), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, *s)
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Name < sources[j].Name })
+	return sources, nil
+}
+
+// sourceDocument is one document offered by a connector. Version is an
+// opaque value (ETag, lastmod, commit) used to skip unchanged documents.
+type sourceDocument struct {
+	Key         string
+	Version     string
+	ContentType string
+	Size        int64
+	Open        func() (io.ReadCloser, error)
+}
+
+// storeSourceDocument copies doc into the source's bucket unless the stored
+// copy already has the same version. It reports whether anything was written.
+func storeSourceDocument(ctx context.Context, src *Source, doc sourceDocument) (string, bool, error) {
+	key := path.Join(src.Prefix, doc.Key)
+	client, err := services.MinIO()
+	if err != nil {
+		return key, false, err
+	}
+	if doc.Version != "" {
+		info, err := client.StatObject(ctx, src.Bucket, key, minio.StatObjectOptions{})
+		if err == nil && info.UserMetadata["Source-Version"] == doc.Version {
+			return key, false, nil
+		}
+	}
+
+	body, err := doc.Open()
+	if err != nil {
+		return key, false, err
+	}
+	defer body.Close()
+
+	contentType := doc.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(key))
+	}
+	_, err = client.PutObject(ctx, src.Bucket, key, io.LimitReader(body, config.Limits.MaxSourceDocumentBytes), doc.Size, minio.PutObjectOptions{
+		ContentType:  contentType,
+		UserMetadata: map[string]string{"Source": src.Name, "Source-Version": doc.Version},
+	})
+	return key, err == nil, err
+}
+
+// syncSource pulls every document from a source into its bucket and adds
+// them to the source's collection. It returns how many documents changed.
+func syncSource(ctx context.Context, src *Source) (int, error) {
+	runningSyncsMu.Lock()
+	if runningSyncs[src.Name] {
+		runningSyncsMu.Unlock()
+		return 0, errSyncRunning
+	}
+	runningSyncs[src.Name] = true
+	runningSyncsMu.Unlock()
+	defer func() {
+		runningSyncsMu.Lock()
+		delete(runningSyncs, src.Name)
+		runningSyncsMu.Unlock()
+	}()
+
+	if err := ensureBucket(ctx, src.Bucket); err != nil {
+		return 0, err
+	}
+
+	var docs []CollectionDocument
+	changed := 0
+	emit := func(doc sourceDocument) error {
+		if len(docs) >= config.Limits.MaxSourceDocuments {
+			return fmt.Errorf("source has more than %d documents", config.Limits.MaxSourceDocuments)
+		}
+		key, written, err := storeSourceDocument(ctx, src, doc)
+		if err != nil {
+			return fmt.Errorf("store %s: %w", doc.Key, err)
+		}
+		if written {
+			changed++
+		}
+		docs = append(docs, CollectionDocument{Bucket: src.Bucket, Name: key})
+		return nil
+	}
+
+	var err error
+	switch src.Type {
+	case "s3":
+		err = pullS3Source(ctx, src.S3, emit)
+	case "sitemap":
+		err = pullSitemapSource(ctx, src.Sitemap, emit)
+	case "git":
+		err = pullGitSource(ctx, src.Git, emit)
+	default:
+		err = fmt.Errorf("unknown source type %q", src.Type)
+	}
+
+	// Register whatever was pulled even if the run stopped early
+	if src.Collection != "" && len(docs) > 0 {
+		_, cErr := updateCollection(ctx, src.Collection, func(c *Collection) error {
+			for _, d := range docs {
+				if !c.hasDocument(d) {
+					c.Documents = append(c.Documents, d)
+				}
+			}
+			return nil
+		})
+		if err == nil && cErr != nil {
+			err = cErr
+		}
+	}
+	return changed, err
+}
+
+// recordSync saves the outcome of a sync run on the source definition.
+func recordSync(ctx context.Context, name string, synced int, syncErr error) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+
+	src, err := loadSource(ctx, name)
+	if err != nil {
+		log.Printf("Failed to record sync of source %s: %v", name, err)
+		return
+	}
+	src.LastSyncAt = time.Now().UTC()
+	src.LastSyncedDocs = synced
+	src.LastSyncError = ""
+	if syncErr != nil {
+		src.LastSyncError = syncErr.Error()
+	}
+	if err := saveSource(ctx, src); err != nil {
+		log.Printf("Failed to record sync of source %s: %v", name, err)
+	}
+}
+
+func pullS3Source(ctx context.Context, cfg *S3SourceConfig, emit func(sourceDocument) error) error {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.Secure,
+	})
+	if err != nil {
+		return err
+	}
+
+	for obj := range client.ListObjects(ctx, cfg.Bucket, minio.ListObjectsOptions{Prefix: cfg.Prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return obj.Err
+		}
+		if strings.HasSuffix(obj.Key, "/") || obj.Size > config.Limits.MaxSourceDocumentBytes {
+			continue
+		}
+		key := obj.Key
+		err := emit(sourceDocument{
+			Key:         strings.TrimPrefix(key, cfg.Prefix),
+			Version:     obj.ETag,
+			ContentType: obj.ContentType,
+			Size:        obj.Size,
+			Open: func() (io.ReadCloser, error) {
+				return client.GetObject(ctx, cfg.Bucket, key, minio.GetObjectOptions{})
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sitemapXML struct {
+	URLs []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+var sourceHTTPClient = &http.Client{Timeout: time.Minute}
+
+func httpGet(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := sourceHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return resp, nil
+}
+
+// sitemapKey maps a page URL to an object key: host plus path, with
+// index.html for directory-style URLs.
+func sitemapKey(pageURL string) (string, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+	p := u.Path
+	if p == "" || strings.HasSuffix(p, "/") {
+		p += "index.html"
+	}
+	return path.Join(u.Host, p), nil
+}
+
+func pullSitemapSource(ctx context.Context, cfg *SitemapSourceConfig, emit func(sourceDocument) error) error {
+	return pullSitemap(ctx, cfg.URL, emit, 0)
+}
+
+func pullSitemap(ctx context.Context, sitemapURL string, emit func(sourceDocument) error, depth int) error {
+	resp, err := httpGet(ctx, sitemapURL)
+	if err != nil {
+		return err
+	}
+	var sm sitemapXML
+	err = xml.NewDecoder(io.LimitReader(resp.Body, config.Limits.MaxSourceDocumentBytes)).Decode(&sm)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("parse sitemap %s: %w", sitemapURL, err)
+	}
+
+	// Follow one level of sitemap index files
+	if depth == 0 {
+		for _, child := range sm.Sitemaps {
+			if err := pullSitemap(ctx, strings.TrimSpace(child.Loc), emit, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, entry := range sm.URLs {
+		pageURL := strings.TrimSpace(entry.Loc)
+		key, err := sitemapKey(pageURL)
+		if err != nil {
+			return err
+		}
+		err = emit(sourceDocument{
+			Key:     key,
+			Version: strings.TrimSpace(entry.LastMod),
+			Size:    -1,
+			Open: func() (io.ReadCloser, error) {
+				resp, err := httpGet(ctx, pageURL)
+				if err != nil {
+					return nil, err
+				}
+				return resp.Body, nil
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pullGitSource(ctx context.Context, cfg *GitSourceConfig, emit func(sourceDocument) error) error {
+	bin, err := exec.LookPath("git")
+	if err != nil {
+		return fmt.Errorf("git sources require git to be installed on the server: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "source-git-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	args := []string{"clone", "--depth", "1"}
+	if cfg.Branch != "" {
+		args = append(args, "--branch", cfg.Branch)
+	}
+	args = append(args, "--", cfg.URL, dir)
+	if out, err := exec.CommandContext(ctx, bin, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("git clone: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	commit, err := exec.CommandContext(ctx, bin, "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return fmt.Errorf("git rev-parse: %w", err)
+	}
+
+	root := filepath.Join(dir, filepath.FromSlash(cfg.Path))
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Size() > config.Limits.MaxSourceDocumentBytes {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		return emit(sourceDocument{
+			Key: filepath.ToSlash(rel),
+			// The commit changes for every file, so version on commit plus size
+			Version: fmt.Sprintf("%s:%d", strings.TrimSpace(string(commit)), info.Size()),
+			Size:    info.Size(),
+			Open: func() (io.ReadCloser, error) {
+				return os.Open(p)
+			},
+		})
+	})
+}
+
+// startSourceScheduler syncs every source whose interval has elapsed,
+// checking once per interval until ctx is cancelled.
+func startSourceScheduler(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				sources, err := listSources(ctx)
+				if err != nil {
+					log.Printf("Failed to list sources: %v", err)
+					continue
+				}
+				for i := range sources {
+					src := &sources[i]
+					if now.Sub(src.LastSyncAt) < time.Duration(src.IntervalMinutes)*time.Minute {
+						continue
+					}
+					go func() {
+						synced, err := syncSource(ctx, src)
+						if errors.Is(err, errSyncRunning) {
+							return
+						}
+						if err != nil {
+							log.Printf("Sync of source %s failed: %v", src.Name, err)
+						}
+						recordSync(ctx, src.Name, synced, err)
+					}()
+				}
+			}
+		}
+	}()
+}
+
+func registerSourceEndpoints(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID:   "create-source",
+		Method:        http.MethodPost,
+		Path:          "/sources",
+		Summary:       "Create a source",
+		Description:   "Define an external source (S3 bucket, sitemap or Git repository) that is periodically pulled into a bucket and collection",
+		DefaultStatus: http.StatusCreated,
+	}, func(ctx context.Context, input *struct {
+		Body SourceCreateRequest
+	}) (*struct {
+		Body Source
+	}, error) {
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+		if err := input.Body.validate(); err != nil {
+			return nil, err
+		}
+
+		sourcesMu.Lock()
+		defer sourcesMu.Unlock()
+
+		if err := ensureBucket(ctx, config.MinIO.SystemBucket); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to create system bucket", err)
+		}
+		name := input.Body.Name
+		if _, err := client.StatObject(ctx, config.MinIO.SystemBucket, sourceKey(name), minio.StatObjectOptions{}); err == nil {
+			return nil, huma.Error409Conflict(fmt.Sprintf("Source %s already exists", name))
+		} else if !isNotFound(err) {
+			return nil, huma.Error500InternalServerError("Failed to check source existence", err)
+		}
+
+		src := Source{Name: name, SourceRequest: input.Body.SourceRequest, CreatedAt: time.Now().UTC()}
+		if err := saveSource(ctx, &src); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to save source", err)
+		}
+
+		return &struct {
+			Body Source
+		}{
+			Body: src.redacted(),
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "update-source",
+		Method:      http.MethodPut,
+		Path:        "/sources/{source}",
+		Summary:     "Update a source",
+		Description: "Replace the settings of an existing source. Omitting the S3 secret key keeps the stored one",
+	}, func(ctx context.Context, input *struct {
+		Source string `path:"source" doc:"Source name"`
+		Body   SourceRequest
+	}) (*struct {
+		Body Source
+	}, error) {
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+		if err := input.Body.validate(); err != nil {
+			return nil, err
+		}
+
+		sourcesMu.Lock()
+		defer sourcesMu.Unlock()
+
+		src, err := loadSource(ctx, input.Source)
+		if err != nil {
+			return nil, err
+		}
+		if input.Body.S3 != nil && input.Body.S3.SecretKey == "" && src.S3 != nil {
+			input.Body.S3.SecretKey = src.S3.SecretKey
+		}
+		src.SourceRequest = input.Body
+		if err := saveSource(ctx, src); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to save source", err)
+		}
+
+		return &struct {
+			Body Source
+		}{
+			Body: src.redacted(),
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "list-sources",
+		Method:      http.MethodGet,
+		Path:        "/sources",
+		Summary:     "List sources",
+		Description: "List all external sources and the outcome of their last sync",
+	}, func(ctx context.Context, input *struct{}) (*struct {
+		Body SourceListResponse
+	}, error) {
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+
+		sources, err := listSources(ctx)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to list sources", err)
+		}
+		for i := range sources {
+			sources[i] = sources[i].redacted()
+		}
+
+		return &struct {
+			Body SourceListResponse
+		}{
+			Body: SourceListResponse{Sources: sources},
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-source",
+		Method:      http.MethodGet,
+		Path:        "/sources/{source}",
+		Summary:     "Get a source",
+		Description: "Get an external source and the outcome of its last sync",
+	}, func(ctx context.Context, input *struct {
+		Source string `path:"source" doc:"Source name"`
+	}) (*struct {
+		Body Source
+	}, error) {
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+
+		src, err := loadSource(ctx, input.Source)
+		if err != nil {
+			return nil, err
+		}
+
+		return &struct {
+			Body Source
+		}{
+			Body: src.redacted(),
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID:   "delete-source",
+		Method:        http.MethodDelete,
+		Path:          "/sources/{source}",
+		Summary:       "Delete a source",
+		Description:   "Stop syncing an external source. Documents already pulled are kept",
+		DefaultStatus: http.StatusNoContent,
+	}, func(ctx context.Context, input *struct {
+		Source string `path:"source" doc:"Source name"`
+	}) (*struct{}, error) {
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+
+		sourcesMu.Lock()
+		defer sourcesMu.Unlock()
+
+		if _, err := loadSource(ctx, input.Source); err != nil {
+			return nil, err
+		}
+		if err := client.RemoveObject(ctx, config.MinIO.SystemBucket, sourceKey(input.Source), minio.RemoveObjectOptions{}); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to delete source", err)
+		}
+		return nil, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "sync-source",
+		Method:      http.MethodPost,
+		Path:        "/sources/{source}/sync",
+		Summary:     "Sync a source now",
+		Description: "Pull an external source immediately instead of waiting for its next scheduled sync",
+	}, func(ctx context.Context, input *struct {
+		Source string `path:"source" doc:"Source name"`
+	}) (*struct {
+		Body SourceSyncResponse
+	}, error) {
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+
+		src, err := loadSource(ctx, input.Source)
+		if err != nil {
+			return nil, err
+		}
+
+		synced, err := syncSource(ctx, src)
+		if errors.Is(err, errSyncRunning) {
+			return nil, huma.Error409Conflict(fmt.Sprintf("Source %s is already syncing", src.Name))
+		}
+		recordSync(ctx, src.Name, synced, err)
+		if err != nil {
+			return nil, huma.Error502BadGateway(fmt.Sprintf("Sync of source %s failed", src.Name), err)
+		}
+
+		return &struct {
+			Body SourceSyncResponse
+		}{
+			Body: SourceSyncResponse{Synced: synced},
+		}, nil
+	})
+}
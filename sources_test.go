@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestSitemapKey(t *testing.T) {
+	cases := map[string]string{
+		"https://docs.example.com/":              "docs.example.com/index.html",
+		"https://docs.example.com":               "docs.example.com/index.html",
+		"https://docs.example.com/guide/":        "docs.example.com/guide/index.html",
+		"https://docs.example.com/guide/install": "docs.example.com/guide/install",
+	}
+	for in, want := range cases {
+		got, err := sitemapKey(in)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", in, err)
+		}
+		if got != want {
+			t.Errorf("sitemapKey(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSourceRequestValidate(t *testing.T) {
+	valid := []SourceRequest{
+		{Type: "s3", Bucket: "docs", S3: &S3SourceConfig{Endpoint: "s3.amazonaws.com", Bucket: "docs"}},
+		{Type: "sitemap", Bucket: "docs", Sitemap: &SitemapSourceConfig{URL: "https://example.com/sitemap.xml"}},
+		{Type: "git", Bucket: "docs", Git: &GitSourceConfig{URL: "https://github.com/example/docs.git"}},
+	}
+	for _, r := range valid {
+		if err := r.validate(); err != nil {
+			t.Errorf("Expected %s source to be valid, got %v", r.Type, err)
+		}
+	}
+
+	invalid := []SourceRequest{
+		{Type: "s3"},
+		{Type: "sitemap", Sitemap: &SitemapSourceConfig{}},
+		{Type: "git"},
+	}
+	for _, r := range invalid {
+		if err := r.validate(); err == nil {
+			t.Errorf("Expected %s source without settings to be invalid", r.Type)
+		}
+	}
+
+	config.MinIO.SystemBucket = "app-system"
+	system := SourceRequest{Type: "git", Bucket: "app-system", Git: &GitSourceConfig{URL: "https://github.com/example/docs.git"}}
+	if err := system.validate(); err == nil {
+		t.Error("Expected sources to be refused the system bucket")
+	}
+
+	// Secret keys can only be stored encrypted
+	t.Setenv("APP_CONFIG_KEY", "")
+	t.Setenv("APP_CONFIG_KEY_FILE", "")
+	withSecret := SourceRequest{Type: "s3", Bucket: "docs", S3: &S3SourceConfig{Endpoint: "s3.amazonaws.com", Bucket: "docs", SecretKey: "secret"}}
+	if err := withSecret.validate(); err == nil {
+		t.Error("Expected a secret key to need the config key")
+	}
+}
+
+func TestSourceSecretKeyEncrypted(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	t.Setenv("APP_CONFIG_KEY", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	objects := map[string]string{}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	ctx := context.Background()
+	src := &Source{Name: "bucket", SourceRequest: SourceRequest{Type: "s3", Bucket: "docs", S3: &S3SourceConfig{Endpoint: "s3.example.com", Bucket: "in", SecretKey: "s3-secret"}}}
+	if err := saveSource(ctx, src); err != nil {
+		t.Fatal(err)
+	}
+	stored := objects["app-system/sources/bucket.json"]
+	if stored == "" || strings.Contains(stored, "s3-secret") || !strings.Contains(stored, encryptedPrefix) {
+		t.Errorf("Expected the secret key to be stored encrypted, got %s", stored)
+	}
+	if src.S3.SecretKey != "s3-secret" {
+		t.Error("Expected the saved source to keep its plaintext key")
+	}
+	loaded, err := loadSource(ctx, "bucket")
+	if err != nil || loaded.S3.SecretKey != "s3-secret" {
+		t.Errorf("Expected the secret key to be decrypted, got %+v (%v)", loaded.S3, err)
+	}
+
+	// Keys stored before encryption still load
+	objects["app-system/sources/old.json"] = `{"name": "old", "type": "s3", "bucket": "docs", "s3": {"endpoint": "s3.example.com", "bucket": "in", "secret_key": "plain"}}`
+	if loaded, err := loadSource(ctx, "old"); err != nil || loaded.S3.SecretKey != "plain" {
+		t.Errorf("Expected a plaintext key to be used as is, got %+v (%v)", loaded, err)
+	}
+}
+
+func TestSourceRedacted(t *testing.T) {
+	src := Source{SourceRequest: SourceRequest{Type: "s3", S3: &S3SourceConfig{SecretKey: "secret"}}}
+
+	if got := src.redacted(); got.S3.SecretKey != "" {
+		t.Error("Expected secret key to be removed")
+	}
+	if src.S3.SecretKey != "secret" {
+		t.Error("Expected original source to keep its secret key")
+	}
+}
+
+func TestSourceEndpointsWithoutClient(t *testing.T) {
+	// Initialize config for testing
+	viper.Reset()
+	initConfig()
+
+	// Ensure MinIO client is not initialized
+	services.SetMinIO(nil)
+
+	// Create test router and API
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+
+	// Register source endpoints
+	registerSourceEndpoints(api)
+
+	createBody, _ := json.Marshal(SourceCreateRequest{
+		Name: "docs-site",
+		SourceRequest: SourceRequest{
+			Type:            "sitemap",
+			Bucket:          "docs",
+			IntervalMinutes: 60,
+			Sitemap:         &SitemapSourceConfig{URL: "https://example.com/sitemap.xml"},
+		},
+	})
+	requests := []struct {
+		method string
+		path   string
+		body   []byte
+	}{
+		{"POST", "/sources", createBody},
+		{"GET", "/sources", nil},
+		{"GET", "/sources/docs-site", nil},
+		{"DELETE", "/sources/docs-site", nil},
+		{"POST", "/sources/docs-site/sync", nil},
+	}
+
+	for _, r := range requests {
+		req := httptest.NewRequest(r.method, r.path, bytes.NewBuffer(r.body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		// Should return 503 Service Unavailable since MinIO client is not configured
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s %s: expected status code 503, got %d: %s", r.method, r.path, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// minCredentialSeconds is the shortest lifetime minio-go requests from STS.
+const minCredentialSeconds = 3600
+
+// StorageConfig configures direct storage access for trusted clients.
+type StorageConfig struct {
+	Tenants              []StorageTenantConfig `mapstructure:"tenants" doc:"Tenants that may request temporary MinIO credentials"`
+	MaxCredentialSeconds int                   `mapstructure:"max_credential_seconds" doc:"Longest lifetime of issued credentials"`
+}
+
+type StorageTenantConfig struct {
+	Name     string `mapstructure:"name" doc:"Tenant name"`
+	Token    string `mapstructure:"token" doc:"Bearer token the tenant authenticates with"`
+	Bucket   string `mapstructure:"bucket" doc:"Bucket holding the tenant's objects"`
+	Prefix   string `mapstructure:"prefix" doc:"Object prefix the tenant is confined to; defaults to the tenant name followed by /"`
+	ReadOnly bool   `mapstructure:"read_only" doc:"Only issue read-only credentials"`
+}
+
+func (c StorageConfig) Validate() error {
+	if c.MaxCredentialSeconds < minCredentialSeconds || c.MaxCredentialSeconds > 43200 {
+		return fmt.Errorf("storage.max_credential_seconds must be between %d and 43200", minCredentialSeconds)
+	}
+	names := map[string]bool{}
+	for _, t := range c.Tenants {
+		if t.Name == "" || t.Token == "" || t.Bucket == "" {
+			return errors.New("storage.tenants: every tenant needs a name, a token and a bucket")
+		}
+		if names[t.Name] {
+			return fmt.Errorf("storage.tenants: duplicate tenant %q", t.Name)
+		}
+		names[t.Name] = true
+	}
+	return nil
+}
+
+// prefix returns the tenant's object prefix, ending in a slash.
+func (t StorageTenantConfig) prefix() string {
+	p := t.Prefix
+	if p == "" {
+		p = t.Name
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(p, "/"), "/") + "/"
+}
+
+type StorageCredentialsRequest struct {
+	Prefix          string `json:"prefix,omitempty" doc:"Narrow the credentials to this folder inside the tenant's prefix"`
+	ReadOnly        bool   `json:"read_only,omitempty" doc:"Issue credentials that can only list and read"`
+	DurationSeconds int    `json:"duration_seconds,omitempty" minimum:"3600" doc:"Lifetime of the credentials; defaults to one hour"`
+}
+
+type StorageCredentialsResponse struct {
+	AccessKey    string    `json:"access_key" doc:"Temporary access key"`
+	SecretKey    string    `json:"secret_key" doc:"Temporary secret key"`
+	SessionToken string    `json:"session_token" doc:"Session token to send with every request"`
+	Expiration   time.Time `json:"expiration" doc:"When the credentials stop working"`
+	Endpoint     string    `json:"endpoint" doc:"MinIO endpoint to use the credentials with"`
+	Bucket       string    `json:"bucket" doc:"Bucket the credentials are valid for"`
+	Prefix       string    `json:"prefix" doc:"Object prefix the credentials are confined to"`
+	ReadOnly     bool      `json:"read_only" doc:"Whether the credentials can only read"`
+}
+
+// storageTenant returns the tenant whose token is in the Authorization
+// header.
+func storageTenant(authorization string) (StorageTenantConfig, bool) {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || token == "" {
+		return StorageTenantConfig{}, false
+	}
+	for _, t := range config.Storage.Tenants {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+			return t, true
+		}
+	}
+	return StorageTenantConfig{}, false
+}
+
+// prefixPolicy returns an IAM policy that confines access to prefix in
+// bucket. Read-only policies can list and download, others can also
+// upload and delete.
+func prefixPolicy(bucket, prefix string, readOnly bool) string {
+	actions := []string{"s3:GetObject"}
+	if !readOnly {
+		actions = append(actions, "s3:PutObject", "s3:DeleteObject", "s3:AbortMultipartUpload", "s3:ListMultipartUploadParts")
+	}
+	policy := map[string]any{
+		"Version": "2012-10-17",
+		"Statement": []map[string]any{
+			{
+				"Effect":    "Allow",
+				"Action":    []string{"s3:ListBucket"},
+				"Resource":  []string{"arn:aws:s3:::" + bucket},
+				"Condition": map[string]any{"StringLike": map[string]any{"s3:prefix": []string{prefix + "*"}}},
+			},
+			{
+				"Effect":   "Allow",
+				"Action":   actions,
+				"Resource": []string{"arn:aws:s3:::" + bucket + "/" + prefix + "*"},
+			},
+		},
+	}
+	b, _ := json.Marshal(policy)
+	return string(b)
+}
+
+func registerStorageCredentialsEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "issue-storage-credentials",
+		Method:      http.MethodPost,
+		Path:        "/storage/credentials",
+		Summary:     "Issue temporary storage credentials",
+		Description: "Issue temporary MinIO credentials confined to the calling tenant's prefix, for use with native S3 SDKs",
+	}, func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer tenant token"`
+		Body          StorageCredentialsRequest
+	}) (*struct {
+		Body StorageCredentialsResponse
+	}, error) {
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+		tenant, ok := storageTenant(input.Authorization)
+		if !ok {
+			return nil, huma.Error401Unauthorized("Invalid or missing tenant token")
+		}
+
+		prefix := tenant.prefix()
+		if sub := strings.Trim(input.Body.Prefix, "/"); sub != "" {
+			if cleaned := path.Clean(sub); cleaned != sub || strings.HasPrefix(cleaned, "..") {
+				return nil, huma.Error422UnprocessableEntity("Prefix must be a plain relative path")
+			}
+			prefix += sub + "/"
+		}
+		readOnly := tenant.ReadOnly || input.Body.ReadOnly
+
+		duration := input.Body.DurationSeconds
+		if duration == 0 {
+			duration = minCredentialSeconds
+		}
+		if duration > config.Storage.MaxCredentialSeconds {
+			return nil, huma.Error422UnprocessableEntity(fmt.Sprintf("duration_seconds must not exceed %d", config.Storage.MaxCredentialSeconds))
+		}
+
+		_, key, secret := secrets.get()
+		creds, err := credentials.NewSTSAssumeRole(minioEndpointURL(config.MinIO.URL), credentials.STSAssumeRoleOptions{
+			AccessKey:       key,
+			SecretKey:       secret,
+			Policy:          prefixPolicy(tenant.Bucket, prefix, readOnly),
+			DurationSeconds: duration,
+		})
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to request credentials", err)
+		}
+		issued := time.Now()
+		v, err := creds.Get()
+		if err != nil {
+			return nil, huma.Error502BadGateway("MinIO refused to issue credentials", err)
+		}
+
+		return &struct {
+			Body StorageCredentialsResponse
+		}{
+			Body: StorageCredentialsResponse{
+				AccessKey:    v.AccessKeyID,
+				SecretKey:    v.SecretAccessKey,
+				SessionToken: v.SessionToken,
+				Expiration:   issued.Add(time.Duration(duration) * time.Second).UTC(),
+				Endpoint:     config.MinIO.URL,
+				Bucket:       tenant.Bucket,
+				Prefix:       prefix,
+				ReadOnly:     readOnly,
+			},
+		}, nil
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestPrefixPolicy(t *testing.T) {
+	var policy struct {
+		Statement []struct {
+			Action    []string
+			Resource  []string
+			Condition map[string]map[string][]string
+		}
+	}
+	if err := json.Unmarshal([]byte(prefixPolicy("docs", "acme/reports/", true)), &policy); err != nil {
+		t.Fatal(err)
+	}
+	list, objects := policy.Statement[0], policy.Statement[1]
+	if list.Resource[0] != "arn:aws:s3:::docs" || list.Condition["StringLike"]["s3:prefix"][0] != "acme/reports/*" {
+		t.Errorf("Unexpected list statement: %+v", list)
+	}
+	if objects.Resource[0] != "arn:aws:s3:::docs/acme/reports/*" || len(objects.Action) != 1 {
+		t.Errorf("Expected read-only access to the prefix, got %+v", objects)
+	}
+}
+
+func TestMinIOEndpointURL(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	if got := minioEndpointURL("minio:9000"); got != "http://minio:9000" {
+		t.Errorf("Expected an http URL by default, got %s", got)
+	}
+	config.MinIO.UseSSL = true
+	if got := minioEndpointURL("minio:9000"); got != "https://minio:9000" {
+		t.Errorf("Expected an https URL with use_ssl, got %s", got)
+	}
+}
+
+func TestStorageCredentialsEndpoint(t *testing.T) {
+	viper.Reset()
+	initConfig()
+
+	var policy string
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		policy = r.PostForm.Get("Policy")
+		w.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult><Credentials>
+<AccessKeyId>TEMPKEY</AccessKeyId><SecretAccessKey>tempsecret</SecretAccessKey><SessionToken>token</SessionToken>
+<Expiration>2030-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`))
+	}))
+	defer sts.Close()
+
+	config.MinIO.URL = strings.TrimPrefix(sts.URL, "http://")
+	config.Storage.Tenants = []StorageTenantConfig{{Name: "acme", Token: "acme-token", Bucket: "tenants"}}
+	secrets.set("", "access", "secret")
+	defer secrets.set("", "", "")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerStorageCredentialsEndpoint(api)
+
+	request := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/storage/credentials", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := request("wrong", `{}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unknown tenant, got %d", w.Code)
+	}
+	if w := request("acme-token", `{"prefix": "../other"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a prefix escaping the tenant, got %d", w.Code)
+	}
+
+	w := request("acme-token", `{"prefix": "reports", "duration_seconds": 7200}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resp StorageCredentialsResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.AccessKey != "TEMPKEY" || resp.SessionToken != "token" || resp.Bucket != "tenants" || resp.Prefix != "acme/reports/" || resp.ReadOnly {
+		t.Errorf("Unexpected credentials: %+v", resp)
+	}
+	if policy != prefixPolicy("tenants", "acme/reports/", false) {
+		t.Errorf("Expected the credentials to be scoped to the prefix, got policy %s", policy)
+	}
+}
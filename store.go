@@ -0,0 +1,327 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// StoreLimits bounds an in-memory store. Whichever limit is reached first
+// evicts the least recently used entries.
+type StoreLimits struct {
+	MaxEntries int   `mapstructure:"max_entries" doc:"Most entries the store keeps"`
+	MaxBytes   int64 `mapstructure:"max_bytes" doc:"Most bytes of values the store keeps"`
+}
+
+func (l StoreLimits) validate(name string) error {
+	if l.MaxEntries <= 0 || l.MaxBytes <= 0 {
+		return fmt.Errorf("%s.max_entries and %s.max_bytes must be positive", name, name)
+	}
+	return nil
+}
+
+// StoresConfig bounds the in-memory stores that are not configured in a
+// section of their own.
+type StoresConfig struct {
+	LinkResults          StoreLimits `mapstructure:"link_results" doc:"Broken links remembered per published site"`
+	TranscriptEmbeddings StoreLimits `mapstructure:"transcript_embeddings" doc:"Embeddings of transcript exchanges kept for semantic conversation search"`
+	DocumentEmbeddings   StoreLimits `mapstructure:"document_embeddings" doc:"Embeddings of document chunks kept for POST /chat/with-files"`
+}
+
+func (c StoresConfig) Validate() error {
+	return errors.Join(
+		c.LinkResults.validate("stores.link_results"),
+		c.TranscriptEmbeddings.validate("stores.transcript_embeddings"),
+		c.DocumentEmbeddings.validate("stores.document_embeddings"),
+	)
+}
+
+// StoreStats are the counters of one in-memory store.
+type StoreStats struct {
+	Name       string
+	Entries    int
+	Bytes      int64
+	MaxEntries int
+	MaxBytes   int64
+	Hits       uint64
+	Misses     uint64
+	Evictions  uint64
+	Expired    uint64
+}
+
+// lruStore is a map bounded by entry count and value size that evicts the
+// least recently used entries. Entries may expire.
+type lruStore[V any] struct {
+	mu     sync.Mutex
+	limits StoreLimits
+	size   func(V) int64
+	order  *list.List
+	items  map[string]*list.Element
+	stats  StoreStats
+	// onRemove, if set, is called with every value that leaves the store
+	onRemove func(V)
+}
+
+type lruEntry[V any] struct {
+	key     string
+	value   V
+	size    int64
+	expires time.Time
+}
+
+// newLRUStore creates a store and registers it for GET /metrics. size
+// reports the bytes a value holds.
+func newLRUStore[V any](name string, limits StoreLimits, size func(V) int64) *lruStore[V] {
+	s := &lruStore[V]{
+		limits: limits,
+		size:   size,
+		order:  list.New(),
+		items:  map[string]*list.Element{},
+		stats:  StoreStats{Name: name, MaxEntries: limits.MaxEntries, MaxBytes: limits.MaxBytes},
+	}
+	registerStore(name, s.snapshot)
+	return s
+}
+
+func (s *lruStore[V]) get(key string) (V, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var zero V
+	el, ok := s.items[key]
+	if !ok {
+		s.stats.Misses++
+		return zero, false
+	}
+	e := el.Value.(*lruEntry[V])
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		s.remove(el)
+		s.stats.Expired++
+		s.stats.Misses++
+		return zero, false
+	}
+	s.order.MoveToFront(el)
+	s.stats.Hits++
+	return e.value, true
+}
+
+// set stores a value; a ttl of zero keeps it until it is evicted. A value
+// larger than the whole store is not stored.
+func (s *lruStore[V]) set(key string, value V, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+	e := &lruEntry[V]{key: key, value: value, size: s.size(value)}
+	if e.size > s.limits.MaxBytes {
+		return
+	}
+	if ttl != 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	s.items[key] = s.order.PushFront(e)
+	s.stats.Entries++
+	s.stats.Bytes += e.size
+	for s.stats.Entries > s.limits.MaxEntries || s.stats.Bytes > s.limits.MaxBytes {
+		s.remove(s.order.Back())
+		s.stats.Evictions++
+	}
+}
+
+// add stores a value like set, but never evicts an entry that has not
+// expired: when the value does not fit once expired entries are dropped,
+// it is not stored and add returns false.
+func (s *lruStore[V]) add(key string, value V, ttl time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+	e := &lruEntry[V]{key: key, value: value, size: s.size(value)}
+	fits := func() bool {
+		return s.stats.Entries < s.limits.MaxEntries && s.stats.Bytes+e.size <= s.limits.MaxBytes
+	}
+	if !fits() {
+		now := time.Now()
+		for el := s.order.Back(); el != nil; {
+			prev := el.Prev()
+			if x := el.Value.(*lruEntry[V]); !x.expires.IsZero() && now.After(x.expires) {
+				s.remove(el)
+				s.stats.Expired++
+			}
+			el = prev
+		}
+		if !fits() {
+			return false
+		}
+	}
+	if ttl != 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	s.items[key] = s.order.PushFront(e)
+	s.stats.Entries++
+	s.stats.Bytes += e.size
+	return true
+}
+
+func (s *lruStore[V]) delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+}
+
+// clear removes every entry.
+func (s *lruStore[V]) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for el := s.order.Front(); el != nil; el = s.order.Front() {
+		s.remove(el)
+	}
+}
+
+// each calls fn for every entry that has not expired, most recently used
+// first, without counting as a use.
+func (s *lruStore[V]) each(fn func(key string, value V)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for el := s.order.Front(); el != nil; el = el.Next() {
+		if e := el.Value.(*lruEntry[V]); e.expires.IsZero() || now.Before(e.expires) {
+			fn(e.key, e.value)
+		}
+	}
+}
+
+func (s *lruStore[V]) remove(el *list.Element) {
+	e := s.order.Remove(el).(*lruEntry[V])
+	delete(s.items, e.key)
+	s.stats.Entries--
+	s.stats.Bytes -= e.size
+	if s.onRemove != nil {
+		s.onRemove(e.value)
+	}
+}
+
+func (s *lruStore[V]) snapshot() StoreStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// storeRegistry holds the stats of every store, sorted by name. A store
+// created again under the same name, e.g. by tests, replaces the earlier
+// one.
+var storeRegistry struct {
+	sync.Mutex
+	names []string
+	stats []func() StoreStats
+}
+
+func registerStore(name string, stats func() StoreStats) {
+	storeRegistry.Lock()
+	defer storeRegistry.Unlock()
+	i := sort.SearchStrings(storeRegistry.names, name)
+	if i < len(storeRegistry.names) && storeRegistry.names[i] == name {
+		storeRegistry.stats[i] = stats
+		return
+	}
+	storeRegistry.names = slices.Insert(storeRegistry.names, i, name)
+	storeRegistry.stats = slices.Insert(storeRegistry.stats, i, stats)
+}
+
+// appendStoreStats appends a snapshot of every store to dst.
+func appendStoreStats(dst []StoreStats) []StoreStats {
+	storeRegistry.Lock()
+	defer storeRegistry.Unlock()
+	for _, fn := range storeRegistry.stats {
+		dst = append(dst, fn())
+	}
+	return dst
+}
+
+// storeMetrics are the metrics reported for every store.
+var storeMetrics = []struct {
+	name, help, kind string
+	value            func(StoreStats) uint64
+}{
+	{"app_store_entries", "Entries held by the in-memory store", "gauge", func(s StoreStats) uint64 { return uint64(s.Entries) }},
+	{"app_store_bytes", "Bytes of values held by the in-memory store", "gauge", func(s StoreStats) uint64 { return uint64(s.Bytes) }},
+	{"app_store_max_entries", "Configured entry limit of the in-memory store", "gauge", func(s StoreStats) uint64 { return uint64(s.MaxEntries) }},
+	{"app_store_max_bytes", "Configured byte limit of the in-memory store", "gauge", func(s StoreStats) uint64 { return uint64(s.MaxBytes) }},
+	{"app_store_hits_total", "Lookups that found an entry", "counter", func(s StoreStats) uint64 { return s.Hits }},
+	{"app_store_misses_total", "Lookups that found no entry", "counter", func(s StoreStats) uint64 { return s.Misses }},
+	{"app_store_evictions_total", "Entries evicted to stay within the limits", "counter", func(s StoreStats) uint64 { return s.Evictions }},
+	{"app_store_expired_total", "Entries dropped because they expired", "counter", func(s StoreStats) uint64 { return s.Expired }},
+}
+
+// appendStoreMetrics appends the store stats in the Prometheus text format
+// to dst. Store names are identifiers and need no escaping.
+func appendStoreMetrics(dst []byte, stats []StoreStats) []byte {
+	for _, m := range storeMetrics {
+		dst = append(dst, "# HELP "...)
+		dst = append(dst, m.name...)
+		dst = append(dst, ' ')
+		dst = append(dst, m.help...)
+		dst = append(dst, "\n# TYPE "...)
+		dst = append(dst, m.name...)
+		dst = append(dst, ' ')
+		dst = append(dst, m.kind...)
+		dst = append(dst, '\n')
+		for _, s := range stats {
+			dst = append(dst, m.name...)
+			dst = append(dst, `{store="`...)
+			dst = append(dst, s.Name...)
+			dst = append(dst, `"} `...)
+			dst = strconv.AppendUint(dst, m.value(s), 10)
+			dst = append(dst, '\n')
+		}
+	}
+	return dst
+}
+
+// metricsBuffer is reused between scrapes so they generate no garbage.
+type metricsBuffer struct {
+	stats []StoreStats
+	out   []byte
+}
+
+var metricsBuffers = sync.Pool{New: func() any { return new(metricsBuffer) }}
+
+func registerMetricsEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "metrics",
+		Method:      http.MethodGet,
+		Path:        "/metrics",
+		Summary:     "Prometheus metrics",
+		Description: "Size, limits, hit rate and evictions of the in-memory stores in the Prometheus text format",
+		Responses: map[string]*huma.Response{
+			"200": {Description: "Metrics", Content: map[string]*huma.MediaType{"text/plain": {}}},
+		},
+	}, func(ctx context.Context, input *struct{}) (*huma.StreamResponse, error) {
+		return metricsResponse, nil
+	})
+}
+
+// metricsResponse writes the metrics from a pooled buffer; it is shared by
+// every request since it holds no state.
+var metricsResponse = &huma.StreamResponse{
+	Body: func(ctx huma.Context) {
+		buf := metricsBuffers.Get().(*metricsBuffer)
+		buf.stats = appendStoreStats(buf.stats[:0])
+		buf.out = appendStoreMetrics(buf.out[:0], buf.stats)
+		ctx.SetHeader("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		ctx.BodyWriter().Write(buf.out)
+		metricsBuffers.Put(buf)
+	},
+}
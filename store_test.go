@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestLRUStore(t *testing.T) {
+	s := newLRUStore("test", StoreLimits{MaxEntries: 3, MaxBytes: 10}, func(v string) int64 { return int64(len(v)) })
+	s.set("a", "aaaa", 0)
+	s.set("b", "bbbb", 0)
+	s.get("a")
+	s.set("c", "cccc", 0)
+
+	if _, ok := s.get("b"); ok {
+		t.Error("Expected the least recently used entry to be evicted when the byte limit is reached")
+	}
+	if v, ok := s.get("a"); !ok || v != "aaaa" {
+		t.Error("Expected a recently used entry to be kept")
+	}
+
+	s.set("big", strings.Repeat("x", 11), 0)
+	if _, ok := s.get("big"); ok {
+		t.Error("Expected a value larger than the store not to be stored")
+	}
+
+	s.set("d", "d", 0)
+	s.set("e", "e", 0)
+	s.set("f", "f", -time.Second)
+	if _, ok := s.get("f"); ok {
+		t.Error("Expected an expired entry to be dropped")
+	}
+
+	stats := s.snapshot()
+	if stats.Entries != 2 || stats.Bytes != 2 {
+		t.Errorf("Expected 2 entries of 2 bytes, got %+v", stats)
+	}
+	if stats.Evictions != 3 || stats.Expired != 1 || stats.Hits != 2 || stats.Misses != 3 {
+		t.Errorf("Unexpected counters: %+v", stats)
+	}
+
+	s.clear()
+	if stats := s.snapshot(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("Expected a cleared store to be empty, got %+v", stats)
+	}
+}
+
+func TestLRUStoreAdd(t *testing.T) {
+	s := newLRUStore("add_test", StoreLimits{MaxEntries: 2, MaxBytes: 100}, func(v string) int64 { return int64(len(v)) })
+	if !s.add("a", "a", time.Minute) || !s.add("b", "b", -time.Second) {
+		t.Fatal("Expected entries to be added while there is room")
+	}
+	// b has expired, so it makes room for c
+	if !s.add("c", "c", time.Minute) {
+		t.Error("Expected an expired entry to make room")
+	}
+	if s.add("d", "d", time.Minute) {
+		t.Error("Expected a full store to refuse an entry instead of evicting one")
+	}
+	if _, ok := s.get("a"); !ok {
+		t.Error("Expected entries that have not expired to be kept")
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	s := newLRUStore("metrics_test", StoreLimits{MaxEntries: 1, MaxBytes: 100}, func(v string) int64 { return int64(len(v)) })
+	s.set("a", "one", 0)
+	s.set("b", "two", 0)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerMetricsEndpoint(api)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("Expected the Prometheus text format, got %s", resp.Header.Get("Content-Type"))
+	}
+	for _, want := range []string{
+		"# TYPE app_store_evictions_total counter",
+		`app_store_evictions_total{store="metrics_test"} 1`,
+		`app_store_bytes{store="metrics_test"} 3`,
+		`app_store_max_entries{store="metrics_test"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Expected %q in the metrics, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestAppendStoreMetricsDoesNotAllocate(t *testing.T) {
+	stats := []StoreStats{{Name: "a", Entries: 3, Hits: 12}, {Name: "b", MaxBytes: 1 << 20}}
+	buf := appendStoreMetrics(nil, stats)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf = appendStoreMetrics(buf[:0], stats)
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations when the buffer is reused, got %v", allocs)
+	}
+}
+
+func BenchmarkMetricsEndpoint(b *testing.B) {
+	router := chi.NewMux()
+	registerMetricsEndpoint(humachi.New(router, huma.DefaultConfig("Test API", "1.0.0")))
+	b.ReportAllocs()
+	for b.Loop() {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/sashabaranov/go-openai"
+)
+
+type ChatStreamToken struct {
+	Content string `json:"content" doc:"Next piece of the reply"`
+}
+
+type ChatStreamDone struct {
+	FinishReason string              `json:"finish_reason" doc:"Why the model stopped, e.g. stop or length"`
+	Filter       *OutputFilterResult `json:"filter,omitempty" doc:"Decisions of the output filter, when enabled"`
+}
+
+type ChatStreamError struct {
+	Message string `json:"message" doc:"What went wrong after the stream started"`
+}
+
+// eventStream writes server-sent events and flushes each one, so tokens
+// reach the client as they are generated instead of sitting in a buffer.
+type eventStream struct {
+	w  io.Writer
+	rc *http.ResponseController
+	// writeTimeout bounds each write, so a client that stops reading is
+	// disconnected instead of blocking the stream; 0 waits forever
+	writeTimeout time.Duration
+}
+
+// newEventStream starts an event stream on ctx. Besides disabling caching,
+// it asks proxies such as nginx not to buffer the response.
+func newEventStream(ctx huma.Context) *eventStream {
+	ctx.SetHeader("Content-Type", "text/event-stream")
+	ctx.SetHeader("Cache-Control", "no-cache")
+	ctx.SetHeader("X-Accel-Buffering", "no")
+	s := &eventStream{w: ctx.BodyWriter()}
+	if w, ok := s.w.(http.ResponseWriter); ok {
+		s.rc = http.NewResponseController(w)
+	}
+	// Send the headers right away so clients know the request was accepted
+	s.flush()
+	return s
+}
+
+func (s *eventStream) flush() error {
+	if s.rc == nil {
+		return http.ErrNotSupported
+	}
+	return s.rc.Flush()
+}
+
+// write writes and flushes one event within the write timeout. Writers
+// without deadlines, such as test recorders, write without one.
+func (s *eventStream) write(format string, args ...any) error {
+	if s.rc != nil && s.writeTimeout > 0 {
+		if err := s.rc.SetWriteDeadline(time.Now().Add(s.writeTimeout)); err == nil {
+			defer s.rc.SetWriteDeadline(time.Time{})
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, format, args...); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+// send writes one event and flushes it to the client.
+func (s *eventStream) send(event string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.write("event: %s\ndata: %s\n\n", event, b)
+}
+
+// comment writes an SSE comment, which clients ignore, and flushes it.
+// Comments keep proxies and load balancers from closing a stream that is
+// waiting for the model.
+func (s *eventStream) comment(text string) error {
+	return s.write(": %s\n\n", text)
+}
+
+// StreamsConfig keeps long-running event streams alive, and bounds what
+// a slow client can hold up.
+type StreamsConfig struct {
+	HeartbeatSeconds   int `mapstructure:"heartbeat_seconds" doc:"Seconds between ping comments on event streams; 0 sends none"`
+	IdleTimeoutSeconds int `mapstructure:"idle_timeout_seconds" doc:"Seconds a stream may wait for the next chunk from OpenAI before it is ended; 0 waits as long as the stream's timeout allows"`
+	MaxBufferBytes     int `mapstructure:"max_buffer_bytes" doc:"Most bytes of a reply held for a client that reads slower than the model writes; a client falling further behind is disconnected"`
+	MaxLagSeconds      int `mapstructure:"max_lag_seconds" doc:"Seconds a client may take to read an event before it is disconnected; 0 waits as long as the stream's timeout allows"`
+}
+
+func (c StreamsConfig) Validate() error {
+	if c.HeartbeatSeconds < 0 || c.IdleTimeoutSeconds < 0 || c.MaxLagSeconds < 0 {
+		return errors.New("streams.heartbeat_seconds, streams.idle_timeout_seconds and streams.max_lag_seconds must not be negative")
+	}
+	if c.MaxBufferBytes <= 0 {
+		return errors.New("streams.max_buffer_bytes must be positive")
+	}
+	return nil
+}
+
+// streamBuffer holds what a stream received from OpenAI and has not sent
+// yet. OpenAI is read in the background, so heartbeats go out while the
+// model thinks and a slow client does not hold up the model: deltas that
+// arrive while the client reads are coalesced into one token event.
+type streamBuffer struct {
+	mu       sync.Mutex
+	pending  strings.Builder
+	since    time.Time // when the oldest pending delta arrived
+	chunks   int
+	finish   string
+	err      error
+	overflow bool
+	// ready is signaled whenever something was received
+	ready chan struct{}
+}
+
+// streamBatch is what a stream received since it last took from its
+// buffer.
+type streamBatch struct {
+	text   string
+	chunks int
+	finish string
+	err    error
+	// overflow reports that the client fell too far behind
+	overflow bool
+}
+
+func newStreamBuffer() *streamBuffer {
+	return &streamBuffer{ready: make(chan struct{}, 1)}
+}
+
+// receive reads stream into b until it ends or fails, or until the pending
+// text exceeds maxBytes or is older than maxLag. Then it stops reading, so
+// the caller can close the stream.
+func (b *streamBuffer) receive(stream *openai.ChatCompletionStream, maxBytes int, maxLag time.Duration) {
+	for {
+		resp, err := stream.Recv()
+		b.mu.Lock()
+		if err != nil {
+			b.err = err
+		} else if len(resp.Choices) > 0 {
+			choice := resp.Choices[0]
+			if choice.Delta.Content != "" {
+				if b.pending.Len() == 0 {
+					b.since = time.Now()
+				}
+				b.pending.WriteString(choice.Delta.Content)
+				b.chunks++
+			}
+			if choice.FinishReason != "" {
+				b.finish = string(choice.FinishReason)
+			}
+		}
+		b.overflow = b.pending.Len() > maxBytes || maxLag > 0 && b.pending.Len() > 0 && time.Since(b.since) > maxLag
+		done := b.err != nil || b.overflow
+		b.mu.Unlock()
+
+		select {
+		case b.ready <- struct{}{}:
+		default:
+		}
+		if done {
+			return
+		}
+	}
+}
+
+// take empties the buffer.
+func (b *streamBuffer) take() streamBatch {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	batch := streamBatch{text: b.pending.String(), chunks: b.chunks, finish: b.finish, err: b.err, overflow: b.overflow}
+	b.pending.Reset()
+	b.chunks, b.finish = 0, ""
+	return batch
+}
+
+func registerChatStreamEndpoint(api huma.API) {
+	huma.Register(api, withRoutePolicy(huma.Operation{
+		OperationID: "chat-stream",
+		Method:      http.MethodPost,
+		Path:        "/chat/stream",
+		Summary:     "Stream a reply from OpenAI",
+		Description: "Send a message to OpenAI and receive the reply as server-sent events: a `token` event per piece of the reply, then `done`, or `error` if the stream fails midway.",
+		Responses: map[string]*huma.Response{
+			"200": {
+				Description: "Server-sent events",
+				Content:     map[string]*huma.MediaType{"text/event-stream": {}},
+			},
+		},
+	}, RoutePolicy{RateClass: "chat", Budgeted: true}), func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer tenant token; required when terms.required is set"`
+		Body          ChatRequest
+	}) (*huma.StreamResponse, error) {
+		provider, err := chatProvider(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := requireTermsAccepted(ctx, input.Authorization); err != nil {
+			return nil, err
+		}
+		ctx, requested := rolloutChatModel(ctx, chatModel(input.Body))
+		model, err := policyModel(ctx, requested)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkSystemPrompt(input.Body); err != nil {
+			return nil, err
+		}
+		if err := applyTemplate(ctx, &input.Body); err != nil {
+			return nil, err
+		}
+		if input.Body.N > 1 {
+			return nil, huma.Error422UnprocessableEntity("Streaming supports only one reply; leave out n or set it to 1")
+		}
+		if len(input.Body.Tools) > 0 || len(input.Body.Functions) > 0 {
+			return nil, huma.Error422UnprocessableEntity("Streaming does not support tools or functions; use /chat")
+		}
+		if err := attachImages(ctx, &input.Body, model, objectCaller(input.Authorization)); err != nil {
+			return nil, err
+		}
+		if err := moderateInput(ctx, provider, "chat_stream", input.Body.Message); err != nil {
+			return nil, err
+		}
+
+		messages, err := chatCompletionMessages(ctx, input.Body)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to load style guide", err)
+		}
+
+		// The slot and the timeout last until the stream has ended
+		release, err := chatLimits.admit(chatModeStreaming, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := withChatTimeout(ctx, chatModeStreaming)
+		stream, err := provider.CreateChatCompletionStream(ctx, input.Body.completionRequest(model, messages))
+		if err != nil {
+			observeRollout(ctx, 0, err)
+			logPrompt(ctx, "chat_stream", input.Body, "", err)
+			timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+			cancel()
+			release()
+			if timedOut {
+				return nil, errChatTimeout(chatModeStreaming)
+			}
+			return nil, openAIError(ctx, "Failed to get OpenAI response", err)
+		}
+
+		return &huma.StreamResponse{
+			Body: func(hctx huma.Context) {
+				defer release()
+				defer cancel()
+				defer stream.Close()
+				events := newEventStream(hctx)
+
+				// The API doesn't report usage for streams; each chunk is
+				// about one token.
+				tokens := 0
+				var reply strings.Builder
+				var streamErr error
+				defer func() {
+					recordTokenUsage(ctx, model, openai.Usage{CompletionTokens: tokens, TotalTokens: tokens})
+					// Stream latency depends on the reply's length, so
+					// only its errors count towards the rollout
+					observeRollout(ctx, 0, streamErr)
+					logPrompt(ctx, "chat_stream", input.Body, reply.String(), streamErr)
+				}()
+
+				// The output filter needs the whole reply, so with it
+				// enabled the reply is sent as one token once complete
+				filtering := config.OutputFilter.Enabled
+
+				maxLag := time.Duration(config.Streams.MaxLagSeconds) * time.Second
+				events.writeTimeout = maxLag
+				buf := newStreamBuffer()
+				go buf.receive(stream, config.Streams.MaxBufferBytes, maxLag)
+				// Nil channels never fire, which disables either timer
+				var heartbeat, idle <-chan time.Time
+				if s := config.Streams.HeartbeatSeconds; s > 0 {
+					ticker := time.NewTicker(time.Duration(s) * time.Second)
+					defer ticker.Stop()
+					heartbeat = ticker.C
+				}
+				idleTimeout := time.Duration(config.Streams.IdleTimeoutSeconds) * time.Second
+				var idleTimer *time.Timer
+				if idleTimeout > 0 {
+					idleTimer = time.NewTimer(idleTimeout)
+					defer idleTimer.Stop()
+					idle = idleTimer.C
+				}
+
+				for {
+					select {
+					case <-buf.ready:
+					case <-heartbeat:
+						if err := events.comment("ping"); err != nil {
+							return
+						}
+						continue
+					case <-idle:
+						streamErr = fmt.Errorf("no data from OpenAI for %d seconds", config.Streams.IdleTimeoutSeconds)
+						events.send("error", ChatStreamError{Message: fmt.Sprintf("OpenAI sent nothing for %d seconds", config.Streams.IdleTimeoutSeconds)})
+						return
+					}
+					if idleTimer != nil {
+						idleTimer.Reset(idleTimeout)
+					}
+
+					batch := buf.take()
+					tokens += batch.chunks
+					reply.WriteString(batch.text)
+					if batch.overflow {
+						// Returning cancels the OpenAI request
+						streamErr = fmt.Errorf("client fell behind by more than %d bytes or %d seconds", config.Streams.MaxBufferBytes, config.Streams.MaxLagSeconds)
+						events.send("error", ChatStreamError{Message: "The client read the stream too slowly"})
+						return
+					}
+					if batch.text != "" && !filtering {
+						if err := events.send("token", ChatStreamToken{Content: batch.text}); err != nil {
+							// Client went away or stopped reading
+							if errors.Is(err, os.ErrDeadlineExceeded) {
+								streamErr = fmt.Errorf("client did not read for %d seconds", config.Streams.MaxLagSeconds)
+							}
+							return
+						}
+					}
+					if batch.finish != "" {
+						done := ChatStreamDone{FinishReason: batch.finish}
+						if filtering {
+							text, result, err := filterOutput(ctx, provider, "chat_stream", reply.String())
+							if err != nil {
+								log.Printf("Output filter failed: %v", err)
+								events.send("error", ChatStreamError{Message: "Output filter failed"})
+								return
+							}
+							if result.Action == outputBlock {
+								events.send("error", ChatStreamError{Message: "The generated reply was blocked by the output filter"})
+								return
+							}
+							if text != "" {
+								if err := events.send("token", ChatStreamToken{Content: text}); err != nil {
+									return
+								}
+							}
+							done.Filter = result
+						}
+						events.send("done", done)
+					}
+
+					if errors.Is(batch.err, io.EOF) {
+						return
+					}
+					if batch.err != nil {
+						streamErr = batch.err
+						if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+							events.send("error", ChatStreamError{Message: fmt.Sprintf("The stream took longer than %d seconds", config.ChatLimits.Streaming.TimeoutSeconds)})
+							return
+						}
+						log.Printf("Chat stream failed: %v", batch.err)
+						events.send("error", ChatStreamError{Message: "OpenAI stream failed"})
+						return
+					}
+				}
+			},
+		}, nil
+	})
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestChatStreamOverH2C(t *testing.T) {
+	viper.Reset()
+	initConfig()
+
+	// The fake OpenAI API holds back the rest of the reply until the client
+	// has seen the first token, which only happens if nothing buffers it.
+	firstSeen := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunk := func(content, finish string) {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q},\"finish_reason\":%q}]}\n\n", content, finish)
+			w.(http.Flusher).Flush()
+		}
+		chunk("Hel", "")
+		select {
+		case <-firstSeen:
+		case <-time.After(5 * time.Second):
+			return
+		}
+		chunk("lo", "")
+		chunk("", "stop")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+	services.SetOpenAI(newTestOpenAIClient(upstream.URL))
+	defer services.SetOpenAI(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerChatStreamEndpoint(api)
+
+	listeners, err := openListeners([]ListenerConfig{
+		{Name: "internal", Addr: "127.0.0.1:0", Protocols: []string{"http1", "h2c"}},
+	}, router)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listeners[0].srv.Close()
+	go serveListeners(context.Background(), listeners, 0)
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	resp, err := client.Post("http://"+listeners[0].ln.Addr().String()+"/chat/stream", "application/json", strings.NewReader(`{"message": "Hi"}`))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("Expected HTTP/2, got %s", resp.Proto)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected an event stream, got %s", ct)
+	}
+	if resp.Header.Get("X-Accel-Buffering") != "no" {
+		t.Error("Expected proxy buffering to be disabled")
+	}
+
+	var events []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		events = append(events, line)
+		if len(events) == 1 {
+			close(firstSeen)
+		}
+	}
+	want := []string{`data: {"content":"Hel"}`, `data: {"content":"lo"}`, `data: {"finish_reason":"stop"}`}
+	if strings.Join(events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected events %v, got %v", want, events)
+	}
+}
+
+func TestChatStreamNoClient(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	services.SetOpenAI(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerChatStreamEndpoint(api)
+
+	req := httptest.NewRequest(http.MethodPost, "/chat/stream", strings.NewReader(`{"message": "Hi"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+}
+
+func TestChatStreamHeartbeatAndIdleTimeout(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Streams.HeartbeatSeconds = 1
+
+	pause := 1500 * time.Millisecond
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunk := func(content, finish string) {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q},\"finish_reason\":%q}]}\n\n", content, finish)
+			w.(http.Flusher).Flush()
+		}
+		chunk("Hel", "")
+		select {
+		case <-time.After(pause):
+		case <-r.Context().Done():
+			return
+		}
+		chunk("lo", "stop")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+	services.SetOpenAI(newTestOpenAIClient(upstream.URL))
+	defer services.SetOpenAI(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerChatStreamEndpoint(api)
+	stream := func() string {
+		req := httptest.NewRequest(http.MethodPost, "/chat/stream", strings.NewReader(`{"message": "Hi"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	if body := stream(); !strings.Contains(body, ": ping\n\n") || !strings.Contains(body, "event: done") {
+		t.Errorf("Expected a ping while the model paused, got %q", body)
+	}
+
+	config.Streams.HeartbeatSeconds = 0
+	config.Streams.IdleTimeoutSeconds = 1
+	body := stream()
+	if strings.Contains(body, ": ping") || strings.Contains(body, "event: done") || !strings.Contains(body, "OpenAI sent nothing for 1 seconds") {
+		t.Errorf("Expected the idle stream to end with an error, got %q", body)
+	}
+}
+
+// streamedReply joins the contents of the token events in an event stream.
+func streamedReply(body string) string {
+	var reply strings.Builder
+	for _, event := range strings.Split(body, "\n\n") {
+		data, ok := strings.CutPrefix(event, "event: token\ndata: ")
+		if !ok {
+			continue
+		}
+		var token ChatStreamToken
+		json.Unmarshal([]byte(data), &token)
+		reply.WriteString(token.Content)
+	}
+	return reply.String()
+}
+
+// slowWriter is a client that takes delay to read every write.
+type slowWriter struct {
+	*httptest.ResponseRecorder
+	delay time.Duration
+}
+
+func (w slowWriter) Write(b []byte) (int, error) {
+	time.Sleep(w.delay)
+	return w.ResponseRecorder.Write(b)
+}
+
+func TestChatStreamSlowReader(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Streams.HeartbeatSeconds = 0
+
+	const words = 200
+	var sent atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := range words {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(time.Millisecond):
+			}
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"w%03d \"},\"finish_reason\":\"\"}]}\n\n", i)
+			w.(http.Flusher).Flush()
+			sent.Add(1)
+		}
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+	services.SetOpenAI(newTestOpenAIClient(upstream.URL))
+	defer services.SetOpenAI(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerChatStreamEndpoint(api)
+	stream := func() string {
+		req := httptest.NewRequest(http.MethodPost, "/chat/stream", strings.NewReader(`{"message": "Hi"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := slowWriter{httptest.NewRecorder(), 20 * time.Millisecond}
+		router.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+	var want strings.Builder
+	for i := range words {
+		fmt.Fprintf(&want, "w%03d ", i)
+	}
+
+	// Tokens arriving while the client reads are sent together, and the
+	// model is read at its own pace
+	body := stream()
+	if streamedReply(body) != want.String() || !strings.Contains(body, "event: done") {
+		t.Fatalf("Expected the whole reply, got %q", body)
+	}
+	if n := strings.Count(body, "event: token"); n >= words/2 {
+		t.Errorf("Expected the tokens of the slow reader to be coalesced, got %d events", n)
+	}
+
+	// A reader falling too far behind is disconnected, which ends the
+	// request to OpenAI
+	config.Streams.MaxBufferBytes = 50
+	sent.Store(0)
+	body = stream()
+	if !strings.Contains(body, "The client read the stream too slowly") || strings.Contains(body, "event: done") {
+		t.Errorf("Expected the slow reader to be disconnected, got %q", body)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := sent.Load(); n == words {
+		t.Error("Expected the OpenAI stream to be closed early")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// maxReplySchemaDepth bounds how deeply a reply schema may nest.
+const maxReplySchemaDepth = 32
+
+type StructuredChatRequest struct {
+	Message     string         `json:"message" minLength:"1" doc:"Message to send to OpenAI"`
+	History     []ChatMessage  `json:"history,omitempty" doc:"Earlier turns of the conversation, oldest first"`
+	Schema      map[string]any `json:"schema" doc:"JSON Schema the reply must match, e.g. {\"type\": \"object\", \"properties\": {\"city\": {\"type\": \"string\"}}, \"required\": [\"city\"]}; $ref is not supported"`
+	Model       string         `json:"model,omitempty" doc:"OpenAI model to use; it must support JSON mode. Defaults to the tenant policy's default model or gpt-3.5-turbo"`
+	Temperature *float32       `json:"temperature,omitempty" minimum:"0" maximum:"2" doc:"Sampling temperature; defaults to OpenAI's default"`
+	MaxTokens   int            `json:"max_tokens,omitempty" minimum:"1" maximum:"128000" doc:"Most tokens the reply may have; defaults to the model's limit"`
+}
+
+type StructuredChatResponse struct {
+	Data   any                 `json:"data" doc:"The reply, parsed; it matches the schema"`
+	Model  string              `json:"model" doc:"Model that generated the reply"`
+	Usage  ChatUsage           `json:"usage" doc:"Tokens billed for the reply"`
+	Cache  string              `json:"cache,omitempty" enum:"hit,miss" doc:"Whether the reply came from the chat cache, if it is enabled"`
+	Filter *OutputFilterResult `json:"filter,omitempty" doc:"Decisions of the output filter, when enabled"`
+}
+
+// parseReplySchema checks a caller's JSON Schema and prepares it for
+// validation. It also returns the schema as JSON for the prompt.
+func parseReplySchema(raw map[string]any) (*huma.Schema, []byte, error) {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, nil, huma.Error422UnprocessableEntity("schema is not valid JSON", err)
+	}
+	// huma's schemas have no field for $ref, which would be dropped
+	// silently
+	if bytes.Contains(data, []byte(`"$ref"`)) {
+		return nil, nil, huma.Error422UnprocessableEntity("schema must not use $ref")
+	}
+	var s huma.Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, nil, huma.Error422UnprocessableEntity("schema is not a supported JSON Schema", err)
+	}
+	if err := compileReplySchema(&s, 0); err != nil {
+		return nil, nil, huma.Error422UnprocessableEntity("schema is not a supported JSON Schema", err)
+	}
+	return &s, data, nil
+}
+
+// compileReplySchema precomputes the validation of s and its subschemas,
+// which huma does one schema at a time.
+func compileReplySchema(s *huma.Schema, depth int) error {
+	if s == nil {
+		return nil
+	}
+	if depth > maxReplySchemaDepth {
+		return fmt.Errorf("schema nests deeper than %d levels", maxReplySchemaDepth)
+	}
+	if s.Pattern != "" {
+		if _, err := regexp.Compile(s.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern %q", s.Pattern)
+		}
+	}
+	if m, ok := s.AdditionalProperties.(map[string]any); ok {
+		data, _ := json.Marshal(m)
+		var addl huma.Schema
+		if err := json.Unmarshal(data, &addl); err != nil {
+			return err
+		}
+		s.AdditionalProperties = &addl
+	}
+
+	subschemas := []*huma.Schema{s.Items, s.Not}
+	subschemas = append(subschemas, s.OneOf...)
+	subschemas = append(subschemas, s.AnyOf...)
+	subschemas = append(subschemas, s.AllOf...)
+	for _, p := range s.Properties {
+		subschemas = append(subschemas, p)
+	}
+	if addl, ok := s.AdditionalProperties.(*huma.Schema); ok {
+		subschemas = append(subschemas, addl)
+	}
+	for _, sub := range subschemas {
+		if err := compileReplySchema(sub, depth+1); err != nil {
+			return err
+		}
+	}
+	s.PrecomputeMessages()
+	return nil
+}
+
+// parseStructuredReply parses a reply and checks it against schema. A
+// watermark the output filter added is not part of the reply's JSON.
+func parseStructuredReply(resp ChatResponse, schema *huma.Schema) (any, error) {
+	reply := resp.Reply
+	if resp.Filter != nil && slices.ContainsFunc(resp.Filter.Decisions, func(d OutputDecision) bool { return d.Action == outputWatermark }) {
+		reply = strings.TrimSuffix(reply, config.OutputFilter.Watermark)
+	}
+	var data any
+	if err := json.Unmarshal([]byte(reply), &data); err != nil {
+		return nil, huma.Error502BadGateway("The model's reply is not JSON", err)
+	}
+	res := &huma.ValidateResult{}
+	huma.Validate(huma.NewMapRegistry("#/components/schemas/", huma.DefaultSchemaNamer), schema, huma.NewPathBuffer([]byte{}, 0), huma.ModeWriteToServer, data, res)
+	if len(res.Errors) > 0 {
+		return nil, huma.Error502BadGateway("The model's reply does not match the schema", res.Errors...)
+	}
+	return data, nil
+}
+
+func registerStructuredChatEndpoint(api huma.API) {
+	huma.Register(api, withRoutePolicy(huma.Operation{
+		OperationID: "chat-structured",
+		Method:      http.MethodPost,
+		Path:        "/chat/structured",
+		Summary:     "Get a reply as JSON matching a schema",
+		Description: "Send a message to OpenAI in JSON mode, check the reply against the given JSON Schema and return it parsed",
+	}, RoutePolicy{RateClass: "chat", Budgeted: true}), func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer tenant token; required when terms.required is set"`
+		Body          StructuredChatRequest
+	}) (*struct {
+		Body StructuredChatResponse
+	}, error) {
+		provider, err := chatProvider(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := requireTermsAccepted(ctx, input.Authorization); err != nil {
+			return nil, err
+		}
+		if input.Body.Schema == nil {
+			return nil, huma.Error422UnprocessableEntity("schema is required")
+		}
+		schema, schemaJSON, err := parseReplySchema(input.Body.Schema)
+		if err != nil {
+			return nil, err
+		}
+		model, err := policyModel(ctx, input.Body.Model)
+		if err != nil {
+			return nil, err
+		}
+
+		req := ChatRequest{
+			Message:     input.Body.Message,
+			History:     input.Body.History,
+			Model:       model,
+			Temperature: input.Body.Temperature,
+			MaxTokens:   input.Body.MaxTokens,
+			replySchema: schemaJSON,
+		}
+		resp, err := completeChat(ctx, provider, "chat_structured", req, model)
+		if err != nil {
+			return nil, err
+		}
+		data, err := parseStructuredReply(resp, schema)
+		if err != nil {
+			return nil, err
+		}
+		return &struct {
+			Body StructuredChatResponse
+		}{Body: StructuredChatResponse{Data: data, Model: resp.Model, Usage: resp.Usage, Cache: resp.Cache, Filter: resp.Filter}}, nil
+	})
+}
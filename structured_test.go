@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+// replyProvider answers every chat with reply and keeps the last request.
+type replyProvider struct {
+	chatOnlyProvider
+	reply string
+	last  openai.ChatCompletionRequest
+}
+
+func (p *replyProvider) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	p.last = req
+	return openai.ChatCompletionResponse{Model: req.Model, Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: p.reply}}}}, nil
+}
+
+func (p *replyProvider) Moderations(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error) {
+	return openai.ModerationResponse{Results: []openai.Result{{}}}, nil
+}
+
+func TestStructuredChat(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	provider := &replyProvider{}
+	config.ChatProvider.Name = "reply"
+	chatProviders["reply"] = func() (ChatProvider, error) { return provider, nil }
+	defer delete(chatProviders, "reply")
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerStructuredChatEndpoint(api)
+	schema := `{"type": "object", "properties": {"city": {"type": "string", "pattern": "^[A-Z]"}, "population": {"type": "integer", "minimum": 0}}, "required": ["city"], "additionalProperties": false}`
+	chat := func(message, schema string) *httptest.ResponseRecorder {
+		body := `{"message": "` + message + `", "schema": ` + schema + `}`
+		req := httptest.NewRequest(http.MethodPost, "/chat/structured", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	provider.reply = `{"city": "Paris", "population": 2100000}`
+	w := chat("Largest city of France?", schema)
+	var resp StructuredChatResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	data, _ := resp.Data.(map[string]any)
+	if w.Code != http.StatusOK || data["city"] != "Paris" || data["population"] != float64(2100000) {
+		t.Fatalf("Expected the parsed reply, got %d: %s", w.Code, w.Body.String())
+	}
+	if rf := provider.last.ResponseFormat; rf == nil || rf.Type != openai.ChatCompletionResponseFormatTypeJSONObject {
+		t.Errorf("Expected JSON mode, got %+v", rf)
+	}
+	if last := provider.last.Messages[len(provider.last.Messages)-1]; last.Role != openai.ChatMessageRoleSystem || !strings.Contains(last.Content, `"required":["city"]`) {
+		t.Errorf("Expected the schema in the prompt, got %+v", last)
+	}
+
+	for message, reply := range map[string]string{
+		"Not JSON":           `The city is Paris`,
+		"Missing city":       `{"population": 2100000}`,
+		"Lower case city":    `{"city": "paris"}`,
+		"Negative":           `{"city": "Paris", "population": -1}`,
+		"Unknown property":   `{"city": "Paris", "country": "France"}`,
+		"Wrong type of city": `{"city": 75}`,
+	} {
+		provider.reply = reply
+		if w := chat(message, schema); w.Code != http.StatusBadGateway {
+			t.Errorf("Expected %q to be refused, got %d: %s", reply, w.Code, w.Body.String())
+		}
+	}
+
+	for _, schema := range []string{
+		`{"$ref": "#/components/schemas/City"}`,
+		`{"type": "object", "properties": {"city": {"type": "string", "pattern": "("}}}`,
+		`{"type": ["string", "null"]}`,
+	} {
+		if w := chat("Hi", schema); w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected schema %s to be rejected, got %d", schema, w.Code)
+		}
+	}
+}
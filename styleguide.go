@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// styleGuideTTL is how long loaded style guide documents are reused
+	// before being fetched from MinIO again.
+	styleGuideTTL = 5 * time.Minute
+	// maxStyleGuideBytes caps the size of each style guide document.
+	maxStyleGuideBytes = 64 << 10
+)
+
+type styleGuideDoc struct {
+	Name    string
+	Content string
+}
+
+var styleGuideCache struct {
+	sync.Mutex
+	prompt   string
+	loadedAt time.Time
+}
+
+// formatStyleGuide builds the system prompt that carries the glossary and
+// style guide documents into a generation request.
+func formatStyleGuide(docs []styleGuideDoc) string {
+	if len(docs) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Follow the glossary and style guide below in everything you write. ")
+	sb.WriteString("Use the preferred terminology exactly as given.\n")
+	for _, doc := range docs {
+		fmt.Fprintf(&sb, "\n### %s\n%s\n", doc.Name, strings.TrimSpace(doc.Content))
+	}
+	return sb.String()
+}
+
+// styleGuidePrompt returns the style guide system prompt, or an empty string
+// when no style guide documents are configured. Documents are cached for
+// styleGuideTTL so generation requests don't hit MinIO every time.
+func styleGuidePrompt(ctx context.Context) (string, error) {
+	if !services.Status().MinIO || config.OpenAI.StyleGuideBucket == "" || len(config.OpenAI.StyleGuideObjects) == 0 {
+		return "", nil
+	}
+
+	styleGuideCache.Lock()
+	defer styleGuideCache.Unlock()
+	if !styleGuideCache.loadedAt.IsZero() && time.Since(styleGuideCache.loadedAt) < styleGuideTTL {
+		return styleGuideCache.prompt, nil
+	}
+
+	docs := make([]styleGuideDoc, 0, len(config.OpenAI.StyleGuideObjects))
+	for _, name := range config.OpenAI.StyleGuideObjects {
+		content, err := readTextObject(ctx, config.OpenAI.StyleGuideBucket, name, "", maxStyleGuideBytes)
+		if err != nil {
+			return "", fmt.Errorf("load style guide %s: %w", name, err)
+		}
+		docs = append(docs, styleGuideDoc{Name: name, Content: content})
+	}
+
+	styleGuideCache.prompt = formatStyleGuide(docs)
+	styleGuideCache.loadedAt = time.Now()
+	return styleGuideCache.prompt, nil
+}
+
+// withStyleGuide prepends the style guide system message to messages when
+// one is configured.
+func withStyleGuide(ctx context.Context, messages []openai.ChatCompletionMessage) ([]openai.ChatCompletionMessage, error) {
+	prompt, err := styleGuidePrompt(ctx)
+	if err != nil || prompt == "" {
+		return messages, err
+	}
+	return append([]openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleSystem, Content: prompt}}, messages...), nil
+}
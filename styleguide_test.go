@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+func TestFormatStyleGuide(t *testing.T) {
+	if got := formatStyleGuide(nil); got != "" {
+		t.Errorf("Expected empty prompt without documents, got %q", got)
+	}
+
+	got := formatStyleGuide([]styleGuideDoc{
+		{Name: "glossary.md", Content: "Use \"sign in\", never \"log in\".\n"},
+	})
+	if !strings.Contains(got, "### glossary.md") || !strings.Contains(got, `Use "sign in"`) {
+		t.Errorf("Expected document name and content in prompt, got %q", got)
+	}
+}
+
+func TestWithStyleGuideNotConfigured(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	services.SetMinIO(nil)
+
+	messages := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hello"}}
+	got, err := withStyleGuide(context.Background(), messages)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(got) != 1 {
+		t.Errorf("Expected messages to be unchanged without a style guide, got %d messages", len(got))
+	}
+}
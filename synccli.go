@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// syncFile is a file on one side of a sync, keyed by its slash-separated
+// path relative to the synced directory or prefix.
+type syncFile struct {
+	Size int64
+	// ETag is the object's ETag for remote files; local files compute their
+	// MD5 on demand.
+	ETag string
+}
+
+// syncAction is one transfer or deletion a sync performs.
+type syncAction struct {
+	Op    string `json:"op"` // "upload", "download" or "delete"
+	Path  string `json:"path"`
+	Error string `json:"error,omitempty"`
+}
+
+// syncReport is the -output json result of a sync.
+type syncReport struct {
+	Direction string       `json:"direction"`
+	Bucket    string       `json:"bucket"`
+	Prefix    string       `json:"prefix"`
+	DryRun    bool         `json:"dry_run"`
+	Actions   []syncAction `json:"actions"`
+	Failed    int          `json:"failed"`
+}
+
+type syncOptions struct {
+	Include  []string
+	Exclude  []string
+	Delete   bool
+	DryRun   bool
+	Parallel int
+}
+
+// matches reports whether a relative path passes the include and exclude
+// globs. A pattern without a slash is matched against the base name.
+func (o syncOptions) matches(rel string) bool {
+	match := func(patterns []string) bool {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, rel); ok {
+				return true
+			}
+			if !strings.Contains(p, "/") {
+				if ok, _ := path.Match(p, path.Base(rel)); ok {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if len(o.Include) > 0 && !match(o.Include) {
+		return false
+	}
+	return !match(o.Exclude)
+}
+
+func validGlobs(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// syncClient transfers files through the API of a running instance.
+type syncClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// request builds an API request carrying the client's token.
+func (c *syncClient) request(ctx context.Context, method, p string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.baseURL, "/")+p, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// do sends the request and turns error responses into errors.
+func (c *syncClient) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+func fileURL(bucket, key string) string {
+	return "/files/" + url.PathEscape(bucket) + "/" + url.PathEscape(key)
+}
+
+// list returns the files below prefix, keyed by their path relative to it.
+func (c *syncClient) list(ctx context.Context, bucket, prefix string) (map[string]syncFile, error) {
+	query := url.Values{"path": {prefix}, "recursive": {"true"}}
+	req, err := c.request(ctx, http.MethodGet, "/folders/"+url.PathEscape(bucket)+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var listing FolderListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		return nil, err
+	}
+	files := map[string]syncFile{}
+	for _, e := range listing.Entries {
+		if !e.IsFolder {
+			files[e.Name] = syncFile{Size: e.Size, ETag: e.ETag}
+		}
+	}
+	return files, nil
+}
+
+func (c *syncClient) upload(ctx context.Context, bucket, key, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	req, err := c.request(ctx, http.MethodPut, fileURL(bucket, key), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// download writes the object to file through a temporary file, so an
+// interrupted transfer never leaves a truncated file behind.
+func (c *syncClient) download(ctx context.Context, bucket, key, file string) error {
+	req, err := c.request(ctx, http.MethodGet, fileURL(bucket, key), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".sync-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// remove moves the object to the trash.
+func (c *syncClient) remove(ctx context.Context, bucket, key string) error {
+	req, err := c.request(ctx, http.MethodDelete, fileURL(bucket, key), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// localFiles walks dir and returns its regular files keyed by their
+// slash-separated relative path. A missing directory has no files.
+func localFiles(dir string) (map[string]syncFile, error) {
+	files := map[string]syncFile{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == dir && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = syncFile{Size: info.Size()}
+		return nil
+	})
+	return files, err
+}
+
+func fileMD5(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sameContent compares a local file with an object by checksum. Multipart
+// ETags are not an MD5 of the content, so those are compared by size.
+func sameContent(local string, size int64, remote syncFile) (bool, error) {
+	if size != remote.Size {
+		return false, nil
+	}
+	if remote.ETag == "" || strings.Contains(remote.ETag, "-") {
+		return true, nil
+	}
+	sum, err := fileMD5(local)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(sum, remote.ETag), nil
+}
+
+// planSync lists what mirrors src onto dst: copies of files missing or
+// different on dst and, with Delete, deletions of files only on dst.
+// Direction is "up" (local to bucket) or "down".
+func planSync(direction, dir string, local, remote map[string]syncFile, opts syncOptions) ([]syncAction, error) {
+	src, dst, op := local, remote, "upload"
+	if direction == "down" {
+		src, dst, op = remote, local, "download"
+	}
+	var actions []syncAction
+	for rel, f := range src {
+		if !opts.matches(rel) {
+			continue
+		}
+		other, ok := dst[rel]
+		if ok {
+			l, r := f, other
+			if direction == "down" {
+				l, r = other, f
+			}
+			same, err := sameContent(filepath.Join(dir, filepath.FromSlash(rel)), l.Size, r)
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				continue
+			}
+		}
+		actions = append(actions, syncAction{Op: op, Path: rel})
+	}
+	if opts.Delete {
+		for rel := range dst {
+			if _, ok := src[rel]; !ok && opts.matches(rel) {
+				actions = append(actions, syncAction{Op: "delete", Path: rel})
+			}
+		}
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i].Path < actions[j].Path })
+	return actions, nil
+}
+
+// runSync performs the actions with up to opts.Parallel transfers at a time,
+// printing each to progress as it completes and recording failures in the
+// action's Error. It returns how many actions failed.
+func runSync(ctx context.Context, c *syncClient, direction, dir, bucket, prefix string, actions []syncAction, opts syncOptions, progress io.Writer) int {
+	var (
+		mu     sync.Mutex
+		failed int
+		wg     sync.WaitGroup
+	)
+	work := make(chan int)
+	for range max(opts.Parallel, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				a := &actions[i]
+				file := filepath.Join(dir, filepath.FromSlash(a.Path))
+				var err error
+				switch {
+				case a.Op == "upload":
+					err = c.upload(ctx, bucket, prefix+a.Path, file)
+				case a.Op == "download":
+					err = c.download(ctx, bucket, prefix+a.Path, file)
+				case direction == "up":
+					err = c.remove(ctx, bucket, prefix+a.Path)
+				default:
+					err = os.Remove(file)
+				}
+				mu.Lock()
+				if err != nil {
+					failed++
+					a.Error = err.Error()
+					fmt.Fprintf(progress, "%s %s failed: %v\n", a.Op, a.Path, err)
+				} else {
+					fmt.Fprintf(progress, "%s %s\n", a.Op, a.Path)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range actions {
+		if ctx.Err() != nil {
+			actions[i].Error = ctx.Err().Error()
+			failed++
+			continue
+		}
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return failed
+}
+
+// runSyncCommand implements the sync subcommand, which mirrors a local
+// directory to a bucket prefix (up) or a bucket prefix to a local directory
+// (down) through the API of a running instance.
+func runSyncCommand(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sync [flags] up|down <dir> <bucket>[/<prefix>]")
+		fs.PrintDefaults()
+	}
+	serverURL := fs.String("url", envOr("APP_URL", "http://localhost:8080"), "URL of a running instance (env APP_URL)")
+	token := fs.String("token", os.Getenv("APP_TOKEN"), "Bearer token sent to the API (env APP_TOKEN)")
+	format := outputFlag(fs, outputTable)
+	var opts syncOptions
+	fs.IntVar(&opts.Parallel, "j", 4, "Number of parallel transfers")
+	fs.BoolVar(&opts.Delete, "delete", false, "Delete files missing from the source; remote files are moved to the trash")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "Print what would change without transferring anything")
+	fs.Func("include", "Only sync paths matching this glob (repeatable)", func(p string) error {
+		opts.Include = append(opts.Include, p)
+		return nil
+	})
+	fs.Func("exclude", "Skip paths matching this glob (repeatable)", func(p string) error {
+		opts.Exclude = append(opts.Exclude, p)
+		return nil
+	})
+	if err := parseFlags(fs, args, format); err != nil {
+		return err
+	}
+	if fs.NArg() != 3 || (fs.Arg(0) != "up" && fs.Arg(0) != "down") {
+		fs.Usage()
+		return usageError("sync needs a direction, a directory and a bucket")
+	}
+	if err := errors.Join(validGlobs(opts.Include), validGlobs(opts.Exclude)); err != nil {
+		return &exitError{code: exitUsage, err: err}
+	}
+	direction, dir := fs.Arg(0), fs.Arg(1)
+	bucket, prefix, _ := strings.Cut(fs.Arg(2), "/")
+	prefix = folderPrefix(prefix)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	c := &syncClient{baseURL: *serverURL, token: *token, client: &http.Client{}}
+
+	local, err := localFiles(dir)
+	if err != nil {
+		return err
+	}
+	remote, err := c.list(ctx, bucket, prefix)
+	if err != nil {
+		return err
+	}
+	actions, err := planSync(direction, dir, local, remote, opts)
+	if err != nil {
+		return err
+	}
+	report := syncReport{Direction: direction, Bucket: bucket, Prefix: prefix, DryRun: opts.DryRun, Actions: actions}
+	if report.Actions == nil {
+		report.Actions = []syncAction{}
+	}
+	if opts.DryRun {
+		if *format == outputJSON {
+			return writeJSON(out, report)
+		}
+		for _, a := range actions {
+			fmt.Fprintf(out, "would %s %s\n", a.Op, a.Path)
+		}
+		return nil
+	}
+
+	progress := out
+	if *format == outputJSON {
+		progress = io.Discard
+	}
+	report.Failed = runSync(ctx, c, direction, dir, bucket, prefix, actions, opts, progress)
+	if *format == outputJSON {
+		if err := writeJSON(out, report); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(out, "%d files changed\n", len(actions)-report.Failed)
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d of %d transfers failed", report.Failed, len(actions))
+	}
+	return nil
+}
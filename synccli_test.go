@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestPlanSync(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "same.txt"), []byte("hello"), 0o600)
+	os.WriteFile(filepath.Join(dir, "changed.txt"), []byte("hello"), 0o600)
+	local := map[string]syncFile{"same.txt": {Size: 5}, "changed.txt": {Size: 5}, "new.md": {Size: 1}, "build/out.o": {Size: 1}}
+	remote := map[string]syncFile{
+		"same.txt":    {Size: 5, ETag: "5d41402abc4b2a76b9719d911017c592"},
+		"changed.txt": {Size: 5, ETag: "00000000000000000000000000000000"},
+		"stale.txt":   {Size: 1},
+	}
+	opts := syncOptions{Exclude: []string{"*.o"}, Delete: true}
+
+	actions, err := planSync("up", dir, local, remote, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []syncAction{{Op: "upload", Path: "changed.txt"}, {Op: "upload", Path: "new.md"}, {Op: "delete", Path: "stale.txt"}}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("Expected %v, got %v", want, actions)
+	}
+
+	actions, err = planSync("down", dir, local, remote, syncOptions{Include: []string{"*.txt"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []syncAction{{Op: "download", Path: "changed.txt"}, {Op: "download", Path: "stale.txt"}}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("Expected %v, got %v", want, actions)
+	}
+}
+
+func TestSyncCommand(t *testing.T) {
+	viper.Reset()
+	initConfig()
+
+	objects := map[string]string{"docs/site/old.txt": "old"}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerFolderEndpoints(api)
+	registerACLEndpoints(api)
+	registerFilePutEndpoint(api)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "css"), 0o700)
+	os.WriteFile(filepath.Join(src, "index.html"), []byte("<h1>Hi</h1>"), 0o600)
+	os.WriteFile(filepath.Join(src, "css", "site.css"), []byte("body{}"), 0o600)
+	os.WriteFile(filepath.Join(src, "notes.tmp"), []byte("scratch"), 0o600)
+
+	var out bytes.Buffer
+	if err := runSyncCommand([]string{"-url", server.URL, "-exclude", "*.tmp", "up", src, "docs/site"}, &out); err != nil {
+		t.Fatalf("sync up failed: %v\n%s", err, out.String())
+	}
+	if objects["docs/site/index.html"] != "<h1>Hi</h1>" || objects["docs/site/css/site.css"] != "body{}" {
+		t.Errorf("Expected the directory to be uploaded, got %v", objects)
+	}
+	if _, ok := objects["docs/site/notes.tmp"]; ok {
+		t.Error("Expected excluded files not to be uploaded")
+	}
+
+	out.Reset()
+	if err := runSyncCommand([]string{"-url", server.URL, "-exclude", "*.tmp", "up", src, "docs/site"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "0 files changed") {
+		t.Errorf("Expected an unchanged directory to transfer nothing, got %q", out.String())
+	}
+
+	dst := filepath.Join(t.TempDir(), "mirror")
+	out.Reset()
+	if err := runSyncCommand([]string{"-url", server.URL, "-j", "2", "down", dst, "docs/site/"}, &out); err != nil {
+		t.Fatalf("sync down failed: %v\n%s", err, out.String())
+	}
+	for name, want := range map[string]string{"index.html": "<h1>Hi</h1>", "css/site.css": "body{}", "old.txt": "old"} {
+		if got, _ := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name))); string(got) != want {
+			t.Errorf("Expected %s to contain %q, got %q", name, want, got)
+		}
+	}
+
+	os.WriteFile(filepath.Join(dst, "extra.txt"), []byte("x"), 0o600)
+	out.Reset()
+	if err := runSyncCommand([]string{"-url", server.URL, "-delete", "-dry-run", "down", dst, "docs/site"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "would delete extra.txt\n" {
+		t.Errorf("Expected the dry run to only report the extra file, got %q", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(dst, "extra.txt")); err != nil {
+		t.Error("Expected a dry run not to delete anything")
+	}
+
+	if err := runSyncCommand([]string{"-url", server.URL, "sideways", dst, "docs"}, &out); err == nil {
+		t.Error("Expected an unknown direction to be rejected")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFDsStart is the first file descriptor systemd passes sockets on.
+const listenFDsStart = 3
+
+// systemdSocket is a socket passed by systemd socket activation.
+type systemdSocket struct {
+	Name string
+	FD   int
+}
+
+// parseListenFDs interprets the LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES
+// variables systemd sets for socket-activated services. Sockets without a
+// FileDescriptorName are named by their index.
+func parseListenFDs(pid int, listenPID, listenFDs, listenFDNames string) ([]systemdSocket, error) {
+	if listenFDs == "" {
+		return nil, nil
+	}
+	if p, err := strconv.Atoi(listenPID); err != nil || p != pid {
+		// Inherited from a parent process
+		return nil, nil
+	}
+	n, err := strconv.Atoi(listenFDs)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", listenFDs)
+	}
+	var names []string
+	if listenFDNames != "" {
+		names = strings.Split(listenFDNames, ":")
+	}
+
+	sockets := make([]systemdSocket, n)
+	for i := range sockets {
+		sockets[i] = systemdSocket{Name: strconv.Itoa(i), FD: listenFDsStart + i}
+		if i < len(names) && names[i] != "" && names[i] != "unknown" {
+			sockets[i].Name = names[i]
+		}
+	}
+	return sockets, nil
+}
+
+var systemdSockets = sync.OnceValues(func() ([]systemdSocket, error) {
+	sockets, err := parseListenFDs(os.Getpid(), os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"))
+	// Don't pass the sockets on to child processes such as the PDF renderer
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	return sockets, err
+})
+
+// systemdListener returns the listener for the socket systemd passed under
+// name, which is its FileDescriptorName or index.
+func systemdListener(name string) (net.Listener, error) {
+	sockets, err := systemdSockets()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sockets {
+		if s.Name == name {
+			f := os.NewFile(uintptr(s.FD), "LISTEN_FD_"+s.Name)
+			defer f.Close()
+			return net.FileListener(f)
+		}
+	}
+	return nil, fmt.Errorf("systemd did not pass a socket named %q", name)
+}
+
+// listenUnix listens on a unix socket at path, replacing a socket left
+// behind by an earlier run, and applies mode to the socket file.
+func listenUnix(path, mode string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is in use", path)
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != "" {
+		m, _ := strconv.ParseUint(mode, 8, 32)
+		if err := os.Chmod(path, os.FileMode(m)); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
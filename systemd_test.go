@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseListenFDs(t *testing.T) {
+	sockets, err := parseListenFDs(42, "42", "3", "http:unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []systemdSocket{{Name: "http", FD: 3}, {Name: "1", FD: 4}, {Name: "2", FD: 5}}
+	if !reflect.DeepEqual(sockets, want) {
+		t.Errorf("Expected %v, got %v", want, sockets)
+	}
+
+	// Sockets meant for another process are ignored
+	if sockets, _ := parseListenFDs(42, "7", "1", ""); len(sockets) != 0 {
+		t.Errorf("Expected no sockets for another PID, got %v", sockets)
+	}
+	if sockets, _ := parseListenFDs(42, "", "", ""); len(sockets) != 0 {
+		t.Errorf("Expected no sockets without LISTEN_FDS, got %v", sockets)
+	}
+	if _, err := parseListenFDs(42, "42", "many", ""); err == nil {
+		t.Error("Expected an error for an invalid LISTEN_FDS")
+	}
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "api.sock")
+
+	// A socket left behind by a crashed run is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l := ListenerConfig{Name: "sidecar", Network: "unix", Addr: path, SocketMode: "0600"}
+	if err := l.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	listeners, err := openListeners([]ListenerConfig{l}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	if err != nil {
+		t.Fatalf("Failed to open unix listener: %v", err)
+	}
+	defer listeners[0].srv.Close()
+	go serveListeners(context.Background(), listeners, 0)
+
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("Expected socket mode 0600, got %v (%v)", fi.Mode().Perm(), err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://unix/health")
+	if err != nil {
+		t.Fatalf("Request over unix socket failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Errorf("Expected ok, got %q", body)
+	}
+
+	// A socket in use is not taken over
+	if _, err := listenUnix(path, ""); err == nil {
+		t.Error("Expected an error for a socket in use")
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// takeoutPrefix is where takeout archives and their status are kept in the
+// system bucket.
+const takeoutPrefix = "takeout/"
+
+// takeoutTimeout bounds how long building one archive may take.
+const takeoutTimeout = 30 * time.Minute
+
+// Takeout statuses.
+const (
+	takeoutPending = "pending"
+	takeoutReady   = "ready"
+	takeoutFailed  = "failed"
+)
+
+// TakeoutConfig configures data subject exports.
+type TakeoutConfig struct {
+	LinkHours int    `mapstructure:"link_hours" doc:"Hours the download link of a takeout archive stays valid, at most 168"`
+	NotifyURL string `mapstructure:"notify_url" doc:"URL a JSON notification is POSTed to when a takeout archive is ready or failed"`
+}
+
+func (c TakeoutConfig) Validate() error {
+	if c.LinkHours < 1 || c.LinkHours > 168 {
+		return errors.New("takeout.link_hours must be between 1 and 168")
+	}
+	if c.NotifyURL != "" {
+		if u, err := url.Parse(c.NotifyURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("takeout.notify_url: %q is not an http(s) URL", c.NotifyURL)
+		}
+	}
+	return nil
+}
+
+// takeoutJobs tracks archives being built so that tests can wait for them.
+var takeoutJobs sync.WaitGroup
+
+type TakeoutRequest struct {
+	Email string `json:"email,omitempty" format:"email" doc:"Address the download link is emailed to when the archive is ready"`
+}
+
+// Takeout is the status of one export.
+type Takeout struct {
+	ID          string    `json:"id" doc:"Takeout ID"`
+	Subject     string    `json:"subject" doc:"ID of the data subject"`
+	Status      string    `json:"status" enum:"pending,ready,failed" doc:"Whether the archive is still being built, ready or failed"`
+	RequestedAt time.Time `json:"requested_at" doc:"When the export was requested"`
+	CompletedAt time.Time `json:"completed_at,omitempty" doc:"When the archive was ready or failed"`
+	Object      string    `json:"object" doc:"System bucket object the archive is stored in"`
+	Size        int64     `json:"size,omitempty" doc:"Archive size in bytes"`
+	Files       int       `json:"files,omitempty" doc:"Number of files in the archive"`
+	Error       string    `json:"error,omitempty" doc:"Why building the archive failed"`
+	Email       string    `json:"email,omitempty" doc:"Address notified when the archive is ready"`
+	URL         string    `json:"url,omitempty" doc:"Presigned download link of a ready archive"`
+	ExpiresAt   time.Time `json:"expires_at,omitempty" doc:"When the download link expires"`
+}
+
+type TakeoutFile struct {
+	Bucket     string    `json:"bucket"`
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	Visibility string    `json:"visibility"`
+	Modified   time.Time `json:"last_modified"`
+}
+
+// TakeoutUsage summarizes what the subject stores and spends.
+type TakeoutUsage struct {
+	Files           int   `json:"files"`
+	Bytes           int64 `json:"bytes"`
+	ProxyRequests   int64 `json:"proxy_requests_today,omitempty"`
+	ProxyTokens     int64 `json:"proxy_tokens_today,omitempty"`
+	ProxyTokenLimit int64 `json:"proxy_daily_token_budget,omitempty"`
+}
+
+// takeoutManifest is manifest.json at the root of an archive.
+type takeoutManifest struct {
+	Subject   string        `json:"subject"`
+	CreatedAt time.Time     `json:"created_at"`
+	Files     []TakeoutFile `json:"files"`
+	// Transcripts are the IDs of the conversations whose transcripts
+	// are in transcripts/
+	Transcripts []string     `json:"transcripts"`
+	Usage       TakeoutUsage `json:"usage"`
+}
+
+func takeoutKey(subject, id string) string {
+	return takeoutPrefix + subject + "/" + id
+}
+
+// checkSubjectAccess allows the admin, and a storage tenant acting on its
+// own data.
+func checkSubjectAccess(authorization, subject string) error {
+	c := objectCaller(authorization)
+	switch {
+	case c.admin || c.tenant == subject:
+		return nil
+	case c.tenant == "":
+		return huma.Error401Unauthorized("Invalid or missing admin or tenant token")
+	}
+	return huma.Error403Forbidden(fmt.Sprintf("Tenant %s may not export data of %s", c.tenant, subject))
+}
+
+// subjectUsage sums the subject's files and, if it is a proxy team, today's
+// proxy usage.
+func subjectUsage(subject string, files []TakeoutFile, now time.Time) TakeoutUsage {
+	u := TakeoutUsage{Files: len(files)}
+	for _, f := range files {
+		u.Bytes += f.Size
+	}
+	for _, t := range config.Proxy.Teams {
+		if t.Name != subject {
+			continue
+		}
+		proxyUsage.mu.Lock()
+		s := proxyUsage.state(t.Name, now)
+		u.ProxyRequests, u.ProxyTokens, u.ProxyTokenLimit = s.requests, s.tokens, t.DailyTokenBudget
+		proxyUsage.mu.Unlock()
+	}
+	return u
+}
+
+// subjectFiles lists the objects whose ACL names the subject as owner,
+// leaving out trashed ones.
+func subjectFiles(ctx context.Context, client *minio.Client, subject string) ([]TakeoutFile, error) {
+	files := []TakeoutFile{}
+	for obj := range client.ListObjects(ctx, config.MinIO.SystemBucket, minio.ListObjectsOptions{Prefix: aclPrefix}) {
+		if obj.Err != nil {
+			if isNotFound(obj.Err) {
+				break
+			}
+			return nil, obj.Err
+		}
+		bucket, ok := strings.CutSuffix(strings.TrimPrefix(obj.Key, aclPrefix), ".json")
+		if !ok {
+			continue
+		}
+		acls, err := loadACLs(ctx, bucket)
+		if err != nil {
+			return nil, err
+		}
+		for name, acl := range acls {
+			if acl.Owner != subject || strings.HasPrefix(name, trashPrefix) {
+				continue
+			}
+			info, err := client.StatObject(ctx, bucket, name, minio.StatObjectOptions{})
+			if isNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, TakeoutFile{Bucket: bucket, Name: name, Size: info.Size, Visibility: acl.Visibility, Modified: info.LastModified})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Bucket+"/"+files[i].Name < files[j].Bucket+"/"+files[j].Name
+	})
+	return files, nil
+}
+
+// writeTakeoutArchive writes the manifest, usage summary, files and
+// conversation transcripts as a zip archive to w.
+func writeTakeoutArchive(ctx context.Context, client *minio.Client, w io.Writer, manifest takeoutManifest, transcripts []Transcript) error {
+	zw := zip.NewWriter(w)
+	add := func(name string, modified time.Time, r io.Reader) error {
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(entry, r)
+		return err
+	}
+	for _, doc := range []struct {
+		name string
+		v    any
+	}{{"manifest.json", manifest}, {"usage.json", manifest.Usage}} {
+		data, err := json.MarshalIndent(doc.v, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := add(doc.name, manifest.CreatedAt, bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+	for _, f := range manifest.Files {
+		obj, err := client.GetObject(ctx, f.Bucket, f.Name, minio.GetObjectOptions{})
+		if err != nil {
+			return err
+		}
+		// Cleaning from the root keeps names like ../x inside files/
+		err = add("files/"+f.Bucket+path.Clean("/"+f.Name), f.Modified, obj)
+		obj.Close()
+		if err != nil {
+			return fmt.Errorf("%s/%s: %w", f.Bucket, f.Name, err)
+		}
+	}
+	for _, t := range transcripts {
+		data, err := json.MarshalIndent(t, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := add("transcripts/"+path.Base(t.ConversationID)+".json", t.CreatedAt, bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// buildTakeout builds the archive for t, stores it next to its status and
+// notifies the subject and the configured URL.
+func buildTakeout(ctx context.Context, client *minio.Client, t Takeout) {
+	err := func() error {
+		files, err := subjectFiles(ctx, client, t.Subject)
+		if err != nil {
+			return err
+		}
+		transcripts, err := tenantTranscripts(ctx, client, t.Subject)
+		if err != nil {
+			return err
+		}
+		manifest := takeoutManifest{Subject: t.Subject, CreatedAt: time.Now().UTC(), Files: files, Transcripts: []string{}, Usage: subjectUsage(t.Subject, files, time.Now())}
+		for _, tr := range transcripts {
+			manifest.Transcripts = append(manifest.Transcripts, tr.ConversationID)
+		}
+
+		// Spool the archive to disk so that it is uploaded with a known
+		// size instead of buffering multipart chunks in memory
+		spool, err := os.CreateTemp("", "takeout-*.zip")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		if err := writeTakeoutArchive(ctx, client, spool, manifest, transcripts); err != nil {
+			return err
+		}
+		size, err := spool.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := client.PutObject(ctx, config.MinIO.SystemBucket, t.Object, spool, size, minio.PutObjectOptions{ContentType: "application/zip"}); err != nil {
+			return err
+		}
+		t.Size, t.Files = size, len(files)
+		return nil
+	}()
+	t.CompletedAt = time.Now().UTC()
+	t.Status = takeoutReady
+	if err != nil {
+		log.Printf("Takeout %s of %s failed: %v", t.ID, t.Subject, err)
+		t.Status, t.Error = takeoutFailed, err.Error()
+	}
+	if err := putJSON(ctx, config.MinIO.SystemBucket, takeoutKey(t.Subject, t.ID)+".json", t); err != nil {
+		log.Printf("Failed to save takeout %s of %s: %v", t.ID, t.Subject, err)
+		return
+	}
+	if t.Status == takeoutReady {
+		if t.URL, t.ExpiresAt, err = presignTakeout(ctx, client, t); err != nil {
+			log.Printf("Failed to sign the link of takeout %s: %v", t.ID, err)
+		}
+	}
+	notifyTakeout(ctx, t)
+}
+
+// presignTakeout returns a download link of a ready archive.
+func presignTakeout(ctx context.Context, client *minio.Client, t Takeout) (string, time.Time, error) {
+	expiry := time.Duration(config.Takeout.LinkHours) * time.Hour
+	params := url.Values{"response-content-disposition": {fmt.Sprintf(`attachment; filename="takeout-%s-%s.zip"`, t.Subject, t.ID)}}
+	u, err := client.PresignedGetObject(ctx, config.MinIO.SystemBucket, t.Object, expiry, params)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return u.String(), time.Now().Add(expiry).UTC(), nil
+}
+
+// notifyTakeout emails the subject and POSTs the status to
+// takeout.notify_url. Failures are logged.
+func notifyTakeout(ctx context.Context, t Takeout) {
+	if t.Email != "" && emailEnabled() {
+		subject, body := "Your data export is ready", fmt.Sprintf("Download your data until %s:\n\n%s", t.ExpiresAt.Format(time.RFC1123), t.URL)
+		if t.Status != takeoutReady {
+			subject, body = "Your data export failed", "Your data export could not be created. Please request it again."
+		}
+		if err := sendEmail([]string{t.Email}, subject, body); err != nil {
+			log.Printf("Failed to email takeout %s: %v", t.ID, err)
+		}
+	}
+	if config.Takeout.NotifyURL == "" {
+		return
+	}
+	body, _ := json.Marshal(t)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.Takeout.NotifyURL, bytes.NewReader(body))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		var resp *http.Response
+		if resp, err = alertHTTPClient.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				err = fmt.Errorf("notify URL returned %s", resp.Status)
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to notify takeout %s: %v", t.ID, err)
+	}
+}
+
+func registerTakeoutEndpoints(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID:   "create-takeout",
+		Method:        http.MethodPost,
+		Path:          "/users/{id}/takeout",
+		Summary:       "Export a data subject's data",
+		Description:   "Start building a zip archive of the files the subject owns, the transcripts of its conversations and a usage summary. The archive is stored in the system bucket; its presigned download link is emailed and sent to takeout.notify_url when it is ready",
+		DefaultStatus: http.StatusAccepted,
+	}, func(ctx context.Context, input *struct {
+		ID            string `path:"id" doc:"Data subject ID, the owner recorded in object ACLs"`
+		Authorization string `header:"Authorization" doc:"Bearer admin token, or the subject's own tenant token"`
+		Body          TakeoutRequest
+	}) (*struct {
+		Body Takeout
+	}, error) {
+		if err := checkSubjectAccess(input.Authorization, input.ID); err != nil {
+			return nil, err
+		}
+		if !subjectIDPattern.MatchString(input.ID) {
+			return nil, huma.Error422UnprocessableEntity("Invalid data subject ID")
+		}
+		t := Takeout{ID: randomHex(8), Subject: input.ID, Status: takeoutPending, RequestedAt: time.Now().UTC()}
+		if input.Body.Email != "" {
+			addr, err := mail.ParseAddress(input.Body.Email)
+			if err != nil {
+				return nil, huma.Error422UnprocessableEntity("Invalid email address", err)
+			}
+			if !emailEnabled() {
+				return nil, huma.Error422UnprocessableEntity("Email notifications need alerts.smtp_addr and alerts.email_from")
+			}
+			t.Email = addr.Address
+		}
+		t.Object = takeoutKey(t.Subject, t.ID) + ".zip"
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+		if err := ensureBucket(ctx, config.MinIO.SystemBucket); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to start the export", err)
+		}
+		if err := putJSON(ctx, config.MinIO.SystemBucket, takeoutKey(t.Subject, t.ID)+".json", t); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to start the export", err)
+		}
+
+		takeoutJobs.Add(1)
+		go func() {
+			defer takeoutJobs.Done()
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), takeoutTimeout)
+			defer cancel()
+			buildTakeout(ctx, client, t)
+		}()
+		return &struct {
+			Body Takeout
+		}{Body: t}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-takeout",
+		Method:      http.MethodGet,
+		Path:        "/users/{id}/takeout/{takeout}",
+		Summary:     "Get a data export",
+		Description: "Report whether an export is ready and return a fresh download link when it is",
+	}, func(ctx context.Context, input *struct {
+		ID            string `path:"id" doc:"Data subject ID"`
+		Takeout       string `path:"takeout" doc:"Takeout ID"`
+		Authorization string `header:"Authorization" doc:"Bearer admin token, or the subject's own tenant token"`
+	}) (*struct {
+		Body Takeout
+	}, error) {
+		if err := checkSubjectAccess(input.Authorization, input.ID); err != nil {
+			return nil, err
+		}
+		if !subjectIDPattern.MatchString(input.ID) || !subjectIDPattern.MatchString(input.Takeout) {
+			return nil, huma.Error404NotFound("Takeout not found")
+		}
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+		var t Takeout
+		if err := getJSON(ctx, config.MinIO.SystemBucket, takeoutKey(input.ID, input.Takeout)+".json", &t); err != nil {
+			if isNotFound(err) {
+				return nil, huma.Error404NotFound(fmt.Sprintf("Takeout %s of %s not found", input.Takeout, input.ID))
+			}
+			return nil, huma.Error500InternalServerError("Failed to read the export", err)
+		}
+		if t.Status == takeoutReady {
+			if t.URL, t.ExpiresAt, err = presignTakeout(ctx, client, t); err != nil {
+				return nil, huma.Error500InternalServerError("Failed to sign the download link", err)
+			}
+		}
+		return &struct {
+			Body Takeout
+		}{Body: t}, nil
+	})
+}
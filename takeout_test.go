@@ -0,0 +1,126 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestTakeout(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "admin-token"
+	config.Storage.Tenants = []StorageTenantConfig{
+		{Name: "acme", Token: "acme-token", Bucket: "tenants"},
+		{Name: "globex", Token: "globex-token", Bucket: "tenants"},
+	}
+
+	objects := map[string]string{
+		"tenants/acme/q1.txt":        "q1",
+		"tenants/.trash/acme/q0.txt": "q0",
+		"tenants/globex/q1.txt":      "other",
+		"app-system/acls/tenants.json": `{
+			"acme/q1.txt": {"visibility": "private", "owner": "acme"},
+			".trash/acme/q0.txt": {"visibility": "private", "owner": "acme"},
+			"globex/q1.txt": {"visibility": "private", "owner": "globex"}
+		}`,
+		"app-system/transcripts/tenants/acme/conv_a.json":   `{"conversation_id": "conv_a", "tenant": "acme", "turns": [{"message": "Hi", "reply": "Hello"}]}`,
+		"app-system/transcripts/tenants/globex/conv_b.json": `{"conversation_id": "conv_b", "tenant": "globex"}`,
+	}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	notified := make(chan Takeout, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var t Takeout
+		json.NewDecoder(r.Body).Decode(&t)
+		notified <- t
+	}))
+	defer hook.Close()
+	config.Takeout.NotifyURL = hook.URL
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerTakeoutEndpoints(api)
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := call("POST", "/users/acme/takeout", "wrong", `{}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", w.Code)
+	}
+	if w := call("POST", "/users/acme/takeout", "globex-token", `{}`); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another tenant, got %d", w.Code)
+	}
+	if w := call("POST", "/users/acme/takeout", "acme-token", `{"email": "me@example.com"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for an email without SMTP settings, got %d", w.Code)
+	}
+
+	w := call("POST", "/users/acme/takeout", "acme-token", `{}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", w.Code, w.Body.String())
+	}
+	var started Takeout
+	json.Unmarshal(w.Body.Bytes(), &started)
+	if started.Status != takeoutPending || started.Subject != "acme" {
+		t.Errorf("Expected a pending takeout of acme, got %+v", started)
+	}
+	takeoutJobs.Wait()
+
+	if n := <-notified; n.ID != started.ID || n.Status != takeoutReady || n.URL == "" {
+		t.Errorf("Expected a notification with the download link, got %+v", n)
+	}
+
+	w = call("GET", "/users/acme/takeout/"+started.ID, "admin-token", "")
+	var done Takeout
+	json.Unmarshal(w.Body.Bytes(), &done)
+	if w.Code != http.StatusOK || done.Status != takeoutReady || done.Files != 1 || !strings.Contains(done.URL, "/app-system/"+done.Object) {
+		t.Fatalf("Expected a ready takeout with a download link, got %d: %s", w.Code, w.Body.String())
+	}
+
+	archive := objects["app-system/"+done.Object]
+	zr, err := zip.NewReader(bytes.NewReader([]byte(archive)), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := map[string]string{}
+	for _, f := range zr.File {
+		r, _ := f.Open()
+		data, _ := io.ReadAll(r)
+		r.Close()
+		contents[f.Name] = string(data)
+	}
+	if contents["files/tenants/acme/q1.txt"] != "q1" || !strings.Contains(contents["transcripts/conv_a.json"], `"reply": "Hello"`) || len(contents) != 4 {
+		t.Errorf("Expected the manifest, usage summary, the owned file and transcript only, got %v", contents)
+	}
+	var manifest takeoutManifest
+	if err := json.Unmarshal([]byte(contents["manifest.json"]), &manifest); err != nil || manifest.Subject != "acme" || manifest.Usage.Bytes != 2 || len(manifest.Transcripts) != 1 || manifest.Transcripts[0] != "conv_a" {
+		t.Errorf("Expected a manifest of acme's 2 bytes, got %+v, %v", manifest, err)
+	}
+
+	if w := call("GET", "/users/acme/takeout/missing", "acme-token", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown takeout, got %d", w.Code)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// templateCacheTTL is how long a loaded template is reused. Changes made
+// through another instance take effect within it.
+const templateCacheTTL = 30 * time.Second
+
+// templateCache holds recently loaded templates by name.
+var templateCache struct {
+	sync.Mutex
+	entries map[string]cachedTemplate
+}
+
+type cachedTemplate struct {
+	template PromptTemplate
+	loadedAt time.Time
+}
+
+func cacheTemplate(t PromptTemplate) {
+	templateCache.Lock()
+	defer templateCache.Unlock()
+	if templateCache.entries == nil {
+		templateCache.entries = map[string]cachedTemplate{}
+	}
+	templateCache.entries[t.Name] = cachedTemplate{template: t, loadedAt: time.Now()}
+}
+
+func uncacheTemplate(name string) {
+	templateCache.Lock()
+	defer templateCache.Unlock()
+	delete(templateCache.entries, name)
+}
+
+// templateVariablePattern matches a {{variable}} placeholder of a prompt
+// template.
+var templateVariablePattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+type TemplateRequest struct {
+	Description string `json:"description,omitempty" maxLength:"1024" doc:"What the template is for"`
+	System      string `json:"system,omitempty" maxLength:"32768" doc:"System prompt sent with the template; may use placeholders too"`
+	Prompt      string `json:"prompt" minLength:"1" maxLength:"32768" doc:"User prompt with {{variable}} placeholders"`
+}
+
+func templateKey(name string) string {
+	return templatesPrefix + name + ".json"
+}
+
+// renderTemplate fills the {{variable}} placeholders of text from vars.
+// Placeholders without a value are an error, naming all of them.
+func renderTemplate(text string, vars map[string]string) (string, error) {
+	var missing []string
+	out := templateVariablePattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		name := templateVariablePattern.FindStringSubmatch(placeholder)[1]
+		v, ok := vars[name]
+		if !ok && !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("no value for %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+func loadTemplate(ctx context.Context, name string) (PromptTemplate, error) {
+	templateCache.Lock()
+	cached, ok := templateCache.entries[name]
+	templateCache.Unlock()
+	if ok && time.Since(cached.loadedAt) < templateCacheTTL {
+		return cached.template, nil
+	}
+
+	var t PromptTemplate
+	if err := getJSON(ctx, config.MinIO.SystemBucket, templateKey(name), &t); err != nil {
+		if isNotFound(err) {
+			return t, huma.Error404NotFound(fmt.Sprintf("Template %s not found", name))
+		}
+		return t, huma.Error500InternalServerError("Failed to read the template", err)
+	}
+	cacheTemplate(t)
+	return t, nil
+}
+
+// applyTemplate renders the template req names into its message, and the
+// template's system prompt unless req sets its own.
+func applyTemplate(ctx context.Context, req *ChatRequest) error {
+	if req.Template == "" {
+		if len(req.Variables) > 0 {
+			return huma.Error422UnprocessableEntity("variables are only used with a template")
+		}
+		return nil
+	}
+	if req.Message != "" {
+		return huma.Error422UnprocessableEntity("Send either a message or a template, not both")
+	}
+	if _, err := services.MinIO(); err != nil {
+		return err
+	}
+	t, err := loadTemplate(ctx, req.Template)
+	if err != nil {
+		return err
+	}
+	message, err := renderTemplate(t.Prompt, req.Variables)
+	if err != nil {
+		return huma.Error422UnprocessableEntity(fmt.Sprintf("Template %s: %v", req.Template, err))
+	}
+	system, err := renderTemplate(t.System, req.Variables)
+	if err != nil {
+		return huma.Error422UnprocessableEntity(fmt.Sprintf("Template %s: %v", req.Template, err))
+	}
+	req.Message = message
+	if req.SystemPrompt == "" {
+		req.SystemPrompt = system
+	}
+	return nil
+}
+
+func registerTemplateEndpoints(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "put-template",
+		Method:      http.MethodPut,
+		Path:        "/templates/{name}",
+		Summary:     "Save a prompt template",
+		Description: "Create or replace a prompt template that chats can render by name",
+	}, func(ctx context.Context, input *struct {
+		Name          string `path:"name" pattern:"^[a-z0-9][a-z0-9_-]{0,62}$" doc:"Template name: lowercase letters, digits, - and _"`
+		Authorization string `header:"Authorization" doc:"Bearer admin token"`
+		Body          TemplateRequest
+	}) (*struct {
+		Body PromptTemplate
+	}, error) {
+		if err := requireAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+
+		t := PromptTemplate{Name: input.Name, Description: input.Body.Description, System: input.Body.System, Prompt: input.Body.Prompt}
+		if err := ensureBucket(ctx, config.MinIO.SystemBucket); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to save the template", err)
+		}
+		if err := putJSON(ctx, config.MinIO.SystemBucket, templateKey(input.Name), t); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to save the template", err)
+		}
+		cacheTemplate(t)
+		audit(ctx, AuditEvent{Action: "template.update", Operation: "put-template", Detail: input.Name})
+		return &struct {
+			Body PromptTemplate
+		}{Body: t}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-template",
+		Method:      http.MethodGet,
+		Path:        "/templates/{name}",
+		Summary:     "Get a prompt template",
+		Description: "Return a prompt template",
+	}, func(ctx context.Context, input *struct {
+		Name string `path:"name" doc:"Template name"`
+	}) (*struct {
+		Body PromptTemplate
+	}, error) {
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+		t, err := loadTemplate(ctx, input.Name)
+		if err != nil {
+			return nil, err
+		}
+		return &struct {
+			Body PromptTemplate
+		}{Body: t}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID:   "delete-template",
+		Method:        http.MethodDelete,
+		Path:          "/templates/{name}",
+		Summary:       "Remove a prompt template",
+		Description:   "Remove a prompt template; chats naming it fail from then on",
+		DefaultStatus: http.StatusNoContent,
+	}, func(ctx context.Context, input *struct {
+		Name          string `path:"name" doc:"Template name"`
+		Authorization string `header:"Authorization" doc:"Bearer admin token"`
+	}) (*struct{}, error) {
+		if err := requireAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+		if err := client.RemoveObject(ctx, config.MinIO.SystemBucket, templateKey(input.Name), minio.RemoveObjectOptions{}); err != nil && !isNotFound(err) {
+			return nil, huma.Error500InternalServerError("Failed to remove the template", err)
+		}
+		uncacheTemplate(input.Name)
+		audit(ctx, AuditEvent{Action: "template.delete", Operation: "delete-template", Detail: input.Name})
+		return nil, nil
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	out, err := renderTemplate("Draft notes for {{version}} from:\n\n{{ changes }}", map[string]string{"version": "2.1", "changes": "- {{x}} fixed", "unused": "y"})
+	if err != nil || out != "Draft notes for 2.1 from:\n\n- {{x}} fixed" {
+		t.Errorf("Unexpected rendering %q, %v", out, err)
+	}
+	if _, err := renderTemplate("{{a}} {{b}} {{a}}", map[string]string{"b": ""}); err == nil || err.Error() != "no value for a" {
+		t.Errorf("Expected the missing variable to be named once, got %v", err)
+	}
+}
+
+func TestTemplateEndpoints(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "admin-token"
+	config.ChatProvider.Name = "echo"
+	chatProviders["echo"] = func() (ChatProvider, error) { return &echoProvider{}, nil }
+	defer delete(chatProviders, "echo")
+
+	objects := map[string]string{}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerGalleryEndpoints(api)
+	registerTemplateEndpoints(api)
+	registerChatEndpoint(api)
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	template := `{"description": "Greet someone", "system": "You greet {{name}} warmly.", "prompt": "Say hello to {{name}}"}`
+	if w := call(http.MethodPut, "/templates/greet", "tenant-token", template); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected saving to be admin only, got %d", w.Code)
+	}
+	if w := call(http.MethodPut, "/templates/Greet!", "admin-token", template); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected an invalid name to be rejected, got %d", w.Code)
+	}
+	if w := call(http.MethodPut, "/templates/greet", "admin-token", template); w.Code != http.StatusOK || !strings.Contains(objects["app-system/templates/greet.json"], `"prompt":"Say hello to {{name}}"`) {
+		t.Fatalf("Expected the template to be saved, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call(http.MethodGet, "/templates", "", ""); !strings.Contains(w.Body.String(), `"name":"greet"`) {
+		t.Errorf("Expected the template to be listed, got %s", w.Body.String())
+	}
+	if w := call(http.MethodGet, "/templates/greet", "", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Greet someone") {
+		t.Errorf("Expected the template, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := call(http.MethodPost, "/chat", "", `{"template": "greet", "variables": {"name": "Ada"}}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"reply":"echo: Say hello to Ada"`) {
+		t.Errorf("Expected the rendered template to be sent, got %d: %s", w.Code, w.Body.String())
+	}
+	for body, want := range map[string]int{
+		`{"template": "greet"}`: http.StatusUnprocessableEntity,
+		`{"template": "greet", "message": "Hi", "variables": {"name": "Ada"}}`: http.StatusUnprocessableEntity,
+		`{"message": "Hi", "variables": {"name": "Ada"}}`:                      http.StatusUnprocessableEntity,
+		`{"template": "farewell"}`:                                             http.StatusNotFound,
+	} {
+		if w := call(http.MethodPost, "/chat", "", body); w.Code != want {
+			t.Errorf("Expected %d for %s, got %d: %s", want, body, w.Code, w.Body.String())
+		}
+	}
+
+	// The template's system prompt applies unless the request has its own
+	req := ChatRequest{Template: "greet", Variables: map[string]string{"name": "Ada"}}
+	if err := applyTemplate(context.Background(), &req); err != nil || req.SystemPrompt != "You greet Ada warmly." {
+		t.Errorf("Expected the template's system prompt, got %q, %v", req.SystemPrompt, err)
+	}
+	req = ChatRequest{Template: "greet", Variables: map[string]string{"name": "Ada"}, SystemPrompt: "Be brief."}
+	if err := applyTemplate(context.Background(), &req); err != nil || req.SystemPrompt != "Be brief." {
+		t.Errorf("Expected the request's system prompt, got %q, %v", req.SystemPrompt, err)
+	}
+
+	if w := call(http.MethodDelete, "/templates/greet", "admin-token", ""); w.Code != http.StatusNoContent {
+		t.Errorf("Expected the template to be removed, got %d", w.Code)
+	}
+	if w := call(http.MethodGet, "/templates/greet", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the removed template to be gone, got %d", w.Code)
+	}
+}
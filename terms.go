@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// Terms and their acceptances are kept in the system bucket.
+const (
+	termsCurrentKey       = "terms/current.json"
+	termsVersionsPrefix   = "terms/versions/"
+	termsAcceptancePrefix = "terms/acceptances/"
+)
+
+// termsCacheTTL is how long the current terms version is cached. Versions
+// published through another instance take effect within it.
+const termsCacheTTL = 30 * time.Second
+
+var termsVersionPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// TermsConfig gates chat on accepting the published terms.
+type TermsConfig struct {
+	Required bool `mapstructure:"required" doc:"Require a tenant token whose tenant accepted the current terms for POST /chat and /chat/stream"`
+}
+
+// TermsVersion is one published version of the terms or DPA.
+type TermsVersion struct {
+	Version     string    `json:"version" doc:"Version identifier, e.g. 2026-10"`
+	Title       string    `json:"title,omitempty" doc:"Title shown to users"`
+	Text        string    `json:"text,omitempty" doc:"Full text of the terms"`
+	URL         string    `json:"url,omitempty" format:"uri" doc:"Where the terms are published"`
+	PublishedAt time.Time `json:"published_at" readOnly:"true" doc:"When the version was published"`
+}
+
+// TermsAcceptance records that a tenant accepted a version.
+type TermsAcceptance struct {
+	Version    string    `json:"version" doc:"Accepted terms version"`
+	AcceptedAt time.Time `json:"accepted_at" doc:"When the version was accepted"`
+}
+
+type TermsAcceptRequest struct {
+	Version string `json:"version" doc:"Version being accepted; must be the current one"`
+}
+
+type TermsAcceptanceResponse struct {
+	Tenant      string            `json:"tenant" doc:"Tenant that accepted"`
+	Current     string            `json:"current" doc:"Current terms version"`
+	Acceptances []TermsAcceptance `json:"acceptances" doc:"Every version the tenant accepted, oldest first"`
+}
+
+// termsState caches the current version and the tenants known to have
+// accepted it.
+var termsState struct {
+	sync.Mutex
+	current  *TermsVersion
+	loadedAt time.Time
+	accepted map[string]string // tenant to accepted version
+}
+
+// currentTerms returns the published version, or nil if none was
+// published.
+func currentTerms(ctx context.Context) (*TermsVersion, error) {
+	termsState.Lock()
+	defer termsState.Unlock()
+	if !termsState.loadedAt.IsZero() && time.Since(termsState.loadedAt) < termsCacheTTL {
+		return termsState.current, nil
+	}
+	var v TermsVersion
+	switch err := getJSON(ctx, config.MinIO.SystemBucket, termsCurrentKey, &v); {
+	case isNotFound(err):
+		termsState.current = nil
+	case err != nil:
+		return nil, err
+	default:
+		termsState.current = &v
+	}
+	termsState.loadedAt = time.Now()
+	return termsState.current, nil
+}
+
+// setCurrentTerms caches a version this instance published.
+func setCurrentTerms(v *TermsVersion) {
+	termsState.Lock()
+	defer termsState.Unlock()
+	termsState.current, termsState.loadedAt = v, time.Now()
+}
+
+func termsAcceptanceKey(tenant string) string {
+	return termsAcceptancePrefix + tenant + ".json"
+}
+
+func loadTermsAcceptances(ctx context.Context, tenant string) ([]TermsAcceptance, error) {
+	acceptances := []TermsAcceptance{}
+	if err := getJSON(ctx, config.MinIO.SystemBucket, termsAcceptanceKey(tenant), &acceptances); err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	return acceptances, nil
+}
+
+// hasAccepted reports whether tenant accepted version.
+func hasAccepted(ctx context.Context, tenant, version string) (bool, error) {
+	termsState.Lock()
+	cached := termsState.accepted[tenant] == version
+	termsState.Unlock()
+	if cached {
+		return true, nil
+	}
+	acceptances, err := loadTermsAcceptances(ctx, tenant)
+	if err != nil {
+		return false, err
+	}
+	for _, a := range acceptances {
+		if a.Version == version {
+			rememberAcceptance(tenant, version)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func rememberAcceptance(tenant, version string) {
+	termsState.Lock()
+	defer termsState.Unlock()
+	if termsState.accepted == nil {
+		termsState.accepted = map[string]string{}
+	}
+	termsState.accepted[tenant] = version
+}
+
+func errTermsNotAccepted(version string) error {
+	return codedError(http.StatusForbidden, "ERR_TERMS_NOT_ACCEPTED",
+		fmt.Sprintf("Accept terms version %s with POST /terms/accept before using chat", version))
+}
+
+// requireTermsAccepted checks the terms gate for a chat request. With the
+// gate enabled callers need a tenant token, and once terms are published
+// the tenant must have accepted the current version.
+func requireTermsAccepted(ctx context.Context, authorization string) error {
+	if !config.Terms.Required {
+		return nil
+	}
+	tenant, ok := storageTenant(authorization)
+	if !ok {
+		return huma.Error401Unauthorized("Chat requires a tenant token")
+	}
+	if _, err := services.MinIO(); err != nil {
+		return err
+	}
+	current, err := currentTerms(ctx)
+	if err != nil {
+		return huma.Error500InternalServerError("Failed to read the terms", err)
+	}
+	if current == nil {
+		return nil
+	}
+	accepted, err := hasAccepted(ctx, tenant.Name, current.Version)
+	if err != nil {
+		return huma.Error500InternalServerError("Failed to read terms acceptances", err)
+	}
+	if !accepted {
+		return errTermsNotAccepted(current.Version)
+	}
+	return nil
+}
+
+func registerTermsEndpoints(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "publish-terms",
+		Method:      http.MethodPut,
+		Path:        "/admin/terms",
+		Summary:     "Publish a terms version",
+		Description: "Make a new version of the terms or DPA current. Every tenant must accept it before using chat again. Published versions cannot be changed",
+	}, func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer admin token"`
+		Body          TermsVersion
+	}) (*struct {
+		Body TermsVersion
+	}, error) {
+		if err := requireAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+		if !termsVersionPattern.MatchString(input.Body.Version) {
+			return nil, huma.Error422UnprocessableEntity("Terms version may only contain letters, digits, '.', '_' and '-'")
+		}
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+		if err := ensureBucket(ctx, config.MinIO.SystemBucket); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to publish the terms", err)
+		}
+		key := termsVersionsPrefix + input.Body.Version + ".json"
+		if _, err := client.StatObject(ctx, config.MinIO.SystemBucket, key, minio.StatObjectOptions{}); err == nil {
+			return nil, huma.Error409Conflict(fmt.Sprintf("Terms version %s was already published", input.Body.Version))
+		} else if !isNotFound(err) {
+			return nil, huma.Error500InternalServerError("Failed to publish the terms", err)
+		}
+
+		v := input.Body
+		v.PublishedAt = time.Now().UTC()
+		if err := putJSON(ctx, config.MinIO.SystemBucket, key, v); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to publish the terms", err)
+		}
+		if err := putJSON(ctx, config.MinIO.SystemBucket, termsCurrentKey, v); err != nil {
+			return nil, huma.Error500InternalServerError("Failed to publish the terms", err)
+		}
+		setCurrentTerms(&v)
+		return &struct {
+			Body TermsVersion
+		}{Body: v}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-terms",
+		Method:      http.MethodGet,
+		Path:        "/terms",
+		Summary:     "Get the current terms",
+		Description: "Return the terms version tenants must accept",
+	}, func(ctx context.Context, input *struct{}) (*struct {
+		Body TermsVersion
+	}, error) {
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+		current, err := currentTerms(ctx)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to read the terms", err)
+		}
+		if current == nil {
+			return nil, huma.Error404NotFound("No terms have been published")
+		}
+		return &struct {
+			Body TermsVersion
+		}{Body: *current}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "accept-terms",
+		Method:      http.MethodPost,
+		Path:        "/terms/accept",
+		Summary:     "Accept the current terms",
+		Description: "Record that the calling tenant accepted the current terms version, with the time of acceptance",
+	}, func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer tenant token"`
+		Body          TermsAcceptRequest
+	}) (*struct {
+		Body TermsAcceptanceResponse
+	}, error) {
+		tenant, ok := storageTenant(input.Authorization)
+		if !ok {
+			return nil, huma.Error401Unauthorized("Invalid or missing tenant token")
+		}
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+		current, err := currentTerms(ctx)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to read the terms", err)
+		}
+		if current == nil {
+			return nil, huma.Error404NotFound("No terms have been published")
+		}
+		if input.Body.Version != current.Version {
+			return nil, huma.Error409Conflict(fmt.Sprintf("Terms version %s is not current; the current version is %s", input.Body.Version, current.Version))
+		}
+
+		acceptances, err := loadTermsAcceptances(ctx, tenant.Name)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to read terms acceptances", err)
+		}
+		if n := len(acceptances); n == 0 || acceptances[n-1].Version != current.Version {
+			acceptances = append(acceptances, TermsAcceptance{Version: current.Version, AcceptedAt: time.Now().UTC()})
+			if err := putJSON(ctx, config.MinIO.SystemBucket, termsAcceptanceKey(tenant.Name), acceptances); err != nil {
+				return nil, huma.Error500InternalServerError("Failed to record the acceptance", err)
+			}
+		}
+		rememberAcceptance(tenant.Name, current.Version)
+		return &struct {
+			Body TermsAcceptanceResponse
+		}{Body: TermsAcceptanceResponse{Tenant: tenant.Name, Current: current.Version, Acceptances: acceptances}}, nil
+	})
+}
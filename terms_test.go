@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestTermsGate(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "admin-token"
+	config.Storage.Tenants = []StorageTenantConfig{{Name: "acme", Token: "acme-token", Bucket: "tenants"}}
+	resetTerms := func() {
+		termsState.Lock()
+		termsState.current, termsState.loadedAt, termsState.accepted = nil, time.Time{}, nil
+		termsState.Unlock()
+	}
+	resetTerms()
+	t.Cleanup(resetTerms)
+
+	objects := map[string]string{}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+	services.SetOpenAI(newFakeOpenAIClient(0))
+	defer services.SetOpenAI(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerChatEndpoint(api)
+	registerTermsEndpoints(api)
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	chat := func(token string) *httptest.ResponseRecorder {
+		return call("POST", "/chat", token, `{"message": "Hi"}`)
+	}
+
+	if w := chat(""); w.Code != http.StatusOK {
+		t.Errorf("Expected chat to be open without the gate, got %d", w.Code)
+	}
+	config.Terms.Required = true
+	if w := chat(""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a tenant token, got %d", w.Code)
+	}
+	if w := chat("acme-token"); w.Code != http.StatusOK {
+		t.Errorf("Expected chat to be open before terms are published, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := call("PUT", "/admin/terms", "acme-token", `{"version": "v1"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected only the admin to publish terms, got %d", w.Code)
+	}
+	if w := call("PUT", "/admin/terms", "admin-token", `{"version": "v1", "title": "Terms of use"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the terms to be published, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call("PUT", "/admin/terms", "admin-token", `{"version": "v1"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected a published version to be immutable, got %d", w.Code)
+	}
+	if w := call("GET", "/terms", "", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"version":"v1"`) {
+		t.Errorf("Expected the current terms, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := chat("acme-token"); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "ERR_TERMS_NOT_ACCEPTED") {
+		t.Errorf("Expected chat to require accepting v1, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call("POST", "/terms/accept", "acme-token", `{"version": "v0"}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected accepting an old version to fail, got %d", w.Code)
+	}
+	if w := call("POST", "/terms/accept", "acme-token", `{"version": "v1"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the acceptance to be recorded, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(objects["app-system/terms/acceptances/acme.json"], `"version":"v1","accepted_at"`) {
+		t.Errorf("Expected the acceptance to be stored with its time, got %s", objects["app-system/terms/acceptances/acme.json"])
+	}
+	if w := chat("acme-token"); w.Code != http.StatusOK {
+		t.Errorf("Expected chat to be open after accepting, got %d", w.Code)
+	}
+
+	// A new version requires accepting again, also after a restart
+	call("PUT", "/admin/terms", "admin-token", `{"version": "v2"}`)
+	resetTerms()
+	if w := chat("acme-token"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected chat to require accepting v2, got %d", w.Code)
+	}
+	w := call("POST", "/terms/accept", "acme-token", `{"version": "v2"}`)
+	if w.Code != http.StatusOK || strings.Count(w.Body.String(), "accepted_at") != 2 {
+		t.Errorf("Expected both acceptances to be kept, got %d: %s", w.Code, w.Body.String())
+	}
+	resetTerms()
+	if w := chat("acme-token"); w.Code != http.StatusOK {
+		t.Errorf("Expected the stored acceptance to open chat, got %d", w.Code)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// States of a listed version compared to the bucket now.
+const (
+	versionCurrent  = "current"
+	versionReplaced = "replaced"
+	versionDeleted  = "deleted"
+)
+
+type BucketSnapshotEntry struct {
+	Name         string    `json:"name" doc:"Object name"`
+	VersionID    string    `json:"version_id" doc:"Version that was current at the time, e.g. to compare with POST /files/diff"`
+	Size         int64     `json:"size" doc:"Size of that version in bytes"`
+	LastModified time.Time `json:"last_modified" doc:"When that version was written"`
+	ETag         string    `json:"etag,omitempty" doc:"ETag of that version"`
+	Now          string    `json:"now" enum:"current,replaced,deleted" doc:"Whether the version is still current, was replaced by a newer one, or the object has been deleted since"`
+}
+
+type BucketSnapshotResponse struct {
+	Bucket  string                `json:"bucket" doc:"Bucket name"`
+	AsOf    time.Time             `json:"as_of" doc:"Time the listing reconstructs"`
+	Objects []BucketSnapshotEntry `json:"objects" doc:"Objects that existed at the time, by name"`
+}
+
+// objectVersionAt is the version of one object current at a time, and its
+// latest version now.
+type objectVersionAt struct {
+	at     *minio.ObjectInfo
+	latest *minio.ObjectInfo
+}
+
+// bucketSnapshot reconstructs from the object versions which objects under
+// prefix existed in a versioned bucket at asOf, and which version of each.
+// Objects deleted by then, folder markers and the trash are left out.
+func bucketSnapshot(ctx context.Context, client *minio.Client, bucket, prefix string, asOf time.Time) ([]BucketSnapshotEntry, error) {
+	versions := map[string]*objectVersionAt{}
+	for obj := range client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true, WithVersions: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		if strings.HasSuffix(obj.Key, "/") || strings.HasPrefix(obj.Key, trashPrefix) {
+			continue
+		}
+		v := versions[obj.Key]
+		if v == nil {
+			v = &objectVersionAt{}
+			versions[obj.Key] = v
+		}
+		if obj.IsLatest {
+			v.latest = &obj
+		}
+		if !obj.LastModified.After(asOf) && (v.at == nil || obj.LastModified.After(v.at.LastModified)) {
+			v.at = &obj
+		}
+	}
+
+	entries := []BucketSnapshotEntry{}
+	for name, v := range versions {
+		if v.at == nil || v.at.IsDeleteMarker {
+			continue
+		}
+		entry := BucketSnapshotEntry{
+			Name:         name,
+			VersionID:    v.at.VersionID,
+			Size:         v.at.Size,
+			LastModified: v.at.LastModified,
+			ETag:         strings.Trim(v.at.ETag, `"`),
+			Now:          versionReplaced,
+		}
+		switch {
+		case v.latest == nil || v.latest.IsDeleteMarker:
+			entry.Now = versionDeleted
+		case v.latest.VersionID == v.at.VersionID:
+			entry.Now = versionCurrent
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+func registerBucketSnapshotEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "list-files-as-of",
+		Method:      http.MethodGet,
+		Path:        "/files/{bucket}",
+		Summary:     "List a bucket as it was",
+		Description: "Reconstruct from object versions which files existed in a versioned bucket at a point in time, and which version of each, for audits and investigating deletions. Admin only",
+	}, func(ctx context.Context, input *struct {
+		Bucket        string    `path:"bucket" doc:"MinIO bucket name"`
+		AsOf          time.Time `query:"as_of" required:"true" doc:"Time to list the bucket at, in RFC 3339 format"`
+		Prefix        string    `query:"prefix" doc:"Only list objects whose names start with this"`
+		Authorization string    `header:"Authorization" doc:"Bearer admin token"`
+	}) (*struct {
+		Body BucketSnapshotResponse
+	}, error) {
+		if err := requireAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+		if input.AsOf.After(time.Now()) {
+			return nil, huma.Error422UnprocessableEntity("as_of must not be in the future")
+		}
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+
+		versioning, err := client.GetBucketVersioning(ctx, input.Bucket)
+		if err != nil {
+			if isNotFound(err) {
+				return nil, errBucketNotFound(input.Bucket)
+			}
+			return nil, huma.Error500InternalServerError("Failed to read bucket versioning", err)
+		}
+		// Suspended buckets keep the versions written while it was enabled
+		if !versioning.Enabled() && !versioning.Suspended() {
+			return nil, codedError(http.StatusConflict, "ERR_VERSIONING_DISABLED", fmt.Sprintf("Bucket %s is not versioned, so its past state is unknown", input.Bucket))
+		}
+
+		entries, err := bucketSnapshot(ctx, client, input.Bucket, input.Prefix, input.AsOf)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Failed to list object versions", err)
+		}
+
+		return &struct {
+			Body BucketSnapshotResponse
+		}{
+			Body: BucketSnapshotResponse{Bucket: input.Bucket, AsOf: input.AsOf, Objects: entries},
+		}, nil
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+// fakeVersionedS3 serves the versioning status of every bucket and a fixed
+// listing of object versions, newest first per key as S3 lists them.
+func fakeVersionedS3(status *string, versions []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case query.Has("location"):
+			w.Write([]byte(`<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-east-1</LocationConstraint>`))
+		case query.Has("versioning"):
+			fmt.Fprintf(w, `<VersioningConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">%s</VersioningConfiguration>`, *status)
+		case query.Has("versions"):
+			fmt.Fprint(w, `<ListVersionsResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>docs</Name><IsTruncated>false</IsTruncated>`)
+			for _, v := range versions {
+				if strings.Contains(v, "<Key>"+query.Get("prefix")) {
+					fmt.Fprint(w, v)
+				}
+			}
+			fmt.Fprint(w, `</ListVersionsResult>`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func TestBucketSnapshot(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "admin-token"
+
+	version := func(key, id, modified string, latest bool) string {
+		return fmt.Sprintf(`<Version><Key>%s</Key><VersionId>%s</VersionId><IsLatest>%t</IsLatest><LastModified>%s</LastModified><ETag>"%s"</ETag><Size>10</Size></Version>`, key, id, latest, modified, id)
+	}
+	deleteMarker := func(key, id, modified string, latest bool) string {
+		return fmt.Sprintf(`<DeleteMarker><Key>%s</Key><VersionId>%s</VersionId><IsLatest>%t</IsLatest><LastModified>%s</LastModified></DeleteMarker>`, key, id, latest, modified)
+	}
+	versions := []string{
+		// Trashed, so left out
+		version(".trash/report.txt", "t1", "2026-03-01T00:00:00.000Z", true),
+		version("notes/", "f1", "2026-01-01T00:00:00.000Z", true),
+		version("notes/a.txt", "a2", "2026-02-15T00:00:00.000Z", true),
+		version("notes/a.txt", "a1", "2026-01-10T00:00:00.000Z", false),
+		deleteMarker("report.txt", "r3", "2026-03-01T00:00:00.000Z", true),
+		version("report.txt", "r1", "2026-01-05T00:00:00.000Z", false),
+		deleteMarker("scratch.txt", "s2", "2026-01-25T00:00:00.000Z", true),
+		version("scratch.txt", "s1", "2026-01-20T00:00:00.000Z", false),
+		version("summary.txt", "u1", "2026-02-20T00:00:00.000Z", true),
+		version("unchanged.txt", "c1", "2026-01-02T00:00:00.000Z", true),
+	}
+
+	status := "<Status>Enabled</Status>"
+	s3 := httptest.NewServer(fakeVersionedS3(&status, versions))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerBucketSnapshotEndpoint(api)
+	list := func(query, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/files/docs?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := list("as_of=2026-02-01T00:00:00Z", "admin-token")
+	var snapshot BucketSnapshotResponse
+	json.Unmarshal(w.Body.Bytes(), &snapshot)
+	got := []string{}
+	for _, o := range snapshot.Objects {
+		got = append(got, o.Name+"@"+o.VersionID+":"+o.Now)
+	}
+	if want := "notes/a.txt@a1:replaced report.txt@r1:deleted unchanged.txt@c1:current"; w.Code != http.StatusOK || strings.Join(got, " ") != want {
+		t.Errorf("Expected %s, got %d: %s", want, w.Code, strings.Join(got, " "))
+	}
+
+	w = list("as_of=2026-02-25T00:00:00Z&prefix=notes/", "admin-token")
+	json.Unmarshal(w.Body.Bytes(), &snapshot)
+	if len(snapshot.Objects) != 1 || snapshot.Objects[0].VersionID != "a2" || snapshot.Objects[0].Now != versionCurrent {
+		t.Errorf("Expected the newer version of notes/a.txt, got %s", w.Body.String())
+	}
+
+	if w := list("as_of=2026-02-01T00:00:00Z", "tenant-token"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the listing to be admin only, got %d", w.Code)
+	}
+	if w := list("as_of=2999-01-01T00:00:00Z", "admin-token"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected a future time to be rejected, got %d", w.Code)
+	}
+	status = ""
+	if w := list("as_of=2026-02-01T00:00:00Z", "admin-token"); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "ERR_VERSIONING_DISABLED") {
+		t.Errorf("Expected an unversioned bucket to be refused, got %d: %s", w.Code, w.Body.String())
+	}
+}
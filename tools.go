@@ -0,0 +1,195 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ChatToolsConfig bounds the tool loop of POST /chat.
+type ChatToolsConfig struct {
+	MaxRounds      int `mapstructure:"max_rounds" doc:"Most server-side tool calls one chat request may run before the model has to answer"`
+	MaxResultBytes int `mapstructure:"max_result_bytes" doc:"Longest tool result sent back to the model; longer results are cut off"`
+}
+
+func (c ChatToolsConfig) Validate() error {
+	if c.MaxRounds <= 0 || c.MaxResultBytes <= 0 {
+		return errors.New("chat_tools.max_rounds and chat_tools.max_result_bytes must be positive")
+	}
+	return nil
+}
+
+// ChatFunction is a function the client declares and runs itself.
+type ChatFunction struct {
+	Name        string         `json:"name" pattern:"^[a-zA-Z0-9_-]{1,64}$" doc:"Function name the model calls it by"`
+	Description string         `json:"description,omitempty" doc:"What the function does, to help the model decide when to call it"`
+	Parameters  map[string]any `json:"parameters,omitempty" doc:"JSON schema of the function's arguments"`
+}
+
+// ChatFunctionCall is a call the model made.
+type ChatFunctionCall struct {
+	Name      string `json:"name" doc:"Function the model calls"`
+	Arguments string `json:"arguments" doc:"Arguments as a JSON object, as written by the model"`
+}
+
+// ChatToolInvocation records one server-side tool call of the loop.
+type ChatToolInvocation struct {
+	Name      string `json:"name" doc:"Tool the model called"`
+	Arguments string `json:"arguments" doc:"Arguments the model passed"`
+	Result    string `json:"result,omitempty" doc:"Result sent back to the model"`
+	Error     string `json:"error,omitempty" doc:"Why the call failed; the model was told as well"`
+}
+
+// serverTool is a tool the service runs for the model.
+type serverTool struct {
+	description string
+	parameters  map[string]any
+	run         func(ctx context.Context, args json.RawMessage) (string, error)
+}
+
+var serverTools = map[string]serverTool{
+	"current_time": {
+		description: "Get the current date and time in UTC",
+		parameters:  map[string]any{"type": "object", "properties": map[string]any{}},
+		run: func(context.Context, json.RawMessage) (string, error) {
+			return time.Now().UTC().Format(time.RFC3339), nil
+		},
+	},
+	"read_file": {
+		description: "Read a text file stored in MinIO",
+		parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"bucket": map[string]any{"type": "string", "description": "Bucket of the file"},
+				"name":   map[string]any{"type": "string", "description": "Object name of the file"},
+			},
+			"required": []string{"bucket", "name"},
+		},
+		run: readFileTool,
+	},
+}
+
+type toolCallerKey struct{}
+
+// withToolCaller sets who tools read stored objects for, so their ACLs apply
+// as on GET /files.
+func withToolCaller(ctx context.Context, c aclCaller) context.Context {
+	return context.WithValue(ctx, toolCallerKey{}, c)
+}
+
+func readFileTool(ctx context.Context, args json.RawMessage) (string, error) {
+	var a struct {
+		Bucket string `json:"bucket"`
+		Name   string `json:"name"`
+	}
+	if err := json.Unmarshal(args, &a); err != nil || a.Bucket == "" || a.Name == "" {
+		return "", errors.New("bucket and name are required")
+	}
+	if err := checkBucketAccess(a.Bucket); err != nil {
+		return "", err
+	}
+	caller, _ := ctx.Value(toolCallerKey{}).(aclCaller)
+	if err := checkObjectACL(ctx, a.Bucket, a.Name, caller); err != nil {
+		return "", err
+	}
+	return readTextObject(ctx, a.Bucket, a.Name, "", int64(config.ChatTools.MaxResultBytes))
+}
+
+// checkChatTools validates the tools and functions of a chat request.
+func checkChatTools(req ChatRequest) error {
+	if len(req.Tools) == 0 && len(req.Functions) == 0 {
+		return nil
+	}
+	if req.N > 1 {
+		return huma.Error422UnprocessableEntity("Tools and functions support only one reply; leave out n or set it to 1")
+	}
+	seen := map[string]bool{}
+	for _, f := range req.Functions {
+		if _, ok := serverTools[f.Name]; ok || seen[f.Name] {
+			return huma.Error422UnprocessableEntity(fmt.Sprintf("Function %s is declared twice or is the name of a server-side tool", f.Name))
+		}
+		seen[f.Name] = true
+	}
+	return nil
+}
+
+// functionDefinitions lists the enabled server-side tools and the client's
+// functions for OpenAI.
+func (r ChatRequest) functionDefinitions() []openai.FunctionDefinition {
+	var defs []openai.FunctionDefinition
+	for _, name := range r.Tools {
+		if t, ok := serverTools[name]; ok {
+			defs = append(defs, openai.FunctionDefinition{Name: name, Description: t.description, Parameters: t.parameters})
+		}
+	}
+	for _, f := range r.Functions {
+		params := f.Parameters
+		if params == nil {
+			params = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		defs = append(defs, openai.FunctionDefinition{Name: f.Name, Description: f.Description, Parameters: params})
+	}
+	return defs
+}
+
+// runChatTools sends cr to OpenAI and, while the model calls server-side
+// tools, runs them and sends their results back. It returns the response
+// that answers, or that calls one of the client's functions, along with a
+// trace of the tools run. After chat_tools.max_rounds calls the model has to
+// answer. The response's usage is summed over all rounds.
+func runChatTools(ctx context.Context, provider ChatProvider, req ChatRequest, cr openai.ChatCompletionRequest) (openai.ChatCompletionResponse, []ChatToolInvocation, error) {
+	cr.Functions = req.functionDefinitions()
+	var trace []ChatToolInvocation
+	var usage openai.Usage
+	for round := 0; ; round++ {
+		if len(cr.Functions) > 0 && round == config.ChatTools.MaxRounds {
+			cr.FunctionCall = "none"
+		}
+		resp, err := createChatCompletion(ctx, provider, cr)
+		if err != nil {
+			return resp, trace, err
+		}
+		recordTokenUsage(ctx, cmp.Or(resp.Model, cr.Model), resp.Usage)
+		usage.PromptTokens += resp.Usage.PromptTokens
+		usage.CompletionTokens += resp.Usage.CompletionTokens
+		usage.TotalTokens += resp.Usage.TotalTokens
+		resp.Usage = usage
+		if len(resp.Choices) == 0 || resp.Choices[0].Message.FunctionCall == nil || round >= config.ChatTools.MaxRounds {
+			return resp, trace, nil
+		}
+		call := *resp.Choices[0].Message.FunctionCall
+		if slices.ContainsFunc(req.Functions, func(f ChatFunction) bool { return f.Name == call.Name }) {
+			return resp, trace, nil
+		}
+
+		inv := ChatToolInvocation{Name: call.Name, Arguments: call.Arguments}
+		result, err := "", fmt.Errorf("unknown function %s", call.Name)
+		if t, ok := serverTools[call.Name]; ok && slices.Contains(req.Tools, call.Name) {
+			result, err = t.run(ctx, json.RawMessage(call.Arguments))
+		}
+		if err != nil {
+			// The model is told, so it can try differently or explain
+			inv.Error = err.Error()
+			result = "Error: " + err.Error()
+		} else {
+			if len(result) > config.ChatTools.MaxResultBytes {
+				result = strings.ToValidUTF8(result[:config.ChatTools.MaxResultBytes], "")
+			}
+			inv.Result = result
+		}
+		trace = append(trace, inv)
+		cr.Messages = append(cr.Messages, resp.Choices[0].Message, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleFunction,
+			Name:    call.Name,
+			Content: result,
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+func TestChatTools(t *testing.T) {
+	viper.Reset()
+	initConfig()
+
+	objects := map[string]string{"docs/notes.txt": "The launch is on Friday."}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	store, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(store)
+	defer services.SetMinIO(nil)
+
+	// The fake model calls whatever the last user message names, then
+	// answers with the last function result
+	var requests []openai.ChatCompletionRequest
+	ai := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+		last := req.Messages[len(req.Messages)-1]
+		msg := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant}
+		switch {
+		case last.Role == openai.ChatMessageRoleFunction || req.FunctionCall == "none":
+			msg.Content = "Answer: " + last.Content
+		case strings.HasPrefix(last.Content, "call "):
+			name, args, _ := strings.Cut(strings.TrimPrefix(last.Content, "call "), " ")
+			msg.FunctionCall = &openai.FunctionCall{Name: name, Arguments: args}
+		default:
+			msg.Content = "No tools needed"
+		}
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Model:   "gpt-3.5-turbo-0613",
+			Choices: []openai.ChatCompletionChoice{{Message: msg}},
+			Usage:   openai.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+		})
+	}))
+	defer ai.Close()
+	services.SetOpenAI(newTestOpenAIClient(ai.URL))
+	defer services.SetOpenAI(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerChatEndpoint(api)
+	registerChatStreamEndpoint(api)
+	call := func(path, body string) (*httptest.ResponseRecorder, ChatResponse) {
+		requests = nil
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp ChatResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+
+	w, resp := call("/chat", `{"message": "call read_file {\"bucket\": \"docs\", \"name\": \"notes.txt\"}", "tools": ["read_file", "current_time"]}`)
+	if w.Code != http.StatusOK || resp.Reply != "Answer: The launch is on Friday." {
+		t.Fatalf("Expected an answer from the file, got %d: %s", w.Code, w.Body.String())
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].Name != "read_file" || resp.ToolCalls[0].Result != "The launch is on Friday." {
+		t.Errorf("Expected the read to be traced, got %+v", resp.ToolCalls)
+	}
+	if len(requests) != 2 || len(requests[0].Functions) != 2 || requests[1].Messages[len(requests[1].Messages)-1].Name != "read_file" {
+		t.Errorf("Expected the tools to be declared and the result sent back, got %+v", requests)
+	}
+	if resp.Model != "gpt-3.5-turbo-0613" || resp.Usage != (ChatUsage{PromptTokens: 20, CompletionTokens: 10, TotalTokens: 30}) {
+		t.Errorf("Expected the model and the usage of both rounds, got %s %+v", resp.Model, resp.Usage)
+	}
+
+	_, resp = call("/chat", `{"message": "call read_file {\"bucket\": \"docs\", \"name\": \"missing.txt\"}", "tools": ["read_file"]}`)
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].Error == "" || !strings.HasPrefix(resp.Reply, "Answer: Error: ") {
+		t.Errorf("Expected the failed read to be reported to the model, got %+v", resp)
+	}
+
+	_, resp = call("/chat", `{"message": "call current_time {}"}`)
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].Error != "unknown function current_time" {
+		t.Errorf("Expected tools that are not enabled to be refused, got %+v", resp)
+	}
+
+	w, resp = call("/chat", `{"message": "call get_weather {\"city\": \"Oslo\"}", "functions": [{"name": "get_weather", "parameters": {"type": "object"}}]}`)
+	if w.Code != http.StatusOK || resp.FunctionCall == nil || resp.FunctionCall.Name != "get_weather" || resp.FunctionCall.Arguments != `{"city": "Oslo"}` || len(requests) != 1 {
+		t.Errorf("Expected the client function call to be returned, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w, resp = call("/chat", `{"message": "", "functions": [{"name": "get_weather"}], "history": [
+		{"role": "user", "content": "call get_weather {}"},
+		{"role": "assistant", "content": "", "function_call": {"name": "get_weather", "arguments": "{}"}},
+		{"role": "function", "name": "get_weather", "content": "Sunny"}]}`)
+	if w.Code != http.StatusOK || resp.Reply != "Answer: Sunny" || requests[0].Messages[len(requests[0].Messages)-2].FunctionCall == nil {
+		t.Errorf("Expected the model to continue from the function result, got %d: %s", w.Code, w.Body.String())
+	}
+
+	config.ChatTools.MaxRounds = 1
+	_, resp = call("/chat", `{"message": "call current_time {}", "tools": ["current_time"]}`)
+	if len(resp.ToolCalls) != 1 || len(requests) != 2 || requests[1].FunctionCall != "none" {
+		t.Errorf("Expected the model to have to answer after one round, got %+v", resp)
+	}
+
+	for path, body := range map[string]string{
+		"/chat":        `{"message": "Hi", "functions": [{"name": "read_file"}]}`,
+		"/chat/stream": `{"message": "Hi", "tools": ["current_time"]}`,
+	} {
+		if w, _ := call(path, body); w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected %s to %s to be rejected, got %d", body, path, w.Code)
+		}
+	}
+	if w, _ := call("/chat", `{"message": "Hi", "tools": ["shell"]}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected an unknown tool to be rejected, got %d", w.Code)
+	}
+}
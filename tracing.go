@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+var (
+	// traceparentPattern matches a W3C trace context traceparent header.
+	traceparentPattern = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-([0-9a-f]{2})$`)
+	requestIDPattern   = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+)
+
+// upstreamRequest identifies a request made to an upstream service.
+type upstreamRequest struct {
+	Service string
+	ID      string
+}
+
+// traceInfo is the trace context of an incoming request. It is carried in
+// the request context and copied onto calls to OpenAI and MinIO.
+type traceInfo struct {
+	RequestID  string
+	TraceID    string
+	Flags      string
+	TraceState string
+
+	mu       sync.Mutex
+	upstream []upstreamRequest
+}
+
+type traceKey struct{}
+
+func withTrace(ctx context.Context, t *traceInfo) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+// traceFromContext returns the trace of the current request, or nil
+// outside of a request (e.g. in background jobs).
+func traceFromContext(ctx context.Context) *traceInfo {
+	t, _ := ctx.Value(traceKey{}).(*traceInfo)
+	return t
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newTrace builds the trace for an incoming request, continuing the
+// caller's correlation ID and W3C trace if they sent valid ones.
+func newTrace(r *http.Request) *traceInfo {
+	t := &traceInfo{
+		RequestID: r.Header.Get(requestIDHeader),
+		TraceID:   randomHex(16),
+		Flags:     "01",
+	}
+	if !requestIDPattern.MatchString(t.RequestID) {
+		t.RequestID = randomHex(16)
+	}
+	if m := traceparentPattern.FindStringSubmatch(r.Header.Get("traceparent")); m != nil && m[1] != strings.Repeat("0", 32) {
+		t.TraceID, t.Flags = m[1], m[2]
+		t.TraceState = r.Header.Get("tracestate")
+	}
+	return t
+}
+
+// traceparent returns a traceparent header for a new outgoing span.
+func (t *traceInfo) traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%s", t.TraceID, randomHex(8), t.Flags)
+}
+
+func (t *traceInfo) addUpstream(service, id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.upstream = append(t.upstream, upstreamRequest{Service: service, ID: id})
+}
+
+// lastUpstream returns the ID of the most recent request to service.
+func (t *traceInfo) lastUpstream(service string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := len(t.upstream) - 1; i >= 0; i-- {
+		if t.upstream[i].Service == service {
+			return t.upstream[i].ID
+		}
+	}
+	return ""
+}
+
+// tracingMiddleware attaches a trace to every request and echoes the
+// correlation ID in the X-Request-ID response header.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := newTrace(r)
+		w.Header().Set(requestIDHeader, t.RequestID)
+		next.ServeHTTP(w, r.WithContext(withTrace(r.Context(), t)))
+	})
+}
+
+// tracingTransport propagates the trace of the request context to an
+// upstream service and remembers the request ID the service returns in
+// idHeader.
+type tracingTransport struct {
+	base     http.RoundTripper
+	service  string
+	idHeader string
+}
+
+func (tr *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t := traceFromContext(req.Context())
+	if t == nil {
+		return tr.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set(requestIDHeader, t.RequestID)
+	req.Header.Set("X-Client-Request-Id", t.RequestID)
+	req.Header.Set("traceparent", t.traceparent())
+	if t.TraceState != "" {
+		req.Header.Set("tracestate", t.TraceState)
+	}
+
+	resp, err := tr.base.RoundTrip(req)
+	if err == nil {
+		if id := resp.Header.Get(tr.idHeader); id != "" {
+			t.addUpstream(tr.service, id)
+		}
+	}
+	return resp, err
+}
+
+// openAIError logs a failed OpenAI call together with the IDs support
+// needs to escalate it, and returns an error carrying the OpenAI request ID.
+// OpenAI rate limits are passed on to the client as such.
+func openAIError(ctx context.Context, msg string, err error) error {
+	status, code := http.StatusInternalServerError, "ERR_OPENAI_FAILED"
+	switch {
+	case openAIStatusCode(err) == http.StatusTooManyRequests:
+		status, code = http.StatusTooManyRequests, "ERR_OPENAI_RATE_LIMIT"
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		// The operation's timeout passed
+		status, code = http.StatusGatewayTimeout, "ERR_TIMEOUT"
+	}
+
+	if t := traceFromContext(ctx); t != nil {
+		upstream := t.lastUpstream("openai")
+		log.Printf("OpenAI request failed (request %s, OpenAI request %s): %v", t.RequestID, upstream, err)
+		if upstream != "" {
+			msg = fmt.Sprintf("%s (OpenAI request ID %s)", msg, upstream)
+		}
+	}
+	return codedError(status, code, msg, err)
+}
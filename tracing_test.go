@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	var got *traceInfo
+	handler := tracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = traceFromContext(r.Context())
+	}))
+
+	// Incoming correlation ID and trace are continued
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set(requestIDHeader, "abc-123")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "vendor=value")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Header().Get(requestIDHeader) != "abc-123" {
+		t.Errorf("Expected request ID to be echoed, got %q", w.Header().Get(requestIDHeader))
+	}
+	if got == nil || got.RequestID != "abc-123" || got.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || got.TraceState != "vendor=value" {
+		t.Errorf("Unexpected trace: %+v", got)
+	}
+
+	// Invalid values are replaced
+	req = httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set(requestIDHeader, "bad id\n")
+	req.Header.Set("traceparent", "garbage")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if got.RequestID == "bad id\n" || len(got.RequestID) != 32 || len(got.TraceID) != 32 {
+		t.Errorf("Expected generated IDs, got %+v", got)
+	}
+}
+
+func TestTracingTransport(t *testing.T) {
+	var headers http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+		w.Header().Set("X-Request-Id", "req_upstream")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: &tracingTransport{base: http.DefaultTransport, service: "openai", idHeader: "X-Request-Id"}}
+	trace := &traceInfo{RequestID: "abc-123", TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", Flags: "01"}
+	ctx := withTrace(context.Background(), trace)
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, upstream.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if headers.Get(requestIDHeader) != "abc-123" || headers.Get("X-Client-Request-Id") != "abc-123" {
+		t.Errorf("Expected correlation ID headers, got %v", headers)
+	}
+	if m := traceparentPattern.FindStringSubmatch(headers.Get("traceparent")); m == nil || m[1] != trace.TraceID {
+		t.Errorf("Expected traceparent continuing the trace, got %q", headers.Get("traceparent"))
+	}
+	if trace.lastUpstream("openai") != "req_upstream" {
+		t.Errorf("Expected upstream request ID to be recorded, got %+v", trace.upstream)
+	}
+
+	err = openAIError(ctx, "Failed to get OpenAI response", errors.New("rate limited"))
+	if !strings.Contains(err.Error(), "req_upstream") {
+		t.Errorf("Expected error to carry the OpenAI request ID, got %q", err.Error())
+	}
+}
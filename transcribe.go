@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+	"github.com/sashabaranov/go-openai"
+)
+
+// TranscriptionRequest names the audio to transcribe and where to store
+// the transcript. Uploads send the same fields as multipart form values
+// next to the file.
+type TranscriptionRequest struct {
+	Bucket      string `json:"bucket,omitempty" doc:"Bucket of a stored audio object to transcribe instead of an upload"`
+	Name        string `json:"name,omitempty" doc:"Name of the stored audio object"`
+	Language    string `json:"language,omitempty" doc:"ISO-639-1 language of the audio, e.g. en; detected when empty"`
+	Prompt      string `json:"prompt,omitempty" doc:"Text guiding the spelling and style of the transcript"`
+	StoreBucket string `json:"store_bucket,omitempty" doc:"Bucket to store the transcript in as a text file"`
+	StoreName   string `json:"store_name,omitempty" doc:"Object name of the stored transcript; defaults to the audio's name with a .txt extension"`
+}
+
+type StoredTranscript struct {
+	Bucket string `json:"bucket" doc:"Bucket the transcript was stored in"`
+	Name   string `json:"name" doc:"Object name of the transcript"`
+	Size   int64  `json:"size" doc:"Stored size in bytes"`
+}
+
+type TranscriptionResponse struct {
+	Text   string            `json:"text" doc:"Transcript of the audio"`
+	Stored *StoredTranscript `json:"stored,omitempty" doc:"Where the transcript was stored, if requested"`
+}
+
+// transcribeInput reads either a multipart upload or a JSON reference to a
+// stored object. huma has no body type accepting both, so the body is
+// parsed by a resolver like PUT /files does.
+type transcribeInput struct {
+	Authorization string `header:"Authorization" doc:"Bearer tenant or admin token; needed to read objects with a private ACL"`
+
+	req  TranscriptionRequest
+	file *multipart.FileHeader
+	err  error
+}
+
+// maxFormOverhead is the room left for the form fields and part headers of
+// an upload.
+const maxFormOverhead = 64 << 10
+
+func errAudioTooLarge() error {
+	return huma.NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("Audio is larger than %d bytes", config.Limits.MaxAudioBytes))
+}
+
+func (i *transcribeInput) Resolve(ctx huma.Context) []error {
+	mediaType, _, _ := mime.ParseMediaType(ctx.Header("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		if err := json.NewDecoder(io.LimitReader(ctx.BodyReader(), maxFormOverhead)).Decode(&i.req); err != nil {
+			return []error{&huma.ErrorDetail{Location: "body", Message: "Expected a JSON object or a multipart form: " + err.Error()}}
+		}
+		return nil
+	}
+
+	// Forms are spooled to disk by the parser, so bound them first. The
+	// handler reports the problem, as resolver errors are always a 422.
+	n, err := strconv.ParseInt(ctx.Header("Content-Length"), 10, 64)
+	switch {
+	case err != nil:
+		i.err = huma.NewError(http.StatusLengthRequired, "Content-Length is required")
+		return nil
+	case n > config.Limits.MaxAudioBytes+maxFormOverhead:
+		i.err = errAudioTooLarge()
+		return nil
+	}
+	form, err := ctx.GetMultipartForm()
+	if err != nil {
+		return []error{&huma.ErrorDetail{Location: "body", Message: "Cannot read multipart form: " + err.Error()}}
+	}
+	value := func(key string) string {
+		if v := form.Value[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	i.req = TranscriptionRequest{
+		Bucket:      value("bucket"),
+		Name:        value("name"),
+		Language:    value("language"),
+		Prompt:      value("prompt"),
+		StoreBucket: value("store_bucket"),
+		StoreName:   value("store_name"),
+	}
+	if files := form.File["file"]; len(files) > 0 {
+		i.file = files[0]
+	}
+	return nil
+}
+
+// readAudio returns the audio of the request, its file name and, for
+// stored objects, its version.
+func readAudio(ctx context.Context, input *transcribeInput) (audio []byte, name, version string, err error) {
+	limit := config.Limits.MaxAudioBytes
+	req := input.req
+	switch {
+	case input.file != nil && req.Bucket != "":
+		return nil, "", "", huma.Error422UnprocessableEntity("Send either a file or bucket and name, not both")
+	case input.file != nil:
+		if input.file.Size > limit {
+			return nil, "", "", errAudioTooLarge()
+		}
+		f, err := input.file.Open()
+		if err != nil {
+			return nil, "", "", huma.Error400BadRequest("Cannot read the uploaded file", err)
+		}
+		defer f.Close()
+		audio, err = io.ReadAll(f)
+		if err != nil {
+			return nil, "", "", huma.Error400BadRequest("Cannot read the uploaded file", err)
+		}
+		return audio, path.Base(input.file.Filename), "", nil
+	case req.Bucket == "" || req.Name == "":
+		return nil, "", "", huma.Error422UnprocessableEntity("Send a file, or bucket and name of a stored audio object")
+	}
+
+	client, err := services.MinIOReader()
+	if err != nil {
+		return nil, "", "", err
+	}
+	if err := checkBucketAccess(req.Bucket); err != nil {
+		return nil, "", "", err
+	}
+	if err := checkObjectACL(ctx, req.Bucket, req.Name, objectCaller(input.Authorization)); err != nil {
+		return nil, "", "", err
+	}
+	obj, err := client.GetObject(ctx, req.Bucket, req.Name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, "", "", huma.Error500InternalServerError("Failed to get object", err)
+	}
+	defer obj.Close()
+	info, err := obj.Stat()
+	if err != nil {
+		if isNotFound(err) {
+			return nil, "", "", errObjectNotFound(req.Bucket, req.Name)
+		}
+		return nil, "", "", huma.Error500InternalServerError("Failed to stat object", err)
+	}
+	if info.Size > limit {
+		return nil, "", "", errAudioTooLarge()
+	}
+	audio, err = io.ReadAll(obj)
+	if err != nil {
+		return nil, "", "", huma.Error500InternalServerError("Failed to read object", err)
+	}
+	return audio, path.Base(req.Name), info.VersionID, nil
+}
+
+func registerTranscribeEndpoint(api huma.API) {
+	huma.Register(api, withRoutePolicy(huma.Operation{
+		OperationID: "transcribe-audio",
+		Method:      http.MethodPost,
+		Path:        "/transcribe",
+		Summary:     "Transcribe audio",
+		Description: "Transcribe an uploaded audio file, or an audio object stored in MinIO, with Whisper and optionally store the transcript",
+		RequestBody: &huma.RequestBody{
+			Description: "A multipart form with the audio as file, or a JSON reference to a stored object",
+			Required:    true,
+			Content: map[string]*huma.MediaType{
+				"multipart/form-data": {Schema: &huma.Schema{
+					Type: huma.TypeObject,
+					Properties: map[string]*huma.Schema{
+						"file":         {Type: huma.TypeString, Format: "binary", Description: "Audio file, e.g. mp3, m4a, wav or webm; the extension tells Whisper the format"},
+						"language":     {Type: huma.TypeString},
+						"prompt":       {Type: huma.TypeString},
+						"store_bucket": {Type: huma.TypeString},
+						"store_name":   {Type: huma.TypeString},
+					},
+				}},
+				"application/json": {Schema: api.OpenAPI().Components.Schemas.Schema(reflect.TypeOf(TranscriptionRequest{}), true, "")},
+			},
+		},
+	}, RoutePolicy{Timeout: 5 * time.Minute, RateClass: "generation", Budgeted: true}), func(ctx context.Context, input *transcribeInput) (*struct {
+		Body TranscriptionResponse
+	}, error) {
+		if input.err != nil {
+			return nil, input.err
+		}
+		if err := requireTermsAccepted(ctx, input.Authorization); err != nil {
+			return nil, err
+		}
+		ai, err := openAIClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req := input.req
+		if req.StoreBucket != "" {
+			if _, err := services.MinIO(); err != nil {
+				return nil, err
+			}
+			if err := checkBucketAccess(req.StoreBucket); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := policyModel(ctx, openai.Whisper1); err != nil {
+			return nil, err
+		}
+
+		audio, name, version, err := readAudio(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := ai.CreateTranscription(ctx, openai.AudioRequest{
+			Model:    openai.Whisper1,
+			FilePath: name,
+			Reader:   bytes.NewReader(audio),
+			Prompt:   req.Prompt,
+			Language: req.Language,
+		})
+		logPrompt(ctx, "transcribe", req, resp.Text, err)
+		if err != nil {
+			return nil, openAIError(ctx, "Failed to transcribe the audio", err)
+		}
+
+		out := TranscriptionResponse{Text: resp.Text}
+		if req.StoreBucket != "" {
+			if out.Stored, err = storeTranscript(ctx, req, name, version, audio, resp.Text); err != nil {
+				return nil, err
+			}
+		}
+		return &struct {
+			Body TranscriptionResponse
+		}{Body: out}, nil
+	})
+}
+
+// storeTranscript saves a transcript as a text file with the provenance
+// of the audio it was made from.
+func storeTranscript(ctx context.Context, req TranscriptionRequest, audioName, audioVersion string, audio []byte, text string) (*StoredTranscript, error) {
+	client, err := services.MinIO()
+	if err != nil {
+		return nil, err
+	}
+	name := req.StoreName
+	if name == "" {
+		name = strings.TrimSuffix(audioName, path.Ext(audioName)) + ".txt"
+		if req.Bucket != "" {
+			name = path.Join(path.Dir(req.Name), name)
+		}
+	}
+	if strings.HasPrefix(name, trashPrefix) {
+		return nil, huma.Error400BadRequest("Transcripts cannot be stored in the trash")
+	}
+	provenance := Provenance{
+		Generator:     "transcribe-audio",
+		Model:         openai.Whisper1,
+		PromptSHA256:  promptSHA256(string(audio)),
+		GeneratedAt:   time.Now().UTC().Truncate(time.Second),
+		SourceVersion: audioVersion,
+	}
+	info, err := client.PutObject(ctx, req.StoreBucket, name, strings.NewReader(text), int64(len(text)), minio.PutObjectOptions{
+		ContentType:  "text/plain; charset=utf-8",
+		UserMetadata: provenance.metadata(),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, errBucketNotFound(req.StoreBucket)
+		}
+		return nil, huma.Error500InternalServerError("Failed to store the transcript", err)
+	}
+	usage.recordUpload(time.Now(), info.Size)
+	return &StoredTranscript{Bucket: req.StoreBucket, Name: name, Size: info.Size}, nil
+}
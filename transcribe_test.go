@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestTranscribe(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Limits.MaxAudioBytes = 1000
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		if r.URL.Path != "/v1/audio/transcriptions" || err != nil || r.FormValue("model") != "whisper-1" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		audio, _ := io.ReadAll(file)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"text": header.Filename + ": " + string(audio) + " " + r.FormValue("language")})
+	}))
+	defer server.Close()
+	services.SetOpenAI(newTestOpenAIClient(server.URL))
+	defer services.SetOpenAI(nil)
+
+	objects := map[string]string{"media/calls/monday.mp3": "ID3 call"}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerTranscribeEndpoint(api)
+	post := func(contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/transcribe", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	upload := func(audio string, fields map[string]string) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for k, v := range fields {
+			mw.WriteField(k, v)
+		}
+		part, _ := mw.CreateFormFile("file", "memo.m4a")
+		part.Write([]byte(audio))
+		mw.Close()
+		return post(mw.FormDataContentType(), buf.Bytes())
+	}
+
+	w := upload("voice memo", map[string]string{"language": "en"})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"text":"memo.m4a: voice memo en"`) || strings.Contains(w.Body.String(), "stored") {
+		t.Fatalf("Expected the upload to be transcribed, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = post("application/json", []byte(`{"bucket": "media", "name": "calls/monday.mp3", "store_bucket": "media"}`))
+	var resp TranscriptionResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Text != "monday.mp3: ID3 call " {
+		t.Fatalf("Expected the stored object to be transcribed, got %d: %s", w.Code, w.Body.String())
+	}
+	if resp.Stored == nil || resp.Stored.Name != "calls/monday.txt" || objects["media/calls/monday.txt"] != resp.Text {
+		t.Errorf("Expected the transcript next to the audio, got %+v", resp.Stored)
+	}
+
+	w = upload("again", map[string]string{"store_bucket": "media", "store_name": "notes/memo.txt"})
+	if w.Code != http.StatusOK || objects["media/notes/memo.txt"] != "memo.m4a: again " {
+		t.Errorf("Expected the transcript under store_name, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := upload(strings.Repeat("x", 1001), nil); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected a large file to be rejected, got %d", w.Code)
+	}
+	if w := post("application/json", []byte(`{"language": "en"}`)); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected a request without audio to be rejected, got %d", w.Code)
+	}
+	if w := post("application/json", []byte(`{"bucket": "media", "name": "missing.mp3"}`)); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a missing object to be reported, got %d", w.Code)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// TranscriptsConfig controls the transcripts of conversations written to
+// MinIO. Unlike the conversations themselves they only grow: regenerated
+// replies stay in them for audits. A transcript is deleted together with its
+// conversation.
+type TranscriptsConfig struct {
+	Enabled bool   `mapstructure:"enabled" doc:"Write every completed exchange of a conversation to its transcript"`
+	Bucket  string `mapstructure:"bucket" doc:"Bucket of the transcripts; defaults to minio.system_bucket"`
+	Prefix  string `mapstructure:"prefix" doc:"Key prefix of the transcripts; each is <prefix>tenants/<tenant>/<conversation id>.json, or <prefix>shared/<conversation id>.json without a tenant"`
+
+	SearchMaxTranscripts int `mapstructure:"search_max_transcripts" doc:"Most transcripts GET /conversations/search reads, most recently written first"`
+}
+
+// Transcript is every exchange of a conversation, oldest first.
+type Transcript struct {
+	ConversationID string           `json:"conversation_id"`
+	Tenant         string           `json:"tenant,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+	Turns          []TranscriptTurn `json:"turns"`
+}
+
+// TranscriptTurn is a message and the reply to it, or a regenerated reply.
+type TranscriptTurn struct {
+	Time        time.Time            `json:"time"`
+	Caller      string               `json:"caller"`
+	Regenerated bool                 `json:"regenerated,omitempty"`
+	Message     string               `json:"message"`
+	Reply       string               `json:"reply"`
+	Settings    ConversationSettings `json:"settings"`
+	Model       string               `json:"model,omitempty"`
+	Usage       ChatUsage            `json:"usage"`
+	Filter      *OutputFilterResult  `json:"filter,omitempty"`
+}
+
+// transcriptLocks serialize the appends to a transcript on this instance.
+// Conversations share a lock when their IDs hash alike.
+var transcriptLocks [64]sync.Mutex
+
+func transcriptLock(id string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return &transcriptLocks[h.Sum32()%uint32(len(transcriptLocks))]
+}
+
+func transcriptBucket() string {
+	if b := config.Conversations.Transcripts.Bucket; b != "" {
+		return b
+	}
+	return config.MinIO.SystemBucket
+}
+
+// transcriptDir is the key prefix of the transcripts of tenant's
+// conversations, so that a tenant's transcripts are listed without the
+// others. Conversations created without a tenant token share one folder.
+func transcriptDir(tenant string) string {
+	if tenant == "" {
+		return config.Conversations.Transcripts.Prefix + "shared/"
+	}
+	return config.Conversations.Transcripts.Prefix + "tenants/" + url.PathEscape(tenant) + "/"
+}
+
+func transcriptKey(tenant, id string) string {
+	return transcriptDir(tenant) + id + ".json"
+}
+
+// appendTranscript adds turn to the transcript of c, creating it with the
+// first turn. Objects cannot be appended to, so the transcript is read and
+// written again.
+func appendTranscript(ctx context.Context, c *Conversation, turn TranscriptTurn) error {
+	mu := transcriptLock(c.ID)
+	mu.Lock()
+	defer mu.Unlock()
+
+	bucket, key := transcriptBucket(), transcriptKey(c.Tenant, c.ID)
+	t := Transcript{ConversationID: c.ID, Tenant: c.Tenant, CreatedAt: c.CreatedAt}
+	if err := getJSON(ctx, bucket, key, &t); err != nil && !isNotFound(err) {
+		return err
+	}
+	t.Turns = append(t.Turns, turn)
+	if err := ensureBucket(ctx, bucket); err != nil {
+		return err
+	}
+	return putJSON(ctx, bucket, key, t)
+}
+
+// recordTranscript appends turn to the transcript of c when transcripts are
+// enabled. The reply has been stored by then, so a failure is logged rather
+// than returned, and a canceled request still completes the write.
+func recordTranscript(ctx context.Context, c *Conversation, turn TranscriptTurn) {
+	if !config.Conversations.Transcripts.Enabled {
+		return
+	}
+	if err := appendTranscript(context.WithoutCancel(ctx), c, turn); err != nil {
+		log.Printf("Failed to write the transcript of conversation %s: %v", c.ID, err)
+	}
+}
+
+// deleteTranscript removes the transcript of tenant's conversation id, if
+// any.
+func deleteTranscript(ctx context.Context, tenant, id string) error {
+	mu := transcriptLock(id)
+	mu.Lock()
+	defer mu.Unlock()
+
+	client, err := services.MinIO()
+	if err != nil {
+		return err
+	}
+	err = client.RemoveObject(ctx, transcriptBucket(), transcriptKey(tenant, id), minio.RemoveObjectOptions{})
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// tenantTranscripts reads every transcript of tenant's conversations.
+func tenantTranscripts(ctx context.Context, client *minio.Client, tenant string) ([]Transcript, error) {
+	var transcripts []Transcript
+	for obj := range client.ListObjects(ctx, transcriptBucket(), minio.ListObjectsOptions{Prefix: transcriptDir(tenant)}) {
+		if obj.Err != nil {
+			// No bucket yet means no transcripts
+			if isNotFound(obj.Err) {
+				break
+			}
+			return nil, obj.Err
+		}
+		var t Transcript
+		if err := getJSON(ctx, transcriptBucket(), obj.Key, &t); err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if t.Tenant == tenant && t.ConversationID != "" {
+			transcripts = append(transcripts, t)
+		}
+	}
+	return transcripts, nil
+}
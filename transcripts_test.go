@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConversationTranscripts(t *testing.T) {
+	router, _ := newConversationTestRouter(t)
+	config.Conversations.Transcripts = TranscriptsConfig{Enabled: true, Bucket: "audit", Prefix: "transcripts/"}
+
+	objects := map[string]string{}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	w := callConversations(router, "POST", "/conversations", "acme-token", `{"model": "gpt-4o-mini"}`)
+	var conv Conversation
+	json.Unmarshal(w.Body.Bytes(), &conv)
+	path := "/conversations/" + conv.ID
+	callConversations(router, "POST", path+"/messages", "acme-token", `{"message": "Hi"}`)
+	callConversations(router, "POST", path+"/messages", "acme-token", `{"message": "How are you?"}`)
+	if w := callConversations(router, "POST", path+"/regenerate", "acme-token", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected a new reply, got %d: %s", w.Code, w.Body.String())
+	}
+	var transcript Transcript
+	if err := json.Unmarshal([]byte(objects["audit/transcripts/tenants/acme/"+conv.ID+".json"]), &transcript); err != nil {
+		t.Fatalf("Expected the transcript in the bucket, got %v", objects)
+	}
+	if transcript.ConversationID != conv.ID || transcript.Tenant != "acme" || len(transcript.Turns) != 3 {
+		t.Fatalf("Expected every exchange in the transcript, got %+v", transcript)
+	}
+	first, regenerated := transcript.Turns[0], transcript.Turns[2]
+	if first.Message != "Hi" || first.Reply != "reply 1" || first.Caller != "tenant:acme" || first.Settings.Model != "gpt-4o-mini" || first.Regenerated {
+		t.Errorf("Expected the first exchange, got %+v", first)
+	}
+	if regenerated.Message != "How are you?" || regenerated.Reply != "reply 3" || !regenerated.Regenerated {
+		t.Errorf("Expected the regenerated reply last, got %+v", regenerated)
+	}
+
+	// The transcript goes with the conversation
+	if w := callConversations(router, "DELETE", path, "acme-token", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected the conversation to be deleted, got %d: %s", w.Code, w.Body.String())
+	}
+	if _, ok := objects["audit/"+transcriptKey(conv.Tenant, conv.ID)]; ok {
+		t.Error("Expected the transcript to be deleted with its conversation")
+	}
+
+	// Without transcripts nothing is written
+	config.Conversations.Transcripts.Enabled = false
+	w = callConversations(router, "POST", "/conversations", "", "")
+	json.Unmarshal(w.Body.Bytes(), &conv)
+	callConversations(router, "POST", "/conversations/"+conv.ID+"/messages", "", `{"message": "Hi"}`)
+	if _, ok := objects["audit/"+transcriptKey(conv.Tenant, conv.ID)]; ok {
+		t.Error("Expected no transcript when they are disabled")
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+// trashPrefix is the per-bucket prefix soft-deleted objects are moved under.
+const trashPrefix = ".trash/"
+
+type FileDeleteResponse struct {
+	Success  bool   `json:"success" doc:"Delete success status"`
+	Message  string `json:"message" doc:"Delete result message"`
+	TrashKey string `json:"trash_key" doc:"Key the object was moved to"`
+}
+
+type TrashItem struct {
+	Name      string    `json:"name" doc:"Original object name"`
+	TrashKey  string    `json:"trash_key" doc:"Key of the object inside the trash"`
+	Size      int64     `json:"size" doc:"Object size in bytes"`
+	DeletedAt time.Time `json:"deleted_at" doc:"When the object was moved to the trash"`
+	ExpiresAt time.Time `json:"expires_at,omitempty" doc:"When the object will be purged permanently"`
+}
+
+type TrashListResponse struct {
+	Bucket string      `json:"bucket" doc:"Bucket name"`
+	Items  []TrashItem `json:"items" doc:"Objects currently in the trash"`
+}
+
+type FileRestoreResponse struct {
+	Success bool   `json:"success" doc:"Restore success status"`
+	Message string `json:"message" doc:"Restore result message"`
+}
+
+// trashKey is the key name is trashed under at deletedAt. The time keeps
+// the copies of an object that was trashed more than once apart.
+func trashKey(name string, deletedAt time.Time) string {
+	return trashPrefix + name + "@" + strconv.FormatInt(deletedAt.UnixNano(), 10)
+}
+
+// trashedName returns the original name of the object trashed under key,
+// and when it was trashed. Keys trashed before the time was added name
+// the object without one.
+func trashedName(key string) (string, time.Time) {
+	name := strings.TrimPrefix(key, trashPrefix)
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		if nanos, err := strconv.ParseInt(name[i+1:], 10, 64); err == nil {
+			return name[:i], time.Unix(0, nanos)
+		}
+	}
+	return name, time.Time{}
+}
+
+// latestTrashKey returns the key of the copy of name trashed last, or ""
+// if name is not in the trash.
+func latestTrashKey(ctx context.Context, client *minio.Client, bucket, name string) (string, error) {
+	var latest string
+	var latestAt time.Time
+	for obj := range client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: trashPrefix + name, Recursive: true}) {
+		if obj.Err != nil {
+			return "", obj.Err
+		}
+		trashed, at := trashedName(obj.Key)
+		if trashed == name && (latest == "" || at.After(latestAt)) {
+			latest, latestAt = obj.Key, at
+		}
+	}
+	return latest, nil
+}
+
+// trashRetention returns how long trashed objects are kept, or zero if they
+// are kept forever.
+func trashRetention() time.Duration {
+	if config.Jobs.TrashRetentionDays <= 0 {
+		return 0
+	}
+	return time.Duration(config.Jobs.TrashRetentionDays) * 24 * time.Hour
+}
+
+// TrashPurgeReport describes one trash purge run.
+type TrashPurgeReport struct {
+	DryRun bool         `json:"dry_run" doc:"Whether nothing was deleted"`
+	RanAt  time.Time    `json:"ran_at" doc:"When the purge ran"`
+	Purged []PurgedItem `json:"purged" doc:"Trashed objects that were deleted, or would be in a dry run"`
+}
+
+// purgeTrash permanently removes trashed objects older than the retention
+// window from every bucket and reports them. With dryRun it only reports
+// what would be removed.
+func purgeTrash(ctx context.Context, now time.Time, dryRun bool) (TrashPurgeReport, error) {
+	report := TrashPurgeReport{DryRun: dryRun, RanAt: now.UTC(), Purged: []PurgedItem{}}
+	retention := trashRetention()
+	client, err := services.MinIO()
+	if err != nil || retention == 0 {
+		return report, nil
+	}
+
+	buckets, err := client.ListBuckets(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	for _, bucket := range buckets {
+		for obj := range client.ListObjects(ctx, bucket.Name, minio.ListObjectsOptions{Prefix: trashPrefix, Recursive: true}) {
+			if obj.Err != nil {
+				return report, obj.Err
+			}
+			if now.Sub(obj.LastModified) < retention {
+				continue
+			}
+			if !dryRun {
+				if err := client.RemoveObject(ctx, bucket.Name, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+					return report, err
+				}
+			}
+			report.Purged = append(report.Purged, PurgedItem{Bucket: bucket.Name, Name: obj.Key, LastModified: obj.LastModified})
+		}
+	}
+	return report, nil
+}
+
+// startTrashPurger runs purgeTrash periodically until ctx is cancelled. Dry
+// runs log each object that would be deleted.
+func startTrashPurger(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				report, err := purgeTrash(ctx, now, config.Jobs.TrashDryRun)
+				if err != nil {
+					log.Printf("Trash purge failed: %v", err)
+					continue
+				}
+				if report.DryRun {
+					for _, item := range report.Purged {
+						log.Printf("Trash purge dry run: would delete %s/%s", item.Bucket, item.Name)
+					}
+				} else if n := len(report.Purged); n > 0 {
+					log.Printf("Trash purge removed %d objects", n)
+				}
+			}
+		}
+	}()
+}
+
+func registerTrashEndpoints(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "purge-trash",
+		Method:      http.MethodPost,
+		Path:        "/admin/trash/purge",
+		Summary:     "Purge the trash",
+		Description: "Permanently delete the trashed objects of every bucket whose retention has expired now, or with dry_run report what would be deleted without deleting anything",
+	}, func(ctx context.Context, input *struct {
+		Authorization string `header:"Authorization" doc:"Bearer admin token"`
+		DryRun        bool   `query:"dry_run" doc:"Only report what would be deleted"`
+		ApprovalID    string `header:"X-Approval-ID" doc:"Approved request for purge-trash on all; required when approvals.admins is set, except for dry runs"`
+	}) (*struct {
+		Body TrashPurgeReport
+	}, error) {
+		if err := requireAdmin(input.Authorization); err != nil {
+			return nil, err
+		}
+		if !input.DryRun {
+			if err := consumeApproval(ctx, input.ApprovalID, approvalPurgeTrash, approvalTargetAll); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+		report, err := purgeTrash(ctx, time.Now(), input.DryRun)
+		if err != nil {
+			return nil, huma.Error500InternalServerError("Trash purge failed", err)
+		}
+		return &struct {
+			Body TrashPurgeReport
+		}{Body: report}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "delete-file",
+		Method:      http.MethodDelete,
+		Path:        "/files/{bucket}/{name}",
+		Summary:     "Move a file to the trash",
+		Description: "Soft delete an object by moving it under the bucket's .trash/ prefix, where it is kept for the configured retention window",
+	}, func(ctx context.Context, input *struct {
+		Bucket string `path:"bucket" doc:"MinIO bucket name"`
+		Name   string `path:"name" doc:"Object name (URL-encoded if it contains slashes)"`
+	}) (*struct {
+		Body FileDeleteResponse
+	}, error) {
+		if err := checkBucketAccess(input.Bucket); err != nil {
+			return nil, err
+		}
+
+		if _, err := services.MinIO(); err != nil {
+			return nil, err
+		}
+
+		name, err := decodeObjectKey(input.Name)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(name, trashPrefix) {
+			return nil, huma.Error400BadRequest("Object is already in the trash")
+		}
+
+		dst := trashKey(name, time.Now())
+		if err := moveObject(ctx, input.Bucket, name, dst); err != nil {
+			if isNotFound(err) {
+				return nil, errObjectNotFound(input.Bucket, name)
+			}
+			return nil, huma.Error500InternalServerError("Failed to move object to trash", err)
+		}
+		audit(ctx, AuditEvent{Action: "file.trashed", Operation: "delete-file", Detail: input.Bucket + "/" + name})
+
+		return &struct {
+			Body FileDeleteResponse
+		}{
+			Body: FileDeleteResponse{
+				Success:  true,
+				Message:  fmt.Sprintf("File %s moved to trash in bucket %s", name, input.Bucket),
+				TrashKey: dst,
+			},
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "list-trash",
+		Method:      http.MethodGet,
+		Path:        "/files/trash/{bucket}",
+		Summary:     "List trashed files",
+		Description: "List soft-deleted objects in a bucket along with when they will be purged",
+	}, func(ctx context.Context, input *struct {
+		Bucket string `path:"bucket" doc:"MinIO bucket name"`
+	}) (*struct {
+		Body TrashListResponse
+	}, error) {
+		if err := checkBucketAccess(input.Bucket); err != nil {
+			return nil, err
+		}
+
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+
+		retention := trashRetention()
+		items := []TrashItem{}
+		for obj := range client.ListObjects(ctx, input.Bucket, minio.ListObjectsOptions{Prefix: trashPrefix, Recursive: true}) {
+			if obj.Err != nil {
+				if isNotFound(obj.Err) {
+					return nil, errBucketNotFound(input.Bucket)
+				}
+				return nil, huma.Error500InternalServerError("Failed to list trash", obj.Err)
+			}
+			name, _ := trashedName(obj.Key)
+			item := TrashItem{
+				Name:      name,
+				TrashKey:  obj.Key,
+				Size:      obj.Size,
+				DeletedAt: obj.LastModified,
+			}
+			if retention > 0 {
+				item.ExpiresAt = obj.LastModified.Add(retention)
+			}
+			items = append(items, item)
+		}
+
+		return &struct {
+			Body TrashListResponse
+		}{
+			Body: TrashListResponse{Bucket: input.Bucket, Items: items},
+		}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "restore-file",
+		Method:      http.MethodPost,
+		Path:        "/files/trash/{bucket}/{name}/restore",
+		Summary:     "Restore a trashed file",
+		Description: "Move a soft-deleted object back to its original name. Of an object trashed several times, the copy trashed last is restored unless trash_key picks another",
+	}, func(ctx context.Context, input *struct {
+		Bucket   string `path:"bucket" doc:"MinIO bucket name"`
+		Name     string `path:"name" doc:"Original object name (URL-encoded if it contains slashes)"`
+		TrashKey string `query:"trash_key" doc:"Trashed copy to restore, as listed by GET /files/trash/{bucket}; defaults to the latest"`
+	}) (*struct {
+		Body FileRestoreResponse
+	}, error) {
+		if err := checkBucketAccess(input.Bucket); err != nil {
+			return nil, err
+		}
+
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := decodeObjectKey(input.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		// Refuse to clobber an object that was re-created after the delete
+		if _, err := client.StatObject(ctx, input.Bucket, name, minio.StatObjectOptions{}); err == nil {
+			return nil, huma.Error409Conflict(fmt.Sprintf("Object %s already exists in bucket %s", name, input.Bucket))
+		} else if !isNotFound(err) {
+			return nil, huma.Error500InternalServerError("Failed to check object existence", err)
+		}
+
+		key := input.TrashKey
+		if key == "" {
+			if key, err = latestTrashKey(ctx, client, input.Bucket, name); err != nil {
+				if isNotFound(err) {
+					return nil, errBucketNotFound(input.Bucket)
+				}
+				return nil, huma.Error500InternalServerError("Failed to list trash", err)
+			}
+		} else if trashed, _ := trashedName(key); !strings.HasPrefix(key, trashPrefix) || trashed != name {
+			return nil, huma.Error400BadRequest(fmt.Sprintf("Trash key %s is not a trashed copy of %s", key, name))
+		}
+		notFound := huma.Error404NotFound(fmt.Sprintf("Object %s not found in trash of bucket %s", name, input.Bucket))
+		if key == "" {
+			return nil, notFound
+		}
+		if err := moveObject(ctx, input.Bucket, key, name); err != nil {
+			if isNotFound(err) {
+				return nil, notFound
+			}
+			return nil, huma.Error500InternalServerError("Failed to restore object", err)
+		}
+		audit(ctx, AuditEvent{Action: "file.restored", Operation: "restore-file", Detail: input.Bucket + "/" + name})
+
+		return &struct {
+			Body FileRestoreResponse
+		}{
+			Body: FileRestoreResponse{
+				Success: true,
+				Message: fmt.Sprintf("File %s restored in bucket %s", name, input.Bucket),
+			},
+		}, nil
+	})
+}
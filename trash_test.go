@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestTrashEndpointsWithoutClient(t *testing.T) {
+	// Initialize config for testing
+	viper.Reset()
+	initConfig()
+
+	// Ensure MinIO client is not initialized
+	services.SetMinIO(nil)
+
+	// Create test router and API
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+
+	// Register trash endpoints
+	registerTrashEndpoints(api)
+
+	requests := []struct {
+		method string
+		path   string
+	}{
+		{"DELETE", "/files/test-bucket/test.txt"},
+		{"GET", "/files/trash/test-bucket"},
+		{"POST", "/files/trash/test-bucket/test.txt/restore"},
+	}
+
+	for _, r := range requests {
+		req := httptest.NewRequest(r.method, r.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		// Should return 503 Service Unavailable since MinIO client is not configured
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s %s: expected status code 503, got %d", r.method, r.path, w.Code)
+		}
+	}
+}
+
+func TestTrashRetention(t *testing.T) {
+	viper.Reset()
+	initConfig()
+
+	if config.Jobs.TrashRetentionDays != 30 {
+		t.Errorf("Expected default trash retention to be 30 days, got %d", config.Jobs.TrashRetentionDays)
+	}
+
+	config.Jobs.TrashRetentionDays = 0
+	if trashRetention() != 0 {
+		t.Errorf("Expected zero retention to disable purging, got %v", trashRetention())
+	}
+
+	deletedAt := time.Unix(0, 1767366245000000000)
+	key := trashKey("reports/q1@2.txt", deletedAt)
+	if key != ".trash/reports/q1@2.txt@1767366245000000000" {
+		t.Errorf("Expected the trash key to carry the time, got %s", key)
+	}
+	if name, at := trashedName(key); name != "reports/q1@2.txt" || !at.Equal(deletedAt) {
+		t.Errorf("Expected the name and time back, got %s at %v", name, at)
+	}
+	if name, at := trashedName(".trash/q1.txt"); name != "q1.txt" || !at.IsZero() {
+		t.Errorf("Expected a key without a time to name the object, got %s at %v", name, at)
+	}
+}
+
+func TestTrashRestore(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	objects := map[string]string{}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerTrashEndpoints(api)
+	call := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	// Trashing the same name twice keeps both copies
+	for _, content := range []string{"first", "second"} {
+		objects["docs/a.txt"] = content
+		if w := call(http.MethodDelete, "/files/docs/a.txt"); w.Code != http.StatusOK {
+			t.Fatalf("Expected the delete to succeed, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	var list TrashListResponse
+	json.Unmarshal(call(http.MethodGet, "/files/trash/docs").Body.Bytes(), &list)
+	if len(list.Items) != 2 || list.Items[0].Name != "a.txt" || list.Items[1].Name != "a.txt" || list.Items[0].TrashKey == list.Items[1].TrashKey {
+		t.Fatalf("Expected two trashed copies of a.txt, got %+v", list.Items)
+	}
+	var first string
+	for _, item := range list.Items {
+		if objects["docs/"+item.TrashKey] == "first" {
+			first = item.TrashKey
+		}
+	}
+
+	if w := call(http.MethodPost, "/files/trash/docs/a.txt/restore"); w.Code != http.StatusOK || objects["docs/a.txt"] != "second" {
+		t.Errorf("Expected the latest copy to be restored, got %d: %v", w.Code, objects)
+	}
+	delete(objects, "docs/a.txt")
+	if w := call(http.MethodPost, "/files/trash/docs/a.txt/restore?trash_key=.trash/b.txt@1"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a trash key of another object to be refused, got %d", w.Code)
+	}
+	if w := call(http.MethodPost, "/files/trash/docs/a.txt/restore?trash_key="+url.QueryEscape(first)); w.Code != http.StatusOK || objects["docs/a.txt"] != "first" {
+		t.Errorf("Expected the chosen copy to be restored, got %d: %v", w.Code, objects)
+	}
+	if w := call(http.MethodPost, "/files/trash/docs/a.txt/restore"); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for an existing object, got %d", w.Code)
+	}
+	delete(objects, "docs/a.txt")
+	if w := call(http.MethodPost, "/files/trash/docs/a.txt/restore"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 once the trash is empty, got %d", w.Code)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Auth.AdminToken = "admin-token"
+
+	// fakeS3 lists every object as last modified on 2026-01-02, more than
+	// 30 days ago
+	objects := map[string]string{
+		"docs/.trash/old.txt": "old",
+		"docs/report.txt":     "live",
+	}
+	fake := fakeS3(objects)
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/" {
+			w.Write([]byte(`<ListAllMyBucketsResult><Buckets><Bucket><Name>docs</Name></Bucket></Buckets></ListAllMyBucketsResult>`))
+			return
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerTrashEndpoints(api)
+	purge := func(query, token string) (int, TrashPurgeReport) {
+		req := httptest.NewRequest(http.MethodPost, "/admin/trash/purge"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var report TrashPurgeReport
+		json.Unmarshal(w.Body.Bytes(), &report)
+		return w.Code, report
+	}
+
+	if code, _ := purge("?dry_run=true", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the admin token, got %d", code)
+	}
+	code, dry := purge("?dry_run=true", "admin-token")
+	if code != http.StatusOK || !dry.DryRun || len(dry.Purged) != 1 || dry.Purged[0].Name != ".trash/old.txt" {
+		t.Errorf("Expected the dry run to report the expired trash, got %d: %+v", code, dry)
+	}
+	if _, ok := objects["docs/.trash/old.txt"]; !ok {
+		t.Error("Expected a dry run not to delete anything")
+	}
+
+	code, report := purge("", "admin-token")
+	if code != http.StatusOK || report.DryRun || len(report.Purged) != 1 {
+		t.Errorf("Expected the expired trash to be purged, got %d: %+v", code, report)
+	}
+	if _, ok := objects["docs/.trash/old.txt"]; ok {
+		t.Error("Expected the expired trash to be deleted")
+	}
+	if _, ok := objects["docs/report.txt"]; !ok {
+		t.Error("Expected live objects to be kept")
+	}
+}
+
+func TestDecodeObjectKey(t *testing.T) {
+	key, err := decodeObjectKey("reports%2Fq1.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if key != "reports/q1.txt" {
+		t.Errorf("Expected reports/q1.txt, got %s", key)
+	}
+
+	if _, err := decodeObjectKey("%zz"); err == nil {
+		t.Error("Expected error for invalid escape sequence")
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/minio/minio-go/v7"
+)
+
+type FilePutResponse struct {
+	Bucket string `json:"bucket" doc:"MinIO bucket name"`
+	Name   string `json:"name" doc:"Object name"`
+	Size   int64  `json:"size" doc:"Stored size in bytes"`
+	ETag   string `json:"etag" doc:"ETag of the stored object"`
+}
+
+// filePutInput streams the request body through a resolver instead of
+// RawBody: huma returns a RawBody's buffer to its pool before the handler
+// runs, so concurrent uploads could store each other's bytes.
+type filePutInput struct {
+	Bucket      string `path:"bucket" doc:"MinIO bucket name"`
+	Name        string `path:"name" doc:"Object name (URL-encoded if it contains slashes)"`
+	ContentType string `header:"Content-Type" doc:"Content type to store; guessed from the name when missing"`
+	UploadID    string `header:"X-Upload-ID" pattern:"^[A-Za-z0-9_-]{16,64}$" doc:"Random ID to watch the upload's progress on the WebSocket /uploads/{id}/events"`
+	Checksum    string `header:"X-Checksum-SHA256" pattern:"^[0-9a-fA-F]{64}$" doc:"Hex SHA-256 of the content; the object is removed again if the received content differs"`
+
+	body io.Reader
+	size int64
+}
+
+func (i *filePutInput) Resolve(ctx huma.Context) []error {
+	i.body = ctx.BodyReader()
+	i.size = -1
+	if n, err := strconv.ParseInt(ctx.Header("Content-Length"), 10, 64); err == nil {
+		i.size = n
+	}
+	return nil
+}
+
+func registerFilePutEndpoint(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "put-file",
+		Method:      http.MethodPut,
+		Path:        "/files/{bucket}/{name}",
+		Summary:     "Upload a file",
+		Description: "Store the raw request body as an object, replacing any object with the same name. Unlike POST /upload this accepts binary content",
+		RequestBody: &huma.RequestBody{
+			Description: "File content",
+			Required:    true,
+			Content: map[string]*huma.MediaType{
+				"application/octet-stream": {Schema: &huma.Schema{Type: "string", Format: "binary"}},
+			},
+		},
+	}, func(ctx context.Context, input *filePutInput) (*struct {
+		Body FilePutResponse
+	}, error) {
+		if err := checkBucketAccess(input.Bucket); err != nil {
+			return nil, err
+		}
+
+		client, err := services.MinIO()
+		if err != nil {
+			return nil, err
+		}
+
+		name, err := decodeObjectKey(input.Name)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(name, trashPrefix) {
+			return nil, huma.Error400BadRequest("Objects cannot be uploaded into the trash")
+		}
+		if input.size < 0 {
+			return nil, huma.NewError(http.StatusLengthRequired, "Content-Length is required")
+		}
+		if input.size > config.Limits.MaxUploadBytes {
+			return nil, huma.NewError(http.StatusRequestEntityTooLarge, fmt.Sprintf("File is larger than %d bytes", config.Limits.MaxUploadBytes))
+		}
+		contentType := input.ContentType
+		if contentType == "" || contentType == "application/octet-stream" {
+			if byExt := mime.TypeByExtension(path.Ext(name)); byExt != "" {
+				contentType = byExt
+			}
+		}
+
+		var tracker *uploadTracker
+		if input.UploadID != "" {
+			tracker = trackUpload(input.UploadID)
+			if !tracker.start() {
+				return nil, huma.Error409Conflict(fmt.Sprintf("Upload ID %s is already in use", input.UploadID))
+			}
+		}
+		info, err := storeUpload(ctx, client, tracker, input, name, contentType)
+		if tracker != nil {
+			if err != nil {
+				tracker.publish(UploadEvent{Type: "error", Error: err.Error()})
+			} else {
+				tracker.publish(UploadEvent{Type: "done", Bytes: info.Size, Total: info.Size, ETag: strings.Trim(info.ETag, `"`)})
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return &struct {
+			Body FilePutResponse
+		}{
+			Body: FilePutResponse{
+				Bucket: input.Bucket,
+				Name:   name,
+				Size:   info.Size,
+				ETag:   strings.Trim(info.ETag, `"`),
+			},
+		}, nil
+	})
+}
+
+// minioPartSize is the smallest body minio-go uploads in parts.
+const minioPartSize = 16 << 20
+
+// uploadStagingPrefix is where uploads sent with a checksum are kept in the
+// system bucket until the checksum has been verified.
+const uploadStagingPrefix = "uploads/"
+
+// storeUpload streams an upload into MinIO, reporting its progress and steps
+// to tracker if set, and verifies its checksum if one was sent.
+func storeUpload(ctx context.Context, client *minio.Client, tracker *uploadTracker, input *filePutInput, name, contentType string) (minio.UploadInfo, error) {
+	// Smaller bodies are stored with one request
+	parts, partSize := 1, input.size
+	if input.size >= minioPartSize {
+		var err error
+		parts, partSize, _, err = minio.OptimalPartInfo(input.size, 0)
+		if err != nil {
+			return minio.UploadInfo{}, huma.Error500InternalServerError("Failed to plan the upload", err)
+		}
+	}
+	// An upload with a checksum is staged and only copied into place once
+	// it matches, so a mismatch leaves the object it would replace intact
+	bucket, key := input.Bucket, name
+	if input.Checksum != "" {
+		exists, err := client.BucketExists(ctx, input.Bucket)
+		if err != nil {
+			return minio.UploadInfo{}, huma.Error500InternalServerError(fmt.Sprintf("Failed to upload %s", name), err)
+		}
+		if !exists {
+			return minio.UploadInfo{}, errBucketNotFound(input.Bucket)
+		}
+		if err := ensureBucket(ctx, config.MinIO.SystemBucket); err != nil {
+			return minio.UploadInfo{}, huma.Error500InternalServerError("Failed to stage the upload", err)
+		}
+		bucket, key = config.MinIO.SystemBucket, uploadStagingPrefix+randomHex(16)
+	}
+	body := newProgressReader(input.body, tracker, input.size, partSize, parts, input.Checksum != "")
+	info, err := client.PutObject(ctx, bucket, key, body, input.size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return info, errBucketNotFound(input.Bucket)
+		}
+		return info, huma.Error500InternalServerError(fmt.Sprintf("Failed to upload %s", name), err)
+	}
+	usage.recordUpload(time.Now(), info.Size)
+	step := func(name, status string) {
+		if tracker != nil {
+			tracker.publish(UploadEvent{Type: "step", Step: name, Status: status})
+		}
+	}
+	step(uploadStepStore, "done")
+
+	if input.Checksum == "" {
+		step(uploadStepChecksum, "skipped")
+		return info, nil
+	}
+	defer func() {
+		if err := client.RemoveObject(context.WithoutCancel(ctx), bucket, key, minio.RemoveObjectOptions{}); err != nil {
+			log.Printf("Failed to remove the staged upload %s/%s: %v", bucket, key, err)
+		}
+	}()
+	if sum := body.sum(); !strings.EqualFold(sum, input.Checksum) {
+		step(uploadStepChecksum, "failed")
+		return info, codedError(http.StatusUnprocessableEntity, "ERR_CHECKSUM_MISMATCH", fmt.Sprintf("Received content has SHA-256 %s, not %s; the object was not kept", sum, input.Checksum))
+	}
+	step(uploadStepChecksum, "done")
+
+	if _, err := client.CopyObject(ctx, minio.CopyDestOptions{Bucket: input.Bucket, Object: name}, minio.CopySrcOptions{Bucket: bucket, Object: key}); err != nil {
+		return info, huma.Error500InternalServerError(fmt.Sprintf("Failed to store %s", name), err)
+	}
+	// minio-go leaves the ETag of copies empty, so read it back
+	stat, err := client.StatObject(ctx, input.Bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		return info, huma.Error500InternalServerError(fmt.Sprintf("Failed to store %s", name), err)
+	}
+	return minio.UploadInfo{Bucket: input.Bucket, Key: name, ETag: stat.ETag, Size: stat.Size, VersionID: stat.VersionID, LastModified: stat.LastModified}, nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/net/websocket"
+)
+
+// uploadEventsPath is the WebSocket an upload's events are watched on.
+const uploadEventsPath = "/uploads/{id}/events"
+
+// uploadIDPattern requires IDs long enough to be unguessable, as anyone who
+// knows an ID can watch its upload.
+var uploadIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{16,64}$`)
+
+// uploadEventRetention is how long the events of a finished upload can
+// still be watched.
+const uploadEventRetention = time.Minute
+
+// progressInterval is the least time between progress events of a part.
+const progressInterval = 250 * time.Millisecond
+
+// Upload steps reported in step events.
+const (
+	uploadStepStore    = "store"
+	uploadStepChecksum = "checksum"
+)
+
+// UploadEvent is one message on an upload's WebSocket.
+type UploadEvent struct {
+	Type   string `json:"type" enum:"progress,step,done,error,ping" doc:"Kind of event"`
+	Bytes  int64  `json:"bytes,omitempty" doc:"Bytes received so far"`
+	Total  int64  `json:"total,omitempty" doc:"Size of the upload"`
+	Part   int    `json:"part,omitempty" doc:"Part the received bytes are in, counting from 1"`
+	Parts  int    `json:"parts,omitempty" doc:"Number of parts the upload is stored in"`
+	Step   string `json:"step,omitempty" enum:"store,checksum" doc:"Step of a step event"`
+	Status string `json:"status,omitempty" enum:"done,failed,skipped" doc:"Outcome of the step"`
+	ETag   string `json:"etag,omitempty" doc:"ETag of the stored object"`
+	Error  string `json:"error,omitempty" doc:"Why the upload or step failed"`
+}
+
+// uploadTracker collects the events of one upload for its watchers.
+type uploadTracker struct {
+	id       string
+	mu       sync.Mutex
+	events   []UploadEvent
+	changed  chan struct{} // closed and replaced on every event
+	started  bool
+	finished bool
+	watchers int
+}
+
+var uploadTrackers struct {
+	sync.Mutex
+	byID map[string]*uploadTracker
+}
+
+// trackUpload returns the tracker of an upload ID, creating it for the
+// upload or for a watcher that connects first.
+func trackUpload(id string) *uploadTracker {
+	uploadTrackers.Lock()
+	defer uploadTrackers.Unlock()
+	if t, ok := uploadTrackers.byID[id]; ok {
+		return t
+	}
+	if uploadTrackers.byID == nil {
+		uploadTrackers.byID = map[string]*uploadTracker{}
+	}
+	t := &uploadTracker{id: id, changed: make(chan struct{})}
+	uploadTrackers.byID[id] = t
+	return t
+}
+
+func (t *uploadTracker) forget() {
+	uploadTrackers.Lock()
+	defer uploadTrackers.Unlock()
+	if uploadTrackers.byID[t.id] == t {
+		delete(uploadTrackers.byID, t.id)
+	}
+}
+
+// start claims the tracker for an upload, and reports false if another
+// upload has the ID.
+func (t *uploadTracker) start() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.started {
+		return false
+	}
+	t.started = true
+	return true
+}
+
+// publish records an event and wakes the watchers. A done or error event
+// finishes the upload; its events are dropped after uploadEventRetention.
+func (t *uploadTracker) publish(e UploadEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.finished {
+		return
+	}
+	t.events = append(t.events, e)
+	if e.Type == "done" || e.Type == "error" {
+		t.finished = true
+		time.AfterFunc(uploadEventRetention, t.forget)
+	}
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// since returns the events from index i on, a channel closed on the next
+// event and whether the upload has finished.
+func (t *uploadTracker) since(i int) ([]UploadEvent, <-chan struct{}, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.events[i:], t.changed, t.finished
+}
+
+func (t *uploadTracker) watch() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.watchers++
+}
+
+// unwatch drops a watcher, and the tracker with the last watcher if no
+// upload has claimed it.
+func (t *uploadTracker) unwatch() {
+	t.mu.Lock()
+	t.watchers--
+	unused := t.watchers == 0 && !t.started
+	t.mu.Unlock()
+	if unused {
+		t.forget()
+	}
+}
+
+// progressReader reports the bytes read through it as progress events, at
+// every part boundary and otherwise at most every progressInterval, and
+// hashes them when a checksum is to be verified.
+type progressReader struct {
+	r        io.Reader
+	tracker  *uploadTracker
+	hash     hash.Hash
+	total    int64
+	partSize int64
+	parts    int
+	read     int64
+	last     time.Time
+}
+
+func newProgressReader(r io.Reader, tracker *uploadTracker, total, partSize int64, parts int, verify bool) *progressReader {
+	p := &progressReader{r: r, tracker: tracker, total: total, partSize: partSize, parts: parts}
+	if verify {
+		p.hash = sha256.New()
+	}
+	return p
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		if p.hash != nil {
+			p.hash.Write(b[:n])
+		}
+		before := p.read
+		p.read += int64(n)
+		if p.tracker != nil {
+			crossed := p.partSize > 0 && before/p.partSize != p.read/p.partSize
+			if now := time.Now(); crossed || p.read == p.total || now.Sub(p.last) >= progressInterval {
+				p.last = now
+				part := 1
+				if p.partSize > 0 {
+					part = min(int((p.read-1)/p.partSize)+1, p.parts)
+				}
+				p.tracker.publish(UploadEvent{Type: "progress", Bytes: p.read, Total: p.total, Part: part, Parts: p.parts})
+			}
+		}
+	}
+	return n, err
+}
+
+// sum returns the hex SHA-256 of the bytes read.
+func (p *progressReader) sum() string {
+	return hex.EncodeToString(p.hash.Sum(nil))
+}
+
+// uploadEventsHandler streams an upload's events as JSON text messages,
+// from its first event on, and closes the socket once the upload has
+// finished.
+func uploadEventsHandler() http.Handler {
+	ws := websocket.Server{
+		// Browsers send the page's origin; the unguessable ID is what
+		// protects an upload, as with presigned links
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(conn *websocket.Conn) {
+			defer conn.Close()
+			t := trackUpload(chi.URLParam(conn.Request(), "id"))
+			t.watch()
+			defer t.unwatch()
+
+			// Notice the client going away
+			gone := make(chan struct{})
+			go func() {
+				io.Copy(io.Discard, conn)
+				close(gone)
+			}()
+			var heartbeat <-chan time.Time
+			if s := config.Streams.HeartbeatSeconds; s > 0 {
+				ticker := time.NewTicker(time.Duration(s) * time.Second)
+				defer ticker.Stop()
+				heartbeat = ticker.C
+			}
+			for sent := 0; ; {
+				events, changed, finished := t.since(sent)
+				for _, e := range events {
+					if websocket.JSON.Send(conn, e) != nil {
+						return
+					}
+				}
+				sent += len(events)
+				if finished {
+					return
+				}
+				select {
+				case <-changed:
+				case <-heartbeat:
+					if websocket.JSON.Send(conn, UploadEvent{Type: "ping"}) != nil {
+						return
+					}
+				case <-gone:
+					return
+				case <-conn.Request().Context().Done():
+					return
+				}
+			}
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !uploadIDPattern.MatchString(chi.URLParam(r, "id")) {
+			writeError(w, huma.Error400BadRequest("Upload IDs are 16 to 64 letters, digits, - or _"))
+			return
+		}
+		ws.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+	"golang.org/x/net/websocket"
+)
+
+func TestUploadEvents(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	objects := map[string]string{}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerFilePutEndpoint(api)
+	router.Handle(uploadEventsPath, uploadEventsHandler())
+	srv := httptest.NewServer(usageMiddleware(router))
+	defer srv.Close()
+
+	watch := func(id string) *websocket.Conn {
+		conn, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/uploads/"+id+"/events", "", srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	events := func(conn *websocket.Conn) []UploadEvent {
+		defer conn.Close()
+		var out []UploadEvent
+		for {
+			var e UploadEvent
+			if websocket.JSON.Receive(conn, &e) != nil {
+				return out
+			}
+			out = append(out, e)
+		}
+	}
+	put := func(id, checksum, content string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPut, srv.URL+"/files/media/talk.txt", strings.NewReader(content))
+		req.Header.Set("X-Upload-ID", id)
+		if checksum != "" {
+			req.Header.Set("X-Checksum-SHA256", checksum)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	sum := sha256.Sum256([]byte("hello world"))
+
+	conn := watch("upload-0123456789")
+	if resp := put("upload-0123456789", hex.EncodeToString(sum[:]), "hello world"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the upload to succeed, got %d", resp.StatusCode)
+	}
+	got := events(conn)
+	var types []string
+	for _, e := range got {
+		types = append(types, e.Type+":"+e.Step+e.Status)
+	}
+	if strings.Join(types, ",") != "progress:,step:storedone,step:checksumdone,done:" {
+		t.Fatalf("Expected progress, both steps and done, got %v", types)
+	}
+	if p := got[0]; p.Bytes != 11 || p.Total != 11 || p.Part != 1 || p.Parts != 1 {
+		t.Errorf("Expected the whole single part reported, got %+v", p)
+	}
+	if d := got[3]; d.Bytes != 11 || d.ETag == "" {
+		t.Errorf("Expected the stored size and ETag, got %+v", d)
+	}
+
+	// A late watcher still gets the events, and the ID can't be reused
+	if late := events(watch("upload-0123456789")); len(late) != 4 {
+		t.Errorf("Expected a late watcher to get every event, got %+v", late)
+	}
+	if resp := put("upload-0123456789", "", "again"); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected a reused upload ID to be refused, got %d", resp.StatusCode)
+	}
+
+	conn = watch("upload-abcdefghij")
+	if resp := put("upload-abcdefghij", hex.EncodeToString(sum[:]), "hello there"); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected a checksum mismatch, got %d", resp.StatusCode)
+	}
+	got = events(conn)
+	if last := got[len(got)-1]; last.Type != "error" || !strings.Contains(last.Error, "SHA-256") || got[len(got)-2].Status != "failed" {
+		t.Errorf("Expected the failed checksum reported, got %+v", got)
+	}
+	// The mismatched upload neither replaced the earlier object nor was
+	// left behind
+	if objects["media/talk.txt"] != "hello world" {
+		t.Errorf("Expected the earlier object to be kept, got %q", objects["media/talk.txt"])
+	}
+	for key := range objects {
+		if strings.Contains(key, uploadStagingPrefix) {
+			t.Errorf("Expected the staged upload to be removed, found %s", key)
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/uploads/short/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a short upload ID to be refused, got %d", resp.StatusCode)
+	}
+}
+
+func TestProgressReaderParts(t *testing.T) {
+	tracker := &uploadTracker{changed: make(chan struct{})}
+	r := newProgressReader(strings.NewReader(strings.Repeat("x", 40)), tracker, 40, 16, 3, false)
+	buf := make([]byte, 8)
+	for {
+		if _, err := r.Read(buf); err != nil {
+			break
+		}
+	}
+	var parts []int
+	for _, e := range tracker.events {
+		parts = append(parts, e.Part)
+	}
+	// The first read and every read ending a part or the upload report
+	want := []int{1, 1, 2, 3}
+	if !slices.Equal(parts, want) {
+		t.Errorf("Expected progress in parts %v, got %v", want, parts)
+	}
+	if last := tracker.events[len(tracker.events)-1]; last.Bytes != 40 || last.Parts != 3 {
+		t.Errorf("Expected the whole upload at the end, got %+v", last)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// usageRetention is how much per-minute usage history is kept in memory.
+const usageRetention = 24 * time.Hour
+
+// latencyBounds are the upper bounds of the request latency histogram.
+var latencyBounds = [...]time.Duration{
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+}
+
+// usageBucket aggregates one minute of traffic.
+type usageBucket struct {
+	Minute      time.Time
+	Requests    int64
+	Errors      int64
+	Tokens      int64
+	UploadBytes int64
+	// Latency[i] counts requests no slower than latencyBounds[i]; the
+	// last entry counts everything slower.
+	Latency [len(latencyBounds) + 1]int64
+}
+
+// withinLatency returns how many requests finished within the histogram
+// bound closest to (and not above) limit.
+func (b usageBucket) withinLatency(limit time.Duration) int64 {
+	var n int64
+	for i, bound := range latencyBounds {
+		if bound > limit {
+			break
+		}
+		n += b.Latency[i]
+	}
+	return n
+}
+
+// usageRecorder keeps per-minute usage counters for the monitoring
+// subsystems. It is safe for concurrent use.
+type usageRecorder struct {
+	mu      sync.Mutex
+	buckets []usageBucket // oldest first
+}
+
+var usage = &usageRecorder{}
+
+// bucket returns the bucket for now's minute, creating it and dropping
+// expired buckets as needed. The caller must hold r.mu.
+func (r *usageRecorder) bucket(now time.Time) *usageBucket {
+	minute := now.Truncate(time.Minute)
+	if n := len(r.buckets); n > 0 && r.buckets[n-1].Minute.Equal(minute) {
+		return &r.buckets[n-1]
+	}
+
+	cutoff := minute.Add(-usageRetention)
+	drop := 0
+	for drop < len(r.buckets) && !r.buckets[drop].Minute.After(cutoff) {
+		drop++
+	}
+	r.buckets = append(r.buckets[drop:], usageBucket{Minute: minute})
+	return &r.buckets[len(r.buckets)-1]
+}
+
+func (r *usageRecorder) recordRequest(now time.Time, status int, elapsed time.Duration) {
+	i := 0
+	for i < len(latencyBounds) && elapsed > latencyBounds[i] {
+		i++
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := r.bucket(now)
+	b.Requests++
+	if status >= http.StatusInternalServerError {
+		b.Errors++
+	}
+	b.Latency[i]++
+}
+
+func (r *usageRecorder) recordTokens(now time.Time, tokens int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bucket(now).Tokens += int64(tokens)
+}
+
+func (r *usageRecorder) recordUpload(now time.Time, bytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bucket(now).UploadBytes += bytes
+}
+
+// sum adds up all buckets in [from, to).
+func (r *usageRecorder) sum(from, to time.Time) usageBucket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	total := usageBucket{Minute: from}
+	for _, b := range r.buckets {
+		if b.Minute.Before(from) || !b.Minute.Before(to) {
+			continue
+		}
+		total.Requests += b.Requests
+		total.Errors += b.Errors
+		total.Tokens += b.Tokens
+		total.UploadBytes += b.UploadBytes
+		for i, n := range b.Latency {
+			total.Latency[i] += n
+		}
+	}
+	return total
+}
+
+// recordTokenUsage records the tokens billed for an OpenAI response of
+// model and charges their cost to the caller of ctx. Calls made with the
+// caller's own OpenAI key are billed to it, not charged.
+func recordTokenUsage(ctx context.Context, model string, u openai.Usage) {
+	now := time.Now()
+	usage.recordTokens(now, u.TotalTokens)
+	if _, ok := ctx.Value(requestOpenAIClientKey{}).(*openai.Client); ok {
+		return
+	}
+	costs.record(costCaller(ctx), u, estimateCost(model, u), now)
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack lets WebSocket handlers take over the connection.
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// usageMiddleware counts every request, server error and its latency.
+func usageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		usage.recordRequest(time.Now(), rec.status, time.Since(start))
+	})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/sashabaranov/go-openai"
+)
+
+// VisionConfig controls chats with images.
+type VisionConfig struct {
+	Model         string   `mapstructure:"model" doc:"Model chats with images use when they don't name one"`
+	Models        []string `mapstructure:"models" doc:"Models that accept images; chats with images and another model are refused"`
+	MaxImageBytes int64    `mapstructure:"max_image_bytes" doc:"Largest stored image a chat may attach"`
+}
+
+func (c VisionConfig) Validate() error {
+	if c.MaxImageBytes <= 0 {
+		return errors.New("vision.max_image_bytes must be positive")
+	}
+	if c.Model != "" && !slices.Contains(c.Models, c.Model) {
+		return fmt.Errorf("vision.model: %s is not in vision.models", c.Model)
+	}
+	return nil
+}
+
+// visionContentTypes are the image formats the vision models accept.
+var visionContentTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+type ChatImage struct {
+	URL    string `json:"url,omitempty" maxLength:"2048" doc:"Public http or https URL of the image, fetched by the model provider"`
+	Bucket string `json:"bucket,omitempty" doc:"Bucket of a stored image; the caller must be allowed to read it"`
+	Name   string `json:"name,omitempty" doc:"Object name of a stored image"`
+	Detail string `json:"detail,omitempty" enum:"low,high,auto" doc:"Resolution the model looks at the image in; defaults to auto"`
+}
+
+// chatModel returns the model requested for req, which is the vision model
+// for chats with images that don't name one.
+func chatModel(req ChatRequest) string {
+	if req.Model == "" && len(req.Images) > 0 {
+		return config.Vision.Model
+	}
+	return req.Model
+}
+
+// attachImages checks the images of req and resolves them to message
+// parts: URLs are passed on, stored images are read for caller and sent
+// inline.
+func attachImages(ctx context.Context, req *ChatRequest, model string, caller aclCaller) error {
+	if len(req.Images) == 0 {
+		return nil
+	}
+	if !slices.Contains(config.Vision.Models, model) {
+		return huma.Error422UnprocessableEntity(fmt.Sprintf("Model %s does not accept images; use one of %s", model, strings.Join(config.Vision.Models, ", ")))
+	}
+	if req.Message == "" && len(req.History) > 0 && req.History[len(req.History)-1].Role == openai.ChatMessageRoleFunction {
+		return huma.Error422UnprocessableEntity("Images need a message to go with")
+	}
+
+	parts := make([]openai.ChatMessagePart, 0, len(req.Images))
+	for i, img := range req.Images {
+		var (
+			imageURL string
+			err      error
+		)
+		switch {
+		case img.URL != "" && img.Bucket == "" && img.Name == "":
+			if u, perr := url.Parse(img.URL); perr != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return huma.Error422UnprocessableEntity(fmt.Sprintf("images[%d].url must be an http or https URL", i))
+			}
+			imageURL = img.URL
+		case img.URL == "" && img.Bucket != "" && img.Name != "":
+			if imageURL, err = storedImageURL(ctx, img.Bucket, img.Name, caller); err != nil {
+				return err
+			}
+		default:
+			return huma.Error422UnprocessableEntity(fmt.Sprintf("images[%d] needs either a url, or a bucket and a name", i))
+		}
+		parts = append(parts, openai.ChatMessagePart{
+			Type:     openai.ChatMessagePartTypeImageURL,
+			ImageURL: &openai.ChatMessageImageURL{URL: imageURL, Detail: openai.ImageURLDetail(img.Detail)},
+		})
+	}
+	req.imageParts = parts
+	return nil
+}
+
+// storedImageURL reads a stored image as a data URL, checking its ACL as
+// GET /files does.
+func storedImageURL(ctx context.Context, bucket, name string, caller aclCaller) (string, error) {
+	client, err := services.MinIO()
+	if err != nil {
+		return "", err
+	}
+	acls, err := loadACLs(ctx, bucket)
+	if err != nil {
+		return "", huma.Error500InternalServerError("Failed to read the object's ACL", err)
+	}
+	if acl, ok := acls[name]; ok {
+		if err := checkACL(acl, caller, bucket, name); err != nil {
+			return "", err
+		}
+	}
+
+	obj, _, err := openObject(ctx, client, bucket, name, "")
+	if err != nil {
+		if isNotFound(err) {
+			return "", errObjectNotFound(bucket, name)
+		}
+		return "", huma.Error500InternalServerError("Failed to get object", err)
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(io.LimitReader(obj, config.Vision.MaxImageBytes+1))
+	if err != nil {
+		return "", huma.Error500InternalServerError("Failed to read object", err)
+	}
+	if int64(len(data)) > config.Vision.MaxImageBytes {
+		return "", huma.Error422UnprocessableEntity(fmt.Sprintf("Image %s is larger than %d bytes", name, config.Vision.MaxImageBytes))
+	}
+	// The stored content type may be anything the uploader sent, so the
+	// format is told from the content
+	contentType := http.DetectContentType(data)
+	if !slices.Contains(visionContentTypes, contentType) {
+		return "", huma.Error422UnprocessableEntity(fmt.Sprintf("Object %s is not a PNG, JPEG, GIF or WebP image", name))
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// userMessage is the new message of req, with its images if it has any.
+func userMessage(req ChatRequest) openai.ChatCompletionMessage {
+	if len(req.imageParts) == 0 {
+		return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: req.Message}
+	}
+	parts := make([]openai.ChatMessagePart, 0, len(req.imageParts)+1)
+	if req.Message != "" {
+		parts = append(parts, openai.ChatMessagePart{Type: openai.ChatMessagePartTypeText, Text: req.Message})
+	}
+	return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, MultiContent: append(parts, req.imageParts...)}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/sashabaranov/go-openai"
+	"github.com/spf13/viper"
+)
+
+// recordingProvider answers every chat and keeps the last request.
+type recordingProvider struct {
+	chatOnlyProvider
+	last openai.ChatCompletionRequest
+}
+
+func (p *recordingProvider) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	p.last = req
+	return openai.ChatCompletionResponse{Model: req.Model, Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "A cat"}}}}, nil
+}
+
+func (p *recordingProvider) Moderations(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error) {
+	return openai.ModerationResponse{Results: []openai.Result{{}}}, nil
+}
+
+func TestChatWithImages(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.Storage.Tenants = []StorageTenantConfig{{Name: "acme", Token: "acme-token", Bucket: "tenants"}}
+	defer func() { config.Storage.Tenants = nil }()
+	provider := &recordingProvider{}
+	config.ChatProvider.Name = "recording"
+	chatProviders["recording"] = func() (ChatProvider, error) { return provider, nil }
+	defer delete(chatProviders, "recording")
+
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	objects := map[string]string{
+		"photos/cat.png":              png,
+		"photos/notes.txt":            "not an image",
+		"photos/secret.png":           png,
+		"app-system/acls/photos.json": `{"secret.png": {"visibility": "private", "owner": "globex"}}`,
+	}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerChatEndpoint(api)
+	chat := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer acme-token")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := chat(`{"message": "What is this?", "images": [{"bucket": "photos", "name": "cat.png", "detail": "low"}, {"url": "https://example.com/dog.jpg"}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the chat to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if provider.last.Model != "gpt-4o" {
+		t.Errorf("Expected the vision model by default, got %s", provider.last.Model)
+	}
+	parts := provider.last.Messages[len(provider.last.Messages)-1].MultiContent
+	if len(parts) != 3 || parts[0].Text != "What is this?" {
+		t.Fatalf("Expected the text and two images, got %+v", parts)
+	}
+	if want := "data:image/png;base64," + base64.StdEncoding.EncodeToString([]byte(png)); parts[1].ImageURL.URL != want || parts[1].ImageURL.Detail != openai.ImageURLDetailLow {
+		t.Errorf("Expected the stored image inline, got %+v", parts[1].ImageURL)
+	}
+	if parts[2].ImageURL.URL != "https://example.com/dog.jpg" {
+		t.Errorf("Expected the URL to be passed on, got %+v", parts[2].ImageURL)
+	}
+
+	for body, want := range map[string]int{
+		`{"message": "Hi", "model": "gpt-3.5-turbo", "images": [{"url": "https://example.com/dog.jpg"}]}`:              http.StatusUnprocessableEntity,
+		`{"message": "Hi", "images": [{"url": "https://example.com/dog.jpg", "bucket": "photos", "name": "cat.png"}]}`: http.StatusUnprocessableEntity,
+		`{"message": "Hi", "images": [{"url": "file:///etc/passwd"}]}`:                                                 http.StatusUnprocessableEntity,
+		`{"message": "Hi", "images": [{"bucket": "photos", "name": "notes.txt"}]}`:                                     http.StatusUnprocessableEntity,
+		`{"message": "Hi", "images": [{"bucket": "photos", "name": "missing.png"}]}`:                                   http.StatusNotFound,
+		`{"message": "Hi", "images": [{"bucket": "photos", "name": "secret.png"}]}`:                                    http.StatusForbidden,
+	} {
+		if w := chat(body); w.Code != want {
+			t.Errorf("Expected %d for %s, got %d: %s", want, body, w.Code, w.Body.String())
+		}
+	}
+}
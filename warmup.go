@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/sashabaranov/go-openai"
+)
+
+// WarmupConfig controls warming up connections and caches before an
+// instance reports itself ready.
+type WarmupConfig struct {
+	OnStart        bool `mapstructure:"on_start" doc:"Warm up when the server starts; GET /readyz fails until it completes"`
+	TimeoutSeconds int  `mapstructure:"timeout_seconds" doc:"Longest a warm-up may take"`
+}
+
+func (c WarmupConfig) Validate() error {
+	if c.TimeoutSeconds <= 0 {
+		return errors.New("warmup.timeout_seconds must be positive")
+	}
+	return nil
+}
+
+// warmupReuse is how long a completed warm-up is returned to further
+// POST /warmup calls instead of running again, so that a gateway calling it
+// on every instance check does not add load.
+const warmupReuse = 30 * time.Second
+
+type WarmupStep struct {
+	Name       string `json:"name" enum:"chat_provider,minio,templates,style_guide,tenant_policies,chat_cache" doc:"What was warmed up"`
+	Status     string `json:"status" enum:"ok,skipped,failed" doc:"skipped when the service is not configured"`
+	DurationMS int64  `json:"duration_ms" doc:"How long the step took"`
+	Detail     string `json:"detail,omitempty" doc:"What was loaded, why the step was skipped, or the error"`
+}
+
+type WarmupResponse struct {
+	StartedAt   time.Time    `json:"started_at" doc:"When the warm-up started"`
+	CompletedAt time.Time    `json:"completed_at" doc:"When the warm-up completed"`
+	Steps       []WarmupStep `json:"steps" doc:"Each step, in the order run"`
+}
+
+// warmupProvider is implemented by chat providers that can open their
+// connection ahead of the first chat.
+type warmupProvider interface {
+	Warmup(ctx context.Context) error
+}
+
+// errWarmupSkipped marks a step whose service is not configured.
+type errWarmupSkipped string
+
+func (e errWarmupSkipped) Error() string { return string(e) }
+
+// warmupSteps are run in order; each returns a short description of what
+// it loaded.
+var warmupSteps = []struct {
+	name string
+	run  func(ctx context.Context) (string, error)
+}{
+	{"chat_provider", warmChatProvider},
+	{"minio", warmMinIO},
+	{"templates", warmTemplates},
+	{"style_guide", warmStyleGuide},
+	{"tenant_policies", warmTenantPolicies},
+	{"chat_cache", warmChatCache},
+}
+
+func warmChatProvider(ctx context.Context) (string, error) {
+	provider, err := chatProvider(ctx)
+	if err != nil {
+		return "", errWarmupSkipped(err.Error())
+	}
+	switch p := provider.(type) {
+	case *openai.Client:
+		// Listing the models opens the connection and fills the model list
+		models, _, err := openAIModels(ctx, p)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d models", len(models)), nil
+	case warmupProvider:
+		return "", p.Warmup(ctx)
+	}
+	return "", errWarmupSkipped("the provider has no connection to warm up")
+}
+
+func warmMinIO(ctx context.Context) (string, error) {
+	client, err := services.MinIO()
+	if err != nil {
+		return "", errWarmupSkipped("MinIO is not configured")
+	}
+	if _, err := client.BucketExists(ctx, config.MinIO.SystemBucket); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+func warmTemplates(ctx context.Context) (string, error) {
+	if _, err := services.MinIO(); err != nil {
+		return "", errWarmupSkipped("MinIO is not configured")
+	}
+	templates, err := listGalleryItems[PromptTemplate](ctx, templatesPrefix)
+	if err != nil {
+		return "", err
+	}
+	for _, t := range templates {
+		cacheTemplate(t)
+	}
+	return fmt.Sprintf("%d templates", len(templates)), nil
+}
+
+func warmStyleGuide(ctx context.Context) (string, error) {
+	if !services.Status().MinIO || config.OpenAI.StyleGuideBucket == "" || len(config.OpenAI.StyleGuideObjects) == 0 {
+		return "", errWarmupSkipped("no style guide is configured")
+	}
+	if _, err := styleGuidePrompt(ctx); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d documents", len(config.OpenAI.StyleGuideObjects)), nil
+}
+
+func warmTenantPolicies(ctx context.Context) (string, error) {
+	if _, err := services.MinIO(); err != nil {
+		return "", errWarmupSkipped("MinIO is not configured")
+	}
+	if len(config.Storage.Tenants) == 0 {
+		return "", errWarmupSkipped("no tenants are configured")
+	}
+	for _, t := range config.Storage.Tenants {
+		if _, err := loadTenantPolicy(ctx, t.Name); err != nil {
+			return "", fmt.Errorf("tenant %s: %w", t.Name, err)
+		}
+	}
+	return fmt.Sprintf("%d tenants", len(config.Storage.Tenants)), nil
+}
+
+func warmChatCache(ctx context.Context) (string, error) {
+	cc := chatCache.Load()
+	if cc == nil {
+		return "", errWarmupSkipped("the chat cache is disabled")
+	}
+	rc, ok := cc.backend.(*redisCache)
+	if !ok {
+		return "", errWarmupSkipped("the chat cache is in memory")
+	}
+	return "", rc.client.Ping(ctx).Err()
+}
+
+// warmupState coalesces concurrent warm-ups and remembers the last one.
+var warmupState struct {
+	sync.Mutex
+	running chan struct{}
+	last    *WarmupResponse
+}
+
+// runWarmup runs the warm-up steps, or waits for the one in progress, and
+// returns its result. A warm-up completed within warmupReuse is returned as
+// is.
+func runWarmup(ctx context.Context) (*WarmupResponse, error) {
+	warmupState.Lock()
+	if last := warmupState.last; last != nil && time.Since(last.CompletedAt) < warmupReuse {
+		warmupState.Unlock()
+		return last, nil
+	}
+	running := warmupState.running
+	if running == nil {
+		running = make(chan struct{})
+		warmupState.running = running
+		// The warm-up outlives a caller that gives up waiting for it
+		go warmup(running)
+	}
+	warmupState.Unlock()
+
+	select {
+	case <-running:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	warmupState.Lock()
+	defer warmupState.Unlock()
+	return warmupState.last, nil
+}
+
+func warmup(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Warmup.TimeoutSeconds)*time.Second)
+	defer cancel()
+
+	resp := &WarmupResponse{StartedAt: time.Now(), Steps: make([]WarmupStep, 0, len(warmupSteps))}
+	for _, step := range warmupSteps {
+		start := time.Now()
+		detail, err := step.run(ctx)
+		s := WarmupStep{Name: step.name, Status: "ok", Detail: detail, DurationMS: time.Since(start).Milliseconds()}
+		var skipped errWarmupSkipped
+		switch {
+		case errors.As(err, &skipped):
+			s.Status, s.Detail = "skipped", skipped.Error()
+		case err != nil:
+			s.Status, s.Detail = "failed", err.Error()
+			log.Printf("Warm-up of %s failed: %v", step.name, err)
+		}
+		resp.Steps = append(resp.Steps, s)
+	}
+	resp.CompletedAt = time.Now()
+
+	warmupState.Lock()
+	warmupState.last = resp
+	warmupState.running = nil
+	warmupState.Unlock()
+	close(done)
+}
+
+// warmedUp reports whether the instance is ready to serve: a warm-up has
+// completed, or none is run at startup.
+func warmedUp() bool {
+	if !config.Warmup.OnStart {
+		return true
+	}
+	warmupState.Lock()
+	defer warmupState.Unlock()
+	return warmupState.last != nil
+}
+
+type LivenessResponse struct {
+	Status string `json:"status" enum:"alive" doc:"Always alive; the process answers requests"`
+}
+
+type ReadinessResponse struct {
+	Status string          `json:"status" enum:"ready" doc:"Always ready; an instance that is not ready answers 503"`
+	Warmup *WarmupResponse `json:"warmup,omitempty" doc:"The last warm-up, once one has run"`
+}
+
+func registerWarmupEndpoints(api huma.API) {
+	huma.Register(api, huma.Operation{
+		OperationID: "warmup",
+		Method:      http.MethodPost,
+		Path:        "/warmup",
+		Summary:     "Warm up connections and caches",
+		Description: "Open the chat provider and storage connections, load the prompt templates and fill the style guide and tenant policy caches. Concurrent calls share one warm-up, and one that completed in the last 30 seconds is returned without running again.",
+	}, func(ctx context.Context, input *struct{}) (*struct {
+		Body *WarmupResponse
+	}, error) {
+		resp, err := runWarmup(ctx)
+		if err != nil {
+			return nil, huma.Error503ServiceUnavailable("The warm-up did not complete in time", err)
+		}
+		return &struct {
+			Body *WarmupResponse
+		}{Body: resp}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "readyz",
+		Method:      http.MethodGet,
+		Path:        "/readyz",
+		Summary:     "Readiness check",
+		Description: "Answer 200 once the instance has warmed up and 503 before, for load balancers and API gateways to route traffic on",
+	}, func(ctx context.Context, input *struct{}) (*struct {
+		Body ReadinessResponse
+	}, error) {
+		if !warmedUp() {
+			return nil, huma.Error503ServiceUnavailable("The instance is warming up")
+		}
+		warmupState.Lock()
+		last := warmupState.last
+		warmupState.Unlock()
+		return &struct {
+			Body ReadinessResponse
+		}{Body: ReadinessResponse{Status: "ready", Warmup: last}}, nil
+	})
+
+	huma.Register(api, huma.Operation{
+		OperationID: "livez",
+		Method:      http.MethodGet,
+		Path:        "/livez",
+		Summary:     "Liveness check",
+		Description: "Answer 200 while the process is running, without checking any dependency",
+	}, func(ctx context.Context, input *struct{}) (*struct {
+		Body LivenessResponse
+	}, error) {
+		return &struct {
+			Body LivenessResponse
+		}{Body: LivenessResponse{Status: "alive"}}, nil
+	})
+}
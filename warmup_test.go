@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/danielgtaylor/huma/v2/adapters/humachi"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/viper"
+)
+
+func TestWarmupEndpoints(t *testing.T) {
+	viper.Reset()
+	initConfig()
+	config.ChatProvider.Name = "echo"
+	chatProviders["echo"] = func() (ChatProvider, error) { return &echoProvider{}, nil }
+	defer delete(chatProviders, "echo")
+	config.Storage.Tenants = []StorageTenantConfig{{Name: "acme", Token: "acme-token", Bucket: "tenants"}}
+	defer func() { config.Storage.Tenants = nil }()
+	warmupState.last = nil
+	defer func() { warmupState.last = nil }()
+
+	objects := map[string]string{
+		"app-system/templates/greet.json": `{"name": "greet", "prompt": "Say hello to {{name}}"}`,
+	}
+	s3 := httptest.NewServer(fakeS3(objects))
+	defer s3.Close()
+	config.MinIO.URL = strings.TrimPrefix(s3.URL, "http://")
+	client, err := newMinIOClient(minioCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetMinIO(client)
+	defer services.SetMinIO(nil)
+	defer uncacheTemplate("greet")
+
+	router := chi.NewMux()
+	api := humachi.New(router, huma.DefaultConfig("Test API", "1.0.0"))
+	registerWarmupEndpoints(api)
+	call := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	if w := call(http.MethodGet, "/livez"); w.Code != http.StatusOK {
+		t.Errorf("Expected the instance to be alive, got %d", w.Code)
+	}
+	if w := call(http.MethodGet, "/readyz"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the instance not to be ready before warming up, got %d", w.Code)
+	}
+
+	w := call(http.MethodPost, "/warmup")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the warm-up to complete, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, want := range []string{
+		`{"name":"chat_provider","status":"skipped"`,
+		`{"name":"minio","status":"ok"`,
+		`"name":"templates","status":"ok"`, `"detail":"1 templates"`,
+		`{"name":"style_guide","status":"skipped"`,
+		`"name":"tenant_policies","status":"ok"`,
+		`{"name":"chat_cache","status":"skipped"`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("Expected %s in %s", want, w.Body.String())
+		}
+	}
+	if w := call(http.MethodGet, "/readyz"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"ready"`) {
+		t.Errorf("Expected the instance to be ready after warming up, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// The template was loaded, so chats no longer read it from storage
+	delete(objects, "app-system/templates/greet.json")
+	if tmpl, err := loadTemplate(context.Background(), "greet"); err != nil || tmpl.Prompt != "Say hello to {{name}}" {
+		t.Errorf("Expected the warmed-up template, got %+v, %v", tmpl, err)
+	}
+
+	// A recent warm-up is reused
+	first := warmupState.last
+	if resp, err := runWarmup(context.Background()); err != nil || resp != first {
+		t.Errorf("Expected the recent warm-up to be reused, got %v", err)
+	}
+
+	config.Warmup.OnStart = false
+	defer func() { config.Warmup.OnStart = true }()
+	warmupState.last = nil
+	if w := call(http.MethodGet, "/readyz"); w.Code != http.StatusOK {
+		t.Errorf("Expected the instance to be ready without a warm-up on start, got %d", w.Code)
+	}
+}